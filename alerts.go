@@ -0,0 +1,446 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of the events alert rules send to their webhook channels.
+const (
+	HookAlertFiring   = "alert.firing"
+	HookAlertResolved = "alert.resolved"
+)
+
+// maxAlertDays is the longest window of an alert rule.
+const maxAlertDays = 31
+
+// AlertCondition is what an alert rule checks its measure against.
+type AlertCondition string
+
+const (
+	// AlertDrop and AlertRise fire when the measure changed by at least
+	// Threshold percent from the window before.
+	AlertDrop AlertCondition = "drop"
+	AlertRise AlertCondition = "rise"
+	// AlertBelow and AlertAbove fire when the measure is below or above
+	// Threshold.
+	AlertBelow AlertCondition = "below"
+	AlertAbove AlertCondition = "above"
+)
+
+// AlertChannel is where an alert is delivered: a webhook URL, sent the
+// alert as a HookEvent, or an email address.
+type AlertChannel struct {
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+// AlertRule is a condition on a site's traffic, checked once a day over the
+// last Days complete UTC days. It measures either Metric, narrowed by Extra
+// like a report, the count of its Value when set or its total otherwise, or
+// the visitors converting on Goal. Channels are alerted when the rule starts firing and when it
+// resolves.
+type AlertRule struct {
+	ID        string         `json:"id"`
+	SiteID    string         `json:"siteId"`
+	Name      string         `json:"name"`
+	Metric    QueryType      `json:"metric"`
+	Value     string         `json:"value,omitempty"`
+	Extra     string         `json:"extra,omitempty"`
+	Goal      *FunnelStep    `json:"goal,omitempty"`
+	Condition AlertCondition `json:"condition"`
+	Threshold float64        `json:"threshold"`
+	Days      int            `json:"days,omitempty"`
+	Channels  []AlertChannel `json:"channels"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+
+	// Firing, CheckedDay and Measured are the state of the rule as of its
+	// last check, kept across restarts so alerts aren't sent twice.
+	Firing     bool     `json:"firing"`
+	CheckedDay uint32   `json:"checkedDay,omitempty"`
+	Measured   *float64 `json:"measured,omitempty"`
+}
+
+func (r AlertRule) validate() error {
+	if r.SiteID == "" || r.Name == "" {
+		return fmt.Errorf("%w: siteId and name are required", ErrInvalid)
+	}
+	if r.Goal != nil {
+		if r.Goal.Event == "" {
+			return fmt.Errorf("%w: the goal needs an event", ErrInvalid)
+		}
+	} else if def, ok := metricDefs[r.Metric]; !ok {
+		return fmt.Errorf("%w: unknown metric %s", ErrInvalid, r.Metric)
+	} else if def.session.averaged() {
+		return fmt.Errorf("%w: %s can't be added up over days", ErrInvalid, r.Metric)
+	}
+	switch r.Condition {
+	case AlertDrop, AlertRise, AlertBelow, AlertAbove:
+	default:
+		return fmt.Errorf("%w: condition must be drop, rise, below or above", ErrInvalid)
+	}
+	if r.Threshold < 0 {
+		return fmt.Errorf("%w: threshold can't be negative", ErrInvalid)
+	}
+	if r.Days < 0 || r.Days > maxAlertDays {
+		return fmt.Errorf("%w: days must be between 1 and %d", ErrInvalid, maxAlertDays)
+	}
+	if len(r.Channels) == 0 {
+		return fmt.Errorf("%w: at least one channel is required", ErrInvalid)
+	}
+	for _, c := range r.Channels {
+		switch c.Type {
+		case "webhook":
+			if u, err := url.Parse(c.Target); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("%w: webhook channels need an http or https URL", ErrInvalid)
+			}
+		case "email":
+			if config.SMTPAddr == "" {
+				return fmt.Errorf("%w: email channels need SMTP_ADDR", ErrInvalid)
+			}
+			if addr, err := mail.ParseAddress(c.Target); err != nil || addr.Name != "" {
+				return fmt.Errorf("%w: email channels need an email address", ErrInvalid)
+			}
+		default:
+			return fmt.Errorf("%w: channel type must be webhook or email", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// days returns the length of the rule's window, a day unless set.
+func (r AlertRule) days() int {
+	if r.Days == 0 {
+		return 1
+	}
+	return r.Days
+}
+
+// fires reports whether the rule fires for value measured over its window,
+// previous over the window before.
+func (r AlertRule) fires(value, previous float64) bool {
+	switch r.Condition {
+	case AlertDrop:
+		return previous > 0 && (previous-value)/previous*100 >= r.Threshold
+	case AlertRise:
+		return value > previous && (previous == 0 || (value-previous)/previous*100 >= r.Threshold)
+	case AlertBelow:
+		return value < r.Threshold
+	case AlertAbove:
+		return value > r.Threshold
+	}
+	return false
+}
+
+// Alert is the data of alert.firing and alert.resolved events: the rule
+// and what it measured over the window from Start to End.
+type Alert struct {
+	RuleID    string         `json:"ruleId"`
+	Name      string         `json:"name"`
+	Condition AlertCondition `json:"condition"`
+	Threshold float64        `json:"threshold"`
+	Start     uint32         `json:"start"`
+	End       uint32         `json:"end"`
+	Value     float64        `json:"value"`
+	Previous  float64        `json:"previous"`
+}
+
+// Rules returns the alert rules of a site ordered by name.
+func (s *Saved) Rules(siteID string) []AlertRule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := []AlertRule{}
+	for _, rule := range s.rules {
+		if rule.SiteID == siteID {
+			list = append(list, rule)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *Saved) Rule(id string) (AlertRule, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rule, ok := s.rules[id]
+	return rule, ok
+}
+
+// PutRule creates rule when its ID is empty and replaces the rule with that
+// ID otherwise. A replaced rule starts over, unchecked and not firing.
+func (s *Saved) PutRule(rule AlertRule) (AlertRule, error) {
+	if err := rule.validate(); err != nil {
+		return AlertRule{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := clock.Now().UTC()
+	prev, exists := s.rules[rule.ID]
+	switch {
+	case rule.ID == "":
+		rule.ID = newSavedID()
+		rule.CreatedAt = now
+	case !exists:
+		return AlertRule{}, ErrNotFound
+	default:
+		rule.SiteID = prev.SiteID
+		rule.CreatedAt = prev.CreatedAt
+	}
+	rule.UpdatedAt = now
+	rule.Firing, rule.CheckedDay, rule.Measured = false, 0, nil
+
+	s.rules[rule.ID] = rule
+	if err := s.save(); err != nil {
+		if exists {
+			s.rules[rule.ID] = prev
+		} else {
+			delete(s.rules, rule.ID)
+		}
+		return AlertRule{}, err
+	}
+	return rule, nil
+}
+
+func (s *Saved) DeleteRule(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rule, ok := s.rules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.rules, id)
+	if err := s.save(); err != nil {
+		s.rules[id] = rule
+		return err
+	}
+	return nil
+}
+
+// allRules returns every alert rule ordered by ID.
+func (s *Saved) allRules() []AlertRule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.file().Rules
+}
+
+// checkedRule records the state of a rule after its check of day, unless
+// the rule was replaced or deleted meanwhile.
+func (s *Saved) checkedRule(checked AlertRule, day uint32, firing bool, measured float64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prev, ok := s.rules[checked.ID]
+	if !ok || !prev.UpdatedAt.Equal(checked.UpdatedAt) {
+		return nil
+	}
+	rule := prev
+	rule.Firing, rule.CheckedDay, rule.Measured = firing, day, &measured
+	s.rules[rule.ID] = rule
+	if err := s.save(); err != nil {
+		s.rules[rule.ID] = prev
+		return err
+	}
+	return nil
+}
+
+// AlertEngine checks the alert rules of saved once a day, on the days that
+// just ended, and alerts their channels when they start firing or resolve.
+// Rules checked for a day aren't checked again for it, by this or another
+// instance sharing SAVED_FILE once it was reloaded. A rule that can't be
+// measured is tried again at the next tick.
+type AlertEngine struct {
+	saved   *Saved
+	store   EventStore
+	funnels FunnelStore
+	sender  hookSender
+	mail    func(to string, msg []byte) error
+	log     *slog.Logger
+}
+
+// NewAlertEngine returns an engine measuring rules on store, and goals on
+// funnels, which may be nil when the store has no funnels. Webhooks are only
+// sent to public addresses.
+func NewAlertEngine(saved *Saved, store EventStore, funnels FunnelStore) *AlertEngine {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return errors.New("alerts don't follow redirects")
+		},
+	}
+	return &AlertEngine{
+		saved:   saved,
+		store:   store,
+		funnels: funnels,
+		sender:  newHookSender(client),
+		mail:    sendMail,
+		log:     slog.Default().With(slog.String("component", "AlertEngine")),
+	}
+}
+
+// Run checks due rules every interval until ctx is done.
+func (a *AlertEngine) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.checkDue(ctx)
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDue checks the rules not checked for yesterday yet and returns how
+// many alerts it sent.
+func (a *AlertEngine) checkDue(ctx context.Context) int {
+	day := AddDays(TimeToInt(clock.Now().UTC()), -1)
+	sent := 0
+	for _, rule := range a.saved.allRules() {
+		if rule.CheckedDay >= day {
+			continue
+		}
+		n, err := a.check(ctx, rule, day)
+		if err != nil {
+			a.log.Error("Failed to check alert rule", slog.String("rule", rule.ID), slog.Any("error", err))
+		}
+		sent += n
+	}
+	return sent
+}
+
+// check measures rule over the window ending with day, alerts its channels
+// when it starts firing or resolves and returns how many it alerted.
+func (a *AlertEngine) check(ctx context.Context, rule AlertRule, day uint32) (int, error) {
+	n := rule.days()
+	start := AddDays(day, -n+1)
+	value, err := a.measure(ctx, rule, start, day)
+	if err != nil {
+		return 0, err
+	}
+	var previous float64
+	if rule.Condition == AlertDrop || rule.Condition == AlertRise {
+		if previous, err = a.measure(ctx, rule, AddDays(start, -n), AddDays(start, -1)); err != nil {
+			return 0, err
+		}
+	}
+
+	firing := rule.fires(value, previous)
+	if err := a.saved.checkedRule(rule, day, firing, value); err != nil {
+		return 0, err
+	}
+	if firing == rule.Firing {
+		return 0, nil
+	}
+
+	typ := HookAlertResolved
+	if firing {
+		typ = HookAlertFiring
+	}
+	alert := Alert{RuleID: rule.ID, Name: rule.Name, Condition: rule.Condition, Threshold: rule.Threshold, Start: start, End: day, Value: value, Previous: previous}
+	ev := HookEvent{
+		ID:     fmt.Sprintf("%s/%d/%s", rule.ID, day, typ),
+		Type:   typ,
+		At:     clock.Now().UTC(),
+		SiteID: rule.SiteID,
+		Data:   alert,
+	}
+	sent := 0
+	for _, c := range rule.Channels {
+		var err error
+		if c.Type == "email" {
+			err = a.mail(c.Target, alertMail(c.Target, rule, typ, alert))
+		} else {
+			err = a.sender.send(c.Target, ev)
+		}
+		if err != nil {
+			a.log.Error("Failed to deliver alert", slog.String("rule", rule.ID), slog.String("channel", c.Type), slog.Any("error", err))
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// measure returns what rule measures between start and end.
+func (a *AlertEngine) measure(ctx context.Context, rule AlertRule, start, end uint32) (float64, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if rule.Goal != nil {
+		if a.funnels == nil {
+			return 0, errors.New("the store doesn't compute goals")
+		}
+		result, err := a.funnels.Funnel(queryCtx, FunnelQuery{SiteID: rule.SiteID, Start: start, End: end, Steps: []FunnelStep{*rule.Goal}})
+		if err != nil {
+			return 0, err
+		}
+		return float64(result.Data[0].Visitors), nil
+	}
+
+	result, err := a.store.GetStats(queryCtx, MetricData{What: rule.Metric, SiteID: rule.SiteID, Start: start, End: end, Extra: rule.Extra})
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, m := range result.Data {
+		if rule.Value == "" || m.Value == rule.Value {
+			total += m.Count
+		}
+	}
+	return float64(total), nil
+}
+
+// alertMail returns the message alerting to of rule.
+func alertMail(to string, rule AlertRule, typ string, alert Alert) []byte {
+	state := "firing"
+	if typ == HookAlertResolved {
+		state = "resolved"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", config.SMTPFrom)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: [%s] %s on %s\r\n", state, sanitizeField(rule.Name, maxEventLen), sanitizeField(rule.SiteID, maxSiteIDLen))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "Alert rule %q of site %s is %s.\r\n\r\n", rule.Name, rule.SiteID, state)
+	fmt.Fprintf(&b, "Measured %g from %d to %d", alert.Value, alert.Start, alert.End)
+	if rule.Condition == AlertDrop || rule.Condition == AlertRise {
+		fmt.Fprintf(&b, ", %g the %d days before", alert.Previous, rule.days())
+	}
+	fmt.Fprintf(&b, ".\r\nCondition: %s %g", rule.Condition, rule.Threshold)
+	if rule.Condition == AlertDrop || rule.Condition == AlertRise {
+		b.WriteString("%")
+	}
+	b.WriteString(".\r\n")
+	return []byte(b.String())
+}
+
+// sendMail sends msg to to through SMTP_ADDR.
+func sendMail(to string, msg []byte) error {
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(config.SMTPAddr)
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+	}
+	return smtp.SendMail(config.SMTPAddr, auth, config.SMTPFrom, []string{to}, msg)
+}
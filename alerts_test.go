@@ -0,0 +1,160 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestAlertEngine(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 11, 0, 5, 0, 0, time.UTC))
+	defer SetClock(fc)()
+	defer func(addr string) { config.SMTPAddr = addr }(config.SMTPAddr)
+	config.SMTPAddr = "localhost:25"
+
+	received := make(chan HookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev HookEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		received <- ev
+	}))
+	defer srv.Close()
+
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	view := func(day uint32, n int) {
+		for i := range n {
+			trk := Tracking{SiteID: "shop", Action: TrackingData{Identity: fmt.Sprint(day, i), Event: "/", Category: PageviewCategory, OccuredAt: day}}
+			if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	view(20240309, 10)
+	view(20240310, 4)
+
+	saved := &Saved{}
+	saved.Load("")
+	rule, err := saved.PutRule(AlertRule{
+		SiteID:    "shop",
+		Name:      "Traffic drop",
+		Metric:    QueryPageViews,
+		Condition: AlertDrop,
+		Threshold: 50,
+		Channels:  []AlertChannel{{Type: "webhook", Target: srv.URL}, {Type: "email", Target: "ops@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mails []string
+	a := NewAlertEngine(saved, m, nil)
+	a.sender.client = srv.Client()
+	a.mail = func(to string, msg []byte) error {
+		mails = append(mails, to+"\n"+string(msg))
+		return nil
+	}
+
+	// 10 page views down to 4 is a drop of 60%
+	if n := a.checkDue(ctx); n != 2 {
+		t.Fatalf("alerted %d channels on the drop", n)
+	}
+	ev := <-received
+	var alert Alert
+	data, _ := json.Marshal(ev.Data)
+	json.Unmarshal(data, &alert)
+	if ev.Type != HookAlertFiring || ev.ID != rule.ID+"/20240310/"+HookAlertFiring || alert.Value != 4 || alert.Previous != 10 {
+		t.Errorf("got %+v with %+v", ev, alert)
+	}
+	if len(mails) != 1 || !strings.Contains(mails[0], "Subject: [firing] Traffic drop on shop") {
+		t.Errorf("got mails %q", mails)
+	}
+	if got, _ := saved.Rule(rule.ID); !got.Firing || got.CheckedDay != 20240310 || *got.Measured != 4 {
+		t.Errorf("state after firing: got %+v", got)
+	}
+
+	// Checked for the day, and still firing the next
+	if n := a.checkDue(ctx); n != 0 {
+		t.Errorf("alerted %d channels again the same day", n)
+	}
+	view(20240311, 1)
+	fc.Advance(24 * time.Hour)
+	if n := a.checkDue(ctx); n != 0 {
+		t.Errorf("alerted %d channels while still firing", n)
+	}
+
+	// Recovering resolves the alert
+	view(20240312, 8)
+	fc.Advance(24 * time.Hour)
+	if n := a.checkDue(ctx); n != 2 {
+		t.Fatalf("alerted %d channels on resolving", n)
+	}
+	if ev := <-received; ev.Type != HookAlertResolved {
+		t.Errorf("got %+v", ev)
+	}
+	if got, _ := saved.Rule(rule.ID); got.Firing || got.CheckedDay != 20240312 {
+		t.Errorf("state after resolving: got %+v", got)
+	}
+
+	// Goals need a store with funnels
+	goal, _ := saved.PutRule(AlertRule{SiteID: "shop", Name: "No signups", Goal: &FunnelStep{Event: "Signup"}, Condition: AlertBelow, Threshold: 1, Channels: []AlertChannel{{Type: "webhook", Target: srv.URL}}})
+	if _, err := a.check(ctx, goal, 20240312); err == nil {
+		t.Error("checked a goal without funnels")
+	}
+}
+
+func TestAlertRuleValidation(t *testing.T) {
+	base := AlertRule{SiteID: "shop", Name: "r", Metric: QueryPageViews, Condition: AlertAbove, Threshold: 100, Channels: []AlertChannel{{Type: "webhook", Target: "https://example.com/hook"}}}
+	if err := base.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, change := range map[string]func(*AlertRule){
+		"unknown metric":    func(r *AlertRule) { r.Metric = QueryType(-1) },
+		"averaged metric":   func(r *AlertRule) { r.Metric = QueryBounceRate },
+		"unknown condition": func(r *AlertRule) { r.Condition = "changed" },
+		"too many days":     func(r *AlertRule) { r.Days = maxAlertDays + 1 },
+		"no channels":       func(r *AlertRule) { r.Channels = nil },
+		"not http":          func(r *AlertRule) { r.Channels[0].Target = "ftp://example.com/" },
+		"email without smtp": func(r *AlertRule) {
+			r.Channels = []AlertChannel{{Type: "email", Target: "ops@example.com"}}
+		},
+		"goal without event": func(r *AlertRule) { r.Goal = &FunnelStep{} },
+	} {
+		rule := base
+		rule.Channels = append([]AlertChannel(nil), base.Channels...)
+		change(&rule)
+		if err := rule.validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		condition       AlertCondition
+		value, previous float64
+		want            bool
+	}{
+		{AlertDrop, 50, 100, true},
+		{AlertDrop, 60, 100, false},
+		{AlertDrop, 0, 0, false},
+		{AlertRise, 150, 100, true},
+		{AlertRise, 1, 0, true},
+		{AlertBelow, 99, 0, true},
+		{AlertAbove, 100, 0, false},
+	} {
+		rule := AlertRule{Condition: tc.condition, Threshold: 50}
+		if tc.condition == AlertBelow || tc.condition == AlertAbove {
+			rule.Threshold = 100
+		}
+		if got := rule.fires(tc.value, tc.previous); got != tc.want {
+			t.Errorf("%s %g from %g: got %t", tc.condition, tc.value, tc.previous, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tracker
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// AnomalyAction selects what happens to events over the volume limit.
+type AnomalyAction string
+
+const (
+	// AnomalyTag stores them with QualityBot, the default.
+	AnomalyTag AnomalyAction = "tag"
+	// AnomalyThrottle stores them with QualityThrottled and doesn't count
+	// them against the site's quota.
+	AnomalyThrottle AnomalyAction = "throttle"
+)
+
+// volumeGuardKeys caps the identities and IPs counted per minute, so a flood
+// of unique ones can't grow memory without bound. Those past it aren't
+// counted until the next minute.
+const volumeGuardKeys = 100_000
+
+// qualityStats counts the events flagged per quality.
+var qualityStats = expvar.NewMap("quality")
+
+// VolumeGuard counts the events of every identity and IP in fixed windows of
+// a minute. Those sending more than the limit are suspected of automation:
+// no visitor views hundreds of pages a minute.
+type VolumeGuard struct {
+	lock   sync.Mutex
+	limit  int
+	minute time.Time
+	counts map[string]int
+}
+
+// NewVolumeGuard returns a VolumeGuard suspecting more than limit events a
+// minute, a zero limit suspects nothing.
+func NewVolumeGuard(limit int) *VolumeGuard {
+	return &VolumeGuard{limit: limit, counts: make(map[string]int)}
+}
+
+// Suspect counts an event of identity from ip, both may be empty, and
+// reports whether either sent more than the limit this minute. A nil guard
+// suspects nothing.
+func (g *VolumeGuard) Suspect(identity, ip string, now time.Time) bool {
+	if g == nil || g.limit <= 0 {
+		return false
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if minute := now.Truncate(time.Minute); !minute.Equal(g.minute) {
+		g.minute = minute
+		clear(g.counts)
+	}
+	suspect := false
+	for _, key := range []string{"identity:" + identity, "ip:" + ip} {
+		if key == "identity:" || key == "ip:" {
+			continue
+		}
+		n, ok := g.counts[key]
+		if !ok && len(g.counts) >= volumeGuardKeys {
+			continue
+		}
+		g.counts[key] = n + 1
+		suspect = suspect || n+1 > g.limit
+	}
+	return suspect
+}
+
+// Check counts the event of action sent from ip and, when it is suspected of
+// automation, flags it with QualityBot or, with ANOMALY_ACTION=throttle,
+// QualityThrottled. Events already flagged keep their quality. It reports
+// whether the event was throttled.
+func (g *VolumeGuard) Check(action *TrackingData, ip string, now time.Time) (throttled bool) {
+	if !g.Suspect(action.Identity, ip, now) || quality(*action) != QualityOK {
+		return false
+	}
+	action.Quality = QualityBot
+	if config.AnomalyAction == AnomalyThrottle {
+		action.Quality = QualityThrottled
+	}
+	qualityStats.Add(action.Quality, 1)
+	return action.Quality == QualityThrottled
+}
@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestVolumeGuard(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+
+	g := NewVolumeGuard(3)
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if g.Suspect("u1", "203.0.113.7", now) {
+			t.Fatalf("event %d suspected", i+1)
+		}
+	}
+	if !g.Suspect("u1", "198.51.100.1", now) {
+		t.Error("fourth event of an identity not suspected")
+	}
+	if !g.Suspect("u2", "203.0.113.7", now) {
+		t.Error("fourth event of an IP not suspected")
+	}
+	if g.Suspect("u1", "203.0.113.7", now.Add(time.Minute)) {
+		t.Error("counts kept into the next minute")
+	}
+	if g.Suspect("", "", now) || (*VolumeGuard)(nil).Suspect("u1", "", now) || NewVolumeGuard(0).Suspect("u1", "", now) {
+		t.Error("suspected without anything to count")
+	}
+
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		g.Suspect("u1", "", now)
+	}
+	action := TrackingData{Identity: "u1"}
+	if g.Check(&action, "", now) || action.Quality != QualityBot {
+		t.Errorf("tag: got quality %q", action.Quality)
+	}
+	self := TrackingData{Identity: "u1", Quality: QualitySelf}
+	if g.Check(&self, "", now) || self.Quality != QualitySelf {
+		t.Errorf("flagged event: got quality %q", self.Quality)
+	}
+	config.AnomalyAction = AnomalyThrottle
+	if action := (TrackingData{Identity: "u1"}); !g.Check(&action, "", now) || action.Quality != QualityThrottled {
+		t.Errorf("throttle: got quality %q", action.Quality)
+	}
+}
+
+func TestFlaggedLeftOut(t *testing.T) {
+	m := NewMemoryEvents()
+	for _, q := range []string{"", QualityBot, QualitySelf} {
+		trk := Tracking{SiteID: "s", Action: TrackingData{Type: PageviewType, Category: PageviewCategory, Event: "/", OccuredAt: 20240301, Quality: q}}
+		if err := m.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := MetricData{What: QueryPageViewList, SiteID: "s", Start: 20240301, End: 20240301}
+	if got, _ := m.GetStats(context.Background(), data); len(got.Data) != 1 || got.Data[0].Count != 1 {
+		t.Errorf("by default: got %+v", got.Data)
+	}
+	data.Qualities = []string{QualityOK, QualityBot}
+	if got, _ := m.GetStats(context.Background(), data); len(got.Data) != 1 || got.Data[0].Count != 2 {
+		t.Errorf("with bots: got %+v", got.Data)
+	}
+	series, _ := m.TimeSeries(context.Background(), TimeSeriesQuery{SiteID: "s", Start: 20240301, End: 20240301})
+	if len(series.Data) != 1 || series.Data[0].Pageviews != 1 {
+		t.Errorf("time series: got %+v", series.Data)
+	}
+}
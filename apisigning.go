@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyIDHeader names the signing secret of an API request signed instead of
+// carrying an API key. The signature and its timestamp are sent in the
+// SignatureHeader and SignatureTimestampHeader.
+const KeyIDHeader = "X-Key-Id"
+
+// SigningSecret is a secret API requests may be signed with rather than
+// sending the key itself. Unlike keys it is stored as is, verifying needs
+// it. Secrets are rotated by adding one with a new ID, moving callers to it
+// and then removing the old one.
+type SigningSecret struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// SignRequest returns the hex HMAC-SHA256 with secret of the method, the
+// request URI, the timestamp (unix seconds) and the hex SHA-256 of the body,
+// each followed by a newline but the last.
+func SignRequest(secret, method, uri string, timestamp int64, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write([]byte(hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequest returns the principal of the key whose secret signed r,
+// whose body is body. Signatures are valid for SignatureMaxAge either side
+// of their timestamp, and may be replayed within it.
+func (k *Keys) VerifyRequest(r *http.Request, body []byte, now time.Time) (Principal, error) {
+	id := r.Header.Get(KeyIDHeader)
+	ts, err := strconv.ParseInt(r.Header.Get(SignatureTimestampHeader), 10, 64)
+	if id == "" || err != nil {
+		return Principal{}, ErrUnsigned
+	}
+
+	k.lock.RLock()
+	signer, ok := k.bySecret[id]
+	k.lock.RUnlock()
+	if !ok {
+		return Principal{}, ErrBadSignature
+	}
+	want := SignRequest(signer.secret, r.Method, r.URL.RequestURI(), ts, body)
+	if !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(want)) {
+		return Principal{}, ErrBadSignature
+	}
+
+	maxAge := config.SignatureMaxAge
+	if signedAt := time.Unix(ts, 0); signedAt.Before(now.Add(-maxAge)) || signedAt.After(now.Add(maxAge)) {
+		return Principal{}, ErrStaleSignature
+	}
+	return signer.key.principal(), nil
+}
+
+// signer is the key a signing secret belongs to.
+type signer struct {
+	key    APIKey
+	secret string
+}
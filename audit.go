@@ -0,0 +1,208 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one request to the stats or admin API: who made it,
+// when, for which site and with which query.
+type AuditEntry struct {
+	At       time.Time `json:"at"`
+	Actor    string    `json:"actor"`
+	RemoteIP string    `json:"remoteIp"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	SiteID   string    `json:"siteId,omitempty"`
+	Query    string    `json:"query,omitempty"`
+	Status   int       `json:"status"`
+}
+
+// AuditQuery selects audit entries between two times, optionally of one
+// site or actor, newest first.
+type AuditQuery struct {
+	Start  time.Time
+	End    time.Time
+	SiteID string
+	Actor  string
+	Limit  int
+}
+
+// AuditStore is implemented by stores keeping the audit log.
+type AuditStore interface {
+	AddAudit(ctx context.Context, entries []AuditEntry) error
+	Audit(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+}
+
+// auditBatchSize and auditFlushInterval bound how long entries wait in the
+// Auditor before they are written.
+const (
+	auditBatchSize     = 100
+	auditFlushInterval = 5 * time.Second
+)
+
+// Auditor writes audit entries to a store in batches, off the request path.
+type Auditor struct {
+	store   AuditStore
+	entries chan AuditEntry
+	done    chan struct{}
+	log     *slog.Logger
+}
+
+func NewAuditor(store AuditStore) *Auditor {
+	return &Auditor{
+		store:   store,
+		entries: make(chan AuditEntry, 1000),
+		done:    make(chan struct{}),
+		log:     slog.Default().With(slog.String("component", "Auditor")),
+	}
+}
+
+// Record queues an entry. It blocks while the queue is full rather than
+// losing the entry, until ctx is done.
+func (a *Auditor) Record(ctx context.Context, entry AuditEntry) error {
+	if entry.At.IsZero() {
+		entry.At = clock.Now().UTC()
+	}
+	select {
+	case a.entries <- entry:
+		return nil
+	case <-ctx.Done():
+		a.log.Error("Failed to queue audit entry", slog.Any("error", ctx.Err()), slog.String("path", entry.Path))
+		return ctx.Err()
+	}
+}
+
+// Run writes queued entries until ctx is done, then writes the remaining ones.
+func (a *Auditor) Run(ctx context.Context) {
+	defer close(a.done)
+
+	ticker := clock.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	var batch []AuditEntry
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Written even while shutting down
+		writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := a.store.AddAudit(writeCtx, batch); err != nil {
+			a.log.Error("Failed to write audit entries", slog.Any("error", err), slog.Int("count", len(batch)))
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case entry := <-a.entries:
+			batch = append(batch, entry)
+			if len(batch) >= auditBatchSize {
+				flush()
+			}
+		case <-ticker.C():
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-a.entries:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Wait returns once Run wrote the last entries.
+func (a *Auditor) Wait() {
+	<-a.done
+}
+
+func (e *Events) AddAudit(ctx context.Context, entries []AuditEntry) error {
+	batch, err := e.DB.PrepareBatch(ctx, `
+		INSERT INTO audit_log (at, actor, remote_ip, method, path, site_id, query, status)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare audit batch: %w", err)
+	}
+	for _, a := range entries {
+		if err := batch.Append(a.At, a.Actor, a.RemoteIP, a.Method, a.Path, a.SiteID, a.Query, uint16(a.Status)); err != nil {
+			return fmt.Errorf("failed to append to audit batch: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send audit batch: %w", err)
+	}
+	return nil
+}
+
+func (e *Events) Audit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	qry := `
+		SELECT at, actor, remote_ip, method, path, site_id, query, status
+		FROM audit_log
+		WHERE at BETWEEN $1 AND $2
+		AND ($3 = '' OR site_id = $3)
+		AND ($4 = '' OR actor = $4)
+		ORDER BY at DESC
+		LIMIT $5;
+	`
+	rows, err := e.DB.Query(ctx, qry, q.Start, q.End, q.SiteID, q.Actor, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("audit query failed: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var a AuditEntry
+		var status uint16
+		if err := rows.Scan(&a.At, &a.Actor, &a.RemoteIP, &a.Method, &a.Path, &a.SiteID, &a.Query, &status); err != nil {
+			return nil, fmt.Errorf("failed scanning audit row: %w", err)
+		}
+		a.Status = int(status)
+		entries = append(entries, a)
+	}
+	return entries, rows.Err()
+}
+
+// memoryAudit is the audit log of MemoryEvents.
+type memoryAudit struct {
+	lock    sync.RWMutex
+	entries []AuditEntry
+}
+
+func (m *MemoryEvents) AddAudit(ctx context.Context, entries []AuditEntry) error {
+	m.audit.lock.Lock()
+	defer m.audit.lock.Unlock()
+	m.audit.entries = append(m.audit.entries, entries...)
+	return nil
+}
+
+func (m *MemoryEvents) Audit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	m.audit.lock.RLock()
+	entries := []AuditEntry{}
+	for _, a := range m.audit.entries {
+		if a.At.Before(q.Start) || a.At.After(q.End) {
+			continue
+		}
+		if (q.SiteID != "" && a.SiteID != q.SiteID) || (q.Actor != "" && a.Actor != q.Actor) {
+			continue
+		}
+		entries = append(entries, a)
+	}
+	m.audit.lock.RUnlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.After(entries[j].At) })
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
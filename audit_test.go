@@ -0,0 +1,48 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAuditor(t *testing.T) {
+	m := NewMemoryEvents()
+	a := NewAuditor(m)
+	ctx, cancel := context.WithCancel(context.Background())
+	go a.Run(ctx)
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{At: at, Actor: "api-key", Path: "/stats", SiteID: "a", Status: 200},
+		{At: at.Add(time.Minute), Actor: "anonymous", Path: "/stats", SiteID: "a", Status: 401},
+		{At: at.Add(2 * time.Minute), Actor: "api-key", Path: "/usage", SiteID: "b", Status: 200},
+		{At: at.AddDate(0, 0, 2), Actor: "api-key", Path: "/stats", SiteID: "a", Status: 200},
+	}
+	for _, e := range entries {
+		if err := a.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Pending entries are written on shutdown
+	cancel()
+	a.Wait()
+
+	day := AuditQuery{Start: at.Add(-time.Hour), End: at.Add(time.Hour), Limit: 10}
+	got, _ := m.Audit(context.Background(), day)
+	if len(got) != 3 || got[0].Path != "/usage" || got[2].Actor != "api-key" {
+		t.Errorf("got %+v, want the first day's entries newest first", got)
+	}
+
+	bySite := day
+	bySite.SiteID, bySite.Actor = "a", "api-key"
+	if got, _ := m.Audit(context.Background(), bySite); len(got) != 1 || got[0].Status != 200 {
+		t.Errorf("site and actor filter: got %+v", got)
+	}
+
+	limited := day
+	limited.Limit = 1
+	if got, _ := m.Audit(context.Background(), limited); len(got) != 1 || got[0].Path != "/usage" {
+		t.Errorf("limit: got %+v", got)
+	}
+}
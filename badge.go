@@ -0,0 +1,117 @@
+package tracker
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// maxBadgeDays bounds the days a badge sums, the time series limit.
+const maxBadgeDays = 365
+
+// BadgeMetric is what an embedded badge shows: the visitors or page views
+// of a site over its last Days days, today included.
+type BadgeMetric struct {
+	Field string
+	Days  int
+}
+
+// ParseBadgeMetric parses metrics such as "visitors-7d" and "pageviews-30d".
+func ParseBadgeMetric(s string) (BadgeMetric, error) {
+	field, days, ok := strings.Cut(s, "-")
+	n, err := strconv.Atoi(strings.TrimSuffix(days, "d"))
+	if !ok || !strings.HasSuffix(days, "d") || err != nil || n < 1 || n > maxBadgeDays {
+		return BadgeMetric{}, fmt.Errorf("%w: metric must be visitors-<days>d or pageviews-<days>d, at most %d days", ErrInvalid, maxBadgeDays)
+	}
+	if field != "visitors" && field != "pageviews" {
+		return BadgeMetric{}, fmt.Errorf("%w: unknown badge metric %q", ErrInvalid, field)
+	}
+	return BadgeMetric{Field: field, Days: n}, nil
+}
+
+// Label is the badge's caption, such as "visitors 7d".
+func (m BadgeMetric) Label() string {
+	return fmt.Sprintf("%s %dd", m.Field, m.Days)
+}
+
+// Counts returns the metric of every point, daily visitors are summed like
+// the dashboard does.
+func (m BadgeMetric) Counts(points []TimeSeriesPoint) (counts []uint64, total uint64) {
+	counts = make([]uint64, len(points))
+	for i, p := range points {
+		counts[i] = p.Pageviews
+		if m.Field == "visitors" {
+			counts[i] = p.Visitors
+		}
+		total += counts[i]
+	}
+	return counts, total
+}
+
+// FormatCount shortens counts for badges: 950, 12.3k, 4.5M.
+func FormatCount(n uint64) string {
+	switch {
+	case n < 1000:
+		return strconv.FormatUint(n, 10)
+	case n < 1_000_000:
+		return strconv.FormatFloat(float64(n)/1e3, 'f', 1, 64) + "k"
+	default:
+		return strconv.FormatFloat(float64(n)/1e6, 'f', 1, 64) + "M"
+	}
+}
+
+// Badge sizes, text widths are estimated at badgeCharWidth per character
+// as no font metrics are at hand.
+const (
+	badgeHeight    = 20
+	badgeCharWidth = 7
+	badgePadding   = 10
+	sparkWidth     = 100
+)
+
+// BadgeSVG renders a badge: label on grey and value on blue, followed by
+// a sparkline of spark when it isn't empty, its highest count touching the
+// top.
+func BadgeSVG(label, value string, spark []uint64) []byte {
+	lw := len(label)*badgeCharWidth + badgePadding
+	vw := len(value)*badgeCharWidth + badgePadding
+	width := lw + vw
+	if len(spark) > 0 {
+		width += sparkWidth
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`, width, badgeHeight, html.EscapeString(label), html.EscapeString(value))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#555"/><rect x="%d" width="%d" height="%d" fill="#007ec6"/>`, lw, badgeHeight, lw, vw, badgeHeight)
+	fmt.Fprintf(&b, `<g fill="#fff" font-family="Verdana,sans-serif" font-size="11" text-anchor="middle">`)
+	fmt.Fprintf(&b, `<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g>`, lw/2, html.EscapeString(label), lw+vw/2, html.EscapeString(value))
+	if len(spark) > 0 {
+		fmt.Fprintf(&b, `<rect x="%d" width="%d" height="%d" fill="#f6f8fa"/>`, lw+vw, sparkWidth, badgeHeight)
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="#007ec6" stroke-width="1.5" points="%s"/>`, sparkline(spark, lw+vw))
+	}
+	b.WriteString(`</svg>`)
+	return b.Bytes()
+}
+
+// sparkline returns the points of a polyline of counts starting at x.
+func sparkline(counts []uint64, x int) string {
+	var peak uint64
+	for _, c := range counts {
+		peak = max(peak, c)
+	}
+	points := make([]string, len(counts))
+	for i, c := range counts {
+		px := float64(x + 2)
+		if len(counts) > 1 {
+			px += float64(i) * float64(sparkWidth-4) / float64(len(counts)-1)
+		}
+		py := float64(badgeHeight - 3)
+		if peak > 0 {
+			py -= float64(c) / float64(peak) * (badgeHeight - 6)
+		}
+		points[i] = strconv.FormatFloat(px, 'f', 1, 64) + "," + strconv.FormatFloat(py, 'f', 1, 64)
+	}
+	return strings.Join(points, " ")
+}
@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseBadgeMetric(t *testing.T) {
+	if m, err := ParseBadgeMetric("visitors-7d"); err != nil || m != (BadgeMetric{Field: "visitors", Days: 7}) || m.Label() != "visitors 7d" {
+		t.Errorf("got %+v, %v", m, err)
+	}
+	for _, bad := range []string{"", "visitors", "visitors-7", "visitors-0d", "visitors-400d", "sessions-7d", "pageviews-xd"} {
+		if _, err := ParseBadgeMetric(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: got %v", bad, err)
+		}
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, want := range map[uint64]string{0: "0", 999: "999", 1000: "1.0k", 12345: "12.3k", 4_560_000: "4.6M"} {
+		if got := FormatCount(n); got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestBadgeSVG(t *testing.T) {
+	m := BadgeMetric{Field: "pageviews", Days: 3}
+	counts, total := m.Counts([]TimeSeriesPoint{{Pageviews: 1, Visitors: 1}, {Pageviews: 4, Visitors: 2}, {}})
+	if total != 5 || len(counts) != 3 {
+		t.Fatalf("got %v, %d", counts, total)
+	}
+
+	counter := BadgeSVG("<label>", FormatCount(total), nil)
+	if !bytes.Contains(counter, []byte("&lt;label&gt;")) || bytes.Contains(counter, []byte("polyline")) {
+		t.Errorf("counter: got %s", counter)
+	}
+	spark := BadgeSVG(m.Label(), FormatCount(total), counts)
+	if !bytes.Contains(spark, []byte(`points="`)) || !bytes.HasSuffix(spark, []byte("</svg>")) {
+		t.Errorf("sparkline: got %s", spark)
+	}
+}
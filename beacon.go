@@ -0,0 +1,40 @@
+package tracker
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+)
+
+// navigator.sendBeacon can only send a few content types without a CORS
+// preflight, which browsers don't wait for while a page unloads: a string
+// goes as text/plain and URLSearchParams as a form. Beacons of either kind
+// carry the JSON payload as is or base64 encoded like the data parameter
+// of GET requests.
+
+const (
+	beaconText = "text/plain"
+	beaconForm = "application/x-www-form-urlencoded"
+)
+
+// beaconJSON returns the JSON payload of a beacon body of mediaType: a
+// text/plain body of JSON or base64, or a form whose data field is base64.
+func beaconJSON(b []byte, mediaType string) ([]byte, error) {
+	if mediaType == beaconForm {
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid form: %v", ErrMalformedPayload, err)
+		}
+		data := form.Get("data")
+		if data == "" {
+			return nil, fmt.Errorf("%w: missing data field", ErrMalformedPayload)
+		}
+		return DecodeBase64(data)
+	}
+
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 || trimmed[0] == '{' || trimmed[0] == '[' {
+		return b, nil
+	}
+	return DecodeBase64(string(trimmed))
+}
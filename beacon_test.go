@@ -0,0 +1,39 @@
+package tracker
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestBeaconJSON(t *testing.T) {
+	payload := `{"site_id":"s","tracking":{"event":"/?a=1&b=2"}}`
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+
+	for _, c := range []struct {
+		name, contentType, body string
+	}{
+		{"text", "text/plain;charset=UTF-8", payload},
+		{"base64 text", "text/plain;charset=UTF-8", encoded + "\n"},
+		{"url-safe base64 text", "text/plain", base64.RawURLEncoding.EncodeToString([]byte(payload))},
+		{"form", "application/x-www-form-urlencoded", url.Values{"data": {encoded}}.Encode()},
+	} {
+		got, err := BodyJSON([]byte(c.body), c.contentType)
+		if err != nil || string(got) != payload {
+			t.Errorf("%s: got %s, %v", c.name, got, err)
+		}
+	}
+
+	for _, c := range []struct {
+		name, contentType, body string
+	}{
+		{"invalid base64", "text/plain", "not base64!"},
+		{"form without data", "application/x-www-form-urlencoded", "site_id=s"},
+		{"invalid form", "application/x-www-form-urlencoded", "data=%zz"},
+	} {
+		if _, err := BodyJSON([]byte(c.body), c.contentType); !errors.Is(err, ErrMalformedPayload) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
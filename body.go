@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrPayloadTooLarge is returned when a request body is over its size cap,
+// before or after decompression.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// ErrUnsupportedEncoding is returned for a Content-Encoding other than gzip.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// ReadBody reads a request body sent with the given Content-Encoding, which
+// may be empty, "identity" or "gzip". limit caps the body both as sent and
+// once decompressed, so a small gzip bomb can't expand into memory.
+func ReadBody(body io.Reader, encoding string, limit int64) ([]byte, error) {
+	r := io.LimitReader(body, limit+1)
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		counted := &countingReader{r: r}
+		zr, err := gzip.NewReader(counted)
+		if err != nil {
+			if counted.n > limit {
+				return nil, ErrPayloadTooLarge
+			}
+			return nil, fmt.Errorf("%w: invalid gzip body: %v", ErrMalformedPayload, err)
+		}
+		defer zr.Close()
+
+		b, err := readLimited(zr, limit)
+		if counted.n > limit {
+			return nil, ErrPayloadTooLarge
+		}
+		if err != nil && !errors.Is(err, ErrPayloadTooLarge) {
+			return nil, fmt.Errorf("%w: invalid gzip body: %v", ErrMalformedPayload, err)
+		}
+		return b, err
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+
+	return readLimited(r, limit)
+}
+
+// readLimited reads r to the end, failing once more than limit bytes came out.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrPayloadTooLarge
+	}
+	return b, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
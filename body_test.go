@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadBody(t *testing.T) {
+	payload := `{"site_id":"s"}`
+	// Compresses to a few hundred bytes
+	bomb := strings.Repeat("a", 1<<20)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		limit    int64
+		want     string
+		err      error
+	}{
+		{"plain", []byte(payload), "", 64, payload, nil},
+		{"identity", []byte(payload), "identity", 64, payload, nil},
+		{"plain at limit", []byte(payload), "", int64(len(payload)), payload, nil},
+		{"plain over limit", []byte(payload), "", int64(len(payload)) - 1, "", ErrPayloadTooLarge},
+		{"gzip", gzipped(t, payload), "gzip", 64, payload, nil},
+		{"gzip uppercase", gzipped(t, payload), "GZIP", 64, payload, nil},
+		{"gzip over limit once decompressed", gzipped(t, bomb), "gzip", 64 << 10, "", ErrPayloadTooLarge},
+		{"gzip over limit compressed", gzipped(t, payload), "gzip", 8, "", ErrPayloadTooLarge},
+		{"invalid gzip", []byte(payload), "gzip", 64, "", ErrMalformedPayload},
+		{"truncated gzip", gzipped(t, payload)[:20], "gzip", 64, "", ErrMalformedPayload},
+		{"unsupported", []byte(payload), "br", 64, "", ErrUnsupportedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ReadBody(bytes.NewReader(tt.body), tt.encoding, tt.limit)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while the breaker keeps queries away from
+// ClickHouse. It is wrapped in a *CircuitOpenError telling when to retry.
+var ErrCircuitOpen = errors.New("circuit open")
+
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrCircuitOpen, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+// Breaker is an EventStore whose stats queries go through a circuit breaker.
+// After threshold consecutive failures it rejects queries for cooldown, then
+// lets a single query through: its success closes the circuit, its failure
+// opens it for another cooldown.
+type Breaker struct {
+	EventStore
+
+	threshold int
+	cooldown  time.Duration
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	log       *slog.Logger
+}
+
+func NewBreaker(store EventStore, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		EventStore: store,
+		threshold:  threshold,
+		cooldown:   cooldown,
+		log:        slog.Default().With(slog.String("component", "Breaker")),
+	}
+}
+
+// allow reports whether a query may run, probe is set for the single query
+// let through after a cooldown.
+func (b *Breaker) allow() (probe bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < b.threshold {
+		return false, nil
+	}
+	now := clock.Now()
+	if now.Before(b.openUntil) {
+		return false, &CircuitOpenError{RetryAfter: b.openUntil.Sub(now)}
+	}
+	if b.probing {
+		return false, &CircuitOpenError{RetryAfter: b.cooldown}
+	}
+	b.probing = true
+	return true, nil
+}
+
+func (b *Breaker) record(ctx context.Context, probe bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case err == nil:
+		if b.failures >= b.threshold {
+			b.log.Info("Circuit closed, queries succeed again")
+		}
+		b.failures = 0
+	case ctx.Err() != nil && !errors.Is(err, context.DeadlineExceeded):
+		// The caller went away, that says nothing about ClickHouse
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openUntil = clock.Now().Add(b.cooldown)
+			statsCounters.Add("circuit_opened", 1)
+			b.log.Warn("Circuit opened after consecutive query failures",
+				slog.Int("failures", b.failures), slog.Duration("cooldown", b.cooldown), slog.Any("error", err))
+		}
+	}
+}
+
+func (b *Breaker) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := b.EventStore.GetStats(ctx, data)
+	b.record(ctx, probe, err)
+	return result, err
+}
+
+func (b *Breaker) TimeSeries(ctx context.Context, q TimeSeriesQuery) (*TimeSeriesResult, error) {
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := b.EventStore.TimeSeries(ctx, q)
+	b.record(ctx, probe, err)
+	return result, err
+}
+
+func (b *Breaker) Values(ctx context.Context, q ValuesQuery) ([]string, error) {
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	values, err := b.EventStore.Values(ctx, q)
+	b.record(ctx, probe, err)
+	return values, err
+}
+
+func (b *Breaker) Summary(ctx context.Context, siteID string) (*SiteSummary, error) {
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	summary, err := b.EventStore.Summary(ctx, siteID)
+	b.record(ctx, probe, err)
+	return summary, err
+}
+
+func (b *Breaker) DarkTraffic(ctx context.Context, q DarkTrafficQuery) (*DarkTrafficResult, error) {
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := b.EventStore.DarkTraffic(ctx, q)
+	b.record(ctx, probe, err)
+	return result, err
+}
+
+// Funnel passes funnel queries through the breaker, when the store computes
+// them.
+func (b *Breaker) Funnel(ctx context.Context, q FunnelQuery) (*FunnelResult, error) {
+	store, ok := b.EventStore.(FunnelStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't compute funnels", errors.ErrUnsupported)
+	}
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := store.Funnel(ctx, q)
+	b.record(ctx, probe, err)
+	return result, err
+}
+
+// Vitals passes vitals queries through the breaker, when the store keeps web vitals.
+func (b *Breaker) Vitals(ctx context.Context, q VitalsQuery) (*VitalsResult, error) {
+	store, ok := b.EventStore.(VitalsStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't keep web vitals", errors.ErrUnsupported)
+	}
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := store.Vitals(ctx, q)
+	b.record(ctx, probe, err)
+	return result, err
+}
+
+// TopErrors passes errors queries through the breaker, when the store keeps JavaScript errors.
+func (b *Breaker) TopErrors(ctx context.Context, q ErrorsQuery) (*ErrorsResult, error) {
+	store, ok := b.EventStore.(ErrorStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't keep JavaScript errors", errors.ErrUnsupported)
+	}
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := store.TopErrors(ctx, q)
+	b.record(ctx, probe, err)
+	return result, err
+}
+
+// Cohorts passes cohorts queries through the breaker, when the store compares cohorts.
+func (b *Breaker) Cohorts(ctx context.Context, q CohortQuery) (*CohortResult, error) {
+	store, ok := b.EventStore.(CohortStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't compare cohorts", errors.ErrUnsupported)
+	}
+	probe, err := b.allow()
+	if err != nil {
+		statsCounters.Add("circuit_rejected", 1)
+		return nil, err
+	}
+	result, err := store.Cohorts(ctx, q)
+	b.record(ctx, probe, err)
+	return result, err
+}
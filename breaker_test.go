@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingStore fails GetStats while err is set.
+type failingStore struct {
+	*MemoryEvents
+	err   error
+	calls int
+}
+
+func (s *failingStore) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MemoryEvents.GetStats(ctx, data)
+}
+
+func TestBreaker(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	store := &failingStore{MemoryEvents: NewMemoryEvents(), err: context.DeadlineExceeded}
+	b := NewBreaker(store, 3, 30*time.Second)
+	data := MetricData{What: QueryBrowsers, SiteID: "site"}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.GetStats(ctx, data); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("query %d: got %v", i, err)
+		}
+	}
+
+	// Open: queries are rejected without reaching the store
+	_, err := b.GetStats(ctx, data)
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want an open circuit", err)
+	}
+	if open.RetryAfter != 30*time.Second {
+		t.Errorf("retry after %s, want 30s", open.RetryAfter)
+	}
+	if store.calls != 3 {
+		t.Errorf("store queried %d times, want 3", store.calls)
+	}
+
+	// A failing probe opens the circuit for another cooldown
+	fc.Advance(30 * time.Second)
+	if _, err := b.GetStats(ctx, data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("probe: got %v", err)
+	}
+	if _, err := b.GetStats(ctx, data); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v after a failed probe, want an open circuit", err)
+	}
+
+	// A successful probe closes it
+	fc.Advance(30 * time.Second)
+	store.err = nil
+	for i := 0; i < 5; i++ {
+		if _, err := b.GetStats(ctx, data); err != nil {
+			t.Fatalf("query %d after recovery: %v", i, err)
+		}
+	}
+}
+
+func TestBreakerIgnoresCancelledCallers(t *testing.T) {
+	store := &failingStore{MemoryEvents: NewMemoryEvents(), err: context.Canceled}
+	b := NewBreaker(store, 1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.GetStats(ctx, MetricData{What: QueryBrowsers, SiteID: "site"})
+
+	store.err = nil
+	if _, err := b.GetStats(context.Background(), MetricData{What: QueryBrowsers, SiteID: "site"}); err != nil {
+		t.Errorf("circuit opened by a cancelled caller: %v", err)
+	}
+}
+
+func TestBreakerOptionalStores(t *testing.T) {
+	store := &failingStore{MemoryEvents: NewMemoryEvents(), err: context.DeadlineExceeded}
+	b := NewBreaker(store, 1, time.Minute)
+	ctx := context.Background()
+	b.GetStats(ctx, MetricData{What: QueryBrowsers, SiteID: "site"})
+
+	for name, query := range map[string]func() error{
+		"funnel": func() error {
+			_, err := b.Funnel(ctx, FunnelQuery{SiteID: "site", Steps: []FunnelStep{{Event: "/"}}})
+			return err
+		},
+		"vitals": func() error {
+			_, err := b.Vitals(ctx, VitalsQuery{SiteID: "site"})
+			return err
+		},
+		"errors": func() error {
+			_, err := b.TopErrors(ctx, ErrorsQuery{SiteID: "site"})
+			return err
+		},
+		"cohorts": func() error {
+			_, err := b.Cohorts(ctx, CohortQuery{SiteID: "site"})
+			return err
+		},
+	} {
+		if err := query(); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("%s: got %v, want an open circuit", name, err)
+		}
+	}
+
+	// Stores without them are reported, not bypassed
+	plain := NewBreaker(struct{ EventStore }{NewMemoryEvents()}, 1, time.Minute)
+	if _, err := plain.Funnel(ctx, FunnelQuery{}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("store without funnels: got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// bulkStats is published on /debug/vars.
+var bulkStats = expvar.NewMap("bulk")
+
+// bulkPoll is how often the lane checks whether the insert queue has room.
+const bulkPoll = 100 * time.Millisecond
+
+type bulkJob struct {
+	trk Tracking
+	ua  useragent.UserAgent
+}
+
+// BulkLane adds imported and backfilled events to the store behind live
+// ones. They wait in a queue of their own and are added at most BULK_RATE
+// per second, and only while fewer than BULK_MAX_QUEUED events wait to be
+// inserted, so bulk loads never hold up live events.
+type BulkLane struct {
+	store     EventStore
+	queued    Queued
+	rate      int
+	maxQueued int
+	jobs      chan bulkJob
+	wg        sync.WaitGroup
+	log       *slog.Logger
+}
+
+// NewBulkLane returns a lane adding events to store. queued reports the
+// store's insert queue, it may be nil when the store doesn't queue.
+func NewBulkLane(store EventStore, queued Queued) *BulkLane {
+	return &BulkLane{
+		store:     store,
+		queued:    queued,
+		rate:      config.BulkRate,
+		maxQueued: max(config.BulkMaxQueued, 1),
+		jobs:      make(chan bulkJob, config.BulkQueueSize),
+		log:       slog.Default().With(slog.String("component", "BulkLane")),
+	}
+}
+
+// Start runs the lane's single worker.
+func (b *BulkLane) Start() {
+	b.wg.Add(1)
+	go b.work()
+	b.log.Info("Bulk lane started", slog.Int("rate", b.rate), slog.Int("queueSize", cap(b.jobs)))
+}
+
+// Submit queues an event, waiting for room in the lane until ctx is done.
+func (b *BulkLane) Submit(ctx context.Context, trk Tracking, ua useragent.UserAgent) error {
+	select {
+	case b.jobs <- bulkJob{trk: trk, ua: ua}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Len returns the number of events waiting in the lane.
+func (b *BulkLane) Len() int {
+	return len(b.jobs)
+}
+
+// Close stops accepting events and returns once every queued event has been
+// handed to the store.
+func (b *BulkLane) Close() {
+	close(b.jobs)
+	b.wg.Wait()
+}
+
+func (b *BulkLane) work() {
+	defer b.wg.Done()
+
+	var throttle <-chan time.Time
+	if b.rate > 0 {
+		ticker := clock.NewTicker(time.Second / time.Duration(b.rate))
+		defer ticker.Stop()
+		throttle = ticker.C()
+	}
+
+	for job := range b.jobs {
+		if throttle != nil {
+			<-throttle
+		}
+		b.waitForRoom()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := b.store.Add(ctx, job.trk, job.ua, nil)
+		cancel()
+		if err != nil {
+			bulkStats.Add("failed", 1)
+			b.log.Error("Failed to add bulk event", slog.Any("error", err))
+			continue
+		}
+		bulkStats.Add("added", 1)
+	}
+}
+
+// waitForRoom returns once the insert queue has fewer than maxQueued events.
+func (b *BulkLane) waitForRoom() {
+	if b.queued == nil {
+		return
+	}
+	for b.queued.QueueStats().Length >= b.maxQueued {
+		bulkStats.Add("waits", 1)
+		<-clock.NewTimer(bulkPoll).C()
+	}
+}
+
+// ImportEvent is an event imported from another tool or backfilled: a
+// tracking payload and the time the event happened.
+type ImportEvent struct {
+	Tracking
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DecodeImport validates an imported event like a tracking payload. Its
+// timestamp is required and may be anywhere in the past.
+func DecodeImport(b []byte) (Tracking, error) {
+	trk, err := DecodePayload(b)
+	if err != nil {
+		return Tracking{}, err
+	}
+	var ev ImportEvent
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return Tracking{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	if ev.Timestamp.IsZero() {
+		return Tracking{}, fmt.Errorf("%w: timestamp is required", ErrMalformedPayload)
+	}
+	if ev.Timestamp.After(clock.Now().Add(maxClientSkew)) {
+		return Tracking{}, fmt.Errorf("%w: timestamp is in the future", ErrMalformedPayload)
+	}
+	trk.Action.HappenedAt = ev.Timestamp
+	return trk, nil
+}
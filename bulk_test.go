@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// busyQueue reports a full insert queue until freed.
+type busyQueue struct{ full atomic.Bool }
+
+func (q *busyQueue) QueueStats() QueueStats {
+	if q.full.Load() {
+		return QueueStats{Length: 1000}
+	}
+	return QueueStats{}
+}
+
+func (q *busyQueue) Flush(ctx context.Context) error { return nil }
+
+func TestBulkLane(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.BulkRate = 0
+
+	mem := NewMemoryEvents()
+	busy := &busyQueue{}
+	busy.full.Store(true)
+	lane := NewBulkLane(mem, busy)
+	lane.Start()
+
+	trk := Tracking{SiteID: "site", Action: TrackingData{Event: "/imported", Category: "Page views", OccuredAt: 20240301}}
+	if err := lane.Submit(context.Background(), trk, useragent.UserAgent{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * bulkPoll)
+	if mem.Len() != 0 {
+		t.Fatal("bulk event added while the insert queue is full")
+	}
+
+	busy.full.Store(false)
+	lane.Close()
+	if mem.Len() != 1 {
+		t.Errorf("got %d events, want 1", mem.Len())
+	}
+}
+
+func TestDecodeImport(t *testing.T) {
+	trk, err := DecodeImport([]byte(`{"site_id":"s","timestamp":"2024-03-01T10:00:00Z","tracking":{"type":"page","event":"/","category":"Page views"}}`))
+	if err != nil || trk.Action.HappenedAt != time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC) || trk.Action.Event != "/" {
+		t.Errorf("got %+v, %v", trk, err)
+	}
+
+	for _, payload := range []string{
+		`{"site_id":"s","tracking":{"event":"/"}}`,
+		`{"site_id":"s","timestamp":"2999-01-01T00:00:00Z","tracking":{"event":"/"}}`,
+		`{"timestamp":"2024-03-01T10:00:00Z","tracking":{"event":"/"}}`,
+	} {
+		if _, err := DecodeImport([]byte(payload)); err == nil {
+			t.Errorf("%s: accepted", payload)
+		}
+	}
+}
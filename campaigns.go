@@ -0,0 +1,162 @@
+package tracker
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Campaign is a marketing campaign registered with the link builder, so the
+// campaigns report lists it before its links bring any traffic. Visits are
+// attributed to it by the utm_campaign parameter the tracking script sends.
+type Campaign struct {
+	ID      string `json:"id"`
+	SiteID  string `json:"siteId"`
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Medium  string `json:"medium"`
+	Term    string `json:"term,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+func (c Campaign) validate() error {
+	if c.SiteID == "" || c.Name == "" || c.Source == "" || c.Medium == "" {
+		return fmt.Errorf("%w: siteId, name, source and medium are required", ErrInvalid)
+	}
+	if len(c.Name) > maxCampaignLen {
+		return fmt.Errorf("%w: name is longer than %d bytes", ErrInvalid, maxCampaignLen)
+	}
+	return nil
+}
+
+// URL returns landing tagged with the campaign's UTM parameters, replacing
+// any it already had. landing must be an absolute http or https URL.
+func (c Campaign) URL(landing string) (string, error) {
+	u, err := url.Parse(landing)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+
+	q := u.Query()
+	for name, value := range map[string]string{
+		"utm_campaign": c.Name,
+		"utm_source":   c.Source,
+		"utm_medium":   c.Medium,
+		"utm_term":     c.Term,
+		"utm_content":  c.Content,
+	} {
+		q.Del(name)
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Campaigns returns the campaigns of a site ordered by name.
+func (s *Saved) Campaigns(siteID string) []Campaign {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := []Campaign{}
+	for _, c := range s.campaigns {
+		if c.SiteID == siteID {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// EnsureCampaign registers c unless the site has a campaign with the same
+// name, and returns the registered campaign along with whether it was
+// created. Links are built again and again for one campaign.
+func (s *Saved) EnsureCampaign(c Campaign) (Campaign, bool, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	if err := c.validate(); err != nil {
+		return Campaign{}, false, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, existing := range s.campaigns {
+		if existing.SiteID == c.SiteID && existing.Name == c.Name {
+			return existing, false, nil
+		}
+	}
+	c.ID = newSavedID()
+	s.campaigns[c.ID] = c
+	if err := s.save(); err != nil {
+		delete(s.campaigns, c.ID)
+		return Campaign{}, false, err
+	}
+	return c, true, nil
+}
+
+func (s *Saved) Campaign(id string) (Campaign, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	c, ok := s.campaigns[id]
+	return c, ok
+}
+
+func (s *Saved) DeleteCampaign(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.campaigns[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.campaigns, id)
+	if err := s.save(); err != nil {
+		s.campaigns[id] = c
+		return err
+	}
+	return nil
+}
+
+// CampaignStats is a campaign's page views, Registered tells campaigns from
+// the link builder from those only seen in utm_campaign parameters.
+type CampaignStats struct {
+	Name       string `json:"name"`
+	Registered bool   `json:"registered"`
+	Pageviews  uint64 `json:"pageviews"`
+}
+
+// CampaignReport merges the page views per campaign of a QueryCampaigns
+// result with the registered campaigns, which are listed even without page
+// views. Most viewed first.
+func CampaignReport(result *StatsResult, registered []Campaign) []CampaignStats {
+	byName := make(map[string]*CampaignStats)
+	for _, c := range registered {
+		byName[c.Name] = &CampaignStats{Name: c.Name, Registered: true}
+	}
+	for _, m := range result.Data {
+		if m.Value == "" {
+			continue
+		}
+		stats, ok := byName[m.Value]
+		if !ok {
+			stats = &CampaignStats{Name: m.Value}
+			byName[m.Value] = stats
+		}
+		stats.Pageviews += m.Count
+	}
+
+	report := make([]CampaignStats, 0, len(byName))
+	for _, stats := range byName {
+		report = append(report, *stats)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Pageviews != report[j].Pageviews {
+			return report[i].Pageviews > report[j].Pageviews
+		}
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
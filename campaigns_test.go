@@ -0,0 +1,64 @@
+package tracker
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCampaignURL(t *testing.T) {
+	c := Campaign{Name: "spring sale", Source: "newsletter", Medium: "email"}
+	got, err := c.URL("https://example.com/shop?id=7&utm_source=old&utm_term=old#top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(got)
+	want := url.Values{"id": {"7"}, "utm_campaign": {"spring sale"}, "utm_source": {"newsletter"}, "utm_medium": {"email"}}
+	if !reflect.DeepEqual(u.Query(), want) || u.Fragment != "top" || u.Path != "/shop" {
+		t.Errorf("got %s", got)
+	}
+
+	for _, bad := range []string{"/shop", "javascript:alert(1)", "ftp://example.com/", "https://"} {
+		if _, err := c.URL(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: got %v", bad, err)
+		}
+	}
+}
+
+func TestEnsureCampaign(t *testing.T) {
+	s := &Saved{}
+	s.Load("")
+
+	c, created, err := s.EnsureCampaign(Campaign{SiteID: "s", Name: " launch ", Source: "x", Medium: "social"})
+	if err != nil || !created || c.ID == "" || c.Name != "launch" {
+		t.Fatalf("got %+v, %v, %v", c, created, err)
+	}
+	again, created, err := s.EnsureCampaign(Campaign{SiteID: "s", Name: "launch", Source: "y", Medium: "cpc"})
+	if err != nil || created || again != c {
+		t.Errorf("same name: got %+v, %v, %v", again, created, err)
+	}
+	if _, created, _ := s.EnsureCampaign(Campaign{SiteID: "other", Name: "launch", Source: "x", Medium: "social"}); !created {
+		t.Error("same name on another site not created")
+	}
+	if _, _, err := s.EnsureCampaign(Campaign{SiteID: "s", Name: "no medium", Source: "x"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("missing medium: got %v", err)
+	}
+	if got := s.Campaigns("s"); len(got) != 1 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestCampaignReport(t *testing.T) {
+	result := &StatsResult{Data: []Metric{{Value: "launch", Count: 3}, {Value: "", Count: 10}, {Value: "untracked", Count: 5}}}
+	registered := []Campaign{{Name: "launch"}, {Name: "winter"}}
+
+	want := []CampaignStats{
+		{Name: "untracked", Pageviews: 5},
+		{Name: "launch", Registered: true, Pageviews: 3},
+		{Name: "winter", Registered: true},
+	}
+	if got := CampaignReport(result, registered); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,178 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// canaryStats is published on /debug/vars: probes sent, seen and timed out,
+// and the ingest-to-query latency percentiles of the recent ones.
+var canaryStats = expvar.NewMap("canary")
+
+var (
+	canaryP50 = new(expvar.Float)
+	canaryP95 = new(expvar.Float)
+)
+
+func init() {
+	canaryStats.Set("p50_seconds", canaryP50)
+	canaryStats.Set("p95_seconds", canaryP95)
+}
+
+const (
+	// canarySamples is how many recent probes the percentiles are over.
+	canarySamples = 100
+	// canaryPoll is how often a probe is queried for until it shows up.
+	canaryPoll = time.Second
+	// canaryTimeout is how long a probe may take to show up before it is
+	// counted as lost.
+	canaryTimeout = 5 * time.Minute
+)
+
+var errCanaryLost = errors.New("canary event not queryable in time")
+
+// Canary measures how long events take from acceptance to being returned by
+// stats queries, the lag of the whole pipeline: enrichment, the insert
+// queue and ClickHouse. Every probe is a page view of a path of its own on
+// the canary site.
+type Canary struct {
+	siteID  string
+	submit  func(ctx context.Context, trk Tracking) error
+	store   EventStore
+	lock    sync.Mutex
+	samples []time.Duration // ring of the latest canarySamples latencies
+	next    int
+	log     *slog.Logger
+}
+
+// CanaryLatency are the percentiles of the recent probes' latencies.
+type CanaryLatency struct {
+	P50     time.Duration
+	P95     time.Duration
+	Samples int
+}
+
+// NewCanary returns a Canary sending its events to siteID with submit, the
+// way accepted events are, and querying store for them.
+func NewCanary(siteID string, submit func(ctx context.Context, trk Tracking) error, store EventStore) *Canary {
+	return &Canary{
+		siteID:  siteID,
+		submit:  submit,
+		store:   store,
+		samples: make([]time.Duration, 0, canarySamples),
+		log:     slog.Default().With(slog.String("component", "Canary")),
+	}
+}
+
+// Probe submits a canary event and polls until a query returns it. It
+// returns the latency, which is recorded for the percentiles.
+func (c *Canary) Probe(ctx context.Context) (time.Duration, error) {
+	sent := clock.Now()
+	path := fmt.Sprintf("/canary/%d", sent.UnixNano())
+	trk := Tracking{
+		SiteID: c.siteID,
+		Action: TrackingData{
+			Type:      PageviewType,
+			Identity:  "canary",
+			Event:     path,
+			Category:  PageviewCategory,
+			Source:    "server",
+			OccuredAt: TimeToInt(sent),
+		},
+	}
+	if err := c.submit(ctx, trk); err != nil {
+		return 0, fmt.Errorf("failed to submit canary event: %w", err)
+	}
+	canaryStats.Add("sent", 1)
+
+	q := ValuesQuery{SiteID: c.siteID, Field: "path", Prefix: path, Start: trk.Action.OccuredAt, End: trk.Action.OccuredAt, Limit: 1}
+	deadline := sent.Add(canaryTimeout)
+	for {
+		values, err := c.store.Values(ctx, q)
+		if err == nil && len(values) > 0 {
+			latency := clock.Now().Sub(sent)
+			c.record(latency)
+			canaryStats.Add("seen", 1)
+			return latency, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			c.log.Warn("Canary query failed", slog.Any("error", err))
+		}
+		if !clock.Now().Before(deadline) {
+			canaryStats.Add("lost", 1)
+			return 0, errCanaryLost
+		}
+
+		timer := clock.NewTimer(canaryPoll)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func (c *Canary) record(latency time.Duration) {
+	c.lock.Lock()
+	if len(c.samples) < canarySamples {
+		c.samples = append(c.samples, latency)
+	} else {
+		c.samples[c.next] = latency
+	}
+	c.next = (c.next + 1) % canarySamples
+	c.lock.Unlock()
+
+	l := c.Latency()
+	canaryP50.Set(l.P50.Seconds())
+	canaryP95.Set(l.P95.Seconds())
+}
+
+// Latency returns the latency percentiles of the recent probes, zero before
+// the first one showed up.
+func (c *Canary) Latency() CanaryLatency {
+	c.lock.Lock()
+	sorted := append([]time.Duration(nil), c.samples...)
+	c.lock.Unlock()
+
+	if len(sorted) == 0 {
+		return CanaryLatency{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return CanaryLatency{P50: percentile(sorted, 50), P95: percentile(sorted, 95), Samples: len(sorted)}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Run probes every interval until ctx is done. Probes run one at a time, a
+// probe outlasting the interval delays the next one.
+func (c *Canary) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			latency, err := c.Probe(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					c.log.Error("Canary probe failed", slog.Any("error", err))
+				}
+				continue
+			}
+			c.log.Debug("Canary probe seen", slog.Duration("latency", latency))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestCanaryProbe(t *testing.T) {
+	mem := NewMemoryEvents()
+	submit := func(ctx context.Context, trk Tracking) error {
+		return mem.Add(ctx, trk, useragent.UserAgent{}, nil)
+	}
+	c := NewCanary("_canary", submit, mem)
+
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Latency(); got.Samples != 1 {
+		t.Errorf("got %+v, want one sample", got)
+	}
+}
+
+func TestCanaryLost(t *testing.T) {
+	defer SetClock(NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))()
+
+	// The queue lost the event, it never shows up
+	submit := func(ctx context.Context, trk Tracking) error { return nil }
+	c := NewCanary("_canary", submit, NewMemoryEvents())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := c.Probe(ctx)
+		done <- err
+	}()
+
+	fc := clock.(*FakeClock)
+	for {
+		select {
+		case err := <-done:
+			if err != errCanaryLost {
+				t.Errorf("got %v, want %v", err, errCanaryLost)
+			}
+			if c.Latency().Samples != 0 {
+				t.Error("lost probe recorded")
+			}
+			return
+		default:
+			fc.Advance(canaryPoll)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 20; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Second)
+	}
+	if got := percentile(sorted, 50); got != 10*time.Second {
+		t.Errorf("p50 = %s", got)
+	}
+	if got := percentile(sorted, 95); got != 19*time.Second {
+		t.Errorf("p95 = %s", got)
+	}
+	if got := percentile(sorted[:1], 95); got != time.Second {
+		t.Errorf("p95 of one = %s", got)
+	}
+}
@@ -0,0 +1,168 @@
+package tracker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for everything time dependent: date bucketing,
+// batching timers and background jobs. Tests swap it with a FakeClock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+var clock Clock = systemClock{}
+
+// SetClock replaces the package clock and returns a function restoring the
+// previous one.
+func SetClock(c Clock) (restore func()) {
+	prev := clock
+	clock = c
+	return func() { clock = prev }
+}
+
+// Now returns the current time of the package clock.
+func Now() time.Time {
+	return clock.Now()
+}
+
+// Today returns the current day as YYYYMMDD.
+func Today() uint32 {
+	return TimeToInt(clock.Now())
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
+
+// FakeClock only moves when Advance is called, firing the timers and tickers
+// that become due on the way.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration // 0 for timers
+	active bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{f.add(d, d)}
+}
+
+func (f *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	w := &fakeWaiter{clock: f, c: make(chan time.Time, 1), when: f.now.Add(d), period: period, active: true}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		// Fire due waiters in order, tickers may be due several times
+		sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].when.Before(f.waiters[j].when) })
+		var next *fakeWaiter
+		for _, w := range f.waiters {
+			if w.active && !w.when.After(end) {
+				next = w
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		f.now = next.when
+		select {
+		case next.c <- next.when:
+		default: // Like time.Ticker, drop ticks nobody received
+		}
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			next.active = false
+		}
+	}
+	f.now = end
+}
+
+// fakeTicker hides the timer's Stop result to satisfy Ticker.
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.fakeWaiter.Stop() }
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+
+	wasActive := w.active
+	w.active = false
+	return wasActive
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+
+	wasActive := w.active
+	w.active = true
+	w.when = w.clock.now.Add(d)
+	return wasActive
+}
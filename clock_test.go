@@ -0,0 +1,193 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	timer := fc.NewTimer(10 * time.Second)
+
+	fc.Advance(9 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	fc.Advance(time.Second)
+	select {
+	case got := <-timer.C():
+		if want := start.Add(10 * time.Second); !got.Equal(want) {
+			t.Errorf("timer fired at %s, want %s", got, want)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+
+	if timer.Reset(5*time.Second) != false {
+		t.Error("Reset of a fired timer reported it as active")
+	}
+	if timer.Stop() != true {
+		t.Error("Stop of a reset timer reported it as inactive")
+	}
+	fc.Advance(time.Minute)
+	select {
+	case <-timer.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	ticker := fc.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	ticks := 0
+	for i := 0; i < 3; i++ {
+		fc.Advance(time.Second)
+		select {
+		case <-ticker.C():
+			ticks++
+		default:
+		}
+	}
+	if ticks != 3 {
+		t.Errorf("got %d ticks, want 3", ticks)
+	}
+}
+
+func TestToday(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC))
+	defer SetClock(fc)()
+
+	if got := Today(); got != 20240229 {
+		t.Errorf("Today() = %d, want 20240229", got)
+	}
+	fc.Advance(time.Second)
+	if got := Today(); got != 20240301 {
+		t.Errorf("Today() = %d, want 20240301", got)
+	}
+}
+
+func TestReplaysWindow(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	r := NewReplays(10 * time.Second)
+
+	r.Remember("a", "s", now)
+	tests := []struct {
+		after time.Duration
+		seen  bool
+	}{
+		{0, true},
+		{9 * time.Second, true},
+		{15 * time.Second, true}, // previous generation
+		{25 * time.Second, false},
+	}
+	for _, tt := range tests {
+		if got := r.Seen("a", now.Add(tt.after)); got != tt.seen {
+			t.Errorf("Seen after %s = %v, want %v", tt.after, got, tt.seen)
+		}
+	}
+}
+
+func TestReplaysForget(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	r := NewReplays(10 * time.Second)
+
+	r.Remember("a", "deleted", now)
+	r.Remember("b", "kept", now.Add(11*time.Second))
+	r.Remember("c", "deleted", now.Add(11*time.Second))
+	r.Forget("deleted")
+	for fp, want := range map[string]bool{"a": false, "b": true, "c": false} {
+		if got := r.Seen(fp, now.Add(12*time.Second)); got != want {
+			t.Errorf("Seen(%q) = %v, want %v", fp, got, want)
+		}
+	}
+}
+
+func TestQuotaMonthRollover(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+	config.QuotaMode = QuotaHard
+	config.DefaultMonthlyQuota = 2
+
+	q := &Quotas{}
+	if err := q.Load("", &Sites{}); err != nil {
+		t.Fatal(err)
+	}
+
+	endOfMonth := time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC)
+	q.Record("site", endOfMonth)
+	q.Record("site", endOfMonth)
+	if !q.Exceeded("site", endOfMonth) {
+		t.Error("quota not exceeded after 2 events with a quota of 2")
+	}
+	if q.Exceeded("site", endOfMonth.Add(time.Minute)) {
+		t.Error("quota still exceeded in the next month")
+	}
+}
+
+func TestRawCutoff(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	if got := rawCutoff(now); got != 0 {
+		t.Errorf("rawCutoff without retention = %d, want 0", got)
+	}
+
+	config.RawRetentionDays = 1
+	if got := rawCutoff(now); got != 20240229 {
+		t.Errorf("rawCutoff = %d, want 20240229", got)
+	}
+	if !useRollups(MetricData{What: QueryBrowsers, Start: 20240228, End: 20240301}, now) {
+		t.Error("range older than the cutoff not served from rollups")
+	}
+	if useRollups(MetricData{What: QueryBrowsers, Start: 20240229, End: 20240301}, now) {
+		t.Error("range within raw retention served from rollups")
+	}
+}
+
+func TestRollupQualities(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+	defer SetClock(NewFakeClock(time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)))()
+	config.RawRetentionDays = 1
+
+	// Only ok events are rolled up, other qualities can't be counted from
+	// the rollups
+	e := &Events{}
+	data := MetricData{What: QueryBrowsers, SiteID: "s", Start: 20240228, End: 20240301, Qualities: []string{QualityOK, QualityBot}}
+	if _, err := e.GetStats(context.Background(), data); !errors.Is(err, ErrInvalid) {
+		t.Errorf("stats: got %v", err)
+	}
+	q := TimeSeriesQuery{SiteID: "s", Start: 20240228, End: 20240301, Qualities: []string{QualityBot}}
+	if _, err := e.TimeSeries(context.Background(), q); !errors.Is(err, ErrInvalid) {
+		t.Errorf("time series: got %v", err)
+	}
+	if err := rollupQualities(nil); err != nil {
+		t.Errorf("ok only: got %v", err)
+	}
+}
+
+func TestRebuildRollupsRange(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+
+	e := &Events{}
+	if err := e.RebuildRollups(context.Background(), "site", 20240302, 20240301); !errors.Is(err, ErrInvalid) {
+		t.Errorf("reversed range: got %v", err)
+	}
+
+	config.RawRetentionDays = 1
+	old := TimeToInt(clock.Now().AddDate(0, 0, -2))
+	if err := e.RebuildRollups(context.Background(), "site", old, old); !errors.Is(err, ErrInvalid) {
+		t.Errorf("range without raw events: got %v", err)
+	}
+}
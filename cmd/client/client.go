@@ -36,8 +36,8 @@ func getMetric(what tracker.QueryType) ([]tracker.Metric, error) {
 	}
 	defer resp.Body.Close()
 
-	var metrics []tracker.Metric
-	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+	var result tracker.StatsResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		b, err := io.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
@@ -46,5 +46,5 @@ func getMetric(what tracker.QueryType) ([]tracker.Metric, error) {
 		return nil, err
 	}
 
-	return metrics, nil
+	return result.Data, nil
 }
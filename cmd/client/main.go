@@ -57,6 +57,9 @@ func main() {
 		case "c":
 			what = tracker.QueryCountry
 			renderPie("Countries")
+		case "d":
+			what = tracker.QueryTouch
+			renderPie("Touch devices")
 		case "r":
 			what = tracker.QueryReferrerHost
 			metrics, err := getMetric(what)
@@ -103,7 +106,8 @@ func main() {
 				dateRangeMode = 0
 			}
 
-			end = int64(tracker.TimeToInt(time.Now()))
+			today := tracker.TimeToInt(time.Now())
+			end = int64(today)
 			days := 30
 			if dateRangeMode == 1 {
 				days = 90
@@ -113,7 +117,7 @@ func main() {
 				days = 365
 			}
 
-			start = int64(tracker.TimeToInt(time.Now().Add(-24 * time.Duration(days) * time.Hour)))
+			start = int64(tracker.AddDays(today, -days))
 
 			ui.Clear()
 			p := widgets.NewParagraph()
@@ -241,7 +241,7 @@ func main() {
 				slog.String("event", event.Action.Event),
 				slog.String("identity", event.Action.Identity), // Log identity being sent
 			}
-			if resp.StatusCode == http.StatusOK {
+			if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusAlreadyReported {
 				logger.LogAttrs(nil, slog.LevelDebug, "Event sent successfully", logAttrs...)
 				successCount++
 			} else {
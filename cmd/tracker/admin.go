@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tracker"
+)
+
+// queue is the store events are queued in before they are written, nil when
+// it doesn't queue them.
+var queue tracker.Queued
+
+// adminHandler serves the admin port. It is meant for operators and should
+// not be exposed publicly, requests still need the API key.
+func adminHandler(ingest bool) http.Handler {
+	mux := http.NewServeMux()
+	if ingest {
+		mux.HandleFunc("GET /queue", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminQueue)))
+		mux.HandleFunc("POST /queue/flush", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminFlush)))
+		mux.HandleFunc("GET /canary", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminCanary)))
+	}
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// queueStatus is the state of the ingest pipeline.
+type queueStatus struct {
+	// Events waiting to be written
+	Length           int     `json:"length"`
+	OldestAgeSeconds float64 `json:"oldestAgeSeconds"`
+	// Events waiting for geo enrichment, they are queued afterwards
+	Enriching int `json:"enriching"`
+	// Events spilled to disk while the queue was full
+	Spilled int `json:"spilled"`
+	// Imported events waiting in the bulk lane
+	Importing int `json:"importing"`
+}
+
+func currentQueueStatus() queueStatus {
+	var status queueStatus
+	if queue != nil {
+		stats := queue.QueueStats()
+		status.Length = stats.Length
+		status.OldestAgeSeconds = stats.OldestAge.Seconds()
+		status.Spilled = stats.Spilled
+	}
+	if enricher != nil {
+		status.Enriching = enricher.Len()
+	}
+	if bulk != nil {
+		status.Importing = bulk.Len()
+	}
+	return status
+}
+
+// adminQueue reports how many events are waiting to be written and how long
+// the oldest has been waiting.
+func adminQueue(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, currentQueueStatus())
+}
+
+// adminFlush writes the queued events now, before planned maintenance for
+// instance, and reports the queue afterwards.
+func adminFlush(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	if queue == nil {
+		writeJSON(w, requestLogger, http.StatusOK, currentQueueStatus())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	flushed := queue.QueueStats().Length
+	if err := queue.Flush(ctx); err != nil {
+		requestLogger.Error("Failed to flush event queue", slog.Any("error", err))
+		http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger.Info("Flushed event queue", slog.Int("events", flushed))
+	writeJSON(w, requestLogger, http.StatusOK, currentQueueStatus())
+}
+
+// adminUsage exports ingested events per site and day or month for billing.
+// Query parameters: from and to as YYYYMMDD (defaults to the last 30 days),
+// site to restrict to one site, period=day|month and format=json|csv.
+func adminUsage(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	if site := params.Get("site"); site != "" && !permitted(w, r, tracker.RoleOwner, site) {
+		return
+	}
+	now := tracker.Now()
+	q := tracker.UsageQuery{
+		SiteID: params.Get("site"),
+		Start:  tracker.TimeToInt(now.AddDate(0, 0, -30)),
+		End:    tracker.TimeToInt(now),
+	}
+
+	var err error
+	if q.Start, err = dayParam(params.Get("from"), q.Start); err != nil {
+		http.Error(w, "Bad Request: from must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if q.End, err = dayParam(params.Get("to"), q.End); err != nil {
+		http.Error(w, "Bad Request: to must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+
+	switch params.Get("period") {
+	case "", "day":
+	case "month":
+		q.Monthly = true
+	default:
+		http.Error(w, "Bad Request: period must be day or month", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := events.Usage(r.Context(), q)
+	if err != nil {
+		requestLogger.Error("Failed to get usage from database", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Keys only see the sites they own
+	if p, _ := principalOf(r); !p.Superuser {
+		owned := usage[:0]
+		for _, u := range usage {
+			if p.Can(siteOf(u.SiteID), tracker.RoleOwner) {
+				owned = append(owned, u)
+			}
+		}
+		usage = owned
+	}
+
+	if params.Get("format") == "csv" || r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=usage-%d-%d.csv", q.Start, q.End))
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"site_id", "period", "events"})
+		for _, u := range usage {
+			cw.Write([]string{u.SiteID, u.Period, strconv.FormatUint(u.Events, 10)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			requestLogger.Error("Failed to write usage CSV", slog.Any("error", err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		requestLogger.Error("Failed to encode usage response", slog.Any("error", err))
+	}
+}
+
+// dayParam parses a YYYYMMDD query parameter, returning def when it is empty.
+func dayParam(v string, def uint32) (uint32, error) {
+	if v == "" {
+		return def, nil
+	}
+	day, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tracker.ParseDay(uint32(day)); err != nil {
+		return 0, err
+	}
+	return uint32(day), nil
+}
+
+// maxRangeDays bounds the days a query over a range of days spans.
+const maxRangeDays = 366
+
+// validRange answers 400 unless siteID is set and start and end are days at
+// most a year apart, as queries over a range of days of a site require.
+func validRange(w http.ResponseWriter, siteID string, start, end uint32) bool {
+	_, startErr := tracker.ParseDay(start)
+	_, endErr := tracker.ParseDay(end)
+	if siteID == "" || startErr != nil || endErr != nil || end < start || tracker.DaysBetween(start, end) > maxRangeDays {
+		http.Error(w, "Bad Request: siteId and a start and end at most a year apart are required", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
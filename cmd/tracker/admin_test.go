@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tracker"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestAdminQueue(t *testing.T) {
+	setupTrack()
+	queue = events.(tracker.Queued)
+	defer func() { queue = nil }()
+
+	h := adminHandler(true)
+	for _, req := range []struct{ method, path string }{{"GET", "/queue"}, {"POST", "/queue/flush"}} {
+		r := httptest.NewRequest(req.method, req.path, nil)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: got status %d", req.method, req.path, w.Code)
+		}
+		var status queueStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s %s: %v", req.method, req.path, err)
+		}
+	}
+
+	// Flushing is a POST
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/queue/flush", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /queue/flush: got status %d", w.Code)
+	}
+}
+
+func TestAudited(t *testing.T) {
+	setupTrack()
+	mem := events.(*tracker.MemoryEvents)
+	auditor, auditLog = tracker.NewAuditor(mem), mem
+	ctx, cancel := context.WithCancel(context.Background())
+	go auditor.Run(ctx)
+	defer func() { auditor, auditLog = nil, nil }()
+
+	body := `{"What":"pages","SiteID":"audited-site","Start":20240301,"End":20240301}`
+	r := httptest.NewRequest("POST", "/stats", strings.NewReader(body))
+	r.Header.Set("X-API-KEY", "wrong")
+	audited(stats)(httptest.NewRecorder(), r)
+
+	cancel()
+	auditor.Wait()
+
+	got, err := mem.Audit(context.Background(), tracker.AuditQuery{
+		Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour), SiteID: "audited-site", Limit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Actor != "invalid-key" || got[0].Status != http.StatusUnauthorized || got[0].Query != body {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestAuditedSecrets(t *testing.T) {
+	setupTrack()
+	mem := events.(*tracker.MemoryEvents)
+	auditor, auditLog = tracker.NewAuditor(mem), mem
+	ctx, cancel := context.WithCancel(context.Background())
+	go auditor.Run(ctx)
+	defer func() { auditor, auditLog = nil, nil }()
+
+	start := time.Now()
+	const secret = "s3cr3t-signing-value"
+	noop := func(w http.ResponseWriter, r *http.Request) { io.Copy(io.Discard, r.Body) }
+	for _, req := range []struct{ path, body string }{
+		{"/admin/apply", `{"sites":[{"id":"audited-secrets"}],"keys":[{"name":"ci","signingSecrets":[{"id":"1","secret":"` + secret + `"}]}]}`},
+		{"/sites/audited-secrets/keys/write", `{"siteId":"audited-secrets","key":"` + secret + `"}`},
+		{"/admin/keys", `{"siteId":"audited-secrets","name":"ci","signingSecrets":[{"secret":"` + secret + `"}]}`},
+		{"/stats", `{"siteId":"audited-secrets","nested":{"apiKey":"` + secret + `"}}`},
+		{"/stats", `{"siteId":"audited-secrets","token":"` + secret},
+	} {
+		audited(noop)(httptest.NewRecorder(), httptest.NewRequest("POST", req.path, strings.NewReader(req.body)))
+	}
+
+	cancel()
+	auditor.Wait()
+	got, err := mem.Audit(context.Background(), tracker.AuditQuery{
+		Start: start, End: time.Now().Add(time.Hour), Limit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("got %d entries", len(got))
+	}
+	for _, entry := range got {
+		if strings.Contains(entry.Query, secret) {
+			t.Errorf("%s: secret recorded in %q", entry.Path, entry.Query)
+		}
+	}
+	// Newest first, the other fields are kept
+	if want := `{"nested":{"apiKey":"[redacted]"},"siteId":"audited-secrets"}`; got[1].Query != want {
+		t.Errorf("got %q, want %q", got[1].Query, want)
+	}
+}
+
+func TestAdminCanary(t *testing.T) {
+	setupTrack()
+	h := adminHandler(true)
+	get := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/canary", nil)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Errorf("disabled: got status %d", w.Code)
+	}
+
+	canary = tracker.NewCanary("_canary", func(ctx context.Context, trk tracker.Tracking) error {
+		return events.Add(ctx, trk, useragent.UserAgent{}, nil)
+	}, events)
+	defer func() { canary = nil }()
+	if _, err := canary.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := get()
+	var status canaryStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || w.Code != http.StatusOK || status.Samples != 1 {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminUsage(t *testing.T) {
+	setupTrack()
+	mem := events.(*tracker.MemoryEvents)
+	for _, day := range []uint32{20230301, 20230302, 20230302, 20230401} {
+		trk := tracker.Tracking{SiteID: "billed-site", Action: tracker.TrackingData{OccuredAt: day, Event: "/", Category: tracker.PageviewCategory}}
+		if err := mem.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/admin/usage?site=billed-site&"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		adminUsage(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, tracker.Principal{Superuser: true})))
+		return w
+	}
+
+	row := func(period string, events uint64) tracker.UsageRow {
+		return tracker.UsageRow{SiteID: "billed-site", Period: period, Events: events}
+	}
+	tests := []struct {
+		query string
+		want  []tracker.UsageRow
+	}{
+		{"from=20230301&to=20230331", []tracker.UsageRow{row("20230301", 1), row("20230302", 2)}},
+		{"from=20230302&to=20230302", []tracker.UsageRow{row("20230302", 2)}},
+		{"from=20230301&to=20230430&period=month", []tracker.UsageRow{row("202303", 3), row("202304", 1)}},
+		{"from=20230501&to=20230531", []tracker.UsageRow{}},
+	}
+	for _, tt := range tests {
+		w := get(tt.query, "")
+		var got []tracker.UsageRow
+		if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s: got %d %s", tt.query, w.Code, w.Body)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	want := "site_id,period,events\nbilled-site,202303,3\n"
+	for _, w := range []*httptest.ResponseRecorder{
+		get("from=20230301&to=20230331&period=month&format=csv", ""),
+		get("from=20230301&to=20230331&period=month", "text/csv"),
+	} {
+		if w.Code != http.StatusOK || w.Body.String() != want || w.Header().Get("Content-Type") != "text/csv" {
+			t.Errorf("CSV: got %d %q", w.Code, w.Body)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=usage-20230301-20230331.csv" {
+			t.Errorf("CSV: got Content-Disposition %q", got)
+		}
+	}
+
+	for _, query := range []string{"from=2023-03-01", "to=20231301", "period=week"} {
+		if w := get(query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", query, w.Code)
+		}
+	}
+}
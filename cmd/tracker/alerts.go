@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// alertRules lists a site's alert rules (GET ?site=) and creates rules
+// (POST).
+func alertRules(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site")
+		if siteID == "" {
+			http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, siteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, saved.Rules(siteID))
+	case http.MethodPost:
+		var rule tracker.AlertRule
+		if !decodeJSON(w, r, &rule) {
+			return
+		}
+		if !permitted(w, r, tracker.RoleAdmin, rule.SiteID) {
+			return
+		}
+		rule.ID = ""
+		putRule(w, requestLogger, rule, http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// alertRule reads (GET), replaces (PUT) and deletes (DELETE) /alerts/{id}.
+func alertRule(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	id := r.PathValue("id")
+	// Changing a rule takes admin on its site, the site itself can't change
+	if prev, ok := saved.Rule(id); ok && r.Method != http.MethodGet && !permitted(w, r, tracker.RoleAdmin, prev.SiteID) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rule, ok := saved.Rule(id)
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, rule.SiteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, rule)
+	case http.MethodPut:
+		var rule tracker.AlertRule
+		if !decodeJSON(w, r, &rule) {
+			return
+		}
+		rule.ID = id
+		putRule(w, requestLogger, rule, http.StatusOK)
+	case http.MethodDelete:
+		savedError(w, requestLogger, saved.DeleteRule(id), http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func putRule(w http.ResponseWriter, requestLogger *slog.Logger, rule tracker.AlertRule, status int) {
+	rule, err := saved.PutRule(rule)
+	if err != nil {
+		savedError(w, requestLogger, err, status)
+		return
+	}
+	writeJSON(w, requestLogger, status, rule)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestAlertRules(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alerts", requireRole(tracker.RoleViewer, tracker.RoleAdmin, alertRules))
+	mux.HandleFunc("/alerts/{id}", requireRole(tracker.RoleViewer, tracker.RoleAdmin, alertRule))
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("POST", "/alerts", `{"siteId":"alert-site","name":"Spike","metric":"pageviews","condition":"rise","threshold":200,"channels":[{"type":"webhook","target":"https://example.com/hook"}]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d: %s", w.Code, w.Body)
+	}
+	var rule tracker.AlertRule
+	json.Unmarshal(w.Body.Bytes(), &rule)
+
+	var list []tracker.AlertRule
+	json.Unmarshal(call("GET", "/alerts?site=alert-site", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != rule.ID {
+		t.Errorf("list: got %+v", list)
+	}
+
+	if w := call("PUT", "/alerts/"+rule.ID, `{"siteId":"alert-site","name":"Spike","metric":"pageviews","condition":"rise","threshold":200,"channels":[{"type":"email","target":"ops@example.com"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("email without SMTP_ADDR: got status %d", w.Code)
+	}
+	if w := call("PUT", "/alerts/missing", `{"siteId":"alert-site","name":"Spike","metric":"pageviews","condition":"above","channels":[{"type":"webhook","target":"https://example.com/hook"}]}`); w.Code != http.StatusNotFound {
+		t.Errorf("missing rule: got status %d", w.Code)
+	}
+	if w := call("DELETE", "/alerts/"+rule.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: got status %d", w.Code)
+	}
+	if w := call("GET", "/alerts/"+rule.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("after delete: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tracker"
+)
+
+// auditor records stats and admin API requests in auditLog, both are nil
+// when the store keeps no audit log.
+var (
+	auditor  *tracker.Auditor
+	auditLog tracker.AuditStore
+)
+
+const (
+	// Request bodies are kept in the audit log up to maxAuditQuery bytes
+	maxAuditQuery = 4096
+	maxAuditLimit = 1000
+)
+
+// statusRecorder remembers the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// audited records every request to h in the audit log, including the
+// rejected ones.
+func audited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auditor == nil {
+			h(w, r)
+			return
+		}
+
+		// The handler still reads the whole body
+		var body []byte
+		if r.Body != nil && r.Method != http.MethodGet {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, maxAuditQuery))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+
+		query := r.URL.RawQuery
+		if recorded := auditBody(r.URL.Path, body); recorded != "" {
+			if query != "" {
+				query += " "
+			}
+			query += recorded
+		}
+		if len(query) > maxAuditQuery {
+			query = query[:maxAuditQuery]
+		}
+		entry := tracker.AuditEntry{
+			Actor:    actor(r),
+			RemoteIP: remoteIP(r),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			SiteID:   auditSite(r, body),
+			Query:    query,
+			Status:   rec.status,
+		}
+		// Recorded even when the client already went away
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		auditor.Record(ctx, entry)
+	}
+}
+
+// unauditedBodies are the paths whose bodies carry site keys, tokens or
+// signing secrets, they are never recorded.
+var unauditedBodies = []string{"/admin/apply", "/admin/config", "/sites"}
+
+// auditBody returns what is recorded of the body of a request to path: the
+// JSON body with the values of secret, key, token and password fields
+// redacted. Bodies that aren't JSON, or were cut at maxAuditQuery, can't be
+// redacted and aren't recorded.
+func auditBody(path string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	for _, prefix := range unauditedBodies {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return ""
+		}
+	}
+
+	var v any
+	if json.Unmarshal(body, &v) != nil {
+		return ""
+	}
+	if !redact(v) {
+		return string(body)
+	}
+	redacted, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(redacted)
+}
+
+// redact replaces the values of sensitive fields in v, decoded JSON, and
+// reports whether it found any.
+func redact(v any) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		for name, field := range v {
+			lower := strings.ToLower(name)
+			if strings.Contains(lower, "secret") || strings.Contains(lower, "key") || strings.Contains(lower, "token") || strings.Contains(lower, "password") {
+				v[name] = "[redacted]"
+				found = true
+				continue
+			}
+			found = redact(field) || found
+		}
+	case []any:
+		for _, item := range v {
+			found = redact(item) || found
+		}
+	}
+	return found
+}
+
+// actor names who made a request: the name of its API key, "api-key" for
+// API_KEY or the email of a signed in user. Rejected requests are told apart
+// by what they carried.
+func actor(r *http.Request) string {
+	p, ok := principalOf(r)
+	switch {
+	case ok:
+		return p.Name
+	case r.Header.Get("X-API-KEY") != "":
+		return "invalid-key"
+	case sessionToken(r) != "":
+		return "invalid-session"
+	default:
+		return "anonymous"
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	ip, err := tracker.IPFromRequest(ipHeaders, r, forceIP)
+	if err != nil || ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// auditSite returns the site a request is about, from the site query
+// parameter or the site ID field of a JSON body.
+func auditSite(r *http.Request, body []byte) string {
+	if site := r.URL.Query().Get("site"); site != "" {
+		return site
+	}
+
+	var fields map[string]any
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	for key, v := range fields {
+		switch strings.ToLower(key) {
+		case "siteid", "site_id", "site":
+			if site, ok := v.(string); ok {
+				return site
+			}
+		}
+	}
+	return ""
+}
+
+// adminAudit returns audit log entries, newest first. Query parameters: from
+// and to as YYYYMMDD (defaults to the last 7 days), site and actor to filter
+// on and limit (defaults to 100, at most 1000).
+func adminAudit(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	// Without a site every entry is returned, that's for API_KEY only
+	var siteIDs []string
+	if site := r.URL.Query().Get("site"); site != "" {
+		siteIDs = append(siteIDs, site)
+	}
+	if !permitted(w, r, tracker.RoleOwner, siteIDs...) {
+		return
+	}
+	if auditLog == nil {
+		http.Error(w, "Not Found: audit log is not kept", http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	now := tracker.Now()
+	from, to := tracker.TimeToInt(now.AddDate(0, 0, -7)), tracker.TimeToInt(now)
+	var err error
+	if from, err = dayParam(params.Get("from"), from); err != nil {
+		http.Error(w, "Bad Request: from must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if to, err = dayParam(params.Get("to"), to); err != nil {
+		http.Error(w, "Bad Request: to must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	q := tracker.AuditQuery{
+		SiteID: params.Get("site"),
+		Actor:  params.Get("actor"),
+		Limit:  100,
+	}
+	start, _ := tracker.ParseDay(from)
+	end, _ := tracker.ParseDay(to)
+	q.Start, q.End = start, end.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxAuditLimit {
+			http.Error(w, "Bad Request: limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := auditLog.Audit(r.Context(), q)
+	if err != nil {
+		requestLogger.Error("Failed to get audit log from database", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, entries)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// keys are the API keys of KEYS_FILE, granted roles on sites. API_KEY is
+// accepted as well and allowed everything, and the keys of sites on their
+// site.
+var keys tracker.Keys
+
+type principalKey struct{}
+
+// authenticate returns who the API key, the signature or the session of a
+// request belongs to.
+func authenticate(r *http.Request) (tracker.Principal, bool) {
+	if r.Header.Get(tracker.KeyIDHeader) != "" {
+		return authenticateSigned(r)
+	}
+
+	key := r.Header.Get("X-API-KEY")
+	if key == "" && sessions != nil {
+		if token := sessionToken(r); token != "" {
+			claims, err := sessions.Parse(token, tracker.SessionUse, tracker.Now())
+			if err != nil {
+				return tracker.Principal{}, false
+			}
+			return users.Lookup(claims.Email), true
+		}
+	}
+
+	// Without API_KEY, KEYS_FILE, site keys nor sign-in the API is open, as
+	// it always was
+	open := keys.Len() == 0 && sessions == nil
+	if apiKey := tracker.GetConfig().APIKey; key == apiKey && (key != "" || open && !sites.HasKeys()) {
+		return tracker.Principal{Name: "api-key", Superuser: true}, true
+	}
+	if p, ok := keys.Lookup(key); ok {
+		return p, true
+	}
+	return sites.LookupKey(key)
+}
+
+// authenticateSigned verifies the signature of a request made with a
+// signing secret. The body is read to hash it and put back for the handler.
+func authenticateSigned(r *http.Request) (tracker.Principal, bool) {
+	var body []byte
+	if r.Body != nil {
+		limit := tracker.GetConfig().MaxBatchBytes
+		b, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		r.Body.Close()
+		if err != nil || int64(len(b)) > limit {
+			return tracker.Principal{}, false
+		}
+		body = b
+		r.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	p, err := keys.VerifyRequest(r, body, tracker.Now())
+	if err != nil {
+		logger.Warn("Rejected signed API request", slog.String("path", r.URL.Path), slog.String("keyId", r.Header.Get(tracker.KeyIDHeader)), slog.Any("error", err))
+		return tracker.Principal{}, false
+	}
+	return p, true
+}
+
+// principalOf returns who made a request, as authenticated by requireRole.
+func principalOf(r *http.Request) (tracker.Principal, bool) {
+	if p, ok := r.Context().Value(principalKey{}).(tracker.Principal); ok {
+		return p, true
+	}
+	return authenticate(r)
+}
+
+// requireRole rejects requests to h whose API key doesn't have role on at
+// least one site, read for GET and HEAD requests and write for the others.
+// Handlers check the role on the sites a request is about with permitted.
+func requireRole(read, write tracker.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			role = read
+		}
+
+		p, ok := authenticate(r)
+		if !ok {
+			logger.Warn("Unauthorized API access attempt", slog.String("path", r.URL.Path))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.CanAny(role) {
+			logger.Warn("Forbidden API access attempt", slog.String("path", r.URL.Path), slog.String("actor", p.Name))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// permitted checks the request's API key has role on every one of siteIDs,
+// answering 401 or 403 otherwise. Without siteIDs only API_KEY is permitted.
+func permitted(w http.ResponseWriter, r *http.Request, role tracker.Role, siteIDs ...string) bool {
+	p, ok := principalOf(r)
+	if !ok {
+		logger.Warn("Unauthorized API access attempt", slog.String("path", r.URL.Path))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	allowed := p.Superuser || len(siteIDs) > 0
+	for _, id := range siteIDs {
+		if allowed && !p.Can(siteOf(id), role) {
+			allowed = false
+		}
+	}
+	if !allowed {
+		logger.Warn("Forbidden API access attempt", slog.String("path", r.URL.Path), slog.String("actor", p.Name))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+	return allowed
+}
+
+// siteOf returns the registered site id, or a site of no org when it isn't
+// registered so only grants on the site itself apply.
+func siteOf(id string) tracker.Site {
+	if site, ok := sites.Get(id); ok {
+		return site
+	}
+	return tracker.Site{ID: id}
+}
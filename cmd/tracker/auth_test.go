@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestPermissions(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+	setConfig(t, "API_KEY", "root")
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[
+		{"id":"v","keyHash":"` + tracker.HashKey("viewer") + `","grants":[{"site":"a","role":"viewer"}]},
+		{"id":"a","keyHash":"` + tracker.HashKey("admin") + `","grants":[{"site":"a","role":"admin"}]},
+		{"id":"o","keyHash":"` + tracker.HashKey("owner") + `","grants":[{"site":"a","role":"owner"}]}
+	]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Load("")
+
+	viewer := func(h http.HandlerFunc) http.HandlerFunc {
+		return requireRole(tracker.RoleViewer, tracker.RoleViewer, h)
+	}
+	owner := func(h http.HandlerFunc) http.HandlerFunc {
+		return requireRole(tracker.RoleOwner, tracker.RoleOwner, h)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", viewer(stats))
+	mux.HandleFunc("/segments", requireRole(tracker.RoleViewer, tracker.RoleAdmin, segments))
+	mux.HandleFunc("/admin/usage", owner(adminUsage))
+	mux.HandleFunc("/admin/audit", owner(adminAudit))
+
+	statsOf := func(site string) string {
+		return `{"what":"pages","siteId":"` + site + `","start":20240301,"end":20240301}`
+	}
+	segmentOf := func(site string) string { return `{"siteId":"` + site + `","name":"s"}` }
+
+	type request struct{ method, path, body string }
+	readStats := request{"POST", "/stats", statsOf("a")}
+	otherStats := request{"POST", "/stats", statsOf("b")}
+	listSegments := request{"GET", "/segments?site=a", ""}
+	createSegment := request{"POST", "/segments", segmentOf("a")}
+	siteUsage := request{"GET", "/admin/usage?site=a", ""}
+	allAudit := request{"GET", "/admin/audit", ""}
+
+	tests := []struct {
+		key  string
+		req  request
+		want int
+	}{
+		{"", readStats, http.StatusUnauthorized},
+		{"wrong", readStats, http.StatusUnauthorized},
+
+		{"viewer", readStats, http.StatusOK},
+		{"viewer", otherStats, http.StatusForbidden},
+		{"viewer", listSegments, http.StatusOK},
+		{"viewer", createSegment, http.StatusForbidden},
+		{"viewer", siteUsage, http.StatusForbidden},
+
+		{"admin", createSegment, http.StatusCreated},
+		{"admin", request{"POST", "/segments", segmentOf("b")}, http.StatusForbidden},
+		{"admin", siteUsage, http.StatusForbidden},
+
+		{"owner", siteUsage, http.StatusOK},
+		{"owner", request{"GET", "/admin/usage?site=b", ""}, http.StatusForbidden},
+		{"owner", allAudit, http.StatusForbidden},
+
+		{"root", otherStats, http.StatusOK},
+		{"root", request{"POST", "/segments", segmentOf("b")}, http.StatusCreated},
+		{"root", request{"GET", "/admin/usage", ""}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.req.method, tt.req.path, strings.NewReader(tt.req.body))
+		r.Header.Set("X-API-KEY", tt.key)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%q %s %s: got status %d, want %d", tt.key, tt.req.method, tt.req.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestSignedRequest(t *testing.T) {
+	setupTrack()
+	setConfig(t, "API_KEY", "root")
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"id":"svc","grants":[{"site":"a","role":"viewer"}],"signingSecrets":[{"id":"k1","secret":"s3cret"}]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Load("")
+
+	h := requireRole(tracker.RoleViewer, tracker.RoleViewer, stats)
+	send := func(site, secret string) int {
+		body := `{"what":"pages","siteId":"` + site + `","start":20240301,"end":20240301}`
+		ts := tracker.Now().Unix()
+		r := httptest.NewRequest("POST", "/stats", strings.NewReader(body))
+		r.Header.Set(tracker.KeyIDHeader, "k1")
+		r.Header.Set(tracker.SignatureTimestampHeader, strconv.FormatInt(ts, 10))
+		r.Header.Set(tracker.SignatureHeader, tracker.SignRequest(secret, "POST", "/stats", ts, []byte(body)))
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	if code := send("a", "s3cret"); code != http.StatusOK {
+		t.Errorf("signed: got status %d", code)
+	}
+	if code := send("b", "s3cret"); code != http.StatusForbidden {
+		t.Errorf("other site: got status %d", code)
+	}
+	if code := send("a", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("bad signature: got status %d", code)
+	}
+}
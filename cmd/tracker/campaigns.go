@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tracker"
+)
+
+// campaignLink is a request to the link builder: the campaign and the page
+// the link lands on.
+type campaignLink struct {
+	tracker.Campaign
+	URL string `json:"url"`
+}
+
+// campaignLinkResult is the registered campaign and the tagged link.
+type campaignLinkResult struct {
+	Campaign tracker.Campaign `json:"campaign"`
+	URL      string           `json:"url"`
+}
+
+// campaigns lists a site's campaigns (GET ?site=) and builds campaign links
+// (POST), posted as {siteId, name, source, medium, term, content, url}.
+// Building a link registers its campaign, 201 tells it is new.
+func campaigns(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site")
+		if siteID == "" {
+			http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, siteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, saved.Campaigns(siteID))
+	case http.MethodPost:
+		var link campaignLink
+		if !decodeJSON(w, r, &link) {
+			return
+		}
+		if !permitted(w, r, tracker.RoleAdmin, link.SiteID) {
+			return
+		}
+		if site, ok := sites.Get(link.SiteID); ok && !onDomain(link.URL, site.Domain) {
+			http.Error(w, "Bad Request: url is not on the site's domain", http.StatusBadRequest)
+			return
+		}
+		tagged, err := link.Campaign.URL(link.URL)
+		if err != nil {
+			savedError(w, requestLogger, err, http.StatusOK)
+			return
+		}
+		c, created, err := saved.EnsureCampaign(link.Campaign)
+		if err != nil {
+			savedError(w, requestLogger, err, http.StatusOK)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeJSON(w, requestLogger, status, campaignLinkResult{Campaign: c, URL: tagged})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteCampaign unregisters /campaigns/{id}, its past visits stay counted.
+func deleteCampaign(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	id := r.PathValue("id")
+	if c, ok := saved.Campaign(id); ok && !permitted(w, r, tracker.RoleAdmin, c.SiteID) {
+		return
+	}
+	savedError(w, requestLogger, saved.DeleteCampaign(id), http.StatusNoContent)
+}
+
+// campaignStats returns the page views of every campaign of the site query
+// parameter between from and to (YYYYMMDD, the last 30 days by default),
+// registered campaigns without traffic included.
+func campaignStats(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	now := tracker.Now()
+	data := tracker.MetricData{What: tracker.QueryCampaigns, SiteID: params.Get("site")}
+	if data.SiteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, data.SiteID) {
+		return
+	}
+	var err error
+	if data.Start, err = dayParam(params.Get("from"), tracker.TimeToInt(now.AddDate(0, 0, -30))); err != nil {
+		http.Error(w, "Bad Request: from must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if data.End, err = dayParam(params.Get("to"), tracker.TimeToInt(now)); err != nil {
+		http.Error(w, "Bad Request: to must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+
+	result, err := events.GetStats(r.Context(), data)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get campaign stats from database", err)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, tracker.CampaignReport(result, saved.Campaigns(data.SiteID)))
+}
+
+// onDomain reports whether link is on domain or one of its subdomains. Any
+// link is accepted for sites without a domain.
+func onDomain(link, domain string) bool {
+	if domain == "" {
+		return true
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
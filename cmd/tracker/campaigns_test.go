@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestCampaigns(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+	sites.Ensure(tracker.Site{ID: "campaign-site", Domain: "shop.example"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/campaigns", requireRole(tracker.RoleViewer, tracker.RoleAdmin, campaigns))
+	mux.HandleFunc("/stats/campaigns", requireRole(tracker.RoleViewer, tracker.RoleViewer, campaignStats))
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	link := `{"siteId":"campaign-site","name":"launch","source":"newsletter","medium":"email","url":"https://www.shop.example/new"}`
+	w := call("POST", "/campaigns", link)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("build link: got status %d: %s", w.Code, w.Body)
+	}
+	var got campaignLinkResult
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if got.URL != "https://www.shop.example/new?utm_campaign=launch&utm_medium=email&utm_source=newsletter" {
+		t.Errorf("got link %s", got.URL)
+	}
+	if w := call("POST", "/campaigns", link); w.Code != http.StatusOK {
+		t.Errorf("build link again: got status %d", w.Code)
+	}
+	if w := call("POST", "/campaigns", strings.Replace(link, "www.shop.example", "evil.example", 1)); w.Code != http.StatusBadRequest {
+		t.Errorf("foreign domain: got status %d", w.Code)
+	}
+	call("POST", "/campaigns", strings.Replace(link, "launch", "winter", 1))
+
+	payload := `{"tracking":{"type":"page","event":"/new","category":"Page views","campaign":"launch"},"site_id":"campaign-site"}`
+	tw := httptest.NewRecorder()
+	track(tw, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	if tw.Code != http.StatusAccepted {
+		t.Fatalf("track: got status %d", tw.Code)
+	}
+	flushEvents(t)
+
+	w = call("GET", "/stats/campaigns?site=campaign-site", "")
+	var report []tracker.CampaignStats
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	want := []tracker.CampaignStats{{Name: "launch", Registered: true, Pageviews: 1}, {Name: "winter", Registered: true}}
+	if len(report) != 2 || report[0] != want[0] || report[1] != want[1] {
+		t.Errorf("got %+v", report)
+	}
+}
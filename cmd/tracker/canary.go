@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tracker"
+
+	"github.com/mileusna/useragent"
+)
+
+// canary probes the ingest-to-query latency, nil unless CANARY_INTERVAL is
+// set on an ingesting instance.
+var canary *tracker.Canary
+
+// startCanary probes every CANARY_INTERVAL through the enricher, the way
+// accepted events go. The returned stop waits for the current probe, the
+// enricher must not be closed while one is being submitted.
+func startCanary(ctx context.Context) (stop func()) {
+	interval := tracker.GetConfig().CanaryInterval
+	if interval <= 0 {
+		return func() {}
+	}
+	submit := func(ctx context.Context, trk tracker.Tracking) error {
+		return enricher.Submit(ctx, trk, useragent.UserAgent{}, nil)
+	}
+	canary = tracker.NewCanary(tracker.GetConfig().CanarySite, submit, events)
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		canary.Run(ctx, interval)
+	}()
+	logger.Info("Canary started", slog.String("site", tracker.GetConfig().CanarySite), slog.Duration("interval", interval))
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// canaryStatus is the ingest-to-query latency of the recent canary events.
+type canaryStatus struct {
+	P50Seconds float64 `json:"p50Seconds"`
+	P95Seconds float64 `json:"p95Seconds"`
+	Samples    int     `json:"samples"`
+}
+
+// adminCanary reports the canary latency percentiles, for SLO checks that
+// don't scrape /debug/vars.
+func adminCanary(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	if canary == nil {
+		http.Error(w, "Not Found: the canary is disabled, set CANARY_INTERVAL", http.StatusNotFound)
+		return
+	}
+	latency := canary.Latency()
+	writeJSON(w, requestLogger, http.StatusOK, canaryStatus{
+		P50Seconds: latency.P50.Round(time.Millisecond).Seconds(),
+		P95Seconds: latency.P95.Round(time.Millisecond).Seconds(),
+		Samples:    latency.Samples,
+	})
+}
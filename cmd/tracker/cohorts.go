@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// cohortStore compares cohorts, nil when the store can't.
+var cohortStore tracker.CohortStore
+
+// cohorts returns visitors, page views, sessions and bounce rate for each
+// day between start and end side by side for 2 to 4 cohorts, posted like
+// time series with cohorts: [{name, filters}] or [{segmentId}]. A saved
+// segment of the site stands for its filters, and names the cohort unless
+// it has a name.
+func cohorts(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.CohortQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode cohorts request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if q.Source != "" && !tracker.Sources[q.Source] {
+		http.Error(w, "Bad Request: unknown source", http.StatusBadRequest)
+		return
+	}
+	if q.Touch != "" && !tracker.Touches[q.Touch] {
+		http.Error(w, "Bad Request: touch must be touch or non-touch", http.StatusBadRequest)
+		return
+	}
+	if err := tracker.ValidateQualities(q.Qualities); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validRange(w, q.SiteID, q.Start, q.End) {
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+	for i, c := range q.Cohorts {
+		if c.SegmentID == "" {
+			continue
+		}
+		seg, ok := saved.Segment(c.SegmentID)
+		if !ok || seg.SiteID != q.SiteID {
+			http.Error(w, "Bad Request: unknown segment "+c.SegmentID, http.StatusBadRequest)
+			return
+		}
+		q.Cohorts[i].Filters = seg.Filters
+		if c.Name == "" {
+			q.Cohorts[i].Name = seg.Name
+		}
+	}
+	if err := q.Validate(); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cohortStore == nil {
+		http.Error(w, "Not Found: the store doesn't compare cohorts", http.StatusNotFound)
+		return
+	}
+	if notModified(w, r, q.End) {
+		return
+	}
+
+	result, err := cohortStore.Cohorts(r.Context(), q)
+	if errors.Is(err, tracker.ErrInvalid) {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get cohorts from database", err)
+		return
+	}
+	writeStats(w, r, requestLogger, q.End, result)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestCohorts(t *testing.T) {
+	setupTrack()
+	cohortStore = events.(tracker.CohortStore)
+	defer func() { cohortStore = nil }()
+	saved.Load("")
+	seg, err := saved.PutSegment(tracker.Segment{SiteID: t.Name(), Name: "Pricing", Filters: map[string]string{"path": "/pricing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []string{"/", "/pricing"} {
+		payload := `{"site_id":"` + t.Name() + `","tracking":{"type":"page","category":"Page views","ua":"Mozilla/5.0","event":"` + event + `"}}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("track: got %d", w.Code)
+		}
+	}
+	flushEvents(t)
+
+	query := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/stats/cohorts", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, cohorts)(w, r)
+		return w
+	}
+	days := fmt.Sprintf(`"start":%d,"end":%[1]d`, tracker.Today())
+	w := query(`{"siteId":"` + t.Name() + `",` + days + `,"cohorts":[{"name":"Home","filters":{"path":"/"}},{"segmentId":"` + seg.ID + `"}]}`)
+	var result tracker.CohortResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if strings.Join(result.Cohorts, ",") != "Home,Pricing" || len(result.Data) != 1 || result.Data[0].Cohorts[0].Pageviews != 1 || result.Data[0].Cohorts[1].Visitors != 1 {
+		t.Errorf("got %+v", result)
+	}
+
+	for name, cohorts := range map[string]string{
+		"one cohort":      `[{"name":"Home"}]`,
+		"unknown segment": `[{"name":"Home"},{"segmentId":"nope"}]`,
+		"unknown field":   `[{"name":"Home"},{"name":"Other","filters":{"nope":"x"}}]`,
+	} {
+		if w := query(`{"siteId":"` + t.Name() + `",` + days + `,"cohorts":` + cohorts + `}`); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", name, w.Code)
+		}
+	}
+}
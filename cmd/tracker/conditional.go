@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"tracker"
+)
+
+// Stats of a closed range, one whose last day ended everywhere, hardly
+// ever change. Their responses carry an ETag of the body and a
+// Last-Modified of when the range closed, so polling dashboards and widgets
+// can ask again with If-None-Match or If-Modified-Since and get a 304.
+// If-Modified-Since is answered without querying, If-None-Match runs the
+// query and is therefore exact even after late events. Queries posted in
+// the body share their URL, so only If-None-Match applies to them.
+
+// notModified answers 304 when the If-Modified-Since of a GET request is
+// after the range ending on end closed. It defers to If-None-Match when
+// present.
+func notModified(w http.ResponseWriter, r *http.Request, end uint32) bool {
+	closed := tracker.ClosedAt(end)
+	if r.Method != http.MethodGet || r.Header.Get("If-None-Match") != "" || tracker.Now().Before(closed) {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || since.Before(closed.Truncate(time.Second)) {
+		return false
+	}
+	w.Header().Set("Last-Modified", closed.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// writeStats writes v, the stats of a range ending on end. Once the range
+// closed the response carries an ETag and Last-Modified, and 304 answers a
+// request whose If-None-Match matches the ETag.
+func writeStats(w http.ResponseWriter, r *http.Request, requestLogger *slog.Logger, end uint32, v any) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		requestLogger.Error("Failed to encode stats response", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if closed := tracker.ClosedAt(end); !tracker.Now().Before(closed) {
+		etag := statsETag(body.Bytes())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", closed.Format(http.TimeFormat))
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body.Bytes())
+}
+
+// statsETag returns the ETag of an encoded stats response. The duration and
+// cached flag of its meta differ between runs of the same query, they are
+// left out so that equal results get equal ETags.
+func statsETag(body []byte) string {
+	hashed := body
+	var response map[string]json.RawMessage
+	if json.Unmarshal(body, &response) == nil && response["meta"] != nil {
+		var meta map[string]json.RawMessage
+		if json.Unmarshal(response["meta"], &meta) == nil {
+			delete(meta, "durationMs")
+			delete(meta, "cached")
+			response["meta"], _ = json.Marshal(meta)
+			hashed, _ = json.Marshal(response)
+		}
+	}
+	sum := sha256.Sum256(hashed)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch reports whether an If-None-Match header lists etag, compared
+// weakly as the header requires.
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestConditionalStats(t *testing.T) {
+	setupTrack()
+
+	call := func(query string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/stats/values?site=s&field=path&"+query, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		values(w, r)
+		return w
+	}
+
+	closed := "from=20240101&to=20240131"
+	w := call(closed)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") != "Thu, 01 Feb 2024 12:00:00 GMT" {
+		t.Fatalf("closed range: got %d %v", w.Code, w.Header())
+	}
+	if w := call(closed, "If-None-Match", `"other", W/`+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("matching etag: got %d", w.Code)
+	}
+	if w := call(closed, "If-None-Match", `"other"`); w.Code != http.StatusOK {
+		t.Errorf("other etag: got %d", w.Code)
+	}
+	if w := call(closed, "If-Modified-Since", "Fri, 02 Feb 2024 00:00:00 GMT"); w.Code != http.StatusNotModified {
+		t.Errorf("modified since: got %d", w.Code)
+	}
+	if w := call(closed, "If-Modified-Since", "Thu, 01 Feb 2024 00:00:00 GMT"); w.Code != http.StatusOK {
+		t.Errorf("modified since before closing: got %d", w.Code)
+	}
+
+	today := tracker.TimeToInt(tracker.Now())
+	if w := call("to=" + strconv.FormatUint(uint64(today), 10)); w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("open range: got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestConditionalStatsMeta(t *testing.T) {
+	setupTrack()
+
+	call := func(etag string) *httptest.ResponseRecorder {
+		body := `{"what":"pages","siteId":"s","start":20240101,"end":20240131}`
+		r := httptest.NewRequest("POST", "/stats", strings.NewReader(body))
+		r.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		stats(w, r)
+		return w
+	}
+	w := call("")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	if w := call(etag); w.Code != http.StatusNotModified {
+		t.Errorf("matching etag: got %d", w.Code)
+	}
+
+	// Timings and cache hits don't change the result
+	a := statsETag([]byte(`{"meta":{"rows":1,"durationMs":1.5,"cached":false},"data":[1]}`))
+	b := statsETag([]byte(`{"meta":{"rows":1,"durationMs":0.2,"cached":true},"data":[1]}`))
+	c := statsETag([]byte(`{"meta":{"rows":1,"durationMs":1.5,"cached":false},"data":[2]}`))
+	if a != b || a == c {
+		t.Errorf("got %s, %s and %s", a, b, c)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"tracker"
+)
+
+// maxConfigBytes bounds an imported configuration document.
+const maxConfigBytes = 16 << 20
+
+// adminExportConfig returns the configuration of every site as a
+// ConfigDocument.
+func adminExportConfig(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="tracker-config.json"`)
+	writeJSON(w, requestLogger, http.StatusOK, tracker.ExportConfig(sites, saved))
+}
+
+// adminImportConfig creates or replaces the objects of a posted
+// ConfigDocument and reports how many of each kind.
+func adminImportConfig(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	defer r.Body.Close()
+	var doc tracker.ConfigDocument
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigBytes)).Decode(&doc); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := tracker.ImportConfig(doc, sites, saved)
+	if err != nil {
+		savedError(w, requestLogger, err, 0)
+		return
+	}
+	requestLogger.Info("Imported configuration", slog.Any("result", result))
+	writeJSON(w, requestLogger, http.StatusOK, result)
+}
+
+// configCmd exports the configuration in SITES_FILE and SAVED_FILE to a
+// document, or imports a document into them. Running instances only see
+// imported configuration once restarted, POST /admin/config to them instead.
+func configCmd(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tracker config export [-o file] | tracker config import file")
+		fs.PrintDefaults()
+	}
+	out := fs.String("o", "", "file to export to, stdout by default")
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	action := args[0]
+	fs.Parse(args[1:])
+
+	if err := sites.Load(tracker.GetConfig().SitesFile); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load sites:", err)
+		os.Exit(1)
+	}
+	if err := saved.Load(tracker.GetConfig().SavedFile); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load saved segments and reports:", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case action == "export":
+		b, err := json.MarshalIndent(tracker.ExportConfig(sites, saved), "", "  ")
+		if err == nil && *out != "" {
+			err = os.WriteFile(*out, append(b, '\n'), 0o600)
+		} else if err == nil {
+			_, err = os.Stdout.Write(append(b, '\n'))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to export configuration:", err)
+			os.Exit(1)
+		}
+	case action == "import" && fs.NArg() == 1:
+		b, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var doc tracker.ConfigDocument
+		if err := json.Unmarshal(b, &doc); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid configuration document:", err)
+			os.Exit(1)
+		}
+		result, err := tracker.ImportConfig(doc, sites, saved)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to import configuration:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("sites: %d created, %d updated\n", result.Sites.Created, result.Sites.Updated)
+		fmt.Printf("segments: %d created, %d updated\n", result.Segments.Created, result.Segments.Updated)
+		fmt.Printf("reports: %d created, %d updated\n", result.Reports.Created, result.Reports.Updated)
+		fmt.Printf("campaigns: %d created, %d updated\n", result.Campaigns.Created, result.Campaigns.Updated)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
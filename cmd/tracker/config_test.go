@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestAdminConfig(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+
+	export := httptest.NewRequest("GET", "/admin/config", nil)
+	export.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+	w := httptest.NewRecorder()
+	requireRole(tracker.RoleOwner, tracker.RoleOwner, adminExportConfig)(w, export)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"version":1`) {
+		t.Fatalf("export: got %d %s", w.Code, w.Body)
+	}
+
+	for body, want := range map[string]int{
+		w.Body.String(): http.StatusOK,
+		`{"version":9}`: http.StatusBadRequest,
+		`{`:             http.StatusBadRequest,
+	} {
+		r := httptest.NewRequest("POST", "/admin/config", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleOwner, tracker.RoleOwner, adminImportConfig)(w, r)
+		if w.Code != want {
+			t.Errorf("import %.40s: got %d %s", body, w.Code, w.Body)
+		}
+	}
+}
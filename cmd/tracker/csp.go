@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// cspSnippet returns the Content Security Policy directives and script tag
+// the site query parameter's pages need for the tracker, at PUBLIC_URL or
+// else at the host the request came to.
+func cspSnippet(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	siteID := r.URL.Query().Get("site")
+	if siteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleAdmin, siteID) {
+		return
+	}
+	site, ok := sites.Get(siteID)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	trackerURL := tracker.GetConfig().PublicURL
+	if trackerURL == "" {
+		scheme := "http"
+		if isHTTPS(r) {
+			scheme = "https"
+		}
+		trackerURL = scheme + "://" + r.Host
+	}
+	snippet, err := tracker.NewCSPSnippet(site, trackerURL)
+	if err != nil {
+		requestLogger.Error("Invalid PUBLIC_URL", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, snippet)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tracker"
+)
+
+func TestCSPSnippet(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "csp-site", Domain: "shop.example"})
+
+	call := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Host = "stats.example"
+		r.Header = header
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleAdmin, tracker.RoleAdmin, cspSnippet)(w, r)
+		return w
+	}
+
+	w := call("/admin/csp-snippet?site=csp-site", http.Header{"X-Forwarded-Proto": {"https"}})
+	var snippet tracker.CSPSnippet
+	if err := json.Unmarshal(w.Body.Bytes(), &snippet); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if snippet.Policy != "script-src 'self' https://stats.example; connect-src 'self' https://stats.example" {
+		t.Errorf("got policy %q", snippet.Policy)
+	}
+
+	setConfig(t, "PUBLIC_URL", "https://t.example")
+	w = call("/admin/csp-snippet?site=csp-site", http.Header{})
+	json.Unmarshal(w.Body.Bytes(), &snippet)
+	if snippet.Directives[1].Sources[1] != "https://t.example" {
+		t.Errorf("PUBLIC_URL: got %+v", snippet.Directives)
+	}
+
+	if w := call("/admin/csp-snippet", http.Header{}); w.Code != http.StatusBadRequest {
+		t.Errorf("no site: got status %d", w.Code)
+	}
+	if w := call("/admin/csp-snippet?site=unknown", http.Header{}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown site: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// maxDarkTrafficPages caps the deep pages listed by darkTraffic.
+const maxDarkTrafficPages = 500
+
+// darkTraffic splits the page views without referrer between start and end
+// into direct home page hits and dark traffic to deep pages, posted like
+// time series as {siteId, start, end, source, limit}. limit defaults to 50.
+func darkTraffic(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.DarkTrafficQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode dark traffic request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if q.Source != "" && !tracker.Sources[q.Source] {
+		http.Error(w, "Bad Request: unknown source", http.StatusBadRequest)
+		return
+	}
+	if !validRange(w, q.SiteID, q.Start, q.End) {
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	q.Limit = min(q.Limit, maxDarkTrafficPages)
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+
+	result, err := events.DarkTraffic(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get dark traffic from database", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		requestLogger.Error("Failed to encode dark traffic response", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// dashboard reads (GET), replaces (PUT) and deletes (DELETE) the dashboard
+// settings of /sites/{id}/dashboard. They are the caller's own, which any
+// viewer may change, or with ?scope=site the site's default, which takes
+// admin to change. GET returns the caller's settings falling back to the
+// site's default.
+func dashboard(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	siteID := r.PathValue("id")
+	p, _ := principalOf(r)
+
+	user := p.Name
+	role := tracker.RoleViewer
+	switch r.URL.Query().Get("scope") {
+	case "", "user":
+	case "site":
+		user = ""
+		if r.Method != http.MethodGet {
+			role = tracker.RoleAdmin
+		}
+	default:
+		http.Error(w, "Bad Request: scope must be user or site", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, role, siteID) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, requestLogger, http.StatusOK, saved.Dashboard(siteID, user))
+	case http.MethodPut:
+		var d tracker.Dashboard
+		if !decodeJSON(w, r, &d) {
+			return
+		}
+		d.SiteID, d.User = siteID, user
+		d, err := saved.PutDashboard(d)
+		if err != nil {
+			savedError(w, requestLogger, err, http.StatusOK)
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, d)
+	case http.MethodDelete:
+		savedError(w, requestLogger, saved.DeleteDashboard(siteID, user), http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
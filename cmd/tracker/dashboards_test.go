@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestDashboardSettings(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetPathValue("id", "dash-site")
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, dashboard)(w, r)
+		return w
+	}
+
+	if w := call("PUT", "/sites/dash-site/dashboard?scope=site", `{"rangeDays":30,"goals":["Signup"]}`); w.Code != http.StatusOK {
+		t.Fatalf("site default: got status %d: %s", w.Code, w.Body)
+	}
+	var got tracker.Dashboard
+	json.Unmarshal(call("GET", "/sites/dash-site/dashboard", "").Body.Bytes(), &got)
+	if got.SiteID != "dash-site" || got.User != "" || got.RangeDays != 30 {
+		t.Errorf("before own settings: got %+v", got)
+	}
+
+	if w := call("PUT", "/sites/dash-site/dashboard", `{"rangeDays":7,"user":"someone-else"}`); w.Code != http.StatusOK {
+		t.Fatalf("own settings: got status %d: %s", w.Code, w.Body)
+	}
+	json.Unmarshal(call("GET", "/sites/dash-site/dashboard", "").Body.Bytes(), &got)
+	if got.User != "api-key" || got.RangeDays != 7 {
+		t.Errorf("own settings: got %+v", got)
+	}
+
+	if w := call("PUT", "/sites/dash-site/dashboard", `{"pinnedReports":["nope"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown report: got status %d", w.Code)
+	}
+	if w := call("GET", "/sites/dash-site/dashboard?scope=team", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope: got status %d", w.Code)
+	}
+	if w := call("DELETE", "/sites/dash-site/dashboard", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"tracker"
+)
+
+// doctor prints a finding per check and exits non-zero if any check failed.
+func doctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Minute, "overall time allowed for the checks")
+	fs.Parse(args)
+
+	// Connection debug output would drown the report
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	if err := sites.Load(tracker.GetConfig().SitesFile); err != nil {
+		fmt.Printf("[fail] sites: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	failed := false
+	for _, f := range tracker.Doctor(ctx, sites) {
+		fmt.Printf("[%s] %s: %s\n", f.Status, f.Check, f.Message)
+		if f.Hint != "" && f.Status != tracker.FindingOK {
+			fmt.Printf("%s-> %s\n", strings.Repeat(" ", len(f.Status)+3), f.Hint)
+		}
+		if f.Status == tracker.FindingFail {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tracker"
+)
+
+// badgeScript inserts the SVG badge of its own URL, without format=js,
+// after the script tag.
+const badgeScript = `(function(){var s=document.currentScript,u=new URL(s.src),i=document.createElement("img");` +
+	`u.searchParams.delete("format");i.src=u.href;i.alt="Site statistics";s.parentNode.insertBefore(i,s.nextSibling)})();`
+
+// publicSeries caches the time series of badges and public feeds for as
+// long as they tell browsers and proxies to.
+var publicSeries = tracker.NewSeriesCache(10 * time.Minute)
+
+// embedBadge serves a counter badge of a site for blogs to embed, as an SVG
+// for img tags and iframes, or with format=js as a script inserting it.
+// Query parameters: site, token, the site's public dashboard token, metric
+// such as visitors-7d or pageviews-30d, and style counter or sparkline.
+// Sites without a public dashboard token have no badges.
+func embedBadge(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	site, ok := sites.Get(params.Get("site"))
+	token := params.Get("token")
+	if !ok || site.PublicToken == "" || subtle.ConstantTimeCompare([]byte(site.PublicToken), []byte(token)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	metric, err := tracker.ParseBadgeMetric(params.Get("metric"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	style := params.Get("style")
+	if style != "" && style != "counter" && style != "sparkline" {
+		http.Error(w, "Bad Request: style must be counter or sparkline", http.StatusBadRequest)
+		return
+	}
+
+	// Badges are public, embedded on every page view of a blog
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if params.Get("format") == "js" {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write([]byte(badgeScript))
+		return
+	}
+
+	end := tracker.TimeToInt(tracker.Now())
+	series, err := publicSeries.TimeSeries(r.Context(), events, tracker.TimeSeriesQuery{SiteID: site.ID, Start: tracker.AddDays(end, 1-metric.Days), End: end})
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get badge time series from database", err)
+		return
+	}
+	counts, total := metric.Counts(series.Data)
+	if style != "sparkline" {
+		counts = nil
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Write(tracker.BadgeSVG(metric.Label(), tracker.FormatCount(total), counts))
+}
+
+// publicFeedDays are the days publicStats covers, today included.
+const publicFeedDays = 30
+
+// publicFeed is the response of publicStats, its totals sum the days.
+type publicFeed struct {
+	Site      string      `json:"site"`
+	Visitors  uint64      `json:"visitors"`
+	Pageviews uint64      `json:"pageviews"`
+	Days      []publicDay `json:"days"`
+}
+
+type publicDay struct {
+	Day       uint32 `json:"day"`
+	Visitors  uint64 `json:"visitors"`
+	Pageviews uint64 `json:"pageviews"`
+}
+
+// publicStats serves the daily visitors and page views of the last 30 days
+// of /public/{site}/stats.json, for open startup pages and the like. Only
+// sites with PublicStats have one.
+func publicStats(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	site, ok := sites.Get(r.PathValue("site"))
+	if !ok || !site.PublicStats {
+		http.NotFound(w, r)
+		return
+	}
+
+	end := tracker.TimeToInt(tracker.Now())
+	series, err := publicSeries.TimeSeries(r.Context(), events, tracker.TimeSeriesQuery{SiteID: site.ID, Start: tracker.AddDays(end, 1-publicFeedDays), End: end})
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get public time series from database", err)
+		return
+	}
+	feed := publicFeed{Site: site.ID, Days: make([]publicDay, 0, len(series.Data))}
+	for _, p := range series.Data {
+		feed.Days = append(feed.Days, publicDay{Day: p.Day, Visitors: p.Visitors, Pageviews: p.Pageviews})
+		feed.Visitors += p.Visitors
+		feed.Pageviews += p.Pageviews
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	writeJSON(w, requestLogger, http.StatusOK, feed)
+}
+
+// publicToken is the response of sitePublicToken.
+type publicToken struct {
+	Token string `json:"token"`
+}
+
+// sitePublicToken sets a new public dashboard token for /sites/{id} on
+// POST, which stops badges embedded with the old one, and removes it on
+// DELETE.
+func sitePublicToken(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleOwner, id) {
+		return
+	}
+	token := ""
+	if r.Method == http.MethodPost {
+		token = tracker.RandomToken()
+	}
+	if _, err := sites.Update(id, func(s *tracker.Site) { s.PublicToken = token }); errors.Is(err, tracker.ErrNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		requestLogger.Error("Failed to save site", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if token == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, publicToken{Token: token})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestEmbedBadge(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: t.Name(), Domain: "blog.example"})
+
+	badge := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		embedBadge(w, httptest.NewRequest("GET", "/embed/badge?site="+t.Name()+"&"+query, nil))
+		return w
+	}
+	if w := badge("token=&metric=visitors-7d"); w.Code != http.StatusNotFound {
+		t.Errorf("without a public token: got %d", w.Code)
+	}
+
+	r := httptest.NewRequest("POST", "/sites/"+t.Name()+"/public-token", nil)
+	r.SetPathValue("id", t.Name())
+	r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+	w := httptest.NewRecorder()
+	requireRole(tracker.RoleOwner, tracker.RoleOwner, sitePublicToken)(w, r)
+	var token publicToken
+	if err := json.Unmarshal(w.Body.Bytes(), &token); w.Code != http.StatusOK || err != nil || token.Token == "" {
+		t.Fatalf("public token: got %d %s", w.Code, w.Body)
+	}
+
+	if w := badge("token=wrong&metric=visitors-7d"); w.Code != http.StatusNotFound {
+		t.Errorf("wrong token: got %d", w.Code)
+	}
+	if w := badge("token=" + token.Token + "&metric=sessions-7d"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown metric: got %d", w.Code)
+	}
+	w = badge("token=" + token.Token + "&metric=pageviews-30d&style=sparkline")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(w.Body.String(), "pageviews 30d") {
+		t.Errorf("badge: got %d %s", w.Code, w.Body)
+	}
+	if w := badge("token=" + token.Token + "&metric=pageviews-30d&format=js"); !strings.Contains(w.Body.String(), "currentScript") {
+		t.Errorf("script: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPublicStats(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "private-site"})
+	sites.Ensure(tracker.Site{ID: "open-site", PublicStats: true})
+	mem := events.(*tracker.MemoryEvents)
+	for _, user := range []string{"u1", "u2", "u2"} {
+		trk := tracker.Tracking{SiteID: "open-site", Action: tracker.TrackingData{Identity: user, Event: "/", Category: tracker.PageviewCategory}}
+		if err := mem.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(site string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/public/"+site+"/stats.json", nil)
+		r.SetPathValue("site", site)
+		w := httptest.NewRecorder()
+		publicStats(w, r)
+		return w
+	}
+	for _, site := range []string{"private-site", "unknown-site"} {
+		if w := get(site); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d", site, w.Code)
+		}
+	}
+
+	w := get("open-site")
+	var feed publicFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if feed.Visitors != 2 || feed.Pageviews != 3 || len(feed.Days) != publicFeedDays {
+		t.Errorf("got %+v", feed)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("CORS: got %q", got)
+	}
+
+	// Page views after the feed was served wait for its cache to expire
+	trk := tracker.Tracking{SiteID: "open-site", Action: tracker.TrackingData{Identity: "u3", Event: "/", Category: tracker.PageviewCategory}}
+	if err := mem.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	w = get("open-site")
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil || feed.Pageviews != 3 {
+		t.Errorf("cached feed: got %+v, %v", feed, err)
+	}
+}
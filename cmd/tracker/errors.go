@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// errorStore keeps JavaScript errors, nil when the store can't.
+var errorStore tracker.ErrorStore
+
+// maxErrorRows caps the rows returned by topErrors.
+const maxErrorRows = 500
+
+// topErrors returns the most frequent JavaScript errors of a site between
+// start and end, posted like time series as {siteId, start, end, limit}.
+// limit defaults to 50.
+func topErrors(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.ErrorsQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode errors request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !validRange(w, q.SiteID, q.Start, q.End) {
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	q.Limit = min(q.Limit, maxErrorRows)
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+	if errorStore == nil {
+		http.Error(w, "Not Found: the store doesn't keep JavaScript errors", http.StatusNotFound)
+		return
+	}
+
+	result, err := errorStore.TopErrors(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get JavaScript errors from database", err)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, result)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestTopErrors(t *testing.T) {
+	setupTrack()
+	errorStore = events.(tracker.ErrorStore)
+	defer func() { errorStore = nil }()
+
+	for _, jsErr := range []string{
+		`{"hash":"a1","message":"x is undefined","source":"/app.js?v=2","count":3}`,
+		`{"hash":"a1","source":"/app.js"}`,
+		`{"message":"boom","source":"/vendor.js"}`,
+	} {
+		payload := `{"site_id":"` + t.Name() + `","tracking":{"type":"jserror","ua":"Mozilla/5.0","event":"/","error":` + jsErr + `}}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("track: got %d", w.Code)
+		}
+	}
+	flushEvents(t)
+
+	body := fmt.Sprintf(`{"siteId":%q,"start":%d,"end":%[2]d}`, t.Name(), tracker.Today())
+	r := httptest.NewRequest("POST", "/stats/errors", strings.NewReader(body))
+	r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+	w := httptest.NewRecorder()
+	requireRole(tracker.RoleViewer, tracker.RoleViewer, topErrors)(w, r)
+
+	var result tracker.ErrorsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if len(result.Data) != 2 || result.Data[0].Count != 4 || result.Data[0].Message != "x is undefined" || result.Data[1].Message != "boom" {
+		t.Errorf("got %+v", result.Data)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// exporter exports the events of an identity, nil when the store can't.
+var exporter tracker.IdentityExporter
+
+// identityExport is the response of adminIdentityExport.
+type identityExport struct {
+	Identity string                  `json:"identity"`
+	Events   []tracker.IdentityEvent `json:"events"`
+}
+
+// adminIdentityExport streams every event stored for /admin/identity/{id}
+// across sites as identityExport JSON, for data subject access requests.
+// Events are written as they are read, exports of busy identities are never
+// held in memory.
+func adminIdentityExport(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	// Identities span sites, only API_KEY can export them
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	if exporter == nil {
+		http.Error(w, "Not Found: the store can't export identities", http.StatusNotFound)
+		return
+	}
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Bad Request: identity is required", http.StatusBadRequest)
+		return
+	}
+
+	// Stored identities are encrypted, encryption is deterministic
+	stored := id
+	if identities != nil {
+		stored = identities.Encrypt(id)
+	}
+
+	head, _ := json.Marshal(id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="identity-export.json"`)
+	w.Write([]byte(`{"identity":` + string(head) + `,"events":[`))
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	count := 0
+	err := exporter.ExportIdentity(r.Context(), stored, func(ev tracker.IdentityEvent) error {
+		if count > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		count++
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+		if flusher != nil && count%1000 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status is sent, aborting leaves the client with invalid JSON
+		// rather than an export that looks complete
+		requestLogger.Error("Failed to export identity", slog.Any("error", err), slog.Int("events", count))
+		panic(http.ErrAbortHandler)
+	}
+	w.Write([]byte("]}\n"))
+	requestLogger.Info("Exported identity", slog.Int("events", count))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestIdentityExport(t *testing.T) {
+	setupTrack()
+	cipher, err := tracker.NewIdentityCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, exporter = cipher, events.(tracker.IdentityExporter)
+	defer func() { identities, exporter = nil, nil }()
+
+	for _, site := range []string{"export-a", "export-b"} {
+		payload := `{"tracking":{"type":"page","identity":"subject-1","event":"/` + site + `","category":"Page views"},"site_id":"` + site + `"}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("track %s: got status %d", site, w.Code)
+		}
+	}
+	flushEvents(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/identity/{id}/export", adminIdentityExport)
+	r := httptest.NewRequest("GET", "/admin/identity/subject-1/export", nil)
+	r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var got identityExport
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	// Enrichment workers may store the events in either order
+	if got.Identity != "subject-1" || len(got.Events) != 2 || got.Events[0].SiteID == got.Events[1].SiteID {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// forecast returns the projected visitors and page views of a site for each
+// of the next days starting today, posted as {siteId, days}, along with the
+// bounds they are expected within.
+func forecast(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.ForecastQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode forecast request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := q.Validate(); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+
+	result, err := tracker.Forecast(r.Context(), events, q)
+	if errors.Is(err, tracker.ErrInvalid) {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		queryError(w, requestLogger, "Failed to get forecast history from database", err)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, result)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestForecast(t *testing.T) {
+	setupTrack()
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/stats/forecast", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, forecast)(w, r)
+		return w
+	}
+
+	w := call(`{"siteId":"forecast-site","days":30}`)
+	var got tracker.ForecastResult
+	if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if len(got.Data) != 30 || got.Data[0].Day != tracker.Today() || got.Method != tracker.ForecastMean {
+		t.Errorf("got %+v", got)
+	}
+
+	for _, body := range []string{`{"siteId":"forecast-site"}`, `{"days":7}`, `{"siteId":"forecast-site","days":7,"touch":"maybe"}`} {
+		if w := call(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", body, w.Code)
+		}
+	}
+}
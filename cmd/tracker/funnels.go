@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// funnelStore computes funnels, nil when the store can't.
+var funnelStore tracker.FunnelStore
+
+// funnel returns the visitors of a site reaching each step of a funnel in
+// order between start and end, posted as {siteId, start, end, steps:
+// [{label, event, category}]}. Each step carries its conversion from the
+// first and the previous step and the median seconds it took from the
+// previous one, a goal is a funnel of one step.
+func funnel(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.FunnelQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode funnel request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !validRange(w, q.SiteID, q.Start, q.End) {
+		return
+	}
+	if err := q.Validate(); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+	if funnelStore == nil {
+		http.Error(w, "Not Found: the store doesn't compute funnels", http.StatusNotFound)
+		return
+	}
+
+	result, err := funnelStore.Funnel(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get funnel from database", err)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, result)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestFunnel(t *testing.T) {
+	setupTrack()
+	funnelStore = events.(tracker.FunnelStore)
+	defer func() { funnelStore = nil }()
+
+	for _, event := range []string{"/pricing", "/signup"} {
+		payload := `{"site_id":"` + t.Name() + `","tracking":{"type":"pageview","ua":"Mozilla/5.0","event":"` + event + `"}}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("track: got %d", w.Code)
+		}
+	}
+	flushEvents(t)
+
+	query := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/stats/funnel", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, funnel)(w, r)
+		return w
+	}
+	days := fmt.Sprintf(`"start":%d,"end":%[1]d`, tracker.Today())
+	w := query(`{"siteId":"` + t.Name() + `",` + days + `,"steps":[{"label":"Pricing","event":"/pricing"},{"event":"/signup"}]}`)
+	var result tracker.FunnelResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if len(result.Data) != 2 || result.Data[0].Label != "Pricing" || result.Data[1].Visitors != 1 || result.Data[1].Conversion != 1 || result.Data[1].MedianSeconds == nil {
+		t.Errorf("got %+v", result.Data)
+	}
+	if w := query(`{"siteId":"` + t.Name() + `",` + days + `,"steps":[]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("no steps: got %d", w.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// bulk adds imported events behind live ones, nil unless the instance
+// ingests.
+var bulk *tracker.BulkLane
+
+// importEvents accepts a JSON array of ImportEvent from importers and
+// backfills. The caller must be an admin of every site of the batch. Events
+// are stored on the day of their timestamp through the bulk lane, so large
+// imports never delay live events, and aren't counted against quotas.
+func importEvents(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	raw, _, err := readPayload(r, tracker.GetConfig().MaxBatchBytes)
+	if err != nil {
+		requestLogger.Error("Failed to read import batch", slog.Any("error", err))
+		payloadError(w, err)
+		return
+	}
+	batch, err := tracker.SplitBatch(raw)
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := batchResult{Rejected: []batchRejected{}}
+	decoded := make([]tracker.Tracking, len(batch))
+	valid := make([]bool, len(batch))
+	var siteIDs []string
+	for i, ev := range batch {
+		trk, err := tracker.DecodeImport(ev)
+		if err != nil {
+			result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: http.StatusBadRequest, Error: err.Error()})
+			continue
+		}
+		decoded[i], valid[i] = trk, true
+		siteIDs = append(siteIDs, trk.SiteID)
+	}
+	if len(siteIDs) > 0 && !permitted(w, r, tracker.RoleAdmin, siteIDs...) {
+		return
+	}
+
+	for i, trk := range decoded {
+		if !valid[i] {
+			continue
+		}
+		site, registered := sites.Get(trk.SiteID)
+		if !tracker.AcceptsEvents(site, registered) {
+			result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: http.StatusForbidden, Error: "site is not registered or verified"})
+			continue
+		}
+
+		trk.Action.ReferrerHost = tracker.ReferrerHost(trk.Action.Referrer)
+		trk.Action.OccuredAt = tracker.DayIn(trk.Action.HappenedAt, tracker.Now().Location())
+		if tracker.Anonymous(site, trk.Action.Consent) {
+			trk.Action.Identity = ""
+		} else if identities != nil && trk.Action.Identity != "" {
+			trk.Action.Identity = identities.Encrypt(trk.Action.Identity)
+		}
+
+		ua := tracker.ParseUserAgent(trk.Action.UserAgent)
+		site.Minimize(&trk.Action)
+		if err := bulk.Submit(r.Context(), trk, ua); err != nil {
+			requestLogger.Error("Failed to queue imported event", slog.Any("error", err))
+			result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: http.StatusServiceUnavailable, Error: err.Error()})
+			continue
+		}
+		result.Accepted++
+	}
+
+	requestLogger.Info("Imported events", slog.Int("events", len(batch)), slog.Int("accepted", result.Accepted),
+		slog.Int("rejected", len(result.Rejected)), slog.Int("lane", bulk.Len()))
+	writeJSON(w, requestLogger, http.StatusAccepted, result)
+}
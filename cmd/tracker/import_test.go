@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestImportEvents(t *testing.T) {
+	setupTrack()
+	bulk = tracker.NewBulkLane(events, nil)
+	bulk.Start()
+	defer func() { bulk = nil }()
+
+	body := `[
+		{"site_id":"import-site","timestamp":"2024-03-01T10:00:00Z","tracking":{"type":"page","event":"/imported","category":"Page views"}},
+		{"site_id":"import-site","tracking":{"type":"page","event":"/undated","category":"Page views"}}
+	]`
+	r := httptest.NewRequest("POST", "/import", strings.NewReader(body))
+	r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+	w := httptest.NewRecorder()
+	requireRole(tracker.RoleAdmin, tracker.RoleAdmin, importEvents)(w, r)
+
+	var result batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if result.Accepted != 1 || len(result.Rejected) != 1 || result.Rejected[0].Index != 1 {
+		t.Errorf("got %+v", result)
+	}
+
+	bulk.Close()
+	got, err := events.Values(context.Background(), tracker.ValuesQuery{SiteID: "import-site", Field: "path", Start: 20240301, End: 20240301, Limit: 10})
+	if err != nil || len(got) != 1 || got[0] != "/imported" {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// jobs runs export jobs, nil when they are disabled or the store can't
+// export sites.
+var jobs *tracker.Jobs
+
+// createJob queues a job posted as {kind, siteId, start, end}, kind being
+// export, and returns it with 202 and its Location. The caller must be an
+// admin of the site.
+func createJob(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var spec tracker.Job
+	if !decodeJSON(w, r, &spec) {
+		return
+	}
+	if spec.SiteID != "" && tracker.DaysBetween(spec.Start, spec.End) > maxRangeDays {
+		http.Error(w, "Bad Request: a start and end at most a year apart are required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleAdmin, spec.SiteID) {
+		return
+	}
+	if jobs == nil {
+		http.Error(w, "Not Found: jobs are disabled", http.StatusNotFound)
+		return
+	}
+
+	job, err := jobs.Create(spec)
+	switch {
+	case errors.Is(err, tracker.ErrInvalid):
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+	case errors.Is(err, tracker.ErrTooManyJobs):
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too Many Requests: "+err.Error(), http.StatusTooManyRequests)
+	case err != nil:
+		requestLogger.Error("Failed to create job", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		requestLogger.Info("Created job", slog.String("job", job.ID), slog.String("site", job.SiteID))
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, requestLogger, http.StatusAccepted, job)
+	}
+}
+
+// getJob returns the job /jobs/{id} and its progress.
+func getJob(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	job, ok := permittedJob(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, job)
+}
+
+// jobResult downloads the result of the job /jobs/{id}/result, with 409
+// until it is done.
+func jobResult(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	job, ok := permittedJob(w, r)
+	if !ok {
+		return
+	}
+	if job.State != tracker.JobDone {
+		http.Error(w, "Conflict: job is "+string(job.State), http.StatusConflict)
+		return
+	}
+
+	f, err := jobs.Result(job.ID)
+	if err != nil {
+		requestLogger.Error("Failed to open job result", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+job.SiteID+`-`+job.ID+`.ndjson"`)
+	http.ServeContent(w, r, "", *job.FinishedAt, f)
+}
+
+// permittedJob returns the job of the request's path when the caller is an
+// admin of its site.
+func permittedJob(w http.ResponseWriter, r *http.Request) (tracker.Job, bool) {
+	if jobs == nil {
+		http.Error(w, "Not Found: jobs are disabled", http.StatusNotFound)
+		return tracker.Job{}, false
+	}
+	job, ok := jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return tracker.Job{}, false
+	}
+	if !permitted(w, r, tracker.RoleAdmin, job.SiteID) {
+		return tracker.Job{}, false
+	}
+	return job, true
+}
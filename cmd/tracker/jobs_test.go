@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tracker"
+)
+
+func TestJobs(t *testing.T) {
+	setupTrack()
+	jobs = tracker.NewJobs(events.(tracker.SiteExporter), t.TempDir())
+	defer func() { jobs = nil }()
+	if err := jobs.Load(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.Run(ctx)
+
+	payload := `{"site_id":"` + t.Name() + `","tracking":{"type":"pageview","ua":"Mozilla/5.0","event":"/"}}`
+	w := httptest.NewRecorder()
+	track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("track: got %d", w.Code)
+	}
+	flushEvents(t)
+
+	do := func(method, id, body string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/jobs/"+id, strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleAdmin, tracker.RoleAdmin, h)(w, r)
+		return w
+	}
+
+	w = do("POST", "", fmt.Sprintf(`{"kind":"export","siteId":"%s","start":%d,"end":%[2]d}`, t.Name(), tracker.Today()), createJob)
+	var job tracker.Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); w.Code != http.StatusAccepted || err != nil {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Location") != "/jobs/"+job.ID {
+		t.Errorf("got Location %q", w.Header().Get("Location"))
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); job.State != tracker.JobDone && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		w = do("GET", job.ID, "", getJob)
+		if err := json.Unmarshal(w.Body.Bytes(), &job); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("get: got %d %s", w.Code, w.Body)
+		}
+	}
+	if job.State != tracker.JobDone || job.Events != 1 {
+		t.Fatalf("got %+v", job)
+	}
+
+	w = do("GET", job.ID, "", jobResult)
+	var ev tracker.IdentityEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &ev); w.Code != http.StatusOK || err != nil || ev.SiteID != t.Name() {
+		t.Errorf("result: got %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", "nope", "", getJob); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: got %d", w.Code)
+	}
+}
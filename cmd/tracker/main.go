@@ -8,9 +8,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,15 +21,43 @@ import (
 )
 
 var (
-	forceIP                 = ""
-	events  *tracker.Events = &tracker.Events{}
-	logger  *slog.Logger
+	forceIP                             = ""
+	events       tracker.EventStore     = &tracker.Events{}
+	sites        *tracker.Sites         = &tracker.Sites{}
+	saved        *tracker.Saved         = &tracker.Saved{}
+	quotas       *tracker.Quotas        = &tracker.Quotas{}
+	blocks       *tracker.GeoBlocks     = tracker.NewGeoBlocks(sites)
+	geoOverrides *tracker.GeoOverrides  = &tracker.GeoOverrides{}
+	unlisted     *tracker.UnlistedKinds = tracker.NewUnlistedKinds()
+	marks        *tracker.Watermarks    = tracker.NewWatermarks()
+	realtime     *tracker.Realtime      = tracker.NewRealtime()
+	visits       *tracker.Visits        = tracker.NewVisits()
+	replays      *tracker.Replays
+	guard        *tracker.VolumeGuard // nil in tests, suspects nobody
+	nonces       *tracker.Nonces
+	enricher     *tracker.Enricher
+	webhooks     *tracker.Webhooks // nil without WEBHOOK_URLS
+	warmed       *tracker.Warmed   // nil when WARMUP_INTERVAL is 0
+	logger       *slog.Logger
 )
 
+// ipHeaders carry the client IP when the tracker is behind a proxy.
+var ipHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// identities encrypts visitor identities before they are stored, it is nil
+// unless IDENTITY_KEY is set.
+var identities *tracker.IdentityCipher
+
+// maxStatsSites bounds how many sites one stats request can combine.
+const maxStatsSites = 100
+
+// Demo site created and seeded by dev mode, it matches the dashboard's default.
+const devSiteID = "news-corp"
+
 func corsMiddleware(next http.Handler) http.Handler {
-	allowedOrigins := map[string]bool{
-		"http://localhost:5173": true,
-		"http://127.0.0.1:8081": true,
+	allowedOrigins := make(map[string]bool)
+	for _, origin := range tracker.GetConfig().CORSOrigins {
+		allowedOrigins[origin] = true
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -38,8 +67,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-KEY")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, Authorization, X-API-KEY, X-Signature, X-Signature-Timestamp, X-Signature-Nonce")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -51,9 +80,6 @@ func corsMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
-	flag.StringVar(&forceIP, "ip", "", "force IP for request, useful in local")
-	flag.Parse()
-
 	// Use TextHandler for development (more readable), JSONHandler for production
 	// logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
@@ -61,21 +87,294 @@ func main() {
 
 	tracker.LoadConfig()
 
-	if err := events.Open(); err != nil {
-		logger.Error("Failed to connect to ClickHouse", slog.Any("error", err))
+	// The first argument selects a subcommand, serve is the default so
+	// `tracker -ip ...` keeps working.
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "doctor":
+		doctor(args)
+	case "rollup":
+		rollup(args)
+	case "config":
+		configCmd(args)
+	case "upgrade":
+		upgrade(args)
+	case "stats":
+		statsCmd(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, available: serve, doctor, rollup, config, upgrade, stats\n", cmd)
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&forceIP, "ip", "", "force IP for request, useful in local")
+	dev := fs.Bool("dev", false, "run without ClickHouse: in-memory storage with a seeded demo site")
+	fs.Parse(args)
+
+	mode := tracker.GetConfig().ServerMode
+	if !mode.Valid() {
+		logger.Error("Unknown SERVER_MODE, expected full, read-only or ingest-only", slog.String("mode", string(mode)))
+		os.Exit(1)
+	}
+	if err := sites.Load(tracker.GetConfig().SitesFile); err != nil {
+		logger.Error("Failed to load sites", slog.Any("error", err))
+		os.Exit(1)
+	}
+	if mode.Serves() {
+		if err := saved.Load(tracker.GetConfig().SavedFile); err != nil {
+			logger.Error("Failed to load saved segments and reports", slog.Any("error", err))
+			os.Exit(1)
+		}
+		if err := keys.Load(tracker.GetConfig().KeysFile); err != nil {
+			logger.Error("Failed to load API keys", slog.Any("error", err))
+			os.Exit(1)
+		}
+		if tracker.GetConfig().OIDCIssuer != "" {
+			if err := setupOIDC(context.Background()); err != nil {
+				logger.Error("Failed to set up OIDC sign-in", slog.Any("error", err))
+				os.Exit(1)
+			}
+		}
+	}
+	if err := geoOverrides.Load(tracker.GetConfig().GeoOverridesFile); err != nil {
+		logger.Error("Failed to load geo overrides", slog.Any("error", err))
 		os.Exit(1)
-	} else if err := events.EnsureTable(); err != nil {
-		logger.Error("Failed to ensure ClickHouse table exists", slog.Any("error", err))
+	}
+	if err := quotas.Load(tracker.GetConfig().QuotaFile, sites); err != nil {
+		logger.Error("Failed to load quota counters", slog.Any("error", err))
 		os.Exit(1)
 	}
+	webhooks = tracker.NewWebhooks()
+	quotas.SetWebhooks(webhooks)
+
+	replays = tracker.NewReplays(tracker.GetConfig().ReplayWindow)
+	guard = tracker.NewVolumeGuard(tracker.GetConfig().AnomalyEventsPerMinute)
+	nonces = tracker.NewNonces(tracker.GetConfig().SignatureMaxAge, tracker.GetConfig().NonceCacheSize)
+	cipher, err := tracker.LoadIdentityCipher()
+	if err != nil {
+		logger.Error("Failed to load identity key", slog.Any("error", err))
+		os.Exit(1)
+	}
+	identities = cipher
+	ingest := mode.Ingests()
 
-	// Start the event processing loop
 	eventsCtx, eventsCancel := context.WithCancel(context.Background())
-	go events.Run(eventsCtx)
+	if webhooks != nil {
+		go webhooks.Run(eventsCtx)
+	}
+
+	if *dev {
+		if err := startDev(eventsCtx); err != nil {
+			logger.Error("Failed to start dev mode", slog.Any("error", err))
+			os.Exit(1)
+		}
+	} else {
+		ch := &tracker.Events{}
+		if err := ch.Open(); err != nil {
+			logger.Error("Failed to connect to ClickHouse", slog.Any("error", err))
+			os.Exit(1)
+		} else if err := ch.EnsureTable(); err != nil {
+			logger.Error("Failed to ensure ClickHouse table exists", slog.Any("error", err))
+			os.Exit(1)
+		}
+		events = ch
+		if path := tracker.GetConfig().QueueSpillFile; path != "" && ingest {
+			spill, err := tracker.OpenSpool(path)
+			if err != nil {
+				logger.Error("Failed to open queue spill file", slog.Any("error", err))
+				os.Exit(1)
+			}
+			defer spill.Close()
+			ch.SpillTo(spill, tracker.GetConfig().QueueHighWater)
+		}
+		if interval := tracker.GetConfig().ClickHousePing; interval > 0 {
+			go ch.RunWatchdog(eventsCtx, interval)
+		}
+		if ingest {
+			go ch.RunUpgradeWatch(eventsCtx, tracker.UpgradePollInterval)
+			go tracker.NewIntegrityChecker(ch, webhooks).Run(eventsCtx, time.Hour)
+		}
+
+		// A TTL deletes raw events by itself
+		if cfg := tracker.GetConfig(); cfg.RawRetentionDays > 0 && cfg.RawRetentionMode == tracker.RetentionMutation && ingest {
+			ch.SetWebhooks(webhooks)
+			go ch.RunLifecycle(eventsCtx, time.Hour)
+		}
+	}
+
+	// Kept before decorators hide them
+	queue, _ = events.(tracker.Queued)
+	exporter, _ = events.(tracker.IdentityExporter)
+	merger, _ = events.(tracker.SiteMerger)
+	siteDeleter, _ = events.(tracker.SiteDeleter)
+	lister, _ = events.(tracker.EventLister)
+	vitalsStore, _ = events.(tracker.VitalsStore)
+	errorStore, _ = events.(tracker.ErrorStore)
+	funnelStore, _ = events.(tracker.FunnelStore)
+	cohortStore, _ = events.(tracker.CohortStore)
+	if store, ok := events.(tracker.SiteExporter); ok && mode.Serves() && tracker.GetConfig().JobsDir != "" {
+		jobs = tracker.NewJobs(store, tracker.GetConfig().JobsDir)
+		if err := jobs.Load(); err != nil {
+			logger.Error("Failed to load jobs", slog.Any("error", err))
+			os.Exit(1)
+		}
+		go jobs.Run(eventsCtx)
+	}
+	if store, ok := events.(tracker.AuditStore); ok && mode.Serves() {
+		auditLog = store
+		auditor = tracker.NewAuditor(store)
+		go auditor.Run(eventsCtx)
+	}
+
+	if mode.Serves() {
+		if threshold := tracker.GetConfig().BreakerThreshold; threshold > 0 {
+			events = tracker.NewBreaker(events, threshold, tracker.GetConfig().BreakerCooldown)
+		}
+		if n := tracker.GetConfig().StatsConcurrency; n > 0 {
+			events = tracker.NewLimited(events, n, tracker.GetConfig().StatsQueueTimeout)
+		}
+		// The optional stores go through the breaker and limiter too
+		if funnelStore != nil {
+			funnelStore = events.(tracker.FunnelStore)
+		}
+		if cohortStore != nil {
+			cohortStore = events.(tracker.CohortStore)
+		}
+		if errorStore != nil {
+			errorStore = events.(tracker.ErrorStore)
+		}
+		if vitalsStore != nil {
+			vitalsStore = events.(tracker.VitalsStore)
+		}
+		if interval := tracker.GetConfig().WarmupInterval; interval > 0 {
+			// Entries outlive one interval so a slow warm-up leaves no gap
+			warmed = tracker.NewWarmed(events, 2*interval)
+			go warmed.RunWarmup(eventsCtx, interval)
+			events = warmed
+		}
+		events = tracker.Coalesce(events)
+		go tracker.NewReportPusher(saved, events).Run(eventsCtx, time.Minute)
+		go tracker.NewAlertEngine(saved, events, funnelStore).Run(eventsCtx, time.Minute)
+	}
+
+	stopCanary := func() {}
+	if ingest {
+		// Start the event processing loop
+		go events.Run(eventsCtx)
+		var spool *tracker.Spool
+		if tracker.GetConfig().EnrichmentFailure == tracker.EnrichmentSpool {
+			var err error
+			if spool, err = tracker.OpenSpool(tracker.GetConfig().EnrichmentSpool); err != nil {
+				logger.Error("Failed to open enrichment spool", slog.Any("error", err))
+				os.Exit(1)
+			}
+			defer spool.Close()
+		}
+		// Only events ingested here are counted, imports aren't realtime
+		enricher = tracker.NewEnricher(realtime.Wrap(events), spool, blocks)
+		enricher.SetGeoOverrides(geoOverrides)
+		geo, err := tracker.NewGeoProvider()
+		if err != nil {
+			logger.Error("Failed to open GeoIP database", slog.Any("error", err))
+			os.Exit(1)
+		}
+		enricher.SetGeoProvider(geo)
+		enricher.SetSites(sites)
+		enricher.Start()
+		go realtime.Run(eventsCtx, time.Minute)
+		if spool != nil {
+			go enricher.RetrySpool(eventsCtx, 5*time.Minute)
+		}
+		go quotas.Run(eventsCtx, 30*time.Second)
+		bulk = tracker.NewBulkLane(events, queue)
+		bulk.Start()
+		stopCanary = startCanary(eventsCtx)
+	} else {
+		logger.Info("Running read-only, events are not ingested")
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/track", track)
-	mux.HandleFunc("/stats", stats)
+	if ingest {
+		mux.HandleFunc("/track", track)
+		mux.HandleFunc("/track/mobile", trackMobile)
+		mux.HandleFunc("/track/batch", trackBatch)
+		mux.HandleFunc("GET /js/{file}", script)
+	}
+	if mode.Serves() {
+		// Stats are read with POST, reading takes viewer whatever the method
+		mux.HandleFunc("/stats", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(stats))))
+		mux.HandleFunc("/stats/timeseries", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(timeSeries))))
+		mux.HandleFunc("/stats/cohorts", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(cohorts))))
+		mux.HandleFunc("/stats/values", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(values))))
+		mux.HandleFunc("/stats/summary", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(summary))))
+		mux.HandleFunc("GET /stats/realtime", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, realtimeTops)))
+		mux.HandleFunc("/stats/vitals", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(vitals))))
+		mux.HandleFunc("/stats/errors", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(topErrors))))
+		mux.HandleFunc("/stats/funnel", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(funnel))))
+		mux.HandleFunc("/stats/forecast", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(forecast))))
+		mux.HandleFunc("/stats/dark-traffic", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(darkTraffic))))
+		mux.HandleFunc("/segments", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, segments)))
+		mux.HandleFunc("/segments/{id}", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, segment)))
+		mux.HandleFunc("/reports", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, reports)))
+		mux.HandleFunc("/reports/{id}", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, report)))
+		mux.HandleFunc("/reports/{id}/stats", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(runReport))))
+		mux.HandleFunc("/alerts", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, alertRules)))
+		mux.HandleFunc("/alerts/{id}", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, alertRule)))
+		mux.HandleFunc("/sites/{id}/dashboard", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, dashboard)))
+		mux.HandleFunc("/campaigns", audited(requireRole(tracker.RoleViewer, tracker.RoleAdmin, campaigns)))
+		mux.HandleFunc("DELETE /campaigns/{id}", audited(requireRole(tracker.RoleAdmin, tracker.RoleAdmin, deleteCampaign)))
+		mux.HandleFunc("/stats/campaigns", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(campaignStats))))
+		mux.HandleFunc("GET /triggers/events", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(eventsTrigger))))
+		mux.HandleFunc("GET /triggers/daily-summary", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, rateLimited(dailySummaryTrigger))))
+		mux.HandleFunc("/usage", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, usage)))
+		mux.HandleFunc("/admin/usage", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminUsage)))
+		mux.HandleFunc("/admin/audit", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminAudit)))
+		mux.HandleFunc("GET /sites", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, listSites)))
+		mux.HandleFunc("GET /sites/{id}", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, getSite)))
+		mux.HandleFunc("POST /sites", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, createSite)))
+		mux.HandleFunc("PUT /sites/{id}", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, putSite)))
+		mux.HandleFunc("DELETE /sites/{id}", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, deleteSite)))
+		mux.HandleFunc("POST /sites/{id}/keys/{kind}", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, setSiteKey)))
+		mux.HandleFunc("DELETE /sites/{id}/keys/{kind}", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, setSiteKey)))
+		mux.HandleFunc("POST /sites/{id}/public-token", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, sitePublicToken)))
+		mux.HandleFunc("DELETE /sites/{id}/public-token", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, sitePublicToken)))
+		mux.HandleFunc("GET /embed/badge", embedBadge)
+		mux.HandleFunc("GET /public/{site}/stats.json", publicStats)
+		mux.HandleFunc("GET /sites/{id}/verification", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, siteVerificationStatus)))
+		mux.HandleFunc("POST /sites/{id}/verify", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, verifySite)))
+		mux.HandleFunc("GET /sites/{id}/blocked", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, siteBlocked)))
+		mux.HandleFunc("POST /admin/sites/{id}/merge", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminMergeSite)))
+		mux.HandleFunc("DELETE /admin/sites/{id}", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminDeleteSite)))
+		mux.HandleFunc("GET /sites/{id}/late", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, siteLate)))
+		mux.HandleFunc("GET /sites/{id}/kinds", audited(requireRole(tracker.RoleViewer, tracker.RoleViewer, siteKinds)))
+		mux.HandleFunc("GET /admin/config", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminExportConfig)))
+		mux.HandleFunc("POST /admin/config", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminImportConfig)))
+		mux.HandleFunc("POST /admin/apply", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminApply)))
+		mux.HandleFunc("GET /admin/csp-snippet", audited(requireRole(tracker.RoleAdmin, tracker.RoleAdmin, cspSnippet)))
+		if ingest {
+			mux.HandleFunc("POST /import", audited(requireRole(tracker.RoleAdmin, tracker.RoleAdmin, importEvents)))
+		}
+		mux.HandleFunc("GET /admin/identity/{id}/export", audited(requireRole(tracker.RoleOwner, tracker.RoleOwner, adminIdentityExport)))
+		mux.HandleFunc("POST /jobs", audited(requireRole(tracker.RoleAdmin, tracker.RoleAdmin, createJob)))
+		mux.HandleFunc("GET /jobs/{id}", audited(requireRole(tracker.RoleAdmin, tracker.RoleAdmin, getJob)))
+		mux.HandleFunc("GET /jobs/{id}/result", audited(requireRole(tracker.RoleAdmin, tracker.RoleAdmin, jobResult)))
+		if oidc != nil {
+			mux.HandleFunc("GET /auth/login", authLogin)
+			mux.HandleFunc("GET /auth/callback", authCallback)
+			mux.HandleFunc("POST /auth/logout", authLogout)
+		}
+		mux.HandleFunc("GET /auth/me", authMe)
+	} else {
+		logger.Info("Running ingest-only, stats and admin routes are disabled")
+	}
 
 	corsHandler := corsMiddleware(mux)
 
@@ -96,6 +395,17 @@ func main() {
 		}
 	}()
 
+	var admin *http.Server
+	if addr := tracker.GetConfig().AdminAddr; addr != "" {
+		admin = &http.Server{Addr: addr, Handler: adminHandler(ingest)}
+		go func() {
+			logger.Info("Admin server starting", slog.String("address", admin.Addr))
+			if err := admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Admin server failed to start", slog.Any("error", err))
+			}
+		}()
+	}
+
 	<-stopChan
 
 	logger.Info("Shutting down server...")
@@ -106,65 +416,166 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.Error("HTTP server shutdown failed", slog.Any("error", err))
 	}
+	if admin != nil {
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Admin server shutdown failed", slog.Any("error", err))
+		}
+	}
+
+	if ingest {
+		stopCanary()
+		logger.Info("Draining geo enrichment queue...")
+		enricher.Close()
+		logger.Info("Draining bulk lane...")
+		bulk.Close()
+	}
 
 	logger.Info("Stopping event processor...")
 	eventsCancel() // Signal Run() to stop accepting new events via context cancellation
 
 	events.WaitFlush()
 	logger.Info("Event processor stopped.")
+	if auditor != nil {
+		auditor.Wait()
+	}
+	webhooks.Wait()
+
+	// Read-only instances never count events, their counters are only read
+	if ingest {
+		if err := quotas.Save(); err != nil {
+			logger.Error("Failed to persist quota counters", slog.Any("error", err))
+		}
+	}
 
 	logger.Info("Shutdown complete.")
 }
 
+// startDev switches to in-memory storage, registers the demo site and seeds
+// it with a month of sample traffic. The demo page is served from another
+// host than the site's domain, so its origin isn't checked.
+func startDev(ctx context.Context) error {
+	tracker.UseDevDefaults()
+	mem := tracker.NewMemoryEvents()
+	events = mem
+
+	if _, created, err := sites.Ensure(tracker.Site{ID: devSiteID, Domain: "localhost", OriginCheck: tracker.OriginOff}); err != nil {
+		return fmt.Errorf("failed to create demo site: %w", err)
+	} else if created {
+		logger.Info("Created demo site", slog.String("site", devSiteID))
+	}
+
+	if err := tracker.SeedDemoData(ctx, mem, devSiteID, 30, 200); err != nil {
+		return err
+	}
+
+	logger.Info("Dev mode ready",
+		slog.String("site", devSiteID),
+		slog.String("apiKey", tracker.GetConfig().APIKey),
+		slog.Int("seededEvents", mem.Len()))
+	return nil
+}
+
 func track(w http.ResponseWriter, r *http.Request) {
 	requestLogger := logger.With(slog.String("path", r.URL.Path), slog.String("method", r.Method))
 
-	var trk tracker.Tracking
-	var err error
+	switch r.Method {
+	case http.MethodPost, http.MethodGet:
+	default:
+		// HEAD would let a prefetcher "send" a beacon without a payload
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&trk); err != nil {
-		requestLogger.Error("Failed to decode tracking data from request body", slog.Any("error", err))
-		// Try to determine if the error is client-side (bad JSON) or server-side
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		if errors.As(err, &syntaxError) || errors.As(err, &unmarshalTypeError) {
-			http.Error(w, "Bad Request: Invalid JSON format", http.StatusBadRequest)
-		} else {
-			http.Error(w, "Internal Server Error: Could not read request body", http.StatusInternalServerError)
-		}
+	// A cached response means the browser never sent the event
+	w.Header().Set("Cache-Control", "no-store")
+
+	raw, body, err := readPayload(r, tracker.GetConfig().MaxBodyBytes)
+	if err != nil {
+		requestLogger.Error("Failed to read tracking payload", slog.Any("error", err))
+		payloadError(w, err)
 		return
 	}
 
-	ua := useragent.Parse(trk.Action.UserAgent)
+	trk, err := tracker.DecodePayload(raw)
+	if err != nil {
+		requestLogger.Error("Failed to decode tracking data", slog.Any("error", err))
+		http.Error(w, "Bad Request: Invalid JSON format", http.StatusBadRequest)
+		return
+	}
 
-	headers := []string{"X-Forward-For", "X-Real-IP"}
-	ip, ipErr := tracker.IPFromRequest(headers, r, forceIP)
+	if site, ok := sites.Get(trk.SiteID); ok {
+		if err := tracker.CheckOrigin(site, r.Header.Get("Origin"), r.Header.Get("Referer")); err != nil {
+			requestLogger.Warn("Rejected beacon from foreign origin", slog.String("site", trk.SiteID),
+				slog.String("origin", r.Header.Get("Origin")), slog.String("referer", r.Header.Get("Referer")))
+			http.Error(w, "Forbidden: origin does not match site domain", http.StatusForbidden)
+			return
+		}
+		if err := checkSigned(site, r, body); err != nil {
+			requestLogger.Warn("Rejected payload signature", slog.String("site", trk.SiteID), slog.Any("error", err))
+			signatureError(w, err)
+			return
+		}
+		if site.VisitorCookie {
+			visitorCookie(w, r, requestLogger, site, &trk)
+		}
+	}
+
+	ingest(w, r, requestLogger, raw, trk, tracker.ParseUserAgent(trk.Action.UserAgent))
+}
+
+// ingest accepts a decoded event and answers the request with the outcome.
+func ingest(w http.ResponseWriter, r *http.Request, requestLogger *slog.Logger, raw []byte, trk tracker.Tracking, ua useragent.UserAgent) {
+	switch status := accept(r, requestLogger, raw, trk, ua); status {
+	case http.StatusTooManyRequests:
+		http.Error(w, "Too Many Requests: monthly event quota exceeded", status)
+	case http.StatusForbidden:
+		http.Error(w, "Forbidden: site is not registered or verified", status)
+	case http.StatusUnprocessableEntity:
+		http.Error(w, "Unprocessable Entity: event kind is not accepted by the site", status)
+	case http.StatusInternalServerError:
+		http.Error(w, "Internal Server Error: Could not process event", status)
+	default:
+		w.WriteHeader(status)
+	}
+}
+
+// accept takes a decoded event through identity, replay and quota checks and
+// queues it for enrichment. raw is the payload the event was decoded from,
+// replays of it are ignored. Events of a batch pass nil: identical events in
+// a batch, such as two taps on the same button, are separate events. It
+// returns the status to answer with.
+func accept(r *http.Request, requestLogger *slog.Logger, raw []byte, trk tracker.Tracking, ua useragent.UserAgent) int {
+	ip, ipErr := tracker.IPFromRequest(ipHeaders, r, forceIP)
 	if ipErr != nil {
 		requestLogger.Error("Failed to get IP from request", slog.Any("error", ipErr))
 		// Continue processing even if IP fails
 	}
 
-	var geoInfo *tracker.GeoInfo
-	if ip != nil {
-		geoInfo, err = tracker.GetGeoInfo(ip.String())
-		if err != nil {
-			requestLogger.Warn("Failed to get geo info", slog.Any("error", err), slog.String("ip", ip.String()))
-			// Continue processing even if GeoIP fails
-		}
-	} else {
-		requestLogger.Debug("Skipping geo lookup due to missing IP")
+	trk.Action.ReferrerHost = tracker.ReferrerHost(trk.Action.Referrer)
+	if trk.Action.Referrer != "" && trk.Action.ReferrerHost == "" {
+		requestLogger.Warn("Failed to parse referrer URL", slog.String("referrer", trk.Action.Referrer))
 	}
 
-	if len(trk.Action.Referrer) > 0 {
-		u, parseErr := url.Parse(trk.Action.Referrer)
-		if parseErr == nil {
-			trk.Action.ReferrerHost = u.Host
-		} else {
-			requestLogger.Warn("Failed to parse referrer URL", slog.String("referrer", trk.Action.Referrer), slog.Any("error", parseErr))
-		}
+	site, registered := sites.Get(trk.SiteID)
+	if registered && site.MergedInto != "" {
+		trk.SiteID = site.MergedInto
+		site, registered = sites.Get(trk.SiteID)
 	}
-
-	if len(trk.Action.Identity) == 0 {
+	if !tracker.AcceptsEvents(site, registered) {
+		requestLogger.Warn("Rejected event for unregistered or unverified site", slog.String("site", trk.SiteID))
+		return http.StatusForbidden
+	}
+	if unlisted.Rejected(site, trk.Action) {
+		requestLogger.Warn("Rejected event of unlisted kind", slog.String("site", trk.SiteID),
+			slog.String("type", trk.Action.Type), slog.String("category", trk.Action.Category))
+		return http.StatusUnprocessableEntity
+	}
+	if tracker.Anonymous(site, trk.Action.Consent) {
+		// Counted in aggregates only, nothing ties the event to a visitor
+		trk.Action.Identity = ""
+		requestLogger.Debug("Storing event anonymously", slog.String("consent", string(trk.Action.Consent)))
+	} else if len(trk.Action.Identity) == 0 {
 		if ip != nil {
 			trk.Action.Identity = fmt.Sprintf("%s-%s", ip.String(), trk.Action.UserAgent)
 			requestLogger.Debug("Generated identity from IP and UserAgent", slog.String("identity", trk.Action.Identity))
@@ -174,27 +585,184 @@ func track(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Send event for processing
-	if err := events.Add(r.Context(), trk, ua, geoInfo); err != nil {
+	// Replays are detected per IP, a browser resending a beacon it
+	// believes failed is the same visit.
+	now := tracker.Now()
+	trk.Action.OccuredAt = tracker.TimeToInt(now)
+	happened := now
+	if !trk.Action.HappenedAt.IsZero() {
+		happened = trk.Action.HappenedAt
+		trk.Action.OccuredAt = tracker.DayIn(happened, now.Location())
+	}
+	ipString := ""
+	if ip != nil {
+		ipString = ip.String()
+	}
+	fp := ""
+	if raw != nil {
+		fp = tracker.Fingerprint(raw, ipString)
+		if replays.Seen(fp, now) {
+			requestLogger.Debug("Ignored duplicate event", slog.String("fingerprint", fp))
+			return http.StatusAlreadyReported
+		}
+	}
+
+	// Flagged events are stored for audit, stats leave them out
+	tracker.Classify(site, &trk.Action, ua, ip)
+	throttled := guard.Check(&trk.Action, ipString, now)
+
+	if quotas.Exceeded(trk.SiteID, now) {
+		requestLogger.Warn("Rejected event over monthly quota", slog.String("site", trk.SiteID))
+		return http.StatusTooManyRequests
+	}
+
+	if identities != nil {
+		trk.Action.Identity = identities.Encrypt(trk.Action.Identity)
+	}
+	trk.Action.SessionID = visits.Session(trk.SiteID, trk.Action.Identity, happened)
+	site.Minimize(&trk.Action)
+
+	if trk.Action.Late = marks.Observe(trk.SiteID, happened); trk.Action.Late {
+		requestLogger.Info("Accepted late event", slog.String("site", trk.SiteID), slog.Time("happenedAt", happened))
+	}
+
+	// Send event for enrichment and processing
+	if err := enricher.Submit(r.Context(), trk, ua, ip); err != nil {
 		requestLogger.Error("Failed to add event to queue", slog.Any("error", err))
-		http.Error(w, "Internal Server Error: Could not process event", http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError
+	}
+
+	if fp != "" {
+		replays.Remember(fp, trk.SiteID, now)
+	}
+	if throttled {
+		requestLogger.Debug("Stored throttled event of suspected automation", slog.String("site", trk.SiteID))
+	} else if over := quotas.Record(trk.SiteID, now); over {
+		requestLogger.Debug("Accepted event over monthly quota", slog.String("site", trk.SiteID))
 	}
 
-	w.WriteHeader(http.StatusOK)
 	requestLogger.Debug("Event tracked successfully")
+	return http.StatusAccepted
 }
 
-func stats(w http.ResponseWriter, r *http.Request) {
-	requestLogger := logger.With(slog.String("path", r.URL.Path))
+// queryError responds to a failed stats query, with 503 and a Retry-After
+// header while the circuit breaker is open or all query slots are taken, and
+// 400 for queries the store can't answer as asked.
+func queryError(w http.ResponseWriter, requestLogger *slog.Logger, msg string, err error) {
+	var open *tracker.CircuitOpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(open.RetryAfter.Seconds())+1))
+		http.Error(w, "Service Unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, tracker.ErrTooBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, tracker.ErrInvalid) {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	requestLogger.Error(msg, slog.Any("error", err))
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
 
-	key := r.Header.Get("X-API-KEY")
-	if key != tracker.GetConfig().APIKey {
-		requestLogger.Warn("Unauthorized stats access attempt")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// readPayload returns the raw JSON payload of a beacon: the request body for
+// POST, possibly gzip compressed, MessagePack encoded or a sendBeacon text or
+// form, the base64 data query parameter for GET. body is the payload as sent, once decompressed or
+// decoded from base64, signatures are computed over it.
+func readPayload(r *http.Request, limit int64) (raw, body []byte, err error) {
+	if r.Method == http.MethodGet {
+		data := r.URL.Query().Get("data")
+		if data == "" {
+			return nil, nil, fmt.Errorf("%w: missing data parameter", tracker.ErrMalformedPayload)
+		}
+		raw, err := tracker.DecodeBase64(data)
+		return raw, raw, err
+	}
+
+	defer r.Body.Close()
+	body, err = tracker.ReadBody(r.Body, r.Header.Get("Content-Encoding"), limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err = tracker.BodyJSON(body, r.Header.Get("Content-Type"))
+	return raw, body, err
+}
+
+// visitorCookie identifies the visitor by the cookie of a site with visitor
+// cookies, setting it for new visitors and refreshing it for returning ones
+// so they keep their ID. It is removed when the visitor withdraws consent.
+func visitorCookie(w http.ResponseWriter, r *http.Request, requestLogger *slog.Logger, site tracker.Site, trk *tracker.Tracking) {
+	if tracker.Anonymous(site, trk.Action.Consent) {
+		if _, err := r.Cookie(tracker.VisitorCookieName); err == nil {
+			expired := tracker.VisitorCookie("", isHTTPS(r))
+			expired.MaxAge = -1
+			http.SetCookie(w, expired)
+		}
 		return
 	}
 
+	id, isNew := tracker.VisitorID(r)
+	http.SetCookie(w, tracker.VisitorCookie(id, isHTTPS(r)))
+	if trk.Action.Identity == "" {
+		trk.Action.Identity = id
+	}
+	requestLogger.Debug("Identified visitor by cookie", slog.Bool("new", isNew))
+}
+
+// isHTTPS reports whether the request came over HTTPS, directly or through a
+// TLS terminating proxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// checkSigned verifies the signature of a payload to a site with a signing
+// key, payloads to other sites needn't be signed.
+func checkSigned(site tracker.Site, r *http.Request, body []byte) error {
+	if site.SigningKey == "" {
+		return nil
+	}
+	return nonces.Verify(site, r.Header, body, tracker.Now())
+}
+
+// signatureStatus is the status a request whose signature was rejected is
+// answered with.
+func signatureStatus(err error) int {
+	if errors.Is(err, tracker.ErrNonceCacheFull) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusUnauthorized
+}
+
+// signatureError answers a request whose signature was rejected.
+func signatureError(w http.ResponseWriter, err error) {
+	status := signatureStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	http.Error(w, http.StatusText(status)+": "+err.Error(), status)
+}
+
+// payloadError answers a request whose payload couldn't be read.
+func payloadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tracker.ErrPayloadTooLarge):
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, tracker.ErrUnsupportedEncoding):
+		w.Header().Set("Accept-Encoding", "gzip")
+		http.Error(w, "Unsupported Media Type: "+err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, tracker.ErrMalformedPayload):
+		http.Error(w, "Bad Request: Invalid payload", http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal Server Error: Could not read request body", http.StatusInternalServerError)
+	}
+}
+
+func stats(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
 	var data tracker.MetricData
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		requestLogger.Error("Failed to decode stats request body", slog.Any("error", err))
@@ -203,16 +771,49 @@ func stats(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	metrics, err := events.GetStats(r.Context(), data)
-	if err != nil {
-		requestLogger.Error("Failed to get stats from database", slog.Any("error", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if data.Org != "" {
+		if data.SiteIDs = sites.InOrg(data.Org); len(data.SiteIDs) == 0 {
+			http.Error(w, "Not Found: unknown org", http.StatusNotFound)
+			return
+		}
+	}
+	if data.Source != "" && !tracker.Sources[data.Source] {
+		http.Error(w, "Bad Request: unknown source", http.StatusBadRequest)
+		return
+	}
+	if data.Touch != "" && !tracker.Touches[data.Touch] {
+		http.Error(w, "Bad Request: touch must be touch or non-touch", http.StatusBadRequest)
+		return
+	}
+	if err := tracker.ValidateQualities(data.Qualities); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for field := range data.Filters {
+		if _, err := tracker.ValueColumn(field); err != nil {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(data.SiteIDs) > maxStatsSites {
+		http.Error(w, fmt.Sprintf("Bad Request: at most %d sites can be combined", maxStatsSites), http.StatusBadRequest)
+		return
+	}
+	siteIDs := data.SiteIDs
+	if len(siteIDs) == 0 {
+		siteIDs = []string{data.SiteID}
+	}
+	if !permitted(w, r, tracker.RoleViewer, siteIDs...) {
+		return
+	}
+	if notModified(w, r, data.End) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(metrics); err != nil {
-		requestLogger.Error("Failed to encode stats response", slog.Any("error", err))
+	result, err := events.GetStats(r.Context(), data)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get stats from database", err)
 		return
 	}
+	writeStats(w, r, requestLogger, data.End, result)
 }
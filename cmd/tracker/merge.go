@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tracker"
+)
+
+// merger moves events between sites, nil when the store can't.
+var merger tracker.SiteMerger
+
+// siteMerge is a request to merge a site into another and its outcome.
+type siteMerge struct {
+	From   string `json:"from"`
+	Into   string `json:"into"`
+	Events uint64 `json:"events"`
+}
+
+// adminMergeSite merges /admin/sites/{id} into the site posted as {into},
+// after a site was renamed for instance. The site is kept in the registry
+// with mergedInto set, so events still sent to it are recorded for the
+// other site, then its queued events are written and all its events moved.
+func adminMergeSite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	if merger == nil {
+		http.Error(w, "Not Found: the store can't merge sites", http.StatusNotFound)
+		return
+	}
+	var merge siteMerge
+	if !decodeJSON(w, r, &merge) {
+		return
+	}
+	merge.From = r.PathValue("id")
+	if merge.Into == "" || merge.Into == merge.From {
+		http.Error(w, "Bad Request: into must be another site", http.StatusBadRequest)
+		return
+	}
+	if into, ok := sites.Get(merge.Into); ok && into.MergedInto != "" {
+		http.Error(w, "Bad Request: "+merge.Into+" was merged into "+into.MergedInto, http.StatusBadRequest)
+		return
+	}
+
+	if _, _, err := sites.Ensure(tracker.Site{ID: merge.From}); err != nil {
+		requestLogger.Error("Failed to save site", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := sites.Update(merge.From, func(s *tracker.Site) { s.MergedInto = merge.Into }); err != nil {
+		requestLogger.Error("Failed to save site", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+	if queue != nil {
+		if err := queue.Flush(ctx); err != nil {
+			requestLogger.Error("Failed to flush queue before merge", slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	moved, err := merger.MergeSite(ctx, merge.From, merge.Into)
+	if err != nil {
+		requestLogger.Error("Failed to merge site", slog.String("from", merge.From), slog.String("into", merge.Into), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	merge.Events = moved
+	requestLogger.Info("Merged site", slog.String("from", merge.From), slog.String("into", merge.Into), slog.Uint64("events", moved))
+	webhooks.Fire(tracker.HookSiteMerged, merge.From, merge)
+	writeJSON(w, requestLogger, http.StatusOK, merge)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestAdminMergeSite(t *testing.T) {
+	setupTrack()
+	merger = events.(tracker.SiteMerger)
+	t.Cleanup(func() { merger = nil })
+
+	send := func(site, path string) {
+		payload := `{"tracking":{"type":"page","event":"` + path + `","category":"Page views"},"site_id":"` + site + `"}`
+		track(httptest.NewRecorder(), httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		flushEvents(t)
+	}
+	pageviews := func(site string) uint64 {
+		result, err := events.GetStats(context.Background(), tracker.MetricData{What: tracker.QueryPageViews, SiteID: site, Start: 0, End: tracker.Today()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n uint64
+		for _, m := range result.Data {
+			n += m.Count
+		}
+		return n
+	}
+	merge := func(id, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/admin/sites/"+id+"/merge", strings.NewReader(body))
+		r.SetPathValue("id", id)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleOwner, tracker.RoleOwner, adminMergeSite)(w, r)
+		return w
+	}
+
+	send("merge-old", "/")
+	send("merge-old", "/docs")
+	w := merge("merge-old", `{"into":"merge-new"}`)
+	var got siteMerge
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Events != 2 {
+		t.Fatalf("got %+v, %v: %s", got, err, w.Body)
+	}
+	if n := pageviews("merge-new"); n != 2 {
+		t.Errorf("merged site: got %d page views, want 2", n)
+	}
+
+	// Events still sent to the old site are recorded for the new one
+	send("merge-old", "/pricing")
+	if old, merged := pageviews("merge-old"), pageviews("merge-new"); old != 0 || merged != 3 {
+		t.Errorf("after merge: got %d and %d page views", old, merged)
+	}
+
+	if w := merge("merge-new", `{"into":"merge-new"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("merge into itself: got status %d", w.Code)
+	}
+	if w := merge("other", `{"into":"merge-old"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("merge into merged site: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// trackMobile accepts events from mobile SDKs in the MobileEvent format. The
+// payload is validated strictly and errors say which field is wrong, see
+// api/openapi.yaml.
+func trackMobile(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path), slog.String("method", r.Method))
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	raw, body, err := readPayload(r, tracker.GetConfig().MaxBodyBytes)
+	if err != nil {
+		requestLogger.Error("Failed to read mobile event", slog.Any("error", err))
+		payloadError(w, err)
+		return
+	}
+
+	trk, ua, err := tracker.DecodeMobileEvent(raw)
+	if err != nil {
+		requestLogger.Debug("Rejected invalid mobile event", slog.Any("error", err))
+		if errors.Is(err, tracker.ErrMalformedPayload) {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if site, ok := sites.Get(trk.SiteID); ok {
+		if err := checkBrowserOrigin(site, r); err != nil {
+			requestLogger.Warn("Rejected mobile event from foreign origin", slog.String("site", trk.SiteID),
+				slog.String("origin", r.Header.Get("Origin")), slog.String("referer", r.Header.Get("Referer")))
+			http.Error(w, "Forbidden: origin does not match site domain", http.StatusForbidden)
+			return
+		}
+		if err := checkSigned(site, r, body); err != nil {
+			requestLogger.Warn("Rejected payload signature", slog.String("site", trk.SiteID), slog.Any("error", err))
+			signatureError(w, err)
+			return
+		}
+	}
+
+	ingest(w, r, requestLogger, raw, trk, ua)
+}
+
+// checkBrowserOrigin applies the site's origin check to mobile events sent
+// from a browser. Native SDKs send neither Origin nor Referer, a browser
+// posting a beacon to the mobile endpoints does and is checked as on /track.
+func checkBrowserOrigin(site tracker.Site, r *http.Request) error {
+	origin, referer := r.Header.Get("Origin"), r.Header.Get("Referer")
+	if origin == "" && referer == "" {
+		return nil
+	}
+	return tracker.CheckOrigin(site, origin, referer)
+}
+
+// batchResult reports what happened to the events of a batch. Events are
+// counted as accepted, the others are listed with the reason they were
+// rejected so the SDK can drop or retry them.
+type batchResult struct {
+	Accepted int             `json:"accepted"`
+	Rejected []batchRejected `json:"rejected"`
+}
+
+type batchRejected struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// trackBatch accepts a JSON array of MobileEvent, usually gzip compressed,
+// from SDKs uploading events they queued while offline.
+func trackBatch(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path), slog.String("method", r.Method))
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	raw, body, err := readPayload(r, tracker.GetConfig().MaxBatchBytes)
+	if err != nil {
+		requestLogger.Error("Failed to read event batch", slog.Any("error", err))
+		payloadError(w, err)
+		return
+	}
+	batch, err := tracker.SplitBatch(raw)
+	if err != nil {
+		requestLogger.Debug("Rejected invalid event batch", slog.Any("error", err))
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The batch is signed as a whole, the signature is checked once for each
+	// site with a signing key
+	signed := make(map[string]error)
+
+	result := batchResult{Rejected: []batchRejected{}}
+	for i, ev := range batch {
+		trk, ua, err := tracker.DecodeMobileEvent(ev)
+		if err != nil {
+			result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: http.StatusBadRequest, Error: err.Error()})
+			continue
+		}
+		site, ok := sites.Get(trk.SiteID)
+		if ok {
+			if err := checkBrowserOrigin(site, r); err != nil {
+				result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: http.StatusForbidden, Error: err.Error()})
+				continue
+			}
+		}
+		if ok && site.SigningKey != "" {
+			err, checked := signed[site.ID]
+			if !checked {
+				err = checkSigned(site, r, body)
+				signed[site.ID] = err
+			}
+			if err != nil {
+				result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: signatureStatus(err), Error: err.Error()})
+				continue
+			}
+		}
+
+		if status := accept(r, requestLogger, nil, trk, ua); status == http.StatusAccepted {
+			result.Accepted++
+		} else {
+			result.Rejected = append(result.Rejected, batchRejected{Index: i, Status: status, Error: http.StatusText(status)})
+		}
+	}
+
+	requestLogger.Debug("Event batch tracked",
+		slog.Int("events", len(batch)),
+		slog.Int("accepted", result.Accepted),
+		slog.Int("rejected", len(result.Rejected)))
+	writeJSON(w, requestLogger, http.StatusAccepted, result)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tracker"
+)
+
+const (
+	sessionCookie = "_tk_session"
+	loginCookie   = "_tk_login"
+
+	// loginTTL is how long users have to sign in with the provider.
+	loginTTL = 10 * time.Minute
+)
+
+// oidc and sessions are nil unless OIDC_ISSUER is set. users are the roles of
+// the users signing in.
+var (
+	oidc     *tracker.OIDC
+	sessions *tracker.Sessions
+	users    tracker.Users
+)
+
+// setupOIDC discovers the provider of OIDC_ISSUER and loads USERS_FILE.
+func setupOIDC(ctx context.Context) error {
+	cfg := tracker.GetConfig()
+	if cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "" {
+		return errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+	if err := users.Load(cfg.UsersFile); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	client := &http.Client{Timeout: 10 * time.Second}
+	provider, err := tracker.DiscoverOIDC(ctx, client, cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL)
+	if err != nil {
+		return err
+	}
+
+	if cfg.SessionSecret == "" {
+		logger.Warn("SESSION_SECRET is not set, sessions end when the server restarts")
+	}
+	oidc, sessions = provider, tracker.NewSessions(cfg.SessionSecret)
+	return nil
+}
+
+// sessionToken returns the session token of a request, a bearer token or
+// the session cookie.
+func sessionToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// authLogin sends the user to the provider to sign in. return_to is where the
+// callback sends them back to: a path, or a page of an allowed CORS origin
+// such as the dashboard.
+func authLogin(w http.ResponseWriter, r *http.Request) {
+	returnTo := r.URL.Query().Get("return_to")
+	if !safeReturnTo(returnTo) {
+		http.Error(w, "Bad Request: return_to must be a path or an allowed origin", http.StatusBadRequest)
+		return
+	}
+
+	login := tracker.SessionClaims{
+		Use:      tracker.LoginUse,
+		State:    tracker.RandomToken(),
+		Nonce:    tracker.RandomToken(),
+		Verifier: tracker.RandomToken(),
+		ReturnTo: returnTo,
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    sessions.Issue(login, tracker.Now(), loginTTL),
+		Path:     "/auth/",
+		MaxAge:   int(loginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, oidc.AuthCodeURL(login.State, login.Nonce, login.Verifier), http.StatusFound)
+}
+
+// authCallback is where the provider sends users back to. It checks the ID
+// token and starts a session.
+func authCallback(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	if e := params.Get("error"); e != "" {
+		requestLogger.Warn("OIDC sign-in failed", slog.String("error", e), slog.String("description", params.Get("error_description")))
+		http.Error(w, "Unauthorized: sign-in failed", http.StatusUnauthorized)
+		return
+	}
+
+	now := tracker.Now()
+	c, err := r.Cookie(loginCookie)
+	if err != nil {
+		http.Error(w, "Bad Request: no sign-in in progress", http.StatusBadRequest)
+		return
+	}
+	login, err := sessions.Parse(c.Value, tracker.LoginUse, now)
+	if err != nil || params.Get("state") == "" || params.Get("state") != login.State {
+		http.Error(w, "Bad Request: sign-in expired or state mismatch", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: "/auth/", MaxAge: -1, HttpOnly: true, Secure: isHTTPS(r)})
+
+	idToken, err := oidc.Exchange(r.Context(), params.Get("code"), login.Verifier)
+	if err != nil {
+		requestLogger.Error("Failed to exchange OIDC code", slog.Any("error", err))
+		http.Error(w, "Bad Gateway: sign-in failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := oidc.Verify(r.Context(), idToken, login.Nonce, now)
+	if err != nil {
+		requestLogger.Warn("Rejected OIDC ID token", slog.Any("error", err))
+		http.Error(w, "Unauthorized: invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	// Roles are granted by email, only trust the ones the provider checked
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		http.Error(w, "Forbidden: a verified email is required", http.StatusForbidden)
+		return
+	}
+
+	ttl := tracker.GetConfig().SessionTTL
+	session := tracker.SessionClaims{Use: tracker.SessionUse, Subject: claims.Subject, Email: claims.Email, Name: claims.Name}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sessions.Issue(session, now, ttl),
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	requestLogger.Info("User signed in", slog.String("email", claims.Email))
+
+	returnTo := login.ReturnTo
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// authLogout ends the session of the cookie. Sessions are stateless, bearer
+// tokens stay valid until they expire.
+func authLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: isHTTPS(r)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type me struct {
+	Name      string          `json:"name"`
+	Superuser bool            `json:"superuser"`
+	Grants    []tracker.Grant `json:"grants"`
+}
+
+// authMe returns who the request is authenticated as and their roles, for
+// the dashboard to know whether to sign in.
+func authMe(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	p, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	grants := p.Grants
+	if grants == nil {
+		grants = []tracker.Grant{}
+	}
+	writeJSON(w, requestLogger, http.StatusOK, me{Name: p.Name, Superuser: p.Superuser, Grants: grants})
+}
+
+// safeReturnTo reports whether users can be sent back to returnTo after
+// signing in, so the callback can't redirect anywhere else.
+func safeReturnTo(returnTo string) bool {
+	if returnTo == "" {
+		return true
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\")
+	}
+	for _, origin := range tracker.GetConfig().CORSOrigins {
+		if u.Scheme+"://"+u.Host == origin {
+			return true
+		}
+	}
+	return false
+}
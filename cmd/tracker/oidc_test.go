@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tracker"
+)
+
+func TestSession(t *testing.T) {
+	setupTrack()
+	path := filepath.Join(t.TempDir(), "users.json")
+	data := `[{"email":"Ann@example.com","grants":[{"site":"a","role":"viewer"}]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	sessions = tracker.NewSessions("secret")
+	defer func() { sessions = nil; users.Load("") }()
+
+	token := sessions.Issue(tracker.SessionClaims{Use: tracker.SessionUse, Email: "ann@example.com"}, tracker.Now(), time.Hour)
+	statsOf := func(site string) string {
+		return `{"what":"pages","siteId":"` + site + `","start":20240301,"end":20240301}`
+	}
+	h := requireRole(tracker.RoleViewer, tracker.RoleViewer, stats)
+
+	tests := []struct {
+		name   string
+		set    func(r *http.Request)
+		site   string
+		status int
+	}{
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token}) }, "a", http.StatusOK},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, "a", http.StatusOK},
+		{"other site", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, "b", http.StatusForbidden},
+		{"forged", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token+"x") }, "a", http.StatusUnauthorized},
+		{"none", func(r *http.Request) {}, "a", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/stats", strings.NewReader(statsOf(tt.site)))
+		tt.set(r)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/auth/me", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	authMe(w, r)
+	var got me
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Name != "ann@example.com" || len(got.Grants) != 1 {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	// The callback only continues a sign-in started with the same state
+	login := sessions.Issue(tracker.SessionClaims{Use: tracker.LoginUse, State: "s"}, tracker.Now(), time.Minute)
+	for _, c := range []*http.Cookie{nil, {Name: loginCookie, Value: login}, {Name: loginCookie, Value: token}} {
+		r := httptest.NewRequest("GET", "/auth/callback?code=c&state=other", nil)
+		if c != nil {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		authCallback(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("cookie %v: got status %d", c, w.Code)
+		}
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	setupTrack()
+	for returnTo, want := range map[string]bool{
+		"":                              true,
+		"/dashboard":                    true,
+		"http://localhost:5173/sites/a": true,
+		"//evil.example":                false,
+		"/\\evil.example":               false,
+		"https://evil.example/":         false,
+		"javascript:alert(1)":           false,
+	} {
+		if got := safeReturnTo(returnTo); got != want {
+			t.Errorf("%q: got %v", returnTo, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"tracker"
+)
+
+// rateLimits counts the stats queries of every API key.
+var rateLimits = tracker.NewRateLimiter()
+
+// rateLimited rejects stats queries of keys over their rate limit or daily
+// quota with 429. Responses tell the key where it stands in X-RateLimit-*
+// headers: Limit, Remaining and Reset (unix seconds) for the minute, and
+// the same prefixed with Daily- for the day. It runs after requireRole.
+func rateLimited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := principalOf(r)
+		if !ok {
+			h(w, r)
+			return
+		}
+		now := tracker.Now()
+		status := rateLimits.Take(p, now)
+		header := w.Header()
+		if status.Limit > 0 && !p.Superuser {
+			header.Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(status.Reset.Unix(), 10))
+		}
+		if status.DailyLimit > 0 && !p.Superuser {
+			header.Set("X-RateLimit-Daily-Limit", strconv.Itoa(status.DailyLimit))
+			header.Set("X-RateLimit-Daily-Remaining", strconv.Itoa(status.DailyRemaining))
+			header.Set("X-RateLimit-Daily-Reset", strconv.FormatInt(status.DailyReset.Unix(), 10))
+		}
+		if !status.Allowed {
+			logger.Warn("Rate limited stats query", slog.String("path", r.URL.Path), slog.String("actor", p.Name))
+			header.Set("Retry-After", strconv.Itoa(int(status.RetryAfter(now).Seconds())+1))
+			http.Error(w, "Too Many Requests: stats query limit reached", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
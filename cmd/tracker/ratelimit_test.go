@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tracker"
+)
+
+func TestRateLimited(t *testing.T) {
+	prev := rateLimits
+	defer func() { rateLimits = prev }()
+	rateLimits = tracker.NewRateLimiter()
+
+	h := rateLimited(func(w http.ResponseWriter, r *http.Request) {})
+	p := tracker.Principal{Name: t.Name(), Grants: []tracker.Grant{{Site: "s", Role: tracker.RoleViewer}}, RateLimit: 1}
+	call := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/stats?siteId=s", nil)
+		w := httptest.NewRecorder()
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+		return w
+	}
+
+	if w := call(); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "1" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("first: got %d %v", w.Code, w.Header())
+	}
+	if w := call(); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("second: got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"tracker"
+)
+
+// maxRealtimeTop caps the values listed per dimension by realtimeTops.
+const maxRealtimeTop = 100
+
+// realtimeTops returns the page views of ?site over the last 30 minutes and
+// its top pages, referrer domains and countries, limit of each, 10 by
+// default. They are counted in memory as events are ingested, only events
+// this instance ingested are counted.
+func realtimeTops(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	siteID := params.Get("site")
+	if siteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxRealtimeTop {
+			http.Error(w, "Bad Request: limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+	if !permitted(w, r, tracker.RoleViewer, siteID) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, requestLogger, http.StatusOK, realtime.Top(siteID, limit, tracker.Now()))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tracker"
+)
+
+func TestRealtimeTops(t *testing.T) {
+	setupTrack()
+
+	now := tracker.Now()
+	for _, path := range []string{"/", "/pricing", "/"} {
+		trk := tracker.Tracking{SiteID: t.Name(), Action: tracker.TrackingData{Type: "page", Category: tracker.PageviewCategory, Event: path}}
+		realtime.Observe(trk, &tracker.GeoInfo{Country: "France"}, now)
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/stats/realtime?"+query, nil)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, realtimeTops)(w, r)
+		return w
+	}
+	w := get("site=" + t.Name() + "&limit=1")
+	var tops tracker.RealtimeTops
+	if err := json.Unmarshal(w.Body.Bytes(), &tops); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if tops.Pageviews != 3 || len(tops.Pages) != 1 || tops.Pages[0] != (tracker.RealtimeCount{Value: "/", Count: 2}) || tops.Countries[0].Value != "France" {
+		t.Errorf("got %+v", tops)
+	}
+	if w := get("site=" + t.Name() + "&limit=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad limit: got %d", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"tracker"
+)
+
+// rollup rebuilds the rollups of a site between two days from its raw
+// events, after backfills, imports or late events.
+func rollup(args []string) {
+	fs := flag.NewFlagSet("rollup", flag.ExitOnError)
+	site := fs.String("site", "", "site to rebuild, every site when empty")
+	from := fs.String("from", "", "first day to rebuild, YYYYMMDD")
+	to := fs.String("to", "", "last day to rebuild, YYYYMMDD, the from day by default")
+	timeout := fs.Duration("timeout", time.Hour, "time allowed for the rebuild")
+	fs.Parse(args)
+
+	start, err := dayParam(*from, 0)
+	if err != nil || start == 0 {
+		fmt.Fprintln(os.Stderr, "-from must be a YYYYMMDD day")
+		os.Exit(2)
+	}
+	end, err := dayParam(*to, start)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-to must be a YYYYMMDD day")
+		os.Exit(2)
+	}
+
+	events := &tracker.Events{}
+	if err := events.Open(); err != nil {
+		logger.Error("Failed to connect to ClickHouse", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer events.DB.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if version, err := events.SchemaVersion(ctx); err != nil || version != tracker.LatestSchemaVersion() {
+		logger.Error("Schema is not up to date, start the tracker first", slog.Int("version", int(version)), slog.Any("error", err))
+		os.Exit(1)
+	}
+	if err := events.RebuildRollups(ctx, *site, start, end); err != nil {
+		logger.Error("Failed to rebuild rollups", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
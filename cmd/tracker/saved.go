@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// segments lists a site's segments (GET ?site=) and creates segments (POST).
+func segments(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site")
+		if siteID == "" {
+			http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, siteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, saved.Segments(siteID))
+	case http.MethodPost:
+		var seg tracker.Segment
+		if !decodeJSON(w, r, &seg) {
+			return
+		}
+		if !permitted(w, r, tracker.RoleAdmin, seg.SiteID) {
+			return
+		}
+		seg.ID = ""
+		putSegment(w, requestLogger, seg, http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// segment reads (GET), replaces (PUT) and deletes (DELETE) /segments/{id}.
+func segment(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	id := r.PathValue("id")
+	// Changing a segment takes admin on its site, the site itself can't change
+	if prev, ok := saved.Segment(id); ok && r.Method != http.MethodGet && !permitted(w, r, tracker.RoleAdmin, prev.SiteID) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		seg, ok := saved.Segment(id)
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, seg.SiteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, seg)
+	case http.MethodPut:
+		var seg tracker.Segment
+		if !decodeJSON(w, r, &seg) {
+			return
+		}
+		seg.ID = id
+		putSegment(w, requestLogger, seg, http.StatusOK)
+	case http.MethodDelete:
+		savedError(w, requestLogger, saved.DeleteSegment(id), http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func putSegment(w http.ResponseWriter, requestLogger *slog.Logger, seg tracker.Segment, status int) {
+	seg, err := saved.PutSegment(seg)
+	if err != nil {
+		savedError(w, requestLogger, err, status)
+		return
+	}
+	writeJSON(w, requestLogger, status, seg)
+}
+
+// reports lists a site's reports (GET ?site=) and creates reports (POST).
+func reports(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site")
+		if siteID == "" {
+			http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, siteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, saved.Reports(siteID))
+	case http.MethodPost:
+		var rep tracker.Report
+		if !decodeJSON(w, r, &rep) {
+			return
+		}
+		if !permitted(w, r, tracker.RoleAdmin, rep.SiteID) {
+			return
+		}
+		rep.ID = ""
+		putReport(w, requestLogger, rep, http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// report reads (GET), replaces (PUT) and deletes (DELETE) /reports/{id}.
+func report(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	id := r.PathValue("id")
+	// Changing a report takes admin on its site, the site itself can't change
+	if prev, ok := saved.Report(id); ok && r.Method != http.MethodGet && !permitted(w, r, tracker.RoleAdmin, prev.SiteID) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rep, ok := saved.Report(id)
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if !permitted(w, r, tracker.RoleViewer, rep.SiteID) {
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, rep)
+	case http.MethodPut:
+		var rep tracker.Report
+		if !decodeJSON(w, r, &rep) {
+			return
+		}
+		rep.ID = id
+		putReport(w, requestLogger, rep, http.StatusOK)
+	case http.MethodDelete:
+		savedError(w, requestLogger, saved.DeleteReport(id), http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runReport returns the stats of /reports/{id}/stats for its current range.
+func runReport(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+	rep, ok := saved.Report(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, rep.SiteID) {
+		return
+	}
+	data, err := saved.ReportData(rep, tracker.Now())
+	if err != nil {
+		savedError(w, requestLogger, err, 0)
+		return
+	}
+	result, err := events.GetStats(r.Context(), data)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get report stats from database", err)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, result)
+}
+
+func putReport(w http.ResponseWriter, requestLogger *slog.Logger, rep tracker.Report, status int) {
+	rep, err := saved.PutReport(rep)
+	if err != nil {
+		savedError(w, requestLogger, err, status)
+		return
+	}
+	writeJSON(w, requestLogger, status, rep)
+}
+
+// savedError maps errors from the saved segments and reports to responses,
+// writing status when there is none.
+func savedError(w http.ResponseWriter, requestLogger *slog.Logger, err error, status int) {
+	switch {
+	case err == nil:
+		w.WriteHeader(status)
+	case errors.Is(err, tracker.ErrNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, tracker.ErrInUse):
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+	case errors.Is(err, tracker.ErrInvalid):
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+	default:
+		requestLogger.Error("Failed to save segments and reports", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	defer r.Body.Close()
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(v); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, requestLogger *slog.Logger, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		requestLogger.Error("Failed to encode response", slog.Any("error", err))
+	}
+}
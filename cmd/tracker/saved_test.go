@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestRunReportSegment(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+	for i, path := range []string{"/", "/docs", "/docs/api"} {
+		payload := `{"tracking":{"type":"page","event":"` + path + `","category":"Page views","identity":"u` + strconv.Itoa(i) + `"},"site_id":"reported"}`
+		track(httptest.NewRecorder(), httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	}
+	flushEvents(t)
+
+	seg, err := saved.PutSegment(tracker.Segment{SiteID: "reported", Name: "Docs", Filters: map[string]string{"path": "/docs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(segmentID string) tracker.StatsResult {
+		t.Helper()
+		rep, err := saved.PutReport(tracker.Report{SiteID: "reported", Name: "Pages", Metric: tracker.QueryPageViewList, RangeDays: 1, SegmentID: segmentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/reports/"+rep.ID+"/stats", nil)
+		r.SetPathValue("id", rep.ID)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, runReport)(w, r)
+
+		var result tracker.StatsResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("got %d %s", w.Code, w.Body)
+		}
+		return result
+	}
+
+	if result := run(""); len(result.Data) != 3 {
+		t.Errorf("without a segment: got %+v", result.Data)
+	}
+	result := run(seg.ID)
+	if len(result.Data) != 1 || result.Data[0].Value != "/docs" || result.Data[0].Count != 1 {
+		t.Errorf("with a segment: got %+v", result.Data)
+	}
+	if result.Meta.Filters["path"] != "/docs" {
+		t.Errorf("got filters %+v", result.Meta.Filters)
+	}
+}
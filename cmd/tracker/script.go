@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"tracker"
+)
+
+// script serves the embedded tracking script at /js/script.js, which follows
+// the latest version, and at its versioned path, which never changes and is
+// cached for good. Appending .integrity to either returns the script's SRI
+// hash for pinning it.
+func script(w http.ResponseWriter, r *http.Request) {
+	versioned := strings.TrimPrefix(tracker.ScriptPath(), "/js/")
+
+	file := r.PathValue("file")
+	integrity := strings.HasSuffix(file, ".integrity")
+	switch strings.TrimSuffix(file, ".integrity") {
+	case "script.js":
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	case versioned:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	// Cross-origin scripts are only checked against their integrity when
+	// loaded with CORS
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+tracker.ScriptIntegrity()+`"`)
+
+	if integrity {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(tracker.ScriptIntegrity()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(tracker.Script()))
+}
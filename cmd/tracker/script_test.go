@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tracker"
+)
+
+func TestScript(t *testing.T) {
+	for _, tt := range []struct {
+		path   string
+		status int
+		cache  string
+		body   string
+	}{
+		{"/js/script.js", http.StatusOK, "public, max-age=3600", string(tracker.Script())},
+		{tracker.ScriptPath(), http.StatusOK, "public, max-age=31536000, immutable", string(tracker.Script())},
+		{"/js/script.js.integrity", http.StatusOK, "public, max-age=3600", tracker.ScriptIntegrity()},
+		{tracker.ScriptPath() + ".integrity", http.StatusOK, "public, max-age=31536000, immutable", tracker.ScriptIntegrity()},
+		{"/js/script.v1.js", http.StatusNotFound, "", ""},
+		{"/js/script.js.map", http.StatusNotFound, "", ""},
+	} {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /js/{file}", script)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cache {
+			t.Errorf("%s: got Cache-Control %q", tt.path, got)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: got body %.40q", tt.path, w.Body.String())
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s: not served with CORS", tt.path)
+		}
+	}
+
+	// Revalidation by the ETag
+	r := httptest.NewRequest("GET", "/js/script.js", nil)
+	r.Header.Set("If-None-Match", `"`+tracker.ScriptIntegrity()+`"`)
+	r.SetPathValue("file", "script.js")
+	w := httptest.NewRecorder()
+	script(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got status %d", w.Code)
+	}
+}
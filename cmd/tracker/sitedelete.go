@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tracker"
+)
+
+// siteDeleter deletes the data of sites, nil when the store can't.
+var siteDeleter tracker.SiteDeleter
+
+// adminDeleteSite deletes /admin/sites/{id} and all its data for good: its
+// registration and keys, raw events, rollups, web vitals and JavaScript
+// errors, saved segments, reports, campaigns, dashboards and alert rules,
+// the grants of API keys on it and what is cached or counted about it in
+// memory. The site is unregistered first and its queued events written, so
+// events still sent to it are rejected unless SITE_VERIFICATION is off. The
+// deletion is recorded in the audit log with what it removed. Deleting a
+// site again removes what was left behind.
+func adminDeleteSite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	if siteDeleter == nil {
+		http.Error(w, "Not Found: the store can't delete sites", http.StatusNotFound)
+		return
+	}
+	deletion := tracker.SiteDeletion{SiteID: r.PathValue("id")}
+
+	err := sites.Delete(deletion.SiteID)
+	if err != nil && !errors.Is(err, tracker.ErrNotFound) {
+		requestLogger.Error("Failed to save sites", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	deletion.Registered = err == nil
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+	if queue != nil {
+		if err := queue.Flush(ctx); err != nil {
+			requestLogger.Error("Failed to flush queue before deletion", slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if deletion.Events, err = siteDeleter.DeleteSite(ctx, deletion.SiteID); err != nil {
+		requestLogger.Error("Failed to delete site events", slog.String("site", deletion.SiteID), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if deletion.Saved, err = saved.DeleteSite(deletion.SiteID); err != nil {
+		requestLogger.Error("Failed to delete saved objects of site", slog.String("site", deletion.SiteID), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if deletion.Grants, err = keys.RevokeSite(deletion.SiteID); err != nil {
+		requestLogger.Error("Failed to revoke grants on site", slog.String("site", deletion.SiteID), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	quotas.Forget(deletion.SiteID)
+	realtime.Forget(deletion.SiteID)
+	marks.Forget(deletion.SiteID)
+	visits.Forget(deletion.SiteID)
+	replays.Forget(deletion.SiteID)
+	unlisted.Forget(deletion.SiteID)
+	publicSeries.Forget(deletion.SiteID)
+	if warmed != nil {
+		warmed.Forget(deletion.SiteID)
+	}
+
+	requestLogger.Info("Deleted site and its data", slog.Any("deletion", deletion))
+	if auditor != nil {
+		// The request is audited too, without the site and what it removed
+		summary, _ := json.Marshal(deletion)
+		entry := tracker.AuditEntry{
+			Actor:    actor(r),
+			RemoteIP: remoteIP(r),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			SiteID:   deletion.SiteID,
+			Query:    string(summary),
+			Status:   http.StatusOK,
+		}
+		auditCtx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		auditor.Record(auditCtx, entry)
+	}
+	webhooks.Fire(tracker.HookSiteDeleted, deletion.SiteID, deletion)
+	writeJSON(w, requestLogger, http.StatusOK, deletion)
+}
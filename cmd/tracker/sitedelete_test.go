@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tracker"
+)
+
+func TestAdminDeleteSite(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+	siteDeleter = events.(tracker.SiteDeleter)
+	mem := events.(*tracker.MemoryEvents)
+	auditor, auditLog = tracker.NewAuditor(mem), mem
+	ctx, cancel := context.WithCancel(context.Background())
+	go auditor.Run(ctx)
+	t.Cleanup(func() { siteDeleter, auditor, auditLog = nil, nil, nil })
+
+	if _, _, err := sites.Ensure(tracker.Site{ID: "deleted", Domain: "deleted.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saved.PutSegment(tracker.Segment{SiteID: "deleted", Name: "Docs", Filters: map[string]string{"path": "/docs"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{"deleted /", "deleted /docs", "kept /docs"} {
+		site, path, _ := strings.Cut(event, " ")
+		payload := `{"tracking":{"type":"page","event":"` + path + `","category":"Page views"},"site_id":"` + site + `"}`
+		track(httptest.NewRecorder(), httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	}
+	flushEvents(t)
+	realtime.Observe(tracker.Tracking{SiteID: "deleted", Action: tracker.TrackingData{Event: "/docs", Category: tracker.PageviewCategory}}, nil, time.Now())
+
+	del := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("DELETE", "/admin/sites/"+id, nil)
+		r.SetPathValue("id", id)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleOwner, tracker.RoleOwner, adminDeleteSite)(w, r)
+		return w
+	}
+	w := del("deleted")
+	var got tracker.SiteDeletion
+	if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if want := (tracker.SiteDeletion{SiteID: "deleted", Registered: true, Events: 2, Saved: 1}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if _, ok := sites.Get("deleted"); ok {
+		t.Error("site still registered")
+	}
+	if segs := saved.Segments("deleted"); len(segs) != 0 {
+		t.Errorf("segments left: %+v", segs)
+	}
+	if tops := realtime.Top("deleted", 5, time.Now()); tops.Pageviews != 0 {
+		t.Errorf("realtime counts left: %+v", tops)
+	}
+	if usage, _ := events.Usage(context.Background(), tracker.UsageQuery{SiteID: "kept", Start: 0, End: tracker.Today()}); len(usage) != 1 || usage[0].Events != 1 {
+		t.Errorf("other site: got usage %+v", usage)
+	}
+
+	// Deleting again finds nothing left
+	w = del("deleted")
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got != (tracker.SiteDeletion{SiteID: "deleted"}) {
+		t.Errorf("deleting again: got %+v, %v", got, err)
+	}
+
+	cancel()
+	auditor.Wait()
+	entries, err := mem.Audit(context.Background(), tracker.AuditQuery{
+		Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour), SiteID: "deleted", Limit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first
+	if len(entries) != 2 || !strings.Contains(entries[1].Query, `"events":2`) || entries[1].Actor != "api-key" {
+		t.Errorf("got audit entries %+v", entries)
+	}
+}
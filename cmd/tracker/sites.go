@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tracker"
+)
+
+// verifier checks sites control their domain.
+var verifier = tracker.NewVerifier()
+
+// siteVerification tells a site's owner how to verify it.
+type siteVerification struct {
+	Site     tracker.Site `json:"site"`
+	Verified bool         `json:"verified"`
+	Record   string       `json:"dnsTxtRecord,omitempty"`
+	Meta     string       `json:"metaTag,omitempty"`
+}
+
+// verificationOf returns site as the caller of r may see it. Keys and their
+// hashes never leave the registry, the verification and public dashboard
+// tokens are only shown to the site's owners.
+func verificationOf(r *http.Request, site tracker.Site) siteVerification {
+	v := siteVerification{Site: site, Verified: !site.Unverified}
+	v.Site.SigningKey = ""
+	v.Site.ReadKeyHash, v.Site.WriteKeyHash = "", ""
+	if p, _ := principalOf(r); !p.Can(site, tracker.RoleOwner) {
+		v.Site.VerificationToken, v.Site.PublicToken = "", ""
+		return v
+	}
+	if site.Unverified {
+		v.Record, v.Meta = tracker.VerificationRecord(site), tracker.VerificationMeta(site)
+	}
+	return v
+}
+
+// listSites returns the registered sites the caller can view.
+func listSites(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	p, _ := principalOf(r)
+	list := []siteVerification{}
+	for _, site := range sites.List() {
+		if p.Can(site, tracker.RoleViewer) {
+			list = append(list, verificationOf(r, site))
+		}
+	}
+	writeJSON(w, requestLogger, http.StatusOK, list)
+}
+
+// getSite returns the registered site /sites/{id}.
+func getSite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleViewer, id) {
+		return
+	}
+	site, ok := sites.Get(id)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, verificationOf(r, site))
+}
+
+// siteKey is the response of setSiteKey, the key is only ever shown there.
+type siteKey struct {
+	Kind tracker.SiteKeyKind `json:"kind"`
+	Role tracker.Role        `json:"role"`
+	Key  string              `json:"key"`
+}
+
+// setSiteKey gives /sites/{id} a new read or write key on POST, replacing
+// the previous one, and revokes it on DELETE.
+func setSiteKey(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleOwner, id) {
+		return
+	}
+	kind := tracker.SiteKeyKind(r.PathValue("kind"))
+	if r.Method == http.MethodDelete {
+		err := sites.RevokeKey(id, kind)
+		if err == nil {
+			requestLogger.Info("Revoked site key", slog.String("site", id), slog.String("kind", string(kind)))
+		}
+		savedError(w, requestLogger, err, http.StatusNoContent)
+		return
+	}
+
+	key, err := sites.SetKey(id, kind)
+	if err != nil {
+		savedError(w, requestLogger, err, 0)
+		return
+	}
+	requestLogger.Info("Set site key", slog.String("site", id), slog.String("kind", string(kind)))
+	writeJSON(w, requestLogger, http.StatusCreated, siteKey{Kind: kind, Role: kind.Role(), Key: key})
+}
+
+// createSite registers a site posted as {id, domain, org}. Keys owning the
+// org can register its sites. While SITE_VERIFICATION is required the site
+// stays inactive until verified, the response tells how. Registering the
+// same site again answers 200 with the registered site, so retries are safe.
+// Only superusers can register an id that already has events.
+func createSite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var site tracker.Site
+	if !decodeJSON(w, r, &site) {
+		return
+	}
+	if site.ID == "" {
+		http.Error(w, "Bad Request: id is required", http.StatusBadRequest)
+		return
+	}
+	if err := tracker.ValidDomain(site.Domain); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, ok := principalOf(r)
+	if !ok || !p.Can(site, tracker.RoleOwner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// Events sent for an id before it was registered belong to whoever
+	// sent them, an org owner registering it would get to read them
+	if _, registered := sites.Get(site.ID); !registered && !p.Superuser {
+		s, err := events.Summary(r.Context(), site.ID)
+		if err != nil {
+			queryError(w, requestLogger, "Failed to get site summary from database", err)
+			return
+		}
+		if s.FirstDay != 0 {
+			http.Error(w, "Conflict: this id already has events, ask an administrator to register it", http.StatusConflict)
+			return
+		}
+	}
+
+	want := tracker.Site{ID: site.ID, Domain: site.Domain, Org: site.Org}
+	if tracker.GetConfig().SiteVerification == tracker.VerificationRequired {
+		want.Unverified, want.VerificationToken = true, tracker.RandomToken()
+	}
+	site, created, err := sites.Ensure(want)
+	if err != nil {
+		requestLogger.Error("Failed to save site", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		if site.Domain != want.Domain || site.Org != want.Org {
+			http.Error(w, "Conflict: a site with this id exists", http.StatusConflict)
+			return
+		}
+		writeJSON(w, requestLogger, http.StatusOK, verificationOf(r, site))
+		return
+	}
+	requestLogger.Info("Registered site", slog.String("site", site.ID), slog.String("domain", site.Domain))
+	webhooks.Fire(tracker.HookSiteCreated, site.ID, nil)
+	writeJSON(w, requestLogger, http.StatusCreated, verificationOf(r, site))
+}
+
+// siteVerificationStatus returns whether /sites/{id} is verified, and the
+// DNS record and meta tag to publish when it isn't.
+func siteVerificationStatus(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleOwner, id) {
+		return
+	}
+	site, ok := sites.Get(id)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, verificationOf(r, site))
+}
+
+// verifySite looks for the token of /sites/{id} in its domain's DNS TXT
+// records and home page, and activates the site when found.
+func verifySite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleOwner, id) {
+		return
+	}
+	site, ok := sites.Get(id)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !site.Unverified {
+		writeJSON(w, requestLogger, http.StatusOK, verificationOf(r, site))
+		return
+	}
+
+	method, err := verifier.Verify(r.Context(), site)
+	if errors.Is(err, tracker.ErrNotVerified) || errors.Is(err, tracker.ErrInvalidDomain) {
+		requestLogger.Info("Site verification failed", slog.String("site", id), slog.Any("error", err))
+		http.Error(w, "Unprocessable Entity: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		requestLogger.Error("Failed to verify site", slog.String("site", id), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	now := tracker.Now().UTC()
+	site, err = sites.Update(id, func(s *tracker.Site) {
+		s.Unverified, s.VerificationToken, s.VerifiedAt = false, "", &now
+	})
+	if err != nil {
+		requestLogger.Error("Failed to save site", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requestLogger.Info("Verified site", slog.String("site", id), slog.String("method", method))
+	writeJSON(w, requestLogger, http.StatusOK, verificationOf(r, site))
+}
+
+// siteBlocked returns the events of /sites/{id} dropped by country since the
+// tracker started, along with the countries it allows and blocks. Events
+// are blocked where they are ingested, an ingest-only process counts them
+// on /debug/vars instead.
+func siteBlocked(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleViewer, id) {
+		return
+	}
+	site, ok := sites.Get(id)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, siteBlocks{
+		Allowed: site.AllowedCountries,
+		Blocked: site.BlockedCountries,
+		Events:  blocks.Counts(id),
+	})
+}
+
+// siteBlocks are a site's country rules and the events they dropped.
+type siteBlocks struct {
+	Allowed []string          `json:"allowedCountries,omitempty"`
+	Blocked []string          `json:"blockedCountries,omitempty"`
+	Events  map[string]uint64 `json:"blockedEvents"`
+}
+
+// siteKinds returns the event kinds /sites/{id} accepts and the events of
+// other kinds it received since the tracker started, rejected or flagged.
+func siteKinds(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleViewer, id) {
+		return
+	}
+	site, ok := sites.Get(id)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	policy := site.UnlistedKinds
+	if policy == "" {
+		policy = tracker.KindReject
+	}
+	writeJSON(w, requestLogger, http.StatusOK, siteKindList{
+		Allowed:  site.AllowedKinds,
+		Unlisted: policy,
+		Events:   unlisted.Counts(id),
+	})
+}
+
+// siteKindList is a site's event kinds and the events of unlisted kinds.
+type siteKindList struct {
+	Allowed  []string           `json:"allowedKinds,omitempty"`
+	Unlisted tracker.KindPolicy `json:"unlistedKinds"`
+	Events   map[string]uint64  `json:"unlistedEvents"`
+}
+
+// siteLate returns the time of the latest event of /sites/{id} and the days
+// late events landed on since the tracker started, whose stats are stale.
+func siteLate(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleViewer, id) {
+		return
+	}
+	late := siteLateness{Days: map[string]uint64{}}
+	if mark := marks.Watermark(id); !mark.IsZero() {
+		late.Watermark = &mark
+	}
+	for day, n := range marks.LateDays(id) {
+		late.Days[strconv.Itoa(int(day))] = n
+	}
+	writeJSON(w, requestLogger, http.StatusOK, late)
+}
+
+// siteLateness is a site's watermark and its late events per YYYYMMDD day.
+type siteLateness struct {
+	Watermark *time.Time        `json:"watermark,omitempty"`
+	Days      map[string]uint64 `json:"lateDays"`
+}
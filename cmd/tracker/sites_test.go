@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestSiteVerification(t *testing.T) {
+	setupTrack()
+	setConfig(t, "SITE_VERIFICATION", "required")
+
+	var published []string
+	defer func(prev *tracker.Verifier) { verifier = prev }(verifier)
+	offline := &http.Transport{Proxy: func(*http.Request) (*url.URL, error) { return nil, errors.New("offline") }}
+	verifier = &tracker.Verifier{
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) { return published, nil },
+		Client:    &http.Client{Transport: offline},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sites", createSite)
+	mux.HandleFunc("POST /sites/{id}/verify", verifySite)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	beacon := func(site string) int {
+		payload := `{"tracking":{"type":"page","event":"/` + t.Name() + `","category":"Page views"},"site_id":"` + site + `"}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		return w.Code
+	}
+
+	w := call("POST", "/sites", `{"id":"claimed","domain":"claimed.example"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d: %s", w.Code, w.Body)
+	}
+	var v siteVerification
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || v.Verified || v.Record == "" {
+		t.Fatalf("create: got %+v, %v", v, err)
+	}
+	w = call("POST", "/sites", `{"id":"claimed","domain":"claimed.example"}`)
+	var again siteVerification
+	if err := json.Unmarshal(w.Body.Bytes(), &again); w.Code != http.StatusOK || err != nil || again.Record != v.Record {
+		t.Errorf("create same again: got status %d, %+v, %v", w.Code, again, err)
+	}
+	if w := call("POST", "/sites", `{"id":"claimed","domain":"other.example"}`); w.Code != http.StatusConflict {
+		t.Errorf("create again: got status %d", w.Code)
+	}
+	if w := call("POST", "/sites", `{"id":"x","domain":"http://x.example/"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("create with URL: got status %d", w.Code)
+	}
+
+	if code := beacon("claimed"); code != http.StatusForbidden {
+		t.Errorf("unverified site: got status %d", code)
+	}
+	if code := beacon("never-registered"); code != http.StatusForbidden {
+		t.Errorf("unregistered site: got status %d", code)
+	}
+
+	if w := call("POST", "/sites/claimed/verify", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("verify before publishing: got status %d", w.Code)
+	}
+	published = []string{v.Record}
+	if w := call("POST", "/sites/claimed/verify", ""); w.Code != http.StatusOK {
+		t.Fatalf("verify: got status %d: %s", w.Code, w.Body)
+	}
+	if code := beacon("claimed"); code != http.StatusAccepted {
+		t.Errorf("verified site: got status %d", code)
+	}
+}
+
+func TestSiteKeys(t *testing.T) {
+	setupTrack()
+	setConfig(t, "API_KEY", "root")
+	sites.Ensure(tracker.Site{ID: "keyed-site", VerificationToken: "verify", PublicToken: "public"})
+	sites.Ensure(tracker.Site{ID: "other-site"})
+	defer sites.RevokeKey("keyed-site", tracker.SiteKeyRead)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sites", requireRole(tracker.RoleViewer, tracker.RoleViewer, listSites))
+	mux.HandleFunc("GET /sites/{id}", requireRole(tracker.RoleViewer, tracker.RoleViewer, getSite))
+	mux.HandleFunc("/sites/{id}/keys/{kind}", requireRole(tracker.RoleOwner, tracker.RoleOwner, setSiteKey))
+	mux.HandleFunc("/stats", requireRole(tracker.RoleViewer, tracker.RoleViewer, stats))
+	call := func(key, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-API-KEY", key)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("root", "POST", "/sites/keyed-site/keys/read", "")
+	var key siteKey
+	if err := json.Unmarshal(w.Body.Bytes(), &key); w.Code != http.StatusCreated || err != nil || key.Key == "" || key.Role != tracker.RoleViewer {
+		t.Fatalf("create key: got status %d: %s", w.Code, w.Body)
+	}
+	if w := call("root", "POST", "/sites/keyed-site/keys/owner", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown kind: got status %d", w.Code)
+	}
+
+	statsOf := func(site string) string {
+		return `{"what":"pages","siteId":"` + site + `","start":20240301,"end":20240301}`
+	}
+	if w := call(key.Key, "POST", "/stats", statsOf("keyed-site")); w.Code != http.StatusOK {
+		t.Errorf("own stats: got status %d: %s", w.Code, w.Body)
+	}
+	if w := call(key.Key, "POST", "/stats", statsOf("other-site")); w.Code != http.StatusForbidden {
+		t.Errorf("other stats: got status %d", w.Code)
+	}
+	if w := call(key.Key, "POST", "/sites/keyed-site/keys/write", ""); w.Code != http.StatusForbidden {
+		t.Errorf("read key creating keys: got status %d", w.Code)
+	}
+
+	var list []siteVerification
+	json.Unmarshal(call(key.Key, "GET", "/sites", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Site.ID != "keyed-site" || list[0].Site.ReadKeyHash != "" {
+		t.Errorf("list: got %+v", list)
+	}
+	// Tokens are for owners only
+	if v := list[0].Site; v.VerificationToken != "" || v.PublicToken != "" {
+		t.Errorf("viewer sees tokens: got %+v", v)
+	}
+	var owned siteVerification
+	json.Unmarshal(call("root", "GET", "/sites/keyed-site", "").Body.Bytes(), &owned)
+	if owned.Site.VerificationToken != "verify" || owned.Site.PublicToken != "public" || owned.Site.ReadKeyHash != "" {
+		t.Errorf("owner: got %+v", owned.Site)
+	}
+	if w := call(key.Key, "GET", "/sites/other-site", ""); w.Code != http.StatusForbidden {
+		t.Errorf("other site: got status %d", w.Code)
+	}
+
+	if w := call("root", "DELETE", "/sites/keyed-site/keys/read", ""); w.Code != http.StatusNoContent {
+		t.Errorf("revoke: got status %d", w.Code)
+	}
+	if w := call(key.Key, "POST", "/stats", statsOf("keyed-site")); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: got status %d", w.Code)
+	}
+}
+
+func TestRegisteredSitesOnly(t *testing.T) {
+	setupTrack()
+	setConfig(t, "SITE_VERIFICATION", "registered")
+	sites.Ensure(tracker.Site{ID: "listed-site", Unverified: true})
+
+	for site, want := range map[string]int{"listed-site": http.StatusAccepted, "stray-site": http.StatusForbidden} {
+		payload := `{"tracking":{"type":"page","event":"/` + t.Name() + `","category":"Page views"},"site_id":"` + site + `"}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		if w.Code != want {
+			t.Errorf("%s: got status %d, want %d", site, w.Code, want)
+		}
+	}
+}
+
+func TestCreateSiteWithEvents(t *testing.T) {
+	setupTrack()
+
+	payload := `{"tracking":{"type":"page","event":"/` + t.Name() + `","category":"Page views"},"site_id":"stray-events"}`
+	track(httptest.NewRecorder(), httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	flushEvents(t)
+
+	owner := tracker.Principal{Name: t.Name(), Grants: []tracker.Grant{{Org: "acme", Role: tracker.RoleOwner}}}
+	for _, tt := range []struct {
+		principal tracker.Principal
+		id        string
+		want      int
+	}{
+		{owner, "stray-events", http.StatusConflict},
+		{owner, "fresh-site", http.StatusCreated},
+		{tracker.Principal{Name: "root", Superuser: true}, "stray-events", http.StatusCreated},
+	} {
+		body := `{"id":"` + tt.id + `","domain":"` + tt.id + `.example","org":"acme"}`
+		r := httptest.NewRequest("POST", "/sites", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		createSite(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, tt.principal)))
+		if w.Code != tt.want {
+			t.Errorf("%s as %s: got status %d, want %d: %s", tt.id, tt.principal.Name, w.Code, tt.want, w.Body)
+		}
+	}
+}
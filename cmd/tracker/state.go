@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// adminApply makes the configuration match a posted DesiredState and
+// returns the StatePlan. With ?dryRun=true nothing changes, with ?prune=true
+// objects missing from the state are deleted. Applying the same state twice
+// changes nothing the second time, so tools can apply it on every run.
+func adminApply(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	if !permitted(w, r, tracker.RoleOwner) {
+		return
+	}
+	defer r.Body.Close()
+	var state tracker.DesiredState
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigBytes)).Decode(&state); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := tracker.ApplyOptions{
+		DryRun: r.URL.Query().Get("dryRun") == "true",
+		Prune:  r.URL.Query().Get("prune") == "true",
+	}
+	plan, err := tracker.ApplyState(state, sites, saved, &keys, opts)
+	if err != nil {
+		savedError(w, requestLogger, err, 0)
+		return
+	}
+	if plan.Applied {
+		requestLogger.Info("Applied configuration state", slog.Bool("prune", opts.Prune), slog.Any("plan", plan))
+		for _, id := range plan.Sites.Created {
+			webhooks.Fire(tracker.HookSiteCreated, id, nil)
+		}
+		for _, id := range plan.Sites.Deleted {
+			webhooks.Fire(tracker.HookSiteDeleted, id, nil)
+		}
+	}
+	writeJSON(w, requestLogger, http.StatusOK, plan)
+}
+
+// putSite creates or replaces /sites/{id} with the posted site, keeping its
+// verification and creation time. It answers 201 when it created the site.
+func putSite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleOwner, id) {
+		return
+	}
+	var site tracker.Site
+	if !decodeJSON(w, r, &site) {
+		return
+	}
+	if site.ID != "" && site.ID != id {
+		http.Error(w, "Bad Request: id doesn't match the path", http.StatusBadRequest)
+		return
+	}
+	site.ID = id
+	if err := tracker.ValidDomain(site.Domain); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Moving a site to another org needs ownership of both
+	if p, ok := principalOf(r); !ok || !p.Can(site, tracker.RoleOwner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	plan, err := tracker.ApplyState(tracker.DesiredState{Sites: []tracker.Site{site}}, sites, saved, &keys, tracker.ApplyOptions{})
+	if err != nil {
+		savedError(w, requestLogger, err, 0)
+		return
+	}
+	site, _ = sites.Get(id)
+	status := http.StatusOK
+	if len(plan.Sites.Created) > 0 {
+		status = http.StatusCreated
+		requestLogger.Info("Registered site", slog.String("site", site.ID), slog.String("domain", site.Domain))
+		webhooks.Fire(tracker.HookSiteCreated, site.ID, nil)
+	}
+	writeJSON(w, requestLogger, status, verificationOf(r, site))
+}
+
+// deleteSite removes /sites/{id} from the registry, its events are kept,
+// see adminDeleteSite to delete them too. Deleting a site that doesn't exist
+// succeeds too.
+func deleteSite(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	id := r.PathValue("id")
+	if !permitted(w, r, tracker.RoleOwner, id) {
+		return
+	}
+	err := sites.Delete(id)
+	if errors.Is(err, tracker.ErrNotFound) {
+		err = nil
+	} else if err == nil {
+		requestLogger.Info("Deleted site", slog.String("site", id))
+		webhooks.Fire(tracker.HookSiteDeleted, id, nil)
+	}
+	savedError(w, requestLogger, err, http.StatusNoContent)
+}
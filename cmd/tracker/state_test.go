@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestAdminApply(t *testing.T) {
+	setupTrack()
+	saved.Load("")
+
+	apply := func(query, body string) (int, tracker.StatePlan) {
+		r := httptest.NewRequest("POST", "/admin/apply"+query, strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleOwner, tracker.RoleOwner, adminApply)(w, r)
+		var plan tracker.StatePlan
+		json.Unmarshal(w.Body.Bytes(), &plan)
+		return w.Code, plan
+	}
+
+	state := `{"sites":[{"id":"declared","domain":"declared.example"}]}`
+	if code, plan := apply("?dryRun=true", state); code != http.StatusOK || plan.Applied || len(plan.Sites.Created) != 1 {
+		t.Errorf("dry run: got %d %+v", code, plan)
+	}
+	if _, ok := sites.Get("declared"); ok {
+		t.Error("dry run created the site")
+	}
+	if code, plan := apply("", state); code != http.StatusOK || !plan.Applied || len(plan.Sites.Created) != 1 {
+		t.Errorf("apply: got %d %+v", code, plan)
+	}
+	if code, plan := apply("", state); code != http.StatusOK || plan.Sites.Changed() {
+		t.Errorf("apply again: got %d %+v", code, plan)
+	}
+	if code, _ := apply("", `{"sites":[{"id":""}]}`); code != http.StatusBadRequest {
+		t.Errorf("invalid state: got %d", code)
+	}
+}
+
+func TestPutDeleteSite(t *testing.T) {
+	setupTrack()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /sites/{id}", requireRole(tracker.RoleOwner, tracker.RoleOwner, putSite))
+	mux.HandleFunc("DELETE /sites/{id}", requireRole(tracker.RoleOwner, tracker.RoleOwner, deleteSite))
+	call := func(method, body string) int {
+		r := httptest.NewRequest(method, "/sites/"+t.Name(), strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i, tc := range []struct {
+		method, body string
+		want         int
+	}{
+		{"PUT", `{"domain":"put.example"}`, http.StatusCreated},
+		{"PUT", `{"domain":"put.example"}`, http.StatusOK},
+		{"PUT", `{"domain":"put.example","id":"other"}`, http.StatusBadRequest},
+		{"PUT", `{"domain":"http://put.example/"}`, http.StatusBadRequest},
+		{"DELETE", "", http.StatusNoContent},
+		{"DELETE", "", http.StatusNoContent},
+	} {
+		if code := call(tc.method, tc.body); code != tc.want {
+			t.Errorf("%d %s: got %d, want %d", i, tc.method, code, tc.want)
+		}
+	}
+	if _, ok := sites.Get(t.Name()); ok {
+		t.Error("site not deleted")
+	}
+}
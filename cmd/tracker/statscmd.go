@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"tracker"
+)
+
+// statsCmd answers a stats query from a terminal or a cron script by asking
+// the stats API of a running tracker, with the key of API_KEY by default:
+//
+//	tracker stats -site X -metric browsers -from 2024-01-01 -to 2024-02-01 -format csv
+func statsCmd(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	site := fs.String("site", "", "site to query")
+	metric := fs.String("metric", "pageviews", "metric, such as pages, browsers or countries")
+	extra := fs.String("extra", "", "filter of the metrics that take one, such as the referrer domain of referrers")
+	from := fs.String("from", "", "first day, YYYY-MM-DD or YYYYMMDD, 30 days before -to by default")
+	to := fs.String("to", "", "last day, YYYY-MM-DD or YYYYMMDD, today by default")
+	format := fs.String("format", "table", "output format: table, json or csv")
+	url := fs.String("url", "http://localhost:9876", "URL of the tracker")
+	key := fs.String("key", "", "API key, API_KEY by default")
+	timeout := fs.Duration("timeout", time.Minute, "time allowed for the query")
+	fs.Parse(args)
+	// Not the flag's default, -h would print it
+	if *key == "" {
+		*key = tracker.GetConfig().APIKey
+	}
+
+	what, err := tracker.ParseQueryType(*metric)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	end, err := cliDay(*to, tracker.Today())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-to must be a YYYY-MM-DD day")
+		os.Exit(2)
+	}
+	start, err := cliDay(*from, tracker.AddDays(end, -29))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-from must be a YYYY-MM-DD day")
+		os.Exit(2)
+	}
+	if *site == "" || start > end {
+		fmt.Fprintln(os.Stderr, "-site is required and -from can't be after -to")
+		os.Exit(2)
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "-format must be table, json or csv")
+		os.Exit(2)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	data := tracker.MetricData{What: what, SiteID: *site, Start: start, End: end, Extra: *extra}
+	result, err := queryStatsAPI(client, *url, *key, data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := printStats(os.Stdout, *format, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// cliDay parses a YYYY-MM-DD or YYYYMMDD day, def when empty.
+func cliDay(v string, def uint32) (uint32, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return tracker.TimeToInt(t), nil
+	}
+	return dayParam(v, def)
+}
+
+// cliDayString formats a YYYYMMDD day as YYYY-MM-DD, as cliDay parses it.
+func cliDayString(day uint32) string {
+	t, err := tracker.ParseDay(day)
+	if err != nil {
+		return strconv.FormatUint(uint64(day), 10)
+	}
+	return t.Format(time.DateOnly)
+}
+
+// queryStatsAPI posts data to the /stats endpoint of the tracker at baseURL.
+func queryStatsAPI(client *http.Client, baseURL, key string, data tracker.MetricData) (*tracker.StatsResult, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/stats", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", key)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("stats query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("stats query failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var result tracker.StatsResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid stats response: %w", err)
+	}
+	return &result, nil
+}
+
+// printStats writes result to w as an aligned table, indented JSON or CSV
+// with a header. Tables and CSV have a YYYY-MM-DD day column for the
+// metrics counted per day.
+func printStats(w io.Writer, format string, result *tracker.StatsResult) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	daily := false
+	for _, m := range result.Data {
+		daily = daily || m.OccuredAt != 0
+	}
+	rows := [][]string{{"value", "count"}}
+	if daily {
+		rows[0] = []string{"day", "value", "count"}
+	}
+	for _, m := range result.Data {
+		row := []string{m.Value, strconv.FormatUint(m.Count, 10)}
+		if daily {
+			row = append([]string{cliDayString(m.OccuredAt)}, row...)
+		}
+		rows = append(rows, row)
+	}
+
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rows[0] = strings.Split(strings.ToUpper(strings.Join(rows[0], "\t")), "\t")
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tracker"
+)
+
+func TestStatsCmd(t *testing.T) {
+	setupTrack()
+	for i, path := range []string{"/", "/docs", "/docs"} {
+		payload := `{"tracking":{"type":"page","event":"` + path + `","category":"Page views","identity":"u` + strconv.Itoa(i) + `"},"site_id":"cli"}`
+		track(httptest.NewRecorder(), httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	}
+	flushEvents(t)
+
+	srv := httptest.NewServer(requireRole(tracker.RoleViewer, tracker.RoleViewer, stats))
+	defer srv.Close()
+	today := tracker.Today()
+	query := func(key string, what tracker.QueryType) (*tracker.StatsResult, error) {
+		data := tracker.MetricData{What: what, SiteID: "cli", Start: tracker.AddDays(today, -1), End: today}
+		return queryStatsAPI(srv.Client(), srv.URL+"/", key, data)
+	}
+
+	if _, err := query("wrong", tracker.QueryPageViewList); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("wrong key: got %v", err)
+	}
+	result, err := query(tracker.GetConfig().APIKey, tracker.QueryPageViewList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := printStats(&b, "csv", result); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || lines[0] != "value,count" || lines[1] != "/docs,2" {
+		t.Errorf("got csv %q", b.String())
+	}
+
+	b.Reset()
+	if err := printStats(&b, "table", result); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(b.String(), "\n"); !strings.Contains(lines[0], "VALUE") || lines[1] != "/docs  2" {
+		t.Errorf("got table %q", b.String())
+	}
+
+	b.Reset()
+	if err := printStats(&b, "json", result); err != nil {
+		t.Fatal(err)
+	}
+	var decoded tracker.StatsResult
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil || len(decoded.Data) != 2 {
+		t.Errorf("got json %s, %v", b.String(), err)
+	}
+
+	// Daily metrics get a day column
+	result, err = query(tracker.GetConfig().APIKey, tracker.QueryPageViews)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	printStats(&b, "csv", result)
+	day, _ := tracker.ParseDay(today)
+	if !strings.HasPrefix(b.String(), "day,value,count\n") || !strings.Contains(b.String(), "\n"+day.Format(time.DateOnly)+",") {
+		t.Errorf("got csv %q", b.String())
+	}
+}
+
+func TestCLIDay(t *testing.T) {
+	for v, want := range map[string]uint32{"": 20240315, "2024-01-02": 20240102, "20240102": 20240102} {
+		if got, err := cliDay(v, 20240315); err != nil || got != want {
+			t.Errorf("%q: got %d, %v", v, got, err)
+		}
+	}
+	for _, v := range []string{"2024-13-01", "yesterday", "20241301"} {
+		if _, err := cliDay(v, 20240315); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+}
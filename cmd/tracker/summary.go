@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// summary returns the lifetime totals of the site query parameter, for the
+// dashboard header.
+func summary(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	siteID := r.URL.Query().Get("site")
+	if siteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, siteID) {
+		return
+	}
+
+	s, err := events.Summary(r.Context(), siteID)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get site summary from database", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		requestLogger.Error("Failed to encode summary response", slog.Any("error", err))
+	}
+}
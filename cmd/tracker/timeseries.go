@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// timeSeries returns visitors, page views, sessions and bounce rate for each
+// day between start and end, posted like stats as {siteId, start, end}.
+func timeSeries(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.TimeSeriesQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode time series request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if q.Source != "" && !tracker.Sources[q.Source] {
+		http.Error(w, "Bad Request: unknown source", http.StatusBadRequest)
+		return
+	}
+	if q.Touch != "" && !tracker.Touches[q.Touch] {
+		http.Error(w, "Bad Request: touch must be touch or non-touch", http.StatusBadRequest)
+		return
+	}
+	if err := tracker.ValidateQualities(q.Qualities); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validRange(w, q.SiteID, q.Start, q.End) {
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+	if notModified(w, r, q.End) {
+		return
+	}
+
+	result, err := events.TimeSeries(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get time series from database", err)
+		return
+	}
+	writeStats(w, r, requestLogger, q.End, result)
+}
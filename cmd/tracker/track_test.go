@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tracker"
+)
+
+var setupOnce sync.Once
+
+// setupTrack wires the handler globals to an in-memory store.
+func setupTrack() {
+	setupOnce.Do(func() {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		tracker.LoadConfig()
+		events = tracker.NewMemoryEvents()
+		replays = tracker.NewReplays(time.Second)
+		nonces = tracker.NewNonces(time.Minute, 100)
+		sites.Load("")
+		quotas.Load("", sites)
+		enricher = tracker.NewEnricher(events, nil, nil)
+		enricher.Start()
+	})
+}
+
+// flushEvents waits until the events tracked so far are stored: closing the
+// enricher drains its queue, a new one then takes over.
+func flushEvents(t *testing.T) {
+	t.Helper()
+	enricher.Close()
+	enricher = tracker.NewEnricher(events, nil, nil)
+	enricher.Start()
+}
+
+// setConfig sets the environment variable key to value and reloads the
+// configuration until t is done. The enricher is stopped while it is
+// reloaded, its goroutines read the configuration.
+func setConfig(t *testing.T, key, value string) {
+	reload := func() {
+		enricher.Close()
+		tracker.LoadConfig()
+		enricher = tracker.NewEnricher(events, nil, nil)
+		enricher.Start()
+	}
+	t.Cleanup(reload)
+	t.Setenv(key, value)
+	reload()
+}
+
+const samplePayload = `{"tracking":{"type":"page","ua":"Mozilla/5.0","event":"/","category":"Page views"},"site_id":"s"}`
+
+func FuzzTrack(f *testing.F) {
+	setupTrack()
+
+	f.Add("POST", samplePayload)
+	f.Add("GET", base64.StdEncoding.EncodeToString([]byte(samplePayload)))
+	f.Add("GET", "%%%")
+	f.Add("POST", `{"site_id":`)
+	f.Add("POST", "{\"site_id\":\"\xff\",\"tracking\":{\"referrer\":\"http://[::1\"}}")
+	f.Add("HEAD", "")
+
+	f.Fuzz(func(t *testing.T, method, payload string) {
+		var r *http.Request
+		if method == "GET" {
+			r = httptest.NewRequest(method, "/track?data="+url.QueryEscape(payload), nil)
+		} else if method == "POST" || method == "HEAD" || method == "PUT" {
+			r = httptest.NewRequest(method, "/track", strings.NewReader(payload))
+		} else {
+			return
+		}
+		r.RemoteAddr = "203.0.113.7:1234"
+
+		w := httptest.NewRecorder()
+		track(w, r)
+
+		switch w.Code {
+		case http.StatusAccepted, http.StatusAlreadyReported, http.StatusBadRequest, http.StatusMethodNotAllowed:
+		default:
+			t.Errorf("%s %q: unexpected status %d", method, payload, w.Code)
+		}
+	})
+}
+
+func TestTrackBatch(t *testing.T) {
+	setupTrack()
+
+	screen := `{"site_id":"s","source":"ios","app_version":"1.0","os_version":"17.5","device_id":"d","type":"screen","name":"Home"}`
+	batch := "[" + screen + `,{"site_id":"s"},` + screen + "]"
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write([]byte(batch))
+	zw.Close()
+
+	r := httptest.NewRequest("POST", "/track/batch", &body)
+	r.Header.Set("Content-Encoding", "gzip")
+	r.RemoteAddr = "203.0.113.8:1234"
+	w := httptest.NewRecorder()
+	trackBatch(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var result batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	// The second screen view is another view of the same screen, not a replay
+	if result.Accepted != 2 || len(result.Rejected) != 1 || result.Rejected[0].Index != 1 {
+		t.Errorf("got %+v", result)
+	}
+}
+
+func TestTrackMobileOrigin(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "strict-app", Domain: "app.example.com", OriginCheck: tracker.OriginStrict})
+
+	screen := `{"site_id":"strict-app","source":"ios","app_version":"1.0","os_version":"17.5","device_id":"d","type":"screen","name":"Home"}`
+	post := func(path, body, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		if path == "/track/batch" {
+			trackBatch(w, r)
+		} else {
+			trackMobile(w, r)
+		}
+		return w
+	}
+
+	// Native SDKs send no Origin, browsers do
+	if w := post("/track/mobile", screen, ""); w.Code != http.StatusAccepted {
+		t.Errorf("native: got %d %s", w.Code, w.Body)
+	}
+	if w := post("/track/mobile", screen, "https://evil.example.org"); w.Code != http.StatusForbidden {
+		t.Errorf("foreign origin: got %d", w.Code)
+	}
+	w := post("/track/batch", "["+screen+"]", "https://evil.example.org")
+	var result batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.Accepted != 0 || len(result.Rejected) != 1 || result.Rejected[0].Status != http.StatusForbidden {
+		t.Errorf("foreign origin batch: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTrackBeacon(t *testing.T) {
+	setupTrack()
+
+	payload := func(path string) string {
+		return `{"tracking":{"type":"page","event":"/` + path + `","category":"Page views"},"site_id":"beacon-site"}`
+	}
+	for contentType, body := range map[string]string{
+		"text/plain;charset=UTF-8":          payload("text"),
+		"text/plain":                        base64.StdEncoding.EncodeToString([]byte(payload("base64"))),
+		"application/x-www-form-urlencoded": url.Values{"data": {base64.StdEncoding.EncodeToString([]byte(payload("form")))}}.Encode(),
+	} {
+		r := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		track(w, r)
+		if w.Code != http.StatusAccepted {
+			t.Errorf("%s: got status %d: %s", contentType, w.Code, w.Body)
+		}
+	}
+}
+
+func TestTrackReplay(t *testing.T) {
+	setupTrack()
+
+	payload := `{"tracking":{"type":"page","event":"/` + t.Name() + `","category":"Page views"},"site_id":"replay-site"}`
+	send := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/track", strings.NewReader(payload))
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		track(w, r)
+		return w
+	}
+	for i, tt := range []struct {
+		ip   string
+		want int
+	}{
+		{"203.0.113.20", http.StatusAccepted},
+		{"203.0.113.20", http.StatusAlreadyReported},
+		{"203.0.113.21", http.StatusAccepted},
+	} {
+		w := send(tt.ip)
+		if w.Code != tt.want {
+			t.Errorf("%d: got status %d, want %d", i, w.Code, tt.want)
+		}
+		if etag := w.Header().Get("ETag"); etag != "" {
+			t.Errorf("%d: got ETag %s on a no-store response", i, etag)
+		}
+	}
+}
+
+func TestTrackBodyTooLarge(t *testing.T) {
+	setupTrack()
+
+	payload := `{"site_id":"s","tracking":{"event":"/` + strings.Repeat("a", int(tracker.GetConfig().MaxBodyBytes)) + `"}}`
+	r := httptest.NewRequest("POST", "/track", strings.NewReader(payload))
+	w := httptest.NewRecorder()
+	track(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want 413", w.Code)
+	}
+}
+
+func TestTrackVisitorCookie(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "cookie-site", VisitorCookie: true})
+
+	send := func(site string, cookie *http.Cookie) *http.Response {
+		payload := `{"tracking":{"type":"page","event":"/` + site + `","category":"Page views"},"site_id":"` + site + `"}`
+		r := httptest.NewRequest("POST", "/track", strings.NewReader(payload))
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		track(w, r)
+		return w.Result()
+	}
+
+	if cookies := send("s", nil).Cookies(); len(cookies) != 0 {
+		t.Errorf("site without visitor cookies got %v", cookies)
+	}
+
+	cookies := send("cookie-site", nil).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != tracker.VisitorCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("got cookies %v", cookies)
+	}
+	again := send("cookie-site", cookies[0]).Cookies()
+	if len(again) != 1 || again[0].Value != cookies[0].Value {
+		t.Errorf("returning visitor got %v, want ID %s", again, cookies[0].Value)
+	}
+
+	// Withdrawing consent removes the cookie
+	payload := `{"tracking":{"type":"page","event":"/denied","category":"Page views","consent":"denied"},"site_id":"cookie-site"}`
+	r := httptest.NewRequest("POST", "/track", strings.NewReader(payload))
+	r.AddCookie(cookies[0])
+	w := httptest.NewRecorder()
+	track(w, r)
+	if removed := w.Result().Cookies(); len(removed) != 1 || removed[0].MaxAge >= 0 {
+		t.Errorf("denied consent got cookies %v", removed)
+	}
+}
+
+func TestTrackEventKinds(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "kinds-site", AllowedKinds: []string{"page", "form"}})
+	sites.Ensure(tracker.Site{ID: "flag-site", AllowedKinds: []string{"page"}, UnlistedKinds: tracker.KindFlag})
+
+	send := func(site, typ, category string) int {
+		payload := `{"tracking":{"type":"` + typ + `","event":"/` + category + `","category":"` + category + `"},"site_id":"` + site + `"}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		return w.Code
+	}
+
+	if got := send("kinds-site", "page", "Page views"); got != http.StatusAccepted {
+		t.Errorf("page view: got status %d", got)
+	}
+	if got := send("kinds-site", "event", "Form"); got != http.StatusAccepted {
+		t.Errorf("form event: got status %d", got)
+	}
+	if got := send("kinds-site", "event", "widget"); got != http.StatusUnprocessableEntity {
+		t.Errorf("unlisted event: got status %d", got)
+	}
+	if got := send("flag-site", "event", "widget"); got != http.StatusAccepted {
+		t.Errorf("flagged event: got status %d", got)
+	}
+
+	for site, want := range map[string]map[string]uint64{"kinds-site": {"widget": 1}, "flag-site": {"widget": 1}} {
+		r := httptest.NewRequest("GET", "/sites/"+site+"/kinds", nil)
+		r.SetPathValue("id", site)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, siteKinds)(w, r)
+		var got siteKindList
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v: %s", site, err, w.Body)
+		}
+		if got.Events["widget"] != want["widget"] || len(got.Events) != 1 {
+			t.Errorf("%s: got %+v", site, got)
+		}
+	}
+}
+
+func TestTrackLateEvents(t *testing.T) {
+	setupTrack()
+
+	event := func(name string, at time.Time) string {
+		return `{"site_id":"late-site","source":"android","app_version":"1.0","os_version":"14","device_id":"d","type":"screen","name":"` +
+			name + `","timestamp":"` + at.UTC().Format(time.RFC3339) + `"}`
+	}
+	now := tracker.Now()
+	batch := "[" + event("Home", now) + "," + event("Offline", now.Add(-3*24*time.Hour)) + "]"
+	w := httptest.NewRecorder()
+	trackBatch(w, httptest.NewRequest("POST", "/track/batch", strings.NewReader(batch)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest("GET", "/sites/late-site/late", nil)
+	r.SetPathValue("id", "late-site")
+	r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+	w = httptest.NewRecorder()
+	requireRole(tracker.RoleViewer, tracker.RoleViewer, siteLate)(w, r)
+	var got siteLateness
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	day := strconv.Itoa(int(tracker.DayIn(now.Add(-3*24*time.Hour), now.Location())))
+	if got.Watermark == nil || got.Days[day] != 1 || len(got.Days) != 1 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestTrackTestMode(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "soft-site", TestMode: true})
+
+	payload := `{"tracking":{"type":"page","event":"/","category":"Page views"},"site_id":"soft-site"}`
+	w := httptest.NewRecorder()
+	track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d", w.Code)
+	}
+	flushEvents(t)
+
+	today := strconv.Itoa(int(tracker.Today()))
+	for qualities, want := range map[string]int{"": 0, `,"qualities":["test"]`: 1} {
+		body := `{"what":"pages","siteId":"soft-site","start":` + today + `,"end":` + today + qualities + `}`
+		r := httptest.NewRequest("POST", "/stats", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, stats)(w, r)
+		var got tracker.StatsResult
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %v: %s", qualities, err, w.Body)
+		}
+		if len(got.Data) != want {
+			t.Errorf("%q: got %+v", qualities, got.Data)
+		}
+	}
+}
+
+func TestTrackSessions(t *testing.T) {
+	setupTrack()
+
+	// The second page view continues the visit of the first, the third is
+	// another visitor's
+	for i, identity := range []string{"v1", "v1", "v2"} {
+		payload := `{"tracking":{"type":"page","identity":"` + identity + `","event":"/` + strconv.Itoa(i) + `","category":"Page views"},"site_id":"` + t.Name() + `"}`
+		w := httptest.NewRecorder()
+		track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("got status %d", w.Code)
+		}
+	}
+	flushEvents(t)
+
+	today := strconv.Itoa(int(tracker.Today()))
+	for what, want := range map[string]uint64{"sessions": 2, "bounce_rate": 50} {
+		body := `{"what":"` + what + `","siteId":"` + t.Name() + `","start":` + today + `,"end":` + today + `}`
+		r := httptest.NewRequest("POST", "/stats", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, stats)(w, r)
+		var got tracker.StatsResult
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v: %s", what, err, w.Body)
+		}
+		if len(got.Data) != 1 || got.Data[0].Count != want {
+			t.Errorf("%s: got %+v", what, got.Data)
+		}
+	}
+}
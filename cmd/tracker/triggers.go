@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tracker"
+)
+
+// lister lists recent custom events, nil when the store can't.
+var lister tracker.EventLister
+
+// Triggers list at most maxTriggerLimit items, and look back
+// triggerLookback without a cursor.
+const (
+	defaultTriggerLimit = 50
+	maxTriggerLimit     = 100
+	triggerLookback     = 24 * time.Hour
+	summaryLookback     = 30
+)
+
+// cursorHeader carries the cursor of the next poll.
+const cursorHeader = "X-Cursor"
+
+// eventsTrigger lists the custom events of a site, such as goal
+// conversions, received at or after cursor, newest first, as a bare JSON
+// array automation platforms poll. Query parameters: site, event to list
+// one name only, cursor in unix seconds, a day back by default, and limit.
+// The X-Cursor header is the cursor of the next poll, events received in
+// its second are listed again, with the same IDs.
+func eventsTrigger(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	siteID := params.Get("site")
+	if siteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, siteID) {
+		return
+	}
+	if lister == nil {
+		http.Error(w, "Not Found: the store can't list events", http.StatusNotFound)
+		return
+	}
+	q := tracker.EventListQuery{
+		SiteID: siteID,
+		Event:  params.Get("event"),
+		Since:  tracker.Now().Add(-triggerLookback).Truncate(time.Second),
+		Limit:  defaultTriggerLimit,
+	}
+	if v := params.Get("cursor"); v != "" {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || secs < 0 {
+			http.Error(w, "Bad Request: cursor must be unix seconds", http.StatusBadRequest)
+			return
+		}
+		q.Since = time.Unix(secs, 0).UTC()
+	}
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxTriggerLimit {
+			http.Error(w, "Bad Request: limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := lister.ListEvents(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to list events from database", err)
+		return
+	}
+	if list == nil {
+		list = []tracker.TriggerEvent{}
+	}
+	cursor := q.Since
+	if len(list) > 0 {
+		cursor = list[0].ReceivedAt
+	}
+	w.Header().Set(cursorHeader, strconv.FormatInt(cursor.Unix(), 10))
+	writeJSON(w, requestLogger, http.StatusOK, list)
+}
+
+// dailySummaryTrigger lists the daily summaries of a site for the days
+// after cursor, YYYYMMDD, that closed everywhere, newest first, as a bare
+// JSON array automation platforms poll. Without a cursor the last 30 days
+// are listed. The X-Cursor header is the cursor of the next poll.
+func dailySummaryTrigger(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	siteID := params.Get("site")
+	if siteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, siteID) {
+		return
+	}
+	now := tracker.Now()
+	today := tracker.TimeToInt(now)
+	start := tracker.AddDays(today, -summaryLookback)
+	cursor, err := dayParam(params.Get("cursor"), 0)
+	if err != nil {
+		http.Error(w, "Bad Request: cursor must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if cursor != 0 {
+		start = max(start, tracker.AddDays(cursor, 1))
+	}
+
+	list := []tracker.DailySummary{}
+	if start <= today {
+		series, err := events.TimeSeries(r.Context(), tracker.TimeSeriesQuery{SiteID: siteID, Start: start, End: today})
+		if err != nil {
+			queryError(w, requestLogger, "Failed to get daily summaries from database", err)
+			return
+		}
+		list = tracker.ClosedDays(siteID, series.Data, now)
+	}
+	if len(list) > 0 {
+		cursor = list[0].Day
+	}
+	if cursor != 0 {
+		w.Header().Set(cursorHeader, strconv.FormatUint(uint64(cursor), 10))
+	}
+	writeJSON(w, requestLogger, http.StatusOK, list)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mileusna/useragent"
+
+	"tracker"
+)
+
+func TestTriggers(t *testing.T) {
+	setupTrack()
+	lister = events.(tracker.EventLister)
+	defer func() { lister = nil }()
+
+	site := t.Name()
+	for _, name := range []string{"signup", "purchase"} {
+		trk := tracker.Tracking{SiteID: site, Action: tracker.TrackingData{Type: "event", Event: name, Category: "Goals"}}
+		if err := events.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	poll := func(h http.HandlerFunc, query string, v any) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/triggers?"+query, nil)
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, h)(w, r)
+		if v != nil && w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w
+	}
+
+	var list []tracker.TriggerEvent
+	w := poll(eventsTrigger, "site="+site+"&event=signup", &list)
+	if w.Code != http.StatusOK || len(list) != 1 || list[0].Event != "signup" || w.Header().Get(cursorHeader) == "" {
+		t.Fatalf("events: got %d %s", w.Code, w.Body)
+	}
+	w = poll(eventsTrigger, "site="+site+"&cursor="+w.Header().Get(cursorHeader), &list)
+	if w.Code != http.StatusOK || len(list) != 2 {
+		t.Errorf("from the cursor: got %d %s", w.Code, w.Body)
+	}
+	if w := poll(eventsTrigger, "site="+site+"&cursor=yesterday", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bad cursor: got %d", w.Code)
+	}
+	if w := poll(eventsTrigger, "site="+site+"&limit=1000", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bad limit: got %d", w.Code)
+	}
+
+	// Today hasn't closed yet
+	var summaries []tracker.DailySummary
+	w = poll(dailySummaryTrigger, "site="+site, &summaries)
+	if w.Code != http.StatusOK || len(summaries) == 0 || summaries[0].Day == tracker.Today() || summaries[0].ID != site+"-"+w.Header().Get(cursorHeader) {
+		t.Fatalf("daily summaries: got %d %s", w.Code, w.Body)
+	}
+	w = poll(dailySummaryTrigger, "site="+site+"&cursor="+w.Header().Get(cursorHeader), &summaries)
+	if w.Code != http.StatusOK || len(summaries) != 0 {
+		t.Errorf("from the cursor: got %d %s", w.Code, w.Body)
+	}
+}
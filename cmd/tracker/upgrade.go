@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"tracker"
+)
+
+// upgrade takes the events table through a blue/green upgrade, one step per
+// run: start, backfill, verify, swap and drop, or status.
+func upgrade(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	ddl := fs.String("ddl", "", "start: file with the CREATE TABLE "+tracker.UpgradeTable+" statement")
+	timeout := fs.Duration("timeout", 24*time.Hour, "time allowed for the step")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tracker upgrade [flags] status|start|backfill|verify|swap|drop")
+		fs.PrintDefaults()
+	}
+	step := "status"
+	if len(args) > 0 && args[0][0] != '-' {
+		step, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	events := &tracker.Events{}
+	if err := events.Open(); err != nil {
+		logger.Error("Failed to connect to ClickHouse", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer events.DB.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if version, err := events.SchemaVersion(ctx); err != nil || version != tracker.LatestSchemaVersion() {
+		logger.Error("Schema is not up to date, start the tracker first", slog.Int("version", int(version)), slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	var err error
+	switch step {
+	case "status":
+		var u *tracker.Upgrade
+		if u, err = events.UpgradeStatus(ctx); err == nil && u == nil {
+			fmt.Println("no upgrade")
+		} else if err == nil {
+			fmt.Printf("%s, dual-writes since %s, backfilled to %d\n", u.State, u.Since.Format(time.RFC3339), u.BackfilledTo)
+		}
+	case "start":
+		var b []byte
+		if b, err = os.ReadFile(*ddl); err != nil {
+			break
+		}
+		var u *tracker.Upgrade
+		if u, err = events.StartUpgrade(ctx, string(b)); err == nil {
+			fmt.Printf("created %s, dual-writes start at %s, backfill after\n", tracker.UpgradeTable, u.Since.Format(time.RFC3339))
+		}
+	case "backfill":
+		err = events.BackfillUpgrade(ctx, func(day uint32) { fmt.Printf("backfilled %d\n", day) })
+	case "verify":
+		var mismatches []tracker.UpgradeMismatch
+		if mismatches, err = events.VerifyUpgrade(ctx); err == nil {
+			for _, m := range mismatches {
+				fmt.Printf("%d: %d events, %d in %s\n", m.Day, m.Old, m.Next, tracker.UpgradeTable)
+			}
+			if len(mismatches) > 0 {
+				fmt.Println("tables differ, backfill again or verify once in-flight inserts landed")
+				os.Exit(1)
+			}
+			fmt.Println("tables match, ready to swap")
+		}
+	case "swap":
+		if err = events.SwapUpgrade(ctx); err == nil {
+			fmt.Printf("swapped, the old table is %s until drop\n", tracker.UpgradeTable)
+		}
+	case "drop":
+		// Trackers still copying inserts stop before the table goes
+		if err = events.DropUpgrade(ctx, tracker.UpgradePollInterval+5*time.Second); err == nil {
+			fmt.Printf("dropped %s\n", tracker.UpgradeTable)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		logger.Error("Upgrade "+step+" failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
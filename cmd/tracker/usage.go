@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tracker"
+)
+
+// usage reports a site's ingested events for a month against its quota.
+// Query parameters: site (required) and month as YYYYMM (defaults to current).
+func usage(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	siteID := r.URL.Query().Get("site")
+	if siteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, siteID) {
+		return
+	}
+
+	month := tracker.Now()
+	if v := r.URL.Query().Get("month"); v != "" {
+		t, err := time.Parse("200601", v)
+		if err != nil {
+			http.Error(w, "Bad Request: month must be YYYYMM", http.StatusBadRequest)
+			return
+		}
+		month = t
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(quotas.Usage(siteID, month)); err != nil {
+		requestLogger.Error("Failed to encode usage response", slog.Any("error", err))
+	}
+}
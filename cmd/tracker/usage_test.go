@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tracker"
+)
+
+func TestUsage(t *testing.T) {
+	setupTrack()
+	sites.Ensure(tracker.Site{ID: "quota-site", MonthlyQuota: 2})
+	now := tracker.Now()
+	for _, at := range []time.Time{
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 31, 23, 59, 0, 0, time.UTC),
+		time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		now,
+	} {
+		quotas.Record("quota-site", at)
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/usage?"+query, nil)
+		w := httptest.NewRecorder()
+		usage(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, tracker.Principal{Superuser: true})))
+		return w
+	}
+
+	tests := []struct {
+		query string
+		want  tracker.Usage
+	}{
+		{"site=quota-site&month=202403", tracker.Usage{SiteID: "quota-site", Month: "202403", Events: 2, Limit: 2}},
+		{"site=quota-site&month=202404", tracker.Usage{SiteID: "quota-site", Month: "202404", Events: 1, Limit: 2}},
+		{"site=quota-site&month=202405", tracker.Usage{SiteID: "quota-site", Month: "202405", Limit: 2}},
+		{"site=quota-site", tracker.Usage{SiteID: "quota-site", Month: now.UTC().Format("200601"), Events: 1, Limit: 2}},
+	}
+	for _, tt := range tests {
+		w := get(tt.query)
+		var got tracker.Usage
+		if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s: got %d %s", tt.query, w.Code, w.Body)
+		}
+		tt.want.Mode = tracker.GetConfig().QuotaMode
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"month=202403", "site=quota-site&month=2024-03", "site=quota-site&month=202413"} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", query, w.Code)
+		}
+	}
+}
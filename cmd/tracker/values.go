@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"tracker"
+)
+
+const maxValues = 100
+
+// values returns the distinct values of a field matching a prefix, most
+// frequent first, for filter dropdowns. Query parameters: site and field
+// (path, referrer, browser, os, country, source or touch) are required, q is
+// the prefix, from and to as YYYYMMDD (defaults to the last 30 days), limit
+// (defaults to 20), source to only count events from one source and touch
+// from touch or non-touch devices.
+func values(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	params := r.URL.Query()
+	now := tracker.Now()
+	q := tracker.ValuesQuery{
+		SiteID: params.Get("site"),
+		Field:  params.Get("field"),
+		Prefix: params.Get("q"),
+		Start:  tracker.TimeToInt(now.AddDate(0, 0, -30)),
+		End:    tracker.TimeToInt(now),
+		Limit:  20,
+		Source: params.Get("source"),
+		Touch:  params.Get("touch"),
+	}
+	if q.SiteID == "" {
+		http.Error(w, "Bad Request: site is required", http.StatusBadRequest)
+		return
+	}
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+	if _, err := tracker.ValueColumn(q.Field); err != nil {
+		http.Error(w, "Bad Request: field must be path, referrer, browser, os, country, source or touch", http.StatusBadRequest)
+		return
+	}
+	if q.Source != "" && !tracker.Sources[q.Source] {
+		http.Error(w, "Bad Request: unknown source", http.StatusBadRequest)
+		return
+	}
+	if q.Touch != "" && !tracker.Touches[q.Touch] {
+		http.Error(w, "Bad Request: touch must be touch or non-touch", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if q.Start, err = dayParam(params.Get("from"), q.Start); err != nil {
+		http.Error(w, "Bad Request: from must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if q.End, err = dayParam(params.Get("to"), q.End); err != nil {
+		http.Error(w, "Bad Request: to must be YYYYMMDD", http.StatusBadRequest)
+		return
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxValues {
+			http.Error(w, "Bad Request: limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if notModified(w, r, q.End) {
+		return
+	}
+
+	vals, err := events.Values(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get values from database", err)
+		return
+	}
+	writeStats(w, r, requestLogger, q.End, vals)
+}
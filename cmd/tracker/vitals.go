@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"tracker"
+)
+
+// vitalsStore keeps web vitals, nil when the store can't.
+var vitalsStore tracker.VitalsStore
+
+// maxVitalsRows caps the rows returned by vitals.
+const maxVitalsRows = 500
+
+// vitals returns the 75th percentile of the web vitals of a site between
+// start and end per page or device, posted like time series as {siteId,
+// start, end, by, metric, limit}. by is page or device, limit defaults to
+// 50.
+func vitals(w http.ResponseWriter, r *http.Request) {
+	requestLogger := logger.With(slog.String("path", r.URL.Path))
+
+	var q tracker.VitalsQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		requestLogger.Error("Failed to decode vitals request body", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !validRange(w, q.SiteID, q.Start, q.End) {
+		return
+	}
+	if err := q.Validate(); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	q.Limit = min(q.Limit, maxVitalsRows)
+	if !permitted(w, r, tracker.RoleViewer, q.SiteID) {
+		return
+	}
+	if vitalsStore == nil {
+		http.Error(w, "Not Found: the store doesn't keep web vitals", http.StatusNotFound)
+		return
+	}
+
+	result, err := vitalsStore.Vitals(r.Context(), q)
+	if err != nil {
+		queryError(w, requestLogger, "Failed to get web vitals from database", err)
+		return
+	}
+	writeJSON(w, requestLogger, http.StatusOK, result)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tracker"
+)
+
+func TestVitals(t *testing.T) {
+	setupTrack()
+	vitalsStore = events.(tracker.VitalsStore)
+	defer func() { vitalsStore = nil }()
+
+	payload := `{"site_id":"` + t.Name() + `","tracking":{"type":"vitals","ua":"Mozilla/5.0","event":"/","vitals":{"inp":180}}}`
+	w := httptest.NewRecorder()
+	track(w, httptest.NewRequest("POST", "/track", strings.NewReader(payload)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("track: got %d", w.Code)
+	}
+	flushEvents(t)
+
+	query := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/stats/vitals", strings.NewReader(body))
+		r.Header.Set("X-API-KEY", tracker.GetConfig().APIKey)
+		w := httptest.NewRecorder()
+		requireRole(tracker.RoleViewer, tracker.RoleViewer, vitals)(w, r)
+		return w
+	}
+	days := fmt.Sprintf(`"start":%d,"end":%[1]d`, tracker.Today())
+	w = query(`{"siteId":"` + t.Name() + `",` + days + `,"by":"page"}`)
+	var result tracker.VitalsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if len(result.Data) != 1 || result.Data[0].Metric != "inp" || result.Data[0].P75 != 180 {
+		t.Errorf("got %+v", result.Data)
+	}
+	if w := query(`{"siteId":"` + t.Name() + `",` + days + `,"by":"os"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("by os: got %d", w.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"sync"
+)
+
+// statsCounters are published on /debug/vars.
+var statsCounters = expvar.NewMap("stats")
+
+// Coalesced is an EventStore whose identical concurrent GetStats calls share
+// a single query: when many dashboards load the same site at once, each
+// distinct query runs once and every caller gets its result.
+type Coalesced struct {
+	EventStore
+
+	lock  sync.Mutex
+	calls map[string]*statsCall
+}
+
+type statsCall struct {
+	done   chan struct{}
+	result *StatsResult
+	err    error
+}
+
+func Coalesce(store EventStore) *Coalesced {
+	return &Coalesced{EventStore: store, calls: make(map[string]*statsCall)}
+}
+
+func (c *Coalesced) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	key := string(b)
+
+	c.lock.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		statsCounters.Add("coalesced", 1)
+		select {
+		case <-call.done:
+			return call.result, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &statsCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.lock.Unlock()
+
+	// The query is shared, the first caller going away must not cancel it
+	// for the others
+	call.result, call.err = c.EventStore.GetStats(context.WithoutCancel(ctx), data)
+	close(call.done)
+
+	c.lock.Lock()
+	delete(c.calls, key)
+	c.lock.Unlock()
+
+	return call.result, call.err
+}
@@ -0,0 +1,75 @@
+package tracker
+
+import (
+	"context"
+	"expvar"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// blockingStore counts GetStats calls, which block until release is closed.
+type blockingStore struct {
+	*MemoryEvents
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *blockingStore) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	s.calls.Add(1)
+	<-s.release
+	return s.MemoryEvents.GetStats(ctx, data)
+}
+
+func TestCoalesce(t *testing.T) {
+	store := &blockingStore{MemoryEvents: NewMemoryEvents(), release: make(chan struct{})}
+	c := Coalesce(store)
+	data := MetricData{What: QueryBrowsers, SiteID: "site", Start: 20240301, End: 20240331}
+
+	coalesced := func() int64 {
+		if v, ok := statsCounters.Get("coalesced").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := coalesced()
+
+	var wg sync.WaitGroup
+	results := make([]*StatsResult, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := c.GetStats(context.Background(), data)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = result
+		}(i)
+	}
+
+	// Let every caller queue up behind the first one
+	for coalesced()-before < int64(len(results)-1) {
+		runtime.Gosched()
+	}
+	close(store.release)
+	wg.Wait()
+
+	if n := store.calls.Load(); n != 1 {
+		t.Errorf("%d queries ran, want 1", n)
+	}
+	for _, r := range results {
+		if r != results[0] {
+			t.Fatal("callers got different results")
+		}
+	}
+
+	// Once done, the next call runs a new query
+	if _, err := c.GetStats(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.calls.Load(); n != 2 {
+		t.Errorf("%d queries ran, want 2", n)
+	}
+}
@@ -0,0 +1,287 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxCohorts bounds the cohorts compared at once, each adds columns to the
+// query.
+const maxCohorts = 4
+
+// Cohort is a part of a site's page views, those matching all of Filters,
+// keyed like the filters of a segment. A cohort without filters is all of
+// them.
+type Cohort struct {
+	Name      string            `json:"name"`
+	SegmentID string            `json:"segmentId,omitempty"`
+	Filters   map[string]string `json:"filters,omitempty"`
+}
+
+// CohortQuery selects the daily page view metrics of several cohorts of a
+// site between two YYYYMMDD days, such as mobile against desktop visitors
+// or the visitors of two campaigns.
+type CohortQuery struct {
+	SiteID string `json:"siteId"`
+	Start  uint32 `json:"start"`
+	End    uint32 `json:"end"`
+	Source string `json:"source,omitempty"`
+	Touch  string `json:"touch,omitempty"`
+	// Qualities are those of the events counted, see MetricData.
+	Qualities []string `json:"qualities,omitempty"`
+	Cohorts   []Cohort `json:"cohorts"`
+}
+
+// Validate checks q, without its days, source, touch and qualities.
+func (q CohortQuery) Validate() error {
+	if len(q.Cohorts) < 2 || len(q.Cohorts) > maxCohorts {
+		return fmt.Errorf("%w: compare 2 to %d cohorts", ErrInvalid, maxCohorts)
+	}
+	for i, c := range q.Cohorts {
+		if c.Name == "" {
+			return fmt.Errorf("%w: cohort %d has no name", ErrInvalid, i+1)
+		}
+		for field := range c.Filters {
+			if _, err := ValueColumn(field); err != nil {
+				return fmt.Errorf("%w: cohort %q: %w", ErrInvalid, c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CohortPoint is a day of each cohort, in the order of the query.
+type CohortPoint struct {
+	Day     uint32         `json:"day"`
+	Cohorts []CohortCounts `json:"cohorts"`
+}
+
+// CohortCounts are the metrics of a time series point, sessions and bounces
+// are counted as in TimeSeries.
+type CohortCounts struct {
+	Visitors   uint64  `json:"visitors"`
+	Pageviews  uint64  `json:"pageviews"`
+	Sessions   uint64  `json:"sessions"`
+	BounceRate float64 `json:"bounceRate"`
+}
+
+// CohortResult holds a point for every day of the range, Cohorts names the
+// columns of its points.
+type CohortResult struct {
+	Meta    StatsMeta     `json:"meta"`
+	Cohorts []string      `json:"cohorts"`
+	Data    []CohortPoint `json:"data"`
+}
+
+// CohortStore is implemented by stores that can compare cohorts.
+type CohortStore interface {
+	Cohorts(ctx context.Context, q CohortQuery) (*CohortResult, error)
+}
+
+// cohortRow is what stores compute per day, one timeSeriesRow per cohort.
+type cohortRow struct {
+	day     uint32
+	cohorts []timeSeriesRow
+}
+
+// cohortColumns returns the columns and values a cohort's events match,
+// ordered by column.
+func cohortColumns(c Cohort) (cols, values []string) {
+	cols, values, _ = filterColumns(c.Filters)
+	return cols, values
+}
+
+// Cohorts counts every cohort in one pass over the raw events: their
+// conditions are aggregated per visitor and day side by side. The rollups
+// keep a single dimension per row and can't, so ranges past the raw
+// retention are invalid.
+func (e *Events) Cohorts(ctx context.Context, q CohortQuery) (*CohortResult, error) {
+	if cutoff := rawCutoff(clock.Now()); cutoff != 0 && q.Start < cutoff {
+		return nil, fmt.Errorf("%w: cohorts are only compared from %d, the first day of raw events", ErrInvalid, cutoff)
+	}
+	started := time.Now()
+	qry, args := cohortQuery(q)
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rows, err := e.DB.Query(queryCtx, qry, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cohorts query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var days []cohortRow
+	for rows.Next() {
+		r := cohortRow{cohorts: make([]timeSeriesRow, len(q.Cohorts))}
+		dest := []any{&r.day}
+		for i := range r.cohorts {
+			dest = append(dest, &r.cohorts[i].views, &r.cohorts[i].visitors, &r.cohorts[i].sessions, &r.cohorts[i].bounces)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed scanning cohorts row: %w", err)
+		}
+		days = append(days, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cohorts rows: %w", err)
+	}
+	return newCohortResult(q, days, SourceRaw, time.Since(started)), nil
+}
+
+// cohortQuery returns the query counting the views of each cohort per
+// visitor, session and day as views1, views2... and the daily views,
+// visitors, sessions and bounces of each from them. Events of none of the cohorts are skipped.
+func cohortQuery(q CohortQuery) (string, []any) {
+	args := []any{q.SiteID, q.Start, q.End, q.Source, q.Touch, countedQualities(q.Qualities)}
+	var conds, views, aggregates []string
+	for i, c := range q.Cohorts {
+		n := i + 1
+		cond := "1"
+		cols, values := cohortColumns(c)
+		if len(cols) > 0 {
+			var parts []string
+			for j, col := range cols {
+				args = append(args, values[j])
+				parts = append(parts, fmt.Sprintf("%s = $%d", col, len(args)))
+			}
+			cond = strings.Join(parts, " AND ")
+		}
+		conds = append(conds, "("+cond+")")
+		views = append(views, fmt.Sprintf("countIf(%s) AS views%d", cond, n))
+		aggregates = append(aggregates, fmt.Sprintf("sum(views%d), uniqExactIf(user_id, views%[1]d > 0), countIf(views%[1]d > 0 AND session_id != ''), countIf(views%[1]d = 1 AND session_id != '')", n))
+	}
+
+	return fmt.Sprintf(`
+		SELECT day, %s
+		FROM (
+			SELECT occured_at AS day, user_id, session_id, %s
+			FROM events
+			WHERE site_id = $1
+			AND occured_at BETWEEN $2 AND $3
+			AND category = 'Page views'
+			AND has($6, toString(quality))
+			AND ($4 = '' OR source = $4)
+			AND ($5 = '' OR touch = $5)
+			AND (%s)
+			GROUP BY occured_at, user_id, session_id
+		)
+		GROUP BY day
+		ORDER BY day;
+	`, strings.Join(aggregates, ", "), strings.Join(views, ", "), strings.Join(conds, " OR ")), args
+}
+
+func (m *MemoryEvents) Cohorts(ctx context.Context, q CohortQuery) (*CohortResult, error) {
+	started := time.Now()
+
+	type key struct {
+		day     uint32
+		user    string
+		session string
+	}
+	type cohortColumn struct{ cols, values []string }
+	cohorts := make([]cohortColumn, len(q.Cohorts))
+	for i, c := range q.Cohorts {
+		cohorts[i].cols, cohorts[i].values = cohortColumns(c)
+	}
+
+	views := make(map[key][]uint64)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Category != PageviewCategory || !counted(q.Qualities, row.trk.Action) {
+			continue
+		}
+		if row.trk.Action.OccuredAt < q.Start || row.trk.Action.OccuredAt > q.End {
+			continue
+		}
+		if q.Source != "" && row.source() != q.Source {
+			continue
+		}
+		if q.Touch != "" && row.touch() != q.Touch {
+			continue
+		}
+		k := key{row.trk.Action.OccuredAt, row.trk.Action.Identity, row.trk.Action.SessionID}
+		for i, c := range cohorts {
+			if !row.matches(c.cols, c.values) {
+				continue
+			}
+			if views[k] == nil {
+				views[k] = make([]uint64, len(q.Cohorts))
+			}
+			views[k][i]++
+		}
+	}
+	m.lock.RUnlock()
+
+	perDay := make(map[uint32]*cohortRow)
+	visitors := make(map[key][]bool)
+	for k, counts := range views {
+		r, ok := perDay[k.day]
+		if !ok {
+			r = &cohortRow{day: k.day, cohorts: make([]timeSeriesRow, len(q.Cohorts))}
+			perDay[k.day] = r
+		}
+		visitor := key{day: k.day, user: k.user}
+		if visitors[visitor] == nil {
+			visitors[visitor] = make([]bool, len(q.Cohorts))
+		}
+		for i, n := range counts {
+			r.cohorts[i].views += n
+			if n > 0 && !visitors[visitor][i] {
+				visitors[visitor][i] = true
+				r.cohorts[i].visitors++
+			}
+			if n == 0 || k.session == "" {
+				continue
+			}
+			r.cohorts[i].sessions++
+			if n == 1 {
+				r.cohorts[i].bounces++
+			}
+		}
+	}
+	var days []cohortRow
+	for _, r := range perDay {
+		days = append(days, *r)
+	}
+	return newCohortResult(q, days, SourceMemory, time.Since(started)), nil
+}
+
+// newCohortResult turns per day rows into one point for every day of the
+// range, days without page views are zero.
+func newCohortResult(q CohortQuery, rows []cohortRow, source StatsSource, took time.Duration) *CohortResult {
+	byDay := make(map[uint32]cohortRow, len(rows))
+	for _, r := range rows {
+		byDay[r.day] = r
+	}
+
+	names := make([]string, len(q.Cohorts))
+	for i, c := range q.Cohorts {
+		names[i] = c.Name
+	}
+	points := []CohortPoint{}
+	for _, day := range DayRange(q.Start, q.End) {
+		p := CohortPoint{Day: day, Cohorts: make([]CohortCounts, len(q.Cohorts))}
+		for i, r := range byDay[day].cohorts {
+			p.Cohorts[i] = CohortCounts{Visitors: r.visitors, Pageviews: r.views, Sessions: r.sessions}
+			if r.sessions > 0 {
+				p.Cohorts[i].BounceRate = float64(r.bounces) / float64(r.sessions)
+			}
+		}
+		points = append(points, p)
+	}
+
+	return &CohortResult{
+		Meta: StatsMeta{
+			Rows:        len(points),
+			DurationMs:  float64(took.Microseconds()) / 1000,
+			Granularity: "day",
+			SampleRate:  1,
+			Source:      source,
+		},
+		Cohorts: names,
+		Data:    points,
+	}
+}
@@ -0,0 +1,90 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestCohortQueryValidate(t *testing.T) {
+	two := []Cohort{{Name: "a"}, {Name: "b"}}
+	for _, q := range []CohortQuery{
+		{},
+		{Cohorts: two[:1]},
+		{Cohorts: make([]Cohort, maxCohorts+1)},
+		{Cohorts: []Cohort{{Name: "a"}, {}}},
+		{Cohorts: []Cohort{{Name: "a"}, {Name: "b", Filters: map[string]string{"nope": "x"}}}},
+	} {
+		if err := q.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: got %v", q.Cohorts, err)
+		}
+	}
+	if err := (CohortQuery{Cohorts: []Cohort{{Name: "a", Filters: map[string]string{"campaign": "spring"}}, {Name: "b"}}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCohortQuerySQL(t *testing.T) {
+	qry, args := cohortQuery(CohortQuery{SiteID: "s", Cohorts: []Cohort{
+		{Name: "Mobile Safari", Filters: map[string]string{"os": "iOS", "browser": "Safari"}},
+		{Name: "All"},
+	}})
+	if len(args) != 8 || args[6] != "Safari" || args[7] != "iOS" {
+		t.Errorf("got args %v", args)
+	}
+	for _, part := range []string{
+		"countIf(browser_name = $7 AND os_name = $8) AS views1",
+		"countIf(1) AS views2",
+		"AND ((browser_name = $7 AND os_name = $8) OR (1))",
+		"countIf(views2 > 0 AND session_id != ''), countIf(views2 = 1 AND session_id != '')",
+	} {
+		if !strings.Contains(qry, part) {
+			t.Errorf("no %q in %s", part, qry)
+		}
+	}
+}
+
+func TestMemoryCohorts(t *testing.T) {
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	add := func(user, session, event, browser, campaign string) {
+		trk := Tracking{SiteID: "s", Action: TrackingData{Identity: user, Event: event, Category: PageviewCategory, Campaign: campaign, OccuredAt: 20240301, SessionID: session}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{Name: browser}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// u1 comes back in a second session, u3 has none
+	add("u1", "v1", "/", "Firefox", "spring")
+	add("u1", "v2", "/pricing", "Firefox", "")
+	add("u2", "v3", "/", "Chrome", "spring")
+	add("u3", "", "/", "Chrome", "")
+
+	q := CohortQuery{SiteID: "s", Start: 20240301, End: 20240302, Cohorts: []Cohort{
+		{Name: "Firefox", Filters: map[string]string{"browser": "Firefox"}},
+		{Name: "Chrome", Filters: map[string]string{"browser": "Chrome"}},
+		{Name: "Spring", Filters: map[string]string{"campaign": "spring"}},
+	}}
+	got, err := m.Cohorts(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Data) != 2 || got.Meta.Rows != 2 || strings.Join(got.Cohorts, ",") != "Firefox,Chrome,Spring" {
+		t.Fatalf("got %+v", got)
+	}
+	want := []CohortCounts{
+		{Visitors: 1, Pageviews: 2, Sessions: 2, BounceRate: 1},
+		{Visitors: 2, Pageviews: 2, Sessions: 1, BounceRate: 1},
+		{Visitors: 2, Pageviews: 2, Sessions: 2, BounceRate: 1},
+	}
+	for i, c := range got.Data[0].Cohorts {
+		if c != want[i] {
+			t.Errorf("%s: got %+v, want %+v", got.Cohorts[i], c, want[i])
+		}
+	}
+	if got.Data[1].Day != 20240302 || got.Data[1].Cohorts[0] != (CohortCounts{}) {
+		t.Errorf("day without views: got %+v", got.Data[1])
+	}
+}
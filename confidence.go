@@ -0,0 +1,14 @@
+package tracker
+
+// ConfidenceLevel is the confidence of the bounds given with estimated
+// counts, for confidenceZ standard deviations.
+const (
+	ConfidenceLevel = 0.95
+	confidenceZ     = 1.96
+)
+
+// Interval bounds an estimated count.
+type Interval struct {
+	Lower uint64 `json:"lower"`
+	Upper uint64 `json:"upper"`
+}
@@ -1,21 +1,228 @@
 package tracker
 
-import "os"
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 var config Config
 
+// ServerMode selects which parts of the server an instance runs, so ingest
+// and query serving can be scaled and restarted independently.
+type ServerMode string
+
+const (
+	// ModeFull ingests events and serves stats.
+	ModeFull ServerMode = "full"
+	// ModeReadOnly only serves stats, nothing is ingested or processed.
+	ModeReadOnly ServerMode = "read-only"
+	// ModeIngestOnly only accepts beacons, for edge ingest nodes.
+	ModeIngestOnly ServerMode = "ingest-only"
+)
+
+// Valid reports whether m is one of the modes, an unknown mode would run an
+// instance in a mode nobody chose.
+func (m ServerMode) Valid() bool {
+	return m == ModeFull || m == ModeReadOnly || m == ModeIngestOnly
+}
+
+// Ingests reports whether the instance accepts and processes events.
+func (m ServerMode) Ingests() bool {
+	return m != ModeReadOnly
+}
+
+// Serves reports whether the instance serves stats and admin routes.
+func (m ServerMode) Serves() bool {
+	return m != ModeIngestOnly
+}
+
+// defaultCORSOrigins are allowed when CORS_ORIGINS is not set: the Vite
+// dashboard and the local demo page.
+var defaultCORSOrigins = []string{"http://localhost:5173", "http://127.0.0.1:8081"}
+
 func LoadConfig() {
 	config = Config{
-		APIKey:             os.Getenv("API_KEY"),
-		EchoIPHost:         os.Getenv("ECHOIP_HOST"),
-		ClickHouseHost:     os.Getenv("CLICKHOUSE_HOST"),
-		ClickHouseDB:       os.Getenv("CLICKHOUSE_DB"),
-		ClickHouseUser:     os.Getenv("CLICKHOUSE_USER"),
-		ClickHousePassword: os.Getenv("CLICKHOUSE_PASSWORD"),
-		GoTrackerHost:      os.Getenv("GOTRACKER_HOST"),
+		APIKey:                 os.Getenv("API_KEY"),
+		EchoIPHost:             os.Getenv("ECHOIP_HOST"),
+		ClickHouseHost:         os.Getenv("CLICKHOUSE_HOST"),
+		ClickHouseDB:           os.Getenv("CLICKHOUSE_DB"),
+		ClickHouseUser:         os.Getenv("CLICKHOUSE_USER"),
+		ClickHousePassword:     os.Getenv("CLICKHOUSE_PASSWORD"),
+		ClickHouseCompress:     envString("CLICKHOUSE_COMPRESSION", "lz4"),
+		ClickHouseMaxExec:      envDuration("CLICKHOUSE_MAX_EXECUTION_TIME", time.Minute),
+		ClickHouseBlockBuf:     int(envUint("CLICKHOUSE_BLOCK_BUFFER_SIZE", 10)),
+		ClickHousePing:         envDuration("CLICKHOUSE_PING_INTERVAL", 10*time.Second),
+		ClientVersion:          envString("CLICKHOUSE_CLIENT_VERSION", BuildVersion()),
+		ServerMode:             ServerMode(envString("SERVER_MODE", string(ModeFull))),
+		SitesFile:              os.Getenv("SITES_FILE"),
+		SavedFile:              os.Getenv("SAVED_FILE"),
+		KeysFile:               os.Getenv("KEYS_FILE"),
+		CORSOrigins:            envList("CORS_ORIGINS", defaultCORSOrigins),
+		OriginCheck:            OriginCheck(envString("ORIGIN_CHECK", string(OriginLenient))),
+		SiteVerification:       SiteVerification(envString("SITE_VERIFICATION", string(VerificationOff))),
+		TrustedProxies:         envList("TRUSTED_PROXIES", nil),
+		AdminAddr:              envString("ADMIN_ADDR", "127.0.0.1:9877"),
+		OIDCIssuer:             os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:           os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:       os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:        os.Getenv("OIDC_REDIRECT_URL"),
+		SessionSecret:          os.Getenv("SESSION_SECRET"),
+		SessionTTL:             envDuration("SESSION_TTL", 12*time.Hour),
+		UsersFile:              os.Getenv("USERS_FILE"),
+		QuotaMode:              QuotaMode(envString("QUOTA_MODE", string(QuotaOff))),
+		QuotaFile:              os.Getenv("QUOTA_FILE"),
+		DefaultMonthlyQuota:    envUint("QUOTA_MONTHLY_EVENTS", 0),
+		RawRetentionDays:       envUint("RAW_RETENTION_DAYS", 0),
+		RawRetentionMode:       RetentionMode(envString("RAW_RETENTION_MODE", string(RetentionMutation))),
+		RecompressDays:         envUint("RAW_RECOMPRESS_DAYS", 0),
+		RecompressCodec:        envString("RAW_RECOMPRESS_CODEC", "ZSTD(9)"),
+		EventsSettings:         envList("EVENTS_TABLE_SETTINGS", nil),
+		MaxBodyBytes:           int64(envUint("MAX_BODY_BYTES", 64<<10)),
+		MaxBatchBytes:          int64(envUint("MAX_BATCH_BYTES", 4<<20)),
+		ConsentUnknown:         ConsentPolicy(envString("CONSENT_UNKNOWN", string(ConsentIdentify))),
+		CookieMaxAge:           envDuration("VISITOR_COOKIE_MAX_AGE", 365*24*time.Hour),
+		CookieSameSite:         envString("VISITOR_COOKIE_SAMESITE", "lax"),
+		SessionTimeout:         envDuration("SESSION_TIMEOUT", 30*time.Minute),
+		SessionCacheSize:       int(envUint("SESSION_CACHE_SIZE", 100_000)),
+		SignatureMaxAge:        envDuration("SIGNATURE_MAX_AGE", 5*time.Minute),
+		NonceCacheSize:         int(envUint("NONCE_CACHE_SIZE", 100_000)),
+		IdentityKey:            os.Getenv("IDENTITY_KEY"),
+		IdentityKeyFile:        os.Getenv("IDENTITY_KEY_FILE"),
+		ReplayWindow:           envDuration("REPLAY_WINDOW", 10*time.Second),
+		AnomalyEventsPerMinute: int(envUint("ANOMALY_EVENTS_PER_MINUTE", 0)),
+		AnomalyAction:          AnomalyAction(envString("ANOMALY_ACTION", string(AnomalyTag))),
+		SpamReferrers:          envList("SPAM_REFERRERS", nil),
+		LateEventAfter:         envDuration("LATE_EVENT_AFTER", 24*time.Hour),
+		CanaryInterval:         envDuration("CANARY_INTERVAL", 0),
+		CanarySite:             envString("CANARY_SITE", "_canary"),
+		PageviewTypes:          envList("PAGEVIEW_TYPES", nil),
+		PageviewCategories:     envList("PAGEVIEW_CATEGORIES", nil),
+		MemoryMaxEvents:        int(envUint("MEMORY_MAX_EVENTS", 1_000_000)),
+		QueueSpillFile:         os.Getenv("QUEUE_SPILL_FILE"),
+		QueueHighWater:         int(envUint("QUEUE_HIGH_WATER", 80)),
+		BulkQueueSize:          int(envUint("BULK_QUEUE_SIZE", 10_000)),
+		BulkRate:               int(envUint("BULK_RATE", 1000)),
+		BulkMaxQueued:          int(envUint("BULK_MAX_QUEUED", 50)),
+		JobsDir:                envString("JOBS_DIR", "jobs"),
+		JobRetention:           envDuration("JOB_RETENTION", 7*24*time.Hour),
+		WebhookURLs:            envList("WEBHOOK_URLS", nil),
+		WebhookSecret:          os.Getenv("WEBHOOK_SECRET"),
+		WebhookQuotaPercent:    envUints("WEBHOOK_QUOTA_PERCENT", []uint64{80, 100}),
+		SMTPAddr:               os.Getenv("SMTP_ADDR"),
+		SMTPFrom:               envString("SMTP_FROM", "tracker@localhost"),
+		SMTPUsername:           os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:           os.Getenv("SMTP_PASSWORD"),
+		GeoTimeout:             envDuration("GEO_TIMEOUT", 2*time.Second),
+		GeoWorkers:             int(envUint("GEO_WORKERS", 4)),
+		GeoQueueSize:           int(envUint("GEO_QUEUE_SIZE", 1000)),
+		GeoCacheSize:           int(envUint("GEO_CACHE_SIZE", 10000)),
+		GeoCacheTTL:            envDuration("GEO_CACHE_TTL", time.Hour),
+		EnrichmentBudget:       envDuration("ENRICHMENT_BUDGET", 50*time.Millisecond),
+		GeoOverridesFile:       os.Getenv("GEO_OVERRIDES_FILE"),
+		GeoIPMMDBPath:          os.Getenv("GEOIP_MMDB_PATH"),
+		EnrichmentFailure:      EnrichmentFailure(envString("ENRICHMENT_FAILURE", string(EnrichmentStore))),
+		EnrichmentSpool:        envString("ENRICHMENT_SPOOL", "enrichment.spool"),
+		BreakerThreshold:       int(envUint("BREAKER_THRESHOLD", 5)),
+		BreakerCooldown:        envDuration("BREAKER_COOLDOWN", 30*time.Second),
+		StatsConcurrency:       int(envUint("STATS_MAX_CONCURRENT", 4)),
+		StatsQueueTimeout:      envDuration("STATS_QUEUE_TIMEOUT", 5*time.Second),
+		StatsRateLimit:         int(envUint("STATS_RATE_LIMIT", 0)),
+		StatsDailyQueries:      int(envUint("STATS_DAILY_QUERIES", 0)),
+		WarmupInterval:         envDuration("WARMUP_INTERVAL", 5*time.Minute),
+		PublicURL:              os.Getenv("PUBLIC_URL"),
+		GoTrackerHost:          os.Getenv("GOTRACKER_HOST"),
+	}
+	if config.AdminAddr == "off" {
+		config.AdminAddr = ""
+	}
+	if config.JobsDir == "off" {
+		config.JobsDir = ""
+	}
+}
+
+// UseDevDefaults fills in the settings a local dev instance needs when they
+// are missing from the environment. The API key matches the dashboard's.
+func UseDevDefaults() {
+	if config.APIKey == "" {
+		config.APIKey = "dev"
 	}
 }
 
 func GetConfig() Config {
 	return config
 }
+
+// envList reads a comma separated list, returning def when the variable is unset.
+func envList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envUint reads an unsigned integer, returning def when the variable is unset
+// or invalid.
+func envUint(key string, def uint64) uint64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		slog.Warn("Ignoring invalid config value", slog.String("key", key), slog.String("value", v))
+		return def
+	}
+	return i
+}
+
+// envUints reads a comma separated list of unsigned integers, returning def
+// when the variable is unset or an item is invalid.
+func envUints(key string, def []uint64) []uint64 {
+	items := envList(key, nil)
+	if items == nil {
+		return def
+	}
+	list := make([]uint64, 0, len(items))
+	for _, item := range items {
+		i, err := strconv.ParseUint(item, 10, 64)
+		if err != nil {
+			slog.Warn("Ignoring invalid config value", slog.String("key", key), slog.String("value", item))
+			return def
+		}
+		list = append(list, i)
+	}
+	return list
+}
+
+// envDuration reads a duration such as "10s", returning def when the variable
+// is unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Ignoring invalid config value", slog.String("key", key), slog.String("value", v))
+		return def
+	}
+	return d
+}
@@ -0,0 +1,127 @@
+package tracker
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+var connStats = expvar.NewMap("clickhouse")
+
+// Reconnection attempts back off from reconnectBackoff to maxReconnectBackoff.
+var (
+	reconnectBackoff    = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// swapConn is the connection Events use, it delegates to the current
+// ClickHouse connection which the watchdog replaces once it died. Callers
+// holding it never see the swap.
+type swapConn struct {
+	cur atomic.Pointer[connBox]
+}
+
+type connBox struct{ driver.Conn }
+
+func newSwapConn(conn driver.Conn) *swapConn {
+	s := &swapConn{}
+	s.cur.Store(&connBox{conn})
+	return s
+}
+
+func (s *swapConn) conn() driver.Conn { return s.cur.Load().Conn }
+
+// swap makes conn the current connection and returns the previous one.
+func (s *swapConn) swap(conn driver.Conn) driver.Conn {
+	return s.cur.Swap(&connBox{conn}).Conn
+}
+
+func (s *swapConn) Contributors() []string { return s.conn().Contributors() }
+
+func (s *swapConn) ServerVersion() (*driver.ServerVersion, error) { return s.conn().ServerVersion() }
+
+func (s *swapConn) Select(ctx context.Context, dest any, query string, args ...any) error {
+	return s.conn().Select(ctx, dest, query, args...)
+}
+
+func (s *swapConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	return s.conn().Query(ctx, query, args...)
+}
+
+func (s *swapConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	return s.conn().QueryRow(ctx, query, args...)
+}
+
+func (s *swapConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return s.conn().PrepareBatch(ctx, query, opts...)
+}
+
+func (s *swapConn) Exec(ctx context.Context, query string, args ...any) error {
+	return s.conn().Exec(ctx, query, args...)
+}
+
+func (s *swapConn) AsyncInsert(ctx context.Context, query string, wait bool, args ...any) error {
+	return s.conn().AsyncInsert(ctx, query, wait, args...)
+}
+
+func (s *swapConn) Ping(ctx context.Context) error { return s.conn().Ping(ctx) }
+
+func (s *swapConn) Stats() driver.Stats { return s.conn().Stats() }
+
+func (s *swapConn) Close() error { return s.conn().Close() }
+
+// RunWatchdog pings ClickHouse every interval until ctx is done. When a ping
+// fails the connection is reopened, with backoff until it succeeds, and
+// swapped in for inserts and queries.
+func (e *Events) RunWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			e.heal(ctx, e.dial)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// heal pings the connection and reopens it with dial if the ping fails. It
+// returns false when ctx was done before a connection could be reopened.
+func (e *Events) heal(ctx context.Context, dial func(context.Context) (driver.Conn, error)) bool {
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	err := e.conn.Ping(pingCtx)
+	cancel()
+	if err == nil || ctx.Err() != nil {
+		return err == nil
+	}
+	connStats.Add("ping_failures", 1)
+	e.log.Error("ClickHouse ping failed, reconnecting", slog.Any("error", err))
+
+	backoff := reconnectBackoff
+	for {
+		conn, err := dial(ctx)
+		if err == nil {
+			old := e.conn.swap(conn)
+			old.Close()
+			connStats.Add("reconnects", 1)
+			e.log.Info("Reconnected to ClickHouse")
+			return true
+		}
+		e.log.Error("ClickHouse reconnect failed", slog.Any("error", err), slog.Duration("retryIn", backoff))
+
+		timer := clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+		backoff = min(2*backoff, maxReconnectBackoff)
+	}
+}
@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// fakeConn is a connection whose pings fail with pingErr.
+type fakeConn struct {
+	driver.Conn
+	pingErr error
+	closed  bool
+}
+
+func (c *fakeConn) Ping(context.Context) error { return c.pingErr }
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestHeal(t *testing.T) {
+	prev := reconnectBackoff
+	reconnectBackoff = time.Millisecond
+	t.Cleanup(func() { reconnectBackoff = prev })
+
+	dead := &fakeConn{pingErr: errors.New("connection reset")}
+	e := &Events{log: slog.Default(), conn: newSwapConn(dead)}
+	e.DB = e.conn
+
+	healthy := &fakeConn{}
+	dials := 0
+	dial := func(context.Context) (driver.Conn, error) {
+		dials++
+		if dials < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return healthy, nil
+	}
+
+	if !e.heal(context.Background(), dial) {
+		t.Fatal("not healed")
+	}
+	if dials != 3 || !dead.closed {
+		t.Errorf("got %d dials, dead connection closed: %v", dials, dead.closed)
+	}
+	if err := e.DB.Ping(context.Background()); err != nil {
+		t.Errorf("swapped connection: %v", err)
+	}
+
+	// A live connection is left alone
+	if !e.heal(context.Background(), dial) || dials != 3 {
+		t.Errorf("live connection redialed, %d dials", dials)
+	}
+
+	// Giving up when the tracker stops
+	healthy.pingErr = errors.New("connection reset")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	never := func(context.Context) (driver.Conn, error) { return nil, errors.New("connection refused") }
+	if e.heal(ctx, never) {
+		t.Error("healed without a connection")
+	}
+}
@@ -0,0 +1,44 @@
+package tracker
+
+// Consent is the visitor's consent to being identified, as reported by the
+// site's consent management platform through the tracker script.
+type Consent string
+
+const (
+	ConsentGranted Consent = "granted"
+	ConsentDenied  Consent = "denied"
+	// ConsentUnknown is assumed for beacons without a consent state.
+	ConsentUnknown Consent = "unknown"
+)
+
+var consents = map[Consent]bool{ConsentGranted: true, ConsentDenied: true, ConsentUnknown: true}
+
+// ConsentPolicy selects how events with an unknown consent state are stored.
+type ConsentPolicy string
+
+const (
+	// ConsentIdentify stores them like events with consent.
+	ConsentIdentify ConsentPolicy = "identify"
+	// ConsentAnonymous stores them like events without consent.
+	ConsentAnonymous ConsentPolicy = "anonymous"
+)
+
+// Anonymous reports whether an event to site with consent must be stored
+// without identifying the visitor: no identity, no visitor cookie. Such
+// events still count in page view and breakdown reports. Denied consent is
+// always anonymous, unknown consent follows the site's policy, which
+// defaults to CONSENT_UNKNOWN.
+func Anonymous(site Site, consent Consent) bool {
+	switch consent {
+	case ConsentGranted:
+		return false
+	case ConsentDenied:
+		return true
+	}
+
+	policy := config.ConsentUnknown
+	if site.ConsentUnknown != "" {
+		policy = site.ConsentUnknown
+	}
+	return policy == ConsentAnonymous
+}
@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAnonymous(t *testing.T) {
+	defer func(prev ConsentPolicy) { config.ConsentUnknown = prev }(config.ConsentUnknown)
+	config.ConsentUnknown = ConsentIdentify
+
+	identify := Site{ID: "i"}
+	anonymous := Site{ID: "a", ConsentUnknown: ConsentAnonymous}
+	tests := []struct {
+		site    Site
+		consent Consent
+		want    bool
+	}{
+		{identify, ConsentGranted, false},
+		{identify, ConsentDenied, true},
+		{identify, ConsentUnknown, false},
+		{anonymous, ConsentGranted, false},
+		{anonymous, ConsentDenied, true},
+		{anonymous, ConsentUnknown, true},
+	}
+	for _, tt := range tests {
+		if got := Anonymous(tt.site, tt.consent); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.site.ID, tt.consent, got, tt.want)
+		}
+	}
+
+	config.ConsentUnknown = ConsentAnonymous
+	if !Anonymous(identify, ConsentUnknown) {
+		t.Error("CONSENT_UNKNOWN=anonymous not applied to a site without a policy")
+	}
+}
+
+func TestDecodePayloadConsent(t *testing.T) {
+	tests := []struct {
+		consent string
+		want    Consent
+		err     error
+	}{
+		{``, ConsentUnknown, nil},
+		{`,"consent":"GRANTED"`, ConsentGranted, nil},
+		{`,"consent":"denied"`, ConsentDenied, nil},
+		{`,"consent":"maybe"`, "", ErrMalformedPayload},
+	}
+	for _, tt := range tests {
+		trk, err := DecodePayload([]byte(`{"site_id":"s","tracking":{"event":"/"` + tt.consent + `}}`))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v", tt.consent, err)
+			continue
+		}
+		if trk.Action.Consent != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.consent, trk.Action.Consent, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package tracker
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// CSPDirective is a Content Security Policy directive and its sources.
+type CSPDirective struct {
+	Name    string   `json:"name"`
+	Sources []string `json:"sources"`
+}
+
+// CSPSnippet is what a site's pages need to load the tracking script under
+// a Content Security Policy: the directives to merge into the site's policy,
+// the same as a policy header, and the script tag pinning the script.
+type CSPSnippet struct {
+	SiteID     string         `json:"siteId"`
+	Domain     string         `json:"domain"`
+	Directives []CSPDirective `json:"directives"`
+	Policy     string         `json:"policy"`
+	ScriptTag  string         `json:"scriptTag"`
+}
+
+// NewCSPSnippet returns the CSP snippet of site for the tracker reached at
+// trackerURL. The script is loaded from its origin and beacons are sent
+// back to it.
+func NewCSPSnippet(site Site, trackerURL string) (CSPSnippet, error) {
+	u, err := url.Parse(trackerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return CSPSnippet{}, fmt.Errorf("%w: tracker URL %q must be an absolute http or https URL", ErrInvalid, trackerURL)
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	snippet := CSPSnippet{
+		SiteID: site.ID,
+		Domain: site.Domain,
+		Directives: []CSPDirective{
+			{Name: "script-src", Sources: []string{"'self'", origin}},
+			{Name: "connect-src", Sources: []string{"'self'", origin}},
+		},
+		ScriptTag: fmt.Sprintf(`<script src="%s%s" integrity="%s" crossorigin="anonymous" data-siteid="%s"></script>`,
+			origin, ScriptPath(), ScriptIntegrity(), html.EscapeString(site.ID)),
+	}
+	policy := make([]string, 0, len(snippet.Directives))
+	for _, d := range snippet.Directives {
+		policy = append(policy, d.Name+" "+strings.Join(d.Sources, " "))
+	}
+	snippet.Policy = strings.Join(policy, "; ")
+	return snippet, nil
+}
@@ -0,0 +1,30 @@
+package tracker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewCSPSnippet(t *testing.T) {
+	site := Site{ID: `a"b`, Domain: "shop.example"}
+	snippet, err := NewCSPSnippet(site, "https://stats.example:8443/tracker/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "script-src 'self' https://stats.example:8443; connect-src 'self' https://stats.example:8443"
+	if snippet.Policy != want {
+		t.Errorf("policy: got %q, want %q", snippet.Policy, want)
+	}
+	if !strings.Contains(snippet.ScriptTag, `src="https://stats.example:8443`+ScriptPath()+`"`) ||
+		!strings.Contains(snippet.ScriptTag, `integrity="`+ScriptIntegrity()+`"`) ||
+		!strings.Contains(snippet.ScriptTag, `data-siteid="a&#34;b"`) {
+		t.Errorf("script tag: got %s", snippet.ScriptTag)
+	}
+
+	for _, bad := range []string{"", "stats.example", "ftp://stats.example"} {
+		if _, err := NewCSPSnippet(site, bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: got %v", bad, err)
+		}
+	}
+}
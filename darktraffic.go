@@ -0,0 +1,152 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Page views without a referrer make up the "Direct" bucket, but only those
+// landing on the home page look like typed URLs and bookmarks. Deep pages
+// reached without a referrer are mostly links opened from apps, email and
+// chat clients, which don't send one: dark traffic.
+
+// homePages are the paths counted as direct traffic, once the query string
+// and fragment are removed.
+var homePages = []string{"", "/", "/index.html", "/index.htm", "/index.php"}
+
+// DarkTrafficQuery selects the page views without referrer of a site between
+// two YYYYMMDD days. Limit caps the listed pages.
+type DarkTrafficQuery struct {
+	SiteID string `json:"siteId"`
+	Start  uint32 `json:"start"`
+	End    uint32 `json:"end"`
+	Source string `json:"source,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// DarkTrafficResult splits the page views without referrer into Direct home
+// page hits and Dark deep page views. Pages lists the deep pages, most
+// viewed first.
+type DarkTrafficResult struct {
+	Meta   StatsMeta `json:"meta"`
+	Direct uint64    `json:"direct"`
+	Dark   uint64    `json:"dark"`
+	Pages  []Metric  `json:"pages"`
+}
+
+// isHomePage reports whether event, a path, is the site's home page.
+func isHomePage(event string) bool {
+	if i := strings.IndexAny(event, "?#"); i >= 0 {
+		event = event[:i]
+	}
+	for _, home := range homePages {
+		if event == home {
+			return true
+		}
+	}
+	return false
+}
+
+// DarkTraffic needs the referrer and the page of each event, rollups keep
+// them apart, so only days with raw events are counted.
+func (e *Events) DarkTraffic(ctx context.Context, q DarkTrafficQuery) (*DarkTrafficResult, error) {
+	started := time.Now()
+	if cutoff := rawCutoff(clock.Now()); q.Start < cutoff {
+		q.Start = cutoff
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	qry := `
+		SELECT replaceRegexpOne(event, '[?#].*$', '') AS page, count()
+		FROM events
+		WHERE site_id = $1
+		AND occured_at BETWEEN $2 AND $3
+		AND category = 'Page views'
+		AND quality = 'ok'
+		AND referrer_domain = ''
+		AND ($4 = '' OR source = $4)
+		GROUP BY page
+		ORDER BY 2 DESC, page;
+	`
+	rows, err := e.DB.Query(queryCtx, qry, q.SiteID, q.Start, q.End, q.Source)
+	if err != nil {
+		return nil, fmt.Errorf("dark traffic query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var pages []Metric
+	for rows.Next() {
+		var m Metric
+		if err := rows.Scan(&m.Value, &m.Count); err != nil {
+			return nil, fmt.Errorf("failed scanning dark traffic row: %w", err)
+		}
+		pages = append(pages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dark traffic rows: %w", err)
+	}
+	return newDarkTrafficResult(q, pages, SourceRaw, time.Since(started)), nil
+}
+
+func (m *MemoryEvents) DarkTraffic(ctx context.Context, q DarkTrafficQuery) (*DarkTrafficResult, error) {
+	started := time.Now()
+
+	counts := make(map[string]uint64)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Category != PageviewCategory || row.trk.Action.ReferrerHost != "" {
+			continue
+		}
+		if quality(row.trk.Action) != QualityOK {
+			continue
+		}
+		if row.trk.Action.OccuredAt < q.Start || row.trk.Action.OccuredAt > q.End {
+			continue
+		}
+		if q.Source != "" && row.source() != q.Source {
+			continue
+		}
+		page := row.trk.Action.Event
+		if i := strings.IndexAny(page, "?#"); i >= 0 {
+			page = page[:i]
+		}
+		counts[page]++
+	}
+	m.lock.RUnlock()
+
+	pages := make([]Metric, 0, len(counts))
+	for page, n := range counts {
+		pages = append(pages, Metric{Value: page, Count: n})
+	}
+	sortMetrics(pages)
+	return newDarkTrafficResult(q, pages, SourceMemory, time.Since(started)), nil
+}
+
+// newDarkTrafficResult splits the page views without referrer per page, most
+// viewed first, into the home page and the capped list of deep pages.
+func newDarkTrafficResult(q DarkTrafficQuery, pages []Metric, source StatsSource, took time.Duration) *DarkTrafficResult {
+	result := &DarkTrafficResult{Pages: []Metric{}}
+	for _, page := range pages {
+		if isHomePage(page.Value) {
+			result.Direct += page.Count
+			continue
+		}
+		result.Dark += page.Count
+		if q.Limit <= 0 || len(result.Pages) < q.Limit {
+			result.Pages = append(result.Pages, page)
+		}
+	}
+
+	result.Meta = StatsMeta{
+		Rows:        len(result.Pages),
+		DurationMs:  float64(took.Microseconds()) / 1000,
+		Granularity: "total",
+		SampleRate:  1,
+		Source:      source,
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestDarkTrafficMemory(t *testing.T) {
+	m := NewMemoryEvents()
+	for _, ev := range []struct{ event, referrerHost string }{
+		{"/", ""},
+		{"/?utm_source=bookmark", ""},
+		{"/index.html", ""},
+		{"/blog/launch", ""},
+		{"/blog/launch#comments", ""},
+		{"/pricing?ref=newsletter", ""},
+		{"/blog/launch", "news.ycombinator.com"},
+		{"/", "google.com"},
+	} {
+		trk := Tracking{SiteID: "dark", Action: TrackingData{Event: ev.event, Category: "Page views", ReferrerHost: ev.referrerHost, OccuredAt: 20240301}}
+		m.Add(context.Background(), trk, useragent.UserAgent{}, nil)
+	}
+
+	q := DarkTrafficQuery{SiteID: "dark", Start: 20240301, End: 20240301}
+	got, err := m.DarkTraffic(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Metric{{Value: "/blog/launch", Count: 2}, {Value: "/pricing", Count: 1}}
+	if got.Direct != 3 || got.Dark != 3 || !reflect.DeepEqual(got.Pages, want) {
+		t.Errorf("got direct %d, dark %d, pages %v", got.Direct, got.Dark, got.Pages)
+	}
+
+	// The limit caps the list, not the count
+	q.Limit = 1
+	if got, _ := m.DarkTraffic(context.Background(), q); got.Dark != 3 || len(got.Pages) != 1 || got.Meta.Rows != 1 {
+		t.Errorf("limited: got dark %d, pages %v", got.Dark, got.Pages)
+	}
+}
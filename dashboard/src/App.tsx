@@ -63,8 +63,21 @@ interface FormattedChartDataItem {
   fill: string;
 }
 
+interface StatsResponse {
+  meta: {
+    rows: number;
+    durationMs: number;
+    filters?: Record<string, string>;
+    granularity: "day" | "total";
+    sampleRate: number;
+    source: string;
+    cached: boolean;
+  };
+  data: ApiDataItem[];
+}
+
 type AnalyticsPayload = {
-  What: number;
+  What: string;
   SiteID: string;
   Start: number;
   End: number;
@@ -74,16 +87,18 @@ const postAnalytics = async (
   url: string,
   { arg }: { arg: AnalyticsPayload }
 ) => {
-  const response = await axios.post<ApiDataItem[]>(url, arg, {
+  const response = await axios.post<StatsResponse>(url, arg, {
     headers: {
       "X-API-KEY": "dev",
     },
+    // Sends the session cookie when signed in with OIDC
+    withCredentials: true,
   });
-  return response.data;
+  return response.data.data;
 };
 
 const payload = {
-  What: 6,
+  What: "oses",
   SiteID: "news-corp",
   Start: 20250413,
   End: 20250416,
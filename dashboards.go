@@ -0,0 +1,145 @@
+package tracker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Dashboard limits keep settings small enough to load with every page.
+const (
+	maxDashboardRangeDays = 366
+	maxDashboardItems     = 50
+)
+
+// Dashboard is the layout of a site's dashboard, shared by the embedded
+// dashboard and other frontends. A site has a default dashboard, with an
+// empty User, and users may override it with their own.
+type Dashboard struct {
+	SiteID string `json:"siteId"`
+	User   string `json:"user,omitempty"`
+
+	// RangeDays is the range the dashboard opens with, the last RangeDays
+	// days, 0 leaves it to the frontend.
+	RangeDays int `json:"rangeDays,omitempty"`
+	// PinnedReports are IDs of the site's reports shown first, in order.
+	PinnedReports []string `json:"pinnedReports"`
+	// Goals are the goal events shown as cards, in order.
+	Goals []string `json:"goals"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (d Dashboard) validate() error {
+	if d.SiteID == "" {
+		return fmt.Errorf("%w: siteId is required", ErrInvalid)
+	}
+	if d.RangeDays < 0 || d.RangeDays > maxDashboardRangeDays {
+		return fmt.Errorf("%w: rangeDays must be between 0 and %d", ErrInvalid, maxDashboardRangeDays)
+	}
+	if len(d.PinnedReports) > maxDashboardItems || len(d.Goals) > maxDashboardItems {
+		return fmt.Errorf("%w: at most %d pinned reports and goals", ErrInvalid, maxDashboardItems)
+	}
+	for _, goal := range d.Goals {
+		if goal == "" {
+			return fmt.Errorf("%w: goals can't be empty", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+func dashboardKey(siteID, user string) string {
+	return siteID + "\x00" + user
+}
+
+// Dashboard returns user's dashboard of a site, the site's default when user
+// has none, and an empty one when the site has no default either. Pinned
+// reports deleted since are left out.
+func (s *Saved) Dashboard(siteID, user string) Dashboard {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	d, ok := s.dashboards[dashboardKey(siteID, user)]
+	if !ok {
+		d, ok = s.dashboards[dashboardKey(siteID, "")]
+	}
+	if !ok {
+		d = Dashboard{SiteID: siteID}
+	}
+
+	pinned := []string{}
+	for _, id := range d.PinnedReports {
+		if _, ok := s.reports[id]; ok {
+			pinned = append(pinned, id)
+		}
+	}
+	d.PinnedReports = pinned
+	if d.Goals == nil {
+		d.Goals = []string{}
+	}
+	return d
+}
+
+// PutDashboard replaces the dashboard of d's site and user. Pinned reports
+// must belong to the site and are kept once each, like goals.
+func (s *Saved) PutDashboard(d Dashboard) (Dashboard, error) {
+	if err := d.validate(); err != nil {
+		return Dashboard{}, err
+	}
+	d.PinnedReports = uniqueStrings(d.PinnedReports)
+	d.Goals = uniqueStrings(d.Goals)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, id := range d.PinnedReports {
+		if rep, ok := s.reports[id]; !ok || rep.SiteID != d.SiteID {
+			return Dashboard{}, fmt.Errorf("%w: unknown report %q", ErrInvalid, id)
+		}
+	}
+	d.UpdatedAt = clock.Now().UTC()
+
+	key := dashboardKey(d.SiteID, d.User)
+	prev, exists := s.dashboards[key]
+	s.dashboards[key] = d
+	if err := s.save(); err != nil {
+		if exists {
+			s.dashboards[key] = prev
+		} else {
+			delete(s.dashboards, key)
+		}
+		return Dashboard{}, err
+	}
+	return d, nil
+}
+
+// DeleteDashboard removes user's dashboard of a site, or the site's default
+// when user is empty.
+func (s *Saved) DeleteDashboard(siteID, user string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := dashboardKey(siteID, user)
+	d, ok := s.dashboards[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.dashboards, key)
+	if err := s.save(); err != nil {
+		s.dashboards[key] = d
+		return err
+	}
+	return nil
+}
+
+// uniqueStrings returns list without repeats, keeping the first of each.
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	unique := []string{}
+	for _, v := range list {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saved.json")
+	s := &Saved{}
+	s.Load(path)
+
+	rep, err := s.PutReport(Report{SiteID: "s", Name: "Pages", Metric: QueryPageViewList, RangeDays: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := s.PutReport(Report{SiteID: "other", Name: "Pages", Metric: QueryPageViewList, RangeDays: 7})
+
+	if got := s.Dashboard("s", "ann"); got.SiteID != "s" || len(got.PinnedReports) != 0 || got.Goals == nil {
+		t.Errorf("no settings: got %+v", got)
+	}
+
+	if _, err := s.PutDashboard(Dashboard{SiteID: "s", RangeDays: 30, PinnedReports: []string{rep.ID, rep.ID}, Goals: []string{"Signup", "Purchase", "Signup"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutDashboard(Dashboard{SiteID: "s", User: "bob", RangeDays: 7, Goals: []string{"Purchase"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users without settings of their own get the site's default
+	got := s.Dashboard("s", "ann")
+	if got.User != "" || got.RangeDays != 30 || !reflect.DeepEqual(got.PinnedReports, []string{rep.ID}) || !reflect.DeepEqual(got.Goals, []string{"Signup", "Purchase"}) {
+		t.Errorf("site default: got %+v", got)
+	}
+	if got := s.Dashboard("s", "bob"); got.User != "bob" || got.RangeDays != 7 {
+		t.Errorf("bob: got %+v", got)
+	}
+
+	for name, d := range map[string]Dashboard{
+		"foreign report": {SiteID: "s", PinnedReports: []string{other.ID}},
+		"unknown report": {SiteID: "s", PinnedReports: []string{"nope"}},
+		"long range":     {SiteID: "s", RangeDays: 1000},
+		"empty goal":     {SiteID: "s", Goals: []string{""}},
+		"no site":        {},
+	} {
+		if _, err := s.PutDashboard(d); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	// Deleted reports are no longer pinned, settings survive a restart
+	s.DeleteReport(rep.ID)
+	s.Load(path)
+	if got := s.Dashboard("s", ""); got.RangeDays != 30 || len(got.PinnedReports) != 0 {
+		t.Errorf("reloaded: got %+v", got)
+	}
+
+	if err := s.DeleteDashboard("s", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Dashboard("s", "bob"); got.User != "" {
+		t.Errorf("deleted: got %+v", got)
+	}
+	if err := s.DeleteDashboard("s", "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted twice: got %v", err)
+	}
+}
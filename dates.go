@@ -0,0 +1,112 @@
+package tracker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Events are bucketed by calendar day, stored as YYYYMMDD integers. All day
+// arithmetic goes through the calendar (time.Date normalization) rather than
+// through durations or string formatting, so days stay whole across DST
+// transitions, leap days and month boundaries.
+
+// Granularity is the size of the buckets days are grouped into.
+type Granularity string
+
+const (
+	GranularityDay   Granularity = "day"
+	GranularityWeek  Granularity = "week"
+	GranularityMonth Granularity = "month"
+)
+
+// TimeToInt returns the day t falls on in its location as YYYYMMDD.
+func TimeToInt(t time.Time) uint32 {
+	y, m, d := t.Date()
+	return uint32(y*10000 + int(m)*100 + d)
+}
+
+// DayIn returns the day t falls on in loc as YYYYMMDD.
+func DayIn(t time.Time, loc *time.Location) uint32 {
+	return TimeToInt(t.In(loc))
+}
+
+func splitDay(day uint32) (year int, month time.Month, dd int) {
+	return int(day / 10000), time.Month(day / 100 % 100), int(day % 100)
+}
+
+// ParseDay checks that day is a valid YYYYMMDD day and returns its midnight
+// in UTC.
+func ParseDay(day uint32) (time.Time, error) {
+	y, m, d := splitDay(day)
+	t := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if TimeToInt(t) != day {
+		return time.Time{}, fmt.Errorf("invalid day %d", day)
+	}
+	return t, nil
+}
+
+// DayStart returns the first instant of day in loc. That is midnight unless
+// a DST transition skips midnight, the day then starts when the gap ends.
+func DayStart(day uint32, loc *time.Location) time.Time {
+	y, m, d := splitDay(day)
+	t := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	if TimeToInt(t) != day {
+		// time.Date resolved the missing midnight with the offset before
+		// the transition, landing on the previous day
+		_, end := t.ZoneBounds()
+		return end
+	}
+	return t
+}
+
+// ClosedAt returns when day ended in every time zone, UTC-12 being the
+// last. Stats of ranges ending on day only change after that when late
+// events arrive or rollups are rebuilt.
+func ClosedAt(day uint32) time.Time {
+	return DayStart(AddDays(day, 1), time.UTC).Add(12 * time.Hour)
+}
+
+// AddDays returns the day n calendar days after day, n may be negative.
+func AddDays(day uint32, n int) uint32 {
+	y, m, d := splitDay(day)
+	// Noon UTC, the calendar is the same in every location
+	return TimeToInt(time.Date(y, m, d+n, 12, 0, 0, 0, time.UTC))
+}
+
+// DaysBetween returns the number of calendar days from start to end.
+func DaysBetween(start, end uint32) int {
+	s, _ := ParseDay(start)
+	e, _ := ParseDay(end)
+	return int(e.Sub(s).Hours() / 24)
+}
+
+// DayRange returns every day from start to end inclusive.
+func DayRange(start, end uint32) []uint32 {
+	var days []uint32
+	for day := start; day <= end; day = AddDays(day, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// MonthOf returns the month of day as YYYYMM.
+func MonthOf(day uint32) uint32 {
+	return day / 100
+}
+
+// Bucket returns the first day of the bucket day belongs to: the day itself,
+// the Monday of its ISO week or the first of its month.
+func Bucket(day uint32, g Granularity) uint32 {
+	switch g {
+	case GranularityWeek:
+		t, err := ParseDay(day)
+		if err != nil {
+			return day
+		}
+		offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return AddDays(day, -offset)
+	case GranularityMonth:
+		return MonthOf(day)*100 + 1
+	}
+	return day
+}
@@ -0,0 +1,174 @@
+package tracker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+var testZones = []string{"UTC", "America/New_York", "Europe/Berlin", "America/Santiago", "Australia/Sydney", "Asia/Kolkata", "Australia/Lord_Howe"}
+
+func loadZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestDayIn(t *testing.T) {
+	tests := []struct {
+		at   string
+		zone string
+		want uint32
+	}{
+		// New York springs forward on 2024-03-10 and falls back on 2024-11-03
+		{"2024-03-10T04:30:00Z", "America/New_York", 20240309},
+		{"2024-03-10T06:30:00Z", "America/New_York", 20240310},
+		{"2024-11-03T03:59:59Z", "America/New_York", 20241102},
+		{"2024-11-03T04:00:00Z", "America/New_York", 20241103},
+		{"2024-11-04T04:59:59Z", "America/New_York", 20241103},
+		// Berlin falls back on 2024-10-27
+		{"2024-10-26T21:59:59Z", "Europe/Berlin", 20241026},
+		{"2024-10-26T22:00:00Z", "Europe/Berlin", 20241027},
+		{"2024-10-27T22:59:59Z", "Europe/Berlin", 20241027},
+		{"2024-10-27T23:00:00Z", "Europe/Berlin", 20241028},
+		// Leap day and year boundary ahead of UTC
+		{"2024-02-28T18:30:00Z", "Asia/Kolkata", 20240229},
+		{"2024-12-31T13:00:00Z", "Australia/Sydney", 20250101},
+		{"2025-01-01T04:59:59Z", "America/New_York", 20241231},
+	}
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := DayIn(at, loadZone(t, tt.zone)); got != tt.want {
+			t.Errorf("DayIn(%s, %s) = %d, want %d", tt.at, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestAddDays(t *testing.T) {
+	tests := []struct {
+		day  uint32
+		n    int
+		want uint32
+	}{
+		{20240228, 1, 20240229},
+		{20240228, 2, 20240301},
+		{20230228, 1, 20230301},
+		{20240301, -1, 20240229},
+		{20240331, -31, 20240229},
+		{20240131, 1, 20240201},
+		{20241231, 1, 20250101},
+		{20250101, -1, 20241231},
+		{20000228, 1, 20000229},
+		{19000228, 1, 19000301},
+		{20240310, 1, 20240311},
+		{20241103, 1, 20241104},
+		{20240101, 366, 20250101},
+		{20240115, 0, 20240115},
+	}
+	for _, tt := range tests {
+		if got := AddDays(tt.day, tt.n); got != tt.want {
+			t.Errorf("AddDays(%d, %d) = %d, want %d", tt.day, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseDay(t *testing.T) {
+	for _, day := range []uint32{20240229, 20000229, 20241231, 20240101} {
+		if _, err := ParseDay(day); err != nil {
+			t.Errorf("ParseDay(%d): %v", day, err)
+		}
+	}
+	for _, day := range []uint32{0, 20230229, 19000229, 20240431, 20241301, 20240100, 20240132, 2024031} {
+		if _, err := ParseDay(day); err == nil {
+			t.Errorf("ParseDay(%d) accepted an invalid day", day)
+		}
+	}
+}
+
+func TestDayRange(t *testing.T) {
+	tests := []struct {
+		start, end uint32
+		want       string
+	}{
+		{20240227, 20240302, "[20240227 20240228 20240229 20240301 20240302]"},
+		{20230227, 20230301, "[20230227 20230228 20230301]"},
+		{20241230, 20250102, "[20241230 20241231 20250101 20250102]"},
+		{20240310, 20240310, "[20240310]"},
+		{20240311, 20240310, "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(DayRange(tt.start, tt.end)); got != tt.want {
+			t.Errorf("DayRange(%d, %d) = %s, want %s", tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	if got := DaysBetween(20240101, 20241231); got != 365 {
+		t.Errorf("DaysBetween over a leap year = %d, want 365", got)
+	}
+	if got := DaysBetween(20230101, 20231231); got != 364 {
+		t.Errorf("DaysBetween over a common year = %d, want 364", got)
+	}
+	if got := DaysBetween(20240310, 20240311); got != 1 {
+		t.Errorf("DaysBetween over a DST transition = %d, want 1", got)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		day  uint32
+		g    Granularity
+		want uint32
+	}{
+		{20240229, GranularityDay, 20240229},
+		{20240101, GranularityWeek, 20240101}, // Monday
+		{20240107, GranularityWeek, 20240101}, // Sunday
+		{20240303, GranularityWeek, 20240226}, // week spanning the leap day
+		{20250101, GranularityWeek, 20241230}, // week spanning the new year
+		{20241103, GranularityWeek, 20241028}, // DST ends on a Sunday
+		{20240229, GranularityMonth, 20240201},
+		{20241231, GranularityMonth, 20241201},
+		{20250101, GranularityMonth, 20250101},
+	}
+	for _, tt := range tests {
+		if got := Bucket(tt.day, tt.g); got != tt.want {
+			t.Errorf("Bucket(%d, %s) = %d, want %d", tt.day, tt.g, got, tt.want)
+		}
+	}
+}
+
+// TestDayStartExhaustive checks every day of 2023 to 2025 in zones with and
+// without DST, including one that skips midnight and one with half hour shifts.
+func TestDayStartExhaustive(t *testing.T) {
+	for _, name := range testZones {
+		loc := loadZone(t, name)
+		for day := uint32(20230101); day <= 20251231; day = AddDays(day, 1) {
+			start := DayStart(day, loc)
+			if got := DayIn(start, loc); got != day {
+				t.Fatalf("%s: DayStart(%d) falls on %d", name, day, got)
+			}
+			if got := DayIn(start.Add(-time.Nanosecond), loc); got != AddDays(day, -1) {
+				t.Fatalf("%s: the instant before DayStart(%d) falls on %d", name, day, got)
+			}
+
+			length := DayStart(AddDays(day, 1), loc).Sub(start)
+			if length < 23*time.Hour || length > 25*time.Hour {
+				t.Fatalf("%s: day %d lasts %s", name, day, length)
+			}
+		}
+	}
+
+	ny := loadZone(t, "America/New_York")
+	if got := DayStart(20240311, ny).Sub(DayStart(20240310, ny)); got != 23*time.Hour {
+		t.Errorf("spring forward day lasts %s, want 23h", got)
+	}
+	if got := DayStart(20241104, ny).Sub(DayStart(20241103, ny)); got != 25*time.Hour {
+		t.Errorf("fall back day lasts %s, want 25h", got)
+	}
+}
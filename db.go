@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
@@ -24,28 +27,71 @@ const (
 	QueryBrowsers
 	QueryOSes
 	QueryCountry
+	QueryTouch
+	QueryCampaigns
+	QueryEngines
+	QuerySessions
+	QueryBounceRate
+	QuerySessionDuration
+	QueryUTMSource
+	QueryUTMMedium
+	QueryUTMTerm
+	QueryUTMContent
 )
 
 type qdata struct {
-	trk Tracking
-	ua  useragent.UserAgent
-	geo *GeoInfo
+	trk      Tracking
+	ua       useragent.UserAgent
+	geo      *GeoInfo
+	queuedAt time.Time
 }
 
 type Events struct {
-	DB   driver.Conn
-	ch   chan qdata
-	lock sync.RWMutex
-	q    []qdata
-	wg   sync.WaitGroup
-	log  *slog.Logger
+	DB      driver.Conn
+	conn    *swapConn
+	ch      chan qdata
+	flushes chan chan error
+	lock    sync.RWMutex
+	q       []qdata
+	wg      sync.WaitGroup
+	log     *slog.Logger
+
+	// Events over the high-water mark are spilled, see SpillTo
+	spill     *Spool
+	highWater int
+
+	// Retention purges are announced to hooks, see SetWebhooks
+	hooks *Webhooks
+
+	// Set while inserts are copied for an upgrade, see RunUpgradeWatch
+	dual atomic.Pointer[dualWrite]
 }
 
 func (e *Events) Open() error {
 	// Use default logger set in main
 	e.log = slog.Default().With(slog.String("component", "Events"))
+	// Created here rather than in Run so events added before Run is
+	// scheduled don't block on a nil channel
+	e.ch = make(chan qdata, 100)
+	e.flushes = make(chan chan error)
+
+	conn, err := e.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	e.conn = newSwapConn(conn)
+	e.DB = e.conn
+	e.log.Info("Successfully connected to ClickHouse")
+	return nil
+}
+
+// dial opens and pings a new connection to ClickHouse.
+func (e *Events) dial(ctx context.Context) (driver.Conn, error) {
+	compression, err := clickhouseCompression(config.ClickHouseCompress)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := context.Background()
 	options := &clickhouse.Options{
 		Addr: []string{config.ClickHouseHost},
 		Auth: clickhouse.Auth{
@@ -58,31 +104,29 @@ func (e *Events) Open() error {
 			e.log.Debug(fmt.Sprintf(format, v...))
 		},
 		Settings: clickhouse.Settings{
-			"max_execution_time": 60,
-		},
-		Compression: &clickhouse.Compression{
-			Method: clickhouse.CompressionLZ4,
+			"max_execution_time": int(config.ClickHouseMaxExec.Seconds()),
 		},
+		Compression:          compression,
 		DialTimeout:          time.Second * 30,
 		MaxOpenConns:         5,
 		MaxIdleConns:         5,
 		ConnMaxLifetime:      time.Duration(10) * time.Minute,
 		ConnOpenStrategy:     clickhouse.ConnOpenInOrder,
-		BlockBufferSize:      10,
+		BlockBufferSize:      uint8(min(max(config.ClickHouseBlockBuf, 1), 255)),
 		MaxCompressionBuffer: 10240,
 		ClientInfo: clickhouse.ClientInfo{
 			Products: []struct {
 				Name    string
 				Version string
 			}{
-				{Name: "analytics-go", Version: "0.2"},
+				{Name: "analytics-go", Version: config.ClientVersion},
 			},
 		},
 	}
 
 	conn, err := clickhouse.Open(options)
 	if err != nil {
-		return fmt.Errorf("failed to open clickhouse connection: %w", err)
+		return nil, fmt.Errorf("failed to open clickhouse connection: %w", err)
 	}
 
 	if err := conn.Ping(ctx); err != nil {
@@ -94,51 +138,87 @@ func (e *Events) Open() error {
 		} else {
 			e.log.Error("ClickHouse connection ping failed", slog.Any("error", err))
 		}
-		return fmt.Errorf("clickhouse ping failed: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("clickhouse ping failed: %w", err)
 	}
-	e.DB = conn
-	e.log.Info("Successfully connected to ClickHouse")
-	return nil
+	return conn, nil
 }
 
-func (e *Events) EnsureTable() error {
-	qry := `
-		CREATE TABLE IF NOT EXISTS events (
-			site_id String NOT NULL,
-			occured_at UInt32 NOT NULL,
-			type String NOT NULL,
-			user_id String NOT NULL,
-			event String NOT NULL,
-			category String NOT NULL,
-			referrer String NOT NULL,
-			referrer_domain String NOT NULL,
-			is_touch BOOLEAN NOT NULL,
-			browser_name String NOT NULL,
-			os_name String NOT NULL,
-			device_type String NOT NULL,
-			country String NOT NULL,
-			region String NOT NULL,
-			timestamp DateTime DEFAULT now()
-		)
-		ENGINE MergeTree
-		ORDER BY (site_id, occured_at);
-	`
+// clickhouseCompression returns the compression of CLICKHOUSE_COMPRESSION.
+func clickhouseCompression(method string) (*clickhouse.Compression, error) {
+	switch strings.ToLower(method) {
+	case "lz4", "":
+		return &clickhouse.Compression{Method: clickhouse.CompressionLZ4}, nil
+	case "zstd":
+		return &clickhouse.Compression{Method: clickhouse.CompressionZSTD}, nil
+	case "none":
+		return &clickhouse.Compression{Method: clickhouse.CompressionNone}, nil
+	}
+	return nil, fmt.Errorf("unknown CLICKHOUSE_COMPRESSION %q, want lz4, zstd or none", method)
+}
 
+// EnsureTable brings the schema up to date by applying every migration newer
+// than the recorded schema version, then applies the configured table
+// settings and warns about columns drifting from the expected schema.
+func (e *Events) EnsureTable() error {
 	ctx := context.Background()
-	err := e.DB.Exec(ctx, qry)
+
+	settings, err := EventsTableSettings()
 	if err != nil {
-		e.log.Error("Failed to execute EnsureTable query", slog.Any("error", err))
-		return fmt.Errorf("failed ensuring table: %w", err)
+		return err
+	}
+
+	if err := e.DB.Exec(ctx, schemaMigrationsTable); err != nil {
+		e.log.Error("Failed to create schema_migrations table", slog.Any("error", err))
+		return fmt.Errorf("failed ensuring schema_migrations table: %w", err)
 	}
-	e.log.Debug("Events table ensured")
+
+	current, err := e.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, qry := range migrations {
+		version := uint32(i + 1)
+		if version <= current {
+			continue
+		}
+		if err := e.DB.Exec(ctx, withCreationSettings(version, qry, settings)); err != nil {
+			e.log.Error("Failed to apply migration", slog.Int("version", int(version)), slog.Any("error", err))
+			return fmt.Errorf("failed applying migration %d: %w", version, err)
+		}
+		if err := e.DB.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			return fmt.Errorf("failed recording migration %d: %w", version, err)
+		}
+		e.log.Info("Applied migration", slog.Int("version", int(version)))
+	}
+	if err := e.applyTableSettings(ctx, settings, current >= 1); err != nil {
+		return err
+	}
+	e.warnSchemaDrift(ctx)
+
+	e.log.Debug("Events table ensured", slog.Int("schemaVersion", len(migrations)))
 	return nil
 }
 
+// SchemaVersion returns the latest migration applied to the database, 0 when
+// none has been.
+func (e *Events) SchemaVersion(ctx context.Context) (uint32, error) {
+	var version uint32
+	if err := e.DB.QueryRow(ctx, "SELECT max(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed reading schema version: %w", err)
+	}
+	return version, nil
+}
+
 func (e *Events) Add(ctx context.Context, trk Tracking, ua useragent.UserAgent, geo *GeoInfo) error {
 	if geo == nil {
 		geo = &GeoInfo{} // Use an empty struct to avoid nil pointer dereferences later
 	}
-	data := qdata{trk, ua, geo}
+	data := qdata{trk: trk, ua: ua, geo: geo, queuedAt: clock.Now()}
+	if e.spilled(data) {
+		return nil
+	}
 
 	select {
 	case e.ch <- data:
@@ -158,10 +238,16 @@ func (e *Events) Run(ctx context.Context) {
 	e.wg.Add(1)
 	defer e.wg.Done()
 
-	e.ch = make(chan qdata, 100)
 	flushInterval := 10 * time.Second
 	maxBatchSize := 50
-	timer := time.NewTimer(flushInterval)
+	timer := clock.NewTimer(flushInterval)
+
+	var unspill <-chan time.Time
+	if e.spill != nil {
+		ticker := clock.NewTicker(spillDrainInterval)
+		defer ticker.Stop()
+		unspill = ticker.C()
+	}
 
 	e.log.Info("Event processor started", slog.Duration("flushInterval", flushInterval), slog.Int("maxBatchSize", maxBatchSize))
 
@@ -182,7 +268,7 @@ func (e *Events) Run(ctx context.Context) {
 
 			// Reset timer if we add an item, avoids unnecessary timed flush right after batch flush
 			if !timer.Stop() {
-				<-timer.C // Drain timer if Stop() returned false
+				<-timer.C() // Drain timer if Stop() returned false
 			}
 			timer.Reset(flushInterval)
 
@@ -191,11 +277,21 @@ func (e *Events) Run(ctx context.Context) {
 				e.flushQueue()
 			}
 
-		case <-timer.C:
+		case <-timer.C():
 			e.log.Debug("Flushing due to timer")
 			e.flushQueue()
 			timer.Reset(flushInterval) // Reset timer after flush
 
+		case <-unspill:
+			if e.unspill() > 0 {
+				e.flushQueue()
+			}
+
+		case done := <-e.flushes:
+			e.log.Info("Flushing on request")
+			e.drainChannel()
+			done <- e.flushQueue()
+
 		case <-ctx.Done():
 			e.log.Info("Shutdown signal received, stopping event processor.", slog.Any("reason", ctx.Err()))
 			close(e.ch) // Close channel to signal no more adds
@@ -213,13 +309,63 @@ func (e *Events) Run(ctx context.Context) {
 	}
 }
 
+// Flush writes the queued events now rather than at the next batch size or
+// timer flush. It returns the insert error, the events are dropped then as
+// for any failed flush.
+func (e *Events) Flush(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case e.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueueStats describes the events added but not written yet.
+func (e *Events) QueueStats() QueueStats {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	stats := QueueStats{Length: len(e.q) + len(e.ch)}
+	if e.spill != nil {
+		stats.Spilled = e.spill.Len()
+	}
+	// Events wait in the channel for as long as Run takes to receive them,
+	// the oldest one is in the queue unless it is empty
+	if len(e.q) > 0 {
+		stats.OldestAge = clock.Now().Sub(e.q[0].queuedAt)
+	}
+	return stats
+}
+
+// drainChannel moves the events waiting in the channel to the queue, it is
+// only called from Run.
+func (e *Events) drainChannel() {
+	for {
+		select {
+		case data := <-e.ch:
+			e.lock.Lock()
+			e.q = append(e.q, data)
+			e.lock.Unlock()
+		default:
+			return
+		}
+	}
+}
+
 // flushQueue extracts the current queue and calls Insert
 // should only be called from Run() or internally where lock is managed
-func (e *Events) flushQueue() {
+func (e *Events) flushQueue() error {
 	e.lock.Lock()
 	if len(e.q) == 0 {
 		e.lock.Unlock()
-		return // Nothing to flush
+		return nil // Nothing to flush
 	}
 	// Copy buffer to temporary slice to minimize lock time
 	tmp := make([]qdata, len(e.q))
@@ -231,8 +377,88 @@ func (e *Events) flushQueue() {
 	if err := e.Insert(tmp); err != nil {
 		e.log.Error("Error inserting event batch", slog.Any("error", err), slog.Int("failed_count", len(tmp)))
 		// Consider adding retry logic or dead-letter queue here for production
-	} else {
-		e.log.Debug("Successfully inserted batch", slog.Int("count", len(tmp)))
+		return err
+	}
+	e.log.Debug("Successfully inserted batch", slog.Int("count", len(tmp)))
+	return nil
+}
+
+// insertColumns are the events columns Insert writes, in the order of
+// eventColumns.values.
+var insertColumns = []string{
+	"site_id", "occured_at", "type", "user_id", "event", "category",
+	"referrer", "referrer_domain", "is_touch", "browser_name", "os_name",
+	"device_type", "country", "region", "source", "app_version", "os_version",
+	"campaign", "late", "browser_engine", "quality", "session_id",
+	"utm_source", "utm_medium", "utm_term", "utm_content", "happened_at",
+}
+
+// eventColumns holds a batch of events column by column. Appending whole
+// columns spares clickhouse-go reflecting on every value of every row.
+type eventColumns struct {
+	siteID, typ, userID, event, category, referrer, referrerDomain []string
+	browser, os, device, country, region, source, appVersion       []string
+	osVersion, campaign, engine, quality, sessionID                []string
+	utmSource, utmMedium, utmTerm, utmContent                      []string
+	occuredAt                                                      []uint32
+	isTouch, late                                                  []bool
+	happenedAt                                                     []time.Time
+}
+
+func newEventColumns(n int) *eventColumns {
+	strs := func() []string { return make([]string, 0, n) }
+	return &eventColumns{
+		siteID: strs(), typ: strs(), userID: strs(), event: strs(), category: strs(),
+		referrer: strs(), referrerDomain: strs(), browser: strs(), os: strs(),
+		device: strs(), country: strs(), region: strs(), source: strs(),
+		appVersion: strs(), osVersion: strs(), campaign: strs(), engine: strs(),
+		quality: strs(), sessionID: strs(),
+		utmSource: strs(), utmMedium: strs(), utmTerm: strs(), utmContent: strs(),
+		occuredAt:  make([]uint32, 0, n),
+		isTouch:    make([]bool, 0, n),
+		late:       make([]bool, 0, n),
+		happenedAt: make([]time.Time, 0, n),
+	}
+}
+
+func (c *eventColumns) add(qd qdata) {
+	c.siteID = append(c.siteID, qd.trk.SiteID)
+	c.occuredAt = append(c.occuredAt, qd.occuredAt())
+	c.typ = append(c.typ, qd.trk.Action.Type)
+	c.userID = append(c.userID, qd.trk.Action.Identity)
+	c.event = append(c.event, qd.trk.Action.Event)
+	c.category = append(c.category, qd.trk.Action.Category)
+	c.referrer = append(c.referrer, qd.trk.Action.Referrer)
+	c.referrerDomain = append(c.referrerDomain, qd.trk.Action.ReferrerHost)
+	c.isTouch = append(c.isTouch, qd.trk.Action.IsTouchDevice)
+	c.browser = append(c.browser, qd.ua.Name)
+	c.os = append(c.os, qd.ua.OS)
+	c.device = append(c.device, qd.ua.Device)
+	c.country = append(c.country, qd.geo.Country)
+	c.region = append(c.region, qd.geo.RegionName)
+	c.source = append(c.source, qd.source())
+	c.appVersion = append(c.appVersion, qd.trk.Action.AppVersion)
+	c.osVersion = append(c.osVersion, qd.trk.Action.OSVersion)
+	c.campaign = append(c.campaign, qd.trk.Action.Campaign)
+	c.late = append(c.late, qd.trk.Action.Late)
+	c.engine = append(c.engine, BrowserEngine(qd.ua))
+	c.quality = append(c.quality, quality(qd.trk.Action))
+	c.sessionID = append(c.sessionID, qd.trk.Action.SessionID)
+	c.utmSource = append(c.utmSource, qd.trk.Action.UTMSource)
+	c.utmMedium = append(c.utmMedium, qd.trk.Action.UTMMedium)
+	c.utmTerm = append(c.utmTerm, qd.trk.Action.UTMTerm)
+	c.utmContent = append(c.utmContent, qd.trk.Action.UTMContent)
+	c.happenedAt = append(c.happenedAt, qd.happenedAt())
+}
+
+// values returns the columns in the order of insertColumns.
+func (c *eventColumns) values() []any {
+	return []any{
+		c.siteID, c.occuredAt, c.typ, c.userID, c.event, c.category,
+		c.referrer, c.referrerDomain, c.isTouch, c.browser, c.os,
+		c.device, c.country, c.region, c.source, c.appVersion, c.osVersion,
+		c.campaign, c.late, c.engine, c.quality, c.sessionID,
+		c.utmSource, c.utmMedium, c.utmTerm, c.utmContent, c.happenedAt,
 	}
 }
 
@@ -245,42 +471,50 @@ func (e *Events) Insert(batchData []qdata) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	qry := `
-		INSERT INTO events
-		(
-			site_id, occured_at, type, user_id, event, category,
-			referrer, referrer_domain, is_touch, browser_name, os_name,
-			device_type, country, region
-		) VALUES (
-			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
-		)
-	`
-
-	batch, err := e.DB.PrepareBatch(ctx, qry)
+	// Vitals and errors go to their own tables
+	var vitals, jsErrors []qdata
+	cols := newEventColumns(len(batchData))
+	for _, qd := range batchData {
+		switch {
+		case qd.trk.Action.Type == VitalsType:
+			vitals = append(vitals, qd)
+		case qd.trk.Action.Type == ErrorType && qd.trk.Action.Error != nil:
+			jsErrors = append(jsErrors, qd)
+		default:
+			cols.add(qd)
+		}
+	}
+	if len(vitals) > 0 {
+		if err := e.insertVitals(ctx, vitals); err != nil {
+			return err
+		}
+	}
+	if len(jsErrors) > 0 {
+		if err := e.insertErrors(ctx, jsErrors); err != nil {
+			return err
+		}
+	}
+	if len(vitals)+len(jsErrors) == len(batchData) {
+		return nil
+	}
+
+	if err := e.sendColumns(ctx, "events", cols); err != nil {
+		return err
+	}
+	e.copyToUpgrade(ctx, cols)
+	return nil
+}
+
+// sendColumns inserts cols into table, events or a table with its
+// insertColumns.
+func (e *Events) sendColumns(ctx context.Context, table string, cols *eventColumns) error {
+	batch, err := e.DB.PrepareBatch(ctx, "INSERT INTO "+table+" ("+strings.Join(insertColumns, ", ")+")")
 	if err != nil {
 		return fmt.Errorf("failed to prepare batch: %w", err)
 	}
-
-	for _, qd := range batchData {
-		err := batch.Append(
-			qd.trk.SiteID,
-			TimeToInt(time.Now()), // Consider using occured_at from client if available/trustworthy
-			qd.trk.Action.Type,
-			qd.trk.Action.Identity,
-			qd.trk.Action.Event,
-			qd.trk.Action.Category,
-			qd.trk.Action.Referrer,
-			qd.trk.Action.ReferrerHost,
-			qd.trk.Action.IsTouchDevice,
-			qd.ua.Name,
-			qd.ua.OS,
-			qd.ua.Device,
-			qd.geo.Country,
-			qd.geo.RegionName,
-		)
-		if err != nil {
-			// Abort maybe? Or just log and continue? For now, return error.
-			return fmt.Errorf("failed to append to batch: %w", err)
+	for i, values := range cols.values() {
+		if err := batch.Column(i).Append(values); err != nil {
+			return fmt.Errorf("failed to append %s to batch: %w", insertColumns[i], err)
 		}
 	}
 
@@ -291,6 +525,42 @@ func (e *Events) Insert(batchData []qdata) error {
 	return nil
 }
 
+// occuredAt is the day the event was accepted, today for events added
+// without one.
+func (q qdata) occuredAt() uint32 {
+	if q.trk.Action.OccuredAt != 0 {
+		return q.trk.Action.OccuredAt
+	}
+	return Today()
+}
+
+// happenedAt is when the event happened, as reported by the client or else
+// when it was received.
+func (q qdata) happenedAt() time.Time {
+	if !q.trk.Action.HappenedAt.IsZero() {
+		return q.trk.Action.HappenedAt.UTC()
+	}
+	return q.queuedAt.UTC()
+}
+
+// touch returns whether the event came from a touch device, as stored in
+// the touch column.
+func (q qdata) touch() string {
+	if q.trk.Action.IsTouchDevice {
+		return "touch"
+	}
+	return "non-touch"
+}
+
+// source returns the event's source, events queued before sources existed
+// are web events.
+func (q qdata) source() string {
+	if q.trk.Action.Source != "" {
+		return q.trk.Action.Source
+	}
+	return "web"
+}
+
 // WaitFlush waits for the Run goroutine to finish processing.
 func (e *Events) WaitFlush() {
 	e.log.Debug("Waiting for event processor to flush and stop...")
@@ -298,20 +568,23 @@ func (e *Events) WaitFlush() {
 	e.log.Debug("Event processor finished.")
 }
 
-func (e *Events) GetStats(ctx context.Context, data MetricData) ([]Metric, error) {
-	qry := e.GenQuery(data)
+func (e *Events) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	started := time.Now()
+	if _, _, err := filterColumns(data.Filters); err != nil {
+		return nil, err
+	}
+	qry, source := e.GenQuery(data), SourceRaw
+	if useRollups(data, clock.Now()) {
+		if err := rollupQualities(data.Qualities); err != nil {
+			return nil, err
+		}
+		qry, source = e.GenRollupQuery(data), SourceRollups
+	}
 
 	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	rows, err := e.DB.Query(
-		queryCtx,
-		qry,
-		data.SiteID,
-		data.Start,
-		data.End,
-		data.Extra, // Ensure GenQuery handles this parameter safely
-	)
+	rows, err := e.DB.Query(queryCtx, qry, data.args()...)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			e.log.Error("Stats query timed out", slog.Any("error", err))
@@ -322,76 +595,164 @@ func (e *Events) GetStats(ctx context.Context, data MetricData) ([]Metric, error
 	}
 	defer rows.Close()
 
-	var metrics []Metric
+	var metrics []siteMetric
 	for rows.Next() {
-		var m Metric
-		// Assuming Metric struct fields match the query output order
-		if err := rows.Scan(&m.OccuredAt, &m.Value, &m.Count); err != nil {
+		var m siteMetric
+		dest := []any{&m.OccuredAt, &m.Value, &m.Count, &m.site}
+		if metricDefs[data.What].session != noSessionStat {
+			dest = append(dest, &m.sessions)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			e.log.Error("Error scanning stats row", slog.Any("error", err))
-			return nil, fmt.Errorf("failed scanning stats row: %w", err) // Return partial results? For now, fail.
+			return nil, fmt.Errorf("failed scanning stats row: %w", err)
 		}
 		metrics = append(metrics, m)
 	}
 
 	if err := rows.Err(); err != nil {
 		e.log.Error("Error after iterating stats rows", slog.Any("error", err))
-		return metrics, fmt.Errorf("error iterating stats rows: %w", err) // Return processed metrics + error
+		return nil, fmt.Errorf("error iterating stats rows: %w", err)
 	}
 
 	e.log.Debug("Successfully retrieved stats", slog.Int("count", len(metrics)))
-	return metrics, nil
+	return newStatsResult(data, metrics, source, time.Since(started)), nil
 }
 
+func (e *Events) Usage(ctx context.Context, q UsageQuery) ([]UsageRow, error) {
+	period := "day"
+	if q.Monthly {
+		period = "intDiv(day, 100)"
+	}
+	siteFilter := "AND ? = ''"
+	if q.SiteID != "" {
+		siteFilter = "AND site_id = ?"
+	}
+
+	qry := fmt.Sprintf(`
+		SELECT site_id, toString(%s) AS period, sum(events)
+		FROM usage_daily
+		WHERE day BETWEEN ? AND ?
+		%s
+		GROUP BY site_id, period
+		ORDER BY site_id, period;
+	`, period, siteFilter)
+
+	rows, err := e.DB.Query(ctx, qry, q.Start, q.End, q.SiteID)
+	if err != nil {
+		e.log.Error("Error executing usage query", slog.Any("error", err))
+		return nil, fmt.Errorf("usage query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []UsageRow
+	for rows.Next() {
+		var u UsageRow
+		if err := rows.Scan(&u.SiteID, &u.Period, &u.Events); err != nil {
+			return nil, fmt.Errorf("failed scanning usage row: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// args are the arguments of the queries GenQuery and GenRollupQuery return,
+// the values of data.Filters last. Session metrics take no Extra.
+func (data MetricData) args() []any {
+	args := []any{data.Sites(), data.Start, data.End, data.Extra, data.Source, data.Touch, countedQualities(data.Qualities)}
+	if metricDefs[data.What].session != noSessionStat {
+		args = slices.Delete(args, 3, 4)
+	}
+	_, values, _ := filterColumns(data.Filters)
+	for _, v := range values {
+		args = append(args, v)
+	}
+	return args
+}
+
+// filterConditions returns the conditions of data.Filters on the events
+// table, each on a new line after indent. Their arguments are the last of
+// args, from $first.
+func (data MetricData) filterConditions(indent string, first int) string {
+	cols, _, _ := filterColumns(data.Filters)
+	var conds strings.Builder
+	for i, col := range cols {
+		fmt.Fprintf(&conds, "\n%sAND %s = $%d", indent, col, first+i)
+	}
+	return conds.String()
+}
+
+// metricDef describes how a QueryType maps onto the events table: the column
+// to group by, whether results are bucketed per day and an optional column
+// that must equal MetricData.Extra.
+type metricDef struct {
+	field  string
+	daily  bool
+	filter string
+	// session metrics aggregate the sessions of each day, see sessionStat
+	session sessionStat
+}
+
+var metricDefs = map[QueryType]metricDef{
+	QueryPageViews:      {field: "event", daily: true},
+	QueryPageViewList:   {field: "event"},
+	QueryUniqueVisitors: {field: "user_id", daily: true},
+	QueryReferrer:       {field: "referrer", filter: "referrer_domain"},
+	QueryReferrerHost:   {field: "referrer_domain"},
+	QueryBrowsers:       {field: "browser_name"},
+	QueryOSes:           {field: "os_name"},
+	QueryCountry:        {field: "country"},
+	QueryTouch:          {field: "touch"},
+	QueryCampaigns:      {field: "campaign"},
+	QueryEngines:        {field: "browser_engine"},
+	QueryUTMSource:      {field: "utm_source"},
+	QueryUTMMedium:      {field: "utm_medium"},
+	QueryUTMTerm:        {field: "utm_term"},
+	QueryUTMContent:     {field: "utm_content"},
+
+	QuerySessions:        {field: "session_id", daily: true, session: sessionCount},
+	QueryBounceRate:      {field: "session_id", daily: true, session: sessionBounceRate},
+	QuerySessionDuration: {field: "session_id", daily: true, session: sessionDuration},
+}
+
+// GenQuery returns the stats query for data, its arguments are data.args().
+// Rows are grouped per site, GetStats combines them.
 func (e *Events) GenQuery(data MetricData) string {
-	field := ""
-	daily := true
+	def := metricDefs[data.What]
+	if def.session != noSessionStat {
+		return genSessionQuery(def, data.filterConditions("\t\t\t", 7))
+	}
+	field := def.field
 	where := "AND $4 = $4"
-	switch data.What {
-	case QueryPageViews:
-		field = "event"
-	case QueryPageViewList:
-		field = "event"
-		daily = false
-	case QueryUniqueVisitors:
-		field = "user_id"
-	case QueryReferrer:
-		field = "referrer"
-		where = "AND referrer_domain = $3 "
-		daily = false
-	case QueryReferrerHost:
-		field = "referrer_domain"
-		daily = false
-	case QueryBrowsers:
-		field = "browser_name"
-		daily = false
-	case QueryOSes:
-		field = "os_name"
-		daily = false
-	case QueryCountry:
-		field = "country"
-		daily = false
-	}
-
-	if daily {
+	if def.filter != "" {
+		where = fmt.Sprintf("AND %s = $4", def.filter)
+	}
+
+	if def.daily {
 		return fmt.Sprintf(`
-		SELECT occured_at, %s, COUNT(*)
+		SELECT occured_at, %s, COUNT(*), site_id
 		FROM events
-		WHERE site_id = $1
+		WHERE has($1, site_id)
 		AND category = 'Page views'
-		GROUP BY occured_at, %s
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		AND has($7, toString(quality))%s
+		GROUP BY site_id, occured_at, %s
 		HAVING occured_at BETWEEN $2 AND $3
 		ORDER BY 3 DESC;
-	`, field, field)
+	`, field, data.filterConditions("\t\t", 8), field)
 	}
 
 	return fmt.Sprintf(`
-		SELECT toUInt32(0), %s, COUNT(*)
+		SELECT toUInt32(0), %s, COUNT(*), site_id
 		FROM events
-		WHERE site_id = $1
+		WHERE has($1, site_id)
 		AND occured_at BETWEEN $2 AND $3
 		AND category = 'Page views'
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		AND has($7, toString(quality))%s
 		%s 
-		GROUP BY %s
+		GROUP BY site_id, %s
 		ORDER BY 3 DESC;
-	`, field, where, field)
+	`, field, data.filterConditions("\t\t", 8), where, field)
 }
@@ -0,0 +1,235 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type FindingStatus string
+
+const (
+	FindingOK   FindingStatus = "ok"
+	FindingWarn FindingStatus = "warn"
+	FindingFail FindingStatus = "fail"
+)
+
+// Finding is the result of a single doctor check. Hint tells the operator
+// what to do about a warning or failure.
+type Finding struct {
+	Check   string
+	Status  FindingStatus
+	Message string
+	Hint    string
+}
+
+// maxClockSkew is how far the local clock may drift from ClickHouse's before
+// daily bucketing starts attributing events to the wrong day around midnight.
+const maxClockSkew = 5 * time.Second
+
+// Doctor checks the configuration and the services the tracker depends on.
+// It never stops at the first problem, every check produces a finding.
+func Doctor(ctx context.Context, sites *Sites) []Finding {
+	var findings []Finding
+
+	findings = append(findings, checkAPIKey(), checkServerMode())
+
+	events := &Events{}
+	if err := events.Open(); err != nil {
+		findings = append(findings, Finding{
+			Check:   "clickhouse",
+			Status:  FindingFail,
+			Message: err.Error(),
+			Hint:    "check CLICKHOUSE_HOST, CLICKHOUSE_DB, CLICKHOUSE_USER and CLICKHOUSE_PASSWORD",
+		})
+	} else {
+		findings = append(findings, Finding{
+			Check:   "clickhouse",
+			Status:  FindingOK,
+			Message: "connected to " + config.ClickHouseHost,
+		})
+		findings = append(findings, events.checkSchema(ctx), events.checkSchemaDrift(ctx), events.checkClock(ctx))
+		events.DB.Close()
+	}
+
+	findings = append(findings, checkGeo(ctx))
+	findings = append(findings, checkCORS(sites)...)
+	return findings
+}
+
+func checkAPIKey() Finding {
+	if config.APIKey == "" {
+		return Finding{
+			Check:   "api key",
+			Status:  FindingFail,
+			Message: "API_KEY is empty, /stats accepts requests without a key",
+			Hint:    "set API_KEY to a long random string",
+		}
+	}
+	if len(config.APIKey) < 16 {
+		return Finding{
+			Check:   "api key",
+			Status:  FindingWarn,
+			Message: fmt.Sprintf("API_KEY is only %d characters long", len(config.APIKey)),
+			Hint:    "use at least 16 random characters",
+		}
+	}
+	return Finding{Check: "api key", Status: FindingOK, Message: "API_KEY is set"}
+}
+
+func (e *Events) checkSchema(ctx context.Context) Finding {
+	version, err := e.SchemaVersion(ctx)
+	if err != nil {
+		return Finding{
+			Check:   "schema",
+			Status:  FindingFail,
+			Message: err.Error(),
+			Hint:    "start the tracker once so it can create its tables",
+		}
+	}
+
+	latest := LatestSchemaVersion()
+	switch {
+	case version < latest:
+		return Finding{
+			Check:   "schema",
+			Status:  FindingWarn,
+			Message: fmt.Sprintf("schema version %d, this binary expects %d", version, latest),
+			Hint:    "restart the tracker to apply pending migrations",
+		}
+	case version > latest:
+		return Finding{
+			Check:   "schema",
+			Status:  FindingWarn,
+			Message: fmt.Sprintf("schema version %d is newer than this binary's %d", version, latest),
+			Hint:    "upgrade the tracker binary",
+		}
+	}
+	return Finding{Check: "schema", Status: FindingOK, Message: fmt.Sprintf("schema version %d", version)}
+}
+
+func (e *Events) checkClock(ctx context.Context) Finding {
+	var serverNow time.Time
+	if err := e.DB.QueryRow(ctx, "SELECT now()").Scan(&serverNow); err != nil {
+		return Finding{Check: "clock", Status: FindingWarn, Message: "could not read ClickHouse time: " + err.Error()}
+	}
+
+	skew := time.Since(serverNow)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		return Finding{
+			Check:   "clock",
+			Status:  FindingWarn,
+			Message: fmt.Sprintf("local clock differs from ClickHouse by %s", skew.Round(time.Second)),
+			Hint:    "enable NTP on both hosts",
+		}
+	}
+	return Finding{Check: "clock", Status: FindingOK, Message: fmt.Sprintf("skew %s", skew.Round(time.Millisecond))}
+}
+
+func checkServerMode() Finding {
+	if !config.ServerMode.Valid() {
+		return Finding{
+			Check:   "server mode",
+			Status:  FindingFail,
+			Message: fmt.Sprintf("SERVER_MODE %q is not a mode, the server refuses to start", config.ServerMode),
+			Hint:    "set SERVER_MODE to full, read-only or ingest-only",
+		}
+	}
+	return Finding{Check: "server mode", Status: FindingOK, Message: "running " + string(config.ServerMode)}
+}
+
+func checkGeo(ctx context.Context) Finding {
+	if config.GeoIPMMDBPath != "" {
+		db, err := OpenMMDB(config.GeoIPMMDBPath)
+		if err != nil {
+			return Finding{Check: "geo", Status: FindingFail, Message: err.Error(), Hint: "check GEOIP_MMDB_PATH is a GeoLite2 City or Country database"}
+		}
+		if info, err := db.Lookup(ctx, "8.8.8.8"); err != nil || info.Country == "" {
+			return Finding{
+				Check:   "geo",
+				Status:  FindingWarn,
+				Message: db.Type + " database at " + config.GeoIPMMDBPath + " has no country for 8.8.8.8",
+				Hint:    "use a GeoLite2 City or Country database",
+			}
+		}
+		if config.EchoIPHost == "" {
+			return Finding{Check: "geo", Status: FindingOK, Message: db.Type + " database at " + config.GeoIPMMDBPath}
+		}
+	}
+	if config.EchoIPHost == "" {
+		return Finding{
+			Check:   "geo",
+			Status:  FindingWarn,
+			Message: "ECHOIP_HOST and GEOIP_MMDB_PATH are empty, events are stored without country and region",
+			Hint:    "set GEOIP_MMDB_PATH to a GeoLite2 database, or run echoip (see docker-compose.yml) and set ECHOIP_HOST",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", config.EchoIPHost+"/json?ip=8.8.8.8", nil)
+	if err != nil {
+		return Finding{Check: "geo", Status: FindingFail, Message: err.Error(), Hint: "check ECHOIP_HOST is a URL"}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Finding{Check: "geo", Status: FindingFail, Message: err.Error(), Hint: "check the echoip service is running"}
+	}
+	defer resp.Body.Close()
+
+	var info GeoInfo
+	if resp.StatusCode != http.StatusOK {
+		return Finding{Check: "geo", Status: FindingFail, Message: "echoip responded " + resp.Status}
+	} else if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return Finding{Check: "geo", Status: FindingFail, Message: "invalid echoip response: " + err.Error()}
+	} else if info.Country == "" {
+		return Finding{
+			Check:   "geo",
+			Status:  FindingWarn,
+			Message: "echoip is reachable but returned no country",
+			Hint:    "configure echoip with a GeoLite2 country database",
+		}
+	}
+	return Finding{Check: "geo", Status: FindingOK, Message: "echoip reachable at " + config.EchoIPHost}
+}
+
+// checkCORS makes sure every registered site domain is an allowed origin,
+// otherwise browsers on that site can't read responses from the tracker.
+func checkCORS(sites *Sites) []Finding {
+	allowed := make(map[string]bool)
+	for _, origin := range config.CORSOrigins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	var findings []Finding
+	for _, site := range sites.List() {
+		if site.Domain == "" {
+			continue
+		}
+		if allowed["https://"+site.Domain] || allowed["http://"+site.Domain] {
+			continue
+		}
+		findings = append(findings, Finding{
+			Check:   "cors",
+			Status:  FindingWarn,
+			Message: fmt.Sprintf("site %s domain %s is not an allowed origin", site.ID, site.Domain),
+			Hint:    fmt.Sprintf("add https://%s to CORS_ORIGINS", site.Domain),
+		})
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, Finding{
+			Check:   "cors",
+			Status:  FindingOK,
+			Message: fmt.Sprintf("%d allowed origins cover all site domains", len(allowed)),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,121 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckServerMode(t *testing.T) {
+	defer func(prev Config) { config = prev }(config)
+
+	for mode, want := range map[ServerMode]FindingStatus{ModeFull: FindingOK, ModeReadOnly: FindingOK, ModeIngestOnly: FindingOK, "ingest": FindingFail, "": FindingFail} {
+		config.ServerMode = mode
+		if got := checkServerMode(); got.Status != want {
+			t.Errorf("%q: got %+v", mode, got)
+		}
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	defer func(prev Config) { config = prev }(config)
+
+	for key, want := range map[string]FindingStatus{"": FindingFail, "short": FindingWarn, "0123456789abcdef": FindingOK} {
+		config.APIKey = key
+		if got := checkAPIKey(); got.Status != want {
+			t.Errorf("%q: got %+v", key, got)
+		}
+	}
+}
+
+func TestCheckGeo(t *testing.T) {
+	defer func(prev Config) { config = prev }(config)
+
+	echoIP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GeoInfo{IP: r.URL.Query().Get("ip"), Country: "United States"})
+	}))
+	defer echoIP.Close()
+	respond := func(status int, body string) string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(s.Close)
+		return s.URL
+	}
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	data, country, _ := testMMDBData()
+	dir := t.TempDir()
+	located := filepath.Join(dir, "located.mmdb")
+	if err := os.WriteFile(located, buildMMDB(24, map[string]int{"8.8.8.0/24": country}, data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unlocated := filepath.Join(dir, "unlocated.mmdb")
+	if err := os.WriteFile(unlocated, buildMMDB(24, map[string]int{"203.0.113.0/24": country}, data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		mmdb, echo string
+		want       FindingStatus
+	}{
+		{"no provider", "", "", FindingWarn},
+		{"echoip", "", echoIP.URL, FindingOK},
+		{"echoip without country", "", respond(http.StatusOK, `{"ip":"8.8.8.8"}`), FindingWarn},
+		{"echoip error", "", respond(http.StatusBadGateway, ""), FindingFail},
+		{"echoip invalid response", "", respond(http.StatusOK, "<html>"), FindingFail},
+		{"echoip down", "", down.URL, FindingFail},
+		{"echoip not a URL", "", "://echoip", FindingFail},
+		{"database", located, "", FindingOK},
+		{"database and echoip", located, echoIP.URL, FindingOK},
+		{"database without 8.8.8.8", unlocated, "", FindingWarn},
+		{"missing database", filepath.Join(dir, "missing.mmdb"), "", FindingFail},
+	}
+	for _, tt := range tests {
+		config.GeoIPMMDBPath, config.EchoIPHost = tt.mmdb, tt.echo
+		if got := checkGeo(context.Background()); got.Status != tt.want {
+			t.Errorf("%s: got %+v", tt.name, got)
+		}
+	}
+}
+
+func TestCheckCORS(t *testing.T) {
+	defer func(prev Config) { config = prev }(config)
+
+	tests := []struct {
+		name    string
+		origins []string
+		domains []string
+		want    []FindingStatus
+	}{
+		{"no sites", nil, nil, []FindingStatus{FindingOK}},
+		{"allowed", []string{"https://blog.example/", "http://shop.example"}, []string{"blog.example", "shop.example", ""}, []FindingStatus{FindingOK}},
+		{"missing", []string{"https://blog.example"}, []string{"blog.example", "shop.example", "docs.example"}, []FindingStatus{FindingWarn, FindingWarn}},
+	}
+	for _, tt := range tests {
+		config.CORSOrigins = tt.origins
+		sites := &Sites{}
+		sites.Load("")
+		for i, domain := range tt.domains {
+			sites.Ensure(Site{ID: strings.Repeat("s", i+1), Domain: domain})
+		}
+		got := checkCORS(sites)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %+v", tt.name, got)
+			continue
+		}
+		for i := range got {
+			if got[i].Status != tt.want[i] {
+				t.Errorf("%s: got %+v", tt.name, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// EnrichmentFailure selects what happens to events enrichment failed for.
+type EnrichmentFailure string
+
+const (
+	// EnrichmentStore stores them with empty geo/UA fields.
+	EnrichmentStore EnrichmentFailure = "store"
+	// EnrichmentSpool sets them aside in the spool and retries later.
+	EnrichmentSpool EnrichmentFailure = "spool"
+)
+
+// enrichmentStats is published on /debug/vars.
+var enrichmentStats = expvar.NewMap("enrichment")
+
+var errIncomplete = errors.New("enrichment incomplete")
+
+type enrichJob struct {
+	trk Tracking
+	ua  useragent.UserAgent
+	ip  net.IP
+	// geo is set when the IP's location is overridden, ip isn't looked up.
+	geo *GeoInfo
+	// lookup is the lookup of ip when it wasn't cached.
+	lookup *GeoLookup
+}
+
+// Enricher adds geo information to events off the request path. Events are
+// queued with their anonymized IP, workers look them up (through a cache)
+// and hand them to the store. A lookup taking longer than ENRICHMENT_BUDGET
+// finishes in the background with its event, so a slow provider doesn't
+// hold up the queue, up to GeoQueueSize of them at once.
+type Enricher struct {
+	store     EventStore
+	spool     *Spool
+	blocks    *GeoBlocks
+	overrides *GeoOverrides
+	sites     *Sites
+	geo       GeoProvider
+	jobs      chan enrichJob
+	late      chan struct{}
+	cache     *geoCache
+	wg        sync.WaitGroup
+	log       *slog.Logger
+}
+
+// NewEnricher returns an Enricher adding events to store. spool receives
+// events enrichment failed for in spool mode, it may be nil otherwise.
+// Events from the countries a site blocks are dropped, blocks may be nil.
+// IPs are looked up with echoip at ECHOIP_HOST unless SetGeoProvider
+// changes it.
+func NewEnricher(store EventStore, spool *Spool, blocks *GeoBlocks) *Enricher {
+	return &Enricher{
+		store:  store,
+		spool:  spool,
+		blocks: blocks,
+		geo:    echoIPProvider(config.EchoIPHost),
+		jobs:   make(chan enrichJob, config.GeoQueueSize),
+		late:   make(chan struct{}, config.GeoQueueSize),
+		cache:  newGeoCache(config.GeoCacheSize, config.GeoCacheTTL),
+		log:    slog.Default().With(slog.String("component", "Enricher")),
+	}
+}
+
+// SetGeoOverrides locates the IPs of overrides without looking them up.
+func (e *Enricher) SetGeoOverrides(overrides *GeoOverrides) {
+	e.overrides = overrides
+}
+
+// SetGeoProvider makes the enricher locate IPs with geo, nil stores events
+// without location.
+func (e *Enricher) SetGeoProvider(geo GeoProvider) {
+	e.geo = geo
+}
+
+// SetSites makes the enricher drop the geo fields sites omit, see
+// Site.OmitFields.
+func (e *Enricher) SetSites(sites *Sites) {
+	e.sites = sites
+}
+
+// Start runs the configured number of workers.
+func (e *Enricher) Start() {
+	workers := config.GeoWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		e.wg.Add(1)
+		go e.work()
+	}
+	e.log.Info("Geo enrichment started", slog.Int("workers", workers), slog.Int("queueSize", cap(e.jobs)))
+}
+
+// Submit queues an event for enrichment. ip may be nil, the event is then
+// stored without geo information.
+func (e *Enricher) Submit(ctx context.Context, trk Tracking, ua useragent.UserAgent, ip net.IP) error {
+	job := enrichJob{trk: trk, ua: ua}
+	if ip != nil {
+		job.ip = AnonymizeIP(ip)
+	}
+	// Overrides may be single IPs, they are matched before anonymizing
+	if geo, ok := e.overrides.Lookup(ip); ok {
+		job.geo = geo
+		enrichmentStats.Add("geo_overridden", 1)
+	}
+
+	select {
+	case e.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		e.log.Warn("Failed to queue event for enrichment: context cancelled", slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	}
+}
+
+// Len returns the number of events waiting for enrichment.
+func (e *Enricher) Len() int {
+	return len(e.jobs)
+}
+
+// Close stops accepting events and returns once every queued event has been
+// handed to the store, late lookups included.
+func (e *Enricher) Close() {
+	close(e.jobs)
+	e.wg.Wait()
+}
+
+func (e *Enricher) work() {
+	defer e.wg.Done()
+
+	for job := range e.jobs {
+		if e.uncached(job) {
+			job.lookup = LookupGeo(func() (*GeoInfo, error) { return e.lookup(job.ip) })
+			ctx, cancel := context.WithTimeout(context.Background(), config.EnrichmentBudget)
+			_, err := job.lookup.Wait(ctx)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) && e.finishLate(job) {
+				continue
+			}
+		}
+		e.process(job)
+	}
+}
+
+// uncached reports whether the job's IP is to be looked up and isn't
+// cached.
+func (e *Enricher) uncached(job enrichJob) bool {
+	if job.geo != nil || job.ip == nil || e.geo == nil {
+		return false
+	}
+	_, ok := e.cache.get(job.ip.String(), clock.Now())
+	return !ok
+}
+
+// finishLate processes job in the background once its lookup is done. It
+// returns false when too many lookups are finishing already, the worker
+// then waits for this one.
+func (e *Enricher) finishLate(job enrichJob) bool {
+	select {
+	case e.late <- struct{}{}:
+	default:
+		return false
+	}
+	enrichmentStats.Add("geo_late", 1)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer func() { <-e.late }()
+		e.process(job)
+	}()
+	return true
+}
+
+// process stores the job's event, or spools it when enrichment failed in
+// spool mode.
+func (e *Enricher) process(job enrichJob) {
+	geo, reason := e.enrich(job)
+	if e.blocks.Blocked(job.trk, geo) {
+		return
+	}
+	if reason != "" && config.EnrichmentFailure == EnrichmentSpool && e.spool != nil {
+		ev := SpooledEvent{Tracking: job.trk, Late: job.trk.Action.Late, Quality: job.trk.Action.Quality, SessionID: job.trk.Action.SessionID, Reason: reason}
+		if job.ip != nil {
+			ev.IP = job.ip.String()
+		}
+		err := e.spool.Append(ev)
+		if err == nil {
+			enrichmentStats.Add("spooled", 1)
+			return
+		}
+		e.log.Error("Failed to spool event, storing it without enrichment", slog.Any("error", err))
+	}
+	if reason != "" {
+		enrichmentStats.Add("stored_incomplete", 1)
+	}
+
+	if err := e.add(job, geo); err != nil {
+		e.log.Error("Failed to add enriched event", slog.Any("error", err))
+	}
+}
+
+// enrich looks up the job's geo information, or waits for its lookup.
+// reason is empty when enrichment succeeded, "geo" or "ua" otherwise.
+func (e *Enricher) enrich(job enrichJob) (geo *GeoInfo, reason string) {
+	geo, err := job.geo, error(nil)
+	if geo == nil && job.lookup != nil {
+		geo, err = job.lookup.Wait(context.Background())
+	} else if geo == nil {
+		geo, err = e.lookup(job.ip)
+	}
+	if err != nil {
+		enrichmentStats.Add("geo_failed", 1)
+		reason = "geo"
+	}
+	if job.trk.Action.UserAgent != "" && (job.ua.Name == "" || job.ua.Name == UnknownBrowser) {
+		enrichmentStats.Add("ua_unknown", 1)
+		// Parsing an unknown user agent again won't tell more
+		if reason == "" && job.ua.Name == "" {
+			reason = "ua"
+		}
+	}
+	return geo, reason
+}
+
+func (e *Enricher) add(job enrichJob, geo *GeoInfo) error {
+	// The request that queued the event is long gone, use a fresh context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if e.sites != nil {
+		if site, ok := e.sites.Get(job.trk.SiteID); ok {
+			geo = site.MinimizeGeo(geo)
+		}
+	}
+	return e.store.Add(ctx, job.trk, job.ua, geo)
+}
+
+// lookup returns nil without error when there is nothing to look up.
+func (e *Enricher) lookup(ip net.IP) (*GeoInfo, error) {
+	if ip == nil || e.geo == nil {
+		return nil, nil
+	}
+
+	key := ip.String()
+	if info, ok := e.cache.get(key, clock.Now()); ok {
+		return info, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GeoTimeout)
+	defer cancel()
+
+	info, err := e.geo.Lookup(ctx, key)
+	if err != nil {
+		e.log.Warn("Failed to get geo info", slog.Any("error", err), slog.String("ip", key))
+		return nil, err
+	}
+	e.cache.put(key, info, clock.Now())
+	return info, nil
+}
+
+// RetrySpool drains the spool every interval until ctx is done, storing the
+// events that can be enriched now and spooling the others again.
+func (e *Enricher) RetrySpool(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+		if e.spool.Len() == 0 {
+			continue
+		}
+
+		drained, err := e.spool.Drain(func(ev SpooledEvent) error {
+			ev.Tracking.Action.Late = ev.Late
+			ev.Tracking.Action.Quality = ev.Quality
+			ev.Tracking.Action.SessionID = ev.SessionID
+			job := enrichJob{trk: ev.Tracking, ua: ParseUserAgent(ev.Tracking.Action.UserAgent), ip: net.ParseIP(ev.IP)}
+			geo, reason := e.enrich(job)
+			if reason != "" {
+				return errIncomplete
+			}
+			if e.blocks.Blocked(job.trk, geo) {
+				return nil
+			}
+			return e.add(job, geo)
+		})
+		if err != nil {
+			e.log.Error("Failed to drain enrichment spool", slog.Any("error", err))
+		}
+		if drained > 0 {
+			enrichmentStats.Add("recovered", int64(drained))
+			e.log.Info("Recovered spooled events", slog.Int("count", drained), slog.Int("remaining", e.spool.Len()))
+		}
+	}
+}
+
+type geoCacheEntry struct {
+	info    *GeoInfo
+	expires time.Time
+}
+
+// geoCache is a size bounded cache of lookups. When full an arbitrary entry
+// is evicted, which is good enough for spreading lookups over many IPs.
+type geoCache struct {
+	lock    sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]geoCacheEntry
+}
+
+func newGeoCache(size int, ttl time.Duration) *geoCache {
+	return &geoCache{size: size, ttl: ttl, entries: make(map[string]geoCacheEntry)}
+}
+
+func (c *geoCache) get(key string, now time.Time) (*GeoInfo, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry.info, true
+}
+
+func (c *geoCache) put(key string, info *GeoInfo, now time.Time) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = geoCacheEntry{info: info, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// slowGeo locates every IP in FR once release is closed.
+type slowGeo struct {
+	release chan struct{}
+}
+
+func (g slowGeo) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	select {
+	case <-g.release:
+		return &GeoInfo{Country: "France", CountryISO: "FR"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestEnricherBudget(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.GeoWorkers = 1
+	config.EnrichmentBudget = time.Millisecond
+
+	geo := slowGeo{release: make(chan struct{})}
+	store := NewMemoryEvents()
+	enricher := NewEnricher(store, nil, nil)
+	enricher.SetGeoProvider(geo)
+	enricher.Start()
+
+	submit := func(ip net.IP) {
+		trk := Tracking{SiteID: "budget", Action: TrackingData{OccuredAt: 20240301, Event: "/", Category: PageviewCategory, Identity: ip.String()}}
+		if err := enricher.Submit(context.Background(), trk, useragent.UserAgent{}, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The only worker moves on from the stalled lookup to the next event
+	submit(net.ParseIP("203.0.113.7"))
+	submit(nil)
+	for deadline := time.Now().Add(5 * time.Second); store.Len() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events stored while the lookup stalls, want 1", store.Len())
+		}
+	}
+
+	close(geo.release)
+	enricher.Close()
+	result, err := store.GetStats(context.Background(), MetricData{What: QueryCountry, SiteID: "budget", Start: 20240301, End: 20240301})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var located bool
+	for _, m := range result.Data {
+		located = located || (m.Value == "France" && m.Count == 1)
+	}
+	if store.Len() != 2 || !located {
+		t.Errorf("got %d events, countries %+v", store.Len(), result.Data)
+	}
+}
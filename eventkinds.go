@@ -0,0 +1,89 @@
+package tracker
+
+import (
+	"expvar"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// KindPolicy selects what happens to events of kinds a site doesn't list.
+type KindPolicy string
+
+const (
+	// KindReject refuses them, the default.
+	KindReject KindPolicy = "reject"
+	// KindFlag keeps them, only counting them, to try a list out before
+	// enforcing it.
+	KindFlag KindPolicy = "flag"
+)
+
+var kindStats = expvar.NewMap("event_kinds")
+
+// AllowsKind reports whether the site accepts events like action, whose type
+// or category must be listed when the site lists kinds.
+func (s Site) AllowsKind(action TrackingData) bool {
+	if len(s.AllowedKinds) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.AllowedKinds, func(kind string) bool {
+		return strings.EqualFold(kind, action.Type) || strings.EqualFold(kind, action.Category)
+	})
+}
+
+// UnlistedKinds counts the events of kinds their site doesn't list per site
+// and kind since the process started.
+type UnlistedKinds struct {
+	lock   sync.Mutex
+	counts map[string]map[string]uint64 // site -> category, or type -> events
+}
+
+func NewUnlistedKinds() *UnlistedKinds {
+	return &UnlistedKinds{counts: make(map[string]map[string]uint64)}
+}
+
+// Rejected reports whether site refuses the event of action, counting it
+// when its kind isn't listed even if the site only flags it.
+func (u *UnlistedKinds) Rejected(site Site, action TrackingData) bool {
+	if site.AllowsKind(action) {
+		return false
+	}
+
+	kind := action.Category
+	if kind == "" {
+		kind = action.Type
+	}
+	u.lock.Lock()
+	if u.counts[site.ID] == nil {
+		u.counts[site.ID] = make(map[string]uint64)
+	}
+	u.counts[site.ID][kind]++
+	u.lock.Unlock()
+
+	if site.UnlistedKinds == KindFlag {
+		kindStats.Add("flagged", 1)
+		return false
+	}
+	kindStats.Add("rejected", 1)
+	return true
+}
+
+// Counts returns the number of events of unlisted kinds seen for siteID by
+// kind.
+func (u *UnlistedKinds) Counts(siteID string) map[string]uint64 {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	counts := make(map[string]uint64)
+	for kind, n := range u.counts[siteID] {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// Forget drops the counts of siteID, after its deletion.
+func (u *UnlistedKinds) Forget(siteID string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	delete(u.counts, siteID)
+}
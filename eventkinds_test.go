@@ -0,0 +1,23 @@
+package tracker
+
+import "testing"
+
+func TestAllowsKind(t *testing.T) {
+	site := Site{AllowedKinds: []string{"page", "Signup"}}
+	for _, tt := range []struct {
+		action TrackingData
+		want   bool
+	}{
+		{TrackingData{Type: "page", Category: "Page views"}, true},
+		{TrackingData{Type: "event", Category: "signup"}, true},
+		{TrackingData{Type: "event", Category: "widget"}, false},
+		{TrackingData{}, false},
+	} {
+		if got := site.AllowsKind(tt.action); got != tt.want {
+			t.Errorf("%+v: got %v", tt.action, got)
+		}
+	}
+	if !(Site{}).AllowsKind(TrackingData{Type: "event", Category: "widget"}) {
+		t.Error("site without kinds rejected an event")
+	}
+}
@@ -0,0 +1,156 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IdentityEvent is an event stored for an identity, as exported to the data
+// subject.
+type IdentityEvent struct {
+	SiteID         string    `json:"siteId"`
+	Day            uint32    `json:"day"`
+	ReceivedAt     time.Time `json:"receivedAt"`
+	Type           string    `json:"type"`
+	Event          string    `json:"event"`
+	Category       string    `json:"category"`
+	Referrer       string    `json:"referrer"`
+	ReferrerDomain string    `json:"referrerDomain"`
+	IsTouch        bool      `json:"isTouch"`
+	Browser        string    `json:"browser"`
+	OS             string    `json:"os"`
+	Device         string    `json:"device"`
+	Country        string    `json:"country"`
+	Region         string    `json:"region"`
+	Source         string    `json:"source"`
+	AppVersion     string    `json:"appVersion"`
+	OSVersion      string    `json:"osVersion"`
+	Campaign       string    `json:"campaign"`
+	UTMSource      string    `json:"utmSource"`
+	UTMMedium      string    `json:"utmMedium"`
+	UTMTerm        string    `json:"utmTerm"`
+	UTMContent     string    `json:"utmContent"`
+}
+
+// IdentityExporter is implemented by stores that can export the events of
+// one identity, for data subject access requests.
+type IdentityExporter interface {
+	// ExportIdentity calls fn with every stored event of identity across
+	// sites, oldest first, stopping at the first error fn returns.
+	ExportIdentity(ctx context.Context, identity string, fn func(IdentityEvent) error) error
+}
+
+// SiteExporter is implemented by stores that can export the raw events of a
+// site, for export jobs. Events are exported as IdentityEvent, which carries
+// no identity.
+type SiteExporter interface {
+	// ExportSite calls fn with every stored event of site on day, oldest
+	// first, stopping at the first error fn returns.
+	ExportSite(ctx context.Context, siteID string, day uint32, fn func(IdentityEvent) error) error
+}
+
+// ExportIdentity streams the raw events, aggregates carry no identity. Events
+// older than RAW_RETENTION_DAYS are gone and not exported.
+func (e *Events) ExportIdentity(ctx context.Context, identity string, fn func(IdentityEvent) error) error {
+	if err := e.exportEvents(ctx, "user_id = $1", []any{identity}, fn); err != nil {
+		return fmt.Errorf("identity export: %w", err)
+	}
+	return nil
+}
+
+// ExportSite streams the raw events of a day, like ExportIdentity.
+func (e *Events) ExportSite(ctx context.Context, siteID string, day uint32, fn func(IdentityEvent) error) error {
+	if err := e.exportEvents(ctx, "site_id = $1 AND occured_at = $2", []any{siteID, day}, fn); err != nil {
+		return fmt.Errorf("site export: %w", err)
+	}
+	return nil
+}
+
+// exportEvents calls fn with the events matching where, oldest first.
+func (e *Events) exportEvents(ctx context.Context, where string, args []any, fn func(IdentityEvent) error) error {
+	qry := `
+		SELECT site_id, occured_at, timestamp, type, event, category, referrer,
+			referrer_domain, is_touch, browser_name, os_name, device_type,
+			country, region, source, app_version, os_version, campaign,
+			utm_source, utm_medium, utm_term, utm_content
+		FROM events
+		WHERE ` + where + `
+		ORDER BY timestamp;
+	`
+	rows, err := e.DB.Query(ctx, qry, args...)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev IdentityEvent
+		if err := rows.Scan(
+			&ev.SiteID, &ev.Day, &ev.ReceivedAt, &ev.Type, &ev.Event, &ev.Category, &ev.Referrer,
+			&ev.ReferrerDomain, &ev.IsTouch, &ev.Browser, &ev.OS, &ev.Device,
+			&ev.Country, &ev.Region, &ev.Source, &ev.AppVersion, &ev.OSVersion, &ev.Campaign,
+			&ev.UTMSource, &ev.UTMMedium, &ev.UTMTerm, &ev.UTMContent,
+		); err != nil {
+			return fmt.Errorf("failed scanning row: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (m *MemoryEvents) ExportIdentity(ctx context.Context, identity string, fn func(IdentityEvent) error) error {
+	return m.exportEvents(ctx, func(row qdata) bool { return row.trk.Action.Identity == identity }, fn)
+}
+
+func (m *MemoryEvents) ExportSite(ctx context.Context, siteID string, day uint32, fn func(IdentityEvent) error) error {
+	return m.exportEvents(ctx, func(row qdata) bool { return row.trk.SiteID == siteID && row.occuredAt() == day }, fn)
+}
+
+func (m *MemoryEvents) exportEvents(ctx context.Context, match func(qdata) bool, fn func(IdentityEvent) error) error {
+	m.lock.RLock()
+	var matched []qdata
+	for _, row := range m.rows {
+		if match(row) {
+			matched = append(matched, row)
+		}
+	}
+	m.lock.RUnlock()
+
+	// Rows are kept in the order they were added
+	for _, row := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(IdentityEvent{
+			SiteID:         row.trk.SiteID,
+			Day:            row.occuredAt(),
+			ReceivedAt:     row.queuedAt,
+			Type:           row.trk.Action.Type,
+			Event:          row.trk.Action.Event,
+			Category:       row.trk.Action.Category,
+			Referrer:       row.trk.Action.Referrer,
+			ReferrerDomain: row.trk.Action.ReferrerHost,
+			IsTouch:        row.trk.Action.IsTouchDevice,
+			Browser:        row.ua.Name,
+			OS:             row.ua.OS,
+			Device:         row.ua.Device,
+			Country:        row.geo.Country,
+			Region:         row.geo.RegionName,
+			Source:         row.source(),
+			AppVersion:     row.trk.Action.AppVersion,
+			OSVersion:      row.trk.Action.OSVersion,
+			Campaign:       row.trk.Action.Campaign,
+			UTMSource:      row.trk.Action.UTMSource,
+			UTMMedium:      row.trk.Action.UTMMedium,
+			UTMTerm:        row.trk.Action.UTMTerm,
+			UTMContent:     row.trk.Action.UTMContent,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Forecasts project the daily visitors and page views of a site from its
+// last forecastHistory days, which TimeSeries reads from the raw events or
+// the rollups. Traffic follows a weekly cycle, so with two weeks of history
+// or more days are smoothed with additive Holt-Winters over a season of a
+// week. Younger sites are projected from the same weekday of their one
+// week, or from their average when they have less than that.
+
+const (
+	// MaxForecastDays is the longest forecast, beyond it the weekly cycle
+	// says little.
+	MaxForecastDays = 90
+
+	forecastSeason  = 7
+	forecastHistory = 8 * forecastSeason
+
+	// Smoothing of the level, trend and season. Traffic levels move over
+	// weeks, trends over months.
+	forecastAlpha = 0.3
+	forecastBeta  = 0.05
+	forecastGamma = 0.3
+)
+
+// Forecast methods, from the most history needed to the least.
+const (
+	ForecastHoltWinters   = "holt-winters"
+	ForecastSeasonalNaive = "seasonal-naive"
+	ForecastMean          = "mean"
+)
+
+// ForecastQuery asks for the Days days of a site's traffic starting today.
+type ForecastQuery struct {
+	SiteID string `json:"siteId"`
+	Days   int    `json:"days"`
+	Source string `json:"source,omitempty"`
+	Touch  string `json:"touch,omitempty"`
+}
+
+func (q ForecastQuery) Validate() error {
+	if q.SiteID == "" {
+		return fmt.Errorf("%w: siteId is required", ErrInvalid)
+	}
+	if q.Days < 1 || q.Days > MaxForecastDays {
+		return fmt.Errorf("%w: days must be between 1 and %d", ErrInvalid, MaxForecastDays)
+	}
+	if q.Source != "" && !Sources[q.Source] {
+		return fmt.Errorf("%w: unknown source", ErrInvalid)
+	}
+	if q.Touch != "" && !Touches[q.Touch] {
+		return fmt.Errorf("%w: touch must be touch or non-touch", ErrInvalid)
+	}
+	return nil
+}
+
+// Projection is a forecast count and the ConfidenceLevel bounds it is
+// expected within.
+type Projection struct {
+	Count  uint64   `json:"count"`
+	Bounds Interval `json:"bounds"`
+}
+
+type ForecastPoint struct {
+	Day       uint32     `json:"day"`
+	Visitors  Projection `json:"visitors"`
+	Pageviews Projection `json:"pageviews"`
+}
+
+// ForecastResult is the forecast of each day along with the days of history
+// it was made from, up to yesterday.
+type ForecastResult struct {
+	Meta         StatsMeta       `json:"meta"`
+	Method       string          `json:"method"`
+	HistoryStart uint32          `json:"historyStart"`
+	HistoryEnd   uint32          `json:"historyEnd"`
+	Data         []ForecastPoint `json:"data"`
+}
+
+// Forecast projects the traffic of q's site from the days before today.
+func Forecast(ctx context.Context, store EventStore, q ForecastQuery) (*ForecastResult, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	today := Today()
+	history := TimeSeriesQuery{
+		SiteID: q.SiteID,
+		Start:  AddDays(today, -forecastHistory),
+		End:    AddDays(today, -1),
+		Source: q.Source,
+		Touch:  q.Touch,
+	}
+	series, err := store.TimeSeries(ctx, history)
+	if err != nil {
+		return nil, err
+	}
+
+	// Days before the site's first page view aren't part of its history
+	first := len(series.Data)
+	for i, p := range series.Data {
+		if p.Visitors > 0 || p.Pageviews > 0 {
+			first = i
+			break
+		}
+	}
+	var visitors, pageviews []float64
+	for _, p := range series.Data[first:] {
+		visitors = append(visitors, float64(p.Visitors))
+		pageviews = append(pageviews, float64(p.Pageviews))
+	}
+
+	method, projectVisitors := forecastSeries(visitors, q.Days)
+	_, projectPageviews := forecastSeries(pageviews, q.Days)
+	result := &ForecastResult{
+		Meta:         series.Meta,
+		Method:       method,
+		HistoryStart: history.Start,
+		HistoryEnd:   history.End,
+		Data:         make([]ForecastPoint, q.Days),
+	}
+	result.Meta.Rows = q.Days
+	result.Meta.Confidence = ConfidenceLevel
+	for i := range result.Data {
+		result.Data[i] = ForecastPoint{
+			Day:       AddDays(today, i),
+			Visitors:  projectVisitors[i],
+			Pageviews: projectPageviews[i],
+		}
+	}
+	return result, nil
+}
+
+// forecastSeries projects the next days of y with the method its length
+// allows, bounded by the spread of the method's errors over y.
+func forecastSeries(y []float64, days int) (string, []Projection) {
+	var method string
+	var forecast, errs []float64
+	switch {
+	case len(y) >= 2*forecastSeason:
+		method = ForecastHoltWinters
+		forecast, errs = holtWinters(y, days)
+	case len(y) >= forecastSeason:
+		method = ForecastSeasonalNaive
+		forecast, errs = seasonalNaive(y, days)
+	default:
+		method = ForecastMean
+		forecast, errs = meanForecast(y, days)
+	}
+
+	var sum float64
+	for _, e := range errs {
+		sum += e * e
+	}
+	var spread float64
+	if len(errs) > 0 {
+		spread = math.Sqrt(sum / float64(len(errs)))
+	}
+
+	projections := make([]Projection, days)
+	for h, f := range forecast {
+		// Errors add up the further the forecast reaches
+		margin := confidenceZ * spread * math.Sqrt(float64(h+1))
+		f = math.Max(0, f)
+		projections[h] = Projection{
+			Count: uint64(math.Round(f)),
+			Bounds: Interval{
+				Lower: uint64(math.Max(0, math.Floor(f-margin))),
+				Upper: uint64(math.Ceil(f + margin)),
+			},
+		}
+	}
+	return method, projections
+}
+
+// holtWinters forecasts days after y, of at least two seasons, with
+// additive Holt-Winters, and returns the errors of its one day ahead
+// forecasts over y from the second season on.
+func holtWinters(y []float64, days int) (forecast, errs []float64) {
+	first, second := mean(y[:forecastSeason]), mean(y[forecastSeason:2*forecastSeason])
+	level := first
+	trend := (second - first) / forecastSeason
+	season := make([]float64, forecastSeason)
+	for i := range season {
+		season[i] = y[i] - first
+	}
+
+	for t := forecastSeason; t < len(y); t++ {
+		s := season[t%forecastSeason]
+		errs = append(errs, y[t]-(level+trend+s))
+		next := forecastAlpha*(y[t]-s) + (1-forecastAlpha)*(level+trend)
+		trend = forecastBeta*(next-level) + (1-forecastBeta)*trend
+		season[t%forecastSeason] = forecastGamma*(y[t]-next) + (1-forecastGamma)*s
+		level = next
+	}
+
+	forecast = make([]float64, days)
+	for h := range forecast {
+		forecast[h] = level + float64(h+1)*trend + season[(len(y)+h)%forecastSeason]
+	}
+	return forecast, errs
+}
+
+// seasonalNaive forecasts every day after y, of at least a season, as the
+// same day of its last season.
+func seasonalNaive(y []float64, days int) (forecast, errs []float64) {
+	for t := forecastSeason; t < len(y); t++ {
+		errs = append(errs, y[t]-y[t-forecastSeason])
+	}
+	last := y[len(y)-forecastSeason:]
+	forecast = make([]float64, days)
+	for h := range forecast {
+		forecast[h] = last[h%forecastSeason]
+	}
+	return forecast, errs
+}
+
+// meanForecast forecasts every day after y as its average.
+func meanForecast(y []float64, days int) (forecast, errs []float64) {
+	m := mean(y)
+	for _, v := range y {
+		errs = append(errs, v-m)
+	}
+	forecast = make([]float64, days)
+	for h := range forecast {
+		forecast[h] = m
+	}
+	return forecast, errs
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestForecastSeries(t *testing.T) {
+	week := []float64{10, 20, 30, 40, 50, 5, 5}
+	var history []float64
+	for range 4 {
+		history = append(history, week...)
+	}
+
+	// A steady weekly cycle is projected exactly
+	method, got := forecastSeries(history, 9)
+	if method != ForecastHoltWinters {
+		t.Errorf("got method %s", method)
+	}
+	for h, p := range got {
+		want := uint64(week[h%7])
+		if p.Count != want || p.Bounds != (Interval{want, want}) {
+			t.Errorf("day %d: got %+v, want %d", h, p, want)
+		}
+	}
+
+	// The same weekday of the last seven days, ten days of which one was off
+	method, got = forecastSeries(append(week, 12, 20, 30), 3)
+	if method != ForecastSeasonalNaive || got[0].Count != 40 || got[2].Count != 5 {
+		t.Errorf("ten days: got %s %+v", method, got)
+	}
+	if got[0].Bounds.Upper <= 40 || got[2].Bounds.Upper-got[2].Bounds.Lower <= got[0].Bounds.Upper-got[0].Bounds.Lower {
+		t.Errorf("ten days: bounds don't widen, got %+v", got)
+	}
+
+	method, got = forecastSeries([]float64{4, 8}, 2)
+	if method != ForecastMean || got[1].Count != 6 || got[1].Bounds.Lower != 0 {
+		t.Errorf("two days: got %s %+v", method, got)
+	}
+
+	method, got = forecastSeries(nil, 1)
+	if method != ForecastMean || got[0] != (Projection{}) {
+		t.Errorf("no history: got %s %+v", method, got)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 29, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	// Three weeks of two visitors a day, each viewing the weekday's number
+	// of pages
+	for day := AddDays(20240328, -20); day <= 20240328; day = AddDays(day, 1) {
+		d, _ := ParseDay(day)
+		for _, visitor := range []string{"a", "b"} {
+			for i := 0; i <= int(d.Weekday()); i++ {
+				trk := Tracking{SiteID: "s", Action: TrackingData{Identity: visitor, Event: fmt.Sprint("/", i), Category: PageviewCategory, OccuredAt: day}}
+				if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	got, err := Forecast(ctx, m, ForecastQuery{SiteID: "s", Days: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != ForecastHoltWinters || got.HistoryEnd != 20240328 || len(got.Data) != 7 || got.Meta.Rows != 7 {
+		t.Fatalf("got %+v", got)
+	}
+	for _, p := range got.Data {
+		d, _ := ParseDay(p.Day)
+		if want := uint64(2 * (int(d.Weekday()) + 1)); p.Visitors.Count != 2 || p.Pageviews.Count != want {
+			t.Errorf("%d: got %+v, want 2 visitors and %d page views", p.Day, p, want)
+		}
+	}
+	if got.Data[0].Day != 20240329 {
+		t.Errorf("first day %d, want today", got.Data[0].Day)
+	}
+
+	if _, err := Forecast(ctx, m, ForecastQuery{SiteID: "s", Days: MaxForecastDays + 1}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("too many days: got %v", err)
+	}
+}
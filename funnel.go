@@ -0,0 +1,232 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxFunnelSteps bounds the steps of a funnel, each adds a column to the
+// query.
+const maxFunnelSteps = 8
+
+// FunnelStep is a step of a funnel: events named Event, of Category when
+// set. Label names the step in results, Event when empty. A goal is a
+// funnel of one step.
+type FunnelStep struct {
+	Label    string `json:"label,omitempty"`
+	Event    string `json:"event"`
+	Category string `json:"category,omitempty"`
+}
+
+// FunnelQuery selects the visitors of a site going through Steps in order
+// between two YYYYMMDD days.
+type FunnelQuery struct {
+	SiteID string       `json:"siteId"`
+	Start  uint32       `json:"start"`
+	End    uint32       `json:"end"`
+	Steps  []FunnelStep `json:"steps"`
+}
+
+// Validate checks q, without its days.
+func (q FunnelQuery) Validate() error {
+	if len(q.Steps) == 0 || len(q.Steps) > maxFunnelSteps {
+		return fmt.Errorf("%w: a funnel has 1 to %d steps", ErrInvalid, maxFunnelSteps)
+	}
+	for i, step := range q.Steps {
+		if step.Event == "" {
+			return fmt.Errorf("%w: step %d has no event", ErrInvalid, i+1)
+		}
+	}
+	return nil
+}
+
+// FunnelStepRow is a step of a funnel and the visitors who reached it, in
+// order, after the previous steps. Conversion is the fraction of the first
+// step's visitors who reached it, StepConversion that of the previous
+// step's. MedianSeconds is the median time it took from the previous step,
+// nil for the first step and steps nobody reached.
+type FunnelStepRow struct {
+	Label          string   `json:"label"`
+	Visitors       uint64   `json:"visitors"`
+	Conversion     float64  `json:"conversion"`
+	StepConversion float64  `json:"stepConversion"`
+	MedianSeconds  *float64 `json:"medianSeconds"`
+}
+
+// FunnelResult is a funnel's steps. Visitors are all the visitors of the
+// site over the days, VisitorConversion the fraction of them who reached
+// the last step: a goal's conversion rate.
+type FunnelResult struct {
+	Meta              StatsMeta       `json:"meta"`
+	Visitors          uint64          `json:"visitors"`
+	VisitorConversion float64         `json:"visitorConversion"`
+	Data              []FunnelStepRow `json:"data"`
+}
+
+// FunnelStore is implemented by stores that can compute funnels.
+type FunnelStore interface {
+	Funnel(ctx context.Context, q FunnelQuery) (*FunnelResult, error)
+}
+
+// funnelCounts are the visitors reaching each step and the median seconds
+// from the previous step, as computed by stores.
+type funnelCounts struct {
+	visitors uint64
+	reached  []uint64
+	medians  []float64
+}
+
+func (e *Events) Funnel(ctx context.Context, q FunnelQuery) (*FunnelResult, error) {
+	started := time.Now()
+	qry, args := funnelQuery(q)
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	row := e.DB.QueryRow(queryCtx, qry, args...)
+
+	counts := funnelCounts{reached: make([]uint64, len(q.Steps)), medians: make([]float64, len(q.Steps))}
+	dest := []any{&counts.visitors}
+	for i := range q.Steps {
+		dest = append(dest, &counts.reached[i], &counts.medians[i])
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("funnel query failed: %w", err)
+	}
+	return newFunnelResult(q, counts, SourceRaw, time.Since(started)), nil
+}
+
+// funnelQuery returns the query counting the visitors of every step of q
+// and the median seconds they took from the previous one. Each visitor's
+// step i is the first matching event at or after their step i-1, at 0 when
+// there is none.
+func funnelQuery(q FunnelQuery) (string, []any) {
+	args := []any{q.SiteID, q.Start, q.End}
+	var arrays, times, aggregates []string
+	for i, step := range q.Steps {
+		n := i + 1
+		args = append(args, step.Event, step.Category)
+		arrays = append(arrays, fmt.Sprintf("groupArrayIf(toUInt32(timestamp), event = $%d AND ($%d = '' OR category = $%[2]d)) AS s%d", len(args)-1, len(args), n))
+		if n == 1 {
+			times = append(times, "if(empty(s1), 0, arrayMin(s1)) AS t1")
+			aggregates = append(aggregates, "countIf(t1 > 0), 0")
+			continue
+		}
+		times = append(times, fmt.Sprintf("if(t%d = 0, 0, arrayMin(arrayFilter(x -> x >= t%[1]d, s%d))) AS t%[2]d", n-1, n))
+		aggregates = append(aggregates, fmt.Sprintf("countIf(t%d > 0), ifNotFinite(quantileExactIf(0.5)(t%[1]d - t%d, t%[1]d > 0), 0)", n, n-1))
+	}
+
+	return fmt.Sprintf(`
+		SELECT count(), %s
+		FROM (
+			SELECT %s
+			FROM (
+				SELECT user_id, %s
+				FROM events
+				WHERE site_id = $1
+				AND occured_at BETWEEN $2 AND $3
+				AND quality = 'ok'
+				GROUP BY user_id
+			)
+		);
+	`, strings.Join(aggregates, ", "), strings.Join(times, ", "), strings.Join(arrays, ", ")), args
+}
+
+func (m *MemoryEvents) Funnel(ctx context.Context, q FunnelQuery) (*FunnelResult, error) {
+	started := time.Now()
+
+	// The times of every visitor's events of each step
+	steps := make(map[string][][]time.Time)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		action := row.trk.Action
+		if row.trk.SiteID != q.SiteID || action.OccuredAt < q.Start || action.OccuredAt > q.End || quality(action) != QualityOK {
+			continue
+		}
+		times, ok := steps[action.Identity]
+		if !ok {
+			times = make([][]time.Time, len(q.Steps))
+			steps[action.Identity] = times
+		}
+		for i, step := range q.Steps {
+			if action.Event == step.Event && (step.Category == "" || action.Category == step.Category) {
+				times[i] = append(times[i], row.queuedAt.Truncate(time.Second))
+			}
+		}
+	}
+	m.lock.RUnlock()
+
+	counts := funnelCounts{visitors: uint64(len(steps)), reached: make([]uint64, len(q.Steps)), medians: make([]float64, len(q.Steps))}
+	durations := make([][]float64, len(q.Steps))
+	for _, times := range steps {
+		var prev time.Time
+		for i := range q.Steps {
+			var at time.Time
+			for _, t := range times[i] {
+				if (i == 0 || !t.Before(prev)) && (at.IsZero() || t.Before(at)) {
+					at = t
+				}
+			}
+			if at.IsZero() {
+				break
+			}
+			counts.reached[i]++
+			if i > 0 {
+				durations[i] = append(durations[i], at.Sub(prev).Seconds())
+			}
+			prev = at
+		}
+	}
+	for i, d := range durations {
+		if len(d) > 0 {
+			slices.Sort(d)
+			counts.medians[i] = d[len(d)/2]
+		}
+	}
+	return newFunnelResult(q, counts, SourceMemory, time.Since(started)), nil
+}
+
+func newFunnelResult(q FunnelQuery, counts funnelCounts, source StatsSource, took time.Duration) *FunnelResult {
+	ratio := func(n, of uint64) float64 {
+		if of == 0 {
+			return 0
+		}
+		return float64(n) / float64(of)
+	}
+
+	data := make([]FunnelStepRow, len(q.Steps))
+	for i, step := range q.Steps {
+		row := FunnelStepRow{Label: step.Label, Visitors: counts.reached[i]}
+		if row.Label == "" {
+			row.Label = step.Event
+		}
+		row.Conversion = ratio(counts.reached[i], counts.reached[0])
+		row.StepConversion = 1
+		if i > 0 {
+			row.StepConversion = ratio(counts.reached[i], counts.reached[i-1])
+			if counts.reached[i] > 0 {
+				median := counts.medians[i]
+				row.MedianSeconds = &median
+			}
+		}
+		data[i] = row
+	}
+	if counts.reached[0] == 0 {
+		data[0].StepConversion = 0
+	}
+
+	return &FunnelResult{
+		Meta: StatsMeta{
+			Rows:        len(data),
+			DurationMs:  float64(took.Microseconds()) / 1000,
+			Granularity: "total",
+			SampleRate:  1,
+			Source:      source,
+		},
+		Visitors:          counts.visitors,
+		VisitorConversion: ratio(counts.reached[len(data)-1], counts.visitors),
+		Data:              data,
+	}
+}
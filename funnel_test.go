@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestFunnelQueryValidate(t *testing.T) {
+	steps := func(n int) []FunnelStep {
+		return make([]FunnelStep, n)
+	}
+	for _, q := range []FunnelQuery{
+		{},
+		{Steps: steps(1)},
+		{Steps: append([]FunnelStep{{Event: "/"}}, steps(maxFunnelSteps)...)},
+	} {
+		if err := q.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: got %v", q.Steps, err)
+		}
+	}
+	if err := (FunnelQuery{Steps: []FunnelStep{{Event: "/"}}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFunnelQuerySteps(t *testing.T) {
+	qry, args := funnelQuery(FunnelQuery{SiteID: "s", Steps: []FunnelStep{{Event: "/"}, {Event: "signup", Category: "Clicks"}}})
+	if len(args) != 7 || args[5] != "signup" || args[6] != "Clicks" {
+		t.Errorf("got args %v", args)
+	}
+	for _, part := range []string{"AS s2", "AS t2", "arrayFilter(x -> x >= t1, s2)", "t2 - t1"} {
+		if !strings.Contains(qry, part) {
+			t.Errorf("no %q in %s", part, qry)
+		}
+	}
+}
+
+func TestMemoryFunnel(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	add := func(user, event, category string, after time.Duration) {
+		fc.Advance(after)
+		trk := Tracking{SiteID: "s", Action: TrackingData{Identity: user, Event: event, Category: category, OccuredAt: 20240301}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// u1 goes through both steps in 10s, u2 in 30s, u3 signs up before
+	// viewing the pricing and u4 never does
+	add("u1", "/pricing", PageviewCategory, 0)
+	add("u1", "signup", "Clicks", 10*time.Second)
+	add("u2", "/pricing", PageviewCategory, 0)
+	add("u2", "signup", "Other", 5*time.Second)
+	add("u2", "signup", "Clicks", 25*time.Second)
+	add("u3", "signup", "Clicks", 0)
+	add("u3", "/pricing", PageviewCategory, time.Second)
+	add("u4", "/", PageviewCategory, 0)
+
+	q := FunnelQuery{SiteID: "s", Start: 20240301, End: 20240301, Steps: []FunnelStep{
+		{Label: "Pricing", Event: "/pricing"},
+		{Event: "signup", Category: "Clicks"},
+	}}
+	got, err := m.Funnel(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Visitors != 4 || got.VisitorConversion != 0.5 || got.Meta.Rows != 2 {
+		t.Errorf("got %+v", got)
+	}
+	first, second := got.Data[0], got.Data[1]
+	if first.Label != "Pricing" || first.Visitors != 3 || first.Conversion != 1 || first.MedianSeconds != nil {
+		t.Errorf("first step: got %+v", first)
+	}
+	if second.Label != "signup" || second.Visitors != 2 || second.StepConversion != 2.0/3 || second.MedianSeconds == nil || *second.MedianSeconds != 30 {
+		t.Errorf("second step: got %+v", second)
+	}
+
+	q.Steps = []FunnelStep{{Event: "/checkout"}, {Event: "signup"}}
+	got, _ = m.Funnel(ctx, q)
+	if got.Data[0].Visitors != 0 || got.Data[0].StepConversion != 0 || got.Data[1].Conversion != 0 || got.Data[1].MedianSeconds != nil {
+		t.Errorf("nobody: got %+v", got.Data)
+	}
+}
@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/")
+
+type queryCase struct {
+	name   string
+	data   MetricData
+	rollup bool
+}
+
+// queryCases covers every QueryType, from raw events and from rollups where
+// the metric supports them, and filtered queries.
+func queryCases() []queryCase {
+	var types []QueryType
+	for what := range metricDefs {
+		types = append(types, what)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	var cases []queryCase
+	for _, what := range types {
+		data := MetricData{What: what, SiteID: "site", Start: 20240301, End: 20240331, Extra: "example.com"}
+		cases = append(cases, queryCase{name: "query_" + what.String() + "_raw", data: data})
+
+		def := metricDefs[what]
+		if rollupDimensions[def.field] && (def.filter == "" || rollupDimensions[def.filter]) {
+			cases = append(cases, queryCase{name: "query_" + what.String() + "_rollup", data: data, rollup: true})
+		}
+	}
+
+	// Segment filters, on a daily, a total and a session metric
+	filters := map[string]string{"path": "/docs", "country": "DE"}
+	for _, what := range []QueryType{QueryPageViews, QueryReferrer, QuerySessions} {
+		data := MetricData{What: what, SiteID: "site", Start: 20240301, End: 20240331, Extra: "example.com", Filters: filters}
+		cases = append(cases, queryCase{name: "query_" + what.String() + "_filtered_raw", data: data})
+	}
+	return cases
+}
+
+func (c queryCase) query(e *Events) string {
+	if c.rollup {
+		return e.GenRollupQuery(c.data)
+	}
+	return e.GenQuery(c.data)
+}
+
+func TestGenQueryGolden(t *testing.T) {
+	e := &Events{}
+	for _, c := range queryCases() {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.query(e)
+			path := filepath.Join("testdata", "golden", c.name+".sql")
+
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run go test -run TestGenQueryGolden -update", err)
+			}
+			if got != string(want) {
+				t.Errorf("generated SQL changed, run with -update if intended\n--- got\n%s\n--- want\n%s", got, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package tracker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -8,8 +9,72 @@ import (
 	"strings"
 )
 
-func GetGeoInfo(ip string) (*GeoInfo, error) {
-	req, err := http.NewRequest("GET", config.EchoIPHost+"/json?ip="+ip, nil)
+// GeoProvider locates IPs. Lookups of IPs a provider knows nothing about
+// return a GeoInfo without country rather than an error.
+type GeoProvider interface {
+	Lookup(ctx context.Context, ip string) (*GeoInfo, error)
+}
+
+// GeoLookup is a geo lookup running in the background, so a worker can stop
+// waiting for it without cancelling it.
+type GeoLookup struct {
+	done chan struct{}
+	info *GeoInfo
+	err  error
+}
+
+// LookupGeo runs lookup in the background.
+func LookupGeo(lookup func() (*GeoInfo, error)) *GeoLookup {
+	l := &GeoLookup{done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		l.info, l.err = lookup()
+	}()
+	return l
+}
+
+// Wait returns the lookup result, or ctx's error if ctx is done first.
+func (l *GeoLookup) Wait(ctx context.Context) (*GeoInfo, error) {
+	select {
+	case <-l.done:
+		return l.info, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// NewGeoProvider returns the provider configured with GEOIP_MMDB_PATH and
+// ECHOIP_HOST: the MaxMind database, falling back to echoip for the IPs it
+// can't locate when both are set. It returns nil when neither is.
+func NewGeoProvider() (GeoProvider, error) {
+	echoIP := echoIPProvider(config.EchoIPHost)
+	if config.GeoIPMMDBPath == "" {
+		return echoIP, nil
+	}
+	db, err := OpenMMDB(config.GeoIPMMDBPath)
+	if err != nil {
+		return nil, err
+	}
+	if echoIP == nil {
+		return db, nil
+	}
+	return geoFallback{db, echoIP}, nil
+}
+
+// EchoIP looks IPs up with the echoip service at its URL.
+type EchoIP string
+
+// echoIPProvider returns the echoip provider at host, nil when host is
+// empty.
+func echoIPProvider(host string) GeoProvider {
+	if host == "" {
+		return nil
+	}
+	return EchoIP(host)
+}
+
+func (e EchoIP) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", string(e)+"/json?ip="+ip, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -25,43 +90,153 @@ func GetGeoInfo(ip string) (*GeoInfo, error) {
 	return &info, err
 }
 
+// GetGeoInfo looks ip up with the echoip service at ECHOIP_HOST.
+func GetGeoInfo(ctx context.Context, ip string) (*GeoInfo, error) {
+	return EchoIP(config.EchoIPHost).Lookup(ctx, ip)
+}
+
+// geoFallback asks fallback for the IPs primary fails to locate.
+type geoFallback struct {
+	primary, fallback GeoProvider
+}
+
+func (g geoFallback) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	info, err := g.primary.Lookup(ctx, ip)
+	if err == nil && info.Country != "" {
+		return info, nil
+	}
+	enrichmentStats.Add("geo_fallback", 1)
+	return g.fallback.Lookup(ctx, ip)
+}
+
+// AnonymizeIP zeroes the host part of ip, the last octet of IPv4 and the
+// last 80 bits of IPv6 addresses. That is still precise enough for country
+// and region lookups.
+func AnonymizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
+
+// defaultTrustedProxies are skipped walking X-Forwarded-For chains when
+// TRUSTED_PROXIES is not set: loopback, private and link-local networks,
+// where load balancers and ingress controllers usually live.
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+	"::1/128", "fc00::/7", "fe80::/10",
+}
+
+// IPFromRequest returns the client IP of a request from the first of headers
+// carrying one, or the peer address when none does. forceIP overrides both.
+// IPs are returned in canonical form: 4 bytes for IPv4, including IPv4
+// mapped IPv6 addresses, and without IPv6 zones or ports.
+//
+// X-Forwarded-For lists every hop, clients can put anything at its start.
+// The client is the last hop that isn't a trusted proxy, counting from the
+// proxy closest to the server.
 func IPFromRequest(headers []string, r *http.Request, forceIP string) (net.IP, error) {
-	// try to get IP from HTTP headers
-	// if nothing, get the RemoteAddr
-	remoteIP := ""
+	if len(forceIP) > 0 {
+		if ip := ParseHostIP(forceIP); ip != nil {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("could not parse IP: %s", forceIP)
+	}
+
 	for _, header := range headers {
-		remoteIP = r.Header.Get(header)
+		values := r.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
 		if http.CanonicalHeaderKey(header) == "X-Forwarded-For" {
-			remoteIP = ipFromForwardedForHeader(remoteIP)
+			if ip := ipFromForwardedFor(values); ip != nil {
+				return ip, nil
+			}
+			continue
 		}
-		if remoteIP != "" {
-			break
+		if ip := ParseHostIP(values[0]); ip != nil {
+			return ip, nil
 		}
 	}
 
-	if remoteIP == "" {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			return nil, err
-		}
-		remoteIP = host
+	ip := ParseHostIP(r.RemoteAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("could not parse IP: %s", r.RemoteAddr)
 	}
+	return ip, nil
+}
 
-	if len(forceIP) > 0 {
-		remoteIP = forceIP
+// ParseHostIP parses an IP as found in headers and peer addresses: bare,
+// with a port, bracketed IPv6 with or without a port, with an IPv6 zone. It
+// returns nil for anything else, such as the "unknown" proxies send.
+func ParseHostIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	// Zones only mean something on the host that saw the address
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
 	}
 
-	ip := net.ParseIP(remoteIP)
+	ip := net.ParseIP(s)
 	if ip == nil {
-		return nil, fmt.Errorf("could not parse IP: %s", remoteIP)
+		return nil
 	}
-	return ip, nil
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// ipFromForwardedFor returns the client of X-Forwarded-For headers, a
+// proxy may have added its own header rather than appended to the last one.
+func ipFromForwardedFor(values []string) net.IP {
+	var hops []net.IP
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			if ip := ParseHostIP(hop); ip != nil {
+				hops = append(hops, ip)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+
+	trusted := trustedProxies()
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !trusted(hops[i]) {
+			return hops[i]
+		}
+	}
+	// Every hop is a proxy, the first is as close to the client as it gets
+	return hops[0]
 }
 
-func ipFromForwardedForHeader(v string) string {
-	sep := strings.Index(v, ",")
-	if sep == -1 {
-		return v
+// trustedProxies returns whether an IP is in TRUSTED_PROXIES.
+func trustedProxies() func(net.IP) bool {
+	cidrs := config.TrustedProxies
+	if cidrs == nil {
+		cidrs = defaultTrustedProxies
+	}
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		} else if ip := ParseHostIP(cidr); ip != nil {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+		}
+	}
+	return func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
 	}
-	return v[:sep]
 }
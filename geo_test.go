@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHostIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":                   "203.0.113.7",
+		" 203.0.113.7 ":                 "203.0.113.7",
+		"203.0.113.7:8080":              "203.0.113.7",
+		"2001:db8::1":                   "2001:db8::1",
+		"2001:DB8:0:0:0:0:0:1":          "2001:db8::1",
+		"[2001:db8::1]":                 "2001:db8::1",
+		"[2001:db8::1]:443":             "2001:db8::1",
+		"fe80::1%eth0":                  "fe80::1",
+		"[fe80::1%25eth0]:443":          "fe80::1",
+		"::ffff:203.0.113.7":            "203.0.113.7",
+		"[::ffff:203.0.113.7]:80":       "203.0.113.7",
+		"2001:0db8:0000:0000::0000:0ab": "2001:db8::ab",
+		"unknown":                       "<nil>",
+		"_hidden":                       "<nil>",
+		"":                              "<nil>",
+		"203.0.113":                     "<nil>",
+		"[203.0.113.7":                  "<nil>",
+	}
+	for in, want := range tests {
+		ip := ParseHostIP(in)
+		if got := ip.String(); got != want {
+			t.Errorf("%q: got %s, want %s", in, got, want)
+		}
+		if ip.To4() != nil && len(ip) != 4 {
+			t.Errorf("%q: IPv4 not in canonical 4 byte form", in)
+		}
+	}
+}
+
+func TestIPFromRequest(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+
+	headers := []string{"X-Forwarded-For", "X-Real-IP"}
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		realIP  string
+		forceIP string
+		want    string
+	}{
+		{name: "peer", remote: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "peer IPv6", remote: "[2001:db8::1]:1234", want: "2001:db8::1"},
+		{name: "peer IPv6 zone", remote: "[fe80::1%eth0]:1234", want: "fe80::1"},
+		{name: "peer without port", remote: "2001:db8::1", want: "2001:db8::1"},
+		{name: "single hop", remote: "10.0.0.2:1", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxies skipped", remote: "10.0.0.2:1", xff: []string{"198.51.100.1, 10.0.0.5, 192.168.1.1"}, want: "198.51.100.1"},
+		{name: "spoofed start", remote: "10.0.0.2:1", xff: []string{"1.2.3.4, 198.51.100.1, 10.0.0.5"}, want: "198.51.100.1"},
+		{name: "split headers", remote: "10.0.0.2:1", xff: []string{"1.2.3.4", "198.51.100.1", "10.0.0.5"}, want: "198.51.100.1"},
+		{name: "IPv6 hops", remote: "[::1]:1", xff: []string{"2001:db8::1, [fd00::2]:80, ::1"}, want: "2001:db8::1"},
+		{name: "IPv6 zone hop", remote: "[::1]:1", xff: []string{"2001:db8::7%en0"}, want: "2001:db8::7"},
+		{name: "mapped IPv4 hop", remote: "[::1]:1", xff: []string{"::ffff:198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage hops ignored", remote: "10.0.0.2:1", xff: []string{"unknown, 198.51.100.1, _proxy,"}, want: "198.51.100.1"},
+		{name: "all trusted", remote: "10.0.0.2:1", xff: []string{"192.168.1.5, 10.0.0.5"}, want: "192.168.1.5"},
+		{name: "no valid hop", remote: "10.0.0.2:1", xff: []string{"unknown"}, realIP: "198.51.100.9", want: "198.51.100.9"},
+		{name: "real ip", remote: "10.0.0.2:1", realIP: "[2001:db8::9]", want: "2001:db8::9"},
+		{name: "forced", remote: "10.0.0.2:1", xff: []string{"198.51.100.1"}, forceIP: "[2001:db8::5]", want: "2001:db8::5"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		ip, err := IPFromRequest(headers, r, tt.forceIP)
+		if err != nil || ip.String() != tt.want {
+			t.Errorf("%s: got %v, %v, want %s", tt.name, ip, err, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "not an address"
+	if ip, err := IPFromRequest(headers, r, ""); err == nil {
+		t.Errorf("invalid peer: got %v", ip)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	t.Setenv("TRUSTED_PROXIES", "203.0.113.0/24,2001:db8::1")
+	LoadConfig()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.1:1"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 10.0.0.5, 2001:db8::1, 203.0.113.8")
+	// 10.0.0.5 is no longer trusted, it is the last hop that can be believed
+	ip, err := IPFromRequest([]string{"X-Forwarded-For"}, r, "")
+	if err != nil || ip.String() != "10.0.0.5" {
+		t.Errorf("got %v, %v", ip, err)
+	}
+}
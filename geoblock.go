@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// BlocksCountry reports whether the site drops events from the country with
+// ISO code iso. Events of unknown country are always kept, failed lookups
+// must not lose traffic.
+func (s Site) BlocksCountry(iso string) bool {
+	if iso == "" {
+		return false
+	}
+	has := func(codes []string) bool {
+		return slices.ContainsFunc(codes, func(code string) bool { return strings.EqualFold(code, iso) })
+	}
+	if len(s.AllowedCountries) > 0 && !has(s.AllowedCountries) {
+		return true
+	}
+	return has(s.BlockedCountries)
+}
+
+// GeoBlocks drops the events of sites' blocked countries once they are
+// enriched, and counts them per site and country since the process started.
+type GeoBlocks struct {
+	lock   sync.Mutex
+	sites  *Sites
+	counts map[string]map[string]uint64 // site -> country ISO -> events
+}
+
+// NewGeoBlocks returns GeoBlocks reading each site's countries from sites.
+func NewGeoBlocks(sites *Sites) *GeoBlocks {
+	return &GeoBlocks{sites: sites, counts: make(map[string]map[string]uint64)}
+}
+
+// Blocked reports whether the event of trk, located by geo, must be dropped
+// and counts it when it is. geo may be nil.
+func (b *GeoBlocks) Blocked(trk Tracking, geo *GeoInfo) bool {
+	if b == nil || geo == nil {
+		return false
+	}
+	site, ok := b.sites.Get(trk.SiteID)
+	if !ok || !site.BlocksCountry(geo.CountryISO) {
+		return false
+	}
+
+	iso := strings.ToUpper(geo.CountryISO)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.counts[site.ID] == nil {
+		b.counts[site.ID] = make(map[string]uint64)
+	}
+	b.counts[site.ID][iso]++
+	enrichmentStats.Add("geo_blocked", 1)
+	return true
+}
+
+// Counts returns the number of events blocked for siteID by country ISO
+// code.
+func (b *GeoBlocks) Counts(siteID string) map[string]uint64 {
+	counts := make(map[string]uint64)
+	if b == nil {
+		return counts
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for iso, n := range b.counts[siteID] {
+		counts[iso] = n
+	}
+	return counts
+}
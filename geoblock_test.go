@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestBlocksCountry(t *testing.T) {
+	tests := []struct {
+		site Site
+		iso  string
+		want bool
+	}{
+		{Site{}, "RU", false},
+		{Site{BlockedCountries: []string{"ru", "CN"}}, "RU", true},
+		{Site{BlockedCountries: []string{"RU"}}, "FR", false},
+		{Site{AllowedCountries: []string{"FR", "DE"}}, "FR", false},
+		{Site{AllowedCountries: []string{"FR", "DE"}}, "US", true},
+		{Site{AllowedCountries: []string{"FR"}, BlockedCountries: []string{"FR"}}, "FR", true},
+		// Unknown countries are kept, a failed lookup mustn't drop traffic
+		{Site{AllowedCountries: []string{"FR"}}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.site.BlocksCountry(tt.iso); got != tt.want {
+			t.Errorf("%+v blocks %q: got %v", tt.site, tt.iso, got)
+		}
+	}
+}
+
+func TestEnricherGeoBlocks(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	countries := map[string]string{"198.51.100.0": "RU", "203.0.113.0": "FR"}
+	echoIP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"country_iso":%q}`, countries[r.URL.Query().Get("ip")])
+	}))
+	defer echoIP.Close()
+	defer func(prev string) { config.EchoIPHost = prev }(config.EchoIPHost)
+	config.EchoIPHost = echoIP.URL
+
+	sites := &Sites{}
+	sites.Load("")
+	sites.Ensure(Site{ID: "blocking", BlockedCountries: []string{"RU"}})
+	blocks := NewGeoBlocks(sites)
+	store := NewMemoryEvents()
+	enricher := NewEnricher(store, nil, blocks)
+	enricher.Start()
+
+	for i, ip := range []string{"198.51.100.7", "203.0.113.7", "198.51.100.8"} {
+		for _, site := range []string{"blocking", "open"} {
+			trk := Tracking{SiteID: site, Action: TrackingData{Event: fmt.Sprint("/", i)}}
+			if err := enricher.Submit(context.Background(), trk, useragent.UserAgent{}, net.ParseIP(ip)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	enricher.Close()
+
+	if got := blocks.Counts("blocking"); len(got) != 1 || got["RU"] != 2 {
+		t.Errorf("blocked counts: got %v", got)
+	}
+	if got := blocks.Counts("open"); len(got) != 0 {
+		t.Errorf("open site blocked counts: got %v", got)
+	}
+	if got := store.Len(); got != 4 {
+		t.Errorf("got %d events stored, want 4", got)
+	}
+}
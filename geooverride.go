@@ -0,0 +1,94 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// GeoOverride locates the IPs of Network, an IP or a CIDR, at Geo without
+// looking them up: office IPs, VPN egress or the fixtures of tests.
+type GeoOverride struct {
+	Network string  `json:"network"`
+	Geo     GeoInfo `json:"geo"`
+}
+
+type geoOverride struct {
+	net *net.IPNet
+	geo GeoInfo
+}
+
+// GeoOverrides is the list of GeoOverride, read from a JSON file. They are
+// checked against the client's IP before it is anonymized, the most
+// specific network matching wins.
+type GeoOverrides struct {
+	lock      sync.RWMutex
+	overrides []geoOverride
+}
+
+// Load reads the overrides from path. A missing file or an empty path
+// leaves none.
+func (o *GeoOverrides) Load(path string) error {
+	var list []GeoOverride
+	if path != "" {
+		if err := readJSONFile(path, &list); err != nil {
+			return err
+		}
+	}
+
+	overrides := make([]geoOverride, 0, len(list))
+	for _, override := range list {
+		n := parseNetwork(override.Network)
+		if n == nil {
+			return fmt.Errorf("geo override: invalid network %q", override.Network)
+		}
+		overrides = append(overrides, geoOverride{net: n, geo: override.Geo})
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.overrides = overrides
+	return nil
+}
+
+// Lookup returns the location ip is overridden with. A nil GeoOverrides
+// overrides nothing.
+func (o *GeoOverrides) Lookup(ip net.IP) (*GeoInfo, bool) {
+	if o == nil || ip == nil {
+		return nil, false
+	}
+
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	var found *geoOverride
+	for i, override := range o.overrides {
+		if !override.net.Contains(ip) {
+			continue
+		}
+		if found == nil || prefixLen(override.net) > prefixLen(found.net) {
+			found = &o.overrides[i]
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	geo := found.geo
+	return &geo, true
+}
+
+// parseNetwork parses a CIDR, or an IP as the network of that IP alone.
+func parseNetwork(s string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n
+	}
+	if ip := ParseHostIP(s); ip != nil {
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}
+	}
+	return nil
+}
+
+func prefixLen(n *net.IPNet) int {
+	ones, _ := n.Mask.Size()
+	return ones
+}
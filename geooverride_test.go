@@ -0,0 +1,101 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestGeoOverridesLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.json")
+	data := `[
+		{"network":"198.51.100.0/24","geo":{"country":"Office","country_iso":"DE"}},
+		{"network":"198.51.100.7","geo":{"country":"Desk","country_iso":"FR"}},
+		{"network":"2001:db8::/32","geo":{"country":"VPN","country_iso":"NL"}}
+	]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var overrides GeoOverrides
+	if err := overrides.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"198.51.100.7":    "FR",
+		"198.51.100.8":    "DE",
+		"2001:db8::1":     "NL",
+		"203.0.113.7":     "",
+		"::ffff:10.0.0.1": "",
+	}
+	for ip, want := range tests {
+		got := ""
+		if geo, ok := overrides.Lookup(net.ParseIP(ip)); ok {
+			got = geo.CountryISO
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", ip, got, want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"network":"nope"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := overrides.Load(path); err == nil {
+		t.Error("invalid network accepted")
+	}
+	var none *GeoOverrides
+	if _, ok := none.Lookup(net.ParseIP("198.51.100.7")); ok {
+		t.Error("nil overrides matched")
+	}
+}
+
+func TestEnricherGeoOverrides(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	var lookups atomic.Int32
+	echoIP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		json.NewEncoder(w).Encode(GeoInfo{CountryISO: "US"})
+	}))
+	defer echoIP.Close()
+	config.EchoIPHost = echoIP.URL
+
+	path := filepath.Join(t.TempDir(), "geo.json")
+	if err := os.WriteFile(path, []byte(`[{"network":"198.51.100.7","geo":{"country_iso":"DE"}}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	overrides := &GeoOverrides{}
+	if err := overrides.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryEvents()
+	enricher := NewEnricher(store, nil, nil)
+	enricher.SetGeoOverrides(overrides)
+	enricher.Start()
+	for _, ip := range []string{"198.51.100.7", "198.51.100.8"} {
+		if err := enricher.Submit(context.Background(), Tracking{SiteID: ip}, useragent.UserAgent{}, net.ParseIP(ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enricher.Close()
+
+	countries := make(map[string]string)
+	for _, row := range store.rows {
+		countries[row.trk.SiteID] = row.geo.CountryISO
+	}
+	if countries["198.51.100.7"] != "DE" || countries["198.51.100.8"] != "US" {
+		t.Errorf("got countries %v", countries)
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("got %d lookups, want 1", n)
+	}
+}
@@ -0,0 +1,113 @@
+package tracker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedIdentityPrefix marks encrypted identities, identities stored
+// before encryption was enabled are kept as they are.
+const encryptedIdentityPrefix = "enc1:"
+
+// ErrIdentityKey is returned for identity keys that aren't 32 bytes, base64
+// encoded.
+var ErrIdentityKey = errors.New("identity key must be 32 bytes, base64 encoded")
+
+// IdentityCipher encrypts visitor identities before they are stored. The
+// encryption is deterministic: the nonce is derived from the identity, so an
+// identity always encrypts to the same value and unique visitor counts and
+// deduplication keep working on the encrypted column. The price is that
+// equal identities can be told apart from different ones, never what they
+// are. Changing the key changes every identity, visitors are then counted
+// again.
+type IdentityCipher struct {
+	aead  cipher.AEAD
+	ivKey []byte
+}
+
+// NewIdentityCipher returns a cipher for a 32 byte key. Encryption and nonce
+// derivation use separate keys derived from it.
+func NewIdentityCipher(key []byte) (*IdentityCipher, error) {
+	if len(key) != 32 {
+		return nil, ErrIdentityKey
+	}
+	block, err := aes.NewCipher(deriveKey(key, "identity encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &IdentityCipher{aead: aead, ivKey: deriveKey(key, "identity nonce")}, nil
+}
+
+// LoadIdentityCipher returns the cipher of IDENTITY_KEY, or of the key in
+// IDENTITY_KEY_FILE as written by a KMS or secrets manager. It returns nil
+// when neither is set, identities are then stored in clear.
+func LoadIdentityCipher() (*IdentityCipher, error) {
+	encoded := config.IdentityKey
+	if config.IdentityKeyFile != "" {
+		b, err := os.ReadFile(config.IdentityKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity key: %w", err)
+		}
+		encoded = string(b)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, ErrIdentityKey
+	}
+	return NewIdentityCipher(key)
+}
+
+// Encrypt returns the encrypted identity. Anonymous events keep their empty
+// identity. Identities are always encrypted, even those that look encrypted
+// already: they come from clients, which could otherwise store any value.
+func (c *IdentityCipher) Encrypt(identity string) string {
+	if identity == "" {
+		return identity
+	}
+	mac := hmac.New(sha256.New, c.ivKey)
+	mac.Write([]byte(identity))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(identity), nil)
+	return encryptedIdentityPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// Decrypt returns the identity an encrypted one stands for. Identities
+// stored in clear are returned as they are.
+func (c *IdentityCipher) Decrypt(identity string) (string, error) {
+	encoded, ok := strings.CutPrefix(identity, encryptedIdentityPrefix)
+	if !ok {
+		return identity, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("malformed encrypted identity")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt identity: %w", err)
+	}
+	return string(plain), nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
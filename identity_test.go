@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIdentityCipher(t *testing.T) {
+	c, err := NewIdentityCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := c.Encrypt("visitor-a")
+	if !strings.HasPrefix(a, encryptedIdentityPrefix) || strings.Contains(a, "visitor") {
+		t.Errorf("got %q", a)
+	}
+	if again := c.Encrypt("visitor-a"); again != a {
+		t.Errorf("not deterministic: %q and %q", a, again)
+	}
+	if b := c.Encrypt("visitor-b"); b == a {
+		t.Error("different identities encrypt the same")
+	}
+	if c.Encrypt("") != "" {
+		t.Error("empty identity changed")
+	}
+	if forged := c.Encrypt(a); forged == a {
+		t.Error("an identity that looks encrypted is stored as sent")
+	} else if got, err := c.Decrypt(forged); err != nil || got != a {
+		t.Errorf("Decrypt(%q) = %q, %v", forged, got, err)
+	}
+
+	for identity, want := range map[string]string{a: "visitor-a", "plain": "plain"} {
+		if got, err := c.Decrypt(identity); err != nil || got != want {
+			t.Errorf("Decrypt(%q) = %q, %v", identity, got, err)
+		}
+	}
+
+	other, _ := NewIdentityCipher(bytes.Repeat([]byte{2}, 32))
+	if other.Encrypt("visitor-a") == a {
+		t.Error("different keys encrypt the same")
+	}
+	if _, err := other.Decrypt(a); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	if _, err := c.Decrypt(a[:len(a)-2] + "AA"); err == nil {
+		t.Error("decrypted a tampered identity")
+	}
+}
+
+func TestLoadIdentityCipher(t *testing.T) {
+	defer func(prev Config) { config = prev }(config)
+
+	config.IdentityKey = ""
+	if c, err := LoadIdentityCipher(); c != nil || err != nil {
+		t.Errorf("no key: got %v, %v", c, err)
+	}
+	config.IdentityKey = base64.StdEncoding.EncodeToString([]byte("short"))
+	if _, err := LoadIdentityCipher(); !errors.Is(err, ErrIdentityKey) {
+		t.Errorf("short key: got %v", err)
+	}
+	config.IdentityKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)) + "\n"
+	if c, err := LoadIdentityCipher(); c == nil || err != nil {
+		t.Errorf("valid key: got %v, %v", c, err)
+	}
+}
@@ -0,0 +1,46 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestEventColumns(t *testing.T) {
+	cols := newEventColumns(2)
+	cols.add(qdata{trk: Tracking{SiteID: "a", Action: TrackingData{Event: "/", IsTouchDevice: true, OccuredAt: 20240301}}, ua: useragent.UserAgent{Name: "Chrome"}, geo: &GeoInfo{Country: "France"}})
+	cols.add(qdata{trk: Tracking{SiteID: "b", Action: TrackingData{Event: "/docs", Campaign: "launch", OccuredAt: 20240302}}, geo: &GeoInfo{}})
+
+	values := cols.values()
+	if len(values) != len(insertColumns) {
+		t.Fatalf("got %d columns, want %d", len(values), len(insertColumns))
+	}
+	expected := make(map[string]bool)
+	for _, col := range expectedColumns["events"] {
+		expected[col.name] = true
+	}
+	for i, name := range insertColumns {
+		if !expected[name] {
+			t.Errorf("%s is not an events column", name)
+		}
+		n := 0
+		switch v := values[i].(type) {
+		case []string:
+			n = len(v)
+		case []uint32:
+			n = len(v)
+		case []bool:
+			n = len(v)
+		case []time.Time:
+			n = len(v)
+		}
+		if n != 2 {
+			t.Errorf("%s: got %d values", name, n)
+		}
+	}
+
+	if cols.siteID[1] != "b" || cols.occuredAt[0] != 20240301 || !cols.isTouch[0] || cols.campaign[1] != "launch" || cols.source[0] != "web" {
+		t.Errorf("got %+v", cols)
+	}
+}
@@ -0,0 +1,941 @@
+//go:build integration
+
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// The integration tests run against a real ClickHouse. Point CLICKHOUSE_HOST
+// (and CLICKHOUSE_DB, CLICKHOUSE_USER, CLICKHOUSE_PASSWORD) at a disposable
+// server, or leave it unset to have one started with docker:
+//
+//	go test -tags integration ./...
+
+var testEvents *Events
+
+func TestMain(m *testing.M) {
+	LoadConfig()
+
+	stop := func() {}
+	if config.ClickHouseHost == "" {
+		var err error
+		if stop, err = startClickHouse(); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to start ClickHouse:", err)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startClickHouse runs a throwaway ClickHouse container and waits until it
+// accepts connections.
+func startClickHouse() (stop func(), err error) {
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-p", "127.0.0.1::9000",
+		"-e", "CLICKHOUSE_USER=default",
+		"-e", "CLICKHOUSE_PASSWORD=password",
+		"clickhouse").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker run: %w", err)
+	}
+	id := strings.TrimSpace(string(out))
+	stop = func() { exec.Command("docker", "rm", "-f", id).Run() }
+
+	out, err = exec.Command("docker", "port", id, "9000/tcp").Output()
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("docker port: %w", err)
+	}
+	config.ClickHouseHost = strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	config.ClickHouseDB = "default"
+	config.ClickHouseUser = "default"
+	config.ClickHousePassword = "password"
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		e := &Events{}
+		if err = e.Open(); err == nil {
+			e.DB.Close()
+			return stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("ClickHouse not ready: %w", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// openTestEvents connects once, applies the migrations and truncates the
+// tables the tests write to.
+func openTestEvents(t *testing.T) *Events {
+	t.Helper()
+
+	if testEvents == nil {
+		e := &Events{}
+		if err := e.Open(); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.EnsureTable(); err != nil {
+			t.Fatal(err)
+		}
+		testEvents = e
+	}
+
+	ctx := context.Background()
+	for _, table := range []string{"events", "usage_daily", "events_daily", "site_totals", "audit_log", "site_merges"} {
+		if err := testEvents.DB.Exec(ctx, "TRUNCATE TABLE "+table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return testEvents
+}
+
+// pushEvents sends events through Add and Run and waits for the final flush.
+func pushEvents(t *testing.T, e *Events, events []qdata) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	for _, qd := range events {
+		if err := e.Add(context.Background(), qd.trk, qd.ua, qd.geo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-done
+
+	// Run closed the channel, give the next test a fresh one
+	e.ch = make(chan qdata, 100)
+}
+
+const (
+	chromeUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36"
+	firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0"
+)
+
+func testEvent(day uint32, user, path, referrer, uaString, country string) qdata {
+	return qdata{
+		trk: Tracking{
+			SiteID: "it-site",
+			Action: TrackingData{
+				Type:         "page",
+				Identity:     user,
+				UserAgent:    uaString,
+				Event:        path,
+				Category:     "Page views",
+				Referrer:     referrer,
+				ReferrerHost: ReferrerHost(referrer),
+				OccuredAt:    day,
+			},
+		},
+		ua:  useragent.Parse(uaString),
+		geo: &GeoInfo{Country: country},
+	}
+}
+
+func TestQueryTypes(t *testing.T) {
+	e := openTestEvents(t)
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "https://github.com/a", chromeUA, "Germany"),
+		testEvent(20240301, "u1", "/docs", "", chromeUA, "Germany"),
+		testEvent(20240301, "u2", "/", "https://github.com/b", firefoxUA, "India"),
+		testEvent(20240302, "u3", "/", "https://duckduckgo.com/", chromeUA, "Germany"),
+	})
+
+	tests := []struct {
+		name  string
+		what  QueryType
+		extra string
+		want  []Metric
+	}{
+		{"page views", QueryPageViews, "", []Metric{
+			{OccuredAt: 20240301, Value: "/", Count: 2},
+			{OccuredAt: 20240301, Value: "/docs", Count: 1},
+			{OccuredAt: 20240302, Value: "/", Count: 1},
+		}},
+		{"page view list", QueryPageViewList, "", []Metric{
+			{Value: "/", Count: 3},
+			{Value: "/docs", Count: 1},
+		}},
+		{"unique visitors", QueryUniqueVisitors, "", []Metric{
+			{OccuredAt: 20240301, Value: "u1", Count: 2},
+			{OccuredAt: 20240301, Value: "u2", Count: 1},
+			{OccuredAt: 20240302, Value: "u3", Count: 1},
+		}},
+		{"referrer hosts", QueryReferrerHost, "", []Metric{
+			{Value: "github.com", Count: 2},
+			{Value: "", Count: 1},
+			{Value: "duckduckgo.com", Count: 1},
+		}},
+		{"referrers", QueryReferrer, "github.com", []Metric{
+			{Value: "https://github.com/a", Count: 1},
+			{Value: "https://github.com/b", Count: 1},
+		}},
+		{"browsers", QueryBrowsers, "", []Metric{
+			{Value: "Chrome", Count: 3},
+			{Value: "Firefox", Count: 1},
+		}},
+		{"engines", QueryEngines, "", []Metric{
+			{Value: "Blink", Count: 3},
+			{Value: "Gecko", Count: 1},
+		}},
+		{"oses", QueryOSes, "", []Metric{
+			{Value: "Windows", Count: 3},
+			{Value: "Linux", Count: 1},
+		}},
+		{"countries", QueryCountry, "", []Metric{
+			{Value: "Germany", Count: 3},
+			{Value: "India", Count: 1},
+		}},
+		{"touch", QueryTouch, "", []Metric{
+			{Value: "non-touch", Count: 4},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.GetStats(context.Background(), MetricData{
+				What:   tt.what,
+				SiteID: "it-site",
+				Start:  20240301,
+				End:    20240302,
+				Extra:  tt.extra,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Meta.Rows != len(got.Data) || got.Meta.Source != SourceRaw {
+				t.Errorf("unexpected meta %+v", got.Meta)
+			}
+			assertMetrics(t, got.Data, tt.want)
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	e := openTestEvents(t)
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/", "", chromeUA, ""),
+		testEvent(20240401, "u1", "/", "", chromeUA, ""),
+	})
+
+	got, err := e.Usage(context.Background(), UsageQuery{Start: 20240101, End: 20241231, Monthly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UsageRow{
+		{SiteID: "it-site", Period: "202403", Events: 2},
+		{SiteID: "it-site", Period: "202404", Events: 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestValues(t *testing.T) {
+	e := openTestEvents(t)
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/docs", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/docs/api", "", chromeUA, ""),
+		testEvent(20240301, "u3", "/docs/api", "", chromeUA, ""),
+		testEvent(20240301, "u1", "/pricing", "", chromeUA, ""),
+	})
+
+	got, err := e.Values(context.Background(), ValuesQuery{
+		SiteID: "it-site", Field: "path", Prefix: "/DOC", Start: 20240301, End: 20240301, Limit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/docs/api", "/docs"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	e := openTestEvents(t)
+
+	click := testEvent(20240228, "u3", "signup", "", chromeUA, "")
+	click.trk.Action.Category = "Clicks"
+	pushEvents(t, e, []qdata{
+		click,
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/docs", "", chromeUA, ""),
+	})
+	bot := testEvent(20240302, "bot", "/", "", chromeUA, "")
+	bot.trk.Action.Quality = QualityBot
+	pushEvents(t, e, []qdata{
+		testEvent(20240302, "u1", "/", "", chromeUA, ""),
+		bot,
+	})
+
+	got, err := e.Summary(context.Background(), "it-site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SiteSummary{SiteID: "it-site", Pageviews: 3, Visitors: 2, FirstDay: 20240228}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+}
+
+func TestExportIdentity(t *testing.T) {
+	e := openTestEvents(t)
+
+	other := testEvent(20240302, "u1", "/pricing", "", chromeUA, "")
+	other.trk.SiteID = "it-other"
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/docs", "", chromeUA, ""),
+		other,
+	})
+
+	var got []IdentityEvent
+	err := e.ExportIdentity(context.Background(), "u1", func(ev IdentityEvent) error {
+		got = append(got, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sites := map[string]bool{}
+	for _, ev := range got {
+		sites[ev.SiteID] = true
+	}
+	if len(got) != 2 || !sites["it-site"] || !sites["it-other"] {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	e := openTestEvents(t)
+
+	signup := testEvent(20240301, "u1", "signup", "https://news.example/", chromeUA, "Canada")
+	signup.trk.Action.Type, signup.trk.Action.Category = "event", "Goals"
+	purchase := signup
+	purchase.trk.Action.Event = "purchase"
+	pushEvents(t, e, []qdata{signup, purchase, testEvent(20240301, "u1", "/", "", chromeUA, "")})
+
+	q := EventListQuery{SiteID: "it-site", Event: "signup", Since: time.Now().Add(-time.Hour), Limit: 10}
+	got, err := e.ListEvents(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ReferrerDomain != "news.example" || got[0].Country != "Canada" || got[0].ID == "" {
+		t.Errorf("got %+v", got)
+	}
+	q.Event = ""
+	if got, _ := e.ListEvents(context.Background(), q); len(got) != 2 {
+		t.Errorf("every event: got %+v", got)
+	}
+}
+
+func TestVitals(t *testing.T) {
+	e := openTestEvents(t)
+	if err := e.DB.Exec(context.Background(), "TRUNCATE TABLE web_vitals"); err != nil {
+		t.Fatal(err)
+	}
+
+	var batch []qdata
+	for _, lcp := range []float64{1000, 2000, 3000, 4000} {
+		qd := testEvent(20240301, "u1", "/docs?q=1", "", chromeUA, "")
+		qd.trk.Action.Type, qd.trk.Action.Category = VitalsType, ""
+		qd.trk.Action.Vitals = map[string]float64{"lcp": lcp, "cls": 0.1}
+		batch = append(batch, qd)
+	}
+	pushEvents(t, e, append(batch, testEvent(20240301, "u1", "/", "", chromeUA, "")))
+
+	got, err := e.Vitals(context.Background(), VitalsQuery{SiteID: "it-site", Start: 20240301, End: 20240301, By: "page", Metric: "lcp", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Data) != 1 || got.Data[0].Value != "/docs" || got.Data[0].Samples != 4 || got.Data[0].P75 < 3000 || got.Data[0].P75 > 4000 {
+		t.Errorf("got %+v", got.Data)
+	}
+	var n uint64
+	if err := e.DB.QueryRow(context.Background(), "SELECT count() FROM events WHERE site_id = 'it-site'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("vitals stored as events: %d events, %v", n, err)
+	}
+}
+
+func TestTopErrors(t *testing.T) {
+	e := openTestEvents(t)
+	if err := e.DB.Exec(context.Background(), "TRUNCATE TABLE js_errors"); err != nil {
+		t.Fatal(err)
+	}
+
+	var batch []qdata
+	for _, jsErr := range []JSError{
+		{Hash: "a", Message: "x is undefined", Source: "/app.js", Count: 2},
+		{Hash: "a", Source: "/app.js", Count: 1},
+		{Hash: "b", Source: "/vendor.js", Count: 1},
+	} {
+		qd := testEvent(20240301, "u1", "/", "", chromeUA, "")
+		qd.trk.Action.Type, qd.trk.Action.Category = ErrorType, ""
+		qd.trk.Action.Error = &jsErr
+		batch = append(batch, qd)
+	}
+	pushEvents(t, e, batch)
+
+	got, err := e.TopErrors(context.Background(), ErrorsQuery{SiteID: "it-site", Start: 20240301, End: 20240301, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Data) != 2 || got.Data[0] != (ErrorRow{Hash: "a", Message: "x is undefined", Source: "/app.js", Count: 3, LastSeen: 20240301}) {
+		t.Errorf("got %+v", got.Data)
+	}
+}
+
+func TestFunnel(t *testing.T) {
+	e := openTestEvents(t)
+	// Events inserted together share their timestamp, every step takes 0s
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/pricing", "", chromeUA, ""),
+		testEvent(20240301, "u1", "/signup", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/pricing", "", chromeUA, ""),
+		testEvent(20240301, "u3", "/", "", chromeUA, ""),
+	})
+
+	got, err := e.Funnel(context.Background(), FunnelQuery{SiteID: "it-site", Start: 20240301, End: 20240301, Steps: []FunnelStep{
+		{Event: "/pricing"},
+		{Label: "Signup", Event: "/signup", Category: "Page views"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Visitors != 3 || len(got.Data) != 2 || got.Data[0].Visitors != 2 || got.Data[1].Visitors != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	if step := got.Data[1]; step.Label != "Signup" || step.StepConversion != 0.5 || step.MedianSeconds == nil || *step.MedianSeconds != 0 {
+		t.Errorf("got %+v", step)
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	e := openTestEvents(t)
+	// v1 lasts a minute though its events are inserted together
+	started := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	session := func(qd qdata, id string, at time.Time) qdata {
+		qd.trk.Action.SessionID = id
+		qd.trk.Action.HappenedAt = at
+		return qd
+	}
+	pushEvents(t, e, []qdata{
+		session(testEvent(20240301, "u1", "/", "", chromeUA, ""), "v1", started),
+		session(testEvent(20240301, "u1", "/docs", "", chromeUA, ""), "v1", started.Add(time.Minute)),
+		session(testEvent(20240301, "u2", "/", "", chromeUA, ""), "v2", started),
+		testEvent(20240301, "u3", "/", "", chromeUA, ""),
+	})
+
+	for what, want := range map[QueryType]uint64{QuerySessions: 2, QueryBounceRate: 50, QuerySessionDuration: 30} {
+		got, err := e.GetStats(context.Background(), MetricData{What: what, SiteID: "it-site", Start: 20240301, End: 20240301})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Data) != 1 || got.Data[0] != (Metric{OccuredAt: 20240301, Count: want}) {
+			t.Errorf("%s: got %+v", what, got.Data)
+		}
+	}
+}
+
+func TestFlaggedEvents(t *testing.T) {
+	e := openTestEvents(t)
+
+	bot := testEvent(20240301, "bot", "/", "", chromeUA, "")
+	bot.trk.Action.Quality = QualityBot
+	spam := testEvent(20240301, "u2", "/", "https://spam.example/", chromeUA, "")
+	spam.trk.Action.Quality = QualitySpam
+	soft := testEvent(20240301, "u3", "/", "", chromeUA, "")
+	soft.trk.Action.Quality = QualityTest
+	pushEvents(t, e, []qdata{testEvent(20240301, "u1", "/", "", chromeUA, ""), bot, bot, spam, soft})
+
+	data := MetricData{What: QueryPageViewList, SiteID: "it-site", Start: 20240301, End: 20240301}
+	assertMetrics(t, queryMetrics(t, e, e.GenQuery(data), data), []Metric{{Value: "/", Count: 1}})
+	assertMetrics(t, queryMetrics(t, e, e.GenRollupQuery(data), data), []Metric{{Value: "/", Count: 1}})
+	data.Qualities = []string{QualityOK, QualityBot}
+	assertMetrics(t, queryMetrics(t, e, e.GenQuery(data), data), []Metric{{Value: "/", Count: 3}})
+	data.Qualities = []string{QualityTest}
+	assertMetrics(t, queryMetrics(t, e, e.GenQuery(data), data), []Metric{{Value: "/", Count: 1}})
+}
+
+func TestUpgrade(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+	// Dual-writes start about now, ClickHouse stamps the rows inserted
+	fc := NewFakeClock(time.Now().Add(-upgradeGrace + time.Second))
+	defer SetClock(fc)()
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, "Germany"),
+		testEvent(20240302, "u2", "/docs", "", firefoxUA, "India"),
+	})
+
+	var ddl string
+	if err := e.DB.QueryRow(ctx, "SELECT create_table_query FROM system.tables WHERE database = currentDatabase() AND name = 'events'").Scan(&ddl); err != nil {
+		t.Fatal(err)
+	}
+	_, columns, _ := strings.Cut(ddl, "(")
+	ddl = "CREATE TABLE " + UpgradeTable + " (" + strings.Replace(columns, "`browser_name` String", "`browser_name` LowCardinality(String)", 1)
+	if _, err := e.StartUpgrade(ctx, ddl); err != nil {
+		t.Fatal(err)
+	}
+	defer e.DropUpgrade(ctx, 0)
+	if err := e.BackfillUpgrade(ctx, nil); !errors.Is(err, ErrInvalid) {
+		t.Errorf("backfill before dual-writes: got %v", err)
+	}
+
+	fc.Advance(upgradeGrace)
+	time.Sleep(2 * time.Second)
+	e.watchUpgrade(ctx)
+	pushEvents(t, e, []qdata{testEvent(20240302, "u3", "/", "", chromeUA, "")})
+	if err := e.BackfillUpgrade(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mismatches, err := e.VerifyUpgrade(ctx); err != nil || len(mismatches) != 0 {
+		t.Fatalf("got %v, %v", mismatches, err)
+	}
+
+	// Deleted sites don't come back with the swap
+	gone := testEvent(20240302, "u4", "/", "", chromeUA, "")
+	gone.trk.SiteID = "it-gone"
+	pushEvents(t, e, []qdata{gone})
+	if _, err := e.DeleteSite(ctx, "it-gone"); err != nil {
+		t.Fatal(err)
+	}
+	var left uint64
+	if err := e.DB.QueryRow(ctx, "SELECT count() FROM "+UpgradeTable+" WHERE site_id = 'it-gone'").Scan(&left); err != nil || left != 0 {
+		t.Errorf("deleted site left %d events in %s, %v", left, UpgradeTable, err)
+	}
+
+	if err := e.SwapUpgrade(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var typ string
+	if err := e.DB.QueryRow(ctx, "SELECT type FROM system.columns WHERE database = currentDatabase() AND table = 'events' AND name = 'browser_name'").Scan(&typ); err != nil || typ != "LowCardinality(String)" {
+		t.Errorf("browser_name is %q, %v", typ, err)
+	}
+	data := MetricData{What: QueryBrowsers, SiteID: "it-site", Start: 20240301, End: 20240302}
+	assertMetrics(t, queryMetrics(t, e, e.GenQuery(data), data), []Metric{{Value: "Chrome", Count: 2}, {Value: "Firefox", Count: 1}})
+}
+
+func TestAudit(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	err := e.AddAudit(ctx, []AuditEntry{
+		{At: at, Actor: "api-key", RemoteIP: "203.0.113.7", Method: "POST", Path: "/stats", SiteID: "it-site", Query: `{"What":"pages"}`, Status: 200},
+		{At: at.Add(time.Minute), Actor: "anonymous", Method: "GET", Path: "/usage", Status: 401},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := e.Audit(ctx, AuditQuery{Start: at.Add(-time.Hour), End: at.Add(time.Hour), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Path != "/usage" || got[1].Query != `{"What":"pages"}` || !got[1].At.Equal(at) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	e := openTestEvents(t)
+
+	session := func(qd qdata, id string) qdata {
+		qd.trk.Action.SessionID = id
+		return qd
+	}
+	// u1 has two sessions on the 1st, u3 none
+	pushEvents(t, e, []qdata{
+		session(testEvent(20240301, "u1", "/", "", chromeUA, ""), "v1"),
+		session(testEvent(20240301, "u1", "/docs", "", chromeUA, ""), "v1"),
+		session(testEvent(20240301, "u1", "/", "", chromeUA, ""), "v2"),
+		session(testEvent(20240301, "u2", "/", "", chromeUA, ""), "v3"),
+		testEvent(20240301, "u3", "/", "", chromeUA, ""),
+		session(testEvent(20240303, "u1", "/", "", chromeUA, ""), "v4"),
+	})
+
+	got, err := e.TimeSeries(context.Background(), TimeSeriesQuery{SiteID: "it-site", Start: 20240301, End: 20240303})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TimeSeriesPoint{
+		{Day: 20240301, Visitors: 3, Pageviews: 5, Sessions: 3, BounceRate: 2.0 / 3},
+		{Day: 20240302},
+		{Day: 20240303, Visitors: 1, Pageviews: 1, Sessions: 1, BounceRate: 1},
+	}
+	if fmt.Sprint(got.Data) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got.Data, want)
+	}
+}
+
+func TestTouchFilter(t *testing.T) {
+	e := openTestEvents(t)
+
+	touch := testEvent(20240310, "t1", "/", "", chromeUA, "France")
+	touch.trk.Action.IsTouchDevice = true
+	pushEvents(t, e, []qdata{touch, testEvent(20240310, "t2", "/pricing", "", chromeUA, "France")})
+
+	for filter, want := range map[string][]Metric{
+		"touch":     {{OccuredAt: 20240310, Value: "/", Count: 1}},
+		"non-touch": {{OccuredAt: 20240310, Value: "/pricing", Count: 1}},
+	} {
+		data := MetricData{What: QueryPageViews, SiteID: "it-site", Start: 20240310, End: 20240310, Touch: filter}
+		assertMetrics(t, queryMetrics(t, e, e.GenQuery(data), data), want)
+		assertMetrics(t, queryMetrics(t, e, e.GenRollupQuery(data), data), want)
+	}
+}
+
+func TestDarkTraffic(t *testing.T) {
+	e := openTestEvents(t)
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240320, "d1", "/", "", chromeUA, "France"),
+		testEvent(20240320, "d2", "/?utm_source=x", "", chromeUA, "France"),
+		testEvent(20240320, "d3", "/guide#intro", "", chromeUA, "France"),
+		testEvent(20240320, "d4", "/guide", "https://github.com/a", chromeUA, "France"),
+	})
+
+	got, err := e.DarkTraffic(context.Background(), DarkTrafficQuery{SiteID: "it-site", Start: 20240320, End: 20240320})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Direct != 2 || got.Dark != 1 || len(got.Pages) != 1 || got.Pages[0] != (Metric{Value: "/guide", Count: 1}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestSchemaDrift(t *testing.T) {
+	e := openTestEvents(t)
+
+	drift, err := e.SchemaDrift(context.Background())
+	if err != nil || len(drift) != 0 {
+		t.Errorf("got %v, %v", drift, err)
+	}
+}
+
+func TestDoctorChecks(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	for _, f := range []Finding{e.checkSchema(ctx), e.checkSchemaDrift(ctx), e.checkClock(ctx)} {
+		if f.Status != FindingOK {
+			t.Errorf("got %+v", f)
+		}
+	}
+}
+
+func TestMergeSite(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/docs", "", chromeUA, ""),
+		testEvent(20240302, "u1", "/", "", chromeUA, ""),
+	})
+	// The first day's raw events expired, only its rollups are left
+	if err := e.DB.Exec(ctx, "ALTER TABLE events DELETE WHERE occured_at < 20240302 SETTINGS mutations_sync = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.DB.Exec(ctx, "ALTER TABLE web_vitals DELETE WHERE site_id = 'it-site' SETTINGS mutations_sync = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DB.Exec(ctx, "INSERT INTO web_vitals VALUES ('it-site', 20240302, '/', 'desktop', 'LCP', 1200)"); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := e.MergeSite(ctx, "it-site", "it-new")
+	if err != nil || moved != 1 {
+		t.Fatalf("got %d, %v", moved, err)
+	}
+	var vitals, left, merges uint64
+	if err := e.DB.QueryRow(ctx, "SELECT countIf(site_id = 'it-new'), countIf(site_id = 'it-site') FROM web_vitals").Scan(&vitals, &left); err != nil || vitals != 1 || left != 0 {
+		t.Errorf("got web vitals %d moved, %d left, %v", vitals, left, err)
+	}
+	if err := e.DB.QueryRow(ctx, "SELECT count() FROM site_merges").Scan(&merges); err != nil || merges != 0 {
+		t.Errorf("got %d merge steps left, %v", merges, err)
+	}
+
+	data := MetricData{What: QueryPageViewList, SiteID: "it-new", Start: 20240301, End: 20240302}
+	assertMetrics(t, queryMetrics(t, e, e.GenRollupQuery(data), data), []Metric{{Value: "/", Count: 2}, {Value: "/docs", Count: 1}})
+	data.SiteID = "it-site"
+	assertMetrics(t, queryMetrics(t, e, e.GenRollupQuery(data), data), []Metric{})
+
+	usage, err := e.Usage(ctx, UsageQuery{Start: 20240301, End: 20240302})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for _, row := range usage {
+		if row.SiteID != "it-new" {
+			t.Errorf("usage left for %s", row.SiteID)
+		}
+		total += row.Events
+	}
+	if total != 3 {
+		t.Errorf("got usage %d, want 3", total)
+	}
+
+	summary, err := e.Summary(ctx, "it-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pageviews != 3 || summary.Visitors != 2 || summary.FirstDay != 20240301 {
+		t.Errorf("got summary %+v", summary)
+	}
+}
+
+func TestMergeSiteResume(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240302, "u2", "/docs", "", chromeUA, ""),
+	})
+	// A merge failed deleting from's rows after copying every table
+	if err := e.DB.Exec(ctx, "INSERT INTO events SELECT * REPLACE ('it-new' AS site_id) FROM events WHERE site_id = 'it-site'"); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []string{"events_daily", "usage_daily", "site_totals", "web_vitals", "js_errors", "events"} {
+		if err := e.DB.Exec(ctx, "INSERT INTO site_merges VALUES ('it-site', 'it-new', ?, 2, 20240301)", step); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved, err := e.MergeSite(ctx, "it-site", "it-new")
+	if err != nil || moved != 2 {
+		t.Fatalf("got %d, %v", moved, err)
+	}
+	summary, err := e.Summary(ctx, "it-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pageviews != 2 {
+		t.Errorf("got %d page views, want 2", summary.Pageviews)
+	}
+	var left uint64
+	if err := e.DB.QueryRow(ctx, "SELECT count() FROM events WHERE site_id = 'it-site'").Scan(&left); err != nil || left != 0 {
+		t.Errorf("got %d events left, %v", left, err)
+	}
+}
+
+func TestDeleteSite(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	other := testEvent(20240301, "u9", "/", "", chromeUA, "")
+	other.trk.SiteID = "it-other"
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240302, "u2", "/docs", "", chromeUA, ""),
+		other,
+	})
+
+	deleted, err := e.DeleteSite(ctx, "it-site")
+	if err != nil || deleted != 2 {
+		t.Fatalf("got %d, %v", deleted, err)
+	}
+	for _, table := range siteTables {
+		var left, others uint64
+		if err := e.DB.QueryRow(ctx, fmt.Sprintf("SELECT countIf(site_id = 'it-site'), countIf(site_id = 'it-other') FROM %s", table)).Scan(&left, &others); err != nil {
+			t.Fatal(err)
+		}
+		if left != 0 {
+			t.Errorf("%s: %d rows left", table, left)
+		}
+		if others == 0 && table != "web_vitals" && table != "js_errors" {
+			t.Errorf("%s: rows of the other site deleted", table)
+		}
+	}
+}
+
+func TestMultiSiteStats(t *testing.T) {
+	e := openTestEvents(t)
+
+	other := testEvent(20240301, "u9", "/", "", chromeUA, "")
+	other.trk.SiteID = "it-other"
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "", chromeUA, ""),
+		testEvent(20240301, "u2", "/docs", "", chromeUA, ""),
+		other,
+	})
+
+	got, err := e.GetStats(context.Background(), MetricData{
+		What:    QueryPageViewList,
+		SiteIDs: []string{"it-site", "it-other"},
+		Start:   20240301,
+		End:     20240301,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMetrics(t, got.Data, []Metric{{Value: "/", Count: 2}, {Value: "/docs", Count: 1}})
+	assertMetrics(t, got.BySite["it-site"], []Metric{{Value: "/", Count: 1}, {Value: "/docs", Count: 1}})
+	assertMetrics(t, got.BySite["it-other"], []Metric{{Value: "/", Count: 1}})
+}
+
+func TestRollupsMatchRaw(t *testing.T) {
+	e := openTestEvents(t)
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "https://github.com/a", chromeUA, "Germany"),
+		testEvent(20240301, "u2", "/docs", "https://github.com/a", firefoxUA, "India"),
+		testEvent(20240302, "u1", "/", "", chromeUA, "Germany"),
+	})
+
+	for what, def := range metricDefs {
+		if !rollupDimensions[def.field] {
+			continue
+		}
+		data := MetricData{What: what, SiteID: "it-site", Start: 20240301, End: 20240302, Extra: "github.com"}
+		raw := queryMetrics(t, e, e.GenQuery(data), data)
+		rolled := queryMetrics(t, e, e.GenRollupQuery(data), data)
+		assertMetrics(t, rolled, raw)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	mismatched := testEvent(20240301, "u2", "/docs", "https://github.com/a", firefoxUA, "India")
+	mismatched.trk.Action.ReferrerHost = "gitlab.com"
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "https://github.com/a", chromeUA, "Germany"),
+		mismatched,
+	})
+	if err := e.DB.Exec(ctx, "ALTER TABLE events_daily DELETE WHERE site_id = 'it-site' AND day = 20240301 AND value = '/docs' SETTINGS mutations_sync = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := e.CheckIntegrity(ctx, 20240301)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IntegrityIssue{
+		{Check: CheckReferrerDomain, SiteID: "it-site", Day: 20240301, Count: 1},
+		{Check: CheckRollups, SiteID: "it-site", Day: 20240301, Count: 1, Expected: 2},
+	}
+	if fmt.Sprint(issues) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", issues, want)
+	}
+}
+
+func TestRebuildRollups(t *testing.T) {
+	e := openTestEvents(t)
+	ctx := context.Background()
+
+	pushEvents(t, e, []qdata{
+		testEvent(20240301, "u1", "/", "https://github.com/a", chromeUA, "Germany"),
+		testEvent(20240301, "u2", "/docs", "", firefoxUA, "India"),
+		testEvent(20240302, "u1", "/", "", chromeUA, "Germany"),
+	})
+	// A backfill wrote raw events without going through the views
+	if err := e.DB.Exec(ctx, "ALTER TABLE events_daily DELETE WHERE site_id = 'it-site' AND day = 20240301 SETTINGS mutations_sync = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.RebuildRollups(ctx, "it-site", 20240301, 20240302); err != nil {
+		t.Fatal(err)
+	}
+	for _, what := range []QueryType{QueryPageViewList, QueryBrowsers, QueryCountry} {
+		data := MetricData{What: what, SiteID: "it-site", Start: 20240301, End: 20240302}
+		assertMetrics(t, queryMetrics(t, e, e.GenRollupQuery(data), data), queryMetrics(t, e, e.GenQuery(data), data))
+	}
+
+	usage, err := e.Usage(ctx, UsageQuery{SiteID: "it-site", Start: 20240301, End: 20240302})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for _, row := range usage {
+		total += row.Events
+	}
+	if total != 3 {
+		t.Errorf("got usage %d, want 3", total)
+	}
+}
+
+func queryMetrics(t *testing.T, e *Events, qry string, data MetricData) []Metric {
+	t.Helper()
+
+	rows, err := e.DB.Query(context.Background(), qry, data.args()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var metrics []Metric
+	for rows.Next() {
+		var m Metric
+		var site string
+		if err := rows.Scan(&m.OccuredAt, &m.Value, &m.Count, &site); err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// assertMetrics compares ignoring order, ties in COUNT(*) ordering aren't stable.
+func assertMetrics(t *testing.T, got, want []Metric) {
+	t.Helper()
+
+	counts := make(map[Metric]int)
+	for _, m := range want {
+		counts[m]++
+	}
+	for _, m := range got {
+		counts[m]--
+	}
+	for _, n := range counts {
+		if n != 0 {
+			t.Errorf("got %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+// TestGenQueryExplain has ClickHouse parse and analyze every generated query.
+func TestGenQueryExplain(t *testing.T) {
+	e := openTestEvents(t)
+
+	for _, c := range queryCases() {
+		t.Run(c.name, func(t *testing.T) {
+			rows, err := e.DB.Query(context.Background(), "EXPLAIN "+c.query(e), c.data.args()...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows.Close()
+		})
+	}
+}
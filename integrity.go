@@ -0,0 +1,259 @@
+package tracker
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HookIntegrityFailed is the type of the events sent to webhooks for every
+// site failing an integrity check.
+const HookIntegrityFailed = "integrity.failed"
+
+// Integrity checks, the Check of IntegrityIssue.
+const (
+	// CheckFutureEvents finds events of days that haven't started anywhere
+	// yet: later than tomorrow in UTC, since sites may count days in time
+	// zones ahead of it.
+	CheckFutureEvents = "future_events"
+	// CheckReferrerDomain finds events whose referrer_domain isn't the host
+	// of their referrer URL. Referrers dropped by data minimization keep
+	// their domain and aren't checked.
+	CheckReferrerDomain = "referrer_domain"
+	// CheckRollups finds sites whose page views rolled up for the day differ
+	// from their raw page views by more than rollupTolerance.
+	CheckRollups = "rollups"
+)
+
+// rollupTolerance is the fraction of a day's raw page views its rollups may
+// differ by.
+const rollupTolerance = 0.01
+
+// integrityStats is published on /debug/vars: checks run and failed, and
+// the issues of the last one per check, events or sites for rollups.
+var integrityStats = expvar.NewMap("integrity")
+
+// IntegrityIssue is a site failing an integrity check of a day. Count is the
+// events failing it, or the page views rolled up for CheckRollups with the
+// raw page views in Expected.
+type IntegrityIssue struct {
+	Check    string `json:"check"`
+	SiteID   string `json:"siteId"`
+	Day      uint32 `json:"day"`
+	Count    uint64 `json:"count"`
+	Expected uint64 `json:"expected,omitempty"`
+}
+
+// IntegrityStore is implemented by stores that can check the invariants of
+// their events.
+type IntegrityStore interface {
+	// CheckIntegrity returns the issues of the events of day, and the
+	// events from after tomorrow whatever day it is.
+	CheckIntegrity(ctx context.Context, day uint32) ([]IntegrityIssue, error)
+}
+
+func (e *Events) CheckIntegrity(ctx context.Context, day uint32) ([]IntegrityIssue, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var issues []IntegrityIssue
+	counts := func(check, qry string, arg uint32) error {
+		rows, err := e.DB.Query(queryCtx, qry, arg)
+		if err != nil {
+			return fmt.Errorf("%s check failed: %w", check, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			issue := IntegrityIssue{Check: check, Day: day}
+			if err := rows.Scan(&issue.SiteID, &issue.Count); err != nil {
+				return fmt.Errorf("failed scanning %s row: %w", check, err)
+			}
+			issues = append(issues, issue)
+		}
+		return rows.Err()
+	}
+
+	tomorrow := AddDays(TimeToInt(clock.Now().UTC()), 1)
+	if err := counts(CheckFutureEvents, `
+		SELECT site_id, count()
+		FROM events
+		WHERE occured_at > $1
+		GROUP BY site_id
+		ORDER BY site_id;
+	`, tomorrow); err != nil {
+		return nil, err
+	}
+	// domain() of URLs without a scheme is their first segment, where
+	// ReferrerHost finds none
+	if err := counts(CheckReferrerDomain, `
+		SELECT site_id, count()
+		FROM events
+		WHERE occured_at = $1
+		AND match(referrer, '^(?i)https?://')
+		AND referrer_domain != lower(domain(referrer))
+		GROUP BY site_id
+		ORDER BY site_id;
+	`, day); err != nil {
+		return nil, err
+	}
+
+	// Every page view is rolled up once under the event dimension
+	rows, err := e.DB.Query(queryCtx, `
+		SELECT site_id, raw, rolled
+		FROM (
+			SELECT site_id, count() AS raw
+			FROM events
+			WHERE occured_at = $1
+			AND category = 'Page views'
+			AND quality = 'ok'
+			GROUP BY site_id
+		)
+		FULL OUTER JOIN (
+			SELECT site_id, sum(events) AS rolled
+			FROM events_daily
+			WHERE day = $1
+			AND dimension = 'event'
+			GROUP BY site_id
+		) USING site_id
+		ORDER BY site_id;
+	`, day)
+	if err != nil {
+		return nil, fmt.Errorf("%s check failed: %w", CheckRollups, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var siteID string
+		var raw, rolled uint64
+		if err := rows.Scan(&siteID, &raw, &rolled); err != nil {
+			return nil, fmt.Errorf("failed scanning %s row: %w", CheckRollups, err)
+		}
+		if rollupsDiffer(raw, rolled) {
+			issues = append(issues, IntegrityIssue{Check: CheckRollups, SiteID: siteID, Day: day, Count: rolled, Expected: raw})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s rows: %w", CheckRollups, err)
+	}
+	return issues, nil
+}
+
+// rollupsDiffer reports whether rolled up page views are off from the raw
+// ones by more than rollupTolerance.
+func rollupsDiffer(raw, rolled uint64) bool {
+	return math.Abs(float64(rolled)-float64(raw)) > rollupTolerance*float64(raw)
+}
+
+// CheckIntegrity runs the checks of raw events, the memory store has no
+// rollups.
+func (m *MemoryEvents) CheckIntegrity(ctx context.Context, day uint32) ([]IntegrityIssue, error) {
+	tomorrow := AddDays(TimeToInt(clock.Now().UTC()), 1)
+	type key struct{ check, site string }
+	counts := make(map[key]uint64)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		action := row.trk.Action
+		if action.OccuredAt > tomorrow {
+			counts[key{CheckFutureEvents, row.trk.SiteID}]++
+		}
+		scheme, _, _ := strings.Cut(strings.ToLower(action.Referrer), "://")
+		if action.OccuredAt == day && (scheme == "http" || scheme == "https") && action.ReferrerHost != ReferrerHost(action.Referrer) {
+			counts[key{CheckReferrerDomain, row.trk.SiteID}]++
+		}
+	}
+	m.lock.RUnlock()
+
+	var issues []IntegrityIssue
+	for k, n := range counts {
+		issues = append(issues, IntegrityIssue{Check: k.check, SiteID: k.site, Day: day, Count: n})
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Check != issues[j].Check {
+			return issues[i].Check < issues[j].Check
+		}
+		return issues[i].SiteID < issues[j].SiteID
+	})
+	return issues, nil
+}
+
+// IntegrityChecker checks the invariants of the events once a day, on the
+// day that just ended, and reports the issues found on /debug/vars, in the
+// logs and to webhooks. A check that fails is tried again at the next tick.
+type IntegrityChecker struct {
+	store   IntegrityStore
+	hooks   *Webhooks
+	checked uint32
+	log     *slog.Logger
+}
+
+// NewIntegrityChecker returns a checker of store firing integrity.failed
+// events to hooks, which may be nil.
+func NewIntegrityChecker(store IntegrityStore, hooks *Webhooks) *IntegrityChecker {
+	return &IntegrityChecker{
+		store: store,
+		hooks: hooks,
+		log:   slog.Default().With(slog.String("component", "IntegrityChecker")),
+	}
+}
+
+// Run checks yesterday every interval, unless it was already, until ctx is
+// done.
+func (c *IntegrityChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		day := AddDays(TimeToInt(clock.Now().UTC()), -1)
+		if c.checked < day {
+			if _, err := c.Check(ctx, day); err != nil {
+				if ctx.Err() == nil {
+					c.log.Error("Integrity check failed", slog.Any("error", err))
+				}
+			} else {
+				c.checked = day
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check checks day, reports its issues and returns them.
+func (c *IntegrityChecker) Check(ctx context.Context, day uint32) ([]IntegrityIssue, error) {
+	integrityStats.Add("runs", 1)
+	issues, err := c.store.CheckIntegrity(ctx, day)
+	if err != nil {
+		integrityStats.Add("failures", 1)
+		return nil, err
+	}
+
+	found := map[string]int64{CheckFutureEvents: 0, CheckReferrerDomain: 0, CheckRollups: 0}
+	for _, issue := range issues {
+		if issue.Check == CheckRollups {
+			found[issue.Check]++
+		} else {
+			found[issue.Check] += int64(issue.Count)
+		}
+		c.log.Warn("Integrity check found an issue", slog.String("check", issue.Check), slog.String("site", issue.SiteID),
+			slog.Int("day", int(issue.Day)), slog.Uint64("count", issue.Count), slog.Uint64("expected", issue.Expected))
+		c.hooks.Fire(HookIntegrityFailed, issue.SiteID, issue)
+	}
+	for check, n := range found {
+		v := new(expvar.Int)
+		v.Set(n)
+		integrityStats.Set(check, v)
+	}
+	v := new(expvar.Int)
+	v.Set(int64(day))
+	integrityStats.Set("last_day", v)
+	c.log.Info("Checked integrity", slog.Int("day", int(day)), slog.Int("issues", len(issues)))
+	return issues, nil
+}
@@ -0,0 +1,97 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestIntegrityChecker(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	var (
+		lock     sync.Mutex
+		received []HookEvent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev HookEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		lock.Lock()
+		received = append(received, ev)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+	prev := config
+	defer func() { config = prev }()
+	config.WebhookURLs = []string{srv.URL}
+
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	add := func(site string, day uint32, referrer, host string) {
+		trk := Tracking{SiteID: site, Action: TrackingData{Identity: "u", Event: "/", Category: PageviewCategory, Referrer: referrer, ReferrerHost: host, OccuredAt: day}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("a", 20240301, "https://News.example.com/a", "news.example.com")
+	add("a", 20240301, "https://news.example.com/a", "")
+	add("a", 20240301, "", "news.example.com")
+	add("a", 20240301, "android-app://com.example", "")
+	add("a", 20240229, "https://news.example.com/a", "other.example.com")
+	add("b", 20240303, "", "")
+	add("b", 20240305, "", "")
+
+	hooks := NewWebhooks()
+	issues, err := NewIntegrityChecker(m, hooks).Check(ctx, 20240301)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IntegrityIssue{
+		{Check: CheckFutureEvents, SiteID: "b", Day: 20240301, Count: 1},
+		{Check: CheckReferrerDomain, SiteID: "a", Day: 20240301, Count: 1},
+	}
+	if len(issues) != len(want) || issues[0] != want[0] || issues[1] != want[1] {
+		t.Errorf("got %+v, want %+v", issues, want)
+	}
+	if got := integrityStats.Get(CheckReferrerDomain).String(); got != "1" {
+		t.Errorf("got %s referrer issues published", got)
+	}
+	if got := integrityStats.Get("last_day").String(); got != "20240301" {
+		t.Errorf("got last day %s", got)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	hooks.Run(cctx)
+	hooks.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 2 || received[0].Type != HookIntegrityFailed || received[0].SiteID != "b" {
+		t.Errorf("got events %+v", received)
+	}
+}
+
+func TestRollupsDiffer(t *testing.T) {
+	for _, c := range []struct {
+		raw, rolled uint64
+		differ      bool
+	}{
+		{0, 0, false},
+		{1000, 1000, false},
+		{1000, 1010, false},
+		{1000, 989, true},
+		{0, 1, true},
+		{50, 49, true},
+	} {
+		if got := rollupsDiffer(c.raw, c.rolled); got != c.differ {
+			t.Errorf("%d raw, %d rolled up: got %v", c.raw, c.rolled, got)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobStats is published on /debug/vars.
+var jobStats = expvar.NewMap("jobs")
+
+// ErrTooManyJobs is returned creating a job while maxUnfinishedJobs are
+// queued or running.
+var ErrTooManyJobs = errors.New("too many unfinished jobs")
+
+const maxUnfinishedJobs = 20
+
+// JobExport is the kind of jobs exporting the raw events of a site between
+// two days, as newline delimited IdentityEvent JSON.
+const JobExport = "export"
+
+type JobState string
+
+const (
+	JobQueued  JobState = "queued"
+	JobRunning JobState = "running"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+)
+
+// Job is a long running export. It goes through its days in order, Progress
+// is the fraction of them done, and its result can be read once done.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	SiteID     string     `json:"siteId"`
+	Start      uint32     `json:"start"`
+	End        uint32     `json:"end"`
+	State      JobState   `json:"state"`
+	Progress   float64    `json:"progress"`
+	DaysDone   int        `json:"daysDone"`
+	Events     uint64     `json:"events"`
+	Bytes      int64      `json:"bytes"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+func (j Job) finished() bool {
+	return j.State == JobDone || j.State == JobFailed
+}
+
+func (j Job) validate() error {
+	if j.Kind != JobExport {
+		return fmt.Errorf("%w: unknown job kind %q", ErrInvalid, j.Kind)
+	}
+	_, startErr := ParseDay(j.Start)
+	_, endErr := ParseDay(j.End)
+	if j.SiteID == "" || startErr != nil || endErr != nil || j.End < j.Start {
+		return fmt.Errorf("%w: siteId, start and end are required", ErrInvalid)
+	}
+	return nil
+}
+
+// Jobs runs export jobs one at a time in the background, so exporting a
+// year of raw events never ties up a request. Every job is written to
+// <id>.json in a directory and its result to <id>.ndjson next to it, both
+// kept across restarts: jobs interrupted by a restart resume at the first
+// day they hadn't finished.
+type Jobs struct {
+	lock    sync.Mutex
+	dir     string
+	store   SiteExporter
+	jobs    map[string]*Job
+	pending []string
+	wake    chan struct{}
+	log     *slog.Logger
+}
+
+// NewJobs returns jobs exporting from store, kept in dir.
+func NewJobs(store SiteExporter, dir string) *Jobs {
+	return &Jobs{
+		dir:   dir,
+		store: store,
+		jobs:  make(map[string]*Job),
+		wake:  make(chan struct{}, 1),
+		log:   slog.Default().With(slog.String("component", "Jobs")),
+	}
+}
+
+// Load reads the jobs of the directory, creating it when missing. Unfinished
+// jobs are queued again in the order they were created.
+func (j *Jobs) Load() error {
+	if err := os.MkdirAll(j.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(j.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, path := range paths {
+		var job Job
+		if err := readJSONFile(path, &job); err != nil {
+			return err
+		}
+		if job.ID == "" {
+			continue
+		}
+		if job.State == JobRunning {
+			job.State = JobQueued
+		}
+		j.jobs[job.ID] = &job
+		if job.State == JobQueued {
+			j.pending = append(j.pending, job.ID)
+		}
+	}
+	sort.Slice(j.pending, func(a, b int) bool {
+		return j.jobs[j.pending[a]].CreatedAt.Before(j.jobs[j.pending[b]].CreatedAt)
+	})
+	j.pruneLocked()
+	return nil
+}
+
+// Create queues a job of spec's kind, site and days.
+func (j *Jobs) Create(spec Job) (Job, error) {
+	if err := spec.validate(); err != nil {
+		return Job{}, err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	unfinished := 0
+	for _, job := range j.jobs {
+		if !job.finished() {
+			unfinished++
+		}
+	}
+	if unfinished >= maxUnfinishedJobs {
+		return Job{}, ErrTooManyJobs
+	}
+
+	job := &Job{
+		ID:        newSavedID(),
+		Kind:      spec.Kind,
+		SiteID:    spec.SiteID,
+		Start:     spec.Start,
+		End:       spec.End,
+		State:     JobQueued,
+		CreatedAt: clock.Now().UTC(),
+	}
+	if err := j.saveLocked(job); err != nil {
+		return Job{}, err
+	}
+	j.jobs[job.ID] = job
+	j.pending = append(j.pending, job.ID)
+	jobStats.Add("created", 1)
+
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+	return *job, nil
+}
+
+// Get returns the job id.
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Result opens the result of the job id, ErrNotFound until it is done.
+func (j *Jobs) Result(id string) (*os.File, error) {
+	job, ok := j.Get(id)
+	if !ok || job.State != JobDone {
+		return nil, ErrNotFound
+	}
+	return os.Open(j.resultPath(id))
+}
+
+// Run runs the queued jobs until ctx is done. A job interrupted then is
+// left running on disk, and resumed by the next Load.
+func (j *Jobs) Run(ctx context.Context) {
+	ticker := clock.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		j.lock.Lock()
+		j.pruneLocked()
+		var next *Job
+		if len(j.pending) > 0 {
+			next = j.jobs[j.pending[0]]
+			j.pending = j.pending[1:]
+		}
+		j.lock.Unlock()
+
+		if next != nil {
+			j.run(ctx, next)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.wake:
+		case <-ticker.C():
+		}
+	}
+}
+
+// run exports the days of job it hasn't done, appending to its result.
+// After each day the result is synced before the job records it, so a
+// resumed job first drops whatever was written past its last day.
+func (j *Jobs) run(ctx context.Context, job *Job) {
+	log := j.log.With(slog.String("job", job.ID), slog.String("site", job.SiteID))
+
+	j.lock.Lock()
+	job.State = JobRunning
+	err := j.saveLocked(job)
+	offset := job.Bytes
+	day := AddDays(job.Start, job.DaysDone)
+	j.lock.Unlock()
+	if err != nil {
+		j.fail(job, err)
+		return
+	}
+
+	f, err := os.OpenFile(j.resultPath(job.ID), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		j.fail(job, err)
+		return
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		j.fail(job, err)
+		return
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		j.fail(job, err)
+		return
+	}
+
+	days := DaysBetween(job.Start, job.End) + 1
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for ; day <= job.End; day = AddDays(day, 1) {
+		var events uint64
+		err := j.store.ExportSite(ctx, job.SiteID, day, func(ev IdentityEvent) error {
+			events++
+			return enc.Encode(ev)
+		})
+		if err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if err == nil {
+			offset, err = f.Seek(0, io.SeekCurrent)
+		}
+		if ctx.Err() != nil {
+			log.Info("Export job interrupted", slog.Uint64("day", uint64(day)))
+			return
+		}
+		if err != nil {
+			j.fail(job, err)
+			return
+		}
+
+		j.lock.Lock()
+		job.DaysDone++
+		job.Events += events
+		job.Bytes = offset
+		job.Progress = float64(job.DaysDone) / float64(days)
+		err = j.saveLocked(job)
+		j.lock.Unlock()
+		if err != nil {
+			j.fail(job, err)
+			return
+		}
+	}
+
+	j.lock.Lock()
+	job.State = JobDone
+	finished := clock.Now().UTC()
+	job.FinishedAt = &finished
+	err = j.saveLocked(job)
+	j.lock.Unlock()
+	if err != nil {
+		log.Error("Failed to save export job", slog.Any("error", err))
+	}
+	jobStats.Add("done", 1)
+	log.Info("Export job done", slog.Uint64("events", job.Events), slog.Int64("bytes", offset))
+}
+
+func (j *Jobs) fail(job *Job, err error) {
+	j.log.Error("Export job failed", slog.String("job", job.ID), slog.Any("error", err))
+	jobStats.Add("failed", 1)
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	job.State = JobFailed
+	job.Error = err.Error()
+	finished := clock.Now().UTC()
+	job.FinishedAt = &finished
+	if err := j.saveLocked(job); err != nil {
+		j.log.Error("Failed to save export job", slog.String("job", job.ID), slog.Any("error", err))
+	}
+}
+
+// pruneLocked deletes the jobs finished more than JobRetention ago and
+// their results.
+func (j *Jobs) pruneLocked() {
+	if config.JobRetention <= 0 {
+		return
+	}
+	cutoff := clock.Now().Add(-config.JobRetention)
+	for id, job := range j.jobs {
+		if !job.finished() || job.FinishedAt == nil || job.FinishedAt.After(cutoff) {
+			continue
+		}
+		for _, path := range []string{j.resultPath(id), j.jobPath(id)} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				j.log.Error("Failed to delete expired job", slog.String("job", id), slog.Any("error", err))
+			}
+		}
+		delete(j.jobs, id)
+		jobStats.Add("expired", 1)
+	}
+}
+
+func (j *Jobs) saveLocked(job *Job) error {
+	return writeJSONFile(j.jobPath(job.ID), job)
+}
+
+func (j *Jobs) jobPath(id string) string {
+	return filepath.Join(j.dir, id+".json")
+}
+
+func (j *Jobs) resultPath(id string) string {
+	return filepath.Join(j.dir, id+".ndjson")
+}
@@ -0,0 +1,170 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func jobEvents(t *testing.T, days ...uint32) *MemoryEvents {
+	t.Helper()
+	m := NewMemoryEvents()
+	for _, day := range days {
+		trk := Tracking{SiteID: "s", Action: TrackingData{Identity: "u", Event: "/", Category: PageviewCategory, OccuredAt: day}}
+		if err := m.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// runJobs runs the queued jobs of j and returns once job id is finished.
+func runJobs(t *testing.T, j *Jobs, id string) Job {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go j.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := j.Get(id); job.finished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s never finished", id)
+	return Job{}
+}
+
+func readResult(t *testing.T, j *Jobs, id string) []IdentityEvent {
+	t.Helper()
+	f, err := j.Result(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []IdentityEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev IdentityEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestJobsExport(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+
+	j := NewJobs(jobEvents(t, 20240301, 20240302, 20240302, 20240305, 20240306), t.TempDir())
+	if err := j.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Create(Job{Kind: "import", SiteID: "s", Start: 20240301, End: 20240305}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown kind: got %v", err)
+	}
+	created, err := j.Create(Job{Kind: JobExport, SiteID: "s", Start: 20240301, End: 20240305})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.State != JobQueued {
+		t.Errorf("got state %s", created.State)
+	}
+	if _, err := j.Result(created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("queued job's result: got %v", err)
+	}
+
+	job := runJobs(t, j, created.ID)
+	if job.State != JobDone || job.Events != 4 || job.DaysDone != 5 || job.Progress != 1 || job.FinishedAt == nil {
+		t.Errorf("got %+v", job)
+	}
+	events := readResult(t, j, job.ID)
+	if len(events) != 4 || events[0].Day != 20240301 || events[3].Day != 20240305 {
+		t.Errorf("got %+v", events)
+	}
+}
+
+func TestJobsResume(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	dir := t.TempDir()
+
+	// A restart interrupted the job after its first day was recorded and
+	// while the second was being written
+	j := NewJobs(jobEvents(t, 20240301, 20240302), dir)
+	line, _ := json.Marshal(IdentityEvent{SiteID: "s", Day: 20240301})
+	line = append(line, '\n')
+	interrupted := &Job{ID: "resumed", Kind: JobExport, SiteID: "s", Start: 20240301, End: 20240302, State: JobRunning, DaysDone: 1, Events: 1, Bytes: int64(len(line))}
+	if err := j.saveLocked(interrupted); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(j.resultPath("resumed"), append(line, `{"siteId":"s","da`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := j.Get("resumed"); job.State != JobQueued {
+		t.Fatalf("got state %s", job.State)
+	}
+	job := runJobs(t, j, "resumed")
+	if job.State != JobDone || job.Events != 2 {
+		t.Errorf("got %+v", job)
+	}
+	events := readResult(t, j, "resumed")
+	if len(events) != 2 || events[1].Day != 20240302 {
+		t.Errorf("got %+v", events)
+	}
+
+	// Reloaded, the finished job is kept
+	reloaded := NewJobs(j.store, dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if job, ok := reloaded.Get("resumed"); !ok || job.State != JobDone {
+		t.Errorf("reloaded: got %+v", job)
+	}
+}
+
+func TestJobsPrune(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.JobRetention = time.Hour
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+	dir := t.TempDir()
+
+	j := NewJobs(jobEvents(t), dir)
+	finished := fc.Now().Add(-2 * time.Hour)
+	for _, job := range []*Job{
+		{ID: "expired", Kind: JobExport, State: JobDone, FinishedAt: &finished},
+		{ID: "queued", Kind: JobExport, State: JobQueued},
+	} {
+		if err := j.saveLocked(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := j.Get("expired"); ok {
+		t.Error("expired job kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expired job file: %v", err)
+	}
+	if _, ok := j.Get("queued"); !ok {
+		t.Error("unfinished job pruned")
+	}
+}
@@ -0,0 +1,228 @@
+package tracker
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrorType is the type of events reporting JavaScript errors seen on a
+// page. They are stored in the js_errors table, summed per error and day,
+// not with the other events.
+const ErrorType = "jserror"
+
+// Bounds of the error fields of jserror events.
+const (
+	maxErrorHashLen    = 64
+	maxErrorMessageLen = 512
+	// maxErrorCount bounds the repeats a tracker may report at once.
+	maxErrorCount = 1000
+)
+
+// JSError is a JavaScript error reported by a jserror event. The tracker
+// identifies errors by Hash, a hash of the message, and may send the message
+// too. Count is the times the error happened since the last report, 1 when
+// zero.
+type JSError struct {
+	Hash    string `json:"hash"`
+	Message string `json:"message,omitempty"`
+	Source  string `json:"source"`
+	Count   uint32 `json:"count,omitempty"`
+}
+
+// validateError checks the error of a jserror event and drops it from
+// events of other types. Errors without hash are identified by their
+// message.
+func validateError(action *TrackingData) error {
+	if action.Type != ErrorType {
+		action.Error = nil
+		return nil
+	}
+	jsErr := action.Error
+	if jsErr == nil {
+		return fmt.Errorf("error is missing")
+	}
+	jsErr.Hash = strings.ToLower(sanitizeField(jsErr.Hash, maxErrorHashLen))
+	jsErr.Message = sanitizeField(jsErr.Message, maxErrorMessageLen)
+	jsErr.Source = stripQuery(sanitizeField(jsErr.Source, maxEventLen))
+	if jsErr.Hash == "" {
+		if jsErr.Message == "" {
+			return fmt.Errorf("error needs a hash or a message")
+		}
+		sum := sha256.Sum256([]byte(jsErr.Message))
+		jsErr.Hash = hex.EncodeToString(sum[:8])
+	}
+	if jsErr.Count > maxErrorCount {
+		return fmt.Errorf("error count must be at most %d", maxErrorCount)
+	}
+	if jsErr.Count == 0 {
+		jsErr.Count = 1
+	}
+	return nil
+}
+
+// ErrorsQuery selects the most frequent errors of a site between two
+// YYYYMMDD days.
+type ErrorsQuery struct {
+	SiteID string `json:"siteId"`
+	Start  uint32 `json:"start"`
+	End    uint32 `json:"end"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// ErrorRow is an error of a source file and the times it happened. Message
+// is one of the messages reported with its hash, LastSeen the last day it
+// happened.
+type ErrorRow struct {
+	Hash     string `json:"hash"`
+	Message  string `json:"message"`
+	Source   string `json:"source"`
+	Count    uint64 `json:"count"`
+	LastSeen uint32 `json:"lastSeen"`
+}
+
+type ErrorsResult struct {
+	Meta StatsMeta  `json:"meta"`
+	Data []ErrorRow `json:"data"`
+}
+
+// ErrorStore is implemented by stores that keep JavaScript errors.
+type ErrorStore interface {
+	TopErrors(ctx context.Context, q ErrorsQuery) (*ErrorsResult, error)
+}
+
+type errorKey struct {
+	siteID       string
+	day          uint32
+	hash, source string
+}
+
+// insertErrors writes the errors of the jserror events of batch, summed per
+// site, day, hash and source file. The table sums them again as it merges.
+func (e *Events) insertErrors(ctx context.Context, batch []qdata) error {
+	var keys []errorKey
+	counts := make(map[errorKey]uint64)
+	messages := make(map[errorKey]string)
+	for _, qd := range batch {
+		jsErr := qd.trk.Action.Error
+		k := errorKey{qd.trk.SiteID, qd.occuredAt(), jsErr.Hash, jsErr.Source}
+		if _, ok := counts[k]; !ok {
+			keys = append(keys, k)
+		}
+		counts[k] += uint64(jsErr.Count)
+		if jsErr.Message != "" {
+			messages[k] = jsErr.Message
+		}
+	}
+
+	b, err := e.DB.PrepareBatch(ctx, "INSERT INTO js_errors (site_id, day, hash, source, message, count)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare errors batch: %w", err)
+	}
+	for _, k := range keys {
+		if err := b.Append(k.siteID, k.day, k.hash, k.source, messages[k], counts[k]); err != nil {
+			return fmt.Errorf("failed to append errors to batch: %w", err)
+		}
+	}
+	if err := b.Send(); err != nil {
+		return fmt.Errorf("failed to send errors batch: %w", err)
+	}
+	return nil
+}
+
+func (e *Events) TopErrors(ctx context.Context, q ErrorsQuery) (*ErrorsResult, error) {
+	started := time.Now()
+	qry := `
+		SELECT hash, anyIf(message, message != ''), source, sum(count), max(day)
+		FROM js_errors
+		WHERE site_id = $1
+		AND day BETWEEN $2 AND $3
+		GROUP BY hash, source
+		ORDER BY 4 DESC, 1, 3
+		LIMIT $4;
+	`
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	rows, err := e.DB.Query(queryCtx, qry, q.SiteID, q.Start, q.End, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("errors query failed: %w", err)
+	}
+	defer rows.Close()
+
+	data := []ErrorRow{}
+	for rows.Next() {
+		var row ErrorRow
+		if err := rows.Scan(&row.Hash, &row.Message, &row.Source, &row.Count, &row.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed scanning errors row: %w", err)
+		}
+		data = append(data, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newErrorsResult(data, SourceRaw, time.Since(started)), nil
+}
+
+func (m *MemoryEvents) TopErrors(ctx context.Context, q ErrorsQuery) (*ErrorsResult, error) {
+	started := time.Now()
+
+	type key struct{ hash, source string }
+	errs := make(map[key]*ErrorRow)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Type != ErrorType || row.trk.Action.Error == nil {
+			continue
+		}
+		day := row.trk.Action.OccuredAt
+		if day < q.Start || day > q.End {
+			continue
+		}
+		jsErr := row.trk.Action.Error
+		k := key{jsErr.Hash, jsErr.Source}
+		e, ok := errs[k]
+		if !ok {
+			e = &ErrorRow{Hash: jsErr.Hash, Source: jsErr.Source}
+			errs[k] = e
+		}
+		e.Count += uint64(max(jsErr.Count, 1))
+		e.LastSeen = max(e.LastSeen, day)
+		if e.Message == "" {
+			e.Message = jsErr.Message
+		}
+	}
+	m.lock.RUnlock()
+
+	data := make([]ErrorRow, 0, len(errs))
+	for _, e := range errs {
+		data = append(data, *e)
+	}
+	slices.SortFunc(data, func(a, b ErrorRow) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return cmp.Or(cmp.Compare(a.Hash, b.Hash), cmp.Compare(a.Source, b.Source))
+	})
+	if q.Limit > 0 && len(data) > q.Limit {
+		data = data[:q.Limit]
+	}
+	return newErrorsResult(data, SourceMemory, time.Since(started)), nil
+}
+
+func newErrorsResult(data []ErrorRow, source StatsSource, took time.Duration) *ErrorsResult {
+	return &ErrorsResult{
+		Meta: StatsMeta{
+			Rows:        len(data),
+			DurationMs:  float64(took.Microseconds()) / 1000,
+			Granularity: "total",
+			SampleRate:  1,
+			Source:      source,
+		},
+		Data: data,
+	}
+}
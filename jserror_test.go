@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestDecodeError(t *testing.T) {
+	trk, err := DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"jserror","error":{"hash":"AB12","source":"https://x.test/app.js?v=3#l"}}}`))
+	if err != nil || *trk.Action.Error != (JSError{Hash: "ab12", Source: "https://x.test/app.js", Count: 1}) {
+		t.Fatalf("got %+v, %v", trk.Action.Error, err)
+	}
+	trk, err = DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"jserror","error":{"message":"boom","count":5}}}`))
+	if err != nil || len(trk.Action.Error.Hash) != 16 || trk.Action.Error.Count != 5 {
+		t.Errorf("hashed message: got %+v, %v", trk.Action.Error, err)
+	}
+	trk, err = DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"page","event":"/","error":{"hash":"a"}}}`))
+	if err != nil || trk.Action.Error != nil {
+		t.Errorf("error of a page view: got %+v, %v", trk.Action.Error, err)
+	}
+
+	for _, payload := range []string{
+		`{"site_id":"s","tracking":{"type":"jserror"}}`,
+		`{"site_id":"s","tracking":{"type":"jserror","error":{"source":"/app.js"}}}`,
+		`{"site_id":"s","tracking":{"type":"jserror","error":{"hash":"a","count":5000}}}`,
+	} {
+		if _, err := DecodePayload([]byte(payload)); !errors.Is(err, ErrMalformedPayload) {
+			t.Errorf("%s: got %v", payload, err)
+		}
+	}
+}
+
+func TestMemoryTopErrors(t *testing.T) {
+	m := NewMemoryEvents()
+	for _, add := range []struct {
+		day   uint32
+		error JSError
+	}{
+		{20240301, JSError{Hash: "a", Source: "/app.js", Count: 2}},
+		{20240302, JSError{Hash: "a", Message: "x is undefined", Source: "/app.js", Count: 1}},
+		{20240302, JSError{Hash: "a", Source: "/other.js", Count: 1}},
+		{20240302, JSError{Hash: "b", Source: "/app.js", Count: 5}},
+		{20240303, JSError{Hash: "c", Source: "/app.js", Count: 9}},
+	} {
+		jsErr := add.error
+		trk := Tracking{SiteID: "s", Action: TrackingData{Type: ErrorType, OccuredAt: add.day, Error: &jsErr}}
+		if err := m.Add(context.Background(), trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := m.TopErrors(context.Background(), ErrorsQuery{SiteID: "s", Start: 20240301, End: 20240302, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ErrorRow{
+		{Hash: "b", Source: "/app.js", Count: 5, LastSeen: 20240302},
+		{Hash: "a", Message: "x is undefined", Source: "/app.js", Count: 3, LastSeen: 20240302},
+	}
+	if len(got.Data) != 2 || got.Data[0] != want[0] || got.Data[1] != want[1] {
+		t.Errorf("got %+v", got.Data)
+	}
+}
@@ -0,0 +1,88 @@
+package tracker
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Events may carry the time they happened, mobile SDKs upload events they
+// queued while offline. Rollups add each event to its own day as it is
+// inserted, but cached and exported stats of that day are already stale,
+// so events arriving long after their site's latest event are stored as
+// late and the days they landed on are listed for recomputing.
+
+// maxClientSkew is how far in the future client timestamps may be, device
+// clocks drift.
+const maxClientSkew = 5 * time.Minute
+
+// maxClientAge is how old client timestamps may be, older events are
+// rejected rather than added to long settled days.
+const maxClientAge = 30 * 24 * time.Hour
+
+var lateStats = expvar.NewMap("late_events")
+
+// Watermarks track each site's high watermark, the time of its latest
+// event, since the process started and count the late events per day.
+type Watermarks struct {
+	lock  sync.Mutex
+	marks map[string]time.Time
+	late  map[string]map[uint32]uint64 // site -> YYYYMMDD -> events
+}
+
+func NewWatermarks() *Watermarks {
+	return &Watermarks{marks: make(map[string]time.Time), late: make(map[string]map[uint32]uint64)}
+}
+
+// Observe moves the watermark of siteID to at if it is later, and reports
+// whether an event happening at at is late: more than LATE_EVENT_AFTER
+// behind the watermark.
+func (w *Watermarks) Observe(siteID string, at time.Time) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	mark := w.marks[siteID]
+	if at.After(mark) {
+		w.marks[siteID] = at
+		return false
+	}
+	if config.LateEventAfter <= 0 || mark.Sub(at) <= config.LateEventAfter {
+		return false
+	}
+
+	if w.late[siteID] == nil {
+		w.late[siteID] = make(map[uint32]uint64)
+	}
+	w.late[siteID][DayIn(at, clock.Now().Location())]++
+	lateStats.Add("late", 1)
+	return true
+}
+
+// Watermark returns the time of the latest event of siteID, zero when none
+// was seen.
+func (w *Watermarks) Watermark(siteID string) time.Time {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.marks[siteID]
+}
+
+// LateDays returns the number of late events of siteID per YYYYMMDD day
+// they happened on, the days whose stats need recomputing.
+func (w *Watermarks) LateDays(siteID string) map[uint32]uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	days := make(map[uint32]uint64)
+	for day, n := range w.late[siteID] {
+		days[day] = n
+	}
+	return days
+}
+
+// Forget drops the watermark and late events of siteID, after its deletion.
+func (w *Watermarks) Forget(siteID string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.marks, siteID)
+	delete(w.late, siteID)
+}
@@ -0,0 +1,37 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatermarks(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.LateEventAfter = 6 * time.Hour
+	restore := SetClock(NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)))
+	t.Cleanup(restore)
+
+	w := NewWatermarks()
+	now := Now()
+	if w.Observe("s", now) || !w.Watermark("s").Equal(now) {
+		t.Fatal("first event is late")
+	}
+	if w.Observe("s", now.Add(-5*time.Hour)) {
+		t.Error("event within the threshold is late")
+	}
+	if !w.Observe("s", now.Add(-3*24*time.Hour)) || !w.Observe("s", now.Add(-3*24*time.Hour-time.Hour)) {
+		t.Error("event three days behind is not late")
+	}
+	if w.Observe("other", now.Add(-3*24*time.Hour)) {
+		t.Error("watermarks are shared between sites")
+	}
+	if days := w.LateDays("s"); len(days) != 1 || days[20240307] != 2 {
+		t.Errorf("got late days %v", days)
+	}
+
+	config.LateEventAfter = 0
+	if w.Observe("s", now.Add(-10*24*time.Hour)) {
+		t.Error("late events flagged while disabled")
+	}
+}
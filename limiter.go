@@ -0,0 +1,147 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTooBusy is returned when no stats query slot frees up in time.
+var ErrTooBusy = errors.New("too many concurrent stats queries")
+
+// Limited is an EventStore running at most a fixed number of stats queries
+// at once, so bursts of dashboard loads don't exhaust the ClickHouse
+// connection pool inserts share. Excess queries wait up to wait for a slot,
+// or are rejected right away when wait is 0.
+type Limited struct {
+	EventStore
+
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func NewLimited(store EventStore, concurrency int, wait time.Duration) *Limited {
+	return &Limited{EventStore: store, slots: make(chan struct{}, concurrency), wait: wait}
+}
+
+func (l *Limited) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.wait <= 0 {
+		statsCounters.Add("limiter_rejected", 1)
+		return ErrTooBusy
+	}
+
+	statsCounters.Add("limiter_queued", 1)
+	timer := clock.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C():
+		statsCounters.Add("limiter_rejected", 1)
+		return ErrTooBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limited) release() {
+	<-l.slots
+}
+
+func (l *Limited) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.EventStore.GetStats(ctx, data)
+}
+
+func (l *Limited) TimeSeries(ctx context.Context, q TimeSeriesQuery) (*TimeSeriesResult, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.EventStore.TimeSeries(ctx, q)
+}
+
+func (l *Limited) Values(ctx context.Context, q ValuesQuery) ([]string, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.EventStore.Values(ctx, q)
+}
+
+func (l *Limited) Summary(ctx context.Context, siteID string) (*SiteSummary, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.EventStore.Summary(ctx, siteID)
+}
+
+func (l *Limited) DarkTraffic(ctx context.Context, q DarkTrafficQuery) (*DarkTrafficResult, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.EventStore.DarkTraffic(ctx, q)
+}
+
+// Funnel takes a slot for funnel queries, when the store computes them.
+func (l *Limited) Funnel(ctx context.Context, q FunnelQuery) (*FunnelResult, error) {
+	store, ok := l.EventStore.(FunnelStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't compute funnels", errors.ErrUnsupported)
+	}
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return store.Funnel(ctx, q)
+}
+
+// Vitals takes a slot for vitals queries, when the store keeps web vitals.
+func (l *Limited) Vitals(ctx context.Context, q VitalsQuery) (*VitalsResult, error) {
+	store, ok := l.EventStore.(VitalsStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't keep web vitals", errors.ErrUnsupported)
+	}
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return store.Vitals(ctx, q)
+}
+
+// TopErrors takes a slot for errors queries, when the store keeps JavaScript errors.
+func (l *Limited) TopErrors(ctx context.Context, q ErrorsQuery) (*ErrorsResult, error) {
+	store, ok := l.EventStore.(ErrorStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't keep JavaScript errors", errors.ErrUnsupported)
+	}
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return store.TopErrors(ctx, q)
+}
+
+// Cohorts takes a slot for cohorts queries, when the store compares cohorts.
+func (l *Limited) Cohorts(ctx context.Context, q CohortQuery) (*CohortResult, error) {
+	store, ok := l.EventStore.(CohortStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: the store can't compare cohorts", errors.ErrUnsupported)
+	}
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return store.Cohorts(ctx, q)
+}
@@ -0,0 +1,77 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLimited(t *testing.T) {
+	store := &blockingStore{MemoryEvents: NewMemoryEvents(), release: make(chan struct{})}
+	l := NewLimited(store, 1, 0)
+	data := MetricData{What: QueryBrowsers, SiteID: "site"}
+
+	done := make(chan error)
+	go func() {
+		_, err := l.GetStats(context.Background(), data)
+		done <- err
+	}()
+	for store.calls.Load() == 0 {
+	}
+
+	if _, err := l.GetStats(context.Background(), data); !errors.Is(err, ErrTooBusy) {
+		t.Errorf("got %v with every slot taken, want ErrTooBusy", err)
+	}
+
+	close(store.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.GetStats(context.Background(), data); err != nil {
+		t.Errorf("got %v once the slot was released", err)
+	}
+}
+
+func TestLimitedOptionalStores(t *testing.T) {
+	store := &blockingStore{MemoryEvents: NewMemoryEvents(), release: make(chan struct{})}
+	l := NewLimited(store, 1, 0)
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		l.GetStats(ctx, MetricData{What: QueryBrowsers, SiteID: "site"})
+		close(done)
+	}()
+	for store.calls.Load() == 0 {
+	}
+
+	for name, query := range map[string]func() error{
+		"funnel": func() error {
+			_, err := l.Funnel(ctx, FunnelQuery{SiteID: "site", Steps: []FunnelStep{{Event: "/"}}})
+			return err
+		},
+		"vitals": func() error {
+			_, err := l.Vitals(ctx, VitalsQuery{SiteID: "site"})
+			return err
+		},
+		"errors": func() error {
+			_, err := l.TopErrors(ctx, ErrorsQuery{SiteID: "site"})
+			return err
+		},
+		"cohorts": func() error {
+			_, err := l.Cohorts(ctx, CohortQuery{SiteID: "site"})
+			return err
+		},
+	} {
+		if err := query(); !errors.Is(err, ErrTooBusy) {
+			t.Errorf("%s: got %v with every slot taken, want ErrTooBusy", name, err)
+		}
+	}
+	close(store.release)
+	<-done
+
+	plain := NewLimited(struct{ EventStore }{NewMemoryEvents()}, 1, 0)
+	if _, err := plain.Funnel(ctx, FunnelQuery{}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("store without funnels: got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// MemoryEvents is an in-process EventStore used by dev mode and tests. It
+// answers every query the ClickHouse store does. Nothing is persisted,
+// events live as long as the process does, or until MemoryMaxEvents newer
+// ones push them out.
+type MemoryEvents struct {
+	lock sync.RWMutex
+	rows []qdata
+	wg   sync.WaitGroup
+	log  *slog.Logger
+	// maxEvents is MemoryMaxEvents when the store was created
+	maxEvents int
+
+	audit memoryAudit
+}
+
+func NewMemoryEvents() *MemoryEvents {
+	return &MemoryEvents{
+		log:       slog.Default().With(slog.String("component", "MemoryEvents")),
+		maxEvents: config.MemoryMaxEvents,
+	}
+}
+
+func (m *MemoryEvents) Add(ctx context.Context, trk Tracking, ua useragent.UserAgent, geo *GeoInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if geo == nil {
+		geo = &GeoInfo{}
+	}
+	if trk.Action.OccuredAt == 0 {
+		trk.Action.OccuredAt = Today()
+	}
+
+	m.lock.Lock()
+	m.rows = append(m.rows, qdata{trk: trk, ua: ua, geo: geo, queuedAt: clock.Now().UTC()})
+	// The dropped rows are freed once append moves the rest
+	if limit := m.maxEvents; limit > 0 && len(m.rows) > limit {
+		m.rows = m.rows[len(m.rows)-limit:]
+	}
+	m.lock.Unlock()
+	return nil
+}
+
+// Run only blocks until ctx is done, events are queryable as soon as they are added.
+func (m *MemoryEvents) Run(ctx context.Context) {
+	m.wg.Add(1)
+	defer m.wg.Done()
+
+	m.log.Info("In-memory event store started")
+	<-ctx.Done()
+	m.log.Info("In-memory event store stopped", slog.Int("events", m.Len()))
+}
+
+func (m *MemoryEvents) WaitFlush() {
+	m.wg.Wait()
+}
+
+// QueueStats is always empty, events are stored as they are added.
+func (m *MemoryEvents) QueueStats() QueueStats {
+	return QueueStats{}
+}
+
+// Flush has nothing to write.
+func (m *MemoryEvents) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Len returns the number of stored events.
+func (m *MemoryEvents) Len() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.rows)
+}
+
+func (m *MemoryEvents) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	started := time.Now()
+	def, ok := metricDefs[data.What]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %s", data.What)
+	}
+	filterCols, filterValues, err := filterColumns(data.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		site  string
+		day   uint32
+		value string
+	}
+	counts := make(map[key]uint64)
+	sites := make(map[string]bool)
+	for _, id := range data.Sites() {
+		sites[id] = true
+	}
+
+	var sessionRows []qdata
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if !sites[row.trk.SiteID] || row.trk.Action.Category != PageviewCategory {
+			continue
+		}
+		if !counted(data.Qualities, row.trk.Action) {
+			continue
+		}
+		if row.trk.Action.OccuredAt < data.Start || row.trk.Action.OccuredAt > data.End {
+			continue
+		}
+		if def.filter != "" && row.column(def.filter) != data.Extra {
+			continue
+		}
+		if data.Source != "" && row.source() != data.Source {
+			continue
+		}
+		if data.Touch != "" && row.touch() != data.Touch {
+			continue
+		}
+		if !row.matches(filterCols, filterValues) {
+			continue
+		}
+		if def.session != noSessionStat {
+			sessionRows = append(sessionRows, row)
+			continue
+		}
+
+		k := key{site: row.trk.SiteID, value: row.column(def.field)}
+		if def.daily {
+			k.day = row.trk.Action.OccuredAt
+		}
+		counts[k]++
+	}
+	m.lock.RUnlock()
+
+	if def.session != noSessionStat {
+		metrics := sessionMetrics(def, sessionRows)
+		sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].OccuredAt < metrics[j].OccuredAt })
+		return newStatsResult(data, metrics, SourceMemory, time.Since(started)), nil
+	}
+
+	metrics := make([]siteMetric, 0, len(counts))
+	for k, count := range counts {
+		metrics = append(metrics, siteMetric{Metric: Metric{OccuredAt: k.day, Value: k.value, Count: count}, site: k.site})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Count != metrics[j].Count {
+			return metrics[i].Count > metrics[j].Count
+		}
+		if metrics[i].OccuredAt != metrics[j].OccuredAt {
+			return metrics[i].OccuredAt < metrics[j].OccuredAt
+		}
+		if metrics[i].Value != metrics[j].Value {
+			return metrics[i].Value < metrics[j].Value
+		}
+		return metrics[i].site < metrics[j].site
+	})
+	return newStatsResult(data, metrics, SourceMemory, time.Since(started)), nil
+}
+
+func (m *MemoryEvents) Usage(ctx context.Context, q UsageQuery) ([]UsageRow, error) {
+	counts := make(map[UsageRow]uint64)
+
+	m.lock.RLock()
+	for _, row := range m.rows {
+		day := row.trk.Action.OccuredAt
+		if day < q.Start || day > q.End || (q.SiteID != "" && row.trk.SiteID != q.SiteID) {
+			continue
+		}
+		if q.Monthly {
+			day = MonthOf(day)
+		}
+		counts[UsageRow{SiteID: row.trk.SiteID, Period: strconv.Itoa(int(day))}]++
+	}
+	m.lock.RUnlock()
+
+	usage := make([]UsageRow, 0, len(counts))
+	for u, count := range counts {
+		u.Events = count
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].SiteID != usage[j].SiteID {
+			return usage[i].SiteID < usage[j].SiteID
+		}
+		return usage[i].Period < usage[j].Period
+	})
+	return usage, nil
+}
+
+// matches reports whether the row has every value in the column of the
+// same index.
+func (q qdata) matches(cols, values []string) bool {
+	for i, col := range cols {
+		if q.column(col) != values[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// column returns the value stored in the events table column of that name.
+func (q qdata) column(name string) string {
+	switch name {
+	case "site_id":
+		return q.trk.SiteID
+	case "type":
+		return q.trk.Action.Type
+	case "user_id":
+		return q.trk.Action.Identity
+	case "event":
+		return q.trk.Action.Event
+	case "category":
+		return q.trk.Action.Category
+	case "referrer":
+		return q.trk.Action.Referrer
+	case "referrer_domain":
+		return q.trk.Action.ReferrerHost
+	case "browser_name":
+		return q.ua.Name
+	case "os_name":
+		return q.ua.OS
+	case "device_type":
+		return q.ua.Device
+	case "country":
+		return q.geo.Country
+	case "region":
+		return q.geo.RegionName
+	case "source":
+		return q.source()
+	case "touch":
+		return q.touch()
+	case "browser_engine":
+		return BrowserEngine(q.ua)
+	case "campaign":
+		return q.trk.Action.Campaign
+	case "utm_source":
+		return q.trk.Action.UTMSource
+	case "utm_medium":
+		return q.trk.Action.UTMMedium
+	case "utm_term":
+		return q.trk.Action.UTMTerm
+	case "utm_content":
+		return q.trk.Action.UTMContent
+	case "app_version":
+		return q.trk.Action.AppVersion
+	case "os_version":
+		return q.trk.Action.OSVersion
+	case "quality":
+		return quality(q.trk.Action)
+	case "session_id":
+		return q.trk.Action.SessionID
+	}
+	return ""
+}
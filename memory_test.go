@@ -0,0 +1,108 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestMemoryEventsStores(t *testing.T) {
+	var store EventStore = NewMemoryEvents()
+	if _, ok := store.(Queued); !ok {
+		t.Error("not Queued")
+	}
+	if _, ok := store.(IdentityExporter); !ok {
+		t.Error("not an IdentityExporter")
+	}
+	if _, ok := store.(SiteExporter); !ok {
+		t.Error("not a SiteExporter")
+	}
+	if _, ok := store.(SiteMerger); !ok {
+		t.Error("not a SiteMerger")
+	}
+	if _, ok := store.(EventLister); !ok {
+		t.Error("not an EventLister")
+	}
+	if _, ok := store.(VitalsStore); !ok {
+		t.Error("not a VitalsStore")
+	}
+	if _, ok := store.(ErrorStore); !ok {
+		t.Error("not an ErrorStore")
+	}
+	if _, ok := store.(FunnelStore); !ok {
+		t.Error("not a FunnelStore")
+	}
+	if _, ok := store.(AuditStore); !ok {
+		t.Error("not an AuditStore")
+	}
+}
+
+func TestMemoryGetStats(t *testing.T) {
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	ua := useragent.Parse("Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0")
+	trk := Tracking{SiteID: "s", Action: TrackingData{
+		Type:         PageviewType,
+		Identity:     "u1",
+		Event:        "/",
+		Category:     PageviewCategory,
+		Referrer:     "https://github.com/x",
+		ReferrerHost: "github.com",
+		Campaign:     "launch",
+		UTMSource:    "newsletter",
+		UTMMedium:    "email",
+		UTMTerm:      "analytics",
+		UTMContent:   "header",
+		OccuredAt:    20240301,
+		SessionID:    "v1",
+	}}
+	if err := m.Add(ctx, trk, ua, &GeoInfo{Country: "Germany"}); err != nil {
+		t.Fatal(err)
+	}
+	trk.Action.Category = "Clicks"
+	if err := m.Add(ctx, trk, ua, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every query counts the page view, grouped by a value it carries.
+	// Session metrics have none, the session bounced after 0s.
+	for what, def := range metricDefs {
+		data := MetricData{What: what, SiteID: "s", Start: 20240301, End: 20240301, Extra: "github.com"}
+		got, err := m.GetStats(ctx, data)
+		if err != nil {
+			t.Fatalf("%v: %v", what, err)
+		}
+		want := map[sessionStat]uint64{noSessionStat: 1, sessionCount: 1, sessionBounceRate: 100, sessionDuration: 0}[def.session]
+		if len(got.Data) != 1 || got.Data[0].Count != want || (got.Data[0].Value == "") != (def.session != noSessionStat) {
+			t.Errorf("%v (%s): got %+v", what, def.field, got.Data)
+		}
+	}
+
+	got, _ := m.GetStats(ctx, MetricData{What: QueryPageViews, SiteID: "s", Start: 20240302, End: 20240303})
+	if len(got.Data) != 0 {
+		t.Errorf("outside the days: got %+v", got.Data)
+	}
+}
+
+func TestMemoryMaxEvents(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.MemoryMaxEvents = 2
+
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	for _, day := range []uint32{20240301, 20240302, 20240303} {
+		trk := Tracking{SiteID: "s", Action: TrackingData{Identity: "u", Event: "/", Category: PageviewCategory, OccuredAt: day}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Len() != 2 {
+		t.Fatalf("got %d events, want 2", m.Len())
+	}
+	usage, _ := m.Usage(ctx, UsageQuery{Start: 20240301, End: 20240303})
+	if len(usage) != 2 || usage[0].Period != "20240302" {
+		t.Errorf("oldest event kept: %+v", usage)
+	}
+}
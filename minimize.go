@@ -0,0 +1,70 @@
+package tracker
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Fields a site can choose not to store at all, see Site.OmitFields.
+const (
+	// OmitReferrer drops the referrer URL, its domain is kept.
+	OmitReferrer = "referrer"
+	// OmitRegion drops the region of the visitor.
+	OmitRegion = "region"
+	// OmitCity drops the city and coordinates of the visitor.
+	OmitCity = "city"
+	// OmitUserAgent drops the user agent string once it is parsed, the
+	// browser, OS and device are kept. Spooled events can't be parsed
+	// again, they are stored without them.
+	OmitUserAgent = "ua"
+)
+
+// OmittableFields are the fields sites can omit.
+var OmittableFields = map[string]bool{
+	OmitReferrer:  true,
+	OmitRegion:    true,
+	OmitCity:      true,
+	OmitUserAgent: true,
+}
+
+// validateOmitFields checks the OmitFields of site.
+func validateOmitFields(site Site) error {
+	for _, field := range site.OmitFields {
+		if !OmittableFields[field] {
+			return fmt.Errorf("site %s: %w: unknown omitted field %q", site.ID, ErrInvalid, field)
+		}
+	}
+	return nil
+}
+
+// Omits reports whether the site doesn't store field.
+func (s Site) Omits(field string) bool {
+	return slices.Contains(s.OmitFields, field)
+}
+
+// Minimize drops the fields of action the site doesn't store. It runs as
+// events are accepted, before they are queued or spooled anywhere.
+func (s Site) Minimize(action *TrackingData) {
+	if s.Omits(OmitReferrer) {
+		action.Referrer = ""
+	}
+	if s.Omits(OmitUserAgent) {
+		action.UserAgent = ""
+	}
+}
+
+// MinimizeGeo returns geo without the fields the site doesn't store. geo
+// is left as is, it may be cached.
+func (s Site) MinimizeGeo(geo *GeoInfo) *GeoInfo {
+	if geo == nil || (!s.Omits(OmitRegion) && !s.Omits(OmitCity)) {
+		return geo
+	}
+	minimized := *geo
+	if s.Omits(OmitRegion) {
+		minimized.RegionName, minimized.RegionCode = "", ""
+	}
+	if s.Omits(OmitCity) {
+		minimized.City, minimized.Latitude, minimized.Longitude = "", 0, 0
+	}
+	return &minimized
+}
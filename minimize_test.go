@@ -0,0 +1,63 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestSiteMinimize(t *testing.T) {
+	site := Site{ID: "s", OmitFields: []string{OmitReferrer, OmitUserAgent, OmitCity}}
+	action := TrackingData{Referrer: "https://news.example/a?b", ReferrerHost: "news.example", UserAgent: "Mozilla/5.0", Event: "/"}
+	site.Minimize(&action)
+	if action.Referrer != "" || action.UserAgent != "" || action.ReferrerHost != "news.example" || action.Event != "/" {
+		t.Errorf("got %+v", action)
+	}
+
+	geo := &GeoInfo{Country: "Germany", RegionName: "Berlin", City: "Berlin", Latitude: 52.5}
+	got := site.MinimizeGeo(geo)
+	if *got != (GeoInfo{Country: "Germany", RegionName: "Berlin"}) || geo.City != "Berlin" {
+		t.Errorf("got %+v from %+v", *got, *geo)
+	}
+	if got := (Site{}).MinimizeGeo(geo); got != geo {
+		t.Error("geo copied for a site omitting nothing")
+	}
+
+	state := DesiredState{Sites: []Site{{ID: "s", OmitFields: []string{"referer"}}}}
+	if err := state.validate(&Saved{}, false); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown field: got %v", err)
+	}
+}
+
+func TestEnricherOmitsGeoFields(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+
+	sites := &Sites{}
+	sites.Load("")
+	sites.Ensure(Site{ID: "strict", OmitFields: []string{OmitRegion}})
+	overrides := &GeoOverrides{}
+	overrides.overrides = []geoOverride{{net: parseNetwork("198.51.100.7"), geo: GeoInfo{CountryISO: "DE", RegionName: "Berlin"}}}
+
+	store := NewMemoryEvents()
+	enricher := NewEnricher(store, nil, nil)
+	enricher.SetGeoOverrides(overrides)
+	enricher.SetSites(sites)
+	enricher.Start()
+	for _, site := range []string{"strict", "open"} {
+		if err := enricher.Submit(context.Background(), Tracking{SiteID: site}, useragent.UserAgent{}, parseNetwork("198.51.100.7").IP); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enricher.Close()
+
+	regions := make(map[string]string)
+	for _, row := range store.rows {
+		regions[row.trk.SiteID] = row.geo.RegionName
+	}
+	if regions["strict"] != "" || regions["open"] != "Berlin" {
+		t.Errorf("got regions %v", regions)
+	}
+}
@@ -0,0 +1,352 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// MMDB reads MaxMind DB files such as GeoLite2-City and GeoLite2-Country,
+// see https://maxmind.github.io/MaxMind-DB/. The file is read into memory
+// once, lookups walk its search tree and decode the record the IP's network
+// points to. A database updated on disk is only read again on restart.
+type MMDB struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	// Type is the database_type of the metadata, such as GeoLite2-City
+	Type string
+}
+
+// mmdbMetadataMarker starts the metadata section at the end of the file.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+var errMMDBCorrupt = errors.New("invalid MaxMind DB")
+
+// OpenMMDB reads the MaxMind DB at path.
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := newMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func newMMDB(buf []byte) (*MMDB, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", errMMDBCorrupt)
+	}
+	meta := buf[i+len(mmdbMetadataMarker):]
+	v, _, err := (mmdbDecoder{meta}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", errMMDBCorrupt, err)
+	}
+	m, _ := v.(map[string]any)
+	uintOf := func(key string) uint {
+		n, _ := m[key].(uint64)
+		return uint(n)
+	}
+
+	db := &MMDB{
+		nodeCount:  uintOf("node_count"),
+		recordSize: uintOf("record_size"),
+		ipVersion:  uintOf("ip_version"),
+	}
+	db.Type, _ = m["database_type"].(string)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", errMMDBCorrupt, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", errMMDBCorrupt, db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	// 16 zero bytes separate the tree from the data
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("%w: search tree larger than the file", errMMDBCorrupt)
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[treeSize+16 : i]
+
+	// IPv4 addresses are found under ::/96 of IPv6 trees
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for b := 0; b < 96 && node < db.nodeCount; b++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *MMDB) record(node, bit uint) uint {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// find returns the record of ip's network, nil when the database has none.
+func (db *MMDB) find(ip net.IP) (any, error) {
+	node := uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < 8*len(ip) && node < db.nodeCount; i++ {
+		node = db.record(node, uint(ip[i/8]>>(7-i%8))&1)
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, fmt.Errorf("%w: search tree deeper than the address", errMMDBCorrupt)
+	}
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.data)) {
+		return nil, fmt.Errorf("%w: record outside the data section", errMMDBCorrupt)
+	}
+	v, _, err := (mmdbDecoder{db.data}).decode(offset, 0)
+	return v, err
+}
+
+// Lookup returns the location of ip in a City or Country database. IPs
+// the database has no network for are returned without a location.
+func (db *MMDB) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("could not parse IP: %s", ip)
+	}
+	v, err := db.find(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &GeoInfo{IP: ip}
+	record, _ := v.(map[string]any)
+	info.Country = mmdbField(record, "country", "names", "en")
+	info.CountryISO = mmdbField(record, "country", "iso_code")
+	info.City = mmdbField(record, "city", "names", "en")
+	if subdivisions, _ := record["subdivisions"].([]any); len(subdivisions) > 0 {
+		region, _ := subdivisions[0].(map[string]any)
+		info.RegionName = mmdbField(region, "names", "en")
+		info.RegionCode = mmdbField(region, "iso_code")
+	}
+	if location, _ := record["location"].(map[string]any); location != nil {
+		info.Latitude, _ = location["latitude"].(float64)
+		info.Longitude, _ = location["longitude"].(float64)
+	}
+	return info, nil
+}
+
+// mmdbField returns the string at path in nested maps, "" when there is
+// none.
+func mmdbField(m map[string]any, path ...string) string {
+	for _, key := range path[:len(path)-1] {
+		m, _ = m[key].(map[string]any)
+	}
+	s, _ := m[path[len(path)-1]].(string)
+	return s
+}
+
+// MaxMind DB data types
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// mmdbMaxDepth bounds the nesting of maps and arrays, records of real
+// databases are a few levels deep.
+const mmdbMaxDepth = 32
+
+// mmdbDecoder decodes the values of a data section, pointers are offsets
+// into it.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset after it. Pointers are
+// followed, the offset after them is the one after the pointer.
+func (d mmdbDecoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deep")
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == mmdbPointer {
+		// size holds the pointer, pointers to pointers are invalid but
+		// depth bounds them anyway
+		v, _, err := d.decode(size, depth+1)
+		return v, offset, err
+	}
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]any, min(size, 64))
+		for range size {
+			var k, v any
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]any, 0, min(size, 64))
+		for range size {
+			var v any
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) || end < offset {
+		return nil, 0, errors.New("value past the end of the data")
+	}
+	b := d.buf[offset:end]
+	switch typ {
+	case mmdbString:
+		return string(b), end, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double of invalid size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float of invalid size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), end, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, errors.New("integer of invalid size")
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, end, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, errors.New("integer of invalid size")
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		// Shorter values are positive, the sign is the top bit of 4 bytes
+		return int64(int32(n)), end, nil
+	case mmdbBytes, mmdbUint128:
+		return b, end, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// control decodes the control byte at offset into the type and size of the
+// value, the pointer of pointers, and returns the offset of its payload.
+func (d mmdbDecoder) control(offset uint) (typ, size, next uint, err error) {
+	read := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(d.buf)) {
+			return nil, errors.New("control past the end of the data")
+		}
+		b := d.buf[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	ctrl := uint(b[0])
+	typ = ctrl >> 5
+	if typ == mmdbPointer {
+		n := (ctrl >> 3) & 3
+		if b, err = read(n + 1); err != nil {
+			return 0, 0, 0, err
+		}
+		var p uint
+		if n < 3 {
+			p = ctrl & 7
+		}
+		for _, c := range b {
+			p = p<<8 | uint(c)
+		}
+		p += [...]uint{0, 2048, 526336, 0}[n]
+		return typ, p, offset, nil
+	}
+	if typ == mmdbExtended {
+		if b, err = read(1); err != nil {
+			return 0, 0, 0, err
+		}
+		typ = 7 + uint(b[0])
+	}
+
+	size = ctrl & 0x1f
+	if size >= 29 {
+		n := size - 28
+		if b, err = read(n); err != nil {
+			return 0, 0, 0, err
+		}
+		var extra uint
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = [...]uint{29, 285, 65821}[n-1] + extra
+	}
+	return typ, size, offset, nil
+}
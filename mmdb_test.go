@@ -0,0 +1,223 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mmdbValue encodes v in the MaxMind DB data format. mmdbPtr values are
+// encoded as pointers.
+type mmdbPtr uint
+
+func mmdbValue(v any) []byte {
+	ctrl := func(typ, size int) []byte {
+		var b []byte
+		if typ > 7 {
+			b = []byte{0, byte(typ - 7)}
+		} else {
+			b = []byte{byte(typ << 5)}
+		}
+		if size >= 29 {
+			b[0] |= 29
+			return append(b, byte(size-29))
+		}
+		b[0] |= byte(size)
+		return b
+	}
+	switch v := v.(type) {
+	case mmdbPtr:
+		return []byte{byte(mmdbPointer<<5 | v>>8), byte(v)}
+	case string:
+		return append(ctrl(mmdbString, len(v)), v...)
+	case float64:
+		return binary.BigEndian.AppendUint64(ctrl(mmdbDouble, 8), math.Float64bits(v))
+	case uint16:
+		return binary.BigEndian.AppendUint16(ctrl(mmdbUint16, 2), v)
+	case []any:
+		b := ctrl(mmdbArray, len(v))
+		for _, e := range v {
+			b = append(b, mmdbValue(e)...)
+		}
+		return b
+	case [][2]any:
+		b := ctrl(mmdbMap, len(v))
+		for _, kv := range v {
+			b = append(b, mmdbValue(kv[0])...)
+			b = append(b, mmdbValue(kv[1])...)
+		}
+		return b
+	}
+	panic("unsupported value")
+}
+
+// buildMMDB returns an IPv6 database with records of recordSize bits
+// locating each network at the data offset it maps to.
+func buildMMDB(recordSize int, networks map[string]int, data []byte) []byte {
+	const empty, leaf = -1, -2
+	type node struct{ records, offsets [2]int }
+	nodes := []node{{records: [2]int{empty, empty}}}
+	for cidr, offset := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		// IPv4 networks are under ::/96
+		ip, bits := n.IP.To16(), 0
+		if v4 := n.IP.To4(); v4 != nil {
+			ip, bits = append(make(net.IP, 12), v4...), 96
+		}
+		ones, _ := n.Mask.Size()
+		bits += ones
+		cur := 0
+		for i := 0; i < bits; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == bits-1 {
+				nodes[cur].records[bit], nodes[cur].offsets[bit] = leaf, offset
+				break
+			}
+			if nodes[cur].records[bit] == empty {
+				nodes = append(nodes, node{records: [2]int{empty, empty}})
+				nodes[cur].records[bit] = len(nodes) - 1
+			}
+			cur = nodes[cur].records[bit]
+		}
+	}
+
+	count := len(nodes)
+	var tree []byte
+	for _, n := range nodes {
+		var r [2]uint32
+		for i, rec := range n.records {
+			switch rec {
+			case empty:
+				r[i] = uint32(count)
+			case leaf:
+				r[i] = uint32(count + 16 + n.offsets[i])
+			default:
+				r[i] = uint32(rec)
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(r[0]>>16), byte(r[0]>>8), byte(r[0]), byte(r[1]>>16), byte(r[1]>>8), byte(r[1]))
+		case 28:
+			tree = append(tree, byte(r[0]>>16), byte(r[0]>>8), byte(r[0]), byte(r[0]>>20&0xF0|r[1]>>24&0x0F), byte(r[1]>>16), byte(r[1]>>8), byte(r[1]))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(tree, r[0]), r[1])
+		}
+	}
+
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, mmdbMetadataMarker...)
+	return append(buf, mmdbValue([][2]any{
+		{"node_count", uint16(count)},
+		{"record_size", uint16(recordSize)},
+		{"ip_version", uint16(6)},
+		{"database_type", "GeoLite2-City"},
+	})...)
+}
+
+// testMMDBData holds France at offset 0, and a country and a Paris city
+// record pointing at it.
+func testMMDBData() (data []byte, country, city int) {
+	data = mmdbValue([][2]any{{"iso_code", "FR"}, {"names", [][2]any{{"en", "France"}}}})
+	country = len(data)
+	data = append(data, mmdbValue([][2]any{{"country", mmdbPtr(0)}})...)
+	city = len(data)
+	data = append(data, mmdbValue([][2]any{
+		{"country", mmdbPtr(0)},
+		{"city", [][2]any{{"names", [][2]any{{"en", "Paris"}}}}},
+		{"subdivisions", []any{[][2]any{{"iso_code", "IDF"}, {"names", [][2]any{{"en", "Île-de-France"}}}}}},
+		{"location", [][2]any{{"latitude", 48.8534}, {"longitude", 2.3488}}},
+	})...)
+	return data, country, city
+}
+
+func TestMMDBLookup(t *testing.T) {
+	data, country, city := testMMDBData()
+	for _, size := range []int{24, 28, 32} {
+		db, err := newMMDB(buildMMDB(size, map[string]int{"203.0.113.0/24": city, "2001:db8::/32": country}, data))
+		if err != nil {
+			t.Fatalf("%d bit records: %v", size, err)
+		}
+		if db.Type != "GeoLite2-City" {
+			t.Errorf("%d bit records: got type %q", size, db.Type)
+		}
+
+		got, err := db.Lookup(context.Background(), "203.0.113.7")
+		want := GeoInfo{IP: "203.0.113.7", Country: "France", CountryISO: "FR", RegionName: "Île-de-France", RegionCode: "IDF", City: "Paris", Latitude: 48.8534, Longitude: 2.3488}
+		if err != nil || *got != want {
+			t.Errorf("%d bit records, IPv4: got %+v, %v", size, got, err)
+		}
+		got, err = db.Lookup(context.Background(), "2001:db8::1")
+		if err != nil || got.CountryISO != "FR" || got.City != "" {
+			t.Errorf("%d bit records, IPv6: got %+v, %v", size, got, err)
+		}
+		got, err = db.Lookup(context.Background(), "198.51.100.1")
+		if err != nil || *got != (GeoInfo{IP: "198.51.100.1"}) {
+			t.Errorf("%d bit records, not found: got %+v, %v", size, got, err)
+		}
+	}
+
+	if _, err := newMMDB([]byte("not a database")); !errors.Is(err, errMMDBCorrupt) {
+		t.Errorf("no metadata: got %v", err)
+	}
+	truncated := buildMMDB(24, map[string]int{"203.0.113.0/24": 1000}, data)
+	db, err := newMMDB(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Lookup(context.Background(), "203.0.113.7"); !errors.Is(err, errMMDBCorrupt) {
+		t.Errorf("record past the data: got %v", err)
+	}
+}
+
+func TestGeoProviderFallback(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	echoIP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GeoInfo{IP: r.URL.Query().Get("ip"), Country: "United States", CountryISO: "US"})
+	}))
+	defer echoIP.Close()
+
+	data, _, city := testMMDBData()
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	if err := os.WriteFile(path, buildMMDB(24, map[string]int{"203.0.113.0/24": city}, data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.GeoIPMMDBPath = path
+	config.EchoIPHost = echoIP.URL
+	geo, err := NewGeoProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := geo.Lookup(context.Background(), "203.0.113.7"); err != nil || got.CountryISO != "FR" {
+		t.Errorf("in the database: got %+v, %v", got, err)
+	}
+	if got, err := geo.Lookup(context.Background(), "198.51.100.1"); err != nil || got.CountryISO != "US" {
+		t.Errorf("echoip fallback: got %+v, %v", got, err)
+	}
+
+	config.EchoIPHost = ""
+	if geo, _ := NewGeoProvider(); geo == nil {
+		t.Error("no provider for the database alone")
+	}
+	config.GeoIPMMDBPath = filepath.Join(t.TempDir(), "missing.mmdb")
+	if _, err := NewGeoProvider(); err == nil {
+		t.Error("opened a missing database")
+	}
+	config.GeoIPMMDBPath = ""
+	if geo, _ := NewGeoProvider(); geo != nil {
+		t.Errorf("got provider %v without configuration", geo)
+	}
+}
@@ -0,0 +1,150 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// MobileEvent is the ingest contract for mobile SDKs, POSTed to
+// /track/mobile and documented in api/openapi.yaml. Unlike web beacons it is
+// validated strictly: unknown fields, missing required fields and values
+// out of range are rejected rather than fixed up, so SDK bugs surface early.
+type MobileEvent struct {
+	SiteID     string  `json:"site_id"`
+	Source     string  `json:"source"`
+	AppVersion string  `json:"app_version"`
+	OSVersion  string  `json:"os_version"`
+	DeviceID   string  `json:"device_id"`
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Category   string  `json:"category,omitempty"`
+	IsTablet   bool    `json:"is_tablet,omitempty"`
+	Consent    Consent `json:"consent,omitempty"`
+	// Timestamp is when the event happened, for events queued offline.
+	Timestamp time.Time `json:"timestamp,omitempty"`
+}
+
+const maxVersionLen = 32
+
+var versionPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.+_-]*$`)
+
+// mobileOS are the OS names mobile events are reported under, matching
+// what the user agent parser reports for mobile browsers.
+var mobileOS = map[string]string{
+	"ios":     useragent.IOS,
+	"android": useragent.Android,
+}
+
+// DecodeMobileEvent validates a mobile SDK event and converts it to the
+// tracking data web beacons produce. Screen views count as page views.
+func DecodeMobileEvent(b []byte) (Tracking, useragent.UserAgent, error) {
+	var ev MobileEvent
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ev); err != nil {
+		return Tracking{}, useragent.UserAgent{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	if err := ev.validate(); err != nil {
+		return Tracking{}, useragent.UserAgent{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+
+	trk := Tracking{
+		SiteID: ev.SiteID,
+		Action: TrackingData{
+			Type:       "event",
+			Identity:   ev.DeviceID,
+			Event:      ev.Name,
+			Category:   ev.Category,
+			Source:     ev.Source,
+			Consent:    ev.Consent,
+			AppVersion: ev.AppVersion,
+			OSVersion:  ev.OSVersion,
+			HappenedAt: ev.Timestamp,
+		},
+	}
+	if trk.Action.Consent == "" {
+		trk.Action.Consent = ConsentUnknown
+	}
+	if ev.Type == "screen" {
+		trk.Action.Type = PageviewType
+		trk.Action.Category = PageviewCategory
+	}
+
+	ua := useragent.UserAgent{OS: mobileOS[ev.Source], OSVersion: ev.OSVersion, Mobile: !ev.IsTablet, Tablet: ev.IsTablet}
+	return trk, ua, nil
+}
+
+// MaxBatchEvents is the most events a single /track/batch request may carry.
+const MaxBatchEvents = 1000
+
+// SplitBatch splits a JSON array of mobile events sent to /track/batch.
+// The events are left encoded, each is decoded with DecodeMobileEvent on its
+// own so one invalid event doesn't reject the others.
+func SplitBatch(b []byte) ([]json.RawMessage, error) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(b, &batch); err != nil {
+		return nil, fmt.Errorf("%w: batch must be an array of events: %v", ErrMalformedPayload, err)
+	}
+	if len(batch) == 0 {
+		return nil, fmt.Errorf("%w: empty batch", ErrMalformedPayload)
+	}
+	if len(batch) > MaxBatchEvents {
+		return nil, fmt.Errorf("%w: batch has more than %d events", ErrMalformedPayload, MaxBatchEvents)
+	}
+	return batch, nil
+}
+
+func (ev MobileEvent) validate() error {
+	required := []struct {
+		name, value string
+		max         int
+	}{
+		{"site_id", ev.SiteID, maxSiteIDLen},
+		{"device_id", ev.DeviceID, maxIdentityLen},
+		{"name", ev.Name, maxEventLen},
+		{"app_version", ev.AppVersion, maxVersionLen},
+		{"os_version", ev.OSVersion, maxVersionLen},
+	}
+	for _, f := range required {
+		if f.value == "" {
+			return fmt.Errorf("%s is required", f.name)
+		}
+		if len(f.value) > f.max || sanitizeField(f.value, f.max) != f.value {
+			return fmt.Errorf("%s must be at most %d bytes of printable UTF-8", f.name, f.max)
+		}
+	}
+	if len(ev.Category) > maxCategoryLen || sanitizeField(ev.Category, maxCategoryLen) != ev.Category {
+		return fmt.Errorf("category must be at most %d bytes of printable UTF-8", maxCategoryLen)
+	}
+
+	if _, ok := mobileOS[ev.Source]; !ok {
+		return fmt.Errorf("source must be ios or android")
+	}
+	if !versionPattern.MatchString(ev.AppVersion) || !versionPattern.MatchString(ev.OSVersion) {
+		return fmt.Errorf("app_version and os_version must be version strings such as 1.4.2")
+	}
+	if ev.Consent != "" && !consents[ev.Consent] {
+		return fmt.Errorf("consent must be granted, denied or unknown")
+	}
+	if !ev.Timestamp.IsZero() {
+		now := clock.Now()
+		if ev.Timestamp.After(now.Add(maxClientSkew)) || ev.Timestamp.Before(now.Add(-maxClientAge)) {
+			return fmt.Errorf("timestamp must be within the last %d days", int(maxClientAge.Hours()/24))
+		}
+	}
+	switch ev.Type {
+	case "screen":
+	case "event":
+		if ev.Category == "" {
+			return fmt.Errorf("category is required for events")
+		}
+	default:
+		return fmt.Errorf("type must be screen or event")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package tracker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeMobileEvent(t *testing.T) {
+	const screen = `{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"screen","name":"Home"}`
+
+	trk, ua, err := DecodeMobileEvent([]byte(screen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trk.Action.Category != "Page views" || trk.Action.Type != "page" || trk.Action.Identity != "d1" {
+		t.Errorf("screen view decoded as %+v", trk.Action)
+	}
+	if trk.Action.Source != "ios" || trk.Action.AppVersion != "2.4.1" || trk.Action.OSVersion != "17.5" {
+		t.Errorf("versions decoded as %+v", trk.Action)
+	}
+	if ua.OS != "iOS" || !ua.Mobile {
+		t.Errorf("user agent %+v", ua)
+	}
+
+	queued := Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+	trk, _, err = DecodeMobileEvent([]byte(strings.Replace(screen, `}`, `,"timestamp":"`+queued.Format(time.RFC3339)+`"}`, 1)))
+	if err != nil || !trk.Action.HappenedAt.Equal(queued) {
+		t.Errorf("timestamp decoded as %v, %v", trk.Action.HappenedAt, err)
+	}
+
+	invalid := []string{
+		`{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"screen","name":"Home","extra":1}`,
+		`{"site_id":"s","source":"web","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"screen","name":"Home"}`,
+		`{"site_id":"s","source":"ios","os_version":"17.5","device_id":"d1","type":"screen","name":"Home"}`,
+		`{"site_id":"s","source":"ios","app_version":"2 beta","os_version":"17.5","device_id":"d1","type":"screen","name":"Home"}`,
+		`{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"event","name":"share"}`,
+		`{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"tap","name":"Home"}`,
+		`{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d\u0000","type":"screen","name":"Home"}`,
+		`{"site_id":"","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"screen","name":"Home"}`,
+		`{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"screen","name":"Home","timestamp":"yesterday"}`,
+		`{"site_id":"s","source":"ios","app_version":"2.4.1","os_version":"17.5","device_id":"d1","type":"screen","name":"Home","timestamp":"2001-01-01T00:00:00Z"}`,
+		`[]`,
+	}
+	for _, payload := range invalid {
+		if _, _, err := DecodeMobileEvent([]byte(payload)); !errors.Is(err, ErrMalformedPayload) {
+			t.Errorf("%s: got %v, want ErrMalformedPayload", payload, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+)
+
+// MsgpackTypes are the Content-Types ingest bodies are decoded as MessagePack
+// for. Anything else is taken as JSON, browsers send beacons as text/plain.
+var MsgpackTypes = map[string]bool{
+	"application/msgpack":       true,
+	"application/x-msgpack":     true,
+	"application/vnd.msgpack":   true,
+	"application/x-messagepack": true,
+}
+
+// maxMsgpackDepth bounds the nesting of maps and arrays, payloads are a few
+// levels deep at most.
+const maxMsgpackDepth = 16
+
+var errMsgpackTruncated = errors.New("truncated msgpack")
+
+// BodyJSON returns the JSON payload of a request body sent with the given
+// Content-Type. MessagePack bodies and beacons are converted so every
+// payload goes through the same decoding and validation, other bodies are
+// returned as is.
+func BodyJSON(b []byte, contentType string) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == beaconText || mediaType == beaconForm:
+		return beaconJSON(b, mediaType)
+	case !MsgpackTypes[mediaType]:
+		return b, nil
+	}
+
+	v, err := DecodeMsgpack(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	return j, nil
+}
+
+// DecodeMsgpack decodes a single MessagePack value into the types
+// encoding/json decodes into: map[string]any, []any, string, float64, bool
+// and nil. Integers become float64 too when they fit, JSON has no other
+// number type. Binary and extension values are rejected, payloads carry
+// neither.
+func DecodeMsgpack(b []byte) (any, error) {
+	d := msgpackDecoder{b: b}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.b) {
+		return nil, fmt.Errorf("%d trailing bytes after msgpack value", len(d.b)-d.off)
+	}
+	return v, nil
+}
+
+type msgpackDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.off < n {
+		return nil, errMsgpackTruncated
+	}
+	p := d.b[d.off : d.off+n]
+	d.off += n
+	return p, nil
+}
+
+// uint reads a big endian unsigned integer of size bytes.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	p, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(p[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(p)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(p)), nil
+	default:
+		return binary.BigEndian.Uint64(p), nil
+	}
+}
+
+func (d *msgpackDecoder) value(depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, fmt.Errorf("msgpack nested deeper than %d levels", maxMsgpackDepth)
+	}
+	p, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	c := p[0]
+	switch {
+	case c <= 0x7f:
+		return float64(c), nil
+	case c >= 0xe0:
+		return float64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		return float64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		// Sign extend from size bytes
+		shift := 64 - 8*size
+		return float64(int64(n<<shift) >> shift), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(d.length(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(d.length(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(d.length(n), depth)
+	case 0xc4, 0xc5, 0xc6:
+		return nil, errors.New("msgpack binary values are not supported")
+	}
+	return nil, fmt.Errorf("unsupported msgpack type 0x%02x", c)
+}
+
+// length converts a declared length, one larger than the rest of the input
+// can't be satisfied and fails in next instead of allocating.
+func (d *msgpackDecoder) length(n uint64) int {
+	if n > uint64(len(d.b)) {
+		return -1
+	}
+	return int(n)
+}
+
+func (d *msgpackDecoder) str(n int) (any, error) {
+	p, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(p), nil
+}
+
+func (d *msgpackDecoder) arrayOf(n, depth int) (any, error) {
+	// Every element takes at least a byte
+	if n < 0 || n > len(d.b)-d.off {
+		return nil, errMsgpackTruncated
+	}
+	a := make([]any, n)
+	for i := range a {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) mapOf(n, depth int) (any, error) {
+	if n < 0 || 2*n > len(d.b)-d.off {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack map keys must be strings")
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,67 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestBodyJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		hex         string
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		// {"site_id":"s","n":-3,"ok":true,"v":null}
+		{"fixmap", "84a7736974655f6964a173a16ed0fda26f6bc3a176c0", "application/msgpack", `{"n":-3,"ok":true,"site_id":"s","v":null}`, false},
+		{"parameters", "81a161a162", "application/x-msgpack; charset=binary", `{"a":"b"}`, false},
+		// [1, 300, 1.5]
+		{"array", "9301cd012ccb3ff8000000000000", "application/msgpack", `[1,300,1.5]`, false},
+		// {"a": "x"} with str8 and map16
+		{"long forms", "de0001d90161d90178", "application/msgpack", `{"a":"x"}`, false},
+		{"truncated", "82a161", "application/msgpack", "", true},
+		{"trailing bytes", "c0c0", "application/msgpack", "", true},
+		{"non-string key", "810102", "application/msgpack", "", true},
+		{"binary", "c40101", "application/msgpack", "", true},
+		{"huge declared length", "dfffffffff", "application/msgpack", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := hex.DecodeString(tt.hex)
+			got, err := BodyJSON(b, tt.contentType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// JSON and text/plain bodies are passed through
+	body := []byte(`{"site_id":"s"}`)
+	for _, ct := range []string{"", "application/json", "text/plain;charset=UTF-8"} {
+		if got, err := BodyJSON(body, ct); err != nil || string(got) != string(body) {
+			t.Errorf("%q: got %s, %v", ct, got, err)
+		}
+	}
+}
+
+func TestDecodeMsgpackDepth(t *testing.T) {
+	b, _ := hex.DecodeString(strings.Repeat("91", maxMsgpackDepth+2) + "c0")
+	if _, err := DecodeMsgpack(b); err == nil {
+		t.Error("deeply nested arrays decoded")
+	}
+}
+
+func FuzzDecodeMsgpack(f *testing.F) {
+	for _, s := range []string{"84a7736974655f6964a173a16ed0fda26f6bc3a176c0", "9301cd012ccb3ff8000000000000", "dfffffffff"} {
+		b, _ := hex.DecodeString(s)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		DecodeMsgpack(b)
+	})
+}
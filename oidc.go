@@ -0,0 +1,358 @@
+package tracker
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for ID and session tokens that are malformed,
+// wrongly signed, expired or meant for someone else.
+var ErrInvalidToken = errors.New("invalid token")
+
+// jwksRefetchInterval limits how often the provider's keys are fetched again
+// when a token is signed with an unknown key, after a key rotation.
+const jwksRefetchInterval = time.Minute
+
+// OIDC signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE. Any provider publishing a discovery
+// document works: Keycloak, Auth0, Google Workspace...
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+	client   *http.Client
+
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// IDClaims are the claims of an ID token the server uses.
+type IDClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the aud claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// DiscoverOIDC reads the provider's discovery document from the issuer.
+func DiscoverOIDC(ctx context.Context, client *http.Client, issuer, clientID, clientSecret, redirectURL string) (*OIDC, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("OIDC provider issuer %q doesn't match %q", doc.Issuer, issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	return &OIDC{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		authURL:      doc.AuthURL,
+		tokenURL:     doc.TokenURL,
+		jwksURL:      doc.JWKSURL,
+		client:       client,
+	}, nil
+}
+
+// AuthCodeURL returns where to send the user to sign in. state and nonce
+// are checked on the way back, verifier is the PKCE code verifier.
+func (o *OIDC) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {o.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(o.authURL, "?") {
+		sep = "&"
+	}
+	return o.authURL + sep + v.Encode()
+}
+
+// Exchange trades the authorization code the provider redirected back with
+// for the raw ID token.
+func (o *OIDC) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"client_id":     {o.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no ID token")
+	}
+	return tokens.IDToken, nil
+}
+
+// Verify checks an ID token was signed by the provider for this client and
+// carries nonce, and returns its claims.
+func (o *OIDC) Verify(ctx context.Context, rawIDToken, nonce string, now time.Time) (IDClaims, error) {
+	var claims IDClaims
+	header, payload, signed, sig, err := splitJWT(rawIDToken)
+	if err != nil {
+		return claims, err
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err := verifyJWT(header.Alg, key, signed, sig); err != nil {
+		return claims, err
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	switch {
+	case claims.Issuer != o.Issuer:
+		return claims, fmt.Errorf("%w: issued by %q", ErrInvalidToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, o.ClientID):
+		return claims, fmt.Errorf("%w: not issued for this client", ErrInvalidToken)
+	case now.Unix() >= claims.Expiry:
+		return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return claims, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case claims.Subject == "":
+		return claims, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// key returns the provider's signing key kid, fetching the provider's keys
+// when it isn't known yet.
+func (o *OIDC) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if o.keys != nil && clock.Now().Sub(o.fetched) < jwksRefetchInterval {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, o.client, o.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+	o.keys = make(map[string]crypto.PublicKey, len(set.Keys))
+	o.fetched = clock.Now()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped, tokens signed with them fail
+		if pub, err := k.publicKey(); err == nil {
+			o.keys[k.Kid] = pub
+		}
+	}
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+}
+
+// jwk is a JSON Web Key, RSA and P-256 keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC key is not on its curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// splitJWT decodes a compact JWT into its header, payload, the signed part
+// and the signature.
+func splitJWT(token string) (header jwtHeader, payload, signed, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &header) != nil {
+		return header, nil, nil, nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	if payload, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return header, nil, nil, nil, fmt.Errorf("%w: malformed payload", ErrInvalidToken)
+	}
+	if sig, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return header, nil, nil, nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	return header, payload, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+// verifyJWT checks sig over signed for the RS256 and ES256 algorithms, the
+// ones providers sign ID tokens with.
+func verifyJWT(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if alg == "ES256" && len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(pub, digest[:], r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: bad %s signature", ErrInvalidToken, alg)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,157 @@
+package tracker
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeProvider is an OIDC provider issuing ID tokens for any code.
+type fakeProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good" || r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, "RS256", p.claims)})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) sign(t *testing.T, alg string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	p := newFakeProvider(t)
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss": p.URL, "sub": "u1", "aud": "client", "nonce": "n",
+			"exp": now.Add(time.Minute).Unix(), "iat": now.Unix(), "email": "a@example.com",
+		}
+	}
+	p.claims = valid()
+
+	ctx := context.Background()
+	o, err := DiscoverOIDC(ctx, p.Client(), p.URL+"/", "client", "secret", "http://localhost/auth/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(o.AuthCodeURL("s", "n", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("state") != "s" || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client" {
+		t.Errorf("auth URL %s", u)
+	}
+
+	raw, err := o.Exchange(ctx, "good", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := o.Verify(ctx, raw, "n", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "u1" || claims.Email != "a@example.com" {
+		t.Errorf("got %+v", claims)
+	}
+	if _, err := o.Exchange(ctx, "bad", "v"); err == nil {
+		t.Error("bad code exchanged")
+	}
+
+	tests := map[string]func(c map[string]any) (string, string){
+		"wrong nonce":    func(c map[string]any) (string, string) { return "RS256", "other" },
+		"wrong audience": func(c map[string]any) (string, string) { c["aud"] = []string{"x", "y"}; return "RS256", "n" },
+		"wrong issuer":   func(c map[string]any) (string, string) { c["iss"] = "https://evil"; return "RS256", "n" },
+		"expired":        func(c map[string]any) (string, string) { c["exp"] = now.Unix(); return "RS256", "n" },
+		"wrong alg":      func(c map[string]any) (string, string) { return "HS256", "n" },
+	}
+	for name, change := range tests {
+		c := valid()
+		alg, nonce := change(c)
+		if _, err := o.Verify(ctx, p.sign(t, alg, c), nonce, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	c := valid()
+	c["aud"] = []string{"other", "client"}
+	if _, err := o.Verify(ctx, p.sign(t, "RS256", c), "n", now); err != nil {
+		t.Errorf("audience list: %v", err)
+	}
+	tampered := raw[:len(raw)-4] + "AAAA"
+	if _, err := o.Verify(ctx, tampered, "n", now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("tampered: got %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := NewSessions("secret")
+	now := time.Now()
+	token := s.Issue(SessionClaims{Use: SessionUse, Email: "a@example.com"}, now, time.Hour)
+
+	c, err := s.Parse(token, SessionUse, now)
+	if err != nil || c.Email != "a@example.com" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+	if _, err := s.Parse(token, LoginUse, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("session used for login: %v", err)
+	}
+	if _, err := s.Parse(token, SessionUse, now.Add(time.Hour)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expired: %v", err)
+	}
+	if _, err := NewSessions("other").Parse(token, SessionUse, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("other secret: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// OriginCheck selects how strictly /track verifies that beacons of a
+// registered site come from the site's domain.
+type OriginCheck string
+
+const (
+	// OriginOff accepts beacons from anywhere.
+	OriginOff OriginCheck = "off"
+	// OriginLenient rejects beacons whose Origin or Referer names another
+	// host but accepts beacons without either, as native apps send them.
+	OriginLenient OriginCheck = "lenient"
+	// OriginStrict only accepts beacons whose Origin or Referer is the
+	// site's domain.
+	OriginStrict OriginCheck = "strict"
+)
+
+var ErrForeignOrigin = errors.New("beacon origin does not match site domain")
+
+// CheckOrigin verifies the Origin, or when absent the Referer, of a beacon
+// for site. Sites without a domain are not checked.
+func CheckOrigin(site Site, origin, referer string) error {
+	mode := config.OriginCheck
+	if site.OriginCheck != "" {
+		mode = site.OriginCheck
+	}
+	if mode == OriginOff || mode == "" || site.Domain == "" {
+		return nil
+	}
+
+	from := origin
+	if from == "" || from == "null" {
+		from = referer
+	}
+	if from == "" {
+		if mode == OriginStrict {
+			return ErrForeignOrigin
+		}
+		return nil
+	}
+
+	u, err := url.Parse(from)
+	if err != nil || !hostMatches(u.Hostname(), site.Domain) {
+		return ErrForeignOrigin
+	}
+	return nil
+}
+
+// hostMatches reports whether host is domain or one of its subdomains.
+func hostMatches(host, domain string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,38 @@
+package tracker
+
+import "testing"
+
+func TestCheckOrigin(t *testing.T) {
+	site := Site{ID: "s", Domain: "example.com"}
+	tests := []struct {
+		mode    OriginCheck
+		origin  string
+		referer string
+		ok      bool
+	}{
+		{OriginLenient, "https://example.com", "", true},
+		{OriginLenient, "https://www.example.com", "", true},
+		{OriginLenient, "https://WWW.Example.com.", "", true},
+		{OriginLenient, "", "https://blog.example.com/post", true},
+		{OriginLenient, "", "", true},
+		{OriginLenient, "null", "", true},
+		{OriginLenient, "https://evil.com", "", false},
+		{OriginLenient, "https://notexample.com", "", false},
+		{OriginLenient, "https://example.com.evil.com", "", false},
+		{OriginLenient, "", "https://evil.com/example.com", false},
+		{OriginStrict, "", "", false},
+		{OriginStrict, "https://example.com", "", true},
+		{OriginOff, "https://evil.com", "", true},
+	}
+	for _, tt := range tests {
+		s := site
+		s.OriginCheck = tt.mode
+		if err := CheckOrigin(s, tt.origin, tt.referer); (err == nil) != tt.ok {
+			t.Errorf("%s origin=%q referer=%q: got %v, want ok=%v", tt.mode, tt.origin, tt.referer, err, tt.ok)
+		}
+	}
+
+	if err := CheckOrigin(Site{ID: "s", OriginCheck: OriginStrict}, "https://evil.com", ""); err != nil {
+		t.Errorf("site without a domain was checked: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"expvar"
+	"slices"
+	"strings"
+)
+
+// Page views are the events stored with type PageviewType and category
+// PageviewCategory, as the JS tracker sends them. Stats, rollups and the
+// materialized views select page views by that category, spelled out in
+// their SQL, so it can't change without migrating stored events.
+const (
+	PageviewType     = "page"
+	PageviewCategory = "Page views"
+)
+
+// pageviewStats counts page views mapped from other trackers' names, and
+// events of PageviewType left out of page views because of their category.
+var pageviewStats = expvar.NewMap("pageviews")
+
+// NormalizePageview maps the page views of other trackers to PageviewType
+// and PageviewCategory: events whose type is one of the PAGEVIEW_TYPES or
+// whose category is one of the PAGEVIEW_CATEGORIES, ignoring case. It
+// reports whether action is a page view.
+func NormalizePageview(action *TrackingData) bool {
+	if action.Type == PageviewType && action.Category == PageviewCategory {
+		return true
+	}
+	match := func(names []string, s string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, s) })
+	}
+	if match(config.PageviewTypes, action.Type) || match(config.PageviewCategories, action.Category) {
+		action.Type, action.Category = PageviewType, PageviewCategory
+		pageviewStats.Add("mapped", 1)
+		return true
+	}
+	if action.Type == PageviewType {
+		// Most likely a tracker naming page views differently
+		pageviewStats.Add("uncounted", 1)
+	}
+	return action.Category == PageviewCategory
+}
@@ -0,0 +1,34 @@
+package tracker
+
+import "testing"
+
+func TestNormalizePageview(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+	config.PageviewTypes = []string{"pageview"}
+	config.PageviewCategories = []string{"Pages"}
+
+	tests := []struct {
+		typ, category string
+		pageview      bool
+		wantType      string
+		wantCategory  string
+	}{
+		{"page", "Page views", true, "page", "Page views"},
+		{"PageView", "", true, "page", "Page views"},
+		{"event", "pages", true, "page", "Page views"},
+		{"page", "Screens", false, "page", "Screens"},
+		{"event", "Signups", false, "event", "Signups"},
+	}
+	for _, tt := range tests {
+		action := TrackingData{Type: tt.typ, Category: tt.category}
+		if got := NormalizePageview(&action); got != tt.pageview || action.Type != tt.wantType || action.Category != tt.wantCategory {
+			t.Errorf("%s/%s: got %v %s/%s", tt.typ, tt.category, got, action.Type, action.Category)
+		}
+	}
+
+	trk, err := DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"pageview","event":"/"}}`))
+	if err != nil || trk.Action.Type != PageviewType || trk.Action.Category != PageviewCategory {
+		t.Errorf("decoded: got %+v, %v", trk.Action, err)
+	}
+}
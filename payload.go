@@ -0,0 +1,200 @@
+package tracker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum stored length in bytes of each payload field, longer values are
+// truncated.
+const (
+	maxSiteIDLen   = 128
+	maxTypeLen     = 32
+	maxIdentityLen = 256
+	maxUALen       = 512
+	maxEventLen    = 2048
+	maxCategoryLen = 256
+	maxReferrerLen = 2048
+	maxCampaignLen = 256
+)
+
+// Sources are where events can come from, beacons without one are "web".
+var Sources = map[string]bool{
+	"web":     true,
+	"ios":     true,
+	"android": true,
+	"server":  true,
+}
+
+// Touches are the values of the touch column, events from devices with a
+// touch screen and from the others.
+var Touches = map[string]bool{
+	"touch":     true,
+	"non-touch": true,
+}
+
+// ErrMalformedPayload is returned when a tracking payload can't be decoded.
+var ErrMalformedPayload = errors.New("malformed tracking payload")
+
+// DecodeData decodes the base64 encoded JSON payload GET beacons carry in
+// their data query parameter.
+func DecodeData(s string) (Tracking, error) {
+	b, err := DecodeBase64(s)
+	if err != nil {
+		return Tracking{}, err
+	}
+	return DecodePayload(b)
+}
+
+// PayloadVersion is the version of the payload format the tracker script
+// sends in its v field. Payloads without one are version 1.
+const PayloadVersion = 1
+
+// payloadDecoders decode each version of the payload format into the
+// current Tracking. Scripts cached by browsers send old versions for
+// months, a version's decoder is only removed once it stops showing up in
+// payloadVersions.
+var payloadDecoders = map[int]func(b []byte) (Tracking, error){
+	1: decodePayloadV1,
+}
+
+// payloadVersions counts the payloads decoded per format version.
+var payloadVersions = expvar.NewMap("payload_versions")
+
+// DecodePayload decodes a JSON tracking payload of any supported version.
+func DecodePayload(b []byte) (Tracking, error) {
+	var head struct {
+		V int `json:"v"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return Tracking{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	version := head.V
+	if version == 0 {
+		version = 1
+	}
+	decode, ok := payloadDecoders[version]
+	if !ok {
+		return Tracking{}, fmt.Errorf("%w: unsupported version %d", ErrMalformedPayload, head.V)
+	}
+	trk, err := decode(b)
+	if err != nil {
+		return Tracking{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	payloadVersions.Add(strconv.Itoa(version), 1)
+
+	if trk.SiteID == "" {
+		return Tracking{}, fmt.Errorf("%w: missing site_id", ErrMalformedPayload)
+	}
+
+	sanitizePayload(&trk)
+	if trk.SiteID == "" {
+		return Tracking{}, fmt.Errorf("%w: invalid site_id", ErrMalformedPayload)
+	}
+	trk.Action.Source = strings.ToLower(strings.TrimSpace(trk.Action.Source))
+	if trk.Action.Source == "" {
+		trk.Action.Source = "web"
+	} else if !Sources[trk.Action.Source] {
+		return Tracking{}, fmt.Errorf("%w: unknown source", ErrMalformedPayload)
+	}
+
+	trk.Action.Consent = Consent(strings.ToLower(strings.TrimSpace(string(trk.Action.Consent))))
+	if trk.Action.Consent == "" {
+		trk.Action.Consent = ConsentUnknown
+	} else if !consents[trk.Action.Consent] {
+		return Tracking{}, fmt.Errorf("%w: unknown consent", ErrMalformedPayload)
+	}
+	if NormalizePageview(&trk.Action) {
+		parseUTM(&trk.Action)
+	}
+	if err := validateVitals(&trk.Action); err != nil {
+		return Tracking{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	if err := validateError(&trk.Action); err != nil {
+		return Tracking{}, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+
+	// Set by the server only
+	trk.Action.ReferrerHost = ""
+	trk.Action.OccuredAt = 0
+	trk.Action.AppVersion = ""
+	trk.Action.OSVersion = ""
+	return trk, nil
+}
+
+// decodePayloadV1 decodes the version 1 format, Tracking as is.
+func decodePayloadV1(b []byte) (Tracking, error) {
+	var trk Tracking
+	err := json.Unmarshal(b, &trk)
+	return trk, err
+}
+
+// DecodeBase64 decodes a base64 payload in the standard or URL-safe
+// alphabet, with or without padding.
+func DecodeBase64(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: invalid base64", ErrMalformedPayload)
+}
+
+// sanitizePayload makes every field safe to store: valid UTF-8 without
+// control characters, bounded in length.
+func sanitizePayload(trk *Tracking) {
+	trk.SiteID = sanitizeField(trk.SiteID, maxSiteIDLen)
+	trk.Action.Type = sanitizeField(trk.Action.Type, maxTypeLen)
+	trk.Action.Identity = sanitizeField(trk.Action.Identity, maxIdentityLen)
+	trk.Action.UserAgent = sanitizeField(trk.Action.UserAgent, maxUALen)
+	trk.Action.Event = sanitizeField(trk.Action.Event, maxEventLen)
+	trk.Action.Category = sanitizeField(trk.Action.Category, maxCategoryLen)
+	trk.Action.Referrer = sanitizeField(trk.Action.Referrer, maxReferrerLen)
+	trk.Action.Campaign = strings.TrimSpace(sanitizeField(trk.Action.Campaign, maxCampaignLen))
+	trk.Action.UTMSource = sanitizeField(trk.Action.UTMSource, maxCampaignLen)
+	trk.Action.UTMMedium = sanitizeField(trk.Action.UTMMedium, maxCampaignLen)
+	trk.Action.UTMTerm = sanitizeField(trk.Action.UTMTerm, maxCampaignLen)
+	trk.Action.UTMContent = sanitizeField(trk.Action.UTMContent, maxCampaignLen)
+}
+
+func sanitizeField(s string, max int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+
+	if len(s) > max {
+		// Cut on a rune boundary
+		cut := max
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut]
+	}
+	return s
+}
+
+// ReferrerHost returns the lower-cased host of a referrer URL, "" when there
+// is none or it can't be parsed.
+func ReferrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,157 @@
+package tracker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mileusna/useragent"
+)
+
+const samplePayload = `{"tracking":{"type":"page","identity":"","ua":"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36","event":"/","category":"Page views","referrer":"","isTouchDevice":false},"site_id":"my-site-id-here"}`
+
+func TestDecodeData(t *testing.T) {
+	data, err := DecodeData(base64.StdEncoding.EncodeToString([]byte(samplePayload)))
+	if err != nil {
+		t.Fatal(err)
+	} else if data.SiteID != "my-site-id-here" {
+		t.Errorf("expected 'my-site-id-here' got %s", data.SiteID)
+	}
+}
+
+func TestDecodePayloadVersions(t *testing.T) {
+	for _, payload := range []string{
+		samplePayload,
+		`{"v":1,` + samplePayload[1:],
+	} {
+		if trk, err := DecodePayload([]byte(payload)); err != nil || trk.Action.Event != "/" {
+			t.Errorf("%s: got %+v, %v", payload[:10], trk, err)
+		}
+	}
+	for _, v := range []string{"2", "-1", `"1"`} {
+		if _, err := DecodePayload([]byte(`{"v":` + v + `,` + samplePayload[1:])); !errors.Is(err, ErrMalformedPayload) {
+			t.Errorf("version %s: got %v", v, err)
+		}
+	}
+
+	// A later version renaming event to path still yields a Tracking
+	payloadDecoders[2] = func(b []byte) (Tracking, error) {
+		var p struct {
+			SiteID string `json:"site_id"`
+			Path   string `json:"path"`
+		}
+		err := json.Unmarshal(b, &p)
+		return Tracking{SiteID: p.SiteID, Action: TrackingData{Type: PageviewType, Event: p.Path, Category: PageviewCategory}}, err
+	}
+	defer delete(payloadDecoders, 2)
+	trk, err := DecodePayload([]byte(`{"v":2,"site_id":"s","path":"/about"}`))
+	if err != nil || trk.SiteID != "s" || trk.Action.Event != "/about" {
+		t.Errorf("version 2: got %+v, %v", trk, err)
+	}
+}
+
+// checkSanitized fails when a decoded payload holds something that must not
+// reach storage.
+func checkSanitized(t *testing.T, trk Tracking) {
+	fields := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"site_id", trk.SiteID, maxSiteIDLen},
+		{"type", trk.Action.Type, maxTypeLen},
+		{"identity", trk.Action.Identity, maxIdentityLen},
+		{"ua", trk.Action.UserAgent, maxUALen},
+		{"event", trk.Action.Event, maxEventLen},
+		{"category", trk.Action.Category, maxCategoryLen},
+		{"referrer", trk.Action.Referrer, maxReferrerLen},
+	}
+	for _, f := range fields {
+		if !utf8.ValidString(f.value) {
+			t.Errorf("%s is not valid UTF-8: %q", f.name, f.value)
+		}
+		if len(f.value) > f.max {
+			t.Errorf("%s is %d bytes, max %d", f.name, len(f.value), f.max)
+		}
+		if strings.ContainsAny(f.value, "\x00\n\r\t") {
+			t.Errorf("%s contains control characters: %q", f.name, f.value)
+		}
+	}
+	if trk.Action.OccuredAt != 0 || trk.Action.ReferrerHost != "" {
+		t.Errorf("server-set fields taken from the payload: %+v", trk.Action)
+	}
+}
+
+func FuzzDecodeData(f *testing.F) {
+	f.Add(base64.StdEncoding.EncodeToString([]byte(samplePayload)))
+	f.Add(base64.RawURLEncoding.EncodeToString([]byte(samplePayload)))
+	f.Add("not base64!")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		trk, err := DecodeData(data)
+		if err != nil {
+			return
+		}
+		checkSanitized(t, trk)
+	})
+}
+
+func FuzzDecodePayload(f *testing.F) {
+	f.Add([]byte(samplePayload))
+	f.Add([]byte(`{"site_id":"s","tracking":{"event":"` + strings.Repeat("a", 5000) + `"}}`))
+	f.Add([]byte("{\"site_id\":\"s\xff\xfe\",\"tracking\":{\"ua\":\"\\u0000x\"}}"))
+	f.Add([]byte(`{"site_id":"s","tracking":{"OccuredAt":20000101,"ReferrerHost":"evil"}}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		trk, err := DecodePayload(b)
+		if err != nil {
+			return
+		}
+		if trk.SiteID == "" {
+			t.Error("accepted a payload without site_id")
+		}
+		if !Sources[trk.Action.Source] {
+			t.Errorf("accepted unknown source %q", trk.Action.Source)
+		}
+		checkSanitized(t, trk)
+	})
+}
+
+func FuzzReferrerHost(f *testing.F) {
+	f.Add("https://www.google.com/search?q=x")
+	f.Add("http://[::1]:8080/")
+	f.Add("//example.com")
+	f.Add("%zz")
+	f.Add("android-app://com.example")
+
+	f.Fuzz(func(t *testing.T, referrer string) {
+		host := ReferrerHost(referrer)
+		if host != strings.ToLower(host) {
+			t.Errorf("host %q is not lower-cased", host)
+		}
+		if strings.ContainsAny(host, "/?#") {
+			t.Errorf("host %q contains URL delimiters", host)
+		}
+	})
+}
+
+func FuzzUserAgent(f *testing.F) {
+	f.Add("Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0")
+	f.Add("")
+	f.Add(strings.Repeat("(", 10000))
+	f.Add("\xff\xfe(;;;)")
+
+	f.Fuzz(func(t *testing.T, ua string) {
+		trk := Tracking{SiteID: "s", Action: TrackingData{UserAgent: ua}}
+		sanitizePayload(&trk)
+		parsed := useragent.Parse(trk.Action.UserAgent)
+		if !utf8.ValidString(parsed.Name) || !utf8.ValidString(parsed.OS) {
+			t.Errorf("parsed UA has invalid UTF-8: %+v", parsed)
+		}
+	})
+}
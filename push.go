@@ -0,0 +1,171 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HookReportPushed is the type of the events carrying a pushed report.
+const HookReportPushed = "report.pushed"
+
+// PushSchedule is how often a report is pushed: at the start of every UTC
+// hour, day or ISO week.
+type PushSchedule string
+
+const (
+	PushHourly PushSchedule = "hourly"
+	PushDaily  PushSchedule = "daily"
+	PushWeekly PushSchedule = "weekly"
+)
+
+// period returns the start of the period now falls in.
+func (s PushSchedule) period(now time.Time) time.Time {
+	now = now.UTC()
+	switch s {
+	case PushHourly:
+		return now.Truncate(time.Hour)
+	case PushWeekly:
+		day := Bucket(TimeToInt(now), GranularityWeek)
+		return DayStart(day, time.UTC)
+	}
+	return DayStart(TimeToInt(now), time.UTC)
+}
+
+// ReportPush is the data of a report.pushed event: the stats of a report
+// for the period that just ended.
+type ReportPush struct {
+	ReportID string       `json:"reportId"`
+	Name     string       `json:"name"`
+	Start    uint32       `json:"start"`
+	End      uint32       `json:"end"`
+	Stats    *StatsResult `json:"stats"`
+}
+
+func (r Report) validatePush() error {
+	if r.PushURL == "" {
+		if r.PushSchedule != "" {
+			return fmt.Errorf("%w: pushSchedule needs a pushUrl", ErrInvalid)
+		}
+		return nil
+	}
+	if u, err := url.Parse(r.PushURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%w: pushUrl must be an http or https URL", ErrInvalid)
+	}
+	switch r.PushSchedule {
+	case PushHourly, PushDaily, PushWeekly:
+		return nil
+	}
+	return fmt.Errorf("%w: pushSchedule must be hourly, daily or weekly", ErrInvalid)
+}
+
+// ReportPusher posts the stats of reports with a PushURL to it on their
+// schedule, so other systems get them without polling. Each push carries
+// the report's stats as of the end of the period that just ended, as a
+// HookEvent whose ID is the same for every instance pushing it. Reports
+// are first pushed at the end of the period they were created or the
+// pusher started in, periods that ended while no pusher ran are skipped.
+type ReportPusher struct {
+	saved  *Saved
+	store  EventStore
+	sender hookSender
+	pushed map[string]time.Time // report ID to the period last pushed
+	log    *slog.Logger
+}
+
+// NewReportPusher returns a pusher of the reports of saved, run on store.
+// Reports are only pushed to public addresses.
+func NewReportPusher(saved *Saved, store EventStore) *ReportPusher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return errors.New("report pushes don't follow redirects")
+		},
+	}
+	return &ReportPusher{
+		saved:  saved,
+		store:  store,
+		sender: newHookSender(client),
+		pushed: make(map[string]time.Time),
+		log:    slog.Default().With(slog.String("component", "ReportPusher")),
+	}
+}
+
+// Run pushes due reports every interval until ctx is done.
+func (p *ReportPusher) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.pushDue(ctx)
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pushDue pushes the reports whose period changed since their last push
+// and returns how many it pushed.
+func (p *ReportPusher) pushDue(ctx context.Context) int {
+	now := clock.Now()
+	_, reports, _ := p.saved.all()
+	pushed := 0
+	known := make(map[string]bool, len(reports))
+	for _, rep := range reports {
+		if rep.PushURL == "" {
+			continue
+		}
+		known[rep.ID] = true
+		period := rep.PushSchedule.period(now)
+		last, ok := p.pushed[rep.ID]
+		if !ok || !period.After(last) {
+			p.pushed[rep.ID] = period
+			continue
+		}
+		// A failed push isn't retried at the next tick
+		p.pushed[rep.ID] = period
+		if err := p.push(ctx, rep, period); err != nil {
+			p.log.Error("Failed to push report", slog.String("report", rep.ID), slog.Any("error", err))
+			continue
+		}
+		pushed++
+	}
+	for id := range p.pushed {
+		if !known[id] {
+			delete(p.pushed, id)
+		}
+	}
+	return pushed
+}
+
+// push posts the stats of rep as of the end of the period before period.
+func (p *ReportPusher) push(ctx context.Context, rep Report, period time.Time) error {
+	data, err := p.saved.ReportData(rep, period.Add(-time.Nanosecond))
+	if err != nil {
+		return err
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	result, err := p.store.GetStats(queryCtx, data)
+	if err != nil {
+		return err
+	}
+	ev := HookEvent{
+		ID:     rep.ID + "/" + period.Format(time.RFC3339),
+		Type:   HookReportPushed,
+		At:     clock.Now().UTC(),
+		SiteID: rep.SiteID,
+		Data:   ReportPush{ReportID: rep.ID, Name: rep.Name, Start: data.Start, End: data.End, Stats: result},
+	}
+	return p.sender.send(rep.PushURL, ev)
+}
@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReportPusher(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	received := make(chan HookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev HookEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		received <- ev
+	}))
+	defer srv.Close()
+
+	saved := &Saved{}
+	saved.Load("")
+	if _, err := saved.PutReport(Report{SiteID: "shop", Name: "Daily visitors", Metric: QueryUniqueVisitors, RangeDays: 1, PushURL: srv.URL, PushSchedule: PushDaily}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saved.PutReport(Report{SiteID: "shop", Name: "Not pushed", Metric: QueryPageViews, RangeDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewReportPusher(saved, NewMemoryEvents())
+	p.sender.client = srv.Client()
+	ctx := context.Background()
+	if n := p.pushDue(ctx); n != 0 {
+		t.Fatalf("pushed %d reports before the day ended", n)
+	}
+
+	fc.Advance(2 * time.Minute)
+	if n := p.pushDue(ctx); n != 1 {
+		t.Fatalf("pushed %d reports once the day ended", n)
+	}
+	ev := <-received
+	var push ReportPush
+	data, _ := json.Marshal(ev.Data)
+	json.Unmarshal(data, &push)
+	if ev.Type != HookReportPushed || ev.ID != push.ReportID+"/2024-03-11T00:00:00Z" || push.Start != 20240310 || push.End != 20240310 {
+		t.Errorf("got %+v with %+v", ev, push)
+	}
+
+	fc.Advance(time.Hour)
+	if n := p.pushDue(ctx); n != 0 {
+		t.Errorf("pushed %d reports again the same day", n)
+	}
+}
+
+func TestReportPushValidation(t *testing.T) {
+	base := Report{SiteID: "shop", Name: "r", Metric: QueryPageViews, RangeDays: 1}
+	for name, push := range map[string]Report{
+		"schedule without url": {PushSchedule: PushDaily},
+		"not http":             {PushURL: "ftp://example.com/", PushSchedule: PushDaily},
+		"unknown schedule":     {PushURL: "https://example.com/hook", PushSchedule: "monthly"},
+	} {
+		rep := base
+		rep.PushURL, rep.PushSchedule = push.PushURL, push.PushSchedule
+		if err := rep.validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	base.PushURL, base.PushSchedule = "https://example.com/hook", PushWeekly
+	if err := base.validate(); err != nil {
+		t.Error(err)
+	}
+	if got := PushWeekly.period(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("weekly period: got %s", got)
+	}
+}
@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/mileusna/useragent"
+)
+
+// Qualities of events, stored in the quality column. Flagged events are
+// stored for audit, stats leave them out unless asked for them and rollups
+// never count them.
+const (
+	QualityOK = "ok"
+	// QualityBot is for bots and headless browsers, and identities or IPs
+	// tagged by VolumeGuard.
+	QualityBot = "bot"
+	// QualitySpam is for referrals from the SPAM_REFERRERS.
+	QualitySpam = "spam"
+	// QualitySelf is for the site owner's own visits, from its OwnIPs.
+	QualitySelf = "self"
+	// QualityThrottled is for the events VolumeGuard throttled.
+	QualityThrottled = "throttled"
+	// QualityTest is for the events of sites in TestMode.
+	QualityTest = "test"
+)
+
+// Qualities are the values of the quality column.
+var Qualities = map[string]bool{
+	QualityOK:        true,
+	QualityBot:       true,
+	QualitySpam:      true,
+	QualitySelf:      true,
+	QualityThrottled: true,
+	QualityTest:      true,
+}
+
+// quality returns the quality of action as stored, QualityOK when unset.
+func quality(action TrackingData) string {
+	if !Qualities[action.Quality] {
+		return QualityOK
+	}
+	return action.Quality
+}
+
+// ValidateQualities checks the qualities a stats query counts.
+func ValidateQualities(qualities []string) error {
+	for _, q := range qualities {
+		if !Qualities[q] {
+			return fmt.Errorf("%w: unknown quality %q", ErrInvalid, q)
+		}
+	}
+	return nil
+}
+
+// countedQualities are the qualities a stats query counts, only QualityOK
+// unless it lists others.
+func countedQualities(qualities []string) []string {
+	if len(qualities) == 0 {
+		return []string{QualityOK}
+	}
+	return qualities
+}
+
+// counted reports whether a stats query counting qualities counts action.
+func counted(qualities []string, action TrackingData) bool {
+	return slices.Contains(countedQualities(qualities), quality(action))
+}
+
+// Classify flags the event of action sent by ua from ip for site: all of
+// them when the site is in test mode, else visits from the site's own IPs,
+// referrals from spam domains, then bots. Events already flagged keep their
+// quality.
+func Classify(site Site, action *TrackingData, ua useragent.UserAgent, ip net.IP) {
+	switch {
+	case quality(*action) != QualityOK:
+	case site.TestMode:
+		action.Quality = QualityTest
+	case site.OwnIP(ip):
+		action.Quality = QualitySelf
+	case spamReferrer(action.ReferrerHost):
+		action.Quality = QualitySpam
+	case ua.Bot:
+		action.Quality = QualityBot
+	default:
+		return
+	}
+	qualityStats.Add(quality(*action), 1)
+}
+
+// spamReferrer reports whether host is one of the SPAM_REFERRERS or one of
+// their subdomains.
+func spamReferrer(host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, spam := range config.SpamReferrers {
+		spam = strings.ToLower(spam)
+		if host == spam || strings.HasSuffix(host, "."+spam) {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnIP reports whether ip is one of the site's OwnIPs, addresses or CIDR
+// ranges.
+func (s Site) OwnIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, own := range s.OwnIPs {
+		if _, ipNet, err := net.ParseCIDR(own); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(own)) {
+			return true
+		}
+	}
+	return false
+}
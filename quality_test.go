@@ -0,0 +1,57 @@
+package tracker
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestClassify(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.SpamReferrers = []string{"Spam.example"}
+
+	site := Site{ID: "s", OwnIPs: []string{"198.51.100.7", "203.0.113.0/24"}}
+	tests := []struct {
+		name     string
+		referrer string
+		ua       useragent.UserAgent
+		ip       string
+		want     string
+	}{
+		{"visitor", "news.example", useragent.UserAgent{Name: "Chrome"}, "192.0.2.1", QualityOK},
+		{"own IP", "", useragent.UserAgent{}, "198.51.100.7", QualitySelf},
+		{"own range", "spam.example", useragent.UserAgent{Bot: true}, "203.0.113.42", QualitySelf},
+		{"spam", "www.spam.example", useragent.UserAgent{Bot: true}, "192.0.2.1", QualitySpam},
+		{"not spam", "notspam.example", useragent.UserAgent{}, "", QualityOK},
+		{"bot", "", useragent.UserAgent{Bot: true}, "", QualityBot},
+	}
+	for _, tt := range tests {
+		action := TrackingData{ReferrerHost: tt.referrer}
+		Classify(site, &action, tt.ua, net.ParseIP(tt.ip))
+		if got := quality(action); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	throttled := TrackingData{Quality: QualityThrottled}
+	Classify(site, &throttled, useragent.UserAgent{Bot: true}, nil)
+	if throttled.Quality != QualityThrottled {
+		t.Errorf("flagged event reclassified as %q", throttled.Quality)
+	}
+	soft := site
+	soft.TestMode = true
+	action := TrackingData{}
+	Classify(soft, &action, useragent.UserAgent{Bot: true}, net.ParseIP("198.51.100.7"))
+	if action.Quality != QualityTest {
+		t.Errorf("test mode: got %q", action.Quality)
+	}
+	if counted(nil, action) || !counted([]string{QualityOK, QualityTest}, action) {
+		t.Error("test events counted by default")
+	}
+	if err := ValidateQualities([]string{QualityOK, "suspect"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown quality: got %v", err)
+	}
+}
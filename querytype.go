@@ -0,0 +1,82 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// queryNames are the identifiers clients use for metrics. Unlike the
+// QueryType values they don't depend on declaration order, so new metrics
+// can be added anywhere.
+var queryNames = map[QueryType]string{
+	QueryPageViews:       "pageviews",
+	QueryPageViewList:    "pages",
+	QueryUniqueVisitors:  "visitors",
+	QueryReferrerHost:    "referrer_hosts",
+	QueryReferrer:        "referrers",
+	QueryBrowsers:        "browsers",
+	QueryOSes:            "oses",
+	QueryCountry:         "countries",
+	QueryTouch:           "touch",
+	QueryCampaigns:       "campaigns",
+	QueryEngines:         "engines",
+	QueryUTMSource:       "utm_sources",
+	QueryUTMMedium:       "utm_mediums",
+	QueryUTMTerm:         "utm_terms",
+	QueryUTMContent:      "utm_contents",
+	QuerySessions:        "sessions",
+	QueryBounceRate:      "bounce_rate",
+	QuerySessionDuration: "session_duration",
+}
+
+func (q QueryType) String() string {
+	if name, ok := queryNames[q]; ok {
+		return name
+	}
+	return strconv.Itoa(int(q))
+}
+
+// ParseQueryType returns the QueryType with that name.
+func ParseQueryType(name string) (QueryType, error) {
+	for q, n := range queryNames {
+		if n == name {
+			return q, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metric %q", name)
+}
+
+func (q QueryType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.String())
+}
+
+// UnmarshalJSON accepts metric names. Integers are still accepted but
+// deprecated, they will be rejected in a future release.
+func (q *QueryType) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var name string
+		if err := json.Unmarshal(b, &name); err != nil {
+			return err
+		}
+		parsed, err := ParseQueryType(name)
+		if err != nil {
+			return err
+		}
+		*q = parsed
+		return nil
+	}
+
+	n, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return fmt.Errorf("invalid metric %s", b)
+	}
+	if _, ok := queryNames[QueryType(n)]; !ok {
+		return fmt.Errorf("unknown metric %d", n)
+	}
+	slog.Warn("Numeric metric identifiers are deprecated, use the metric name", slog.Int("what", n), slog.String("name", QueryType(n).String()))
+	*q = QueryType(n)
+	return nil
+}
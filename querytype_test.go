@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQueryTypeJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    QueryType
+		wantErr bool
+	}{
+		{in: `{"what":"browsers"}`, want: QueryBrowsers},
+		{in: `{"what":"countries"}`, want: QueryCountry},
+		{in: `{"what":5}`, want: QueryBrowsers},
+		{in: `{"what":"nope"}`, wantErr: true},
+		{in: `{"what":99}`, wantErr: true},
+		{in: `{"what":true}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var data MetricData
+		err := json.Unmarshal([]byte(tt.in), &data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && data.What != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.in, data.What, tt.want)
+		}
+	}
+
+	for q := range queryNames {
+		b, err := json.Marshal(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back QueryType
+		if err := json.Unmarshal(b, &back); err != nil || back != q {
+			t.Errorf("%v does not round trip: %s, %v", q, b, err)
+		}
+	}
+}
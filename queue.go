@@ -0,0 +1,22 @@
+package tracker
+
+import (
+	"context"
+	"time"
+)
+
+// QueueStats describes the events a store accepted but hasn't written yet.
+type QueueStats struct {
+	Length    int
+	OldestAge time.Duration
+	// Spilled events wait on disk until the queue has room
+	Spilled int
+}
+
+// Queued is implemented by stores that queue events before writing them.
+// The admin port uses it to check the pipeline and flush before maintenance.
+type Queued interface {
+	QueueStats() QueueStats
+	// Flush writes the queued events now.
+	Flush(ctx context.Context) error
+}
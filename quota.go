@@ -0,0 +1,184 @@
+package tracker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type QuotaMode string
+
+const (
+	// QuotaOff only counts events.
+	QuotaOff QuotaMode = "off"
+	// QuotaSoft accepts events over the quota and reports the overage.
+	QuotaSoft QuotaMode = "soft"
+	// QuotaHard rejects events once the quota is used up.
+	QuotaHard QuotaMode = "hard"
+)
+
+// Usage is a site's event count for one month against its quota.
+type Usage struct {
+	SiteID string    `json:"siteId"`
+	Month  string    `json:"month"`
+	Events uint64    `json:"events"`
+	Limit  uint64    `json:"limit"`
+	Mode   QuotaMode `json:"mode"`
+	Over   bool      `json:"over"`
+}
+
+// Quotas counts ingested events per site and month and enforces the monthly
+// quota. Counters are kept in memory and periodically written to a JSON file.
+type Quotas struct {
+	lock   sync.Mutex
+	path   string
+	sites  *Sites
+	counts map[string]map[string]uint64 // site -> month (200601) -> events
+	dirty  bool
+	hooks  *Webhooks
+	log    *slog.Logger
+}
+
+func quotaMonth(t time.Time) string {
+	return t.UTC().Format("200601")
+}
+
+// Load restores counters from path, an empty path keeps them in memory only.
+// Per-site limits are read from sites, falling back to the configured default.
+func (q *Quotas) Load(path string, sites *Sites) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.log = slog.Default().With(slog.String("component", "Quotas"))
+	q.path = path
+	q.sites = sites
+	q.counts = make(map[string]map[string]uint64)
+	if path == "" {
+		return nil
+	}
+
+	return readJSONFile(path, &q.counts)
+}
+
+func (q *Quotas) limit(siteID string) uint64 {
+	if site, ok := q.sites.Get(siteID); ok && site.MonthlyQuota > 0 {
+		return site.MonthlyQuota
+	}
+	return config.DefaultMonthlyQuota
+}
+
+// Exceeded reports whether an event for siteID must be rejected, which only
+// happens in hard mode.
+func (q *Quotas) Exceeded(siteID string, now time.Time) bool {
+	if config.QuotaMode != QuotaHard {
+		return false
+	}
+	limit := q.limit(siteID)
+	if limit == 0 {
+		return false
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.counts[siteID][quotaMonth(now)] >= limit
+}
+
+// Record counts an accepted event and returns whether the site is now over
+// its quota.
+func (q *Quotas) Record(siteID string, now time.Time) bool {
+	limit := q.limit(siteID)
+	month := quotaMonth(now)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	months, ok := q.counts[siteID]
+	if !ok {
+		months = make(map[string]uint64)
+		q.counts[siteID] = months
+	}
+	months[month]++
+	q.dirty = true
+
+	if limit > 0 && q.hooks != nil {
+		for _, percent := range config.WebhookQuotaPercent {
+			// Fired once, by the event reaching the threshold
+			if n := months[month]; n*100 >= percent*limit && (n-1)*100 < percent*limit {
+				q.hooks.Fire(HookQuotaThreshold, siteID, QuotaCrossing{Month: month, Percent: percent, Events: n, Limit: limit})
+			}
+		}
+	}
+	return limit > 0 && months[month] > limit
+}
+
+// SetWebhooks fires a quota.threshold event when a site's usage reaches
+// one of WEBHOOK_QUOTA_PERCENT of its limit.
+func (q *Quotas) SetWebhooks(h *Webhooks) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.hooks = h
+}
+
+// Usage returns the counters of siteID for the month containing t.
+func (q *Quotas) Usage(siteID string, t time.Time) Usage {
+	limit := q.limit(siteID)
+	month := quotaMonth(t)
+
+	q.lock.Lock()
+	events := q.counts[siteID][month]
+	q.lock.Unlock()
+
+	return Usage{
+		SiteID: siteID,
+		Month:  month,
+		Events: events,
+		Limit:  limit,
+		Mode:   config.QuotaMode,
+		Over:   limit > 0 && events > limit,
+	}
+}
+
+// Forget drops the counters of siteID, after its deletion.
+func (q *Quotas) Forget(siteID string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.counts[siteID]; ok {
+		delete(q.counts, siteID)
+		q.dirty = true
+	}
+}
+
+// Run persists the counters every interval until ctx is done. Call Save once
+// more on shutdown to keep the last increments.
+func (q *Quotas) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := q.Save(); err != nil {
+				q.log.Error("Failed to persist quota counters", slog.Any("error", err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Save writes the counters to disk if they changed since the last save.
+func (q *Quotas) Save() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.path == "" || !q.dirty {
+		return nil
+	}
+
+	if err := writeJSONFile(q.path, q.counts); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
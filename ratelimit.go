@@ -0,0 +1,117 @@
+package tracker
+
+import (
+	"sync"
+	"time"
+)
+
+// RateStatus is where a principal stands against its stats API limits
+// after a request: the queries left in the current minute and UTC day, and
+// when they reset. A zero limit is no limit.
+type RateStatus struct {
+	Allowed        bool
+	Limit          int
+	Remaining      int
+	Reset          time.Time
+	DailyLimit     int
+	DailyRemaining int
+	DailyReset     time.Time
+}
+
+// RetryAfter returns how long a rejected principal has to wait.
+func (s RateStatus) RetryAfter(now time.Time) time.Duration {
+	if s.DailyLimit > 0 && s.DailyRemaining == 0 {
+		return s.DailyReset.Sub(now)
+	}
+	return s.Reset.Sub(now)
+}
+
+type rateCounter struct {
+	minute  time.Time
+	inMin   int
+	day     uint32
+	inDay   int
+	touched time.Time
+}
+
+// RateLimiter counts the stats queries of every principal in fixed windows
+// of a minute and of a UTC day, so a runaway integration can't monopolize
+// ClickHouse. Superusers aren't limited.
+type RateLimiter struct {
+	lock     sync.Mutex
+	counters map[string]*rateCounter
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{counters: make(map[string]*rateCounter)}
+}
+
+// limits returns the queries p may run per minute and per day, its key's
+// own limits or STATS_RATE_LIMIT and STATS_DAILY_QUERIES.
+func (p Principal) limits() (perMinute, perDay int) {
+	perMinute, perDay = config.StatsRateLimit, config.StatsDailyQueries
+	if p.RateLimit > 0 {
+		perMinute = p.RateLimit
+	}
+	if p.DailyQueries > 0 {
+		perDay = p.DailyQueries
+	}
+	return perMinute, perDay
+}
+
+// Take counts a query of p at now unless it is over one of its limits.
+func (l *RateLimiter) Take(p Principal, now time.Time) RateStatus {
+	perMinute, perDay := p.limits()
+	now = now.UTC()
+	minute := now.Truncate(time.Minute)
+	day := TimeToInt(now)
+	status := RateStatus{
+		Allowed:    true,
+		Limit:      perMinute,
+		Reset:      minute.Add(time.Minute),
+		DailyLimit: perDay,
+		DailyReset: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+	}
+	if p.Superuser || (perMinute == 0 && perDay == 0) {
+		return status
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	c, ok := l.counters[p.Name]
+	if !ok {
+		l.prune(now)
+		c = &rateCounter{}
+		l.counters[p.Name] = c
+	}
+	if !c.minute.Equal(minute) {
+		c.minute, c.inMin = minute, 0
+	}
+	if c.day != day {
+		c.day, c.inDay = day, 0
+	}
+	c.touched = now
+
+	status.Allowed = (perMinute == 0 || c.inMin < perMinute) && (perDay == 0 || c.inDay < perDay)
+	if status.Allowed {
+		c.inMin++
+		c.inDay++
+	}
+	if perMinute > 0 {
+		status.Remaining = perMinute - c.inMin
+	}
+	if perDay > 0 {
+		status.DailyRemaining = perDay - c.inDay
+	}
+	return status
+}
+
+// prune forgets principals idle for more than a day, the lock must be held.
+func (l *RateLimiter) prune(now time.Time) {
+	for name, c := range l.counters {
+		if now.Sub(c.touched) > 24*time.Hour {
+			delete(l.counters, name)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+	config.StatsRateLimit = 2
+	config.StatsDailyQueries = 3
+
+	l := NewRateLimiter()
+	key := Principal{Name: "ci"}
+	now := time.Date(2024, 3, 10, 23, 58, 30, 0, time.UTC)
+
+	for i, want := range []bool{true, true, false} {
+		if s := l.Take(key, now); s.Allowed != want {
+			t.Errorf("query %d: got %+v", i, s)
+		}
+	}
+	s := l.Take(key, now)
+	if s.Remaining != 0 || s.DailyRemaining != 1 || s.RetryAfter(now) != 30*time.Second {
+		t.Errorf("over the rate: got %+v", s)
+	}
+
+	// A new minute, but only one query left today
+	now = now.Add(time.Minute)
+	if s := l.Take(key, now); !s.Allowed || s.DailyRemaining != 0 {
+		t.Errorf("next minute: got %+v", s)
+	}
+	if s := l.Take(key, now); s.Allowed || s.RetryAfter(now) != 30*time.Second {
+		t.Errorf("over the quota: got %+v, retry after %s", s, s.RetryAfter(now))
+	}
+	if s := l.Take(key, now.Add(time.Minute)); !s.Allowed || s.DailyRemaining != 2 {
+		t.Errorf("next day: got %+v", s)
+	}
+
+	// Keys' own limits and superusers
+	if s := l.Take(Principal{Name: "big", RateLimit: 100}, now); s.Limit != 100 || s.Remaining != 99 {
+		t.Errorf("own limit: got %+v", s)
+	}
+	for i := 0; i < 5; i++ {
+		if s := l.Take(Principal{Name: "root", Superuser: true}, now); !s.Allowed {
+			t.Fatalf("superuser limited: %+v", s)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role is what an API key may do with a site. Each role includes the ones
+// below it.
+type Role string
+
+const (
+	// RoleViewer reads stats, segments and reports.
+	RoleViewer Role = "viewer"
+	// RoleAdmin also manages segments and reports.
+	RoleAdmin Role = "admin"
+	// RoleOwner also reads billing usage and the audit log.
+	RoleOwner Role = "owner"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleAdmin: 2, RoleOwner: 3}
+
+// Includes reports whether r allows everything role allows.
+func (r Role) Includes(role Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[role]
+}
+
+// Grant gives a role on one site, or on every site of an org.
+type Grant struct {
+	Site string `json:"site,omitempty"`
+	Org  string `json:"org,omitempty"`
+	Role Role   `json:"role"`
+}
+
+// Principal is who made an API request.
+type Principal struct {
+	Name string
+	// Superuser is the holder of API_KEY or a user marked so, allowed
+	// everything including the operations that aren't about a site.
+	Superuser bool
+	Grants    []Grant
+
+	// RateLimit and DailyQueries override STATS_RATE_LIMIT and
+	// STATS_DAILY_QUERIES for this principal when set.
+	RateLimit    int
+	DailyQueries int
+}
+
+// Can reports whether p has role on site.
+func (p Principal) Can(site Site, role Role) bool {
+	if p.Superuser {
+		return true
+	}
+	for _, g := range p.Grants {
+		if !g.Role.Includes(role) {
+			continue
+		}
+		if (g.Site != "" && g.Site == site.ID) || (g.Org != "" && g.Org == site.Org) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAny reports whether p has role on at least one site.
+func (p Principal) CanAny(role Role) bool {
+	if p.Superuser {
+		return true
+	}
+	for _, g := range p.Grants {
+		if g.Role.Includes(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a key for the stats and admin API. Only the key's SHA-256 is
+// stored, see HashKey.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	KeyHash   string    `json:"keyHash"`
+	Grants    []Grant   `json:"grants"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// RateLimit is the stats queries per minute and DailyQueries the stats
+	// queries per UTC day the key may run, 0 keeps the configured default.
+	RateLimit    int `json:"rateLimit,omitempty"`
+	DailyQueries int `json:"dailyQueries,omitempty"`
+
+	// SigningSecrets sign requests made without the key, see SignRequest.
+	// A key without KeyHash can only sign.
+	SigningSecrets []SigningSecret `json:"signingSecrets,omitempty"`
+}
+
+// principal returns who makes requests with the key.
+func (k APIKey) principal() Principal {
+	name := k.Name
+	if name == "" {
+		name = k.ID
+	}
+	return Principal{Name: name, Grants: k.Grants, RateLimit: k.RateLimit, DailyQueries: k.DailyQueries}
+}
+
+// HashKey returns the hash of a key as stored in APIKey.KeyHash.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Keys is the registry of API keys, read from a JSON file.
+type Keys struct {
+	lock sync.RWMutex
+	keyIndex
+	path string
+}
+
+// keyIndex finds keys by ID, by hash and by the IDs of their signing
+// secrets.
+type keyIndex struct {
+	byID     map[string]APIKey
+	byHash   map[string]APIKey
+	bySecret map[string]signer
+}
+
+func newKeyIndex(list []APIKey) (keyIndex, error) {
+	index := keyIndex{
+		byID:     make(map[string]APIKey, len(list)),
+		byHash:   make(map[string]APIKey, len(list)),
+		bySecret: make(map[string]signer),
+	}
+	for _, key := range list {
+		index.byID[key.ID] = key
+		if key.KeyHash != "" {
+			index.byHash[key.KeyHash] = key
+		}
+		for _, s := range key.SigningSecrets {
+			if s.ID == "" || s.Secret == "" {
+				return keyIndex{}, fmt.Errorf("key %s: signing secrets need an id and a secret", key.ID)
+			}
+			if _, ok := index.bySecret[s.ID]; ok {
+				return keyIndex{}, fmt.Errorf("key %s: duplicate signing secret id %s", key.ID, s.ID)
+			}
+			index.bySecret[s.ID] = signer{key: key, secret: s.Secret}
+		}
+	}
+	return index, nil
+}
+
+// Load reads the registry from path. A missing file or an empty path leaves
+// it empty, only API_KEY is accepted then.
+func (k *Keys) Load(path string) error {
+	var list []APIKey
+	if path != "" {
+		if err := readJSONFile(path, &list); err != nil {
+			return err
+		}
+	}
+
+	index, err := newKeyIndex(list)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keyIndex = index
+	k.path = path
+	return nil
+}
+
+// Lookup returns the principal of key.
+func (k *Keys) Lookup(key string) (Principal, bool) {
+	if key == "" {
+		return Principal{}, false
+	}
+
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	apiKey, ok := k.byHash[HashKey(key)]
+	if !ok {
+		return Principal{}, false
+	}
+	return apiKey.principal(), true
+}
+
+// Len returns the number of keys.
+func (k *Keys) Len() int {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return len(k.byID)
+}
+
+// RevokeSite removes the grants on siteID from every key and returns how
+// many there were. Grants on the site's org are kept, they cover other
+// sites.
+func (k *Keys) RevokeSite(siteID string) (int, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	revoked := 0
+	list := make([]APIKey, 0, len(k.byID))
+	for _, key := range k.byID {
+		grants := slices.DeleteFunc(slices.Clone(key.Grants), func(g Grant) bool { return g.Site == siteID })
+		revoked += len(key.Grants) - len(grants)
+		key.Grants = grants
+		list = append(list, key)
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	index, err := newKeyIndex(list)
+	if err != nil {
+		return 0, err
+	}
+	if k.path != "" {
+		if err := writeJSONFile(k.path, list); err != nil {
+			return 0, err
+		}
+	}
+	k.keyIndex = index
+	return revoked, nil
+}
+
+// User is someone signing in with OIDC, identified by their email.
+type User struct {
+	Email     string  `json:"email"`
+	Superuser bool    `json:"superuser,omitempty"`
+	Grants    []Grant `json:"grants"`
+}
+
+// Users is the registry of users' roles, read from a JSON file.
+type Users struct {
+	lock    sync.RWMutex
+	byEmail map[string]User
+}
+
+// Load reads the registry from path. A missing file or an empty path leaves
+// it empty, users can sign in but aren't granted anything.
+func (u *Users) Load(path string) error {
+	var list []User
+	if path != "" {
+		if err := readJSONFile(path, &list); err != nil {
+			return err
+		}
+	}
+
+	byEmail := make(map[string]User, len(list))
+	for _, user := range list {
+		byEmail[strings.ToLower(user.Email)] = user
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.byEmail = byEmail
+	return nil
+}
+
+// Lookup returns the principal of the user with email, with no grants when
+// the user isn't registered.
+func (u *Users) Lookup(email string) Principal {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	user := u.byEmail[strings.ToLower(email)]
+	return Principal{Name: email, Superuser: user.Superuser, Grants: user.Grants}
+}
@@ -0,0 +1,160 @@
+package tracker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPrincipalCan(t *testing.T) {
+	site := Site{ID: "a", Org: "acme"}
+	other := Site{ID: "b", Org: "other"}
+
+	principals := map[string]Principal{
+		"viewer":    {Grants: []Grant{{Site: "a", Role: RoleViewer}}},
+		"admin":     {Grants: []Grant{{Site: "a", Role: RoleAdmin}}},
+		"owner":     {Grants: []Grant{{Site: "a", Role: RoleOwner}}},
+		"org admin": {Grants: []Grant{{Org: "acme", Role: RoleAdmin}}},
+		"bad role":  {Grants: []Grant{{Site: "a", Role: "root"}}},
+		"superuser": {Superuser: true},
+		"none":      {},
+	}
+	// Whether each principal has viewer, admin and owner on site
+	tests := []struct {
+		principal string
+		want      [3]bool
+	}{
+		{"viewer", [3]bool{true, false, false}},
+		{"admin", [3]bool{true, true, false}},
+		{"owner", [3]bool{true, true, true}},
+		{"org admin", [3]bool{true, true, false}},
+		{"bad role", [3]bool{false, false, false}},
+		{"superuser", [3]bool{true, true, true}},
+		{"none", [3]bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		p := principals[tt.principal]
+		for i, role := range []Role{RoleViewer, RoleAdmin, RoleOwner} {
+			if got := p.Can(site, role); got != tt.want[i] {
+				t.Errorf("%s as %s: got %v, want %v", tt.principal, role, got, tt.want[i])
+			}
+			if got := p.CanAny(role); got != tt.want[i] {
+				t.Errorf("%s as %s on any site: got %v, want %v", tt.principal, role, got, tt.want[i])
+			}
+		}
+		if !p.Superuser && p.Can(other, RoleViewer) {
+			t.Errorf("%s can view a site it has no grant on", tt.principal)
+		}
+	}
+}
+
+func TestKeysLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"id":"k1","name":"ci","keyHash":"` + HashKey("secret") + `","grants":[{"site":"a","role":"viewer"}]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys Keys
+	if err := keys.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := keys.Lookup("secret")
+	if !ok || p.Name != "ci" || p.Superuser || !p.Can(Site{ID: "a"}, RoleViewer) {
+		t.Errorf("got %+v, %v", p, ok)
+	}
+	for _, key := range []string{"", "wrong", HashKey("secret")} {
+		if _, ok := keys.Lookup(key); ok {
+			t.Errorf("%q accepted", key)
+		}
+	}
+}
+
+func TestKeysRevokeSite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"id":"k1","name":"ci","keyHash":"` + HashKey("secret") + `","grants":[{"site":"a","role":"owner"},{"site":"b","role":"viewer"},{"org":"acme","role":"viewer"}]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var keys Keys
+	if err := keys.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := keys.RevokeSite("a"); n != 1 || err != nil {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if n, _ := keys.RevokeSite("a"); n != 0 {
+		t.Errorf("revoked %d grants again", n)
+	}
+	// Reloaded from the file
+	if err := keys.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := keys.Lookup("secret")
+	if p.Can(Site{ID: "a"}, RoleViewer) || !p.Can(Site{ID: "b"}, RoleViewer) || !p.Can(Site{ID: "c", Org: "acme"}, RoleViewer) {
+		t.Errorf("got grants %+v", p.Grants)
+	}
+}
+
+func TestKeysVerifyRequest(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.SignatureMaxAge = time.Minute
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"id":"svc","grants":[{"site":"a","role":"viewer"}],
+		"signingSecrets":[{"id":"2024-01","secret":"old"},{"id":"2024-06","secret":"new"}]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var keys Keys
+	if err := keys.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"siteId":"a"}`)
+	request := func(id, secret string, ts int64, body []byte) *http.Request {
+		r := httptest.NewRequest("POST", "/stats?x=1", nil)
+		r.Header.Set(KeyIDHeader, id)
+		r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(ts, 10))
+		r.Header.Set(SignatureHeader, SignRequest(secret, "POST", "/stats?x=1", ts, body))
+		return r
+	}
+
+	// Both secrets are valid while rotating
+	for _, s := range []SigningSecret{{"2024-01", "old"}, {"2024-06", "new"}} {
+		p, err := keys.VerifyRequest(request(s.ID, s.Secret, now.Unix(), body), body, now)
+		if err != nil || p.Name != "svc" || !p.Can(Site{ID: "a"}, RoleViewer) {
+			t.Errorf("%s: got %+v, %v", s.ID, p, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want error
+	}{
+		{"unknown id", request("other", "new", now.Unix(), body), ErrBadSignature},
+		{"other secret", request("2024-06", "old", now.Unix(), body), ErrBadSignature},
+		{"other body", request("2024-06", "new", now.Unix(), []byte(`{"siteId":"b"}`)), ErrBadSignature},
+		{"stale", request("2024-06", "new", now.Add(-2*time.Minute).Unix(), body), ErrStaleSignature},
+		{"no timestamp", httptest.NewRequest("POST", "/stats", nil), ErrUnsigned},
+	}
+	for _, tt := range tests {
+		if _, err := keys.VerifyRequest(tt.r, body, now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Signing only keys have no key to look up
+	if _, ok := keys.Lookup(""); ok {
+		t.Error("empty key accepted")
+	}
+}
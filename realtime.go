@@ -0,0 +1,227 @@
+package tracker
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// Realtime counts cover the last realtimeMinutes minutes, kept per minute so
+// counts of older minutes drop off as time passes. A minute keeps at most
+// realtimeKeys distinct values per dimension, the rest are counted as
+// RealtimeOther.
+const (
+	realtimeMinutes = 30
+	realtimeKeys    = 1000
+)
+
+// RealtimeOther is the value counting page views whose value didn't fit in
+// a minute.
+const RealtimeOther = "(other)"
+
+// RealtimeCount is a value and its page views.
+type RealtimeCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// RealtimeTops are the page views of a site over the last 30 minutes, and
+// its top pages, referrer domains and countries.
+type RealtimeTops struct {
+	SiteID    string          `json:"siteId"`
+	Since     time.Time       `json:"since"`
+	Pageviews uint64          `json:"pageviews"`
+	Events    uint64          `json:"events"`
+	Pages     []RealtimeCount `json:"pages"`
+	Referrers []RealtimeCount `json:"referrers"`
+	Countries []RealtimeCount `json:"countries"`
+}
+
+// realtimeMinute holds the counts of a site for one minute.
+type realtimeMinute struct {
+	start     time.Time
+	pageviews uint64
+	events    uint64
+	pages     map[string]uint64
+	referrers map[string]uint64
+	countries map[string]uint64
+}
+
+func newRealtimeMinute(start time.Time) *realtimeMinute {
+	return &realtimeMinute{
+		start:     start,
+		pages:     make(map[string]uint64),
+		referrers: make(map[string]uint64),
+		countries: make(map[string]uint64),
+	}
+}
+
+func countRealtime(counts map[string]uint64, value string) {
+	if _, ok := counts[value]; !ok && len(counts) >= realtimeKeys {
+		value = RealtimeOther
+	}
+	counts[value]++
+}
+
+// Realtime keeps rolling counts of the events added to the store, in
+// memory, so the realtime feed never queries the database. Each instance
+// only counts the events it ingested.
+type Realtime struct {
+	lock  sync.Mutex
+	sites map[string][]*realtimeMinute // site -> minutes, oldest first
+}
+
+func NewRealtime() *Realtime {
+	return &Realtime{sites: make(map[string][]*realtimeMinute)}
+}
+
+// Observe counts an event stored at now, flagged events aren't.
+func (r *Realtime) Observe(trk Tracking, geo *GeoInfo, now time.Time) {
+	if quality(trk.Action) != QualityOK {
+		return
+	}
+	start := now.UTC().Truncate(time.Minute)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	minutes := r.sites[trk.SiteID]
+	if n := len(minutes); n == 0 || minutes[n-1].start.Before(start) {
+		minutes = append(expireMinutes(minutes, start), newRealtimeMinute(start))
+		r.sites[trk.SiteID] = minutes
+	}
+	m := minutes[len(minutes)-1]
+	if trk.Action.Category != PageviewCategory {
+		m.events++
+		return
+	}
+	m.pageviews++
+	countRealtime(m.pages, trk.Action.Event)
+	if trk.Action.ReferrerHost != "" {
+		countRealtime(m.referrers, trk.Action.ReferrerHost)
+	}
+	if geo != nil && geo.Country != "" {
+		countRealtime(m.countries, geo.Country)
+	}
+}
+
+// expireMinutes drops the minutes that are out of the window at now.
+func expireMinutes(minutes []*realtimeMinute, now time.Time) []*realtimeMinute {
+	since := now.Add(-(realtimeMinutes - 1) * time.Minute)
+	i := 0
+	for i < len(minutes) && minutes[i].start.Before(since) {
+		i++
+	}
+	return minutes[i:]
+}
+
+// Top returns the page views and events of siteID over the last 30 minutes
+// at now, and its top n pages, referrer domains and countries.
+func (r *Realtime) Top(siteID string, n int, now time.Time) RealtimeTops {
+	start := now.UTC().Truncate(time.Minute)
+	tops := RealtimeTops{SiteID: siteID, Since: start.Add(-(realtimeMinutes - 1) * time.Minute)}
+	pages := make(map[string]uint64)
+	referrers := make(map[string]uint64)
+	countries := make(map[string]uint64)
+
+	r.lock.Lock()
+	minutes := expireMinutes(r.sites[siteID], start)
+	if len(minutes) == 0 {
+		delete(r.sites, siteID)
+	} else {
+		r.sites[siteID] = minutes
+	}
+	for _, m := range minutes {
+		tops.Pageviews += m.pageviews
+		tops.Events += m.events
+		for v, c := range m.pages {
+			pages[v] += c
+		}
+		for v, c := range m.referrers {
+			referrers[v] += c
+		}
+		for v, c := range m.countries {
+			countries[v] += c
+		}
+	}
+	r.lock.Unlock()
+
+	tops.Pages = topCounts(pages, n)
+	tops.Referrers = topCounts(referrers, n)
+	tops.Countries = topCounts(countries, n)
+	return tops
+}
+
+// Forget drops the counts of siteID, after its deletion.
+func (r *Realtime) Forget(siteID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.sites, siteID)
+}
+
+// topCounts returns the n values counted most, ties by value.
+func topCounts(counts map[string]uint64, n int) []RealtimeCount {
+	list := make([]RealtimeCount, 0, len(counts))
+	for v, c := range counts {
+		list = append(list, RealtimeCount{Value: v, Count: c})
+	}
+	slices.SortFunc(list, func(a, b RealtimeCount) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return list[:min(n, len(list))]
+}
+
+// Run forgets the sites without events in the window every interval until
+// ctx is done.
+func (r *Realtime) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			r.expire(clock.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Realtime) expire(now time.Time) {
+	start := now.UTC().Truncate(time.Minute)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for site, minutes := range r.sites {
+		if minutes = expireMinutes(minutes, start); len(minutes) == 0 {
+			delete(r.sites, site)
+		} else {
+			r.sites[site] = minutes
+		}
+	}
+}
+
+// Wrap returns store, counting the events added to it once they are.
+func (r *Realtime) Wrap(store EventStore) EventStore {
+	return &realtimeStore{EventStore: store, realtime: r}
+}
+
+type realtimeStore struct {
+	EventStore
+	realtime *Realtime
+}
+
+func (s *realtimeStore) Add(ctx context.Context, trk Tracking, ua useragent.UserAgent, geo *GeoInfo) error {
+	if err := s.EventStore.Add(ctx, trk, ua, geo); err != nil {
+		return err
+	}
+	s.realtime.Observe(trk, geo, clock.Now())
+	return nil
+}
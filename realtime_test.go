@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestRealtime(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 30, 0, time.UTC))
+	defer SetClock(fc)()
+
+	r := NewRealtime()
+	store := r.Wrap(NewMemoryEvents())
+	add := func(category, path, referrer, country string) {
+		trk := Tracking{SiteID: "s", Action: TrackingData{Category: category, Event: path, ReferrerHost: referrer}}
+		if err := store.Add(context.Background(), trk, useragent.UserAgent{}, &GeoInfo{Country: country}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(PageviewCategory, "/", "news.example", "France")
+	add(PageviewCategory, "/pricing", "", "France")
+	fc.Advance(20 * time.Minute)
+	add(PageviewCategory, "/pricing", "search.example", "Spain")
+	add("Signups", "signup", "", "Spain")
+
+	tops := r.Top("s", 10, fc.Now())
+	if tops.Pageviews != 3 || tops.Events != 1 || tops.Pages[0] != (RealtimeCount{Value: "/pricing", Count: 2}) || len(tops.Referrers) != 2 || len(tops.Countries) != 2 {
+		t.Errorf("got %+v", tops)
+	}
+
+	// The first minute leaves the window
+	fc.Advance(10 * time.Minute)
+	tops = r.Top("s", 1, fc.Now())
+	if tops.Pageviews != 1 || len(tops.Pages) != 1 || tops.Countries[0].Value != "Spain" {
+		t.Errorf("after 30 minutes: got %+v", tops)
+	}
+	fc.Advance(30 * time.Minute)
+	r.expire(fc.Now())
+	if len(r.sites) != 0 {
+		t.Errorf("sites kept: %v", r.sites)
+	}
+}
+
+func TestRealtimeKeys(t *testing.T) {
+	r := NewRealtime()
+	now := time.Now()
+	for i := 0; i <= realtimeKeys; i++ {
+		r.Observe(Tracking{SiteID: "s", Action: TrackingData{Type: "page", Category: PageviewCategory, Event: fmt.Sprintf("/%d", i)}}, nil, now)
+	}
+	r.Observe(Tracking{SiteID: "s", Action: TrackingData{Type: "page", Category: PageviewCategory, Event: "/new"}}, nil, now)
+	tops := r.Top("s", 1, now)
+	if tops.Pages[0] != (RealtimeCount{Value: RealtimeOther, Count: 2}) || tops.Pageviews != realtimeKeys+2 {
+		t.Errorf("got %+v", tops.Pages)
+	}
+}
@@ -0,0 +1,100 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"sync"
+	"time"
+)
+
+// replayGenerationSize caps how many fingerprints a generation holds, so a
+// flood of unique payloads can't grow memory without bound.
+const replayGenerationSize = 100_000
+
+// Replays remembers fingerprints of recently accepted payloads to detect
+// beacons the browser or a proxy sent twice. Fingerprints live in two
+// generations that rotate every window, so one is remembered for at least
+// one window and at most two.
+type Replays struct {
+	lock      sync.Mutex
+	window    time.Duration
+	current   map[string]string // fingerprint to site
+	previous  map[string]string
+	rotatedAt time.Time
+}
+
+// NewReplays returns a Replays remembering fingerprints for window, a zero
+// window disables replay detection.
+func NewReplays(window time.Duration) *Replays {
+	return &Replays{
+		window:   window,
+		current:  make(map[string]string),
+		previous: make(map[string]string),
+	}
+}
+
+// Fingerprint identifies a raw payload sent from an IP.
+func Fingerprint(payload []byte, ip string) string {
+	h := sha256.New()
+	h.Write([]byte(ip))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Seen reports whether fp was remembered within the window.
+func (r *Replays) Seen(fp string, now time.Time) bool {
+	if r.window <= 0 {
+		return false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.rotate(now)
+	if _, ok := r.current[fp]; ok {
+		return true
+	}
+	_, ok := r.previous[fp]
+	return ok
+}
+
+// Remember records fp as accepted for siteID.
+func (r *Replays) Remember(fp, siteID string, now time.Time) {
+	if r.window <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.rotate(now)
+	r.current[fp] = siteID
+}
+
+// Forget drops the fingerprints of siteID, once it is deleted.
+func (r *Replays) Forget(siteID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, gen := range []map[string]string{r.current, r.previous} {
+		maps.DeleteFunc(gen, func(_, site string) bool { return site == siteID })
+	}
+}
+
+// rotate starts a new generation when the window elapsed or the current one
+// is full, the lock must be held.
+func (r *Replays) rotate(now time.Time) {
+	if now.Sub(r.rotatedAt) < r.window && len(r.current) < replayGenerationSize {
+		return
+	}
+	if now.Sub(r.rotatedAt) >= 2*r.window {
+		// Both generations expired
+		r.previous = make(map[string]string)
+	} else {
+		r.previous = r.current
+	}
+	r.current = make(map[string]string)
+	r.rotatedAt = now
+}
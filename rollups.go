@@ -0,0 +1,188 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Page views are rolled up per site, day and dimension into events_daily as
+// they are inserted. Days are the finest granularity stats are bucketed by, so
+// the rollups answer the same queries as the raw events for every metric whose
+// field is a rolled up dimension. Once raw events are older than
+// RAW_RETENTION_DAYS they are deleted and GetStats reads the rollups instead.
+
+// rollupDimensions are the events columns available in events_daily, they
+// must match the dimensions the events_daily_mv migration produces.
+var rollupDimensions = map[string]bool{
+	"event":           true,
+	"user_id":         true,
+	"referrer":        true,
+	"referrer_domain": true,
+	"browser_name":    true,
+	"os_name":         true,
+	"country":         true,
+	"touch":           true,
+}
+
+// rawCutoff is the first day still kept as raw events, 0 when raw events
+// never expire.
+func rawCutoff(now time.Time) uint32 {
+	if config.RawRetentionDays == 0 {
+		return 0
+	}
+	return TimeToInt(now.AddDate(0, 0, -int(config.RawRetentionDays)))
+}
+
+// useRollups reports whether a query must be answered from events_daily
+// because part of its range has no raw events anymore. Filtered queries
+// can't be, the rollups keep a single dimension per row.
+func useRollups(data MetricData, now time.Time) bool {
+	cutoff := rawCutoff(now)
+	if cutoff == 0 || data.Start >= cutoff || len(data.Filters) > 0 {
+		return false
+	}
+	def := metricDefs[data.What]
+	return rollupDimensions[def.field] && (def.filter == "" || rollupDimensions[def.filter])
+}
+
+// rollupQualities checks that a query answered from events_daily counts
+// only ok events, the rollups keep no others.
+func rollupQualities(qualities []string) error {
+	for _, q := range countedQualities(qualities) {
+		if q != QualityOK {
+			return fmt.Errorf("%w: quality breakdown unavailable past raw retention", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// GenRollupQuery is GenQuery against events_daily, it takes the same arguments.
+func (e *Events) GenRollupQuery(data MetricData) string {
+	def := metricDefs[data.What]
+	where := "AND $4 = $4"
+	if def.filter != "" {
+		where = "AND filter_value = $4"
+	}
+
+	day := "toUInt32(0)"
+	group := "site_id, value"
+	if def.daily {
+		day = "day"
+		group = "site_id, day, value"
+	}
+
+	return fmt.Sprintf(`
+		SELECT %s, value, sum(events), site_id
+		FROM events_daily
+		WHERE has($1, site_id)
+		AND dimension = '%s'
+		AND day BETWEEN $2 AND $3
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		AND $7 = $7
+		%s
+		GROUP BY %s
+		ORDER BY 3 DESC;
+	`, day, def.field, where, group)
+}
+
+// PurgeRaw deletes raw events, web vitals and JavaScript errors older than
+// the raw retention, the rollups of the events are kept.
+func (e *Events) PurgeRaw(ctx context.Context) error {
+	cutoff := rawCutoff(clock.Now())
+	if cutoff == 0 {
+		return nil
+	}
+
+	if err := e.DB.Exec(ctx, "ALTER TABLE events DELETE WHERE occured_at < ?", cutoff); err != nil {
+		return fmt.Errorf("failed to purge raw events: %w", err)
+	}
+	if err := e.DB.Exec(ctx, "ALTER TABLE web_vitals DELETE WHERE day < ?", cutoff); err != nil {
+		return fmt.Errorf("failed to purge web vitals: %w", err)
+	}
+	if err := e.DB.Exec(ctx, "ALTER TABLE js_errors DELETE WHERE day < ?", cutoff); err != nil {
+		return fmt.Errorf("failed to purge JavaScript errors: %w", err)
+	}
+	e.log.Info("Purged raw events", slog.Int("before", int(cutoff)))
+	e.hooks.Fire(HookRetentionPurged, "", map[string]uint32{"before": cutoff})
+	return nil
+}
+
+// SetWebhooks fires a retention.purged event after every purge. It must be
+// called before RunLifecycle.
+func (e *Events) SetWebhooks(h *Webhooks) {
+	e.hooks = h
+}
+
+// RunLifecycle purges expired raw events every interval until ctx is done.
+func (e *Events) RunLifecycle(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.PurgeRaw(ctx); err != nil {
+			e.log.Error("Raw event purge failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rollupInserts fill the rollup tables from the raw events of a site, all
+// sites for an empty one, between two days. They select what the
+// materialized views of the latest migrations do.
+var rollupInserts = map[string]string{
+	"events_daily": `
+		INSERT INTO events_daily (site_id, day, dimension, value, filter_value, source, touch, events)
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, touch, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV2 + ` AS dim
+		WHERE category = 'Page views'
+		AND quality = 'ok'
+		AND ($1 = '' OR site_id = $1)
+		AND occured_at BETWEEN $2 AND $3
+		GROUP BY site_id, day, dimension, value, filter_value, source, touch
+	`,
+	"usage_daily": `
+		INSERT INTO usage_daily (site_id, day, events)
+		SELECT site_id, occured_at AS day, count() AS events
+		FROM events
+		WHERE ($1 = '' OR site_id = $1)
+		AND occured_at BETWEEN $2 AND $3
+		GROUP BY site_id, day
+	`,
+}
+
+// RebuildRollups recomputes the rollups of siteID, or of every site when
+// empty, between two YYYYMMDD days from the raw events, after backfills or
+// late events. Days without raw events anymore are refused, their rollups
+// can't be rebuilt. Events inserted meanwhile may be counted twice, rebuild
+// days that don't receive events. Lifetime site totals are left as they
+// are.
+func (e *Events) RebuildRollups(ctx context.Context, siteID string, from, to uint32) error {
+	if from > to {
+		return fmt.Errorf("%w: from %d is after to %d", ErrInvalid, from, to)
+	}
+	if cutoff := rawCutoff(clock.Now()); from < cutoff {
+		return fmt.Errorf("%w: raw events before %d are deleted, their rollups can't be rebuilt", ErrInvalid, cutoff)
+	}
+
+	for _, table := range []string{"events_daily", "usage_daily"} {
+		qry := fmt.Sprintf("ALTER TABLE %s DELETE WHERE (? = '' OR site_id = ?) AND day BETWEEN ? AND ? SETTINGS mutations_sync = 1", table)
+		if err := e.DB.Exec(ctx, qry, siteID, siteID, from, to); err != nil {
+			return fmt.Errorf("failed to delete %s rollups: %w", table, err)
+		}
+		if err := e.DB.Exec(ctx, rollupInserts[table], siteID, from, to); err != nil {
+			return fmt.Errorf("failed to rebuild %s rollups: %w", table, err)
+		}
+		e.log.Info("Rebuilt rollups", slog.String("table", table), slog.String("site", siteID),
+			slog.Int("from", int(from)), slog.Int("to", int(to)))
+	}
+	return nil
+}
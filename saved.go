@@ -0,0 +1,399 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrInUse    = errors.New("in use")
+	ErrInvalid  = errors.New("invalid")
+)
+
+// Segment is a named set of filters, keyed by the fields /stats/values
+// accepts.
+type Segment struct {
+	ID        string            `json:"id"`
+	SiteID    string            `json:"siteId"`
+	Name      string            `json:"name"`
+	Filters   map[string]string `json:"filters"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// Report is a named stats query. The range is either the last RangeDays days
+// up to the day the report is run, or fixed between Start and End.
+type Report struct {
+	ID        string    `json:"id"`
+	SiteID    string    `json:"siteId"`
+	Name      string    `json:"name"`
+	Metric    QueryType `json:"metric"`
+	Extra     string    `json:"extra,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Touch     string    `json:"touch,omitempty"`
+	SegmentID string    `json:"segmentId,omitempty"`
+	RangeDays int       `json:"rangeDays,omitempty"`
+	Start     uint32    `json:"start,omitempty"`
+	End       uint32    `json:"end,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// PushURL, when set, is sent the report's stats on every PushSchedule,
+	// see ReportPusher.
+	PushURL      string       `json:"pushUrl,omitempty"`
+	PushSchedule PushSchedule `json:"pushSchedule,omitempty"`
+}
+
+// MetricData returns the stats query the report stands for when run at now,
+// without its segment, see Saved.ReportData.
+func (r Report) MetricData(now time.Time) MetricData {
+	data := MetricData{What: r.Metric, SiteID: r.SiteID, Start: r.Start, End: r.End, Extra: r.Extra, Source: r.Source, Touch: r.Touch}
+	if r.RangeDays > 0 {
+		data.Start = TimeToInt(now.AddDate(0, 0, -r.RangeDays+1))
+		data.End = TimeToInt(now)
+	}
+	return data
+}
+
+func (s Segment) validate() error {
+	if s.SiteID == "" || s.Name == "" {
+		return fmt.Errorf("%w: siteId and name are required", ErrInvalid)
+	}
+	for field := range s.Filters {
+		if _, err := ValueColumn(field); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+func (r Report) validate() error {
+	if r.SiteID == "" || r.Name == "" {
+		return fmt.Errorf("%w: siteId and name are required", ErrInvalid)
+	}
+	if _, ok := metricDefs[r.Metric]; !ok {
+		return fmt.Errorf("%w: unknown metric %s", ErrInvalid, r.Metric)
+	}
+	if r.Touch != "" && !Touches[r.Touch] {
+		return fmt.Errorf("%w: touch must be touch or non-touch", ErrInvalid)
+	}
+	if r.RangeDays < 0 || (r.RangeDays == 0 && (r.Start == 0 || r.End < r.Start)) {
+		return fmt.Errorf("%w: either rangeDays or a start and end are required", ErrInvalid)
+	}
+	return r.validatePush()
+}
+
+// Saved holds the saved segments, reports, campaigns, dashboards and alert
+// rules. Like Sites it is kept in memory and written to a JSON file when a
+// path is set.
+type Saved struct {
+	lock       sync.RWMutex
+	path       string
+	segments   map[string]Segment
+	reports    map[string]Report
+	campaigns  map[string]Campaign
+	dashboards map[string]Dashboard // site and user to the dashboard
+	rules      map[string]AlertRule
+}
+
+type savedFile struct {
+	Segments   []Segment   `json:"segments"`
+	Reports    []Report    `json:"reports"`
+	Campaigns  []Campaign  `json:"campaigns"`
+	Dashboards []Dashboard `json:"dashboards"`
+	Rules      []AlertRule `json:"rules"`
+}
+
+// Load reads saved segments, reports, campaigns, dashboards and alert rules
+// from path. A missing file is not an error, an empty path keeps them in
+// memory.
+func (s *Saved) Load(path string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.path = path
+	s.segments = make(map[string]Segment)
+	s.reports = make(map[string]Report)
+	s.campaigns = make(map[string]Campaign)
+	s.dashboards = make(map[string]Dashboard)
+	s.rules = make(map[string]AlertRule)
+	if path == "" {
+		return nil
+	}
+
+	var f savedFile
+	if err := readJSONFile(path, &f); err != nil {
+		return err
+	}
+	for _, seg := range f.Segments {
+		s.segments[seg.ID] = seg
+	}
+	for _, rep := range f.Reports {
+		s.reports[rep.ID] = rep
+	}
+	for _, c := range f.Campaigns {
+		s.campaigns[c.ID] = c
+	}
+	for _, d := range f.Dashboards {
+		s.dashboards[dashboardKey(d.SiteID, d.User)] = d
+	}
+	for _, rule := range f.Rules {
+		s.rules[rule.ID] = rule
+	}
+	return nil
+}
+
+// Segments returns the segments of a site ordered by name.
+func (s *Saved) Segments(siteID string) []Segment {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := []Segment{}
+	for _, seg := range s.segments {
+		if seg.SiteID == siteID {
+			list = append(list, seg)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *Saved) Segment(id string) (Segment, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	seg, ok := s.segments[id]
+	return seg, ok
+}
+
+// ReportData returns the stats query rep stands for when run at now,
+// filtered by its segment.
+func (s *Saved) ReportData(rep Report, now time.Time) (MetricData, error) {
+	data := rep.MetricData(now)
+	if rep.SegmentID == "" {
+		return data, nil
+	}
+	seg, ok := s.Segment(rep.SegmentID)
+	if !ok || seg.SiteID != rep.SiteID {
+		return MetricData{}, fmt.Errorf("%w: unknown segment %q", ErrNotFound, rep.SegmentID)
+	}
+	data.Filters = seg.Filters
+	return data, nil
+}
+
+// PutSegment creates seg when its ID is empty and replaces the segment with
+// that ID otherwise.
+func (s *Saved) PutSegment(seg Segment) (Segment, error) {
+	if err := seg.validate(); err != nil {
+		return Segment{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := clock.Now().UTC()
+	prev, exists := s.segments[seg.ID]
+	switch {
+	case seg.ID == "":
+		seg.ID = newSavedID()
+		seg.CreatedAt = now
+	case !exists:
+		return Segment{}, ErrNotFound
+	default:
+		seg.SiteID = prev.SiteID
+		seg.CreatedAt = prev.CreatedAt
+	}
+	seg.UpdatedAt = now
+
+	s.segments[seg.ID] = seg
+	if err := s.save(); err != nil {
+		if exists {
+			s.segments[seg.ID] = prev
+		} else {
+			delete(s.segments, seg.ID)
+		}
+		return Segment{}, err
+	}
+	return seg, nil
+}
+
+func (s *Saved) DeleteSegment(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	seg, ok := s.segments[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, rep := range s.reports {
+		if rep.SegmentID == id {
+			return fmt.Errorf("%w: segment is used by report %q", ErrInUse, rep.Name)
+		}
+	}
+	delete(s.segments, id)
+	if err := s.save(); err != nil {
+		s.segments[id] = seg
+		return err
+	}
+	return nil
+}
+
+// Reports returns the reports of a site ordered by name.
+func (s *Saved) Reports(siteID string) []Report {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := []Report{}
+	for _, rep := range s.reports {
+		if rep.SiteID == siteID {
+			list = append(list, rep)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *Saved) Report(id string) (Report, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rep, ok := s.reports[id]
+	return rep, ok
+}
+
+// PutReport creates rep when its ID is empty and replaces the report with
+// that ID otherwise. A referenced segment must belong to the same site.
+func (s *Saved) PutReport(rep Report) (Report, error) {
+	if err := rep.validate(); err != nil {
+		return Report{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prev, exists := s.reports[rep.ID]
+	if exists {
+		rep.SiteID = prev.SiteID
+	}
+	if rep.SegmentID != "" {
+		if seg, ok := s.segments[rep.SegmentID]; !ok || seg.SiteID != rep.SiteID {
+			return Report{}, fmt.Errorf("%w: unknown segment %q", ErrInvalid, rep.SegmentID)
+		}
+	}
+
+	now := clock.Now().UTC()
+	switch {
+	case rep.ID == "":
+		rep.ID = newSavedID()
+		rep.CreatedAt = now
+	case !exists:
+		return Report{}, ErrNotFound
+	default:
+		rep.CreatedAt = prev.CreatedAt
+	}
+	rep.UpdatedAt = now
+
+	s.reports[rep.ID] = rep
+	if err := s.save(); err != nil {
+		if exists {
+			s.reports[rep.ID] = prev
+		} else {
+			delete(s.reports, rep.ID)
+		}
+		return Report{}, err
+	}
+	return rep, nil
+}
+
+func (s *Saved) DeleteReport(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rep, ok := s.reports[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.reports, id)
+	if err := s.save(); err != nil {
+		s.reports[id] = rep
+		return err
+	}
+	return nil
+}
+
+// DeleteSite deletes the segments, reports, campaigns, dashboards and alert
+// rules of siteID and returns how many there were.
+func (s *Saved) DeleteSite(siteID string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prevSegments, prevReports, prevCampaigns := maps.Clone(s.segments), maps.Clone(s.reports), maps.Clone(s.campaigns)
+	prevDashboards, prevRules := maps.Clone(s.dashboards), maps.Clone(s.rules)
+	maps.DeleteFunc(s.segments, func(_ string, seg Segment) bool { return seg.SiteID == siteID })
+	maps.DeleteFunc(s.reports, func(_ string, rep Report) bool { return rep.SiteID == siteID })
+	maps.DeleteFunc(s.campaigns, func(_ string, c Campaign) bool { return c.SiteID == siteID })
+	maps.DeleteFunc(s.dashboards, func(_ string, d Dashboard) bool { return d.SiteID == siteID })
+	maps.DeleteFunc(s.rules, func(_ string, rule AlertRule) bool { return rule.SiteID == siteID })
+	deleted := len(prevSegments) - len(s.segments) + len(prevReports) - len(s.reports) + len(prevCampaigns) - len(s.campaigns) +
+		len(prevDashboards) - len(s.dashboards) + len(prevRules) - len(s.rules)
+	if deleted == 0 {
+		return 0, nil
+	}
+	if err := s.save(); err != nil {
+		s.segments, s.reports, s.campaigns = prevSegments, prevReports, prevCampaigns
+		s.dashboards, s.rules = prevDashboards, prevRules
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// save writes segments, reports, campaigns, dashboards and alert rules to
+// disk, the lock must be held.
+func (s *Saved) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.file())
+}
+
+// file returns segments, reports, campaigns and alert rules ordered by ID
+// and dashboards by site and user, the lock must be held.
+func (s *Saved) file() savedFile {
+	f := savedFile{Segments: []Segment{}, Reports: []Report{}, Campaigns: []Campaign{}, Dashboards: []Dashboard{}, Rules: []AlertRule{}}
+	for _, seg := range s.segments {
+		f.Segments = append(f.Segments, seg)
+	}
+	for _, rep := range s.reports {
+		f.Reports = append(f.Reports, rep)
+	}
+	sort.Slice(f.Segments, func(i, j int) bool { return f.Segments[i].ID < f.Segments[j].ID })
+	for _, c := range s.campaigns {
+		f.Campaigns = append(f.Campaigns, c)
+	}
+	sort.Slice(f.Reports, func(i, j int) bool { return f.Reports[i].ID < f.Reports[j].ID })
+	sort.Slice(f.Campaigns, func(i, j int) bool { return f.Campaigns[i].ID < f.Campaigns[j].ID })
+	for _, d := range s.dashboards {
+		f.Dashboards = append(f.Dashboards, d)
+	}
+	sort.Slice(f.Dashboards, func(i, j int) bool {
+		return dashboardKey(f.Dashboards[i].SiteID, f.Dashboards[i].User) < dashboardKey(f.Dashboards[j].SiteID, f.Dashboards[j].User)
+	})
+	for _, rule := range s.rules {
+		f.Rules = append(f.Rules, rule)
+	}
+	sort.Slice(f.Rules, func(i, j int) bool { return f.Rules[i].ID < f.Rules[j].ID })
+	return f
+}
+
+func newSavedID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
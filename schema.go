@@ -0,0 +1,397 @@
+package tracker
+
+const schemaMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version UInt32 NOT NULL,
+		applied_at DateTime DEFAULT now()
+	)
+	ENGINE MergeTree
+	ORDER BY version;
+`
+
+// eventsDailyDimensionsV1 lists the (dimension, value, filter_value) tuples
+// events_daily gets for each page view. filter_value holds the column a
+// metric may filter on.
+const eventsDailyDimensionsV1 = `[
+	('event', event, ''),
+	('user_id', user_id, ''),
+	('referrer', referrer, referrer_domain),
+	('referrer_domain', referrer_domain, ''),
+	('browser_name', browser_name, ''),
+	('os_name', os_name, ''),
+	('country', country, '')
+]`
+
+// eventsDailyDimensionsV2 adds touch to eventsDailyDimensionsV1.
+const eventsDailyDimensionsV2 = `[
+	('event', event, ''),
+	('user_id', user_id, ''),
+	('referrer', referrer, referrer_domain),
+	('referrer_domain', referrer_domain, ''),
+	('browser_name', browser_name, ''),
+	('os_name', os_name, ''),
+	('country', country, ''),
+	('touch', touch, '')
+]`
+
+// migrations are applied in order by EnsureTable, a migration's schema
+// version is its position in the list starting at 1. Never edit or reorder
+// existing entries, append new ones.
+var migrations = []string{
+	// 1: events table
+	`
+		CREATE TABLE IF NOT EXISTS events (
+			site_id String NOT NULL,
+			occured_at UInt32 NOT NULL,
+			type String NOT NULL,
+			user_id String NOT NULL,
+			event String NOT NULL,
+			category String NOT NULL,
+			referrer String NOT NULL,
+			referrer_domain String NOT NULL,
+			is_touch BOOLEAN NOT NULL,
+			browser_name String NOT NULL,
+			os_name String NOT NULL,
+			device_type String NOT NULL,
+			country String NOT NULL,
+			region String NOT NULL,
+			timestamp DateTime DEFAULT now()
+		)
+		ENGINE MergeTree
+		ORDER BY (site_id, occured_at);
+	`,
+	// 2-4: daily ingest counts per site for usage reporting. The view
+	// counts events from 3 on, the backfill those inserted before the
+	// second it was created.
+	`
+		CREATE TABLE IF NOT EXISTS usage_daily (
+			site_id String NOT NULL,
+			day UInt32 NOT NULL,
+			events UInt64 NOT NULL
+		)
+		ENGINE SummingMergeTree
+		ORDER BY (site_id, day);
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS usage_daily_mv TO usage_daily AS
+		SELECT site_id, occured_at AS day, count() AS events
+		FROM events
+		GROUP BY site_id, day;
+	`,
+	`
+		INSERT INTO usage_daily
+		SELECT site_id, occured_at, count()
+		FROM events
+		WHERE timestamp < (
+			SELECT metadata_modification_time
+			FROM system.tables
+			WHERE database = currentDatabase() AND name = 'usage_daily_mv'
+		)
+		GROUP BY site_id, occured_at;
+	`,
+	// 5-7: daily page view rollups kept after raw events expire, see rollups.go
+	`
+		CREATE TABLE IF NOT EXISTS events_daily (
+			site_id String NOT NULL,
+			day UInt32 NOT NULL,
+			dimension String NOT NULL,
+			value String NOT NULL,
+			filter_value String NOT NULL,
+			events UInt64 NOT NULL
+		)
+		ENGINE SummingMergeTree
+		ORDER BY (site_id, dimension, day, filter_value, value);
+	`,
+	`
+		INSERT INTO events_daily
+		SELECT site_id, occured_at, dim.1, dim.2, dim.3, count()
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV1 + ` AS dim
+		WHERE category = 'Page views'
+		GROUP BY site_id, occured_at, dim.1, dim.2, dim.3;
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS events_daily_mv TO events_daily AS
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV1 + ` AS dim
+		WHERE category = 'Page views'
+		GROUP BY site_id, day, dimension, value, filter_value;
+	`,
+	// 8-11: event source (web, ios, android, server), rollups are kept per
+	// source. 10 changes the view in place, 11 creates it for databases
+	// where 10 dropped it.
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS source String DEFAULT 'web';
+	`,
+	`
+		ALTER TABLE events_daily
+			ADD COLUMN IF NOT EXISTS source String DEFAULT 'web',
+			MODIFY ORDER BY (site_id, dimension, day, filter_value, value, source);
+	`,
+	`
+		ALTER TABLE events_daily_mv MODIFY QUERY
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV1 + ` AS dim
+		WHERE category = 'Page views'
+		GROUP BY site_id, day, dimension, value, filter_value, source;
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS events_daily_mv TO events_daily AS
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV1 + ` AS dim
+		WHERE category = 'Page views'
+		GROUP BY site_id, day, dimension, value, filter_value, source;
+	`,
+	// 12: app and OS versions of mobile SDK events
+	`
+		ALTER TABLE events
+			ADD COLUMN IF NOT EXISTS app_version String DEFAULT '',
+			ADD COLUMN IF NOT EXISTS os_version String DEFAULT '';
+	`,
+	// 13-16: lifetime totals per site, backfilled from the rollups which
+	// outlive raw events. Events inserted between 15 and 16 are not counted.
+	`
+		CREATE TABLE IF NOT EXISTS site_totals (
+			site_id String NOT NULL,
+			pageviews SimpleAggregateFunction(sum, UInt64),
+			visitors AggregateFunction(uniq, String),
+			first_day SimpleAggregateFunction(min, UInt32)
+		)
+		ENGINE AggregatingMergeTree
+		ORDER BY site_id;
+	`,
+	`
+		INSERT INTO site_totals
+		SELECT site_id, sumIf(events, dimension = 'event'), uniqStateIf(value, dimension = 'user_id'), min(day)
+		FROM events_daily
+		WHERE dimension IN ('event', 'user_id')
+		GROUP BY site_id;
+	`,
+	`
+		INSERT INTO site_totals (site_id, first_day)
+		SELECT site_id, min(day)
+		FROM usage_daily
+		GROUP BY site_id;
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS site_totals_mv TO site_totals AS
+		SELECT site_id,
+			countIf(category = 'Page views') AS pageviews,
+			uniqStateIf(user_id, category = 'Page views') AS visitors,
+			min(occured_at) AS first_day
+		FROM events
+		GROUP BY site_id;
+	`,
+	// 17: audit log of stats and admin API requests
+	`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			at DateTime64(3, 'UTC') NOT NULL,
+			actor String NOT NULL,
+			remote_ip String NOT NULL,
+			method String NOT NULL,
+			path String NOT NULL,
+			site_id String NOT NULL,
+			query String NOT NULL,
+			status UInt16 NOT NULL
+		)
+		ENGINE MergeTree
+		PARTITION BY toYYYYMM(at)
+		ORDER BY at;
+	`,
+	// 18: table settings applied from the configuration, see tablesettings.go
+	`
+		CREATE TABLE IF NOT EXISTS table_settings (
+			name String NOT NULL,
+			value String NOT NULL,
+			applied_at DateTime64(3, 'UTC') DEFAULT now64(3)
+		)
+		ENGINE ReplacingMergeTree(applied_at)
+		ORDER BY name;
+	`,
+	// 19-22: touch and non-touch devices, rollups are kept per touch. Page
+	// views rolled up before 20 match neither. 21 changes the view in place,
+	// 22 creates it for databases where 21 dropped it.
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS touch String ALIAS if(is_touch, 'touch', 'non-touch');
+	`,
+	`
+		ALTER TABLE events_daily
+			ADD COLUMN IF NOT EXISTS touch String DEFAULT '',
+			MODIFY ORDER BY (site_id, dimension, day, filter_value, value, source, touch);
+	`,
+	`
+		ALTER TABLE events_daily_mv MODIFY QUERY
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, touch, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV2 + ` AS dim
+		WHERE category = 'Page views'
+		GROUP BY site_id, day, dimension, value, filter_value, source, touch;
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS events_daily_mv TO events_daily AS
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, touch, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV2 + ` AS dim
+		WHERE category = 'Page views'
+		GROUP BY site_id, day, dimension, value, filter_value, source, touch;
+	`,
+	// 23: utm_campaign of landing pages, not rolled up
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS campaign String DEFAULT '';
+	`,
+	// 24: events reported long after they happened, see late.go
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS late Bool DEFAULT false;
+	`,
+	// 25: rendering engine of the browser, see BrowserEngine, not rolled up
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS browser_engine String DEFAULT '';
+	`,
+	// 26: web vitals of vitals events, see vitals.go
+	`
+		CREATE TABLE IF NOT EXISTS web_vitals (
+			site_id String NOT NULL,
+			day UInt32 NOT NULL,
+			path String NOT NULL,
+			device LowCardinality(String) NOT NULL,
+			metric LowCardinality(String) NOT NULL,
+			value Float64 NOT NULL
+		)
+		ENGINE MergeTree
+		PARTITION BY intDiv(day, 100)
+		ORDER BY (site_id, metric, day, path);
+	`,
+	// 27: JavaScript errors of jserror events, see jserror.go
+	`
+		CREATE TABLE IF NOT EXISTS js_errors (
+			site_id String NOT NULL,
+			day UInt32 NOT NULL,
+			hash String NOT NULL,
+			source String NOT NULL,
+			message String NOT NULL,
+			count UInt64 NOT NULL
+		)
+		ENGINE SummingMergeTree(count)
+		PARTITION BY intDiv(day, 100)
+		ORDER BY (site_id, day, hash, source);
+	`,
+	// 28-30: events suspected of automation, see VolumeGuard, aren't
+	// rolled up. 29 changes the view in place, 30 creates it for databases
+	// where 29 dropped it. 31-32 turn quality into the Qualities.
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS quality String DEFAULT 'ok';
+	`,
+	`
+		ALTER TABLE events_daily_mv MODIFY QUERY
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, touch, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV2 + ` AS dim
+		WHERE category = 'Page views' AND quality = 'ok'
+		GROUP BY site_id, day, dimension, value, filter_value, source, touch;
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS events_daily_mv TO events_daily AS
+		SELECT site_id, occured_at AS day, dim.1 AS dimension, dim.2 AS value, dim.3 AS filter_value, source, touch, count() AS events
+		FROM events
+		ARRAY JOIN ` + eventsDailyDimensionsV2 + ` AS dim
+		WHERE category = 'Page views' AND quality = 'ok'
+		GROUP BY site_id, day, dimension, value, filter_value, source, touch;
+	`,
+	`
+		ALTER TABLE events UPDATE quality = 'bot' WHERE quality NOT IN ('ok', 'bot', 'spam', 'self', 'throttled');
+	`,
+	`
+		ALTER TABLE events MODIFY COLUMN quality ` + qualityEnumV1 + ` DEFAULT 'ok';
+	`,
+	// 33: progress of blue/green upgrades of the events table, see upgrade.go
+	`
+		CREATE TABLE IF NOT EXISTS schema_upgrade (
+			state String NOT NULL,
+			since DateTime NOT NULL,
+			backfilled_to UInt32 NOT NULL,
+			updated_at DateTime64(3) NOT NULL
+		)
+		ENGINE MergeTree
+		ORDER BY updated_at;
+	`,
+	// 34: events of sites in test mode
+	`
+		ALTER TABLE events MODIFY COLUMN quality ` + qualityEnumV2 + ` DEFAULT 'ok';
+	`,
+	// 35: sessions of visitors, see Visits
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS session_id String DEFAULT '';
+	`,
+	// 36: UTM parameters of landing pages besides utm_campaign, see
+	// parseUTM, not rolled up
+	`
+		ALTER TABLE events
+			ADD COLUMN IF NOT EXISTS utm_source String DEFAULT '',
+			ADD COLUMN IF NOT EXISTS utm_medium String DEFAULT '',
+			ADD COLUMN IF NOT EXISTS utm_term String DEFAULT '',
+			ADD COLUMN IF NOT EXISTS utm_content String DEFAULT '';
+	`,
+	// 37: when events happened, timestamp is when their batch was inserted.
+	// Earlier events keep their insert time.
+	`
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS happened_at DateTime DEFAULT timestamp;
+	`,
+	// 38-41: lifetime totals count ok page views only, backfilled again
+	// from the rollups which only hold those. Page views inserted between
+	// 38 and 41 are not counted.
+	`
+		DROP VIEW IF EXISTS site_totals_mv;
+	`,
+	`
+		TRUNCATE TABLE IF EXISTS site_totals;
+	`,
+	`
+		INSERT INTO site_totals
+		SELECT site_id, sumIf(events, dimension = 'event'), uniqStateIf(value, dimension = 'user_id'), min(day)
+		FROM (
+			SELECT site_id, day, dimension, value, events
+			FROM events_daily
+			WHERE dimension IN ('event', 'user_id')
+			UNION ALL
+			SELECT site_id, day, '', '', 0
+			FROM usage_daily
+		)
+		GROUP BY site_id;
+	`,
+	`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS site_totals_mv TO site_totals AS
+		SELECT site_id,
+			countIf(category = 'Page views' AND quality = 'ok') AS pageviews,
+			uniqStateIf(user_id, category = 'Page views' AND quality = 'ok') AS visitors,
+			min(occured_at) AS first_day
+		FROM events
+		GROUP BY site_id;
+	`,
+	// 42: copies done by site merges, see MergeSite
+	`
+		CREATE TABLE IF NOT EXISTS site_merges (
+			from_site String NOT NULL,
+			into_site String NOT NULL,
+			step String NOT NULL,
+			moved UInt64 NOT NULL,
+			oldest UInt32 NOT NULL
+		)
+		ENGINE MergeTree
+		ORDER BY (from_site, into_site);
+	`,
+}
+
+// qualityEnumV1 is the type of the quality column, holding the Qualities.
+const qualityEnumV1 = "Enum8('ok' = 1, 'bot' = 2, 'spam' = 3, 'self' = 4, 'throttled' = 5)"
+
+// qualityEnumV2 adds QualityTest to qualityEnumV1.
+const qualityEnumV2 = "Enum8('ok' = 1, 'bot' = 2, 'spam' = 3, 'self' = 4, 'throttled' = 5, 'test' = 6)"
+
+// LatestSchemaVersion is the version the database has once all migrations are
+// applied.
+func LatestSchemaVersion() uint32 {
+	return uint32(len(migrations))
+}
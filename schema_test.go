@@ -0,0 +1,47 @@
+package tracker
+
+import (
+	"strings"
+	"testing"
+)
+
+// migrationOf returns the version of the first migration containing stmt.
+func migrationOf(t *testing.T, stmt string) int {
+	t.Helper()
+	for i, qry := range migrations {
+		if strings.Contains(qry, stmt) {
+			return i + 1
+		}
+	}
+	t.Fatalf("no migration has %q", stmt)
+	return 0
+}
+
+func TestUsageMigrations(t *testing.T) {
+	view := migrationOf(t, "CREATE MATERIALIZED VIEW IF NOT EXISTS usage_daily_mv")
+	backfill := migrationOf(t, "INSERT INTO usage_daily")
+	if backfill < view {
+		t.Errorf("usage_daily backfilled at %d, before its view is created at %d", backfill, view)
+	}
+	// Events the view counted are left out of the backfill
+	if !strings.Contains(migrations[backfill-1], "name = 'usage_daily_mv'") {
+		t.Errorf("backfill not bounded by the view's creation: %s", migrations[backfill-1])
+	}
+}
+
+func TestRollupViewModified(t *testing.T) {
+	// The view is changed in place, the next migration creates it with the
+	// same query where it was dropped instead
+	for _, version := range []int{10, 21, 29} {
+		modify, create := migrations[version-1], migrations[version]
+		_, query, ok := strings.Cut(modify, "ALTER TABLE events_daily_mv MODIFY QUERY")
+		if !ok {
+			t.Errorf("%d: got %s", version, modify)
+			continue
+		}
+		_, created, ok := strings.Cut(create, "CREATE MATERIALIZED VIEW IF NOT EXISTS events_daily_mv TO events_daily AS")
+		if !ok || strings.Join(strings.Fields(created), " ") != strings.Join(strings.Fields(query), " ") {
+			t.Errorf("%d: view created as\n%s\nmodified as\n%s", version+1, create, modify)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// column is a column the tracker reads or writes and its ClickHouse type.
+type column struct {
+	name, typ string
+}
+
+// expectedColumns are the columns of the tables inserts and stats queries
+// rely on once every migration is applied. Keep them in step with the
+// migrations adding or changing columns.
+var expectedColumns = map[string][]column{
+	"events": {
+		{"site_id", "String"},
+		{"occured_at", "UInt32"},
+		{"type", "String"},
+		{"user_id", "String"},
+		{"event", "String"},
+		{"category", "String"},
+		{"referrer", "String"},
+		{"referrer_domain", "String"},
+		{"is_touch", "Bool"},
+		{"browser_name", "String"},
+		{"os_name", "String"},
+		{"device_type", "String"},
+		{"country", "String"},
+		{"region", "String"},
+		{"timestamp", "DateTime"},
+		{"source", "String"},
+		{"app_version", "String"},
+		{"os_version", "String"},
+		{"touch", "String"},
+		{"campaign", "String"},
+		{"late", "Bool"},
+		{"browser_engine", "String"},
+		{"quality", qualityEnumV2},
+		{"session_id", "String"},
+		{"utm_source", "String"},
+		{"utm_medium", "String"},
+		{"utm_term", "String"},
+		{"utm_content", "String"},
+		{"happened_at", "DateTime"},
+	},
+	"web_vitals": {
+		{"site_id", "String"},
+		{"day", "UInt32"},
+		{"path", "String"},
+		{"device", "LowCardinality(String)"},
+		{"metric", "LowCardinality(String)"},
+		{"value", "Float64"},
+	},
+	"schema_upgrade": {
+		{"state", "String"},
+		{"since", "DateTime"},
+		{"backfilled_to", "UInt32"},
+		{"updated_at", "DateTime64(3)"},
+	},
+	"site_merges": {
+		{"from_site", "String"},
+		{"into_site", "String"},
+		{"step", "String"},
+		{"moved", "UInt64"},
+		{"oldest", "UInt32"},
+	},
+	"js_errors": {
+		{"site_id", "String"},
+		{"day", "UInt32"},
+		{"hash", "String"},
+		{"source", "String"},
+		{"message", "String"},
+		{"count", "UInt64"},
+	},
+	"events_daily": {
+		{"site_id", "String"},
+		{"day", "UInt32"},
+		{"dimension", "String"},
+		{"value", "String"},
+		{"filter_value", "String"},
+		{"events", "UInt64"},
+		{"source", "String"},
+		{"touch", "String"},
+	},
+}
+
+// ColumnDrift is a column of the live schema that differs from the expected
+// one. Got is empty when the column is missing.
+type ColumnDrift struct {
+	Table  string
+	Column string
+	Want   string
+	Got    string
+}
+
+func (d ColumnDrift) String() string {
+	if d.Got == "" {
+		return fmt.Sprintf("%s.%s is missing, want %s", d.Table, d.Column, d.Want)
+	}
+	return fmt.Sprintf("%s.%s is %s, want %s", d.Table, d.Column, d.Got, d.Want)
+}
+
+// SchemaDrift compares the live columns of the tracker's tables with the
+// expected ones. Extra columns are not drift, a newer binary may have added
+// them.
+func (e *Events) SchemaDrift(ctx context.Context) ([]ColumnDrift, error) {
+	tables := make([]string, 0, len(expectedColumns))
+	for table := range expectedColumns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	rows, err := e.DB.Query(ctx, `
+		SELECT table, name, type
+		FROM system.columns
+		WHERE database = currentDatabase() AND has($1, table)
+	`, tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading table columns: %w", err)
+	}
+	defer rows.Close()
+
+	live := make(map[string]map[string]string)
+	for rows.Next() {
+		var table, name, typ string
+		if err := rows.Scan(&table, &name, &typ); err != nil {
+			return nil, fmt.Errorf("failed scanning table column: %w", err)
+		}
+		if live[table] == nil {
+			live[table] = make(map[string]string)
+		}
+		live[table][name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating table columns: %w", err)
+	}
+
+	var drift []ColumnDrift
+	for _, table := range tables {
+		drift = append(drift, diffColumns(table, expectedColumns[table], live[table])...)
+	}
+	return drift, nil
+}
+
+// diffColumns returns the columns of want missing from or typed differently
+// in got, a map of column names to types.
+func diffColumns(table string, want []column, got map[string]string) []ColumnDrift {
+	var drift []ColumnDrift
+	for _, col := range want {
+		typ, ok := got[col.name]
+		// Adopting LowCardinality, see upgrade.go, changes nothing for
+		// inserts and queries
+		if ok && (typ == col.typ || typ == "LowCardinality("+col.typ+")") {
+			continue
+		}
+		drift = append(drift, ColumnDrift{Table: table, Column: col.name, Want: col.typ, Got: typ})
+	}
+	return drift
+}
+
+// warnSchemaDrift logs every drifted column, inserts and queries touching
+// them will fail.
+func (e *Events) warnSchemaDrift(ctx context.Context) {
+	drift, err := e.SchemaDrift(ctx)
+	if err != nil {
+		e.log.Warn("Could not check the schema for drift", slog.Any("error", err))
+		return
+	}
+	for _, d := range drift {
+		e.log.Error("Schema drift, inserts and stats queries may fail", slog.String("table", d.Table),
+			slog.String("column", d.Column), slog.String("want", d.Want), slog.String("got", d.Got))
+	}
+}
+
+func (e *Events) checkSchemaDrift(ctx context.Context) Finding {
+	drift, err := e.SchemaDrift(ctx)
+	if err != nil {
+		return Finding{Check: "schema drift", Status: FindingWarn, Message: err.Error()}
+	}
+	if len(drift) > 0 {
+		problems := make([]string, len(drift))
+		for i, d := range drift {
+			problems[i] = d.String()
+		}
+		return Finding{
+			Check:   "schema drift",
+			Status:  FindingFail,
+			Message: strings.Join(problems, "; "),
+			Hint:    "the tables were changed by hand or by another binary, alter them back to the expected types",
+		}
+	}
+	return Finding{Check: "schema drift", Status: FindingOK, Message: "tables have the expected columns"}
+}
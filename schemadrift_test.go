@@ -0,0 +1,19 @@
+package tracker
+
+import "testing"
+
+func TestDiffColumns(t *testing.T) {
+	want := []column{{"site_id", "String"}, {"is_touch", "Bool"}, {"campaign", "String"}}
+	got := map[string]string{"site_id": "LowCardinality(String)", "is_touch": "UInt8", "extra": "String"}
+
+	drift := diffColumns("events", want, got)
+	if len(drift) != 2 {
+		t.Fatalf("got %v", drift)
+	}
+	if s := drift[0].String(); s != "events.is_touch is UInt8, want Bool" {
+		t.Errorf("got %q", s)
+	}
+	if s := drift[1].String(); s != "events.campaign is missing, want String" {
+		t.Errorf("got %q", s)
+	}
+}
@@ -0,0 +1,39 @@
+package tracker
+
+import (
+	"crypto/sha512"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+)
+
+// The tracking script, built from src/track.ts, is served by the tracker
+// itself so sites can pin it with Subresource Integrity. Pinned hashes
+// break on any change to the script, so every release of it gets a new
+// ScriptVersion served at its own path, and /js/script.js follows the
+// latest.
+
+// ScriptVersion is the version of the embedded script, bump it with every
+// change to static/track.js.
+const ScriptVersion = 5
+
+//go:embed static/track.js
+var scriptBody []byte
+
+// Script returns the embedded tracking script.
+func Script() []byte {
+	return scriptBody
+}
+
+// ScriptPath is the versioned path of the embedded script, safe to cache
+// forever.
+func ScriptPath() string {
+	return fmt.Sprintf("/js/script.v%d.js", ScriptVersion)
+}
+
+// ScriptIntegrity is the SRI hash of the embedded script, for the integrity
+// attribute of its script tag.
+func ScriptIntegrity() string {
+	sum := sha512.Sum384(scriptBody)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,25 @@
+package tracker
+
+import "testing"
+
+// scriptIntegrities are the released versions of the script, sites pin
+// them so a version's script must never change.
+var scriptIntegrities = map[int]string{
+	2: "sha384-iPP+MOQw2mYdkE37PFbDLRk4RFsPAKcr08aI8no503M33fF41I0TACyR86bQSz5x",
+	3: "sha384-SemIi125veEiVIyl7vOSu2cCUMtX6ajAOClmB6wqzazFJHHsbZds+LqANHfk2ifx",
+	4: "sha384-XLBZ6AUF9+Ul+359q0ONXcVOXjx6esCfTieqwkxC6M0pZnDSGyRzKWkiAHUrWkZa",
+	5: "sha384-ohvswL/7b0gxoRaFC9kmnylwNsyRigXs77DyYa6djLCBPo1RB0eKpd+r1U8VUVNW",
+}
+
+func TestScriptIntegrity(t *testing.T) {
+	want, ok := scriptIntegrities[ScriptVersion]
+	if !ok {
+		t.Fatalf("version %d is not listed, add %s", ScriptVersion, ScriptIntegrity())
+	}
+	if got := ScriptIntegrity(); got != want {
+		t.Errorf("static/track.js changed, bump ScriptVersion: got %s, want %s", got, want)
+	}
+	if got := ScriptPath(); got != "/js/script.v5.js" {
+		t.Errorf("path: got %s", got)
+	}
+}
@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+var (
+	seedPaths = []string{
+		"/", "/about", "/contact", "/products", "/products/1", "/products/2",
+		"/blog", "/blog/post-1", "/blog/post-2", "/pricing", "/features", "/docs",
+	}
+	seedUserAgents = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Linux; Android 13; SM-G991U) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0",
+	}
+	seedReferrers = []string{
+		"https://www.google.com/", "https://duckduckgo.com/", "https://news.ycombinator.com/",
+		"https://www.reddit.com/", "https://github.com/", "", "", "",
+	}
+	seedGeos = []GeoInfo{
+		{Country: "United States", CountryISO: "US", RegionName: "California"},
+		{Country: "Germany", CountryISO: "DE", RegionName: "Berlin"},
+		{Country: "India", CountryISO: "IN", RegionName: "Karnataka"},
+		{Country: "Brazil", CountryISO: "BR", RegionName: "São Paulo"},
+		{Country: "Japan", CountryISO: "JP", RegionName: "Tokyo"},
+	}
+)
+
+// SeedDemoData adds perDay random page views for each of the last days days
+// to store, so a fresh dev instance has something to show.
+func SeedDemoData(ctx context.Context, store EventStore, siteID string, days, perDay int) error {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pick := func(list []string) string { return list[rnd.Intn(len(list))] }
+
+	now := clock.Now()
+	for d := 0; d < days; d++ {
+		occuredAt := TimeToInt(now.AddDate(0, 0, -d))
+		for i := 0; i < perDay; i++ {
+			uaString := pick(seedUserAgents)
+			referrer := pick(seedReferrers)
+			geo := seedGeos[rnd.Intn(len(seedGeos))]
+			visitor := fmt.Sprintf("demo-visitor-%d", rnd.Intn(perDay*3+1))
+
+			trk := Tracking{
+				SiteID: siteID,
+				Action: TrackingData{
+					Type:          PageviewType,
+					Identity:      visitor,
+					UserAgent:     uaString,
+					Event:         pick(seedPaths),
+					Category:      PageviewCategory,
+					Referrer:      referrer,
+					ReferrerHost:  ReferrerHost(referrer),
+					IsTouchDevice: rnd.Intn(3) == 0,
+					OccuredAt:     occuredAt,
+					// One visit per visitor and day
+					SessionID: fmt.Sprintf("%s-%d", visitor, occuredAt),
+				},
+			}
+			if err := store.Add(ctx, trk, useragent.Parse(uaString), &geo); err != nil {
+				return fmt.Errorf("failed to seed demo event: %w", err)
+			}
+		}
+	}
+	return nil
+}
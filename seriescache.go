@@ -0,0 +1,83 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SeriesCache keeps time series results for ttl. Public badges and feeds
+// are fetched on every page view of the pages embedding them, without an
+// API key to rate limit, so they read from it rather than from the store.
+// Concurrent misses of the same query wait for a single store query.
+type SeriesCache struct {
+	ttl   time.Duration
+	lock  sync.Mutex
+	cache map[string]*seriesEntry
+}
+
+type seriesEntry struct {
+	siteID  string
+	ready   chan struct{}
+	result  *TimeSeriesResult
+	err     error
+	expires time.Time
+}
+
+func NewSeriesCache(ttl time.Duration) *SeriesCache {
+	return &SeriesCache{ttl: ttl, cache: make(map[string]*seriesEntry)}
+}
+
+// TimeSeries returns the cached result of q, or queries store for it. A
+// failed query isn't cached.
+func (c *SeriesCache) TimeSeries(ctx context.Context, store EventStore, q TimeSeriesQuery) (*TimeSeriesResult, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%d", q.SiteID, q.Start, q.End)
+	now := clock.Now()
+
+	c.lock.Lock()
+	entry, ok := c.cache[key]
+	if !ok || (entry.done() && (entry.err != nil || !now.Before(entry.expires))) {
+		for k, e := range c.cache {
+			if e.done() && !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		entry = &seriesEntry{siteID: q.SiteID, ready: make(chan struct{})}
+		c.cache[key] = entry
+		c.lock.Unlock()
+
+		entry.result, entry.err = store.TimeSeries(ctx, q)
+		entry.expires = now.Add(c.ttl)
+		close(entry.ready)
+		return entry.result, entry.err
+	}
+	c.lock.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.result, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Forget drops the cached results of siteID, after its deletion.
+func (c *SeriesCache) Forget(siteID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, entry := range c.cache {
+		if entry.siteID == siteID {
+			delete(c.cache, key)
+		}
+	}
+}
+
+func (e *seriesEntry) done() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingStore counts the time series queries reaching the store, failing
+// them while err is set.
+type countingStore struct {
+	EventStore
+	queries int
+	err     error
+}
+
+func (s *countingStore) TimeSeries(ctx context.Context, q TimeSeriesQuery) (*TimeSeriesResult, error) {
+	s.queries++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.EventStore.TimeSeries(ctx, q)
+}
+
+func TestSeriesCache(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+	ctx := context.Background()
+
+	store := &countingStore{EventStore: NewMemoryEvents()}
+	c := NewSeriesCache(time.Minute)
+	week := TimeSeriesQuery{SiteID: "a", Start: 20240304, End: 20240310}
+	query := func() {
+		t.Helper()
+		if _, err := c.TimeSeries(ctx, store, week); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query()
+	query()
+	if store.queries != 1 {
+		t.Errorf("got %d queries for a cached result", store.queries)
+	}
+	if _, err := c.TimeSeries(ctx, store, TimeSeriesQuery{SiteID: "b", Start: 20240304, End: 20240310}); err != nil || store.queries != 2 {
+		t.Errorf("other site: got %d queries, %v", store.queries, err)
+	}
+
+	fc.Advance(2 * time.Minute)
+	query()
+	if store.queries != 3 {
+		t.Errorf("got %d queries after expiry", store.queries)
+	}
+
+	c.Forget("a")
+	store.err = errors.New("down")
+	if _, err := c.TimeSeries(ctx, store, week); err == nil {
+		t.Error("failed query: got no error")
+	}
+	store.err = nil
+	query()
+	if store.queries != 5 {
+		t.Errorf("got %d queries, failures must not be cached", store.queries)
+	}
+}
@@ -0,0 +1,97 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Uses of the tokens Sessions issue, a token issued for one is rejected for
+// the other.
+const (
+	// SessionUse tokens authenticate dashboard and API requests.
+	SessionUse = "session"
+	// LoginUse tokens carry the state of a sign-in in progress.
+	LoginUse = "login"
+)
+
+// SessionClaims are the claims of a token issued by Sessions.
+type SessionClaims struct {
+	Use      string `json:"use"`
+	Subject  string `json:"sub,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Name     string `json:"name,omitempty"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+
+	// Sign-in state: checked against what the provider sends back
+	State    string `json:"state,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	Verifier string `json:"verifier,omitempty"`
+	ReturnTo string `json:"returnTo,omitempty"`
+}
+
+// Sessions issues and checks local tokens once users signed in, HS256 JWTs
+// so they can be sent as a cookie or a bearer token.
+type Sessions struct {
+	key []byte
+}
+
+// NewSessions returns Sessions signing with secret. Without a secret a random
+// one is used, sessions then end when the server restarts.
+func NewSessions(secret string) *Sessions {
+	if secret == "" {
+		return &Sessions{key: []byte(RandomToken())}
+	}
+	return &Sessions{key: []byte(secret)}
+}
+
+// Issue returns a token for claims valid for ttl from now.
+func (s *Sessions) Issue(claims SessionClaims, now time.Time, ttl time.Duration) string {
+	claims.IssuedAt = now.Unix()
+	claims.Expiry = now.Add(ttl).Unix()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.sign([]byte(signed)))
+}
+
+// Parse checks a token was issued by s for use and hasn't expired.
+func (s *Sessions) Parse(token, use string, now time.Time) (SessionClaims, error) {
+	var claims SessionClaims
+	header, payload, signed, sig, err := splitJWT(token)
+	if err != nil {
+		return claims, err
+	}
+	if header.Alg != "HS256" || !hmac.Equal(sig, s.sign(signed)) {
+		return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Use != use {
+		return claims, fmt.Errorf("%w: not a %s token", ErrInvalidToken, use)
+	}
+	if now.Unix() >= claims.Expiry {
+		return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+func (s *Sessions) sign(b []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// RandomToken returns 32 random bytes, base64url encoded.
+func RandomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
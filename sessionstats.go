@@ -0,0 +1,156 @@
+package tracker
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Session metrics are computed per day from the page views of each session
+// that day, a session running past midnight counts on both days. Their rows
+// have no value and Count is the day's number of sessions, percentage of
+// bounces (sessions of a single page view) or average duration in seconds
+// from the first to the last page view.
+
+// sessionStat is how a session metric aggregates the sessions of a day.
+type sessionStat int
+
+const (
+	noSessionStat sessionStat = iota
+	sessionCount
+	sessionBounceRate
+	sessionDuration
+)
+
+// expr is the ClickHouse aggregate of the stat over sessions of views page
+// views lasting duration seconds.
+func (s sessionStat) expr() string {
+	switch s {
+	case sessionBounceRate:
+		return "toUInt64(round(100 * countIf(views = 1) / count()))"
+	case sessionDuration:
+		return "toUInt64(round(avg(duration)))"
+	}
+	return "count()"
+}
+
+// averaged reports whether the stat averages sessions, so that days of
+// several sites combine weighted by their sessions rather than summed.
+func (s sessionStat) averaged() bool {
+	return s == sessionBounceRate || s == sessionDuration
+}
+
+// sessionSpan is a session's page views on one day.
+type sessionSpan struct {
+	views       uint64
+	first, last time.Time
+}
+
+// value aggregates the sessions of a day like expr.
+func (s sessionStat) value(spans []sessionSpan) uint64 {
+	var bounces uint64
+	var seconds float64
+	for _, span := range spans {
+		if span.views == 1 {
+			bounces++
+		}
+		seconds += span.last.Sub(span.first).Seconds()
+	}
+	n := float64(len(spans))
+	switch s {
+	case sessionBounceRate:
+		return uint64(math.Round(100 * float64(bounces) / n))
+	case sessionDuration:
+		return uint64(math.Round(seconds / n))
+	}
+	return uint64(len(spans))
+}
+
+// genSessionQuery is GenQuery for session metrics, rows carry the number
+// of sessions of the day in a fifth column. It takes the arguments of
+// GenQuery but Extra.
+func genSessionQuery(def metricDef, filters string) string {
+	return fmt.Sprintf(`
+		SELECT occured_at, '', %s, site_id, count()
+		FROM (
+			SELECT site_id, occured_at, session_id, count() AS views,
+				dateDiff('second', min(happened_at), max(happened_at)) AS duration
+			FROM events
+			WHERE has($1, site_id)
+			AND occured_at BETWEEN $2 AND $3
+			AND category = 'Page views'
+			AND session_id != ''
+			AND ($4 = '' OR source = $4)
+			AND ($5 = '' OR touch = $5)
+			AND has($6, toString(quality))%s
+			GROUP BY site_id, occured_at, session_id
+		)
+		GROUP BY site_id, occured_at
+		ORDER BY occured_at;
+	`, def.session.expr(), filters)
+}
+
+// sessionMetrics aggregates the rows of data's sites and days, already
+// filtered, per site and day like genSessionQuery.
+func sessionMetrics(def metricDef, rows []qdata) []siteMetric {
+	type key struct {
+		site string
+		day  uint32
+	}
+	type sessionKey struct {
+		key
+		session string
+	}
+	spans := make(map[sessionKey]*sessionSpan)
+	var order []sessionKey
+	for _, row := range rows {
+		if row.trk.Action.SessionID == "" {
+			continue
+		}
+		k := sessionKey{key{row.trk.SiteID, row.trk.Action.OccuredAt}, row.trk.Action.SessionID}
+		at := row.happenedAt().Truncate(time.Second)
+		span, ok := spans[k]
+		if !ok {
+			span = &sessionSpan{first: at, last: at}
+			spans[k] = span
+			order = append(order, k)
+		}
+		span.views++
+		span.first = minTime(span.first, at)
+		span.last = maxTime(span.last, at)
+	}
+
+	days := make(map[key][]sessionSpan)
+	var dayOrder []key
+	for _, k := range order {
+		if _, ok := days[k.key]; !ok {
+			dayOrder = append(dayOrder, k.key)
+		}
+		days[k.key] = append(days[k.key], *spans[k])
+	}
+
+	metrics := make([]siteMetric, 0, len(days))
+	for _, k := range dayOrder {
+		day := days[k]
+		metrics = append(metrics, siteMetric{
+			Metric:   Metric{OccuredAt: k.day, Count: def.session.value(day)},
+			site:     k.site,
+			sessions: uint64(len(day)),
+		})
+	}
+	return metrics
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,76 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestMemorySessionStats(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	add := func(site, session, category string, after time.Duration) {
+		fc.Advance(after)
+		trk := Tracking{SiteID: site, Action: TrackingData{Identity: "u", Event: "/", Category: category, OccuredAt: 20240301, SessionID: session}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// On a, v1 lasts 60s over two page views and v2 bounces, custom events
+	// and anonymous page views aren't part of sessions. On b, v3 bounces.
+	add("a", "v1", PageviewCategory, 0)
+	add("a", "v2", PageviewCategory, 0)
+	add("a", "v1", "Clicks", 30*time.Second)
+	add("a", "v1", PageviewCategory, 30*time.Second)
+	add("a", "", PageviewCategory, 0)
+	add("b", "v3", PageviewCategory, 0)
+
+	for _, c := range []struct {
+		what        QueryType
+		a, combined uint64
+	}{
+		{QuerySessions, 2, 3},
+		{QueryBounceRate, 50, 67},
+		{QuerySessionDuration, 30, 20},
+	} {
+		got, err := m.GetStats(ctx, MetricData{What: c.what, SiteID: "a", Start: 20240301, End: 20240301})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Data) != 1 || got.Data[0] != (Metric{OccuredAt: 20240301, Count: c.a}) || got.Meta.Granularity != "day" {
+			t.Errorf("%s of a: got %+v", c.what, got.Data)
+		}
+
+		got, _ = m.GetStats(ctx, MetricData{What: c.what, SiteIDs: []string{"a", "b"}, Start: 20240301, End: 20240301})
+		if len(got.Data) != 1 || got.Data[0].Count != c.combined || len(got.BySite["b"]) != 1 {
+			t.Errorf("%s of a and b: got %+v", c.what, got)
+		}
+	}
+
+	// Time series count the same sessions
+	series, err := m.TimeSeries(ctx, TimeSeriesQuery{SiteID: "a", Start: 20240301, End: 20240301})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (TimeSeriesPoint{Day: 20240301, Visitors: 1, Pageviews: 4, Sessions: 2, BounceRate: 0.5}); len(series.Data) != 1 || series.Data[0] != want {
+		t.Errorf("time series of a: got %+v", series.Data)
+	}
+
+	// Durations are between when events happened, not when they arrived:
+	// c's events are sent together from a device
+	for _, ago := range []time.Duration{90 * time.Second, 0} {
+		trk := Tracking{SiteID: "c", Action: TrackingData{Identity: "u", Event: "/", Category: PageviewCategory, OccuredAt: 20240301, SessionID: "v4", HappenedAt: fc.Now().Add(-ago)}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := m.GetStats(ctx, MetricData{What: QuerySessionDuration, SiteID: "c", Start: 20240301, End: 20240301})
+	if len(got.Data) != 1 || got.Data[0].Count != 90 {
+		t.Errorf("duration of c: got %+v", got.Data)
+	}
+}
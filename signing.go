@@ -0,0 +1,114 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers carrying the signature of a payload sent to a site with a
+// signing key.
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+)
+
+const maxNonceLen = 64
+
+var (
+	// ErrUnsigned is returned when a site requires signed payloads and the
+	// request carries no signature.
+	ErrUnsigned = errors.New("payload is not signed")
+	// ErrBadSignature is returned when the signature doesn't match.
+	ErrBadSignature = errors.New("invalid payload signature")
+	// ErrStaleSignature is returned when the signature timestamp is too far
+	// from the current time.
+	ErrStaleSignature = errors.New("stale payload signature")
+	// ErrReplayedNonce is returned when a nonce was used before.
+	ErrReplayedNonce = errors.New("replayed signature nonce")
+	// ErrNonceCacheFull is returned when no more nonces can be remembered.
+	// Signed payloads are then rejected rather than risking replays.
+	ErrNonceCacheFull = errors.New("too many signed payloads")
+)
+
+// Sign returns the hex HMAC-SHA256 with key of the timestamp (unix seconds),
+// the nonce and the request body, each followed by a newline but the body.
+func Sign(key string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Nonces verifies payload signatures and remembers the nonces of accepted
+// ones for as long as their timestamp is valid, so a captured request can't
+// be sent again. At most size nonces are remembered.
+type Nonces struct {
+	lock   sync.Mutex
+	maxAge time.Duration
+	size   int
+	seen   map[string]time.Time // nonce to expiry
+}
+
+// NewNonces returns a Nonces accepting timestamps up to maxAge away from
+// the current time.
+func NewNonces(maxAge time.Duration, size int) *Nonces {
+	return &Nonces{maxAge: maxAge, size: size, seen: make(map[string]time.Time)}
+}
+
+// Verify checks the signature headers of a request to site with body, the
+// request body as sent, once decompressed. The nonce is remembered when the
+// signature is valid.
+func (n *Nonces) Verify(site Site, h http.Header, body []byte, now time.Time) error {
+	sig, tsHeader, nonce := h.Get(SignatureHeader), h.Get(SignatureTimestampHeader), h.Get(SignatureNonceHeader)
+	if sig == "" && tsHeader == "" && nonce == "" {
+		return ErrUnsigned
+	}
+	ts, err := strconv.ParseInt(tsHeader, 10, 64)
+	if err != nil || nonce == "" || len(nonce) > maxNonceLen {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(Sign(site.SigningKey, ts, nonce, body))) {
+		return ErrBadSignature
+	}
+
+	signedAt := time.Unix(ts, 0)
+	if signedAt.Before(now.Add(-n.maxAge)) || signedAt.After(now.Add(n.maxAge)) {
+		return ErrStaleSignature
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	key := site.ID + "\x00" + nonce
+	if expires, ok := n.seen[key]; ok && !now.After(expires) {
+		return ErrReplayedNonce
+	}
+	if len(n.seen) >= n.size {
+		n.expire(now)
+		if len(n.seen) >= n.size {
+			return ErrNonceCacheFull
+		}
+	}
+	// Past this the timestamp is stale, the nonce needn't be remembered
+	n.seen[key] = signedAt.Add(n.maxAge)
+	return nil
+}
+
+// expire forgets the nonces whose timestamps are stale, the lock must be held.
+func (n *Nonces) expire(now time.Time) {
+	for key, expires := range n.seen {
+		if now.After(expires) {
+			delete(n.seen, key)
+		}
+	}
+}
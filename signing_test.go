@@ -0,0 +1,77 @@
+package tracker
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedHeader(key string, ts int64, nonce string, body []byte) http.Header {
+	h := http.Header{}
+	h.Set(SignatureHeader, Sign(key, ts, nonce, body))
+	h.Set(SignatureTimestampHeader, strconv.FormatInt(ts, 10))
+	h.Set(SignatureNonceHeader, nonce)
+	return h
+}
+
+func TestNoncesVerify(t *testing.T) {
+	site := Site{ID: "s", SigningKey: "secret"}
+	body := []byte(`{"site_id":"s"}`)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	n := NewNonces(5*time.Minute, 10)
+
+	tests := []struct {
+		name string
+		h    http.Header
+		err  error
+	}{
+		{"unsigned", http.Header{}, ErrUnsigned},
+		{"valid", signedHeader("secret", now.Unix(), "n1", body), nil},
+		{"replayed", signedHeader("secret", now.Unix(), "n1", body), ErrReplayedNonce},
+		{"wrong key", signedHeader("other", now.Unix(), "n2", body), ErrBadSignature},
+		{"missing nonce", signedHeader("secret", now.Unix(), "", body), ErrBadSignature},
+		{"too old", signedHeader("secret", now.Add(-6*time.Minute).Unix(), "n3", body), ErrStaleSignature},
+		{"too new", signedHeader("secret", now.Add(6*time.Minute).Unix(), "n4", body), ErrStaleSignature},
+		{"slightly old", signedHeader("secret", now.Add(-4*time.Minute).Unix(), "n5", body), nil},
+	}
+	for _, tt := range tests {
+		if err := n.Verify(site, tt.h, body, now); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// A tampered body fails
+	if err := n.Verify(site, signedHeader("secret", now.Unix(), "n6", body), []byte(`{"site_id":"t"}`), now); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("tampered body: got %v", err)
+	}
+	// The same nonce is fine for another site
+	other := Site{ID: "o", SigningKey: "secret"}
+	if err := n.Verify(other, signedHeader("secret", now.Unix(), "n1", body), body, now); err != nil {
+		t.Errorf("other site: got %v", err)
+	}
+}
+
+func TestNoncesBounded(t *testing.T) {
+	site := Site{ID: "s", SigningKey: "secret"}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	n := NewNonces(time.Minute, 2)
+
+	for i, want := range []error{nil, nil, ErrNonceCacheFull} {
+		nonce := strconv.Itoa(i)
+		if err := n.Verify(site, signedHeader("secret", now.Unix(), nonce, nil), nil, now); !errors.Is(err, want) {
+			t.Errorf("nonce %d: got %v, want %v", i, err, want)
+		}
+	}
+
+	// Once the first nonces expire there is room again, a replay of one of
+	// them is rejected as stale
+	later := now.Add(61 * time.Second)
+	if err := n.Verify(site, signedHeader("secret", later.Unix(), "3", nil), nil, later); err != nil {
+		t.Errorf("after expiry: got %v", err)
+	}
+	if err := n.Verify(site, signedHeader("secret", now.Unix(), "0", nil), nil, later); !errors.Is(err, ErrStaleSignature) {
+		t.Errorf("expired replay: got %v", err)
+	}
+}
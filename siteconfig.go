@@ -0,0 +1,198 @@
+package tracker
+
+import (
+	"fmt"
+	"maps"
+	"time"
+)
+
+// ConfigVersion is the version of the ConfigDocument format, documents of
+// other versions are refused.
+const ConfigVersion = 1
+
+// ConfigDocument is the configuration of every site in a single document:
+// the registry and the saved segments, reports and campaigns. It is
+// exported from one instance and imported into another to promote staging
+// configuration to production or to restore it after a loss. API keys and
+// users are files of their own and not part of it. Sites carry their
+// signing keys, keep documents as safe as the keys.
+type ConfigDocument struct {
+	Version    int        `json:"version"`
+	ExportedAt time.Time  `json:"exportedAt"`
+	Sites      []Site     `json:"sites"`
+	Segments   []Segment  `json:"segments"`
+	Reports    []Report   `json:"reports"`
+	Campaigns  []Campaign `json:"campaigns"`
+}
+
+// ImportCounts are how many objects of a kind an import created and how
+// many it replaced.
+type ImportCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+// ConfigImport is the outcome of importing a ConfigDocument.
+type ConfigImport struct {
+	Sites     ImportCounts `json:"sites"`
+	Segments  ImportCounts `json:"segments"`
+	Reports   ImportCounts `json:"reports"`
+	Campaigns ImportCounts `json:"campaigns"`
+}
+
+// ExportConfig returns the configuration of every site.
+func ExportConfig(sites *Sites, saved *Saved) ConfigDocument {
+	doc := ConfigDocument{Version: ConfigVersion, ExportedAt: clock.Now().UTC(), Sites: sites.List()}
+	doc.Segments, doc.Reports, doc.Campaigns = saved.all()
+	return doc
+}
+
+// ImportConfig creates or replaces, by ID, every object of doc. Objects
+// missing from doc are kept. The document is validated as a whole before
+// anything changes, sites are then saved before the saved objects.
+func ImportConfig(doc ConfigDocument, sites *Sites, saved *Saved) (ConfigImport, error) {
+	var result ConfigImport
+	if doc.Version != ConfigVersion {
+		return result, fmt.Errorf("%w: document version %d, want %d", ErrInvalid, doc.Version, ConfigVersion)
+	}
+	for _, site := range doc.Sites {
+		if site.ID == "" {
+			return result, fmt.Errorf("%w: sites need an id", ErrInvalid)
+		}
+	}
+
+	segments, reports, campaigns, err := saved.check(doc)
+	if err != nil {
+		return result, err
+	}
+	if result.Sites, err = sites.putAll(doc.Sites); err != nil {
+		return result, err
+	}
+	result.Segments, result.Reports, result.Campaigns = segments, reports, campaigns
+	if err := saved.putAll(doc); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// putAll creates or replaces every site of list and saves once.
+func (s *Sites) putAll(list []Site) (ImportCounts, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sites == nil {
+		s.sites = make(map[string]Site)
+	}
+	prev := maps.Clone(s.sites)
+	var counts ImportCounts
+	for _, site := range list {
+		if _, ok := s.sites[site.ID]; ok {
+			counts.Updated++
+		} else {
+			counts.Created++
+		}
+		if site.CreatedAt.IsZero() {
+			site.CreatedAt = clock.Now().UTC()
+		}
+		s.sites[site.ID] = site
+	}
+	if err := s.save(); err != nil {
+		s.sites = prev
+		return ImportCounts{}, err
+	}
+	return counts, nil
+}
+
+// all returns every segment, report and campaign.
+func (s *Saved) all() ([]Segment, []Report, []Campaign) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	f := s.file()
+	return f.Segments, f.Reports, f.Campaigns
+}
+
+// check validates the saved objects of doc, reports may use segments of
+// doc or existing ones, and counts the objects doc creates and replaces.
+func (s *Saved) check(doc ConfigDocument) (segments, reports, campaigns ImportCounts, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	count := func(counts *ImportCounts, exists bool) {
+		if exists {
+			counts.Updated++
+		} else {
+			counts.Created++
+		}
+	}
+
+	segmentSites := make(map[string]string)
+	for id, seg := range s.segments {
+		segmentSites[id] = seg.SiteID
+	}
+	for _, seg := range doc.Segments {
+		if seg.ID == "" {
+			return segments, reports, campaigns, fmt.Errorf("%w: segments need an id", ErrInvalid)
+		}
+		if err := seg.validate(); err != nil {
+			return segments, reports, campaigns, fmt.Errorf("segment %s: %w", seg.ID, err)
+		}
+		_, exists := s.segments[seg.ID]
+		count(&segments, exists)
+		segmentSites[seg.ID] = seg.SiteID
+	}
+	for _, rep := range doc.Reports {
+		if rep.ID == "" {
+			return segments, reports, campaigns, fmt.Errorf("%w: reports need an id", ErrInvalid)
+		}
+		if err := rep.validate(); err != nil {
+			return segments, reports, campaigns, fmt.Errorf("report %s: %w", rep.ID, err)
+		}
+		if site, ok := segmentSites[rep.SegmentID]; rep.SegmentID != "" && (!ok || site != rep.SiteID) {
+			return segments, reports, campaigns, fmt.Errorf("%w: report %s uses unknown segment %q", ErrInvalid, rep.ID, rep.SegmentID)
+		}
+		_, exists := s.reports[rep.ID]
+		count(&reports, exists)
+	}
+	for _, c := range doc.Campaigns {
+		if c.ID == "" {
+			return segments, reports, campaigns, fmt.Errorf("%w: campaigns need an id", ErrInvalid)
+		}
+		if err := c.validate(); err != nil {
+			return segments, reports, campaigns, fmt.Errorf("campaign %s: %w", c.ID, err)
+		}
+		_, exists := s.campaigns[c.ID]
+		count(&campaigns, exists)
+	}
+	return segments, reports, campaigns, nil
+}
+
+// putAll creates or replaces the saved objects of doc, which check
+// validated, and saves once.
+func (s *Saved) putAll(doc ConfigDocument) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prevSegments, prevReports, prevCampaigns := maps.Clone(s.segments), maps.Clone(s.reports), maps.Clone(s.campaigns)
+	now := clock.Now().UTC()
+	for _, seg := range doc.Segments {
+		if seg.CreatedAt.IsZero() {
+			seg.CreatedAt, seg.UpdatedAt = now, now
+		}
+		s.segments[seg.ID] = seg
+	}
+	for _, rep := range doc.Reports {
+		if rep.CreatedAt.IsZero() {
+			rep.CreatedAt, rep.UpdatedAt = now, now
+		}
+		s.reports[rep.ID] = rep
+	}
+	for _, c := range doc.Campaigns {
+		s.campaigns[c.ID] = c
+	}
+	if err := s.save(); err != nil {
+		s.segments, s.reports, s.campaigns = prevSegments, prevReports, prevCampaigns
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package tracker
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	staging, stagingSaved := &Sites{}, &Saved{}
+	staging.Load("")
+	stagingSaved.Load("")
+	staging.Ensure(Site{ID: "shop", Domain: "shop.example", AllowedKinds: []string{"page"}})
+	seg, err := stagingSaved.PutSegment(Segment{SiteID: "shop", Name: "Mobile", Filters: map[string]string{"touch": "touch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stagingSaved.PutReport(Report{SiteID: "shop", Name: "Mobile pages", Metric: QueryPageViewList, SegmentID: seg.ID, RangeDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	doc := ExportConfig(staging, stagingSaved)
+
+	dir := t.TempDir()
+	prod, prodSaved := &Sites{}, &Saved{}
+	prod.Load(filepath.Join(dir, "sites.json"))
+	prodSaved.Load(filepath.Join(dir, "saved.json"))
+	prod.Ensure(Site{ID: "shop", Domain: "old.example"})
+
+	result, err := ImportConfig(doc, prod, prodSaved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ConfigImport{Sites: ImportCounts{Updated: 1}, Segments: ImportCounts{Created: 1}, Reports: ImportCounts{Created: 1}}
+	if result != want {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+
+	// Saved to disk
+	prod.Load(filepath.Join(dir, "sites.json"))
+	prodSaved.Load(filepath.Join(dir, "saved.json"))
+	if site, _ := prod.Get("shop"); site.Domain != "shop.example" || len(site.AllowedKinds) != 1 {
+		t.Errorf("got site %+v", site)
+	}
+	if reports := prodSaved.Reports("shop"); len(reports) != 1 || reports[0].SegmentID != seg.ID {
+		t.Errorf("got reports %+v", reports)
+	}
+
+	// Importing again replaces
+	if result, err := ImportConfig(doc, prod, prodSaved); err != nil || result.Reports != (ImportCounts{Updated: 1}) {
+		t.Errorf("reimport: got %+v, %v", result, err)
+	}
+}
+
+func TestImportConfigInvalid(t *testing.T) {
+	sites, saved := &Sites{}, &Saved{}
+	sites.Load("")
+	saved.Load("")
+
+	for name, doc := range map[string]ConfigDocument{
+		"version":          {Version: 2},
+		"site without id":  {Version: ConfigVersion, Sites: []Site{{Domain: "a.example"}}},
+		"unknown segment":  {Version: ConfigVersion, Reports: []Report{{ID: "r", SiteID: "s", Name: "r", Metric: QueryPageViews, RangeDays: 1, SegmentID: "nope"}}},
+		"invalid campaign": {Version: ConfigVersion, Sites: []Site{{ID: "s"}}, Campaigns: []Campaign{{ID: "c", SiteID: "s"}}},
+	} {
+		if _, err := ImportConfig(doc, sites, saved); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if len(sites.List()) != 0 {
+		t.Error("invalid document partly imported")
+	}
+}
@@ -0,0 +1,80 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// SiteDeleter is implemented by stores that can delete the data of a site
+// for good.
+type SiteDeleter interface {
+	// DeleteSite deletes every event, rollup, web vital and JavaScript
+	// error of siteID and returns the number of raw events deleted. The
+	// audit log is kept, it records the deletion.
+	DeleteSite(ctx context.Context, siteID string) (uint64, error)
+}
+
+// SiteDeletion is what deleting a site removed: its registration, raw
+// events, saved segments, reports, campaigns, dashboards and alert rules,
+// and the grants of API keys on it.
+type SiteDeletion struct {
+	SiteID     string `json:"siteId"`
+	Registered bool   `json:"registered"`
+	Events     uint64 `json:"events"`
+	Saved      int    `json:"saved"`
+	Grants     int    `json:"grants"`
+}
+
+// siteTables are the tables holding rows of a site. Their partitions span
+// all sites, by month at most, so the rows are deleted rather than
+// partitions dropped.
+var siteTables = []string{"events", "events_daily", "usage_daily", "site_totals", "web_vitals", "js_errors"}
+
+// DeleteSite deletes the rows of siteID from every table with a mutation,
+// waiting for each. It isn't atomic, events inserted for the site meanwhile
+// may be left behind: stop sending them and flush the queue first. Deleting
+// again removes what was left. During an upgrade the events are deleted from
+// UpgradeTable too, after events so a backfill can't copy them back, or
+// swapping the tables would bring them back.
+func (e *Events) DeleteSite(ctx context.Context, siteID string) (uint64, error) {
+	var deleted uint64
+	row := e.DB.QueryRow(ctx, "SELECT count() FROM events WHERE site_id = $1", siteID)
+	if err := row.Scan(&deleted); err != nil {
+		return 0, fmt.Errorf("failed to count events of %s: %w", siteID, err)
+	}
+
+	tables := siteTables
+	u, err := e.UpgradeStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if u != nil && u.State != UpgradeDropped {
+		tables = append(slices.Clone(siteTables), UpgradeTable)
+	}
+	for _, table := range tables {
+		qry := fmt.Sprintf("ALTER TABLE %s DELETE WHERE site_id = ? SETTINGS mutations_sync = 1", table)
+		if err := e.DB.Exec(ctx, qry, siteID); err != nil {
+			return 0, fmt.Errorf("failed to delete %s of %s: %w", table, siteID, err)
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryEvents) DeleteSite(ctx context.Context, siteID string) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var deleted uint64
+	kept := m.rows[:0]
+	for _, row := range m.rows {
+		if row.trk.SiteID == siteID {
+			deleted++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	clear(m.rows[len(kept):])
+	m.rows = kept
+	return deleted, nil
+}
@@ -0,0 +1,96 @@
+package tracker
+
+import "fmt"
+
+// SiteKeyKind is one of the two API keys a site may have of its own, for
+// integrations that should only ever reach that site.
+type SiteKeyKind string
+
+const (
+	SiteKeyRead  SiteKeyKind = "read"
+	SiteKeyWrite SiteKeyKind = "write"
+)
+
+// Role returns the role a key of kind has on its site, empty for unknown
+// kinds.
+func (k SiteKeyKind) Role() Role {
+	switch k {
+	case SiteKeyRead:
+		return RoleViewer
+	case SiteKeyWrite:
+		return RoleAdmin
+	}
+	return ""
+}
+
+// hash returns the field of site holding the hash of its key of kind.
+func (k SiteKeyKind) hash(site *Site) *string {
+	if k == SiteKeyWrite {
+		return &site.WriteKeyHash
+	}
+	return &site.ReadKeyHash
+}
+
+// SetKey gives site id a new key of kind, replacing any previous one, and
+// returns it. Only its hash is kept, the key can't be shown again.
+func (s *Sites) SetKey(id string, kind SiteKeyKind) (string, error) {
+	if kind.Role() == "" {
+		return "", fmt.Errorf("%w: key kind must be read or write", ErrInvalid)
+	}
+	key := RandomToken()
+	if _, err := s.Update(id, func(site *Site) { *kind.hash(site) = HashKey(key) }); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// RevokeKey removes the key of kind of site id, ErrNotFound when it has
+// none.
+func (s *Sites) RevokeKey(id string, kind SiteKeyKind) error {
+	if kind.Role() == "" {
+		return fmt.Errorf("%w: key kind must be read or write", ErrInvalid)
+	}
+	site, ok := s.Get(id)
+	if !ok || *kind.hash(&site) == "" {
+		return ErrNotFound
+	}
+	_, err := s.Update(id, func(site *Site) { *kind.hash(site) = "" })
+	return err
+}
+
+// HasKeys reports whether any site has a key of its own.
+func (s *Sites) HasKeys() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, site := range s.sites {
+		if site.ReadKeyHash != "" || site.WriteKeyHash != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupKey returns the principal of a site's own key, granted its role on
+// that site only.
+func (s *Sites) LookupKey(key string) (Principal, bool) {
+	if key == "" {
+		return Principal{}, false
+	}
+	hash := HashKey(key)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, site := range s.sites {
+		for _, kind := range []SiteKeyKind{SiteKeyRead, SiteKeyWrite} {
+			if h := *kind.hash(&site); h != "" && h == hash {
+				return Principal{
+					Name:   "site:" + site.ID + ":" + string(kind),
+					Grants: []Grant{{Site: site.ID, Role: kind.Role()}},
+				}, true
+			}
+		}
+	}
+	return Principal{}, false
+}
@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSiteKeys(t *testing.T) {
+	s := &Sites{}
+	s.Load("")
+	s.Ensure(Site{ID: "a"})
+	s.Ensure(Site{ID: "b"})
+
+	if s.HasKeys() {
+		t.Error("keys before any was set")
+	}
+	read, err := s.SetKey("a", SiteKeyRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write, _ := s.SetKey("a", SiteKeyWrite)
+	if !s.HasKeys() {
+		t.Error("no keys once set")
+	}
+
+	p, ok := s.LookupKey(read)
+	if !ok || !p.Can(Site{ID: "a"}, RoleViewer) || p.Can(Site{ID: "a"}, RoleAdmin) || p.Can(Site{ID: "b"}, RoleViewer) {
+		t.Errorf("read key: got %+v, %v", p, ok)
+	}
+	p, ok = s.LookupKey(write)
+	if !ok || !p.Can(Site{ID: "a"}, RoleAdmin) || p.Can(Site{ID: "a"}, RoleOwner) {
+		t.Errorf("write key: got %+v, %v", p, ok)
+	}
+
+	// A new key replaces the previous one
+	again, _ := s.SetKey("a", SiteKeyRead)
+	if _, ok := s.LookupKey(read); ok {
+		t.Error("replaced key still valid")
+	}
+	if _, ok := s.LookupKey(again); !ok {
+		t.Error("new key not valid")
+	}
+
+	if err := s.RevokeKey("a", SiteKeyWrite); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.LookupKey(write); ok {
+		t.Error("revoked key still valid")
+	}
+	if err := s.RevokeKey("a", SiteKeyWrite); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke twice: got %v", err)
+	}
+	if _, err := s.SetKey("a", "owner"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown kind: got %v", err)
+	}
+	if _, err := s.SetKey("nope", SiteKeyRead); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown site: got %v", err)
+	}
+	if _, ok := s.LookupKey(""); ok {
+		t.Error("empty key valid")
+	}
+}
@@ -0,0 +1,133 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// SiteMerger is implemented by stores that can move the events of one site
+// to another, after a site was renamed or registered twice.
+type SiteMerger interface {
+	// MergeSite moves every event and rollup of site from to site into,
+	// and returns the number of raw events moved. from is left without
+	// events.
+	MergeSite(ctx context.Context, from, into string) (uint64, error)
+}
+
+// mergeTables are the tables rows are copied between sites from, their
+// materialized views fill them from the copied raw events. Days older
+// than the site's oldest raw event only live in the rollups and are copied
+// from them.
+var mergeTables = []string{"events_daily", "usage_daily"}
+
+// rawMergeTables are filled by the tracker rather than from events, they
+// are copied whole.
+var rawMergeTables = []string{"web_vitals", "js_errors"}
+
+// mergeStep is a copy of MergeSite, recorded in site_merges once done.
+type mergeStep struct {
+	name, query string
+	args        []any
+}
+
+// MergeSite copies the events of from to into with INSERT SELECT, then
+// deletes them. It isn't atomic, rows inserted for from meanwhile may be
+// left behind: redirect from's events and flush the queue first.
+//
+// Each copy is recorded in the site_merges table once done, so merging
+// again after a failure resumes with the next copy instead of doubling the
+// rows of into. A copy that failed before it was recorded may have been
+// inserted partly and is done again.
+func (e *Events) MergeSite(ctx context.Context, from, into string) (uint64, error) {
+	done, moved, oldest, err := e.mergeProgress(ctx, from, into)
+	if err != nil {
+		return 0, err
+	}
+	if len(done) == 0 {
+		row := e.DB.QueryRow(ctx, "SELECT count(), min(occured_at) FROM events WHERE site_id = $1", from)
+		if err := row.Scan(&moved, &oldest); err != nil {
+			return 0, fmt.Errorf("failed to count events of %s: %w", from, err)
+		}
+		if moved == 0 {
+			oldest = math.MaxUint32
+		}
+	}
+
+	// Rollups first, the raw copy fills those of later days
+	var steps []mergeStep
+	for _, table := range mergeTables {
+		steps = append(steps, mergeStep{table, fmt.Sprintf("INSERT INTO %s SELECT * REPLACE ($2 AS site_id) FROM %[1]s WHERE site_id = $1 AND day < $3", table), []any{from, into, oldest}})
+	}
+	// Totals of the rolled up days, uniq states merge with the raw ones
+	steps = append(steps, mergeStep{"site_totals", `
+		INSERT INTO site_totals
+		SELECT $2, sumIf(events, dimension = 'event'), uniqStateIf(value, dimension = 'user_id'), min(day)
+		FROM events_daily
+		WHERE site_id = $1 AND dimension IN ('event', 'user_id') AND day < $3
+		GROUP BY site_id
+	`, []any{from, into, oldest}})
+	for _, table := range rawMergeTables {
+		steps = append(steps, mergeStep{table, fmt.Sprintf("INSERT INTO %s SELECT * REPLACE ($2 AS site_id) FROM %[1]s WHERE site_id = $1", table), []any{from, into}})
+	}
+	steps = append(steps, mergeStep{"events", "INSERT INTO events SELECT * REPLACE ($2 AS site_id) FROM events WHERE site_id = $1", []any{from, into}})
+
+	for _, step := range steps {
+		if done[step.name] {
+			continue
+		}
+		if err := e.DB.Exec(ctx, step.query, step.args...); err != nil {
+			return 0, fmt.Errorf("failed to copy %s of %s: %w", step.name, from, err)
+		}
+		if err := e.DB.Exec(ctx, "INSERT INTO site_merges (from_site, into_site, step, moved, oldest) VALUES (?, ?, ?, ?, ?)", from, into, step.name, moved, oldest); err != nil {
+			return 0, fmt.Errorf("failed to record merge of %s: %w", step.name, err)
+		}
+	}
+
+	for _, table := range siteTables {
+		qry := fmt.Sprintf("ALTER TABLE %s DELETE WHERE site_id = ? SETTINGS mutations_sync = 1", table)
+		if err := e.DB.Exec(ctx, qry, from); err != nil {
+			return 0, fmt.Errorf("failed to delete %s of %s: %w", table, from, err)
+		}
+	}
+	if err := e.DB.Exec(ctx, "ALTER TABLE site_merges DELETE WHERE from_site = ? AND into_site = ? SETTINGS mutations_sync = 1", from, into); err != nil {
+		return 0, fmt.Errorf("failed to clear merge of %s: %w", from, err)
+	}
+	return moved, nil
+}
+
+// mergeProgress returns the copies already done by an interrupted merge of
+// from into into, and the event count and oldest day it started with.
+func (e *Events) mergeProgress(ctx context.Context, from, into string) (map[string]bool, uint64, uint32, error) {
+	rows, err := e.DB.Query(ctx, "SELECT step, moved, oldest FROM site_merges WHERE from_site = $1 AND into_site = $2", from, into)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read merge of %s: %w", from, err)
+	}
+	defer rows.Close()
+
+	done := map[string]bool{}
+	var moved uint64
+	var oldest uint32
+	for rows.Next() {
+		var step string
+		if err := rows.Scan(&step, &moved, &oldest); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to read merge of %s: %w", from, err)
+		}
+		done[step] = true
+	}
+	return done, moved, oldest, rows.Err()
+}
+
+func (m *MemoryEvents) MergeSite(ctx context.Context, from, into string) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var moved uint64
+	for i := range m.rows {
+		if m.rows[i].trk.SiteID == from {
+			m.rows[i].trk.SiteID = into
+			moved++
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,223 @@
+package tracker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Site struct {
+	ID        string    `json:"id"`
+	Domain    string    `json:"domain"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Org groups the sites of one owner, stats can combine all of them.
+	Org string `json:"org,omitempty"`
+
+	// OriginCheck overrides ORIGIN_CHECK for this site, empty keeps the
+	// default.
+	OriginCheck OriginCheck `json:"originCheck,omitempty"`
+
+	// ConsentUnknown overrides CONSENT_UNKNOWN for this site, empty keeps
+	// the default.
+	ConsentUnknown ConsentPolicy `json:"consentUnknown,omitempty"`
+
+	// VisitorCookie makes /track identify this site's visitors with a
+	// cookie it sets, for sites whose privacy policy allows cookies.
+	VisitorCookie bool `json:"visitorCookie,omitempty"`
+
+	// SigningKey, when set, is the secret server-side senders sign this
+	// site's payloads with. Unsigned, stale and replayed payloads are
+	// rejected, browsers can't keep a secret so beacons can't be sent.
+	SigningKey string `json:"signingKey,omitempty"`
+
+	// Unverified sites registered while SITE_VERIFICATION is required get
+	// no events until their owner published VerificationToken on Domain.
+	Unverified        bool       `json:"unverified,omitempty"`
+	VerificationToken string     `json:"verificationToken,omitempty"`
+	VerifiedAt        *time.Time `json:"verifiedAt,omitempty"`
+
+	// AllowedCountries, when set, are the only countries events are kept
+	// from, BlockedCountries are dropped. Both hold ISO 3166 codes, events
+	// geo lookups failed for are kept.
+	AllowedCountries []string `json:"allowedCountries,omitempty"`
+	BlockedCountries []string `json:"blockedCountries,omitempty"`
+
+	// AllowedKinds, when set, are the only event types ("page", "event")
+	// and categories accepted for this site. Events of other kinds are
+	// rejected, or kept and counted when UnlistedKinds is flag.
+	AllowedKinds  []string   `json:"allowedKinds,omitempty"`
+	UnlistedKinds KindPolicy `json:"unlistedKinds,omitempty"`
+
+	// OwnIPs are the addresses and CIDR ranges of the site owner, whose
+	// visits are stored as QualitySelf.
+	OwnIPs []string `json:"ownIps,omitempty"`
+
+	// TestMode soft launches the site: its events go through the whole
+	// pipeline but are stored as QualityTest, so stats leave them out
+	// unless asked for them.
+	TestMode bool `json:"testMode,omitempty"`
+
+	// OmitFields are the OmittableFields never stored for this site, for
+	// strict data minimization policies.
+	OmitFields []string `json:"omitFields,omitempty"`
+
+	// MergedInto is the site this site's events were merged into, events
+	// still sent to this site are recorded for it.
+	MergedInto string `json:"mergedInto,omitempty"`
+
+	// MonthlyQuota overrides QUOTA_MONTHLY_EVENTS for this site, 0 keeps the default.
+	MonthlyQuota uint64 `json:"monthlyQuota,omitempty"`
+
+	// PublicToken, when set, is the public dashboard token. Anyone holding
+	// it can embed the site's badges, it grants no access to the API.
+	PublicToken string `json:"publicToken,omitempty"`
+
+	// PublicStats publishes the site's daily visitors and page views for
+	// anyone to read, for transparency pages.
+	PublicStats bool `json:"publicStats,omitempty"`
+
+	// ReadKeyHash and WriteKeyHash are the hashes of the site's own API
+	// keys, see HashKey. The read key is a viewer of the site and the write
+	// key an admin of it, neither can reach other sites.
+	ReadKeyHash  string `json:"readKeyHash,omitempty"`
+	WriteKeyHash string `json:"writeKeyHash,omitempty"`
+}
+
+// Sites is the registry of known sites. It is kept in memory and written to a
+// JSON file when a path is set.
+type Sites struct {
+	lock  sync.RWMutex
+	path  string
+	sites map[string]Site
+}
+
+// Load reads the registry from path. A missing file is not an error, it will
+// be created on the first write. An empty path keeps the registry in memory.
+func (s *Sites) Load(path string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.path = path
+	s.sites = make(map[string]Site)
+	if path == "" {
+		return nil
+	}
+
+	var list []Site
+	if err := readJSONFile(path, &list); err != nil {
+		return err
+	}
+	for _, site := range list {
+		s.sites[site.ID] = site
+	}
+	return nil
+}
+
+func (s *Sites) Get(id string) (Site, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	site, ok := s.sites[id]
+	return site, ok
+}
+
+// List returns all sites ordered by ID.
+func (s *Sites) List() []Site {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := make([]Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		list = append(list, site)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// InOrg returns the IDs of the sites of org ordered by ID.
+func (s *Sites) InOrg(org string) []string {
+	var ids []string
+	for _, site := range s.List() {
+		if site.Org == org {
+			ids = append(ids, site.ID)
+		}
+	}
+	return ids
+}
+
+// Ensure registers site if no site with the same ID exists yet and returns
+// the registered site along with whether it was created.
+func (s *Sites) Ensure(site Site) (Site, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sites == nil {
+		s.sites = make(map[string]Site)
+	}
+	if existing, ok := s.sites[site.ID]; ok {
+		return existing, false, nil
+	}
+
+	if site.CreatedAt.IsZero() {
+		site.CreatedAt = clock.Now().UTC()
+	}
+	s.sites[site.ID] = site
+	if err := s.save(); err != nil {
+		delete(s.sites, site.ID)
+		return Site{}, false, err
+	}
+	return site, true, nil
+}
+
+// Update applies fn to the registered site id and saves the result.
+func (s *Sites) Update(id string, fn func(*Site)) (Site, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prev, ok := s.sites[id]
+	if !ok {
+		return Site{}, ErrNotFound
+	}
+	site := prev
+	fn(&site)
+	site.ID = id
+	s.sites[id] = site
+	if err := s.save(); err != nil {
+		s.sites[id] = prev
+		return Site{}, err
+	}
+	return site, nil
+}
+
+// Delete removes the registered site id. Its events are kept.
+func (s *Sites) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prev, ok := s.sites[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.sites, id)
+	if err := s.save(); err != nil {
+		s.sites[id] = prev
+		return err
+	}
+	return nil
+}
+
+// save writes the registry to disk, the lock must be held.
+func (s *Sites) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		list = append(list, site)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return writeJSONFile(s.path, list)
+}
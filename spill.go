@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"expvar"
+	"log/slog"
+	"time"
+)
+
+// While ClickHouse is slow the insert queue fills up and Add blocks, which
+// holds up enrichment and in turn the requests submitting events. With a
+// spill spool, events added above the queue's high-water mark are written
+// to disk instead, and queued again once the queue has room. Spilled events
+// survive restarts.
+
+// spillStats is published on /debug/vars.
+var spillStats = expvar.NewMap("queue_spill")
+
+// Every spillDrainInterval at most spillBatch spilled events are queued
+// again.
+const (
+	spillDrainInterval = 5 * time.Second
+	spillBatch         = 500
+)
+
+// SpillTo spills events added while at least highWater events wait in the
+// channel to spool. It must be called before Run.
+func (e *Events) SpillTo(spool *Spool, highWater int) {
+	e.spill = spool
+	e.highWater = min(max(highWater, 1), cap(e.ch))
+}
+
+// spilled writes an event to the spill spool when the queue is above its
+// high-water mark. It returns false when the event must be queued.
+func (e *Events) spilled(data qdata) bool {
+	if e.spill == nil || len(e.ch) < e.highWater {
+		return false
+	}
+	err := e.spill.Append(SpooledEvent{Tracking: data.trk, Geo: data.geo, Late: data.trk.Action.Late, Quality: data.trk.Action.Quality, SessionID: data.trk.Action.SessionID, Reason: "overflow"})
+	if err != nil {
+		e.log.Error("Failed to spill event, waiting for the queue", slog.Any("error", err))
+		return false
+	}
+	spillStats.Add("spilled", 1)
+	return true
+}
+
+// unspill moves up to spillBatch spilled events to the queue once the
+// channel is at most half as full as its high-water mark, the others stay spilled. It
+// returns the number of events moved and is only called from Run.
+func (e *Events) unspill() int {
+	if e.spill.Len() == 0 || len(e.ch) > e.highWater/2 {
+		return 0
+	}
+
+	moved := 0
+	drained, err := e.spill.Drain(func(ev SpooledEvent) error {
+		if moved >= spillBatch {
+			return errStopDrain
+		}
+		moved++
+		ev.Tracking.Action.Late = ev.Late
+		ev.Tracking.Action.Quality = ev.Quality
+		ev.Tracking.Action.SessionID = ev.SessionID
+		geo := ev.Geo
+		if geo == nil {
+			geo = &GeoInfo{}
+		}
+		e.lock.Lock()
+		e.q = append(e.q, qdata{trk: ev.Tracking, ua: ParseUserAgent(ev.Tracking.Action.UserAgent), geo: geo, queuedAt: ev.SpooledAt})
+		e.lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		e.log.Error("Failed to drain spilled events", slog.Any("error", err))
+	}
+	if drained > 0 {
+		spillStats.Add("unspilled", int64(drained))
+		e.log.Info("Queued spilled events", slog.Int("count", drained), slog.Int("remaining", e.spill.Len()))
+	}
+	return drained
+}
@@ -0,0 +1,46 @@
+package tracker
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestSpill(t *testing.T) {
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	e := &Events{ch: make(chan qdata, 4), log: slog.Default()}
+	e.SpillTo(spool, 2)
+
+	ctx := context.Background()
+	for i, path := range []string{"/a", "/b", "/c"} {
+		trk := Tracking{SiteID: "site", Action: TrackingData{Event: path, OccuredAt: 20240301, Late: i == 2}}
+		if err := e.Add(ctx, trk, useragent.UserAgent{}, &GeoInfo{Country: "France"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(e.ch) != 2 || spool.Len() != 1 || e.QueueStats().Spilled != 1 {
+		t.Fatalf("got %d queued, %d spilled", len(e.ch), spool.Len())
+	}
+
+	// The queue is still above half the high-water mark
+	if n := e.unspill(); n != 0 {
+		t.Errorf("unspilled %d events under pressure", n)
+	}
+	<-e.ch
+	<-e.ch
+	if n := e.unspill(); n != 1 || spool.Len() != 0 {
+		t.Fatalf("unspilled %d events, %d left", n, spool.Len())
+	}
+	got := e.q[0]
+	if got.trk.Action.Event != "/c" || !got.trk.Action.Late || got.geo.Country != "France" {
+		t.Errorf("got %+v %+v", got.trk, got.geo)
+	}
+}
@@ -0,0 +1,221 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SpooledEvent is an event set aside for later processing, with the reason
+// it couldn't be processed right away.
+type SpooledEvent struct {
+	Tracking  Tracking  `json:"tracking"`
+	IP        string    `json:"ip,omitempty"`
+	Reason    string    `json:"reason"`
+	SpooledAt time.Time `json:"spooledAt"`
+	// Geo is set for events spilled once enriched
+	Geo *GeoInfo `json:"geo,omitempty"`
+	// Late is kept apart, TrackingData doesn't encode it
+	Late bool `json:"late,omitempty"`
+	// Quality and SessionID too
+	Quality   string `json:"quality,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+// Spool is an append-only JSON lines file of events waiting to be processed
+// again, it survives restarts. Draining moves the file aside and reads it
+// from where the previous drain stopped, the number of bytes drained from
+// it is kept next to it so a restart resumes there too.
+type Spool struct {
+	lock sync.Mutex
+	path string
+	f    *os.File
+	size int
+	// offset is how far the draining file was drained
+	offset int64
+}
+
+// errStopDrain stops a drain, the event it is returned for and the ones
+// after it stay spooled.
+var errStopDrain = errors.New("drain stopped")
+
+func OpenSpool(path string) (*Spool, error) {
+	s := &Spool{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	// Count what a previous run left behind, a drain that didn't finish
+	// resumes where it stopped
+	if err := readJSONFile(s.offsetPath(), &s.offset); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	n := 0
+	count := func(SpooledEvent) error { n++; return nil }
+	if _, err := s.scan(s.drainingPath(), s.offset, count); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	if _, err := s.scan(path, 0, count); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	s.size = n
+	return s, nil
+}
+
+func (s *Spool) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %w", err)
+	}
+	s.f = f
+	return nil
+}
+
+func (s *Spool) drainingPath() string { return s.path + ".draining" }
+func (s *Spool) offsetPath() string   { return s.path + ".offset" }
+
+// Len returns the number of spooled events.
+func (s *Spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+func (s *Spool) Append(ev SpooledEvent) error {
+	if ev.SpooledAt.IsZero() {
+		ev.SpooledAt = clock.Now().UTC()
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to encode spooled event: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	s.size++
+	return nil
+}
+
+// Drain hands the spooled events to fn in order and removes them from the
+// spool. Events fn returns an error for are spooled again, unless the error
+// is errStopDrain: the drain then stops and that event and the ones after it
+// are drained next time. Events appended while draining are kept for a later
+// drain. Only the part drained is dropped, the rest of the file is neither
+// read nor rewritten. A crash before a drain returns hands the events of
+// that drain to fn again.
+func (s *Spool) Drain(fn func(SpooledEvent) error) (drained int, err error) {
+	s.lock.Lock()
+	if _, statErr := os.Stat(s.drainingPath()); errors.Is(statErr, os.ErrNotExist) {
+		if err := s.f.Close(); err != nil {
+			s.lock.Unlock()
+			return 0, fmt.Errorf("failed to close spool: %w", err)
+		}
+		if err := os.Rename(s.path, s.drainingPath()); err != nil {
+			s.open()
+			s.lock.Unlock()
+			return 0, fmt.Errorf("failed to rotate spool: %w", err)
+		}
+		s.offset = 0
+		if err := s.open(); err != nil {
+			s.lock.Unlock()
+			return 0, err
+		}
+	}
+	offset := s.offset
+	s.lock.Unlock()
+
+	var failed []SpooledEvent
+	consumed := 0
+	end, err := s.scan(s.drainingPath(), offset, func(ev SpooledEvent) error {
+		if err := fn(ev); errors.Is(err, errStopDrain) {
+			return err
+		} else if err != nil {
+			failed = append(failed, ev)
+		} else {
+			drained++
+		}
+		consumed++
+		return nil
+	})
+	stopped := errors.Is(err, errStopDrain)
+	if stopped {
+		err = nil
+	}
+
+	s.lock.Lock()
+	s.size -= consumed
+	s.offset = end
+	s.lock.Unlock()
+	for _, ev := range failed {
+		if appendErr := s.Append(ev); appendErr != nil && err == nil {
+			err = appendErr
+		}
+	}
+	if !stopped && err == nil {
+		// Drained to the end
+		if err := os.Remove(s.drainingPath()); err != nil {
+			return drained, fmt.Errorf("failed to remove drained spool: %w", err)
+		}
+		os.Remove(s.offsetPath())
+		s.lock.Lock()
+		s.offset = 0
+		s.lock.Unlock()
+		return drained, nil
+	}
+	if saveErr := writeJSONFile(s.offsetPath(), end); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return drained, err
+}
+
+func (s *Spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.f.Close()
+}
+
+// scan calls fn for each event in the spool file at path from offset on,
+// skipping lines that can't be decoded and a last line without its newline,
+// one being written or cut short by a crash. It stops at the first error of
+// fn and returns the offset of the first line not processed.
+func (s *Spool) scan(path string, offset int64, fn func(SpooledEvent) error) (int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return offset, nil
+	} else if err != nil {
+		return offset, fmt.Errorf("failed to read spool: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, fmt.Errorf("failed to read spool: %w", err)
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		} else if err != nil {
+			return offset, fmt.Errorf("failed to read spool: %w", err)
+		}
+		var ev SpooledEvent
+		if json.Unmarshal(line, &ev) == nil {
+			if err := fn(ev); err != nil {
+				return offset, err
+			}
+		}
+		offset += int64(len(line))
+	}
+}
@@ -0,0 +1,127 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpoolDrain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	spool, err := OpenSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := spool.Append(SpooledEvent{Tracking: Tracking{SiteID: fmt.Sprint(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stopping keeps the rest, failures are spooled again
+	var got []string
+	drain := func(stopAt string, fail string) int {
+		t.Helper()
+		n, err := spool.Drain(func(ev SpooledEvent) error {
+			switch ev.Tracking.SiteID {
+			case stopAt:
+				return errStopDrain
+			case fail:
+				return errors.New("not now")
+			}
+			got = append(got, ev.Tracking.SiteID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := drain("2", "1"); n != 1 || spool.Len() != 4 || fmt.Sprint(got) != "[0]" {
+		t.Fatalf("drained %d, %d left, got %v", n, spool.Len(), got)
+	}
+	spool.Append(SpooledEvent{Tracking: Tracking{SiteID: "5"}})
+
+	// A restart resumes the drain where it stopped
+	spool.Close()
+	if spool, err = OpenSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	if spool.Len() != 5 {
+		t.Fatalf("got %d spooled after reopening, want 5", spool.Len())
+	}
+	if n := drain("", ""); n != 3 || fmt.Sprint(got) != "[0 2 3 4]" {
+		t.Fatalf("drained %d, got %v", n, got)
+	}
+	if n := drain("", ""); n != 2 || spool.Len() != 0 || fmt.Sprint(got) != "[0 2 3 4 1 5]" {
+		t.Fatalf("drained %d, %d left, got %v", n, spool.Len(), got)
+	}
+	for _, leftover := range []string{path + ".draining", path + ".offset"} {
+		if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind: %v", leftover, err)
+		}
+	}
+}
+
+func TestSpoolResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	// A crash stopped a drain after the first event
+	first := `{"tracking":{"site_id":"a"}}` + "\n"
+	if err := os.WriteFile(path+".draining", []byte(first+`{"tracking":{"site_id":"b"}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".offset", []byte(fmt.Sprint(len(first))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"tracking":{"site_id":"c"}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spool, err := OpenSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	if spool.Len() != 2 {
+		t.Fatalf("got %d spooled, want 2", spool.Len())
+	}
+
+	var got []string
+	for range 2 {
+		if _, err := spool.Drain(func(ev SpooledEvent) error {
+			got = append(got, ev.Tracking.SiteID)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(got) != "[b c]" || spool.Len() != 0 {
+		t.Errorf("got %v, %d left", got, spool.Len())
+	}
+	for _, leftover := range []string{path + ".draining", path + ".offset"} {
+		if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind: %v", leftover, err)
+		}
+	}
+}
+
+func TestSpoolPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	// A crash cut the last line short
+	if err := os.WriteFile(path+".draining", []byte(`{"tracking":{"site_id":"a"}}`+"\n"+`{"tracking":{"si`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spool, err := OpenSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	if spool.Len() != 1 {
+		t.Fatalf("got %d spooled, want 1", spool.Len())
+	}
+	if n, err := spool.Drain(func(SpooledEvent) error { return nil }); n != 1 || err != nil || spool.Len() != 0 {
+		t.Errorf("drained %d, %v, %d left", n, err, spool.Len())
+	}
+}
@@ -6,9 +6,33 @@ interface TrackingData {
   category: string;
   referrer: string;
   isTouchDevice: boolean;
+  consent: Consent;
+  campaign: string;
+  utmSource: string;
+  utmMedium: string;
+  utmTerm: string;
+  utmContent: string;
 }
 
+// Campaign is the UTM parameters of a landing page
+type Campaign = Pick<
+  TrackingData,
+  "campaign" | "utmSource" | "utmMedium" | "utmTerm" | "utmContent"
+>;
+
+const noCampaign: Campaign = {
+  campaign: "",
+  utmSource: "",
+  utmMedium: "",
+  utmTerm: "",
+  utmContent: "",
+};
+
+type Consent = "granted" | "denied" | "unknown";
+
 interface TrackPayload {
+  // v is the payload format version, see PayloadVersion
+  v: number;
   tracking: TrackingData;
   site_id: string;
 }
@@ -18,16 +42,27 @@ class Tracker {
   private siteId: string = "";
   private referrer: string = "";
   private isTouch = false;
-
-  constructor(siteId: string, ref: string) {
+  private consentState: Consent = "unknown";
+  // campaign is the landing page's UTM parameters, only its view carries
+  // them
+  private campaign: Campaign = noCampaign;
+
+  constructor(
+    siteId: string,
+    ref: string,
+    consent: Consent = "unknown",
+    campaign: Campaign = noCampaign
+  ) {
     this.siteId = siteId;
     this.referrer = ref;
+    this.campaign = campaign;
     this.isTouch = "ontouchstart" in window || navigator.maxTouchPoints > 0;
 
     const customId = this.getSession("id");
     if (customId) {
       this.id = customId;
     }
+    this.consentState = this.getSession("consent") || consent;
   }
 
   private getSession(key) {
@@ -44,6 +79,13 @@ class Tracker {
     localStorage.setItem(key, JSON.stringify(value));
   }
 
+  // consent records the visitor's choice from the consent banner, beacons
+  // without consent are stored without identity.
+  consent(state: Consent) {
+    this.consentState = state;
+    this.setSession("consent", state);
+  }
+
   identify(customId: string) {
     this.id = customId;
     this.setSession("id", customId);
@@ -59,9 +101,13 @@ class Tracker {
         category: category,
         referrer: this.referrer,
         isTouchDevice: this.isTouch,
+        consent: this.consentState,
+        ...this.campaign,
       },
       site_id: this.siteId,
+      v: 1,
     };
+    this.campaign = noCampaign;
     this.trackRequest(payload);
   }
 
@@ -69,10 +115,9 @@ class Tracker {
     this.track(path, "Page views");
   }
   private trackRequest(payload: TrackPayload) {
-    const blob = new Blob([JSON.stringify(payload)], {
-      type: "application/json",
-    });
-    navigator.sendBeacon("http://localhost:9876/track", blob);
+    // A string is sent as text/plain, which needs no CORS preflight, so the
+    // beacon still goes out while the page unloads
+    navigator.sendBeacon("http://localhost:9876/track", JSON.stringify(payload));
   }
 }
 ((w, d) => {
@@ -90,7 +135,19 @@ class Tracker {
     externalReferrer = ref;
   }
 
-  let tracker = new Tracker(ds.siteid, externalReferrer);
+  const utm = new URLSearchParams(w.location.search);
+  let tracker = new Tracker(
+    ds.siteid,
+    externalReferrer,
+    ds.consent as Consent | undefined,
+    {
+      campaign: utm.get("utm_campaign") || "",
+      utmSource: utm.get("utm_source") || "",
+      utmMedium: utm.get("utm_medium") || "",
+      utmTerm: utm.get("utm_term") || "",
+      utmContent: utm.get("utm_content") || "",
+    }
+  );
 
   w._got = w._got || tracker;
 
@@ -0,0 +1,313 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+)
+
+// DesiredState is the configuration infrastructure-as-code tools declare.
+// Applying it creates and replaces objects by ID and, when pruning, deletes
+// the ones it doesn't list. Applying the same state again changes nothing.
+// An exported ConfigDocument is a valid state.
+type DesiredState struct {
+	Sites     []Site     `json:"sites"`
+	Segments  []Segment  `json:"segments"`
+	Reports   []Report   `json:"reports"`
+	Campaigns []Campaign `json:"campaigns"`
+	// Keys are left alone when nil, an empty list manages them too
+	Keys []APIKey `json:"keys"`
+}
+
+// StateChanges lists the IDs of the objects of a kind a state creates,
+// updates, leaves unchanged and deletes.
+type StateChanges struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Deleted   []string `json:"deleted"`
+}
+
+// Changed reports whether applying changes anything.
+func (c StateChanges) Changed() bool {
+	return len(c.Created)+len(c.Updated)+len(c.Deleted) > 0
+}
+
+// StatePlan is what applying a DesiredState changes, or changed once
+// Applied.
+type StatePlan struct {
+	Sites     StateChanges  `json:"sites"`
+	Segments  StateChanges  `json:"segments"`
+	Reports   StateChanges  `json:"reports"`
+	Campaigns StateChanges  `json:"campaigns"`
+	Keys      *StateChanges `json:"keys,omitempty"`
+	Applied   bool          `json:"applied"`
+}
+
+// ApplyOptions select whether objects missing from the state are deleted,
+// and whether the plan is only computed.
+type ApplyOptions struct {
+	Prune  bool
+	DryRun bool
+}
+
+// ApplyState makes the configuration match state, see DesiredState. Server
+// managed fields, such as creation times and the verification of sites,
+// are kept. The state is validated as a whole first, sites, saved objects
+// and keys are then saved in turn.
+func ApplyState(state DesiredState, sites *Sites, saved *Saved, keys *Keys, opts ApplyOptions) (StatePlan, error) {
+	var plan StatePlan
+	if err := state.validate(saved, opts.Prune); err != nil {
+		return plan, err
+	}
+
+	var err error
+	if plan.Sites, err = sites.apply(state.Sites, opts); err != nil {
+		return plan, err
+	}
+	if plan.Segments, plan.Reports, plan.Campaigns, err = saved.apply(state, opts); err != nil {
+		return plan, err
+	}
+	if state.Keys != nil {
+		changes, err := keys.apply(state.Keys, opts)
+		if err != nil {
+			return plan, err
+		}
+		plan.Keys = &changes
+	}
+	plan.Applied = !opts.DryRun
+	return plan, nil
+}
+
+func (state DesiredState) validate(saved *Saved, prune bool) error {
+	ids := func(kind string, n int, id func(int) string) error {
+		seen := make(map[string]bool, n)
+		for i := 0; i < n; i++ {
+			if id(i) == "" {
+				return fmt.Errorf("%w: %s need an id", ErrInvalid, kind)
+			}
+			if seen[id(i)] {
+				return fmt.Errorf("%w: %s %q is listed twice", ErrInvalid, kind, id(i))
+			}
+			seen[id(i)] = true
+		}
+		return nil
+	}
+	for _, err := range []error{
+		ids("sites", len(state.Sites), func(i int) string { return state.Sites[i].ID }),
+		ids("segments", len(state.Segments), func(i int) string { return state.Segments[i].ID }),
+		ids("reports", len(state.Reports), func(i int) string { return state.Reports[i].ID }),
+		ids("campaigns", len(state.Campaigns), func(i int) string { return state.Campaigns[i].ID }),
+		ids("keys", len(state.Keys), func(i int) string { return state.Keys[i].ID }),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, site := range state.Sites {
+		if site.Domain == "" {
+			continue
+		}
+		if err := ValidDomain(site.Domain); err != nil {
+			return fmt.Errorf("site %s: %w: %w", site.ID, ErrInvalid, err)
+		}
+	}
+	for _, site := range state.Sites {
+		if err := validateOmitFields(site); err != nil {
+			return err
+		}
+	}
+	for _, key := range state.Keys {
+		if len(key.KeyHash) != 64 && (key.KeyHash != "" || len(key.SigningSecrets) == 0) {
+			return fmt.Errorf("%w: key %s needs the SHA-256 keyHash of the key or signing secrets", ErrInvalid, key.ID)
+		}
+	}
+	if _, err := newKeyIndex(state.Keys); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	// Once pruned, only the segments of the state are left for reports
+	doc := ConfigDocument{Segments: state.Segments, Reports: state.Reports, Campaigns: state.Campaigns}
+	if prune {
+		_, _, _, err := (&Saved{}).check(doc)
+		return err
+	}
+	_, _, _, err := saved.check(doc)
+	return err
+}
+
+// diffState compares the desired objects with the existing ones by ID.
+// Objects are the same when they encode to the same JSON, once keep copied
+// the server managed fields of the existing object to the desired one.
+func diffState[T any](existing map[string]T, desired []T, id func(T) string, keep func(want *T, have T), prune bool) (StateChanges, []T) {
+	changes := StateChanges{Created: []string{}, Updated: []string{}, Unchanged: []string{}, Deleted: []string{}}
+	listed := make(map[string]bool, len(desired))
+	var put []T
+	for _, want := range desired {
+		listed[id(want)] = true
+		have, ok := existing[id(want)]
+		if !ok {
+			changes.Created = append(changes.Created, id(want))
+			put = append(put, want)
+			continue
+		}
+		keep(&want, have)
+		if sameJSON(want, have) {
+			changes.Unchanged = append(changes.Unchanged, id(want))
+			continue
+		}
+		changes.Updated = append(changes.Updated, id(want))
+		put = append(put, want)
+	}
+	if prune {
+		for id := range existing {
+			if !listed[id] {
+				changes.Deleted = append(changes.Deleted, id)
+			}
+		}
+		sort.Strings(changes.Deleted)
+	}
+	return changes, put
+}
+
+func sameJSON(a, b any) bool {
+	ab, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ab, bb)
+}
+
+func (s *Sites) apply(desired []Site, opts ApplyOptions) (StateChanges, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sites == nil {
+		s.sites = make(map[string]Site)
+	}
+	changes, put := diffState(s.sites, desired, func(site Site) string { return site.ID }, func(want *Site, have Site) {
+		want.CreatedAt, want.MergedInto = have.CreatedAt, have.MergedInto
+		want.Unverified, want.VerificationToken, want.VerifiedAt = have.Unverified, have.VerificationToken, have.VerifiedAt
+		want.ReadKeyHash, want.WriteKeyHash = have.ReadKeyHash, have.WriteKeyHash
+	}, opts.Prune)
+	if opts.DryRun || !changes.Changed() {
+		return changes, nil
+	}
+
+	prev := maps.Clone(s.sites)
+	now := clock.Now().UTC()
+	for _, site := range put {
+		if _, ok := s.sites[site.ID]; !ok {
+			site.CreatedAt, site.MergedInto, site.VerifiedAt = now, "", nil
+			site.Unverified, site.VerificationToken = false, ""
+			site.ReadKeyHash, site.WriteKeyHash = "", ""
+			if config.SiteVerification == VerificationRequired {
+				site.Unverified, site.VerificationToken = true, RandomToken()
+			}
+		}
+		s.sites[site.ID] = site
+	}
+	for _, id := range changes.Deleted {
+		delete(s.sites, id)
+	}
+	if err := s.save(); err != nil {
+		s.sites = prev
+		return StateChanges{}, err
+	}
+	return changes, nil
+}
+
+func (s *Saved) apply(state DesiredState, opts ApplyOptions) (segments, reports, campaigns StateChanges, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	segments, putSegments := diffState(s.segments, state.Segments, func(seg Segment) string { return seg.ID }, func(want *Segment, have Segment) {
+		want.CreatedAt, want.UpdatedAt = have.CreatedAt, have.UpdatedAt
+	}, opts.Prune)
+	reports, putReports := diffState(s.reports, state.Reports, func(rep Report) string { return rep.ID }, func(want *Report, have Report) {
+		want.CreatedAt, want.UpdatedAt = have.CreatedAt, have.UpdatedAt
+	}, opts.Prune)
+	campaigns, putCampaigns := diffState(s.campaigns, state.Campaigns, func(c Campaign) string { return c.ID }, func(*Campaign, Campaign) {}, opts.Prune)
+	if opts.DryRun || !(segments.Changed() || reports.Changed() || campaigns.Changed()) {
+		return segments, reports, campaigns, nil
+	}
+
+	prevSegments, prevReports, prevCampaigns := maps.Clone(s.segments), maps.Clone(s.reports), maps.Clone(s.campaigns)
+	now := clock.Now().UTC()
+	for _, seg := range putSegments {
+		seg.CreatedAt, seg.UpdatedAt = createdAt(s.segments[seg.ID].CreatedAt, now), now
+		s.segments[seg.ID] = seg
+	}
+	for _, rep := range putReports {
+		rep.CreatedAt, rep.UpdatedAt = createdAt(s.reports[rep.ID].CreatedAt, now), now
+		s.reports[rep.ID] = rep
+	}
+	for _, c := range putCampaigns {
+		s.campaigns[c.ID] = c
+	}
+	for _, id := range segments.Deleted {
+		delete(s.segments, id)
+	}
+	for _, id := range reports.Deleted {
+		delete(s.reports, id)
+	}
+	for _, id := range campaigns.Deleted {
+		delete(s.campaigns, id)
+	}
+	if err := s.save(); err != nil {
+		s.segments, s.reports, s.campaigns = prevSegments, prevReports, prevCampaigns
+		return StateChanges{}, StateChanges{}, StateChanges{}, err
+	}
+	return segments, reports, campaigns, nil
+}
+
+func (k *Keys) apply(desired []APIKey, opts ApplyOptions) (StateChanges, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	byID := make(map[string]APIKey, len(k.byID))
+	for id, key := range k.byID {
+		byID[id] = key
+	}
+	changes, put := diffState(byID, desired, func(key APIKey) string { return key.ID }, func(want *APIKey, have APIKey) {
+		want.CreatedAt = have.CreatedAt
+	}, opts.Prune)
+	if opts.DryRun || !changes.Changed() {
+		return changes, nil
+	}
+
+	now := clock.Now().UTC()
+	for _, key := range put {
+		key.CreatedAt = createdAt(byID[key.ID].CreatedAt, now)
+		byID[key.ID] = key
+	}
+	for _, id := range changes.Deleted {
+		delete(byID, id)
+	}
+	list := make([]APIKey, 0, len(byID))
+	for _, key := range byID {
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	index, err := newKeyIndex(list)
+	if err != nil {
+		return StateChanges{}, err
+	}
+	if k.path != "" {
+		if err := writeJSONFile(k.path, list); err != nil {
+			return StateChanges{}, err
+		}
+	}
+	k.keyIndex = index
+	return changes, nil
+}
+
+// createdAt returns have, or now for objects created now.
+func createdAt(have, now time.Time) time.Time {
+	if have.IsZero() {
+		return now
+	}
+	return have
+}
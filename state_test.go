@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyState(t *testing.T) {
+	dir := t.TempDir()
+	sites, saved, keys := &Sites{}, &Saved{}, &Keys{}
+	sites.Load(filepath.Join(dir, "sites.json"))
+	saved.Load(filepath.Join(dir, "saved.json"))
+	keys.Load(filepath.Join(dir, "keys.json"))
+	sites.Ensure(Site{ID: "old", Domain: "old.example"})
+
+	state := DesiredState{
+		Sites:    []Site{{ID: "shop", Domain: "shop.example", AllowedKinds: []string{"page"}}},
+		Segments: []Segment{{ID: "mobile", SiteID: "shop", Name: "Mobile", Filters: map[string]string{"touch": "touch"}}},
+		Reports:  []Report{{ID: "pages", SiteID: "shop", Name: "Mobile pages", Metric: QueryPageViewList, SegmentID: "mobile", RangeDays: 7}},
+		Keys:     []APIKey{{ID: "ci", Name: "CI", KeyHash: HashKey("secret"), Grants: []Grant{{Site: "shop", Role: RoleAdmin}}}},
+	}
+
+	plan, err := ApplyState(state, sites, saved, keys, ApplyOptions{Prune: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Applied || !reflect.DeepEqual(plan.Sites.Deleted, []string{"old"}) || len(plan.Keys.Created) != 1 {
+		t.Errorf("dry run: got %+v", plan)
+	}
+	if _, ok := sites.Get("shop"); ok {
+		t.Error("dry run created a site")
+	}
+
+	if plan, err = ApplyState(state, sites, saved, keys, ApplyOptions{Prune: true}); err != nil || !plan.Applied {
+		t.Fatalf("apply: got %+v, %v", plan, err)
+	}
+	if _, ok := keys.Lookup("secret"); !ok {
+		t.Error("key not applied")
+	}
+
+	// Saved to disk, and applying again changes nothing
+	sites.Load(filepath.Join(dir, "sites.json"))
+	saved.Load(filepath.Join(dir, "saved.json"))
+	keys.Load(filepath.Join(dir, "keys.json"))
+	if _, ok := sites.Get("old"); ok {
+		t.Error("old site not pruned")
+	}
+	plan, err = ApplyState(state, sites, saved, keys, ApplyOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for kind, changes := range map[string]StateChanges{"sites": plan.Sites, "segments": plan.Segments, "reports": plan.Reports, "keys": *plan.Keys} {
+		if changes.Changed() || len(changes.Unchanged) != 1 {
+			t.Errorf("reapply %s: got %+v", kind, changes)
+		}
+	}
+
+	// Without keys, keys are left alone
+	state.Keys = nil
+	state.Sites[0].Domain = "www.shop.example"
+	if plan, err = ApplyState(state, sites, saved, keys, ApplyOptions{Prune: true}); err != nil || plan.Keys != nil || len(plan.Sites.Updated) != 1 {
+		t.Errorf("update: got %+v, %v", plan, err)
+	}
+	if keys.Len() != 1 {
+		t.Errorf("got %d keys", keys.Len())
+	}
+}
+
+func TestApplyStateInvalid(t *testing.T) {
+	sites, saved, keys := &Sites{}, &Saved{}, &Keys{}
+	sites.Load("")
+	saved.Load("")
+	kept, err := saved.PutSegment(Segment{SiteID: "s", Name: "Kept", Filters: map[string]string{"touch": "touch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Report{ID: "r", SiteID: "s", Name: "r", Metric: QueryPageViews, RangeDays: 1, SegmentID: kept.ID}
+
+	for name, tc := range map[string]struct {
+		state DesiredState
+		prune bool
+	}{
+		"site twice":         {state: DesiredState{Sites: []Site{{ID: "s"}, {ID: "s"}}}},
+		"bad domain":         {state: DesiredState{Sites: []Site{{ID: "s", Domain: "http://s.example/"}}}},
+		"key without hash":   {state: DesiredState{Keys: []APIKey{{ID: "k"}}}},
+		"pruned segment":     {state: DesiredState{Reports: []Report{report}}, prune: true},
+		"report without id":  {state: DesiredState{Reports: []Report{{SiteID: "s"}}}},
+		"invalid campaign":   {state: DesiredState{Campaigns: []Campaign{{ID: "c", SiteID: "s"}}}},
+		"segment without id": {state: DesiredState{Segments: []Segment{{SiteID: "s"}}}},
+	} {
+		if _, err := ApplyState(tc.state, sites, saved, keys, ApplyOptions{Prune: tc.prune}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if _, err := ApplyState(DesiredState{Reports: []Report{report}}, sites, saved, keys, ApplyOptions{}); err != nil {
+		t.Errorf("report on existing segment: %v", err)
+	}
+	if len(sites.List()) != 0 {
+		t.Error("invalid state partly applied")
+	}
+}
+
+func TestApplyStateSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	sites, saved, keys := &Sites{}, &Saved{}, &Keys{}
+	sites.Load("")
+	saved.Load("")
+	keys.Load(filepath.Join(dir, "keys.json"))
+
+	// Keys that can only sign have no hash
+	state := DesiredState{Keys: []APIKey{{ID: "svc", SigningSecrets: []SigningSecret{{ID: "k1", Secret: "s"}}}}}
+	if _, err := ApplyState(state, sites, saved, keys, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := ApplyState(state, sites, saved, keys, ApplyOptions{})
+	if err != nil || len(plan.Keys.Unchanged) != 1 {
+		t.Errorf("reapply: got %+v, %v", plan.Keys, err)
+	}
+	if _, ok := keys.bySecret["k1"]; !ok || keys.Len() != 1 {
+		t.Errorf("signing secret not applied: %+v", keys.keyIndex)
+	}
+
+	state.Keys = append(state.Keys, APIKey{ID: "other", SigningSecrets: []SigningSecret{{ID: "k1", Secret: "t"}}})
+	if _, err := ApplyState(state, sites, saved, keys, ApplyOptions{}); err == nil {
+		t.Error("duplicate signing secret id applied")
+	}
+}
@@ -0,0 +1,130 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// EventStore is the storage backend used by the tracker server. Events is the
+// ClickHouse implementation, MemoryEvents keeps everything in process.
+type EventStore interface {
+	Add(ctx context.Context, trk Tracking, ua useragent.UserAgent, geo *GeoInfo) error
+	Run(ctx context.Context)
+	WaitFlush()
+	GetStats(ctx context.Context, data MetricData) (*StatsResult, error)
+	TimeSeries(ctx context.Context, q TimeSeriesQuery) (*TimeSeriesResult, error)
+	Usage(ctx context.Context, q UsageQuery) ([]UsageRow, error)
+	Values(ctx context.Context, q ValuesQuery) ([]string, error)
+	Summary(ctx context.Context, siteID string) (*SiteSummary, error)
+	DarkTraffic(ctx context.Context, q DarkTrafficQuery) (*DarkTrafficResult, error)
+}
+
+// Sites returns the IDs of the sites data selects.
+func (data MetricData) Sites() []string {
+	if len(data.SiteIDs) > 0 {
+		return data.SiteIDs
+	}
+	return []string{data.SiteID}
+}
+
+// filterColumns returns the events columns and values filters match, ordered
+// by field. Unknown fields are invalid.
+func filterColumns(filters map[string]string) (cols, values []string, err error) {
+	fields := make([]string, 0, len(filters))
+	for field := range filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		col, err := ValueColumn(field)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		cols = append(cols, col)
+		values = append(values, filters[field])
+	}
+	return cols, values, nil
+}
+
+// siteMetric is a metric of a single site, stores compute them per site and
+// newStatsResult combines them.
+type siteMetric struct {
+	Metric
+	site string
+	// sessions weigh the averages of session metrics combining sites
+	sessions uint64
+}
+
+// newStatsResult wraps metrics computed for data with their metadata,
+// combining sites and splitting them per site when several were queried.
+func newStatsResult(data MetricData, rows []siteMetric, source StatsSource, took time.Duration) *StatsResult {
+	def := metricDefs[data.What]
+	result := &StatsResult{Data: []Metric{}}
+
+	if len(data.Sites()) > 1 {
+		result.BySite = make(map[string][]Metric)
+		combined := make(map[Metric]uint64)
+		weights := make(map[Metric]uint64)
+		for _, row := range rows {
+			result.BySite[row.site] = append(result.BySite[row.site], row.Metric)
+			m := Metric{OccuredAt: row.OccuredAt, Value: row.Value}
+			if def.session.averaged() {
+				combined[m] += row.Count * row.sessions
+				weights[m] += row.sessions
+			} else {
+				combined[m] += row.Count
+			}
+		}
+		for m, count := range combined {
+			if weight := weights[m]; weight > 0 {
+				count = (count + weight/2) / weight
+			}
+			m.Count = count
+			result.Data = append(result.Data, m)
+		}
+		sortMetrics(result.Data)
+	} else {
+		for _, row := range rows {
+			result.Data = append(result.Data, row.Metric)
+		}
+	}
+
+	result.Meta = StatsMeta{
+		Rows:        len(result.Data),
+		DurationMs:  float64(took.Microseconds()) / 1000,
+		Granularity: "total",
+		SampleRate:  1,
+		Source:      source,
+	}
+	if def.daily {
+		result.Meta.Granularity = "day"
+	}
+	if len(data.Filters) > 0 {
+		result.Meta.Filters = maps.Clone(data.Filters)
+	}
+	if def.filter != "" {
+		if result.Meta.Filters == nil {
+			result.Meta.Filters = make(map[string]string)
+		}
+		result.Meta.Filters[def.filter] = data.Extra
+	}
+	return result
+}
+
+// sortMetrics orders metrics by count, most frequent first.
+func sortMetrics(metrics []Metric) {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Count != metrics[j].Count {
+			return metrics[i].Count > metrics[j].Count
+		}
+		if metrics[i].OccuredAt != metrics[j].OccuredAt {
+			return metrics[i].OccuredAt < metrics[j].OccuredAt
+		}
+		return metrics[i].Value < metrics[j].Value
+	})
+}
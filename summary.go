@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SiteSummary holds the lifetime totals of a site. They are kept up to date
+// as events are inserted, in the site_totals table, so reading them doesn't
+// scan the site's history. Like the rollups they count QualityOK page views
+// only.
+type SiteSummary struct {
+	SiteID    string `json:"siteId"`
+	Pageviews uint64 `json:"pageviews"`
+	// Visitors is approximate, distinct identities are estimated.
+	Visitors uint64 `json:"visitors"`
+	// FirstDay is the YYYYMMDD day of the site's first event, 0 when it
+	// has none.
+	FirstDay uint32 `json:"firstDay"`
+}
+
+func (e *Events) Summary(ctx context.Context, siteID string) (*SiteSummary, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	summary := &SiteSummary{SiteID: siteID}
+	qry := `
+		SELECT sum(pageviews), uniqMerge(visitors), min(first_day)
+		FROM site_totals
+		WHERE site_id = $1;
+	`
+	if err := e.DB.QueryRow(queryCtx, qry, siteID).Scan(&summary.Pageviews, &summary.Visitors, &summary.FirstDay); err != nil {
+		return nil, fmt.Errorf("failed reading site totals: %w", err)
+	}
+	return summary, nil
+}
+
+func (m *MemoryEvents) Summary(ctx context.Context, siteID string) (*SiteSummary, error) {
+	summary := &SiteSummary{SiteID: siteID}
+	visitors := make(map[string]bool)
+
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != siteID {
+			continue
+		}
+		if day := row.trk.Action.OccuredAt; summary.FirstDay == 0 || day < summary.FirstDay {
+			summary.FirstDay = day
+		}
+		if row.trk.Action.Category == PageviewCategory && counted(nil, row.trk.Action) {
+			summary.Pageviews++
+			visitors[row.trk.Action.Identity] = true
+		}
+	}
+	m.lock.RUnlock()
+
+	summary.Visitors = uint64(len(visitors))
+	return summary, nil
+}
@@ -0,0 +1,38 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestMemorySummary(t *testing.T) {
+	m := NewMemoryEvents()
+	ctx := context.Background()
+	add := func(site, user, category string, day uint32, quality string) {
+		trk := Tracking{SiteID: site, Action: TrackingData{Identity: user, Category: category, OccuredAt: day, Quality: quality}}
+		if err := m.Add(ctx, trk, useragent.UserAgent{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("s", "u1", "Page views", 20240302, "")
+	add("s", "u1", "Page views", 20240303, "")
+	add("s", "u2", "Page views", 20240303, "")
+	add("s", "u3", "Clicks", 20240301, "")
+	add("s", "bot", "Page views", 20240303, QualityBot)
+	add("other", "u4", "Page views", 20240101, "")
+
+	got, err := m.Summary(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SiteSummary{SiteID: "s", Pageviews: 3, Visitors: 2, FirstDay: 20240301}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+
+	if got, _ := m.Summary(ctx, "none"); *got != (SiteSummary{SiteID: "none"}) {
+		t.Errorf("site without events: got %+v", *got)
+	}
+}
@@ -0,0 +1,139 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// RetentionMode selects how raw events older than RAW_RETENTION_DAYS are
+// deleted.
+type RetentionMode string
+
+const (
+	// RetentionMutation deletes them with an hourly ALTER TABLE DELETE
+	// mutation, which rewrites every part holding expired rows.
+	RetentionMutation RetentionMode = "mutation"
+	// RetentionTTL leaves it to a table TTL, expired rows are dropped as
+	// parts are merged. Cheaper on large tables, but rows linger until the
+	// next TTL merge (merge_with_ttl_timeout).
+	RetentionTTL RetentionMode = "ttl"
+)
+
+// creationSettings can only be set when a MergeTree table is created, they
+// are ignored on existing tables.
+var creationSettings = map[string]bool{
+	"index_granularity":       true,
+	"index_granularity_bytes": true,
+}
+
+var (
+	settingNameRe  = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	settingValueRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	codecRe        = regexp.MustCompile(`^[A-Za-z0-9_]+(\([0-9, ]*\))?$`)
+)
+
+// TableSetting is one MergeTree setting of the events table.
+type TableSetting struct {
+	Name  string
+	Value string
+}
+
+// EventsTableSettings parses EVENTS_TABLE_SETTINGS, a list of name=value
+// MergeTree settings such as index_granularity=4096 or
+// merge_with_ttl_timeout=3600.
+func EventsTableSettings() ([]TableSetting, error) {
+	var settings []TableSetting
+	for _, item := range config.EventsSettings {
+		name, value, ok := strings.Cut(item, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !settingNameRe.MatchString(name) || !settingValueRe.MatchString(value) {
+			return nil, fmt.Errorf("invalid events table setting %q, want name=value", item)
+		}
+		settings = append(settings, TableSetting{Name: name, Value: value})
+	}
+	return settings, nil
+}
+
+// eventsTTL returns the TTL clause the retention settings give the events
+// table, empty when it needs none. Rows expire by the day they occured on.
+func eventsTTL() (string, error) {
+	const day = "makeDate(intDiv(occured_at, 10000), intDiv(occured_at, 100) % 100, occured_at % 100)"
+
+	var rules []string
+	if config.RecompressDays > 0 {
+		if !codecRe.MatchString(config.RecompressCodec) {
+			return "", fmt.Errorf("invalid recompression codec %q", config.RecompressCodec)
+		}
+		rules = append(rules, fmt.Sprintf("%s + INTERVAL %d DAY RECOMPRESS CODEC(%s)", day, config.RecompressDays, config.RecompressCodec))
+	}
+	if config.RawRetentionDays > 0 && config.RawRetentionMode == RetentionTTL {
+		rules = append(rules, fmt.Sprintf("%s + INTERVAL %d DAY DELETE", day, config.RawRetentionDays))
+	}
+	return strings.Join(rules, ", "), nil
+}
+
+// withCreationSettings adds the creation-only settings of
+// EVENTS_TABLE_SETTINGS to the migration creating the events table.
+func withCreationSettings(version uint32, qry string, settings []TableSetting) string {
+	if version != 1 {
+		return qry
+	}
+	var clauses []string
+	for _, s := range settings {
+		if creationSettings[s.Name] {
+			clauses = append(clauses, s.Name+" = "+s.Value)
+		}
+	}
+	if len(clauses) == 0 {
+		return qry
+	}
+	return strings.TrimSuffix(strings.TrimSpace(qry), ";") + "\n\t\tSETTINGS " + strings.Join(clauses, ", ") + ";"
+}
+
+// applyTableSettings brings the events table's settings and TTL in line with
+// the configuration. Settings are applied on every start, they are cheap to
+// set, the TTL only when it changed since it rewrites existing parts.
+// existed is whether the events table was there before this start.
+func (e *Events) applyTableSettings(ctx context.Context, settings []TableSetting, existed bool) error {
+	var clauses []string
+	for _, s := range settings {
+		if !creationSettings[s.Name] {
+			clauses = append(clauses, s.Name+" = "+s.Value)
+		} else if existed {
+			e.log.Warn("Setting only applies when the events table is created, ignoring it", slog.String("setting", s.Name))
+		}
+	}
+	if len(clauses) > 0 {
+		if err := e.DB.Exec(ctx, "ALTER TABLE events MODIFY SETTING "+strings.Join(clauses, ", ")); err != nil {
+			return fmt.Errorf("failed applying events table settings: %w", err)
+		}
+	}
+
+	ttl, err := eventsTTL()
+	if err != nil {
+		return err
+	}
+	var applied string
+	if err := e.DB.QueryRow(ctx, "SELECT argMax(value, applied_at) FROM table_settings WHERE name = 'events.ttl'").Scan(&applied); err != nil {
+		return fmt.Errorf("failed reading applied events TTL: %w", err)
+	}
+	if ttl == applied {
+		return nil
+	}
+
+	qry := "ALTER TABLE events REMOVE TTL"
+	if ttl != "" {
+		qry = "ALTER TABLE events MODIFY TTL " + ttl
+	}
+	if err := e.DB.Exec(ctx, qry); err != nil {
+		return fmt.Errorf("failed changing events TTL: %w", err)
+	}
+	if err := e.DB.Exec(ctx, "INSERT INTO table_settings (name, value) VALUES ('events.ttl', ?)", ttl); err != nil {
+		return fmt.Errorf("failed recording events TTL: %w", err)
+	}
+	e.log.Info("Changed events TTL", slog.String("ttl", ttl))
+	return nil
+}
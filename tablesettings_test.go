@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventsTableSettings(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	t.Setenv("EVENTS_TABLE_SETTINGS", "index_granularity=4096, merge_with_ttl_timeout = 3600")
+	LoadConfig()
+
+	settings, err := EventsTableSettings()
+	if err != nil || len(settings) != 2 || settings[1] != (TableSetting{"merge_with_ttl_timeout", "3600"}) {
+		t.Fatalf("got %v, %v", settings, err)
+	}
+
+	create := withCreationSettings(1, migrations[0], settings)
+	if !strings.HasSuffix(create, "SETTINGS index_granularity = 4096;") || strings.Contains(create, "merge_with_ttl_timeout") {
+		t.Errorf("events table creation: got %s", create)
+	}
+	if got := withCreationSettings(2, migrations[1], settings); got != migrations[1] {
+		t.Errorf("other migration changed: got %s", got)
+	}
+
+	for _, bad := range []string{"index_granularity", "x=1; DROP TABLE events", "Name=1", "a=1 2"} {
+		config.EventsSettings = []string{bad}
+		if _, err := EventsTableSettings(); err == nil {
+			t.Errorf("%q: accepted", bad)
+		}
+	}
+}
+
+func TestEventsTTL(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+
+	if ttl, err := eventsTTL(); ttl != "" || err != nil {
+		t.Errorf("defaults: got %q, %v", ttl, err)
+	}
+
+	// Deletes are left to the purge mutation by default
+	config.RawRetentionDays = 90
+	if ttl, _ := eventsTTL(); ttl != "" {
+		t.Errorf("mutation retention: got %q", ttl)
+	}
+
+	config.RawRetentionMode = RetentionTTL
+	config.RecompressDays = 30
+	ttl, err := eventsTTL()
+	if err != nil || !strings.Contains(ttl, "INTERVAL 30 DAY RECOMPRESS CODEC(ZSTD(9)), ") || !strings.HasSuffix(ttl, "INTERVAL 90 DAY DELETE") {
+		t.Errorf("ttl retention: got %q, %v", ttl, err)
+	}
+
+	config.RecompressCodec = "ZSTD(3)) DELETE"
+	if _, err := eventsTTL(); err == nil {
+		t.Error("invalid codec accepted")
+	}
+}
@@ -0,0 +1,13 @@
+
+		SELECT toUInt32(0), campaign, COUNT(*), site_id
+		FROM events
+		WHERE has($1, site_id)
+		AND occured_at BETWEEN $2 AND $3
+		AND category = 'Page views'
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		AND has($7, toString(quality))
+		AND $4 = $4 
+		GROUP BY site_id, campaign
+		ORDER BY 3 DESC;
+	
\ No newline at end of file
@@ -0,0 +1,12 @@
+
+		SELECT occured_at, event, COUNT(*), site_id
+		FROM events
+		WHERE has($1, site_id)
+		AND category = 'Page views'
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		AND has($7, toString(quality))
+		GROUP BY site_id, occured_at, event
+		HAVING occured_at BETWEEN $2 AND $3
+		ORDER BY 3 DESC;
+	
\ No newline at end of file
@@ -0,0 +1,13 @@
+
+		SELECT toUInt32(0), value, sum(events), site_id
+		FROM events_daily
+		WHERE has($1, site_id)
+		AND dimension = 'referrer_domain'
+		AND day BETWEEN $2 AND $3
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		AND $7 = $7
+		AND $4 = $4
+		GROUP BY site_id, value
+		ORDER BY 3 DESC;
+	
\ No newline at end of file
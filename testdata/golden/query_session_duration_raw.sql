@@ -0,0 +1,18 @@
+
+		SELECT occured_at, '', toUInt64(round(avg(duration))), site_id, count()
+		FROM (
+			SELECT site_id, occured_at, session_id, count() AS views,
+				dateDiff('second', min(happened_at), max(happened_at)) AS duration
+			FROM events
+			WHERE has($1, site_id)
+			AND occured_at BETWEEN $2 AND $3
+			AND category = 'Page views'
+			AND session_id != ''
+			AND ($4 = '' OR source = $4)
+			AND ($5 = '' OR touch = $5)
+			AND has($6, toString(quality))
+			GROUP BY site_id, occured_at, session_id
+		)
+		GROUP BY site_id, occured_at
+		ORDER BY occured_at;
+	
\ No newline at end of file
@@ -0,0 +1,195 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Sessions are counted like the session metrics of GetStats: the sessions
+// events are stored with, see Visits, per day. A bounce is a session with a
+// single page view. The rollups keep no sessions, ranges answered from them
+// have no sessions nor bounces.
+
+// TimeSeriesQuery selects the daily page view metrics of a site between two
+// YYYYMMDD days.
+type TimeSeriesQuery struct {
+	SiteID string `json:"siteId"`
+	Start  uint32 `json:"start"`
+	End    uint32 `json:"end"`
+	Source string `json:"source,omitempty"`
+	Touch  string `json:"touch,omitempty"`
+	// Qualities are those of the events counted, see MetricData.
+	Qualities []string `json:"qualities,omitempty"`
+}
+
+type TimeSeriesPoint struct {
+	Day        uint32  `json:"day"`
+	Visitors   uint64  `json:"visitors"`
+	Pageviews  uint64  `json:"pageviews"`
+	Sessions   uint64  `json:"sessions"`
+	BounceRate float64 `json:"bounceRate"`
+}
+
+type TimeSeriesResult struct {
+	Meta StatsMeta         `json:"meta"`
+	Data []TimeSeriesPoint `json:"data"`
+}
+
+// timeSeriesRow is what both stores compute per day, points are derived
+// from it.
+type timeSeriesRow struct {
+	day      uint32
+	views    uint64
+	visitors uint64
+	sessions uint64
+	bounces  uint64
+}
+
+func (e *Events) TimeSeries(ctx context.Context, q TimeSeriesQuery) (*TimeSeriesResult, error) {
+	started := time.Now()
+
+	// Views per visitor, session and day first, the rollups keep the same
+	// counts per visitor under the user_id dimension
+	source := SourceRaw
+	inner := `
+			SELECT occured_at AS day, user_id, session_id, count() AS views
+			FROM events
+			WHERE site_id = $1
+			AND occured_at BETWEEN $2 AND $3
+			AND category = 'Page views'
+			AND has($6, toString(quality))
+			AND ($4 = '' OR source = $4)
+			AND ($5 = '' OR touch = $5)
+			GROUP BY occured_at, user_id, session_id`
+	if cutoff := rawCutoff(clock.Now()); cutoff != 0 && q.Start < cutoff {
+		if err := rollupQualities(q.Qualities); err != nil {
+			return nil, err
+		}
+		source = SourceRollups
+		inner = `
+			SELECT day, value AS user_id, '' AS session_id, sum(events) AS views
+			FROM events_daily
+			WHERE site_id = $1
+			AND dimension = 'user_id'
+			AND day BETWEEN $2 AND $3
+			AND ($4 = '' OR source = $4)
+			AND ($5 = '' OR touch = $5)
+			AND $6 = $6
+			GROUP BY day, value`
+	}
+	qry := fmt.Sprintf(`
+		SELECT day, sum(views), uniqExact(user_id), countIf(session_id != ''), countIf(session_id != '' AND views = 1)
+		FROM (%s
+		)
+		GROUP BY day
+		ORDER BY day;
+	`, inner)
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rows, err := e.DB.Query(queryCtx, qry, q.SiteID, q.Start, q.End, q.Source, q.Touch, countedQualities(q.Qualities))
+	if err != nil {
+		return nil, fmt.Errorf("time series query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var days []timeSeriesRow
+	for rows.Next() {
+		var r timeSeriesRow
+		if err := rows.Scan(&r.day, &r.views, &r.visitors, &r.sessions, &r.bounces); err != nil {
+			return nil, fmt.Errorf("failed scanning time series row: %w", err)
+		}
+		days = append(days, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating time series rows: %w", err)
+	}
+	return newTimeSeriesResult(q, days, source, time.Since(started)), nil
+}
+
+func (m *MemoryEvents) TimeSeries(ctx context.Context, q TimeSeriesQuery) (*TimeSeriesResult, error) {
+	started := time.Now()
+
+	type key struct {
+		day     uint32
+		user    string
+		session string
+	}
+	views := make(map[key]uint64)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Category != PageviewCategory || !counted(q.Qualities, row.trk.Action) {
+			continue
+		}
+		if row.trk.Action.OccuredAt < q.Start || row.trk.Action.OccuredAt > q.End {
+			continue
+		}
+		if q.Source != "" && row.source() != q.Source {
+			continue
+		}
+		if q.Touch != "" && row.touch() != q.Touch {
+			continue
+		}
+		views[key{row.trk.Action.OccuredAt, row.trk.Action.Identity, row.trk.Action.SessionID}]++
+	}
+	m.lock.RUnlock()
+
+	perDay := make(map[uint32]*timeSeriesRow)
+	visitors := make(map[key]bool)
+	var days []timeSeriesRow
+	for k, n := range views {
+		r, ok := perDay[k.day]
+		if !ok {
+			r = &timeSeriesRow{day: k.day}
+			perDay[k.day] = r
+		}
+		r.views += n
+		if visitor := (key{day: k.day, user: k.user}); !visitors[visitor] {
+			visitors[visitor] = true
+			r.visitors++
+		}
+		if k.session == "" {
+			continue
+		}
+		r.sessions++
+		if n == 1 {
+			r.bounces++
+		}
+	}
+	for _, r := range perDay {
+		days = append(days, *r)
+	}
+	return newTimeSeriesResult(q, days, SourceMemory, time.Since(started)), nil
+}
+
+// newTimeSeriesResult turns per day rows into one point for every day of the
+// range, days without page views are zero.
+func newTimeSeriesResult(q TimeSeriesQuery, rows []timeSeriesRow, source StatsSource, took time.Duration) *TimeSeriesResult {
+	byDay := make(map[uint32]timeSeriesRow, len(rows))
+	for _, r := range rows {
+		byDay[r.day] = r
+	}
+
+	points := []TimeSeriesPoint{}
+	for _, day := range DayRange(q.Start, q.End) {
+		r := byDay[day]
+		p := TimeSeriesPoint{Day: day, Visitors: r.visitors, Pageviews: r.views, Sessions: r.sessions}
+		if r.sessions > 0 {
+			p.BounceRate = float64(r.bounces) / float64(r.sessions)
+		}
+		points = append(points, p)
+	}
+
+	return &TimeSeriesResult{
+		Meta: StatsMeta{
+			Rows:        len(points),
+			DurationMs:  float64(took.Microseconds()) / 1000,
+			Granularity: "day",
+			SampleRate:  1,
+			Source:      source,
+		},
+		Data: points,
+	}
+}
@@ -0,0 +1,140 @@
+package tracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Automation platforms poll triggers for new items and tell items apart by
+// their ID, items they saw before are skipped. Triggers list items newest
+// first, from a cursor on: the cursor given back with the previous list.
+
+// TriggerEvent is a custom event as the events trigger lists it, without
+// the visitor's identity.
+type TriggerEvent struct {
+	ID             string    `json:"id"`
+	SiteID         string    `json:"siteId"`
+	Day            uint32    `json:"day"`
+	ReceivedAt     time.Time `json:"receivedAt"`
+	Event          string    `json:"event"`
+	Category       string    `json:"category"`
+	ReferrerDomain string    `json:"referrerDomain"`
+	Country        string    `json:"country"`
+	Source         string    `json:"source"`
+	Campaign       string    `json:"campaign"`
+}
+
+// triggerID identifies an event by its fields, events are stored without
+// an ID. Identical events received in the same second share it.
+func (ev TriggerEvent) triggerID(identity string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%s\x00%s\x00%s\x00%s",
+		ev.SiteID, ev.ReceivedAt.Unix(), ev.Event, ev.Category, ev.ReferrerDomain, ev.Campaign, identity)))
+	return strconv.FormatInt(ev.ReceivedAt.Unix(), 10) + "-" + hex.EncodeToString(sum[:8])
+}
+
+// EventListQuery selects up to Limit custom events of SiteID, named Event
+// unless empty, received at or after Since.
+type EventListQuery struct {
+	SiteID string
+	Event  string
+	Since  time.Time
+	Limit  int
+}
+
+// EventLister is implemented by stores that can list recent custom events,
+// for polling triggers.
+type EventLister interface {
+	// ListEvents returns the events of q, newest first.
+	ListEvents(ctx context.Context, q EventListQuery) ([]TriggerEvent, error)
+}
+
+// ListEvents reads the raw events, their timestamp is when they were
+// inserted, to the second.
+func (e *Events) ListEvents(ctx context.Context, q EventListQuery) ([]TriggerEvent, error) {
+	qry := `
+		SELECT site_id, occured_at, timestamp, event, category, referrer_domain,
+			country, source, campaign, user_id
+		FROM events
+		WHERE site_id = $1 AND type = 'event' AND ($2 = '' OR event = $2) AND timestamp >= $3
+		ORDER BY timestamp DESC
+		LIMIT $4;
+	`
+	rows, err := e.DB.Query(ctx, qry, q.SiteID, q.Event, q.Since.UTC(), q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("event list query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var list []TriggerEvent
+	for rows.Next() {
+		var ev TriggerEvent
+		var identity string
+		if err := rows.Scan(
+			&ev.SiteID, &ev.Day, &ev.ReceivedAt, &ev.Event, &ev.Category, &ev.ReferrerDomain,
+			&ev.Country, &ev.Source, &ev.Campaign, &identity,
+		); err != nil {
+			return nil, fmt.Errorf("failed scanning event list row: %w", err)
+		}
+		ev.ID = ev.triggerID(identity)
+		list = append(list, ev)
+	}
+	return list, rows.Err()
+}
+
+func (m *MemoryEvents) ListEvents(ctx context.Context, q EventListQuery) ([]TriggerEvent, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var list []TriggerEvent
+	// Rows are kept in the order they were added
+	for i := len(m.rows) - 1; i >= 0 && len(list) < q.Limit; i-- {
+		row := m.rows[i]
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Type != "event" || (q.Event != "" && row.trk.Action.Event != q.Event) {
+			continue
+		}
+		received := row.queuedAt.UTC().Truncate(time.Second)
+		if received.Before(q.Since) {
+			continue
+		}
+		ev := TriggerEvent{
+			SiteID:         row.trk.SiteID,
+			Day:            row.occuredAt(),
+			ReceivedAt:     received,
+			Event:          row.trk.Action.Event,
+			Category:       row.trk.Action.Category,
+			ReferrerDomain: row.trk.Action.ReferrerHost,
+			Country:        row.geo.Country,
+			Source:         row.source(),
+			Campaign:       row.trk.Action.Campaign,
+		}
+		ev.ID = ev.triggerID(row.trk.Action.Identity)
+		list = append(list, ev)
+	}
+	return list, nil
+}
+
+// DailySummary is a day of a site as the daily summary trigger lists it,
+// once the day closed everywhere.
+type DailySummary struct {
+	ID string `json:"id"`
+	TimeSeriesPoint
+	SiteID string `json:"siteId"`
+}
+
+// ClosedDays returns the points of series for days that closed by now, as
+// daily summaries of siteID, newest first.
+func ClosedDays(siteID string, series []TimeSeriesPoint, now time.Time) []DailySummary {
+	list := []DailySummary{}
+	for i := len(series) - 1; i >= 0; i-- {
+		p := series[i]
+		if now.Before(ClosedAt(p.Day)) {
+			continue
+		}
+		list = append(list, DailySummary{ID: siteID + "-" + strconv.FormatUint(uint64(p.Day), 10), TimeSeriesPoint: p, SiteID: siteID})
+	}
+	return list
+}
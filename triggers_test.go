@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestMemoryListEvents(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+
+	m := NewMemoryEvents()
+	add := func(site, typ, event string) {
+		trk := Tracking{SiteID: site, Action: TrackingData{Type: typ, Identity: "u1", Event: event, Category: "Goals"}}
+		if err := m.Add(context.Background(), trk, useragent.UserAgent{}, &GeoInfo{Country: "Canada"}); err != nil {
+			t.Fatal(err)
+		}
+		fc.Advance(time.Minute)
+	}
+	add("s", "event", "signup")
+	add("s", "page", "/")
+	add("other", "event", "signup")
+	add("s", "event", "purchase")
+	add("s", "event", "signup")
+
+	q := EventListQuery{SiteID: "s", Since: time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), Limit: 10}
+	list, err := m.ListEvents(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Event != "signup" || list[1].Event != "purchase" || list[0].Country != "Canada" {
+		t.Fatalf("got %+v", list)
+	}
+	if list[0].ID == list[2].ID || list[0].ReceivedAt != time.Date(2024, 3, 10, 12, 4, 0, 0, time.UTC) {
+		t.Errorf("got %+v", list)
+	}
+	again, _ := m.ListEvents(context.Background(), q)
+	if again[0].ID != list[0].ID {
+		t.Errorf("IDs changed: %s and %s", list[0].ID, again[0].ID)
+	}
+
+	q.Event = "signup"
+	q.Since = time.Date(2024, 3, 10, 12, 1, 0, 0, time.UTC)
+	if list, _ := m.ListEvents(context.Background(), q); len(list) != 1 {
+		t.Errorf("since the cursor: got %+v", list)
+	}
+	q.Event, q.Since, q.Limit = "", time.Time{}, 1
+	if list, _ := m.ListEvents(context.Background(), q); len(list) != 1 || list[0].Event != "signup" {
+		t.Errorf("limit: got %+v", list)
+	}
+}
+
+func TestClosedDays(t *testing.T) {
+	series := []TimeSeriesPoint{{Day: 20240308, Visitors: 1}, {Day: 20240309, Visitors: 2}, {Day: 20240310, Visitors: 3}}
+	// 20240309 closes at noon on the 10th
+	list := ClosedDays("s", series, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	if len(list) != 2 || list[0].Day != 20240309 || list[0].ID != "s-20240309" || list[1].Visitors != 1 {
+		t.Errorf("got %+v", list)
+	}
+	if list := ClosedDays("s", series, time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)); len(list) != 0 {
+		t.Errorf("nothing closed: got %+v", list)
+	}
+}
@@ -1,5 +1,7 @@
 package tracker
 
+import "time"
+
 type TrackingData struct {
 	Type          string `json:"type"`
 	Identity      string `json:"identity"`
@@ -8,8 +10,41 @@ type TrackingData struct {
 	Category      string `json:"category"`
 	Referrer      string `json:"referrer"`
 	ReferrerHost  string
-	IsTouchDevice bool `json:"isTouchDevice"`
+	IsTouchDevice bool    `json:"isTouchDevice"`
+	Source        string  `json:"source"`
+	Consent       Consent `json:"consent"`
+	Campaign      string  `json:"campaign"`
 	OccuredAt     uint32
+
+	// The other UTM parameters of landing pages, Campaign is utm_campaign.
+	// See parseUTM.
+	UTMSource  string `json:"utmSource"`
+	UTMMedium  string `json:"utmMedium"`
+	UTMTerm    string `json:"utmTerm"`
+	UTMContent string `json:"utmContent"`
+
+	// Vitals are the web vitals of VitalsType events, by name
+	Vitals map[string]float64 `json:"vitals,omitempty"`
+	// Error is the JavaScript error of ErrorType events
+	Error *JSError `json:"error,omitempty"`
+
+	// HappenedAt is the time the client reports the event happened at,
+	// zero for events stored as they arrive. Late events happened long
+	// before their site's latest event, see Watermarks.
+	HappenedAt time.Time `json:"-"`
+	Late       bool      `json:"-"`
+
+	// Quality flags events stats leave out, see Classify and VolumeGuard.
+	// Empty is QualityOK.
+	Quality string `json:"-"`
+
+	// SessionID is the visit the event belongs to, see Visits. Empty for
+	// anonymous and imported events.
+	SessionID string `json:"-"`
+
+	// Set from mobile SDK events only, see MobileEvent
+	AppVersion string
+	OSVersion  string
 }
 
 type Tracking struct {
@@ -34,12 +69,89 @@ type Metric struct {
 	Count     uint64 `json:"count"`
 }
 
+// StatsSource tells where stats were computed from.
+type StatsSource string
+
+const (
+	SourceRaw     StatsSource = "raw"
+	SourceRollups StatsSource = "rollups"
+	SourceMemory  StatsSource = "memory"
+)
+
+// StatsMeta describes how a stats result was computed. SampleRate is the
+// fraction of events the result is based on, always 1 as events aren't
+// sampled. Confidence is the level of the bounds of estimated counts, such
+// as forecasts.
+type StatsMeta struct {
+	Rows        int               `json:"rows"`
+	DurationMs  float64           `json:"durationMs"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	Granularity string            `json:"granularity"`
+	SampleRate  float64           `json:"sampleRate"`
+	Confidence  float64           `json:"confidence,omitempty"`
+	Source      StatsSource       `json:"source"`
+	Cached      bool              `json:"cached"`
+}
+
+// StatsResult is the response of the stats endpoint. Data combines every
+// queried site, BySite splits it per site when more than one was queried.
+type StatsResult struct {
+	Meta   StatsMeta           `json:"meta"`
+	Data   []Metric            `json:"data"`
+	BySite map[string][]Metric `json:"bySite,omitempty"`
+}
+
+// MetricData selects stats for SiteID, or for all SiteIDs combined when set.
+// Org is resolved to the IDs of the organisation's sites by the server.
+// Source restricts the stats to events from one source, see Sources, and
+// Touch to events from touch or non-touch devices, see Touches.
 type MetricData struct {
-	What   QueryType `json:"what"`
-	SiteID string    `json:"siteId"`
-	Start  uint32    `json:"start"`
-	End    uint32    `json:"end"`
-	Extra  string    `json:"extra"`
+	What    QueryType `json:"what"`
+	SiteID  string    `json:"siteId"`
+	SiteIDs []string  `json:"siteIds,omitempty"`
+	Org     string    `json:"org,omitempty"`
+	Start   uint32    `json:"start"`
+	End     uint32    `json:"end"`
+	Extra   string    `json:"extra"`
+	Source  string    `json:"source,omitempty"`
+	Touch   string    `json:"touch,omitempty"`
+	// Qualities are the qualities of the events counted, QualityOK only
+	// when empty. Rollups only count QualityOK.
+	Qualities []string `json:"qualities,omitempty"`
+	// Filters restrict the page views counted to those whose fields, as in
+	// ValuesQuery, have these values. Filtered queries aren't answered from
+	// the rollups.
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// ValuesQuery selects the distinct values of a filterable field starting
+// with Prefix, most frequent first.
+type ValuesQuery struct {
+	SiteID string
+	Field  string
+	Prefix string
+	Start  uint32
+	End    uint32
+	Limit  int
+	Source string
+	Touch  string
+}
+
+// UsageQuery selects ingested event counts between two YYYYMMDD days, for a
+// single site when SiteID is set.
+type UsageQuery struct {
+	SiteID  string
+	Start   uint32
+	End     uint32
+	Monthly bool
+}
+
+// UsageRow is the number of events a site ingested in a period, YYYYMMDD for
+// daily and YYYYMM for monthly usage.
+type UsageRow struct {
+	SiteID string `json:"siteId"`
+	Period string `json:"period"`
+	Events uint64 `json:"events"`
 }
 
 type Config struct {
@@ -49,6 +161,209 @@ type Config struct {
 	ClickHouseDB       string
 	ClickHouseUser     string
 	ClickHousePassword string
+	ServerMode         ServerMode
+	SitesFile          string
+	SavedFile          string
+	KeysFile           string
+	CORSOrigins        []string
+	OriginCheck        OriginCheck
+
+	// ClickHouse client tuning: ClickHouseCompress is lz4, zstd or none,
+	// ClickHouseMaxExec bounds every query server side. ClientVersion is
+	// sent as the client product version, the binary's by default. The
+	// connection is pinged every ClickHousePing and reopened when it died,
+	// 0 disables the watchdog.
+	ClickHouseCompress string
+	ClickHouseMaxExec  time.Duration
+	ClickHouseBlockBuf int
+	ClickHousePing     time.Duration
+	ClientVersion      string
+
+	// Users sign in with the OIDC provider at OIDCIssuer and get a session
+	// lasting SessionTTL, signed with SessionSecret. Their roles are read
+	// from UsersFile. An empty OIDCIssuer disables sign-in.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	SessionSecret    string
+	SessionTTL       time.Duration
+	UsersFile        string
+
+	// TrustedProxies are the CIDRs of proxies skipped in X-Forwarded-For
+	// chains, nil uses private and loopback networks.
+	TrustedProxies []string
+
+	// AdminAddr is the address of the admin port for operators, empty
+	// (ADMIN_ADDR=off) disables it. It defaults to loopback only.
+	AdminAddr string
+
+	// SiteVerification registered only accepts events for registered
+	// sites, required only for verified ones.
+	SiteVerification SiteVerification
+
+	// Ingest quotas
+	QuotaMode           QuotaMode
+	QuotaFile           string
+	DefaultMonthlyQuota uint64
+
+	// Raw events older than this many days are deleted, only rollups are
+	// kept. 0 keeps raw events forever.
+	RawRetentionDays uint64
+	RawRetentionMode RetentionMode
+
+	// Raw events older than RecompressDays are recompressed with the
+	// RecompressCodec, 0 never recompresses them. EventsSettings are
+	// name=value MergeTree settings of the events table.
+	RecompressDays  uint64
+	RecompressCodec string
+	EventsSettings  []string
+
+	// Request bodies of single events and of batches are capped at these
+	// sizes, compressed bodies once decompressed.
+	MaxBodyBytes  int64
+	MaxBatchBytes int64
+
+	// Events with an unknown consent state are stored identified or
+	// anonymously, sites can override it.
+	ConsentUnknown ConsentPolicy
+
+	// Visitor ID cookies of sites with VisitorCookie last CookieMaxAge and
+	// are sent with CookieSameSite: lax, strict or none.
+	CookieMaxAge   time.Duration
+	CookieSameSite string
+
+	// A visitor's session ends after SessionTimeout without events, the
+	// sessions of at most SessionCacheSize visitors are tracked at once.
+	// Unrelated to the sign-in sessions of SessionTTL.
+	SessionTimeout   time.Duration
+	SessionCacheSize int
+
+	// Signed payloads are valid for SignatureMaxAge either side of their
+	// timestamp, the nonces of at most NonceCacheSize of them are remembered.
+	SignatureMaxAge time.Duration
+	NonceCacheSize  int
+
+	// Visitor identities are stored encrypted with the base64 key in
+	// IdentityKey, or in the IdentityKeyFile, when either is set.
+	IdentityKey     string
+	IdentityKeyFile string
+
+	// Identical payloads from the same IP within this window are duplicates.
+	ReplayWindow time.Duration
+
+	// Identities or IPs sending more than AnomalyEventsPerMinute events a
+	// minute are suspected of automation, their events are tagged or
+	// throttled per AnomalyAction. 0 suspects nobody.
+	AnomalyEventsPerMinute int
+	AnomalyAction          AnomalyAction
+
+	// Referrals from these domains and their subdomains are QualitySpam.
+	SpamReferrers []string
+
+	// Events happening more than LateEventAfter before their site's latest
+	// event are late, 0 never flags them.
+	LateEventAfter time.Duration
+
+	// A page view is sent to CanarySite every CanaryInterval and queried
+	// until it shows up, measuring the ingest-to-query latency. 0 disables
+	// the canary.
+	CanaryInterval time.Duration
+	CanarySite     string
+
+	// Events of one of the PageviewTypes, or of one of the
+	// PageviewCategories, are stored as page views, for trackers naming
+	// them differently than the JS tracker.
+	PageviewTypes      []string
+	PageviewCategories []string
+
+	// Dev mode keeps at most MemoryMaxEvents events in memory, dropping
+	// the oldest past it, 0 for no limit.
+	MemoryMaxEvents int
+
+	// Events added while QueueHighWater events wait to be inserted are
+	// spilled to the QueueSpillFile and queued again once the queue has
+	// room. Without a file adding events blocks until it has.
+	QueueSpillFile string
+	QueueHighWater int
+
+	// Imported events wait in a queue of BulkQueueSize and are added at
+	// most BulkRate per second, 0 for no limit, while fewer than
+	// BulkMaxQueued events wait to be inserted.
+	BulkQueueSize int
+	BulkRate      int
+	BulkMaxQueued int
+
+	// Export jobs and their results are kept in JobsDir, an empty one
+	// (JOBS_DIR=off) disables them. Finished jobs are deleted after
+	// JobRetention.
+	JobsDir      string
+	JobRetention time.Duration
+
+	// Site lifecycle, quota and retention events are posted to the
+	// WebhookURLs, signed with the WebhookSecret when set. Quota events
+	// fire when a site's monthly usage reaches each WebhookQuotaPercent of
+	// its limit.
+	WebhookURLs         []string
+	WebhookSecret       string
+	WebhookQuotaPercent []uint64
+
+	// Alerts sent to email channels go through the SMTP server at SMTPAddr
+	// (host:port) from SMTPFrom, authenticated when SMTPUsername is set.
+	// Without SMTPAddr alert rules can't have email channels.
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Geo enrichment runs on GeoWorkers workers fed by a queue of
+	// GeoQueueSize events, lookups are cached per anonymized IP.
+	GeoTimeout   time.Duration
+	GeoWorkers   int
+	GeoQueueSize int
+	GeoCacheSize int
+	GeoCacheTTL  time.Duration
+	// How long a worker waits for a geo lookup before moving on to the
+	// next event, the lookup then finishes in the background, which itself
+	// gives up after GeoTimeout.
+	EnrichmentBudget time.Duration
+
+	// The IPs and networks of GeoOverridesFile are located as it says,
+	// before any lookup.
+	GeoOverridesFile string
+
+	// GeoIPMMDBPath is a MaxMind GeoLite2 City or Country database IPs are
+	// looked up in locally, with echoip at EchoIPHost only asked for those
+	// it can't locate.
+	GeoIPMMDBPath string
+
+	// Events missing geo or UA enrichment are stored as they are, or
+	// written to the EnrichmentSpool file and retried.
+	EnrichmentFailure EnrichmentFailure
+	EnrichmentSpool   string
+
+	// Stats queries are rejected for BreakerCooldown after BreakerThreshold
+	// consecutive failures.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// At most StatsConcurrency stats queries run at once, others wait up to
+	// StatsQueueTimeout for a slot.
+	StatsConcurrency  int
+	StatsQueueTimeout time.Duration
+
+	// Every API key may run StatsRateLimit stats queries per minute and
+	// StatsDailyQueries per UTC day, 0 for no limit. Keys can override both.
+	StatsRateLimit    int
+	StatsDailyQueries int
+
+	// The overview queries of recently active sites are run every
+	// WarmupInterval and served from cache, 0 disables the warm-up.
+	WarmupInterval time.Duration
+
+	// PublicURL is the URL sites reach the tracker at, for installation
+	// snippets. Empty uses the host requests came to.
+	PublicURL string
 
 	// Dashboard
 	GoTrackerHost string
@@ -0,0 +1,476 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Breaking changes to the events table, such as new column types or sort
+// keys, can't be made with ALTER TABLE. They are rolled out blue/green:
+//
+//  1. start creates events_next from a CREATE TABLE statement, and every
+//     tracker inserting events copies its inserts to it from a time far
+//     enough ahead for all of them to have noticed.
+//  2. backfill copies the events inserted before that time, a day at a
+//     time, and can be resumed.
+//  3. verify compares the events of every day in both tables.
+//  4. swap exchanges the tables and recreates the materialized views, the
+//     old table is kept as events_next until drop.
+//
+// The upgrade's progress is kept in the schema_upgrade table, trackers poll
+// it every UpgradePollInterval.
+
+// UpgradeTable is the table an upgrade builds next to events.
+const UpgradeTable = "events_next"
+
+// UpgradePollInterval is how often trackers check whether to copy their
+// inserts to UpgradeTable.
+const UpgradePollInterval = 30 * time.Second
+
+// upgradeGrace is how long after start dual-writes begin, every tracker
+// polls at least once meanwhile.
+const upgradeGrace = 2*UpgradePollInterval + 10*time.Second
+
+// UpgradeState is how far an upgrade went.
+type UpgradeState string
+
+const (
+	UpgradeDualWrite  UpgradeState = "dual-write"
+	UpgradeBackfilled UpgradeState = "backfilled"
+	UpgradeVerified   UpgradeState = "verified"
+	UpgradeSwapped    UpgradeState = "swapped"
+	UpgradeDropped    UpgradeState = "dropped"
+)
+
+// Upgrade is the progress of a blue/green upgrade of the events table.
+// Events inserted from Since on are written to both tables, those inserted
+// before are backfilled, up to the BackfilledTo YYYYMMDD day so far.
+type Upgrade struct {
+	State        UpgradeState `json:"state"`
+	Since        time.Time    `json:"since"`
+	BackfilledTo uint32       `json:"backfilledTo"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+}
+
+// writing reports whether trackers copy their inserts during u.
+func (u *Upgrade) writing() bool {
+	return u != nil && (u.State == UpgradeDualWrite || u.State == UpgradeBackfilled || u.State == UpgradeVerified)
+}
+
+// UpgradeMismatch is a day whose events differ in number between events and
+// UpgradeTable.
+type UpgradeMismatch struct {
+	Day  uint32 `json:"day"`
+	Old  uint64 `json:"old"`
+	Next uint64 `json:"next"`
+}
+
+var upgradeStats = expvar.NewMap("upgrade")
+
+var createNextRe = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?` + UpgradeTable + `\s*\(`)
+
+// UpgradeStatus returns the latest upgrade, nil when there was none.
+func (e *Events) UpgradeStatus(ctx context.Context) (*Upgrade, error) {
+	rows, err := e.DB.Query(ctx, `
+		SELECT state, since, backfilled_to, updated_at
+		FROM schema_upgrade
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading upgrade: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var u Upgrade
+	var state string
+	if err := rows.Scan(&state, &u.Since, &u.BackfilledTo, &u.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed scanning upgrade: %w", err)
+	}
+	u.State = UpgradeState(state)
+	return &u, nil
+}
+
+func (e *Events) saveUpgrade(ctx context.Context, u *Upgrade) error {
+	u.UpdatedAt = clock.Now().UTC()
+	err := e.DB.Exec(ctx, "INSERT INTO schema_upgrade (state, since, backfilled_to, updated_at) VALUES (?, ?, ?, ?)",
+		string(u.State), u.Since, u.BackfilledTo, u.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed saving upgrade: %w", err)
+	}
+	e.log.Info("Upgrade progressed", slog.String("state", string(u.State)), slog.Int("backfilledTo", int(u.BackfilledTo)))
+	return nil
+}
+
+// upgradeIn returns the upgrade in progress, an error unless it is in one of
+// states.
+func (e *Events) upgradeIn(ctx context.Context, states ...UpgradeState) (*Upgrade, error) {
+	u, err := e.UpgradeStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		if u != nil && u.State == state {
+			return u, nil
+		}
+	}
+	current := "none"
+	if u != nil {
+		current = string(u.State)
+	}
+	return nil, fmt.Errorf("%w: upgrade is %s, want %v", ErrInvalid, current, states)
+}
+
+// StartUpgrade creates UpgradeTable with ddl, a CREATE TABLE statement, and
+// has trackers copy their inserts to it once Since has come.
+func (e *Events) StartUpgrade(ctx context.Context, ddl string) (*Upgrade, error) {
+	if !createNextRe.MatchString(ddl) {
+		return nil, fmt.Errorf("%w: the statement must be CREATE TABLE %s (...)", ErrInvalid, UpgradeTable)
+	}
+	if u, err := e.UpgradeStatus(ctx); err != nil {
+		return nil, err
+	} else if u.writing() || (u != nil && u.State == UpgradeSwapped) {
+		return nil, fmt.Errorf("%w: an upgrade is %s, drop it first", ErrInvalid, u.State)
+	}
+
+	if err := e.DB.Exec(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("failed creating %s: %w", UpgradeTable, err)
+	}
+	next, err := e.tableColumns(ctx, UpgradeTable)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range insertColumns {
+		if !next[col] {
+			return nil, fmt.Errorf("%w: %s has no %s column, inserts couldn't be copied", ErrInvalid, UpgradeTable, col)
+		}
+	}
+
+	u := &Upgrade{State: UpgradeDualWrite, Since: clock.Now().Add(upgradeGrace).UTC().Truncate(time.Second)}
+	if err := e.saveUpgrade(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// tableColumns returns the columns of table that can be inserted into,
+// neither ALIAS nor MATERIALIZED.
+func (e *Events) tableColumns(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := e.DB.Query(ctx, `
+		SELECT name
+		FROM system.columns
+		WHERE database = currentDatabase() AND table = $1
+		AND default_kind IN ('', 'DEFAULT')
+		ORDER BY position
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading %s columns: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed scanning %s column: %w", table, err)
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+// backfillColumns are the columns events and UpgradeTable share, copied by
+// the backfill, in the order of the events table.
+func (e *Events) backfillColumns(ctx context.Context) ([]string, error) {
+	next, err := e.tableColumns(ctx, UpgradeTable)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := e.DB.Query(ctx, `
+		SELECT name
+		FROM system.columns
+		WHERE database = currentDatabase() AND table = 'events'
+		AND default_kind IN ('', 'DEFAULT')
+		ORDER BY position
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading events columns: %w", err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed scanning events column: %w", err)
+		}
+		if next[name] {
+			cols = append(cols, name)
+		}
+	}
+	return cols, rows.Err()
+}
+
+// BackfillUpgrade copies the events inserted before the upgrade's Since to
+// UpgradeTable a day at a time, calling progress after every day. An
+// interrupted backfill resumes with the last day it completed, copied again
+// along with whatever it copied after.
+func (e *Events) BackfillUpgrade(ctx context.Context, progress func(day uint32)) error {
+	u, err := e.upgradeIn(ctx, UpgradeDualWrite)
+	if err != nil {
+		return err
+	}
+	if wait := u.Since.Sub(clock.Now()); wait > 0 {
+		return fmt.Errorf("%w: dual-writes start at %s, backfill in %s", ErrInvalid, u.Since.Format(time.RFC3339), wait.Round(time.Second))
+	}
+	cols, err := e.backfillColumns(ctx)
+	if err != nil {
+		return err
+	}
+
+	days, err := e.upgradeDays(ctx, u)
+	if err != nil {
+		return err
+	}
+	// Copies of an interrupted backfill, dual-writes are inserted later
+	clear := fmt.Sprintf("ALTER TABLE %s DELETE WHERE occured_at >= ? AND timestamp < ? SETTINGS mutations_sync = 1", UpgradeTable)
+	if err := e.DB.Exec(ctx, clear, u.BackfilledTo, u.Since); err != nil {
+		return fmt.Errorf("failed clearing interrupted backfill: %w", err)
+	}
+
+	list := strings.Join(cols, ", ")
+	for _, day := range days {
+		qry := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM events WHERE occured_at = ? AND timestamp < ?", UpgradeTable, list, list)
+		if err := e.DB.Exec(ctx, qry, day, u.Since); err != nil {
+			return fmt.Errorf("failed backfilling day %d: %w", day, err)
+		}
+		u.BackfilledTo = day
+		if err := e.saveUpgrade(ctx, u); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(day)
+		}
+	}
+
+	u.State = UpgradeBackfilled
+	return e.saveUpgrade(ctx, u)
+}
+
+// upgradeDays returns the days with events inserted before Since left to
+// backfill, oldest first, from BackfilledTo on.
+func (e *Events) upgradeDays(ctx context.Context, u *Upgrade) ([]uint32, error) {
+	rows, err := e.DB.Query(ctx, `
+		SELECT DISTINCT occured_at
+		FROM events
+		WHERE occured_at >= $1 AND timestamp < $2
+		ORDER BY occured_at
+	`, u.BackfilledTo, u.Since)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing days to backfill: %w", err)
+	}
+	defer rows.Close()
+
+	var days []uint32
+	for rows.Next() {
+		var day uint32
+		if err := rows.Scan(&day); err != nil {
+			return nil, fmt.Errorf("failed scanning day to backfill: %w", err)
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// VerifyUpgrade compares the events of every day in events and
+// UpgradeTable, returning the days they differ on. Without any the upgrade
+// can be swapped. Run it while few events are inserted, those in flight
+// may not have reached both tables yet.
+func (e *Events) VerifyUpgrade(ctx context.Context) ([]UpgradeMismatch, error) {
+	u, err := e.upgradeIn(ctx, UpgradeBackfilled, UpgradeVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	qry := fmt.Sprintf(`
+		SELECT day, sum(old), sum(next)
+		FROM (
+			SELECT occured_at AS day, count() AS old, toUInt64(0) AS next FROM events GROUP BY day
+			UNION ALL
+			SELECT occured_at AS day, toUInt64(0) AS old, count() AS next FROM %s GROUP BY day
+		)
+		GROUP BY day
+		HAVING sum(old) != sum(next)
+		ORDER BY day
+	`, UpgradeTable)
+	rows, err := e.DB.Query(ctx, qry)
+	if err != nil {
+		return nil, fmt.Errorf("failed comparing tables: %w", err)
+	}
+	defer rows.Close()
+
+	mismatches := []UpgradeMismatch{}
+	for rows.Next() {
+		var m UpgradeMismatch
+		if err := rows.Scan(&m.Day, &m.Old, &m.Next); err != nil {
+			return nil, fmt.Errorf("failed scanning comparison: %w", err)
+		}
+		mismatches = append(mismatches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(mismatches) == 0 {
+		u.State = UpgradeVerified
+		if err := e.saveUpgrade(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	return mismatches, nil
+}
+
+var viewRe = regexp.MustCompile(`CREATE MATERIALIZED VIEW IF NOT EXISTS (\w+) TO \w+ AS\s[\s\S]*\sFROM events\s`)
+
+// eventsViews returns the latest definition of every materialized view
+// reading from events, by name.
+func eventsViews() map[string]string {
+	views := make(map[string]string)
+	for _, qry := range migrations {
+		if m := viewRe.FindStringSubmatch(qry); m != nil {
+			views[m[1]] = qry
+		}
+	}
+	return views
+}
+
+// SwapUpgrade exchanges events and UpgradeTable once verified, atomically,
+// and recreates the materialized views reading from events. Events inserted
+// while the views are recreated, a few milliseconds, aren't rolled up. The
+// old table is kept as UpgradeTable until DropUpgrade.
+func (e *Events) SwapUpgrade(ctx context.Context) error {
+	u, err := e.upgradeIn(ctx, UpgradeVerified)
+	if err != nil {
+		return err
+	}
+
+	views := eventsViews()
+	for name := range views {
+		if err := e.DB.Exec(ctx, "DROP VIEW IF EXISTS "+name); err != nil {
+			return fmt.Errorf("failed dropping %s: %w", name, err)
+		}
+	}
+	swapErr := e.DB.Exec(ctx, "EXCHANGE TABLES events AND "+UpgradeTable)
+	for name, qry := range views {
+		if err := e.DB.Exec(ctx, qry); err != nil {
+			return errors.Join(swapErr, fmt.Errorf("failed recreating %s: %w", name, err))
+		}
+	}
+	if swapErr != nil {
+		return fmt.Errorf("failed exchanging tables: %w", swapErr)
+	}
+
+	u.State = UpgradeSwapped
+	return e.saveUpgrade(ctx, u)
+}
+
+// DropUpgrade ends the upgrade: trackers stop copying their inserts and
+// UpgradeTable, the new table when the upgrade is abandoned before swap or
+// the old one after, is dropped once wait has passed for them to notice.
+func (e *Events) DropUpgrade(ctx context.Context, wait time.Duration) error {
+	u, err := e.UpgradeStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		u = &Upgrade{}
+	}
+	if u.writing() {
+		u.State = UpgradeDropped
+		if err := e.saveUpgrade(ctx, u); err != nil {
+			return err
+		}
+		timer := clock.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else if u.State != UpgradeDropped {
+		u.State = UpgradeDropped
+		if err := e.saveUpgrade(ctx, u); err != nil {
+			return err
+		}
+	}
+	if err := e.DB.Exec(ctx, "DROP TABLE IF EXISTS "+UpgradeTable); err != nil {
+		return fmt.Errorf("failed dropping %s: %w", UpgradeTable, err)
+	}
+	return nil
+}
+
+// dualWrite is the upgrade inserts are copied during.
+type dualWrite struct {
+	since time.Time
+}
+
+// RunUpgradeWatch checks every interval until ctx is done whether an
+// upgrade is in progress, copying inserts to UpgradeTable from its Since on
+// while it is.
+func (e *Events) RunUpgradeWatch(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.watchUpgrade(ctx)
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Events) watchUpgrade(ctx context.Context) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	u, err := e.UpgradeStatus(queryCtx)
+	if err != nil {
+		// Keep doing what was last decided
+		e.log.Warn("Failed to check for an upgrade", slog.Any("error", err))
+		return
+	}
+	var dual *dualWrite
+	if u.writing() {
+		dual = &dualWrite{since: u.Since}
+	}
+	if old := e.dual.Swap(dual); (old == nil) != (dual == nil) {
+		e.log.Info("Copying inserts to "+UpgradeTable+" changed", slog.Bool("copying", dual != nil))
+	}
+}
+
+// copyToUpgrade copies the inserted cols to UpgradeTable during an
+// upgrade. Failures are logged, not returned: the events are stored, and
+// verify tells about the missing copies.
+func (e *Events) copyToUpgrade(ctx context.Context, cols *eventColumns) {
+	dual := e.dual.Load()
+	if dual == nil || clock.Now().Before(dual.since) {
+		return
+	}
+	if err := e.sendColumns(ctx, UpgradeTable, cols); err != nil {
+		upgradeStats.Add("copy_failed", 1)
+		e.log.Error("Failed to copy inserted events to "+UpgradeTable, slog.Any("error", err))
+		return
+	}
+	upgradeStats.Add("copied", int64(len(cols.siteID)))
+}
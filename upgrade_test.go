@@ -0,0 +1,30 @@
+package tracker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventsViews(t *testing.T) {
+	views := eventsViews()
+	if len(views) != 3 || views["usage_daily_mv"] == "" || views["site_totals_mv"] == "" {
+		t.Fatalf("got %v", views)
+	}
+	if !strings.Contains(views["events_daily_mv"], "quality = 'ok'") {
+		t.Errorf("events_daily_mv isn't the latest definition: %s", views["events_daily_mv"])
+	}
+}
+
+func TestCreateNext(t *testing.T) {
+	for ddl, want := range map[string]bool{
+		"CREATE TABLE events_next (site_id String) ENGINE MergeTree ORDER BY site_id": true,
+		"\n\tcreate table if not exists events_next(site_id String)":                  true,
+		"CREATE TABLE events (site_id String)":                                        false,
+		"CREATE TABLE events_next_old (site_id String)":                               false,
+		"DROP TABLE events; CREATE TABLE events_next (site_id String)":                false,
+	} {
+		if got := createNextRe.MatchString(ddl); got != want {
+			t.Errorf("%q: got %v", ddl, got)
+		}
+	}
+}
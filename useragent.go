@@ -0,0 +1,90 @@
+package tracker
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mileusna/useragent"
+)
+
+// UnknownBrowser is the browser of events whose user agent is missing or
+// can't be made sense of.
+const UnknownBrowser = "Unknown"
+
+// maxBrowserNameLen bounds browser names, longer ones are garbage.
+const maxBrowserNameLen = 64
+
+// headlessBrowsers are the automated browsers reported apart from the
+// browsers they are built on, by the token in their user agent.
+var headlessBrowsers = []struct{ token, name string }{
+	{"HeadlessChrome", "Headless Chrome"},
+	{"PhantomJS", "PhantomJS"},
+	{"SlimerJS", "SlimerJS"},
+}
+
+// ParseUserAgent parses a user agent for the browsers, OS and devices
+// reports. Only the first maxUALen bytes are parsed, headless browsers are
+// bots named apart, and empty or garbage user agents are UnknownBrowser.
+func ParseUserAgent(s string) useragent.UserAgent {
+	s = sanitizeField(s, maxUALen)
+	ua := useragent.Parse(s)
+	for _, b := range headlessBrowsers {
+		if strings.Contains(s, b.token) {
+			ua.Name, ua.Bot = b.name, true
+			return ua
+		}
+	}
+	if garbageBrowser(ua) {
+		ua.Name, ua.Version = UnknownBrowser, ""
+	}
+	return ua
+}
+
+// garbageBrowser reports whether the browser of ua isn't a name: empty,
+// too long, not printable, or the whole user agent, matched by nothing.
+func garbageBrowser(ua useragent.UserAgent) bool {
+	if ua.Name == "" || len(ua.Name) > maxBrowserNameLen {
+		return true
+	}
+	if strings.IndexFunc(ua.Name, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return true
+	}
+	return ua.Name == ua.String && ua.Version == "" && ua.OS == "" && !ua.Bot
+}
+
+// Rendering engines of browsers, see BrowserEngine.
+const (
+	EngineBlink    = "Blink"
+	EngineGecko    = "Gecko"
+	EngineWebKit   = "WebKit"
+	EngineTrident  = "Trident"
+	EngineEdgeHTML = "EdgeHTML"
+	EnginePresto   = "Presto"
+)
+
+// BrowserEngine returns the rendering engine of the browser of ua, "" when
+// it isn't a browser's. Chromium based browsers all render with Blink, and
+// every iOS browser with WebKit.
+func BrowserEngine(ua useragent.UserAgent) string {
+	s := ua.String
+	has := func(token string) bool { return strings.Contains(s, token) }
+	switch {
+	case s == "":
+		return ""
+	case has("Edge/"):
+		return EngineEdgeHTML
+	case has("Trident/") || has("MSIE "):
+		return EngineTrident
+	case has("Presto/"):
+		return EnginePresto
+	case ua.OS == useragent.IOS && has("AppleWebKit/"):
+		return EngineWebKit
+	case has("AppleWebKit/") && (has("Chrome/") || has("Chromium/")):
+		return EngineBlink
+	case has("AppleWebKit/"):
+		return EngineWebKit
+	case has("Gecko/") || has("Firefox/"):
+		return EngineGecko
+	}
+	return ""
+}
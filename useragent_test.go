@@ -0,0 +1,50 @@
+package tracker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua, name string
+		bot      bool
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome", false},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", "Headless Chrome", true},
+		{"Mozilla/5.0 (Unknown; Linux x86_64) AppleWebKit/538.1 (KHTML, like Gecko) PhantomJS/2.1.1 Safari/538.1", "PhantomJS", true},
+		{"curl/8.4.0", "curl", false},
+		{"", UnknownBrowser, false},
+		{"   ", UnknownBrowser, false},
+		{"garbage!!", UnknownBrowser, false},
+		{strings.Repeat("x", 10_000), UnknownBrowser, false},
+	}
+	for _, tt := range tests {
+		ua := ParseUserAgent(tt.ua)
+		if ua.Name != tt.name || ua.Bot != tt.bot {
+			t.Errorf("%.40q: got %q bot %v", tt.ua, ua.Name, ua.Bot)
+		}
+		if len(ua.String) > maxUALen {
+			t.Errorf("%.40q: parsed %d bytes", tt.ua, len(ua.String))
+		}
+	}
+}
+
+func TestBrowserEngine(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0":                   EngineBlink,
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36":                                   EngineBlink,
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                                          EngineGecko,
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15":                              EngineWebKit,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1": EngineWebKit,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Safari/537.36 Edge/15.15063":               EngineEdgeHTML,
+		"Mozilla/5.0 (Windows NT 10.0; Trident/7.0; rv:11.0) like Gecko":                                                                                  EngineTrident,
+		"curl/8.4.0": "",
+		"":           "",
+	}
+	for ua, want := range tests {
+		if got := BrowserEngine(ParseUserAgent(ua)); got != want {
+			t.Errorf("%.60q: got %q, want %q", ua, got, want)
+		}
+	}
+}
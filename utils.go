@@ -1,16 +1,40 @@
 package tracker
 
 import (
-	"log"
-	"strconv"
-	"time"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 )
 
-func TimeToInt(d time.Time) uint32 {
-	now := d.Format("20060102")
-	i, err := strconv.ParseInt(now, 10, 32)
+// readJSONFile decodes the file at path into v. A missing file is not an
+// error and leaves v untouched.
+func readJSONFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile encodes v to path through a temporary file, so a crash never
+// leaves a partially written file behind.
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
 	}
-	return uint32(i)
+	return nil
 }
@@ -0,0 +1,38 @@
+package tracker
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UTM parameters tag the links of a campaign. The tracking script sends
+// those of the landing page with its view only, so they attribute a visit
+// rather than each of its pages. Other clients may track the landing page
+// with its query string instead, the parameters are then parsed from it.
+// Referrers aren't parsed, on the site itself they are the landing page
+// again and would attribute the page after it as well.
+
+// parseUTM fills the UTM fields of a page view the payload left empty from
+// the query string of its event.
+func parseUTM(action *TrackingData) {
+	_, rawQuery, ok := strings.Cut(action.Event, "?")
+	if !ok {
+		return
+	}
+	rawQuery, _, _ = strings.Cut(rawQuery, "#")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil && len(query) == 0 {
+		return
+	}
+	for name, field := range map[string]*string{
+		"utm_campaign": &action.Campaign,
+		"utm_source":   &action.UTMSource,
+		"utm_medium":   &action.UTMMedium,
+		"utm_term":     &action.UTMTerm,
+		"utm_content":  &action.UTMContent,
+	} {
+		if *field == "" {
+			*field = sanitizeField(query.Get(name), maxCampaignLen)
+		}
+	}
+}
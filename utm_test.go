@@ -0,0 +1,23 @@
+package tracker
+
+import "testing"
+
+func TestParseUTM(t *testing.T) {
+	// The script's fields win over the event's query string
+	trk, err := DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"page","category":"Page views",` +
+		`"event":"/pricing?utm_source=ads&utm_medium=cpc&utm_campaign=spring#plans","campaign":"launch",` +
+		`"referrer":"https://example.com/?utm_term=ignored"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := trk.Action
+	if a.Campaign != "launch" || a.UTMSource != "ads" || a.UTMMedium != "cpc" || a.UTMTerm != "" || a.UTMContent != "" {
+		t.Errorf("page view: got %+v", a)
+	}
+
+	// Only page views are attributed
+	trk, _ = DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"event","category":"Clicks","event":"/?utm_source=ads"}}`))
+	if trk.Action.UTMSource != "" {
+		t.Errorf("event: got %q", trk.Action.UTMSource)
+	}
+}
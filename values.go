@@ -0,0 +1,135 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// valueFields maps the fields clients can filter on to events columns.
+var valueFields = map[string]string{
+	"path":     "event",
+	"referrer": "referrer_domain",
+	"browser":  "browser_name",
+	"os":       "os_name",
+	"country":  "country",
+	"source":   "source",
+	"touch":    "touch",
+	"campaign": "campaign",
+}
+
+// ValueColumn returns the events column behind a filterable field.
+func ValueColumn(field string) (string, error) {
+	col, ok := valueFields[field]
+	if !ok {
+		return "", fmt.Errorf("unknown field %q", field)
+	}
+	return col, nil
+}
+
+func (e *Events) Values(ctx context.Context, q ValuesQuery) ([]string, error) {
+	col, err := ValueColumn(q.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ranges reaching past the raw retention are answered from the rollups,
+	// campaigns aren't rolled up and only have the raw events' values
+	qry := fmt.Sprintf(`
+		SELECT %[1]s
+		FROM events
+		WHERE site_id = $1
+		AND occured_at BETWEEN $2 AND $3
+		AND category = 'Page views'
+		AND quality = 'ok'
+		AND %[1]s != ''
+		AND startsWith(lower(%[1]s), lower($4))
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		GROUP BY %[1]s
+		ORDER BY count() DESC, %[1]s
+		LIMIT %[2]d;
+	`, col, q.Limit)
+	if cutoff := rawCutoff(clock.Now()); cutoff != 0 && q.Start < cutoff && rollupDimensions[col] {
+		qry = fmt.Sprintf(`
+		SELECT value
+		FROM events_daily
+		WHERE site_id = $1
+		AND dimension = '%s'
+		AND day BETWEEN $2 AND $3
+		AND value != ''
+		AND startsWith(lower(value), lower($4))
+		AND ($5 = '' OR source = $5)
+		AND ($6 = '' OR touch = $6)
+		GROUP BY value
+		ORDER BY sum(events) DESC, value
+		LIMIT %d;
+	`, col, q.Limit)
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rows, err := e.DB.Query(queryCtx, qry, q.SiteID, q.Start, q.End, q.Prefix, q.Source, q.Touch)
+	if err != nil {
+		return nil, fmt.Errorf("values query failed: %w", err)
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed scanning value row: %w", err)
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (m *MemoryEvents) Values(ctx context.Context, q ValuesQuery) ([]string, error) {
+	col, err := ValueColumn(q.Field)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.ToLower(q.Prefix)
+
+	counts := make(map[string]int)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Category != PageviewCategory || quality(row.trk.Action) != QualityOK {
+			continue
+		}
+		if row.trk.Action.OccuredAt < q.Start || row.trk.Action.OccuredAt > q.End {
+			continue
+		}
+		if q.Source != "" && row.source() != q.Source {
+			continue
+		}
+		if q.Touch != "" && row.touch() != q.Touch {
+			continue
+		}
+		v := row.column(col)
+		if v != "" && strings.HasPrefix(strings.ToLower(v), prefix) {
+			counts[v]++
+		}
+	}
+	m.lock.RUnlock()
+
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	if q.Limit > 0 && len(values) > q.Limit {
+		values = values[:q.Limit]
+	}
+	return values, nil
+}
@@ -0,0 +1,181 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SiteVerification selects whether sites must prove they control their
+// domain before events are accepted for them.
+type SiteVerification string
+
+const (
+	// VerificationOff accepts events for any site, registered or not.
+	VerificationOff SiteVerification = "off"
+	// VerificationRegistered only accepts events for registered sites,
+	// verified or not, so stray site IDs don't pollute stats.
+	VerificationRegistered SiteVerification = "registered"
+	// VerificationRequired only accepts events for registered sites that
+	// were verified, for multi-tenant deployments. Sites registered before
+	// verification was required stay active.
+	VerificationRequired SiteVerification = "required"
+)
+
+// VerificationName is the name of the meta tag, and the prefix of the DNS
+// TXT record, carrying a site's verification token.
+const VerificationName = "tracker-site-verification"
+
+var (
+	// ErrNotVerified is returned when neither the DNS TXT record nor the
+	// meta tag carries the site's token.
+	ErrNotVerified = errors.New("verification token not found in DNS TXT records nor home page meta tags")
+	// ErrInvalidDomain is returned for site domains that aren't a host name.
+	ErrInvalidDomain = errors.New("domain must be a host name like example.com")
+)
+
+var (
+	hostnameRe = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
+	metaTagRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRe     = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// ValidDomain checks domain is a host name, sites can only be verified for
+// public names.
+func ValidDomain(domain string) error {
+	if !hostnameRe.MatchString(domain) || len(domain) > 253 {
+		return ErrInvalidDomain
+	}
+	return nil
+}
+
+// VerificationRecord returns the DNS TXT record value proving a site's
+// owner controls its domain.
+func VerificationRecord(site Site) string {
+	return VerificationName + "=" + site.VerificationToken
+}
+
+// VerificationMeta returns the meta tag proving a site's owner controls its
+// home page.
+func VerificationMeta(site Site) string {
+	return `<meta name="` + VerificationName + `" content="` + site.VerificationToken + `">`
+}
+
+// Verifier checks a site's token is published on its domain, in a DNS TXT
+// record or a meta tag of its home page.
+type Verifier struct {
+	LookupTXT func(ctx context.Context, name string) ([]string, error)
+	// Client fetches the home page. It only connects to public addresses,
+	// so sites can't make the tracker probe internal hosts.
+	Client *http.Client
+}
+
+func NewVerifier() *Verifier {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second}
+	return &Verifier{
+		LookupTXT: net.DefaultResolver.LookupTXT,
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 3 {
+					return errors.New("too many redirects")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Verify returns how the site proved it controls its domain, "dns" or
+// "meta", or ErrNotVerified.
+func (v *Verifier) Verify(ctx context.Context, site Site) (string, error) {
+	if site.VerificationToken == "" {
+		return "", errors.New("site has no verification token")
+	}
+	if err := ValidDomain(site.Domain); err != nil {
+		return "", err
+	}
+
+	want := VerificationRecord(site)
+	records, dnsErr := v.LookupTXT(ctx, site.Domain)
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			return "dns", nil
+		}
+	}
+
+	found, metaErr := v.homePageHasToken(ctx, site)
+	if found {
+		return "meta", nil
+	}
+	if dnsErr != nil && metaErr != nil {
+		return "", fmt.Errorf("%w: %v; %v", ErrNotVerified, dnsErr, metaErr)
+	}
+	return "", ErrNotVerified
+}
+
+func (v *Verifier) homePageHasToken(ctx context.Context, site Site) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+site.Domain+"/", nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("home page status %d", resp.StatusCode)
+	}
+
+	// The tag belongs in the head, near the top of the page
+	page, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range metaTagRe.FindAll(page, -1) {
+		attrs := map[string]string{}
+		for _, m := range attrRe.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(m[1]))] = strings.Trim(string(m[2]), `"'`)
+		}
+		if attrs["name"] == VerificationName && attrs["content"] == site.VerificationToken {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// publicOnly refuses connections to loopback, private and link-local
+// addresses.
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// AcceptsEvents reports whether events are accepted for site, registered is
+// whether it is in the registry.
+func AcceptsEvents(site Site, registered bool) bool {
+	switch config.SiteVerification {
+	case VerificationRequired:
+		return registered && !site.Unverified
+	case VerificationRegistered:
+		return registered
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// fakeVerifier serves txt as every domain's TXT records and page as every
+// home page.
+func fakeVerifier(txt []string, page string) *Verifier {
+	return &Verifier{
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) { return txt, nil },
+		Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(page)), Request: r}, nil
+		})},
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	site := Site{ID: "s", Domain: "example.com", VerificationToken: "tok"}
+	tests := []struct {
+		name   string
+		txt    []string
+		page   string
+		method string
+	}{
+		{"dns", []string{"v=spf1 -all", "tracker-site-verification=tok"}, "", "dns"},
+		{"meta", nil, `<html><head><META content='tok' name="tracker-site-verification"></head>`, "meta"},
+		{"wrong token", []string{"tracker-site-verification=other"}, `<meta name="tracker-site-verification" content="other">`, ""},
+		{"nothing", nil, "<html></html>", ""},
+	}
+	for _, tt := range tests {
+		method, err := fakeVerifier(tt.txt, tt.page).Verify(context.Background(), site)
+		if method != tt.method || (tt.method == "" && !errors.Is(err, ErrNotVerified)) {
+			t.Errorf("%s: got %q, %v", tt.name, method, err)
+		}
+	}
+
+	site.Domain = "localhost"
+	if _, err := fakeVerifier(nil, "").Verify(context.Background(), site); !errors.Is(err, ErrInvalidDomain) {
+		t.Errorf("localhost: got %v", err)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	for address, ok := range map[string]bool{
+		"93.184.216.34:443":    true,
+		"127.0.0.1:443":        false,
+		"10.1.2.3:80":          false,
+		"169.254.169.254:80":   false,
+		"[::1]:443":            false,
+		"[2606:4700::1111]:80": true,
+	} {
+		if err := publicOnly("tcp", address, nil); (err == nil) != ok {
+			t.Errorf("%s: got %v", address, err)
+		}
+	}
+}
+
+func TestAcceptsEvents(t *testing.T) {
+	defer func(prev SiteVerification) { config.SiteVerification = prev }(config.SiteVerification)
+
+	pending := Site{ID: "p", Unverified: true}
+	config.SiteVerification = VerificationOff
+	if !AcceptsEvents(pending, true) || !AcceptsEvents(Site{}, false) {
+		t.Error("verification off rejects events")
+	}
+	config.SiteVerification = VerificationRegistered
+	if !AcceptsEvents(pending, true) || AcceptsEvents(Site{}, false) {
+		t.Error("verification registered accepts the wrong sites")
+	}
+	config.SiteVerification = VerificationRequired
+	if AcceptsEvents(pending, true) || AcceptsEvents(Site{}, false) || !AcceptsEvents(Site{ID: "v"}, true) {
+		t.Error("verification required accepts the wrong sites")
+	}
+}
@@ -0,0 +1,36 @@
+package tracker
+
+import "runtime/debug"
+
+// Version is the tracker's release, set when building with
+// -ldflags "-X tracker.Version=v1.2.3".
+var Version string
+
+// BuildVersion returns Version, or else the VCS revision the binary was
+// built from, "dev" when neither is known.
+func BuildVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	revision = revision[:min(len(revision), 12)]
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,26 @@
+package tracker
+
+import "testing"
+
+func TestBuildVersion(t *testing.T) {
+	t.Cleanup(func() { Version = "" })
+
+	if BuildVersion() == "" {
+		t.Error("empty build version")
+	}
+	Version = "v1.2.3"
+	if got := BuildVersion(); got != "v1.2.3" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestClickhouseCompression(t *testing.T) {
+	for _, method := range []string{"lz4", "ZSTD", "none", ""} {
+		if _, err := clickhouseCompression(method); err != nil {
+			t.Errorf("%q: %v", method, err)
+		}
+	}
+	if _, err := clickhouseCompression("gzip"); err == nil {
+		t.Error("gzip accepted")
+	}
+}
@@ -0,0 +1,61 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// VisitorCookieName is the cookie /track keeps the visitor ID of sites with
+// VisitorCookie in.
+const VisitorCookieName = "_tk_vid"
+
+// NewVisitorID returns a random visitor ID.
+func NewVisitorID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// VisitorID returns the visitor ID in the request's cookie, or a new one when
+// it has none or an invalid one.
+func VisitorID(r *http.Request) (id string, isNew bool) {
+	if c, err := r.Cookie(VisitorCookieName); err == nil && validVisitorID(c.Value) {
+		return c.Value, false
+	}
+	return NewVisitorID(), true
+}
+
+func validVisitorID(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// VisitorCookie returns the HttpOnly cookie carrying id, with the configured
+// lifetime and SameSite mode. It is only first-party when the tracker is
+// served from the site's domain, a proxied /track for instance. secure is
+// whether the request came over HTTPS, SameSite=None cookies are always
+// secure as browsers reject them otherwise.
+func VisitorCookie(id string, secure bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     VisitorCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(config.CookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch strings.ToLower(config.CookieSameSite) {
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		c.SameSite = http.SameSiteNoneMode
+		c.Secure = true
+	}
+	return c
+}
@@ -0,0 +1,87 @@
+package tracker
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// visitStats is published on /debug/vars.
+var visitStats = expvar.NewMap("sessions")
+
+// Visits derives the sessions of visitors: the events of a visitor on a
+// site belong to one session until SESSION_TIMEOUT passes without any.
+// Sessions are kept in memory, instances behind a load balancer each see
+// the part of a session they receive.
+type Visits struct {
+	lock     sync.Mutex
+	sessions map[string]visit // site and identity to the current session
+}
+
+type visit struct {
+	id   string
+	last time.Time
+}
+
+func NewVisits() *Visits {
+	return &Visits{sessions: make(map[string]visit)}
+}
+
+// Session returns the session of identity's event on siteID happening at
+// at, a new one when the visitor's previous event is more than
+// SESSION_TIMEOUT before. Events without identity have no session. Past
+// SESSION_CACHE_SIZE visitors new sessions aren't remembered, their next
+// events start sessions of their own.
+func (v *Visits) Session(siteID, identity string, at time.Time) string {
+	if identity == "" {
+		return ""
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := siteID + "\x00" + identity
+	if s, ok := v.sessions[key]; ok && at.Sub(s.last) <= config.SessionTimeout {
+		// Late events belong to the session without extending it
+		if at.After(s.last) {
+			s.last = at
+			v.sessions[key] = s
+		}
+		return s.id
+	}
+
+	s := visit{id: NewVisitorID(), last: at}
+	visitStats.Add("started", 1)
+	if len(v.sessions) >= config.SessionCacheSize {
+		v.expire(at)
+		if len(v.sessions) >= config.SessionCacheSize {
+			visitStats.Add("untracked", 1)
+			return s.id
+		}
+	}
+	v.sessions[key] = s
+	return s.id
+}
+
+// Forget drops the sessions of siteID, once it is deleted.
+func (v *Visits) Forget(siteID string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	prefix := siteID + "\x00"
+	for key := range v.sessions {
+		if strings.HasPrefix(key, prefix) {
+			delete(v.sessions, key)
+		}
+	}
+}
+
+// expire forgets the sessions that timed out by now, the lock must be held.
+func (v *Visits) expire(now time.Time) {
+	for key, s := range v.sessions {
+		if now.Sub(s.last) > config.SessionTimeout {
+			delete(v.sessions, key)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVisitsSession(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.SessionTimeout = 30 * time.Minute
+
+	v := NewVisits()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := v.Session("s", "u1", start)
+	if first == "" {
+		t.Fatal("no session")
+	}
+	if got := v.Session("s", "u1", start.Add(29*time.Minute)); got != first {
+		t.Errorf("within the timeout: got a new session")
+	}
+	// The timeout runs from the latest event
+	if got := v.Session("s", "u1", start.Add(58*time.Minute)); got != first {
+		t.Errorf("within the timeout of the latest event: got a new session")
+	}
+	if got := v.Session("s", "u1", start.Add(40*time.Minute)); got != first {
+		t.Errorf("late event: got a new session")
+	}
+	if got := v.Session("s", "u1", start.Add(89*time.Minute)); got == first {
+		t.Errorf("after the timeout: got the same session")
+	}
+	if got := v.Session("other", "u1", start); got == first {
+		t.Errorf("other site: got the same session")
+	}
+	if got := v.Session("s", "", start); got != "" {
+		t.Errorf("anonymous: got session %q", got)
+	}
+
+	// Deleted sites start over
+	current, other := v.Session("s", "u1", start.Add(89*time.Minute)), v.Session("other", "u1", start)
+	v.Forget("s")
+	if got := v.Session("s", "u1", start.Add(90*time.Minute)); got == current {
+		t.Error("session of a deleted site kept")
+	}
+	if got := v.Session("other", "u1", start); got != other {
+		t.Error("session of another site forgotten")
+	}
+}
+
+func TestVisitsCacheSize(t *testing.T) {
+	t.Cleanup(LoadConfig)
+	LoadConfig()
+	config.SessionTimeout = 30 * time.Minute
+	config.SessionCacheSize = 1
+
+	v := NewVisits()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	v.Session("s", "u1", start)
+	untracked := v.Session("s", "u2", start)
+	if v.Session("s", "u2", start) == untracked {
+		t.Error("session of a full cache remembered")
+	}
+
+	// Timed out sessions make room
+	later := start.Add(time.Hour)
+	tracked := v.Session("s", "u2", later)
+	if v.Session("s", "u2", later) != tracked {
+		t.Error("session not remembered once the cache had room")
+	}
+}
@@ -0,0 +1,235 @@
+package tracker
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// VitalsType is the type of events carrying the Core Web Vitals measured on
+// a page, whose path is their event. They are stored in the web_vitals
+// table, one row per vital, not with the other events.
+const VitalsType = "vitals"
+
+// vitalLimits are the web vitals accepted and their largest plausible
+// value: milliseconds for all but CLS, a unitless score.
+var vitalLimits = map[string]float64{
+	"lcp":  120_000,
+	"inp":  60_000,
+	"ttfb": 120_000,
+	"cls":  100,
+}
+
+// validateVitals checks the vitals of a vitals event and drops them from
+// events of other types.
+func validateVitals(action *TrackingData) error {
+	if action.Type != VitalsType {
+		action.Vitals = nil
+		return nil
+	}
+	if action.Event == "" {
+		return fmt.Errorf("vitals need the page path as event")
+	}
+	if len(action.Vitals) == 0 {
+		return fmt.Errorf("vitals are missing")
+	}
+	for name, value := range action.Vitals {
+		limit, ok := vitalLimits[name]
+		if !ok {
+			return fmt.Errorf("unknown vital %q", name)
+		}
+		if math.IsNaN(value) || value < 0 || value > limit {
+			return fmt.Errorf("vital %s must be between 0 and %g", name, limit)
+		}
+	}
+	return nil
+}
+
+// DeviceClass returns "mobile", "tablet", "desktop" or "other" for ua.
+func DeviceClass(ua useragent.UserAgent) string {
+	switch {
+	case ua.Tablet:
+		return "tablet"
+	case ua.Mobile:
+		return "mobile"
+	case ua.Desktop:
+		return "desktop"
+	}
+	return "other"
+}
+
+// stripQuery returns the path or URL without its query string and
+// fragment.
+func stripQuery(s string) string {
+	if i := strings.IndexAny(s, "?#"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// VitalsQuery selects the web vitals of a site between two YYYYMMDD days,
+// grouped By "page" or "device". Metric selects one vital, all when empty.
+// Limit caps the rows, those with the most samples are kept.
+type VitalsQuery struct {
+	SiteID string `json:"siteId"`
+	Start  uint32 `json:"start"`
+	End    uint32 `json:"end"`
+	By     string `json:"by"`
+	Metric string `json:"metric,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// Validate checks q, without its days.
+func (q VitalsQuery) Validate() error {
+	if q.By != "page" && q.By != "device" {
+		return fmt.Errorf("%w: by must be page or device", ErrInvalid)
+	}
+	if _, ok := vitalLimits[q.Metric]; q.Metric != "" && !ok {
+		return fmt.Errorf("%w: unknown vital %q", ErrInvalid, q.Metric)
+	}
+	return nil
+}
+
+// VitalsRow is the 75th percentile of a vital for a page or device, over
+// Samples measures.
+type VitalsRow struct {
+	Value   string  `json:"value"`
+	Metric  string  `json:"metric"`
+	P75     float64 `json:"p75"`
+	Samples uint64  `json:"samples"`
+}
+
+type VitalsResult struct {
+	Meta StatsMeta   `json:"meta"`
+	Data []VitalsRow `json:"data"`
+}
+
+// VitalsStore is implemented by stores that keep web vitals.
+type VitalsStore interface {
+	Vitals(ctx context.Context, q VitalsQuery) (*VitalsResult, error)
+}
+
+// insertVitals writes a row per vital of the vitals events of batch.
+func (e *Events) insertVitals(ctx context.Context, batch []qdata) error {
+	b, err := e.DB.PrepareBatch(ctx, "INSERT INTO web_vitals (site_id, day, path, device, metric, value)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare vitals batch: %w", err)
+	}
+	for _, qd := range batch {
+		for name, value := range qd.trk.Action.Vitals {
+			err := b.Append(qd.trk.SiteID, qd.occuredAt(), stripQuery(qd.trk.Action.Event), DeviceClass(qd.ua), name, value)
+			if err != nil {
+				return fmt.Errorf("failed to append vitals to batch: %w", err)
+			}
+		}
+	}
+	if err := b.Send(); err != nil {
+		return fmt.Errorf("failed to send vitals batch: %w", err)
+	}
+	return nil
+}
+
+func (e *Events) Vitals(ctx context.Context, q VitalsQuery) (*VitalsResult, error) {
+	started := time.Now()
+	group := "path"
+	if q.By == "device" {
+		group = "device"
+	}
+	qry := fmt.Sprintf(`
+		SELECT %s, metric, quantile(0.75)(value), count()
+		FROM web_vitals
+		WHERE site_id = $1
+		AND day BETWEEN $2 AND $3
+		AND ($4 = '' OR metric = $4)
+		GROUP BY %[1]s, metric
+		ORDER BY 4 DESC, 1, 2
+		LIMIT $5;
+	`, group)
+
+	queryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	rows, err := e.DB.Query(queryCtx, qry, q.SiteID, q.Start, q.End, q.Metric, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("vitals query failed: %w", err)
+	}
+	defer rows.Close()
+
+	data := []VitalsRow{}
+	for rows.Next() {
+		var row VitalsRow
+		if err := rows.Scan(&row.Value, &row.Metric, &row.P75, &row.Samples); err != nil {
+			return nil, fmt.Errorf("failed scanning vitals row: %w", err)
+		}
+		data = append(data, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newVitalsResult(data, SourceRaw, time.Since(started)), nil
+}
+
+func (m *MemoryEvents) Vitals(ctx context.Context, q VitalsQuery) (*VitalsResult, error) {
+	started := time.Now()
+
+	type key struct{ value, metric string }
+	values := make(map[key][]float64)
+	m.lock.RLock()
+	for _, row := range m.rows {
+		if row.trk.SiteID != q.SiteID || row.trk.Action.Type != VitalsType {
+			continue
+		}
+		if row.trk.Action.OccuredAt < q.Start || row.trk.Action.OccuredAt > q.End {
+			continue
+		}
+		value := stripQuery(row.trk.Action.Event)
+		if q.By == "device" {
+			value = DeviceClass(row.ua)
+		}
+		for name, v := range row.trk.Action.Vitals {
+			if q.Metric == "" || name == q.Metric {
+				values[key{value, name}] = append(values[key{value, name}], v)
+			}
+		}
+	}
+	m.lock.RUnlock()
+
+	data := make([]VitalsRow, 0, len(values))
+	for k, vs := range values {
+		data = append(data, VitalsRow{Value: k.value, Metric: k.metric, P75: percentile75(vs), Samples: uint64(len(vs))})
+	}
+	slices.SortFunc(data, func(a, b VitalsRow) int {
+		if a.Samples != b.Samples {
+			return cmp.Compare(b.Samples, a.Samples)
+		}
+		return cmp.Or(cmp.Compare(a.Value, b.Value), cmp.Compare(a.Metric, b.Metric))
+	})
+	if q.Limit > 0 && len(data) > q.Limit {
+		data = data[:q.Limit]
+	}
+	return newVitalsResult(data, SourceMemory, time.Since(started)), nil
+}
+
+// percentile75 returns the nearest-rank 75th percentile of values.
+func percentile75(values []float64) float64 {
+	slices.Sort(values)
+	return values[(len(values)*75+99)/100-1]
+}
+
+func newVitalsResult(data []VitalsRow, source StatsSource, took time.Duration) *VitalsResult {
+	return &VitalsResult{
+		Meta: StatsMeta{
+			Rows:        len(data),
+			DurationMs:  float64(took.Microseconds()) / 1000,
+			Granularity: "total",
+			SampleRate:  1,
+			Source:      source,
+		},
+		Data: data,
+	}
+}
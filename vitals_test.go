@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mileusna/useragent"
+)
+
+func TestDecodeVitals(t *testing.T) {
+	trk, err := DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"vitals","event":"/docs?q=1","vitals":{"lcp":2100,"cls":0.05}}}`))
+	if err != nil || trk.Action.Vitals["lcp"] != 2100 || trk.Action.Vitals["cls"] != 0.05 {
+		t.Fatalf("got %+v, %v", trk.Action, err)
+	}
+	trk, err = DecodePayload([]byte(`{"site_id":"s","tracking":{"type":"page","event":"/","vitals":{"lcp":2100}}}`))
+	if err != nil || trk.Action.Vitals != nil {
+		t.Errorf("vitals of a page view: got %+v, %v", trk.Action.Vitals, err)
+	}
+
+	for _, payload := range []string{
+		`{"site_id":"s","tracking":{"type":"vitals","event":"/"}}`,
+		`{"site_id":"s","tracking":{"type":"vitals","vitals":{"lcp":1}}}`,
+		`{"site_id":"s","tracking":{"type":"vitals","event":"/","vitals":{"fid":1}}}`,
+		`{"site_id":"s","tracking":{"type":"vitals","event":"/","vitals":{"inp":-1}}}`,
+		`{"site_id":"s","tracking":{"type":"vitals","event":"/","vitals":{"cls":1e9}}}`,
+	} {
+		if _, err := DecodePayload([]byte(payload)); !errors.Is(err, ErrMalformedPayload) {
+			t.Errorf("%s: got %v", payload, err)
+		}
+	}
+}
+
+func TestMemoryVitals(t *testing.T) {
+	m := NewMemoryEvents()
+	desktop := useragent.UserAgent{Desktop: true}
+	mobile := useragent.UserAgent{Mobile: true}
+	for i, lcp := range []float64{1000, 4000, 2000, 3000} {
+		ua := desktop
+		if i == 1 {
+			ua = mobile
+		}
+		trk := Tracking{SiteID: "s", Action: TrackingData{Type: VitalsType, Event: "/?utm=x", OccuredAt: 20240301, Vitals: map[string]float64{"lcp": lcp, "cls": 0.1}}}
+		if err := m.Add(context.Background(), trk, ua, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := VitalsQuery{SiteID: "s", Start: 20240301, End: 20240301, By: "page", Metric: "lcp"}
+	got, err := m.Vitals(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Data) != 1 || got.Data[0] != (VitalsRow{Value: "/", Metric: "lcp", P75: 3000, Samples: 4}) {
+		t.Errorf("per page: got %+v", got.Data)
+	}
+
+	q.By, q.Metric = "device", ""
+	got, _ = m.Vitals(context.Background(), q)
+	if len(got.Data) != 4 || got.Data[0] != (VitalsRow{Value: "desktop", Metric: "cls", P75: 0.1, Samples: 3}) || got.Data[1].P75 != 3000 {
+		t.Errorf("per device: got %+v", got.Data)
+	}
+	if err := (VitalsQuery{By: "country"}).Validate(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("by country: got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// warmupRanges are the dashboard's default date ranges in days, today
+// included.
+var warmupRanges = []int{1, 7, 30}
+
+// Warmed is an EventStore serving the dashboard's overview queries from a
+// cache that RunWarmup keeps filled for recently active sites, so the first
+// dashboard load is fast even right after a restart. Other queries, and
+// cached results older than ttl, go to the underlying store.
+type Warmed struct {
+	EventStore
+
+	ttl   time.Duration
+	lock  sync.RWMutex
+	cache map[string]warmedEntry
+	log   *slog.Logger
+}
+
+type warmedEntry struct {
+	siteID  string
+	result  *StatsResult
+	expires time.Time
+}
+
+func NewWarmed(store EventStore, ttl time.Duration) *Warmed {
+	return &Warmed{
+		EventStore: store,
+		ttl:        ttl,
+		cache:      make(map[string]warmedEntry),
+		log:        slog.Default().With(slog.String("component", "Warmed")),
+	}
+}
+
+func warmedKey(data MetricData) string {
+	b, _ := json.Marshal(data)
+	return string(b)
+}
+
+func (w *Warmed) GetStats(ctx context.Context, data MetricData) (*StatsResult, error) {
+	w.lock.RLock()
+	entry, ok := w.cache[warmedKey(data)]
+	w.lock.RUnlock()
+
+	if ok && clock.Now().Before(entry.expires) {
+		statsCounters.Add("cache_hits", 1)
+		result := *entry.result
+		result.Meta.Cached = true
+		return &result, nil
+	}
+	return w.EventStore.GetStats(ctx, data)
+}
+
+// Forget drops the cached results of siteID, after its deletion.
+func (w *Warmed) Forget(siteID string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for key, entry := range w.cache {
+		if entry.siteID == siteID {
+			delete(w.cache, key)
+		}
+	}
+}
+
+// overviewQueries are the queries the dashboard runs for a site when it is
+// opened: every unfiltered metric over each default range.
+func overviewQueries(siteID string, today uint32) []MetricData {
+	var queries []MetricData
+	for what, def := range metricDefs {
+		if def.filter != "" {
+			continue
+		}
+		for _, days := range warmupRanges {
+			queries = append(queries, MetricData{What: what, SiteID: siteID, Start: AddDays(today, -days+1), End: today})
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].What < queries[j].What })
+	return queries
+}
+
+// Warm runs the overview queries of every site with events since yesterday
+// and caches their results.
+func (w *Warmed) Warm(ctx context.Context) error {
+	today := Today()
+	active, err := w.EventStore.Usage(ctx, UsageQuery{Start: AddDays(today, -1), End: today})
+	if err != nil {
+		return err
+	}
+	sites := make(map[string]bool)
+	for _, u := range active {
+		sites[u.SiteID] = true
+	}
+
+	warmed := 0
+	for siteID := range sites {
+		for _, data := range overviewQueries(siteID, today) {
+			result, err := w.EventStore.GetStats(ctx, data)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				w.log.Warn("Failed to warm up query", slog.String("site", siteID), slog.String("what", data.What.String()), slog.Any("error", err))
+				continue
+			}
+			w.lock.Lock()
+			w.cache[warmedKey(data)] = warmedEntry{siteID: siteID, result: result, expires: clock.Now().Add(w.ttl)}
+			w.lock.Unlock()
+			warmed++
+		}
+	}
+
+	// Drop what is no longer refreshed: inactive sites and past days
+	now := clock.Now()
+	w.lock.Lock()
+	for key, entry := range w.cache {
+		if now.After(entry.expires) {
+			delete(w.cache, key)
+		}
+	}
+	w.lock.Unlock()
+
+	w.log.Debug("Warmed up overview queries", slog.Int("sites", len(sites)), slog.Int("queries", warmed))
+	return nil
+}
+
+// RunWarmup warms the cache right away and then every interval until ctx is
+// done.
+func (w *Warmed) RunWarmup(ctx context.Context, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Warm(ctx); err != nil && ctx.Err() == nil {
+			w.log.Error("Query warm-up failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWarmed(t *testing.T) {
+	fc := NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	defer SetClock(fc)()
+	ctx := context.Background()
+
+	mem := NewMemoryEvents()
+	if err := SeedDemoData(ctx, mem, "active", 3, 5); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWarmed(mem, time.Minute)
+	if err := w.Warm(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	week := MetricData{What: QueryPageViews, SiteID: "active", Start: 20240304, End: 20240310}
+	result, err := w.GetStats(ctx, week)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Meta.Cached || len(result.Data) == 0 {
+		t.Errorf("overview query not served from the warmed cache: %+v", result.Meta)
+	}
+
+	other := MetricData{What: QueryPageViews, SiteID: "active", Start: 20240305, End: 20240310}
+	if result, _ := w.GetStats(ctx, other); result.Meta.Cached {
+		t.Error("query outside the default ranges served from cache")
+	}
+
+	fc.Advance(2 * time.Minute)
+	if result, _ := w.GetStats(ctx, week); result.Meta.Cached {
+		t.Error("expired result served from cache")
+	}
+}
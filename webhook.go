@@ -0,0 +1,203 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Types of the events sent to webhooks.
+const (
+	HookSiteCreated     = "site.created"
+	HookSiteDeleted     = "site.deleted"
+	HookSiteMerged      = "site.merged"
+	HookQuotaThreshold  = "quota.threshold"
+	HookRetentionPurged = "retention.purged"
+)
+
+// Headers of webhook requests. The signature is the hex HMAC-SHA256 of the
+// body with WEBHOOK_SECRET, prefixed with "sha256=".
+const (
+	HookEventHeader     = "X-Tracker-Event"
+	HookSignatureHeader = "X-Tracker-Signature"
+)
+
+// webhookStats is published on /debug/vars.
+var webhookStats = expvar.NewMap("webhooks")
+
+// A delivery is tried webhookAttempts times, waiting twice as long before
+// each retry.
+const (
+	webhookAttempts = 3
+	webhookBackoff  = 2 * time.Second
+	webhookTimeout  = 10 * time.Second
+)
+
+// HookEvent is the body posted to webhooks. Receivers can use ID to ignore
+// events delivered twice.
+type HookEvent struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	At     time.Time `json:"at"`
+	SiteID string    `json:"siteId,omitempty"`
+	Data   any       `json:"data,omitempty"`
+}
+
+// QuotaCrossing is the data of a quota.threshold event: the site's usage
+// reached Percent of its monthly limit.
+type QuotaCrossing struct {
+	Month   string `json:"month"`
+	Percent uint64 `json:"percent"`
+	Events  uint64 `json:"events"`
+	Limit   uint64 `json:"limit"`
+}
+
+// Webhooks posts site lifecycle, quota and retention events to the
+// WEBHOOK_URLS so billing and CRM systems can follow them. Events are
+// delivered in the background, in order, and dropped when too many wait. A
+// nil Webhooks fires nothing.
+type Webhooks struct {
+	sender hookSender
+	urls   []string
+	events chan HookEvent
+	done   chan struct{}
+	log    *slog.Logger
+}
+
+// NewWebhooks returns webhooks posting to the configured URLs, or nil when
+// there are none.
+func NewWebhooks() *Webhooks {
+	if len(config.WebhookURLs) == 0 {
+		return nil
+	}
+	return &Webhooks{
+		sender: newHookSender(&http.Client{Timeout: webhookTimeout}),
+		urls:   config.WebhookURLs,
+		events: make(chan HookEvent, 1000),
+		done:   make(chan struct{}),
+		log:    slog.Default().With(slog.String("component", "Webhooks")),
+	}
+}
+
+// Fire queues an event of type typ without waiting for its delivery.
+func (h *Webhooks) Fire(typ, siteID string, data any) {
+	if h == nil {
+		return
+	}
+	ev := HookEvent{ID: RandomToken(), Type: typ, At: clock.Now().UTC(), SiteID: siteID, Data: data}
+	select {
+	case h.events <- ev:
+	default:
+		webhookStats.Add("dropped", 1)
+		h.log.Error("Webhook queue full, dropped event", slog.String("type", typ), slog.String("site", siteID))
+	}
+}
+
+// Run delivers queued events until ctx is done, then delivers the remaining
+// ones.
+func (h *Webhooks) Run(ctx context.Context) {
+	defer close(h.done)
+
+	for {
+		select {
+		case ev := <-h.events:
+			h.deliver(ev)
+		case <-ctx.Done():
+			for {
+				select {
+				case ev := <-h.events:
+					h.deliver(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Wait returns once Run delivered the last events.
+func (h *Webhooks) Wait() {
+	if h == nil {
+		return
+	}
+	<-h.done
+}
+
+func (h *Webhooks) deliver(ev HookEvent) {
+	for _, url := range h.urls {
+		if err := h.sender.send(url, ev); err != nil {
+			h.log.Error("Failed to deliver webhook", slog.Any("error", err), slog.String("url", url), slog.String("type", ev.Type))
+		}
+	}
+}
+
+// hookSender posts events, signed with WEBHOOK_SECRET when set, and retries
+// failed deliveries.
+type hookSender struct {
+	client  *http.Client
+	secret  string
+	backoff time.Duration
+}
+
+func newHookSender(client *http.Client) hookSender {
+	return hookSender{client: client, secret: config.WebhookSecret, backoff: webhookBackoff}
+}
+
+// send posts ev to url, webhookAttempts times at most.
+func (s hookSender) send(url string, ev HookEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		err := s.post(url, ev.Type, body)
+		if err == nil {
+			webhookStats.Add("sent", 1)
+			return nil
+		}
+		if attempt == webhookAttempts {
+			webhookStats.Add("failed", 1)
+			return err
+		}
+		webhookStats.Add("retries", 1)
+		<-clock.NewTimer(backoff).C()
+		backoff *= 2
+	}
+}
+
+func (s hookSender) post(url, typ string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HookEventHeader, typ)
+	if s.secret != "" {
+		req.Header.Set(HookSignatureHeader, "sha256="+SignHook(s.secret, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// SignHook returns the hex HMAC-SHA256 of a webhook body with secret.
+func SignHook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
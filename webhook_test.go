@@ -0,0 +1,98 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooksDeliver(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		received []HookEvent
+		attempts int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		if attempts++; attempts == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if got := r.Header.Get(HookSignatureHeader); got != "sha256="+SignHook("secret", body) {
+			t.Errorf("got signature %q", got)
+		}
+		var ev HookEvent
+		json.Unmarshal(body, &ev)
+		if r.Header.Get(HookEventHeader) != ev.Type {
+			t.Errorf("got event header %q for %q", r.Header.Get(HookEventHeader), ev.Type)
+		}
+		received = append(received, ev)
+	}))
+	defer srv.Close()
+
+	prev := config
+	defer func() { config = prev }()
+	config.WebhookURLs = []string{srv.URL}
+	config.WebhookSecret = "secret"
+
+	h := NewWebhooks()
+	h.sender.backoff = time.Millisecond
+	h.Fire(HookSiteCreated, "shop", nil)
+	h.Fire(HookSiteDeleted, "shop", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.Run(ctx)
+	h.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if attempts != 3 || len(received) != 2 || received[0].Type != HookSiteCreated || received[1].SiteID != "shop" {
+		t.Errorf("got %d attempts, events %+v", attempts, received)
+	}
+}
+
+func TestQuotaThresholdHooks(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+	config.DefaultMonthlyQuota = 10
+	config.WebhookURLs = []string{"http://hooks.invalid"}
+	config.WebhookQuotaPercent = []uint64{50, 100}
+
+	q := &Quotas{}
+	q.Load("", &Sites{})
+	h := NewWebhooks()
+	q.SetWebhooks(h)
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		q.Record("shop", now)
+	}
+
+	var crossed []uint64
+	for len(h.events) > 0 {
+		ev := <-h.events
+		crossed = append(crossed, ev.Data.(QuotaCrossing).Percent)
+	}
+	if len(crossed) != 2 || crossed[0] != 50 || crossed[1] != 100 {
+		t.Errorf("got crossings %v", crossed)
+	}
+}
+
+func TestNilWebhooks(t *testing.T) {
+	prev := config
+	defer func() { config = prev }()
+	config.WebhookURLs = nil
+
+	h := NewWebhooks()
+	if h != nil {
+		t.Fatal("webhooks without URLs")
+	}
+	h.Fire(HookSiteCreated, "shop", nil)
+	h.Wait()
+}